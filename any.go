@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// rfc8482HINFOCPU is the CPU field of the synthetic HINFO RR.
+const rfc8482HINFOCPU = "RFC8482"
+
+// ANYAnswer is the answer to an ANY query returned by [*Resolver.LookupANY].
+type ANYAnswer struct {
+	// RRs contains the valid answer RRs.
+	RRs []dns.RR
+
+	// Minimal indicates that the server declined to answer the ANY
+	// query by returning the synthetic HINFO RR defined by RFC 8482,
+	// which means that RRs does not describe the RRsets of the name.
+	//
+	// Note that RFC 8482 also allows servers to return a subset of
+	// the RRsets, which we cannot distinguish from a complete answer.
+	Minimal bool
+}
+
+// IsRFC8482Answer returns whether the given answer RRs contain the
+// synthetic HINFO RR that, according to RFC 8482, servers return when
+// they decline to answer ANY queries. Such a HINFO RR has its CPU field
+// set to "RFC8482" and, usually, an empty OS field.
+func IsRFC8482Answer(rrs []dns.RR) bool {
+	for _, rr := range rrs {
+		if hinfo, ok := rr.(*dns.HINFO); ok && strings.EqualFold(hinfo.Cpu, rfc8482HINFOCPU) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupANY sends an ANY query for the given name and returns the
+// answer, flagging the RFC 8482 minimal answers as such. Since most
+// servers now decline to answer ANY queries, you should not rely on
+// the answer containing all the RRsets of the name.
+func (r *Resolver) LookupANY(ctx context.Context, name string) (*ANYAnswer, error) {
+	rrs, err := r.lookup(ctx, name, dns.TypeANY)
+	if err != nil {
+		return nil, err
+	}
+	return &ANYAnswer{RRs: rrs, Minimal: IsRFC8482Answer(rrs)}, nil
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRFC8482Answer(t *testing.T) {
+	newRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		assert.NoError(t, err)
+		return rr
+	}
+	tests := []struct {
+		name   string
+		rrs    []dns.RR
+		expect bool
+	}{{
+		name:   "empty answer",
+		rrs:    nil,
+		expect: false,
+	}, {
+		name:   "synthetic HINFO",
+		rrs:    []dns.RR{newRR(`example.com. 3789 IN HINFO "RFC8482" ""`)},
+		expect: true,
+	}, {
+		name:   "synthetic HINFO with a different case",
+		rrs:    []dns.RR{newRR(`example.com. 3789 IN HINFO "rfc8482" ""`)},
+		expect: true,
+	}, {
+		name:   "real HINFO",
+		rrs:    []dns.RR{newRR(`example.com. 3600 IN HINFO "x86_64" "Linux"`)},
+		expect: false,
+	}, {
+		name: "subset of the RRsets",
+		rrs: []dns.RR{
+			newRR("example.com. 3600 IN A 93.184.215.14"),
+			newRR("example.com. 3600 IN NS a.iana-servers.net."),
+		},
+		expect: false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, IsRFC8482Answer(tt.rrs))
+		})
+	}
+}
+
+func TestResolver_LookupANY(t *testing.T) {
+	newResolver := func(rrs ...string) *Resolver {
+		config := NewConfig()
+		config.AddServer(NewServerAddr(ProtocolUDP, "8.8.8.8:53"))
+		return &Resolver{
+			Config: config,
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					assert.Equal(t, dns.TypeANY, query.Question[0].Qtype)
+					resp := &dns.Msg{}
+					resp.SetReply(query)
+					resp.RecursionAvailable = true
+					for _, s := range rrs {
+						rr, _ := dns.NewRR(s)
+						resp.Answer = append(resp.Answer, rr)
+					}
+					return resp, nil
+				},
+			},
+		}
+	}
+
+	t.Run("minimal answer", func(t *testing.T) {
+		reso := newResolver(`example.com. 3789 IN HINFO "RFC8482" ""`)
+		answer, err := reso.LookupANY(context.Background(), "example.com")
+		assert.NoError(t, err)
+		assert.True(t, answer.Minimal)
+		assert.Len(t, answer.RRs, 1)
+	})
+
+	t.Run("full answer", func(t *testing.T) {
+		reso := newResolver("example.com. 3600 IN A 93.184.215.14", "example.com. 3600 IN TXT \"v=spf1 -all\"")
+		answer, err := reso.LookupANY(context.Background(), "example.com")
+		assert.NoError(t, err)
+		assert.False(t, answer.Minimal)
+		assert.Len(t, answer.RRs, 2)
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		reso := newResolver()
+		_, err := reso.LookupANY(context.Background(), "example.com")
+		assert.ErrorIs(t, err, ErrNoData)
+	})
+}
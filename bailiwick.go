@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import "github.com/miekg/dns"
+
+// ScrubOutOfBailiwick removes from the answer, authority, and additional
+// sections of the response the RRs whose owner name is outside of the
+// bailiwick of the given zone, i.e., the zone of the server that sent the
+// response. A server is not authoritative for names outside its zone, so
+// caching such RRs would allow it to poison the cache. The OPT pseudo-RR
+// is always preserved. This function modifies the response in place and
+// returns the number of RRs it removed.
+func ScrubOutOfBailiwick(zone string, resp *dns.Msg) (removed int) {
+	zone = dns.CanonicalName(zone)
+	scrub := func(rrs []dns.RR) []dns.RR {
+		out := rrs[:0]
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeOPT || dns.IsSubDomain(zone, dns.CanonicalName(rr.Header().Name)) {
+				out = append(out, rr)
+				continue
+			}
+			removed++
+		}
+		return out
+	}
+	resp.Answer = scrub(resp.Answer)
+	resp.Ns = scrub(resp.Ns)
+	resp.Extra = scrub(resp.Extra)
+	return
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubOutOfBailiwick(t *testing.T) {
+	h := &iterativeTestHierarchy{}
+	resp := &dns.Msg{}
+	resp.Answer = []dns.RR{
+		h.rr("www.example.com. 300 IN CNAME www.example.net."),
+		h.rr("www.example.net. 300 IN A 203.0.113.66"),
+	}
+	resp.Ns = []dns.RR{
+		h.rr("example.com. 3600 IN NS ns.example.com."),
+		h.rr("com. 3600 IN NS a.gtld.com."),
+	}
+	resp.Extra = []dns.RR{
+		h.rr("NS.EXAMPLE.COM. 3600 IN A 192.0.2.3"),
+		h.rr("ns.example.net. 3600 IN A 203.0.113.66"),
+	}
+	resp.SetEdns0(1232, false)
+
+	assert.Equal(t, 3, ScrubOutOfBailiwick("Example.COM", resp))
+	assert.Equal(t, []dns.RR{h.rr("www.example.com. 300 IN CNAME www.example.net.")}, resp.Answer)
+	assert.Equal(t, []dns.RR{h.rr("example.com. 3600 IN NS ns.example.com.")}, resp.Ns)
+	assert.Len(t, resp.Extra, 2)
+	assert.Equal(t, "NS.EXAMPLE.COM.", resp.Extra[0].Header().Name)
+	assert.NotNil(t, resp.IsEdns0())
+
+	t.Run("everything is within the root zone bailiwick", func(t *testing.T) {
+		assert.Equal(t, 0, ScrubOutOfBailiwick(".", resp))
+	})
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// caaFlagCritical is the issuer critical flag of CAA records.
+const caaFlagCritical = 128
+
+// CAAPolicy is the relevant CAA RRset of a name (RFC 8659) returned
+// by [*Resolver.LookupCAA].
+type CAAPolicy struct {
+	// Name is the name owning the relevant RRset, which is either the
+	// name we looked up or one of its ancestors, or empty when no
+	// name in the hierarchy has CAA records.
+	Name string
+
+	// Records contains the relevant CAA records.
+	Records []*dns.CAA
+}
+
+// LookupCAA returns the relevant CAA RRset of the given name, which
+// we obtain by walking up the name hierarchy until we find a name with
+// CAA records, excluding the root, as specified by RFC 8659 Sect. 3.
+// Note that, unlike NXDOMAIN and NODATA, which mean that the ancestors
+// are relevant, any other failure is an error, since the CAs must not
+// issue certificates when they cannot determine the relevant RRset.
+func (r *Resolver) LookupCAA(ctx context.Context, name string) (*CAAPolicy, error) {
+	for labels := dns.SplitDomainName(dns.Fqdn(name)); len(labels) > 0; labels = labels[1:] {
+		// 1. obtain the RRs of the current name
+		current := dns.Fqdn(strings.Join(labels, "."))
+		rrs, err := r.lookup(ctx, current, dns.TypeCAA)
+		if errors.Is(err, ErrNoData) || errors.Is(err, ErrNoName) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// 2. stop at the first name with CAA records, skipping the CNAMEs
+		policy := &CAAPolicy{Name: current}
+		for _, rr := range rrs {
+			if caa, ok := rr.(*dns.CAA); ok {
+				policy.Records = append(policy.Records, caa)
+			}
+		}
+		if len(policy.Records) > 0 {
+			return policy, nil
+		}
+	}
+	return &CAAPolicy{}, nil
+}
+
+// Permits returns whether the policy allows the CA identified by the given
+// issuer domain name, e.g., "letsencrypt.org", to issue a certificate,
+// which is a wildcard certificate when wildcard is true.
+//
+// Following RFC 8659 Sect. 4, a policy without issue records allows every CA,
+// the issuewild records take precedence over the issue ones for wildcard
+// certificates and are otherwise ignored, and the records with the critical
+// flag set and an unknown tag forbid issuance.
+func (p *CAAPolicy) Permits(issuer string, wildcard bool) bool {
+	// 1. handle the unknown critical properties
+	var issue, issuewild []string
+	for _, record := range p.Records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issue = append(issue, record.Value)
+		case "issuewild":
+			issuewild = append(issuewild, record.Value)
+		case "iodef", "contactemail", "contactphone", "issuemail", "issuevmc":
+			// known properties not restricting the issuance of certificates
+		default:
+			if record.Flag&caaFlagCritical != 0 {
+				return false
+			}
+		}
+	}
+
+	// 2. select the properties to consider
+	values := issue
+	if wildcard && len(issuewild) > 0 {
+		values = issuewild
+	}
+	if len(values) <= 0 {
+		return true // no property restricts this kind of certificate
+	}
+
+	// 3. look for a property authorizing the issuer
+	for _, value := range values {
+		domain, _, _ := strings.Cut(value, ";")
+		domain = strings.TrimSpace(domain)
+		if domain != "" && equalASCIIName(dns.Fqdn(domain), dns.Fqdn(issuer)) {
+			return true
+		}
+	}
+	return false
+}
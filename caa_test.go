@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCAAPolicy_Permits(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []string
+		issuer   string
+		wildcard bool
+		expected bool
+	}{{
+		name:     "empty policy",
+		issuer:   "ca.example.net",
+		expected: true,
+	}, {
+		name:     "authorized issuer with parameters",
+		records:  []string{`example.com. 300 IN CAA 0 issue "ca.example.net; account=230123"`},
+		issuer:   "CA.example.net.",
+		expected: true,
+	}, {
+		name:     "unauthorized issuer",
+		records:  []string{`example.com. 300 IN CAA 0 issue "ca.example.net"`},
+		issuer:   "other.example.org",
+		expected: false,
+	}, {
+		name:     "no issuer authorized",
+		records:  []string{`example.com. 300 IN CAA 0 issue ";"`},
+		issuer:   "ca.example.net",
+		expected: false,
+	}, {
+		name: "issuewild takes precedence for wildcards",
+		records: []string{
+			`example.com. 300 IN CAA 0 issue "ca.example.net"`,
+			`example.com. 300 IN CAA 0 issuewild "wild.example.org"`,
+		},
+		issuer:   "ca.example.net",
+		wildcard: true,
+		expected: false,
+	}, {
+		name: "issuewild ignored otherwise",
+		records: []string{
+			`example.com. 300 IN CAA 0 issuewild ";"`,
+			`example.com. 300 IN CAA 0 iodef "mailto:security@example.com"`,
+		},
+		issuer:   "ca.example.net",
+		expected: true,
+	}, {
+		name: "issue applies to wildcards without issuewild",
+		records: []string{
+			`example.com. 300 IN CAA 0 issue "ca.example.net"`,
+		},
+		issuer:   "ca.example.net",
+		wildcard: true,
+		expected: true,
+	}, {
+		name: "unknown critical property",
+		records: []string{
+			`example.com. 300 IN CAA 0 issue "ca.example.net"`,
+			`example.com. 300 IN CAA 128 tbs "unknown"`,
+		},
+		issuer:   "ca.example.net",
+		expected: false,
+	}, {
+		name: "unknown noncritical property",
+		records: []string{
+			`example.com. 300 IN CAA 0 issue "ca.example.net"`,
+			`example.com. 300 IN CAA 0 tbs "unknown"`,
+		},
+		issuer:   "ca.example.net",
+		expected: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &CAAPolicy{Name: "example.com."}
+			for _, record := range tt.records {
+				rr, err := dns.NewRR(record)
+				assert.NoError(t, err)
+				policy.Records = append(policy.Records, rr.(*dns.CAA))
+			}
+			assert.Equal(t, tt.expected, policy.Permits(tt.issuer, tt.wildcard))
+		})
+	}
+}
+
+func TestResolver_LookupCAA(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	var queried []string
+	config := NewConfig()
+	config.AddServer(NewServerAddr(ProtocolUDP, "8.8.8.8:53"))
+	config.SetAttempts(1)
+	reso := &Resolver{
+		Config: config,
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				q0 := query.Question[0]
+				assert.Equal(t, dns.TypeCAA, q0.Qtype)
+				queried = append(queried, q0.Name)
+				resp := new(dns.Msg)
+				resp.SetReply(query)
+				resp.RecursionAvailable = true
+				switch q0.Name {
+				case "example.com.":
+					rr, err := dns.NewRR(`example.com. 300 IN CAA 0 issue "ca.example.net"`)
+					assert.NoError(t, err)
+					resp.Answer = append(resp.Answer, rr)
+				case "nonexistent.example.com.":
+					resp.Rcode = dns.RcodeNameError
+				case "broken.example.org.":
+					return nil, expectedErr
+				}
+				return resp, nil
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		lookup   string
+		expected string
+		queried  []string
+		err      error
+	}{{
+		name:     "records at the name",
+		lookup:   "example.com",
+		expected: "example.com.",
+		queried:  []string{"example.com."},
+	}, {
+		name:     "records at an ancestor",
+		lookup:   "www.nonexistent.example.com",
+		expected: "example.com.",
+		queried:  []string{"www.nonexistent.example.com.", "nonexistent.example.com.", "example.com."},
+	}, {
+		name:    "no records in the hierarchy",
+		lookup:  "www.example.org",
+		queried: []string{"www.example.org.", "example.org.", "org."},
+	}, {
+		name:    "lookup failure",
+		lookup:  "www.broken.example.org",
+		queried: []string{"www.broken.example.org.", "broken.example.org."},
+		err:     expectedErr,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queried = nil
+			policy, err := reso.LookupCAA(context.Background(), tt.lookup)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.queried, queried)
+			if err == nil {
+				assert.Equal(t, tt.expected, policy.Name)
+				assert.Equal(t, tt.expected != "", len(policy.Records) > 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"hash/maphash"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultCacheMaxEntries is the default maximum number of entries of a [*Cache].
+const DefaultCacheMaxEntries = 4096
+
+// DefaultCacheShards is the default number of shards of a [*Cache].
+const DefaultCacheShards = 16
+
+// cacheEvictionSamples is the number of entries of a full shard among
+// which the [CacheEvictionPolicy] chooses the entry to evict.
+const cacheEvictionSamples = 16
+
+// DefaultCachePrefetchTimeout is the timeout of the background
+// lookups refreshing the entries of a [*Cache].
+const DefaultCachePrefetchTimeout = 10 * time.Second
+
+// Cache caches the answers obtained by a [*Resolver] for the minimum TTL
+// of the answer RRs, after applying the [*TTLPolicy], if any. We only cache
+// successful lookups returning at least one RR with a nonzero TTL.
+//
+// When PrefetchWindow is positive, serving an entry expiring within the
+// window refreshes the entry in the background, such that frequently used
+// names do not cause cache misses.
+//
+// To avoid contention on a single mutex at high query rates, we split the
+// entries among shards, each with its own mutex, according to the name.
+//
+// The zero value is ready to use. This struct is safe for concurrent use
+// by multiple goroutines. Do not modify MaxEntries and Shards after the
+// first use, since we use them to create the shards.
+type Cache struct {
+	// Eviction is the optional policy choosing which entry to evict
+	// when a shard is full. To keep inserting constant time, the policy
+	// only sees a random sample of the entries of the shard, hence it
+	// approximates evicting the entry it would choose among all of them.
+	//
+	// If nil, we use [CacheEvictionTTL].
+	Eviction CacheEvictionPolicy
+
+	// MaxEntries is the optional maximum number of entries, which we
+	// split evenly among the shards. When a shard is full, we evict one
+	// of its entries according to the Eviction policy.
+	//
+	// If zero, we use [DefaultCacheMaxEntries].
+	MaxEntries int
+
+	// NoClientSubnet optionally disables caching when the [*Resolver]
+	// sends ECS, since the cache would otherwise reveal which names
+	// clients within each subnet resolved, which is a privacy issue.
+	NoClientSubnet bool
+
+	// PrefetchWindow is the optional window before the expiry of an entry
+	// within which serving the entry causes a background refresh.
+	//
+	// If zero, we do not prefetch entries.
+	PrefetchWindow time.Duration
+
+	// Shards is the optional number of shards. We never use
+	// more shards than the maximum number of entries.
+	//
+	// If zero, we use [DefaultCacheShards].
+	Shards int
+
+	// once ensures we create the shards once.
+	once sync.Once
+
+	// seed is the seed to hash names into shards.
+	seed maphash.Seed
+
+	// shards contains the shards.
+	shards []*cacheShard
+}
+
+// cacheShard is a shard of a [*Cache].
+type cacheShard struct {
+	// entries contains the cached entries.
+	entries map[cacheKey]*cacheEntry
+
+	// eviction is the eviction policy.
+	eviction CacheEvictionPolicy
+
+	// keys and usages are scratch space for the sample of entries
+	// among which we choose the entry to evict.
+	keys   []cacheKey
+	usages []CacheEntryUsage
+
+	// maxEntries is the maximum number of entries.
+	maxEntries int
+
+	// mu protects entries and stats.
+	mu sync.Mutex
+
+	// stats contains the shard statistics.
+	stats CacheStats
+}
+
+// CacheStats contains the statistics of a [*Cache].
+type CacheStats struct {
+	// Entries is the number of entries, including
+	// the expired ones not evicted yet.
+	Entries int
+
+	// Hits is the number of lookups served from the cache.
+	Hits int64
+
+	// Misses is the number of lookups not served from the cache.
+	Misses int64
+
+	// Prefetches is the number of background refreshes we started.
+	Prefetches int64
+
+	// PrefetchHits is the number of lookups served using
+	// an entry that was refreshed in the background.
+	PrefetchHits int64
+}
+
+// cacheKey is the key of a [*Cache] entry.
+type cacheKey struct {
+	name  string
+	qtype uint16
+
+	// subnet is the ECS scope of the answer, which is the
+	// zero prefix when the answer applies to all clients.
+	subnet netip.Prefix
+}
+
+// cacheEntry is an entry of a [*Cache].
+type cacheEntry struct {
+	// rrs contains the answer RRs.
+	rrs []dns.RR
+
+	// stored is when we stored the entry.
+	stored time.Time
+
+	// expires is when the entry expires.
+	expires time.Time
+
+	// lastUsed is when we last served the entry.
+	lastUsed time.Time
+
+	// hits is the number of lookups served using the entry.
+	hits int64
+
+	// prefetched indicates that a background refresh stored the entry.
+	prefetched bool
+
+	// prefetching indicates that a background refresh is in progress.
+	prefetching bool
+}
+
+// maxEntries returns the maximum number of entries or the default.
+func (c *Cache) maxEntries() int {
+	if c.MaxEntries > 0 {
+		return c.MaxEntries
+	}
+	return DefaultCacheMaxEntries
+}
+
+// init creates the shards, if needed, and returns them.
+func (c *Cache) init() []*cacheShard {
+	c.once.Do(func() {
+		maxEntries := c.maxEntries()
+		count := DefaultCacheShards
+		if c.Shards > 0 {
+			count = c.Shards
+		}
+		count = min(count, maxEntries)
+		var eviction CacheEvictionPolicy = CacheEvictionTTL{}
+		if c.Eviction != nil {
+			eviction = c.Eviction
+		}
+		c.seed = maphash.MakeSeed()
+		c.shards = make([]*cacheShard, 0, count)
+		for idx := 0; idx < count; idx++ {
+			c.shards = append(c.shards, &cacheShard{
+				entries:    make(map[cacheKey]*cacheEntry),
+				eviction:   eviction,
+				maxEntries: (maxEntries + count - 1) / count,
+			})
+		}
+	})
+	return c.shards
+}
+
+// shard returns the shard containing the entries for the given key. We only
+// hash the name, such that all the entries of a name share the same shard.
+func (c *Cache) shard(key cacheKey) *cacheShard {
+	shards := c.init()
+	return shards[maphash.String(c.seed, key.name)%uint64(len(shards))]
+}
+
+// forEach calls fn for each entry while holding the mutex of its shard.
+func (c *Cache) forEach(fn func(key cacheKey, entry *cacheEntry)) {
+	for _, shard := range c.init() {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			fn(key, entry)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *Cache) Stats() CacheStats {
+	var stats CacheStats
+	for _, shard := range c.init() {
+		shard.mu.Lock()
+		stats.Entries += len(shard.entries)
+		stats.Hits += shard.stats.Hits
+		stats.Misses += shard.stats.Misses
+		stats.Prefetches += shard.stats.Prefetches
+		stats.PrefetchHits += shard.stats.PrefetchHits
+		shard.mu.Unlock()
+	}
+	return stats
+}
+
+// get returns a copy of the RRs of the unexpired entry with the given key,
+// whose TTLs reflect the remaining lifetime, along with the key of the entry,
+// and whether we should start a background refresh of the entry, which we
+// mark as refreshing. When the key contains an ECS subnet, we use the entry
+// with the longest scope containing the subnet, including the global one.
+func (c *Cache) get(key cacheKey, now time.Time) ([]dns.RR, cacheKey, bool, bool) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry := shard.lookupLocked(&key, now)
+	if entry == nil {
+		shard.stats.Misses++
+		return nil, key, false, false
+	}
+	shard.stats.Hits++
+	entry.hits++
+	entry.lastUsed = now
+	if entry.prefetched {
+		shard.stats.PrefetchHits++
+	}
+	prefetch := c.PrefetchWindow > 0 && !entry.prefetching && entry.expires.Sub(now) <= c.PrefetchWindow
+	if prefetch {
+		entry.prefetching = true
+		shard.stats.Prefetches++
+	}
+	return entry.copyRRs(now), key, prefetch, true
+}
+
+// lookupLocked returns the unexpired entry for the given key, if any, and
+// updates the key to be the key of the entry. The caller must hold the mutex.
+func (c *cacheShard) lookupLocked(key *cacheKey, now time.Time) *cacheEntry {
+	subnet := key.subnet
+	for bits := subnet.Bits(); bits >= 0; bits-- {
+		key.subnet = netip.PrefixFrom(subnet.Addr(), bits).Masked()
+		if bits <= 0 {
+			break // the global entry uses the zero prefix
+		}
+		if entry := c.entries[*key]; entry != nil && now.Before(entry.expires) {
+			return entry
+		}
+	}
+	key.subnet = netip.Prefix{}
+	if entry := c.entries[*key]; entry != nil && now.Before(entry.expires) {
+		return entry
+	}
+	key.subnet = subnet
+	return nil
+}
+
+// copyRRs returns a copy of the RRs whose TTLs reflect the remaining lifetime.
+func (e *cacheEntry) copyRRs(now time.Time) []dns.RR {
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	rrs := make([]dns.RR, 0, len(e.rrs))
+	for _, rr := range e.rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Ttl -= min(elapsed, rr.Header().Ttl)
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// put stores a copy of the given RRs using the given key, unless the RRs are not cacheable.
+func (c *Cache) put(key cacheKey, rrs []dns.RR, now time.Time, prefetched bool) {
+	var ttl uint32
+	for idx, rr := range rrs {
+		if idx == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	entry := &cacheEntry{
+		rrs:        make([]dns.RR, 0, len(rrs)),
+		stored:     now,
+		expires:    now.Add(time.Duration(ttl) * time.Second),
+		lastUsed:   now,
+		prefetched: prefetched,
+	}
+	for _, rr := range rrs {
+		entry.rrs = append(entry.rrs, dns.Copy(rr))
+	}
+
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.insertLocked(key, entry, now)
+}
+
+// insertLocked inserts the given entry, preserving the usage of the entry
+// it replaces, if any, and evicting an entry when the shard is full. The
+// caller must hold the mutex.
+func (c *cacheShard) insertLocked(key cacheKey, entry *cacheEntry, now time.Time) {
+	if old, found := c.entries[key]; found {
+		entry.hits += old.hits
+		entry.lastUsed = maxTime(entry.lastUsed, old.lastUsed)
+	} else if len(c.entries) >= c.maxEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = entry
+}
+
+// evictLocked samples up to cacheEvictionSamples entries, relying on the
+// randomized map iteration order, and evicts the expired ones or, if none
+// of them is expired, the one chosen by the eviction policy. Since we only
+// look at the sample, the cost does not depend on the number of entries.
+// The caller must hold the mutex.
+func (c *cacheShard) evictLocked(now time.Time) {
+	var expired bool
+	c.keys, c.usages = c.keys[:0], c.usages[:0]
+	for key, entry := range c.entries {
+		if len(c.keys) >= cacheEvictionSamples {
+			break
+		}
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+			expired = true
+			continue
+		}
+		c.keys = append(c.keys, key)
+		c.usages = append(c.usages, CacheEntryUsage{
+			Stored:   entry.stored,
+			Expires:  entry.expires,
+			LastUsed: entry.lastUsed,
+			Hits:     entry.hits,
+		})
+	}
+	if !expired && len(c.keys) > 0 {
+		victim := c.eviction.Victim(c.usages)
+		delete(c.entries, c.keys[min(max(victim, 0), len(c.keys)-1)])
+	}
+}
+
+// maxTime returns the later of the given times.
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// donePrefetching clears the refreshing flag of the entry with the given
+// key, if it still exists, such that we can retry a failed refresh.
+func (c *Cache) donePrefetching(key cacheKey) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if entry := shard.entries[key]; entry != nil {
+		entry.prefetching = false
+	}
+}
+
+// lookupCached implements [*Resolver.lookup] when the resolver has a cache.
+func (r *Resolver) lookupCached(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	// 1. Normalize the name like the query would do and bypass
+	// the cache if the name is invalid, to get the proper error,
+	// or if we should not cache answers obtained using ECS.
+	query, err := NewQueryWithServerAddr(&ServerAddr{}, name, qtype)
+	if err != nil || (r.ClientSubnet.IsValid() && r.Cache.NoClientSubnet) {
+		return r.lookupUncached(ctx, name, qtype)
+	}
+	key := cacheKey{name: dns.CanonicalName(query.Question[0].Name), qtype: qtype}
+	if r.ClientSubnet.IsValid() {
+		key.subnet = r.ClientSubnet.Masked()
+	}
+
+	// 2. Serve from the cache, possibly refreshing in the background.
+	if rrs, entryKey, prefetch, found := r.Cache.get(key, r.timeNow()); found {
+		if prefetch {
+			go r.prefetch(entryKey, name, qtype)
+		}
+		return rrs, nil
+	}
+
+	// 3. Otherwise, perform the lookup and cache the result using the scope.
+	rrs, scope, err := r.lookupUncachedWithScope(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	key.subnet = scope
+	r.Cache.put(key, rrs, r.timeNow(), false)
+	return rrs, nil
+}
+
+// prefetch refreshes the cache entry with the given key in the background
+// using [QueryPriorityBackground], to avoid delaying interactive queries.
+func (r *Resolver) prefetch(key cacheKey, name string, qtype uint16) {
+	defer r.Cache.donePrefetching(key)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCachePrefetchTimeout)
+	defer cancel()
+	ctx = ContextWithQueryPriority(ctx, QueryPriorityBackground)
+	if rrs, scope, err := r.lookupUncachedWithScope(ctx, name, qtype); err == nil {
+		r.Cache.put(cacheKey{name: key.name, qtype: key.qtype, subnet: scope}, rrs, r.timeNow(), true)
+	}
+}
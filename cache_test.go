@@ -0,0 +1,307 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// cacheTestEntry returns a copy of the entry with the given key, if any.
+func cacheTestEntry(cache *Cache, key cacheKey) (cacheEntry, bool) {
+	shard := cache.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, found := shard.entries[key]
+	if !found {
+		return cacheEntry{}, false
+	}
+	return *entry, true
+}
+
+func TestCache(t *testing.T) {
+	// newResolver returns a [*Resolver] using the given cache and
+	// a transport answering with an A record with the given TTL, along with
+	// the number of queries and a function to advance the virtual time.
+	newResolver := func(cache *Cache, ttl uint32) (*Resolver, *atomic.Int64, func(time.Duration)) {
+		var (
+			mu  sync.Mutex
+			now = time.Now()
+		)
+		queries := &atomic.Int64{}
+		reso := &Resolver{
+			Cache: cache,
+			TimeNow: func() time.Time {
+				mu.Lock()
+				defer mu.Unlock()
+				return now
+			},
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					queries.Add(1)
+					resp := &dns.Msg{}
+					resp.SetReply(query)
+					resp.Answer = append(resp.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+						A:   net.IPv4(93, 184, 215, 14),
+					})
+					return resp, nil
+				},
+			},
+		}
+		advance := func(d time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			now = now.Add(d)
+		}
+		return reso, queries, advance
+	}
+
+	lookup := func(t *testing.T, reso *Resolver, name string) []dns.RR {
+		rrs, _, err := reso.LookupWithExchanges(context.Background(), name, dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+		return rrs
+	}
+
+	t.Run("serves unexpired entries with the remaining TTL", func(t *testing.T) {
+		cache := &Cache{}
+		reso, queries, advance := newResolver(cache, 300)
+		lookup(t, reso, "example.com")
+		advance(100 * time.Second)
+		rrs := lookup(t, reso, "EXAMPLE.com.")
+		assert.Equal(t, uint32(200), rrs[0].Header().Ttl)
+		assert.Equal(t, int64(1), queries.Load())
+
+		// make sure modifying the returned RRs does not modify the cache
+		rrs[0].Header().Ttl = 1
+		rrs = lookup(t, reso, "example.com")
+		assert.Equal(t, uint32(200), rrs[0].Header().Ttl)
+
+		advance(200 * time.Second)
+		lookup(t, reso, "example.com")
+		assert.Equal(t, int64(2), queries.Load())
+		assert.Equal(t, CacheStats{Entries: 1, Hits: 2, Misses: 2}, cache.Stats())
+	})
+
+	t.Run("does not cache zero TTLs", func(t *testing.T) {
+		reso, queries, _ := newResolver(&Cache{}, 0)
+		lookup(t, reso, "example.com")
+		lookup(t, reso, "example.com")
+		assert.Equal(t, int64(2), queries.Load())
+	})
+
+	t.Run("caches the TTL after applying the policy", func(t *testing.T) {
+		reso, queries, advance := newResolver(&Cache{}, 0)
+		reso.TTLPolicy = &TTLPolicy{MinTTL: time.Minute}
+		lookup(t, reso, "example.com")
+		advance(59 * time.Second)
+		rrs := lookup(t, reso, "example.com")
+		assert.Equal(t, uint32(1), rrs[0].Header().Ttl)
+		assert.Equal(t, int64(1), queries.Load())
+	})
+
+	t.Run("does not cache failures", func(t *testing.T) {
+		cache := &Cache{}
+		reso := &Resolver{Cache: cache, Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				resp := &dns.Msg{}
+				resp.SetRcode(query, dns.RcodeNameError)
+				return resp, nil
+			},
+		}}
+		_, err := reso.LookupA(context.Background(), "example.com")
+		assert.ErrorIs(t, err, ErrNoName)
+		assert.Equal(t, 0, cache.Stats().Entries)
+	})
+
+	t.Run("bypasses the cache for invalid names", func(t *testing.T) {
+		cache := &Cache{}
+		reso := &Resolver{Cache: cache}
+		_, err := reso.LookupA(context.Background(), "invalid_name.example")
+		assert.Error(t, err)
+		assert.Equal(t, CacheStats{}, cache.Stats())
+	})
+
+	t.Run("evicts the entry expiring first when full", func(t *testing.T) {
+		cache := &Cache{MaxEntries: 2, Shards: 1}
+		reso, _, advance := newResolver(cache, 300)
+		lookup(t, reso, "a.example.com")
+		advance(time.Second)
+		lookup(t, reso, "b.example.com")
+		lookup(t, reso, "c.example.com")
+		assert.Equal(t, 2, cache.Stats().Entries)
+		_, found := cacheTestEntry(cache, cacheKey{name: "a.example.com.", qtype: dns.TypeA})
+		assert.False(t, found)
+	})
+
+	t.Run("prefetches entries about to expire", func(t *testing.T) {
+		cache := &Cache{PrefetchWindow: 30 * time.Second}
+		reso, queries, advance := newResolver(cache, 300)
+		var priorities []QueryPriority
+		txp := reso.Transport.(*MockResolverTransport)
+		mockQuery := txp.MockQuery
+		txp.MockQuery = func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			priorities = append(priorities, QueryPriorityFromContext(ctx))
+			return mockQuery(ctx, addr, query)
+		}
+		lookup(t, reso, "example.com")
+		advance(200 * time.Second)
+		lookup(t, reso, "example.com")
+		assert.Equal(t, int64(0), cache.Stats().Prefetches)
+
+		advance(80 * time.Second)
+		rrs := lookup(t, reso, "example.com")
+		assert.Equal(t, uint32(20), rrs[0].Header().Ttl)
+		assert.Eventually(t, func() bool { return queries.Load() == 2 }, time.Second, time.Millisecond)
+		assert.Eventually(t, func() bool {
+			entry, _ := cacheTestEntry(cache, cacheKey{name: "example.com.", qtype: dns.TypeA})
+			return entry.prefetched
+		}, time.Second, time.Millisecond)
+
+		advance(60 * time.Second)
+		rrs = lookup(t, reso, "example.com")
+		assert.Equal(t, uint32(240), rrs[0].Header().Ttl)
+		assert.Equal(t, int64(2), queries.Load())
+		assert.Equal(t, CacheStats{Entries: 1, Hits: 3, Misses: 1, Prefetches: 1, PrefetchHits: 1}, cache.Stats())
+		assert.Equal(t, []QueryPriority{QueryPriorityInteractive, QueryPriorityBackground}, priorities)
+	})
+
+	t.Run("keys entries by the ECS scope", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			scope          uint8
+			noClientSubnet bool
+			queries        int64
+		}{
+			{"tailored answers", 24, false, 2},
+			{"global answers", 0, false, 1},
+			{"caching disabled", 0, true, 3},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cache := &Cache{NoClientSubnet: tt.noClientSubnet}
+				queries := &atomic.Int64{}
+				newResolver := func(subnet string) *Resolver {
+					return &Resolver{
+						Cache:        cache,
+						ClientSubnet: netip.MustParsePrefix(subnet),
+						Transport: &MockResolverTransport{
+							MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+								queries.Add(1)
+								resp := newClientSubnetResponse(query, tt.scope)
+								rr, _ := dns.NewRR("example.com. 300 IN A 93.184.215.14")
+								resp.Answer = append(resp.Answer, rr)
+								return resp, nil
+							},
+						},
+					}
+				}
+				lookup(t, newResolver("192.0.2.0/24"), "example.com")
+				lookup(t, newResolver("198.51.100.0/24"), "example.com")
+				lookup(t, newResolver("192.0.2.0/24"), "example.com")
+				assert.Equal(t, tt.queries, queries.Load())
+			})
+		}
+	})
+}
+
+func TestCache_shards(t *testing.T) {
+	tests := []struct {
+		name       string
+		cache      *Cache
+		shards     int
+		maxEntries int
+	}{
+		{"defaults", &Cache{}, DefaultCacheShards, DefaultCacheMaxEntries / DefaultCacheShards},
+		{"custom", &Cache{MaxEntries: 100, Shards: 8}, 8, 13},
+		{"more shards than entries", &Cache{MaxEntries: 4, Shards: 8}, 4, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shards := tt.cache.init()
+			assert.Len(t, shards, tt.shards)
+			assert.Equal(t, tt.maxEntries, shards[0].maxEntries)
+		})
+	}
+
+	t.Run("aggregating the statistics", func(t *testing.T) {
+		cache := &Cache{}
+		now := time.Now()
+		for idx := 0; idx < 64; idx++ {
+			rr, _ := dns.NewRR(fmt.Sprintf("%d.example.com. 300 IN A 93.184.215.14", idx))
+			key := cacheKey{name: rr.Header().Name, qtype: dns.TypeA}
+			cache.put(key, []dns.RR{rr}, now, false)
+			cache.get(key, now)
+		}
+		var used int
+		for _, shard := range cache.init() {
+			if len(shard.entries) > 0 {
+				used++
+			}
+		}
+		assert.Greater(t, used, 1)
+		assert.Equal(t, CacheStats{Entries: 64, Hits: 64}, cache.Stats())
+	})
+}
+
+func BenchmarkCache_get(b *testing.B) {
+	for _, shards := range []int{1, DefaultCacheShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cache := &Cache{Shards: shards}
+			now := time.Now()
+			var keys []cacheKey
+			for idx := 0; idx < 1024; idx++ {
+				rr, _ := dns.NewRR(fmt.Sprintf("%d.example.com. 300 IN A 93.184.215.14", idx))
+				key := cacheKey{name: rr.Header().Name, qtype: dns.TypeA}
+				cache.put(key, []dns.RR{rr}, now, false)
+				keys = append(keys, key)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			var workers atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				idx := int(workers.Add(1)) * 97 // spread workers among the shards
+				for pb.Next() {
+					cache.get(keys[idx%len(keys)], now)
+					idx++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCache_put(b *testing.B) {
+	for _, shards := range []int{1, DefaultCacheShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cache := &Cache{Shards: shards}
+			now := time.Now()
+			var rrs []dns.RR
+			for idx := 0; idx < 1024; idx++ {
+				rr, _ := dns.NewRR(fmt.Sprintf("%d.example.com. 300 IN A 93.184.215.14", idx))
+				rrs = append(rrs, rr)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			var workers atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				idx := int(workers.Add(1)) * 97 // spread workers among the shards
+				for pb.Next() {
+					rr := rrs[idx%len(rrs)]
+					cache.put(cacheKey{name: rr.Header().Name, qtype: dns.TypeA}, []dns.RR{rr}, now, false)
+					idx++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// CacheEvictionPolicy chooses which entry to evict when a shard
+// of a [*Cache] is full and we need to store a new entry. We pass
+// the policy a random sample of the unexpired entries of the shard,
+// after evicting the expired entries we found while sampling, if
+// any, in which case we do not use the policy.
+//
+// Implementations must be safe for concurrent use by multiple
+// goroutines, since shards invoke the policy concurrently.
+type CacheEvictionPolicy interface {
+	// Victim returns the index of the entry to evict within the given
+	// nonempty sample of entries, which the policy must not retain.
+	Victim(entries []CacheEntryUsage) int
+}
+
+// CacheEntryUsage describes how a [*Cache] entry has been used,
+// for the purpose of choosing which entry to evict.
+type CacheEntryUsage struct {
+	// Stored is when we stored the entry.
+	Stored time.Time
+
+	// Expires is when the entry expires.
+	Expires time.Time
+
+	// LastUsed is when we last served the entry or, if
+	// we never served it, when we stored the entry.
+	LastUsed time.Time
+
+	// Hits is the number of lookups served using the entry,
+	// including those served before refreshing the entry.
+	Hits int64
+}
+
+// CacheEvictionTTL is the [CacheEvictionPolicy] evicting the
+// entry that expires first. This is the default policy, which
+// minimizes the amount of cached TTL we throw away.
+type CacheEvictionTTL struct{}
+
+var _ CacheEvictionPolicy = CacheEvictionTTL{}
+
+// Victim implements [CacheEvictionPolicy].
+func (CacheEvictionTTL) Victim(entries []CacheEntryUsage) int {
+	return cacheEvictionMin(entries, func(a, b *CacheEntryUsage) bool {
+		return a.Expires.Before(b.Expires)
+	})
+}
+
+// CacheEvictionLRU is the [CacheEvictionPolicy] evicting the
+// least recently used entry, which suits high-hit-rate proxies.
+type CacheEvictionLRU struct{}
+
+var _ CacheEvictionPolicy = CacheEvictionLRU{}
+
+// Victim implements [CacheEvictionPolicy].
+func (CacheEvictionLRU) Victim(entries []CacheEntryUsage) int {
+	return cacheEvictionMin(entries, func(a, b *CacheEntryUsage) bool {
+		return a.LastUsed.Before(b.LastUsed)
+	})
+}
+
+// CacheEvictionLFU is the [CacheEvictionPolicy] evicting the least
+// frequently used entry, breaking ties using the least recently used.
+type CacheEvictionLFU struct{}
+
+var _ CacheEvictionPolicy = CacheEvictionLFU{}
+
+// Victim implements [CacheEvictionPolicy].
+func (CacheEvictionLFU) Victim(entries []CacheEntryUsage) int {
+	return cacheEvictionMin(entries, func(a, b *CacheEntryUsage) bool {
+		if a.Hits != b.Hits {
+			return a.Hits < b.Hits
+		}
+		return a.LastUsed.Before(b.LastUsed)
+	})
+}
+
+// CacheEvictionRandom is the [CacheEvictionPolicy] evicting a random
+// entry, which is cheap and suits memory-constrained deployments.
+type CacheEvictionRandom struct{}
+
+var _ CacheEvictionPolicy = CacheEvictionRandom{}
+
+// Victim implements [CacheEvictionPolicy].
+func (CacheEvictionRandom) Victim(entries []CacheEntryUsage) int {
+	return rand.IntN(len(entries))
+}
+
+// cacheEvictionMin returns the index of the entry for which
+// less returns true when compared with any other entry.
+func cacheEvictionMin(entries []CacheEntryUsage, less func(a, b *CacheEntryUsage) bool) int {
+	var victim int
+	for idx := 1; idx < len(entries); idx++ {
+		if less(&entries[idx], &entries[victim]) {
+			victim = idx
+		}
+	}
+	return victim
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheEvictionPolicy(t *testing.T) {
+	now := time.Now()
+	entries := []CacheEntryUsage{
+		{Expires: now.Add(time.Hour), LastUsed: now.Add(-time.Minute), Hits: 1},
+		{Expires: now.Add(time.Minute), LastUsed: now, Hits: 10},
+		{Expires: now.Add(time.Hour), LastUsed: now.Add(-time.Hour), Hits: 5},
+		{Expires: now.Add(2 * time.Hour), LastUsed: now.Add(-time.Second), Hits: 1},
+	}
+
+	tests := []struct {
+		name     string
+		policy   CacheEvictionPolicy
+		expected int
+	}{
+		{"TTL", CacheEvictionTTL{}, 1},
+		{"LRU", CacheEvictionLRU{}, 2},
+		{"LFU", CacheEvictionLFU{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.policy.Victim(entries))
+		})
+	}
+
+	t.Run("Random", func(t *testing.T) {
+		seen := make(map[int]bool)
+		for idx := 0; idx < 1000; idx++ {
+			victim := CacheEvictionRandom{}.Victim(entries)
+			assert.True(t, victim >= 0 && victim < len(entries))
+			seen[victim] = true
+		}
+		assert.Len(t, seen, len(entries))
+	})
+}
+
+func TestCache_Eviction(t *testing.T) {
+	now := time.Now()
+	newRR := func(name string, ttl uint32) (cacheKey, []dns.RR) {
+		rr := &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}}
+		return cacheKey{name: name, qtype: dns.TypeA}, []dns.RR{rr}
+	}
+	has := func(cache *Cache, name string) bool {
+		_, found := cacheTestEntry(cache, cacheKey{name: name, qtype: dns.TypeA})
+		return found
+	}
+
+	t.Run("using the policy", func(t *testing.T) {
+		cache := &Cache{MaxEntries: 2, Shards: 1, Eviction: CacheEvictionLRU{}}
+		key, rrs := newRR("a.example.com.", 60)
+		cache.put(key, rrs, now, false)
+		cache.get(key, now.Add(time.Second))
+		key, rrs = newRR("b.example.com.", 300)
+		cache.put(key, rrs, now, false)
+		key, rrs = newRR("c.example.com.", 300)
+		cache.put(key, rrs, now.Add(2*time.Second), false)
+		assert.True(t, has(cache, "a.example.com."))
+		assert.False(t, has(cache, "b.example.com."))
+		assert.True(t, has(cache, "c.example.com."))
+	})
+
+	t.Run("evicting expired entries first", func(t *testing.T) {
+		cache := &Cache{MaxEntries: 2, Shards: 1, Eviction: CacheEvictionLFU{}}
+		key, rrs := newRR("a.example.com.", 1)
+		cache.put(key, rrs, now, false)
+		cache.get(key, now)
+		key, rrs = newRR("b.example.com.", 300)
+		cache.put(key, rrs, now, false)
+		key, rrs = newRR("c.example.com.", 300)
+		cache.put(key, rrs, now.Add(time.Minute), false)
+		assert.False(t, has(cache, "a.example.com."))
+		assert.True(t, has(cache, "b.example.com."))
+	})
+
+	t.Run("evicting all the sampled expired entries", func(t *testing.T) {
+		cache := &Cache{MaxEntries: 3, Shards: 1}
+		for _, name := range []string{"a.example.com.", "b.example.com."} {
+			key, rrs := newRR(name, 1)
+			cache.put(key, rrs, now, false)
+		}
+		key, rrs := newRR("c.example.com.", 300)
+		cache.put(key, rrs, now, false)
+		key, rrs = newRR("d.example.com.", 300)
+		cache.put(key, rrs, now.Add(time.Minute), false)
+		assert.Equal(t, 2, cache.Stats().Entries)
+		assert.True(t, has(cache, "c.example.com."))
+	})
+
+	t.Run("passing the policy a bounded sample", func(t *testing.T) {
+		policy := &cacheEvictionTestPolicy{}
+		cache := &Cache{MaxEntries: 4 * cacheEvictionSamples, Shards: 1, Eviction: policy}
+		for idx := 0; idx < 8*cacheEvictionSamples; idx++ {
+			key, rrs := newRR(fmt.Sprintf("%d.example.com.", idx), 300)
+			cache.put(key, rrs, now, false)
+		}
+		assert.Equal(t, 4*cacheEvictionSamples, cache.Stats().Entries)
+		assert.Equal(t, []int{cacheEvictionSamples}, slices.Compact(policy.sizes))
+	})
+
+	t.Run("preserving the usage when refreshing", func(t *testing.T) {
+		cache := &Cache{}
+		key, rrs := newRR("a.example.com.", 300)
+		cache.put(key, rrs, now, false)
+		cache.get(key, now.Add(time.Second))
+		cache.put(key, rrs, now.Add(time.Second), true)
+		entry, _ := cacheTestEntry(cache, key)
+		assert.Equal(t, int64(1), entry.hits)
+	})
+}
+
+// cacheEvictionTestPolicy records the size of the samples it sees
+// and evicts the first entry of each sample.
+type cacheEvictionTestPolicy struct {
+	sizes []int
+}
+
+// Victim implements [CacheEvictionPolicy].
+func (p *cacheEvictionTestPolicy) Victim(entries []CacheEntryUsage) int {
+	p.sizes = append(p.sizes, len(entries))
+	return 0
+}
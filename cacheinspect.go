@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// CacheEntry describes an entry of a [*Cache] for inspection purposes.
+type CacheEntry struct {
+	// Name is the canonical query name.
+	Name string
+
+	// QType is the query type.
+	QType uint16
+
+	// Subnet is the ECS scope of the answer, which is the
+	// zero prefix when the answer applies to all clients.
+	Subnet netip.Prefix
+
+	// RRs contains a copy of the answer RRs with their TTLs
+	// reflecting the remaining lifetime of the entry.
+	RRs []dns.RR
+
+	// TTL is the remaining lifetime of the entry.
+	TTL time.Duration
+}
+
+// Entries returns the entries of the cache that have not expired at the
+// given time, sorted by name, query type, and subnet, for inspection purposes.
+func (c *Cache) Entries(now time.Time) []CacheEntry {
+	var entries []CacheEntry
+	c.forEach(func(key cacheKey, entry *cacheEntry) {
+		if !now.Before(entry.expires) {
+			return
+		}
+		entries = append(entries, CacheEntry{
+			Name:   key.name,
+			QType:  key.qtype,
+			Subnet: key.subnet,
+			RRs:    entry.copyRRs(now),
+			TTL:    entry.expires.Sub(now),
+		})
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		if entries[i].QType != entries[j].QType {
+			return entries[i].QType < entries[j].QType
+		}
+		return entries[i].Subnet.String() < entries[j].Subnet.String()
+	})
+	return entries
+}
+
+// TTL returns the remaining lifetime, at the given time, of the entry with
+// the given name and query type that applies to all clients, regardless of
+// ECS, and whether such an unexpired entry exists. Unlike lookups, calling
+// this method does not affect the statistics.
+func (c *Cache) TTL(name string, qtype uint16, now time.Time) (time.Duration, bool) {
+	key := cacheKey{name: dns.CanonicalName(name), qtype: qtype}
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry := shard.entries[key]
+	if entry == nil || !now.Before(entry.expires) {
+		return 0, false
+	}
+	return entry.expires.Sub(now), true
+}
+
+// flushFunc removes the entries whose key matches and returns their number.
+func (c *Cache) flushFunc(match func(key cacheKey) bool) int {
+	var count int
+	for _, shard := range c.init() {
+		shard.mu.Lock()
+		for key := range shard.entries {
+			if match(key) {
+				delete(shard.entries, key)
+				count++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return count
+}
+
+// Flush removes all the entries and returns their number.
+func (c *Cache) Flush() int {
+	return c.flushFunc(func(key cacheKey) bool {
+		return true
+	})
+}
+
+// FlushName removes the entries with the given name, regardless of
+// the query type, and returns their number.
+func (c *Cache) FlushName(name string) int {
+	name = dns.CanonicalName(name)
+	return c.flushFunc(func(key cacheKey) bool {
+		return key.name == name
+	})
+}
+
+// FlushSuffix removes the entries whose name is equal to or a subdomain
+// of the given suffix, e.g., to flush a whole zone, and returns their number.
+func (c *Cache) FlushSuffix(suffix string) int {
+	suffix = dns.CanonicalName(suffix)
+	return c.flushFunc(func(key cacheKey) bool {
+		return dns.IsSubDomain(suffix, key.name)
+	})
+}
+
+// FlushType removes the entries with the given query type and returns their number.
+func (c *Cache) FlushType(qtype uint16) int {
+	return c.flushFunc(func(key cacheKey) bool {
+		return key.qtype == qtype
+	})
+}
+
+// HitRate returns the fraction of lookups served from the cache,
+// or zero when there have not been any lookups.
+func (s CacheStats) HitRate() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
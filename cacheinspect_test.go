@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Inspection(t *testing.T) {
+	now := time.Now()
+	newCache := func() *Cache {
+		cache := &Cache{}
+		for _, text := range []string{
+			"example.com. 300 IN A 93.184.215.14",
+			"example.com. 300 IN AAAA 2001:db8::1",
+			"www.example.com. 60 IN A 93.184.215.14",
+			"example.org. 10 IN A 93.184.215.14",
+		} {
+			rr, _ := dns.NewRR(text)
+			key := cacheKey{name: rr.Header().Name, qtype: rr.Header().Rrtype}
+			cache.put(key, []dns.RR{rr}, now, false)
+		}
+		return cache
+	}
+
+	t.Run("Entries", func(t *testing.T) {
+		entries := newCache().Entries(now.Add(30 * time.Second))
+		assert.Len(t, entries, 3)
+		assert.Equal(t, "example.com.", entries[0].Name)
+		assert.Equal(t, dns.TypeA, entries[0].QType)
+		assert.Equal(t, 270*time.Second, entries[0].TTL)
+		assert.Equal(t, uint32(270), entries[0].RRs[0].Header().Ttl)
+		assert.Equal(t, dns.TypeAAAA, entries[1].QType)
+		assert.Equal(t, "www.example.com.", entries[2].Name)
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		cache := newCache()
+		ttl, found := cache.TTL("WWW.example.com", dns.TypeA, now.Add(20*time.Second))
+		assert.True(t, found)
+		assert.Equal(t, 40*time.Second, ttl)
+		_, found = cache.TTL("example.org", dns.TypeA, now.Add(20*time.Second))
+		assert.False(t, found)
+		assert.Equal(t, CacheStats{Entries: 4}, cache.Stats())
+	})
+
+	t.Run("flushing", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			flush     func(c *Cache) int
+			remaining int
+		}{
+			{"Flush", func(c *Cache) int { return c.Flush() }, 0},
+			{"FlushName", func(c *Cache) int { return c.FlushName("Example.COM") }, 2},
+			{"FlushSuffix", func(c *Cache) int { return c.FlushSuffix("com") }, 1},
+			{"FlushType", func(c *Cache) int { return c.FlushType(dns.TypeA) }, 1},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cache := newCache()
+				assert.Equal(t, 4-tt.remaining, tt.flush(cache))
+				assert.Equal(t, tt.remaining, cache.Stats().Entries)
+			})
+		}
+	})
+
+	t.Run("HitRate", func(t *testing.T) {
+		assert.Equal(t, 0.0, CacheStats{}.HitRate())
+		assert.Equal(t, 0.75, CacheStats{Hits: 3, Misses: 1}.HitRate())
+	})
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrInvalidCacheSnapshot indicates that a [*Cache] snapshot is malformed.
+var ErrInvalidCacheSnapshot = errors.New("invalid cache snapshot")
+
+// cacheSnapshotEntry is the serialization of a [*Cache] entry. We serialize
+// entries as JSON, one per line, and RRs using the presentation format,
+// such that snapshots are easy to inspect.
+type cacheSnapshotEntry struct {
+	// Name is the canonical query name.
+	Name string `json:"name"`
+
+	// QType is the query type.
+	QType string `json:"qtype"`
+
+	// Subnet is the ECS scope of the answer, if any.
+	Subnet string `json:"subnet,omitempty"`
+
+	// RRs contains the answer RRs with their original TTLs.
+	RRs []string `json:"rrs"`
+
+	// Stored is when we stored the entry.
+	Stored time.Time `json:"stored"`
+
+	// Expires is when the entry expires.
+	Expires time.Time `json:"expires"`
+}
+
+// WriteSnapshot writes the entries of the cache that have not expired
+// at the given time to w, such that [*Cache.ReadSnapshot] can later
+// reload them, e.g., after a restart.
+func (c *Cache) WriteSnapshot(w io.Writer, now time.Time) error {
+	// 1. serialize the unexpired entries while holding the mutexes
+	var entries []*cacheSnapshotEntry
+	c.forEach(func(key cacheKey, entry *cacheEntry) {
+		if !now.Before(entry.expires) {
+			return
+		}
+		sentry := &cacheSnapshotEntry{
+			Name:    key.name,
+			QType:   dns.TypeToString[key.qtype],
+			RRs:     make([]string, 0, len(entry.rrs)),
+			Stored:  entry.stored,
+			Expires: entry.expires,
+		}
+		for _, rr := range entry.rrs {
+			sentry.RRs = append(sentry.RRs, rr.String())
+		}
+		if key.subnet.IsValid() {
+			sentry.Subnet = key.subnet.String()
+		}
+		entries = append(entries, sentry)
+	})
+
+	// 2. write the entries without holding the mutex
+	encoder := json.NewEncoder(w)
+	for _, sentry := range entries {
+		if err := encoder.Encode(sentry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSnapshot reads the entries written by [*Cache.WriteSnapshot] and adds
+// those that have not expired at the given time to the cache. Since entries
+// contain absolute times, the TTLs served after reloading account for the
+// time elapsed since writing the snapshot. On error, we do not modify the cache.
+func (c *Cache) ReadSnapshot(r io.Reader, now time.Time) error {
+	// 1. parse all the entries before modifying the cache
+	var (
+		keys    []cacheKey
+		entries []*cacheEntry
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1<<16), 1<<22)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sentry := &cacheSnapshotEntry{}
+		if err := json.Unmarshal([]byte(line), sentry); err != nil {
+			return err
+		}
+		qtype, found := dns.StringToType[sentry.QType]
+		if !found {
+			return fmt.Errorf("%w: unknown qtype: %s", ErrInvalidCacheSnapshot, sentry.QType)
+		}
+		key := cacheKey{name: dns.CanonicalName(sentry.Name), qtype: qtype}
+		if sentry.Subnet != "" {
+			subnet, err := netip.ParsePrefix(sentry.Subnet)
+			if err != nil {
+				return fmt.Errorf("%w: invalid subnet: %s", ErrInvalidCacheSnapshot, sentry.Subnet)
+			}
+			key.subnet = subnet.Masked()
+		}
+		entry := &cacheEntry{
+			rrs:      make([]dns.RR, 0, len(sentry.RRs)),
+			stored:   sentry.Stored,
+			expires:  sentry.Expires,
+			lastUsed: sentry.Stored,
+		}
+		for _, text := range sentry.RRs {
+			rr, err := dns.NewRR(text)
+			if err != nil || rr == nil {
+				return fmt.Errorf("%w: invalid RR: %s", ErrInvalidCacheSnapshot, text)
+			}
+			entry.rrs = append(entry.rrs, rr)
+		}
+		if len(entry.rrs) <= 0 || !now.Before(entry.expires) {
+			continue
+		}
+		keys = append(keys, key)
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// 2. add the entries to the cache
+	for idx, key := range keys {
+		shard := c.shard(key)
+		shard.mu.Lock()
+		shard.insertLocked(key, entries[idx], now)
+		shard.mu.Unlock()
+	}
+	return nil
+}
+
+// SaveSnapshotFile is like [*Cache.WriteSnapshot] but atomically
+// replaces the given file, using the current time.
+func (c *Cache) SaveSnapshotFile(path string) error {
+	// 1. write the snapshot to a temporary file in the same directory
+	filep, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filep.Name()) // fails after a successful rename
+	if err := c.WriteSnapshot(filep, time.Now()); err != nil {
+		filep.Close()
+		return err
+	}
+	if err := filep.Close(); err != nil {
+		return err
+	}
+
+	// 2. replace the file such that readers never see a partial snapshot
+	return os.Rename(filep.Name(), path)
+}
+
+// LoadSnapshotFile is like [*Cache.ReadSnapshot] but reads the given file,
+// using the current time. A nonexistent file is not an error, such that
+// the first run of a program starts with an empty cache.
+func (c *Cache) LoadSnapshotFile(path string) error {
+	filep, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer filep.Close()
+	return c.ReadSnapshot(filep, time.Now())
+}
+
+// PersistSnapshotFile saves the cache to the given file using
+// [*Cache.SaveSnapshotFile] every interval until the context is done,
+// e.g., on shutdown, when it saves the cache one last time and returns
+// the corresponding error. Periodic failures are retried at the next
+// interval. Run this method in a background goroutine.
+func (c *Cache) PersistSnapshotFile(ctx context.Context, path string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return c.SaveSnapshotFile(path)
+		case <-ticker.C:
+			_ = c.SaveSnapshotFile(path)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Snapshot(t *testing.T) {
+	now := time.Now()
+	newCache := func() *Cache {
+		cache := &Cache{}
+		a, _ := dns.NewRR("example.com. 300 IN A 93.184.215.14")
+		aaaa, _ := dns.NewRR("example.com. 60 IN AAAA 2001:db8::1")
+		cache.put(cacheKey{name: "example.com.", qtype: dns.TypeA}, []dns.RR{a}, now, false)
+		cache.put(cacheKey{name: "example.com.", qtype: dns.TypeAAAA}, []dns.RR{aaaa}, now, false)
+		cache.put(cacheKey{name: "example.com.", qtype: dns.TypeA, subnet: netip.MustParsePrefix("192.0.2.0/24")},
+			[]dns.RR{a}, now, false)
+		return cache
+	}
+
+	t.Run("round trip adjusting the TTLs", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, newCache().WriteSnapshot(buf, now.Add(time.Second)))
+		assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+
+		cache := &Cache{}
+		later := now.Add(100 * time.Second)
+		assert.NoError(t, cache.ReadSnapshot(buf, later))
+		assert.Equal(t, 2, cache.Stats().Entries)
+		rrs, _, _, found := cache.get(cacheKey{name: "example.com.", qtype: dns.TypeA}, later)
+		assert.True(t, found)
+		assert.Equal(t, "example.com.\t200\tIN\tA\t93.184.215.14", rrs[0].String())
+		subnet := netip.MustParsePrefix("192.0.2.0/24")
+		_, found = cacheTestEntry(cache, cacheKey{name: "example.com.", qtype: dns.TypeA, subnet: subnet})
+		assert.True(t, found)
+	})
+
+	t.Run("skips expired entries when writing", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.NoError(t, newCache().WriteSnapshot(buf, now.Add(time.Minute)))
+		assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	})
+
+	t.Run("malformed snapshots", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			snapshot string
+		}{
+			{"invalid JSON", "{"},
+			{"unknown qtype", `{"name":"example.com.","qtype":"FOO","rrs":[]}`},
+			{"invalid RR", `{"name":"example.com.","qtype":"A","rrs":["example.com. IN A foo"]}`},
+			{"invalid subnet", `{"name":"example.com.","qtype":"A","subnet":"foo","rrs":[]}`},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cache := newCache()
+				snapshot := `{"name":"a.example.com.","qtype":"A","rrs":["a.example.com. 1 IN A 10.0.0.1"],` +
+					`"expires":"2100-01-01T00:00:00Z"}` + "\n" + tt.snapshot
+				assert.Error(t, cache.ReadSnapshot(strings.NewReader(snapshot), now))
+				assert.Equal(t, 3, cache.Stats().Entries)
+			})
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.jsonl")
+		cache := &Cache{}
+		assert.NoError(t, cache.LoadSnapshotFile(path))
+		assert.Equal(t, 0, cache.Stats().Entries)
+
+		assert.NoError(t, newCache().SaveSnapshotFile(path))
+		assert.NoError(t, cache.LoadSnapshotFile(path))
+		assert.Equal(t, 3, cache.Stats().Entries)
+
+		entries, err := os.ReadDir(filepath.Dir(path))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+
+		assert.Error(t, cache.SaveSnapshotFile(filepath.Join(path, "nonexistent")))
+		assert.Error(t, cache.LoadSnapshotFile(filepath.Dir(path)))
+	})
+
+	t.Run("persisting on shutdown", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.jsonl")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.NoError(t, newCache().PersistSnapshotFile(ctx, path, time.Hour))
+		cache := &Cache{}
+		assert.NoError(t, cache.LoadSnapshotFile(path))
+		assert.Equal(t, 3, cache.Stats().Entries)
+	})
+}
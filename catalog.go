@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ErrInvalidCatalogZone indicates that a catalog zone is malformed or
+// uses an unsupported schema version, hence we must not process it.
+var ErrInvalidCatalogZone = errors.New("invalid catalog zone")
+
+// CatalogMember is a member zone listed in a [*CatalogZone].
+type CatalogMember struct {
+	// ID is the unique label identifying the member within the catalog.
+	ID string
+
+	// Zone is the canonical name of the member zone.
+	Zone string
+
+	// Groups contains the values of the group property, if any, which
+	// producers use to signal how to configure the member zone.
+	Groups []string
+
+	// COO is the canonical name of the catalog the member zone is
+	// migrating to, according to the change of ownership property,
+	// or an empty string when the property is missing.
+	COO string
+}
+
+// CatalogZone is a parsed catalog zone (RFC 9432), which lists the zones
+// that secondary servers should serve, allowing operators to provision
+// and deprovision zones by updating a single zone.
+//
+// Construct using [ParseCatalogZone].
+type CatalogZone struct {
+	// Origin is the canonical catalog zone origin.
+	Origin string
+
+	// Members contains the member zones sorted by ID.
+	Members []*CatalogMember
+}
+
+// ParseCatalogZone parses the given zone as a version 2 catalog zone, as
+// defined by RFC 9432. We ignore the records whose meaning is not defined by
+// the specification. We fail with an error wrapping [ErrInvalidCatalogZone]
+// when the version is missing or unsupported, when a member has multiple PTR
+// records, or when multiple members refer to the same zone.
+func ParseCatalogZone(zone *Zone) (*CatalogZone, error) {
+	zone.mu.RLock()
+	defer zone.mu.RUnlock()
+
+	// 1. make sure we support the schema version
+	origin := zone.origin
+	var versions []string
+	for _, rr := range zone.nodes["version."+origin][dns.TypeTXT] {
+		versions = append(versions, strings.Join(rr.(*dns.TXT).Txt, ""))
+	}
+	if len(versions) != 1 || versions[0] != "2" {
+		return nil, fmt.Errorf("%w: missing or unsupported version: %v", ErrInvalidCatalogZone, versions)
+	}
+
+	// 2. collect the members and their properties
+	cz := &CatalogZone{Origin: origin}
+	zones := make(map[string]bool)
+	suffix := ".zones." + origin
+	for name, rrsets := range zone.nodes {
+		id, found := strings.CutSuffix(name, suffix)
+		if !found || strings.Contains(id, ".") || len(rrsets[dns.TypePTR]) <= 0 {
+			continue
+		}
+		if len(rrsets[dns.TypePTR]) != 1 {
+			return nil, fmt.Errorf("%w: multiple PTR records for member %s", ErrInvalidCatalogZone, id)
+		}
+		member := &CatalogMember{
+			ID:   id,
+			Zone: dns.CanonicalName(rrsets[dns.TypePTR][0].(*dns.PTR).Ptr),
+		}
+		if zones[member.Zone] {
+			return nil, fmt.Errorf("%w: duplicate member zone %s", ErrInvalidCatalogZone, member.Zone)
+		}
+		zones[member.Zone] = true
+		for _, rr := range zone.nodes["group."+name][dns.TypeTXT] {
+			member.Groups = append(member.Groups, strings.Join(rr.(*dns.TXT).Txt, ""))
+		}
+		slices.Sort(member.Groups)
+		if coo := zone.nodes["coo."+name][dns.TypePTR]; len(coo) == 1 {
+			member.COO = dns.CanonicalName(coo[0].(*dns.PTR).Ptr)
+		}
+		cz.Members = append(cz.Members, member)
+	}
+	slices.SortFunc(cz.Members, func(a, b *CatalogMember) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return cz, nil
+}
+
+// CatalogConsumer consumes a catalog zone (RFC 9432), keeping the catalog
+// zone in sync using a [*Secondary] and running a [*Secondary] for each
+// member zone, which we create when the member appears in the catalog and
+// stop when it disappears from the catalog. Since RFC 9432 requires resetting
+// a member zone whose unique ID changes, we then replace its [*Secondary].
+//
+// Construct using [NewCatalogConsumer].
+type CatalogConsumer struct {
+	// Catalog is the [*Secondary] keeping the catalog zone in sync,
+	// created by [NewCatalogConsumer]. Do not change its OnChange field.
+	Catalog *Secondary
+
+	// NewMember is the optional factory creating the [*Secondary] for a
+	// member zone, which allows configuring it according to its groups.
+	//
+	// If nil, we use [NewSecondary] with the primary and the transport
+	// of the catalog zone.
+	NewMember func(member *CatalogMember) *Secondary
+
+	// OnMembersChange is the optional callback invoked when members are
+	// added to or removed from the catalog. We invoke this callback from
+	// the goroutine refreshing the catalog, so it should not block.
+	OnMembersChange func(added, removed []*CatalogMember)
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// ctx is the context passed to [*CatalogConsumer.Run].
+	ctx context.Context
+
+	// err is the error that occurred parsing the catalog, if any.
+	err error
+
+	// members maps the member zones names to their state.
+	members map[string]*catalogConsumerMember
+
+	// wg tracks the running member secondaries.
+	wg sync.WaitGroup
+}
+
+// catalogConsumerMember is the state of a member zone.
+type catalogConsumerMember struct {
+	member *CatalogMember
+	sec    *Secondary
+	cancel context.CancelFunc
+}
+
+// NewCatalogConsumer creates a new [*CatalogConsumer] for the catalog
+// zone with the given origin served by the given primary.
+func NewCatalogConsumer(origin string, primary *ServerAddr) *CatalogConsumer {
+	c := &CatalogConsumer{members: make(map[string]*catalogConsumerMember)}
+	c.Catalog = NewSecondary(origin, primary)
+	c.Catalog.OnChange = func(prev, zone *Zone) {
+		c.update(zone)
+	}
+	return c
+}
+
+// Run keeps the catalog zone and the member zones in sync until the
+// context is done, then waits for the member secondaries to stop.
+func (c *CatalogConsumer) Run(ctx context.Context) {
+	c.mu.Lock()
+	c.ctx = ctx
+	c.mu.Unlock()
+	c.Catalog.Run(ctx)
+	c.mu.Lock()
+	for name, state := range c.members {
+		state.cancel()
+		delete(c.members, name)
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+// update processes a new version of the catalog zone. When the catalog is
+// invalid, we keep the current members, as required by RFC 9432.
+func (c *CatalogConsumer) update(zone *Zone) {
+	// 1. parse the catalog
+	cz, err := ParseCatalogZone(zone)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	if err != nil || c.ctx == nil {
+		return
+	}
+
+	// 2. stop the members that disappeared or changed ID
+	wanted := make(map[string]*CatalogMember)
+	for _, member := range cz.Members {
+		wanted[member.Zone] = member
+	}
+	var added, removed []*CatalogMember
+	for name, state := range c.members {
+		if member := wanted[name]; member == nil || member.ID != state.member.ID {
+			state.cancel()
+			delete(c.members, name)
+			removed = append(removed, state.member)
+		}
+	}
+
+	// 3. start the new members
+	for _, member := range cz.Members {
+		if state := c.members[member.Zone]; state != nil {
+			state.member = member
+			continue
+		}
+		sec := c.newMember(member)
+		ctx, cancel := context.WithCancel(c.ctx)
+		c.members[member.Zone] = &catalogConsumerMember{member: member, sec: sec, cancel: cancel}
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			sec.Run(ctx)
+		}()
+		added = append(added, member)
+	}
+
+	// 4. notify the changes
+	if c.OnMembersChange != nil && (len(added) > 0 || len(removed) > 0) {
+		c.OnMembersChange(added, removed)
+	}
+}
+
+// newMember creates the [*Secondary] for the given member.
+func (c *CatalogConsumer) newMember(member *CatalogMember) *Secondary {
+	if c.NewMember != nil {
+		return c.NewMember(member)
+	}
+	sec := NewSecondary(member.Zone, c.Catalog.Primary)
+	sec.Transport = c.Catalog.Transport
+	return sec
+}
+
+// Err returns the error that occurred parsing the latest version
+// of the catalog zone, if any, in which case we keep the members
+// listed by the latest valid version of the catalog.
+func (c *CatalogConsumer) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Member returns the [*Secondary] of the given member zone or nil.
+func (c *CatalogConsumer) Member(zone string) *Secondary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state := c.members[dns.CanonicalName(zone)]; state != nil {
+		return state.sec
+	}
+	return nil
+}
+
+// Members returns the current members sorted by ID.
+func (c *CatalogConsumer) Members() []*CatalogMember {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var members []*CatalogMember
+	for _, state := range c.members {
+		members = append(members, state.member)
+	}
+	slices.SortFunc(members, func(a, b *CatalogMember) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return members
+}
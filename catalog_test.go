@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// catalogTestHeader contains the records every test catalog zone starts with.
+const catalogTestHeader = `$ORIGIN catalog.invalid.
+@ 0 IN SOA invalid. invalid. 1 3600 600 86400 0
+@ 0 IN NS invalid.
+`
+
+func TestParseCatalogZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []*CatalogMember
+		err      error
+	}{{
+		name: "members and properties",
+		data: catalogTestHeader + `version 0 IN TXT "2"
+m2.zones 0 IN PTR Example.NET.
+m1.zones 0 IN PTR example.com.
+group.m1.zones 0 IN TXT "signed"
+group.m1.zones 0 IN TXT "anycast"
+coo.m2.zones 0 IN PTR other.catalog.invalid.
+ext.m1.zones 0 IN TXT "ignored"
+other.m1.zones 0 IN PTR ignored.invalid.
+ext 0 IN TXT "ignored"
+`,
+		expected: []*CatalogMember{{
+			ID:     "m1",
+			Zone:   "example.com.",
+			Groups: []string{"anycast", "signed"},
+		}, {
+			ID:   "m2",
+			Zone: "example.net.",
+			COO:  "other.catalog.invalid.",
+		}},
+	}, {
+		name:     "no members",
+		data:     catalogTestHeader + "version 0 IN TXT \"2\"\n",
+		expected: nil,
+	}, {
+		name: "missing version",
+		data: catalogTestHeader + "m1.zones 0 IN PTR example.com.\n",
+		err:  ErrInvalidCatalogZone,
+	}, {
+		name: "unsupported version",
+		data: catalogTestHeader + "version 0 IN TXT \"1\"\n",
+		err:  ErrInvalidCatalogZone,
+	}, {
+		name: "multiple versions",
+		data: catalogTestHeader + "version 0 IN TXT \"2\"\nversion 0 IN TXT \"3\"\n",
+		err:  ErrInvalidCatalogZone,
+	}, {
+		name: "multiple PTR records",
+		data: catalogTestHeader + `version 0 IN TXT "2"
+m1.zones 0 IN PTR example.com.
+m1.zones 0 IN PTR example.net.
+`,
+		err: ErrInvalidCatalogZone,
+	}, {
+		name: "duplicate member zones",
+		data: catalogTestHeader + `version 0 IN TXT "2"
+m1.zones 0 IN PTR example.com.
+m2.zones 0 IN PTR EXAMPLE.com.
+`,
+		err: ErrInvalidCatalogZone,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, err := ParseZone(strings.NewReader(tt.data), "catalog.invalid", "")
+			assert.NoError(t, err)
+			cz, err := ParseCatalogZone(zone)
+			assert.ErrorIs(t, err, tt.err)
+			if tt.err == nil {
+				assert.Equal(t, "catalog.invalid.", cz.Origin)
+				assert.Equal(t, tt.expected, cz.Members)
+			}
+		})
+	}
+}
+
+func TestCatalogConsumer(t *testing.T) {
+	// 1. serve the catalog, whose content depends on the version
+	version := &atomic.Uint32{}
+	version.Store(1)
+	catalog, _ := startStreamServer(t, serveXfrTest(func() []string {
+		records := []string{
+			fmt.Sprintf("catalog.invalid. 0 IN SOA invalid. invalid. %d 3600 600 86400 0", version.Load()),
+			"catalog.invalid. 0 IN NS invalid.",
+			"version.catalog.invalid. 0 IN TXT \"2\"",
+			"m1.zones.catalog.invalid. 0 IN PTR example.com.",
+			"m2.zones.catalog.invalid. 0 IN PTR example.net.",
+		}
+		switch version.Load() {
+		case 2:
+			records = records[:4]
+		case 3:
+			records = append(records[:2], "version.catalog.invalid. 0 IN TXT \"1\"")
+		}
+		return records
+	}, nil))
+
+	// 2. serve example.com using another primary
+	serial := &atomic.Uint32{}
+	serial.Store(1)
+	primary, _ := startStreamServer(t, serveXfrTest(func() []string {
+		return xfrTestRecordsWithSerial(serial.Load())
+	}, nil))
+
+	// 3. consume the catalog
+	c := NewCatalogConsumer("catalog.invalid", catalog)
+	c.NewMember = func(member *CatalogMember) *Secondary {
+		return NewSecondary(member.Zone, primary)
+	}
+	changes := make(chan [2][]string, 4)
+	c.OnMembersChange = func(added, removed []*CatalogMember) {
+		var change [2][]string
+		for _, member := range added {
+			change[0] = append(change[0], member.Zone)
+		}
+		for _, member := range removed {
+			change[1] = append(change[1], member.Zone)
+		}
+		changes <- change
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+
+	// 4. make sure we provision the members
+	change := <-changes
+	assert.ElementsMatch(t, []string{"example.com.", "example.net."}, change[0])
+	assert.Empty(t, change[1])
+	assert.Len(t, c.Members(), 2)
+	assert.NotNil(t, c.Member("EXAMPLE.com"))
+	assert.Nil(t, c.Member("example.org"))
+	assert.Eventually(t, func() bool { return c.Member("example.com").Zone() != nil }, time.Second, time.Millisecond)
+
+	// 5. make sure we deprovision the removed members
+	version.Store(2)
+	query := &dns.Msg{}
+	query.SetNotify("catalog.invalid.")
+	c.Catalog.HandleNotify(query)
+	assert.Equal(t, [2][]string{nil, {"example.net."}}, <-changes)
+	assert.Nil(t, c.Member("example.net"))
+	assert.NoError(t, c.Err())
+
+	// 6. make sure we keep the members when the catalog is invalid
+	version.Store(3)
+	c.Catalog.HandleNotify(query)
+	assert.Eventually(t, func() bool { return c.Err() != nil }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, c.Err(), ErrInvalidCatalogZone)
+	assert.Len(t, c.Members(), 1)
+
+	cancel()
+	<-done
+	assert.Empty(t, c.Members())
+}
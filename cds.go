@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrDelegationMismatch indicates that the DS records of the parent zone
+// do not match the DNSKEY records of the child zone.
+var ErrDelegationMismatch = errors.New("DS does not match any DNSKEY")
+
+// NewDS returns the DS record for the given DNSKEY using the given digest
+// type, which is usually [dns.SHA256], such that the parent zone can delegate
+// to the zone signed using the key.
+func NewDS(dnskey *dns.DNSKEY, digestType uint8) (*dns.DS, error) {
+	ds := dnskey.ToDS(digestType)
+	if ds == nil {
+		return nil, fmt.Errorf("%w: cannot compute DS using digest type %d", ErrUnsupportedAlgorithm, digestType)
+	}
+	return ds, nil
+}
+
+// NewCDSRRset returns the CDS RRset (RFC 7344) the child zone publishes to
+// ask the parent zone to delegate to the given keys, using the given digest
+// type. We only include the key signing keys, i.e., the keys with the SEP
+// flag, unless none of the keys has it, in which case we include all of them.
+func NewCDSRRset(dnskeys []*dns.DNSKEY, digestType uint8) ([]dns.RR, error) {
+	var rrset []dns.RR
+	for _, dnskey := range cdsDelegationKeys(dnskeys) {
+		ds, err := NewDS(dnskey, digestType)
+		if err != nil {
+			return nil, err
+		}
+		ds.Hdr.Rrtype = dns.TypeCDS
+		rrset = append(rrset, &dns.CDS{DS: *ds})
+	}
+	return rrset, nil
+}
+
+// NewCDNSKEYRRset is like [NewCDSRRset] but returns the CDNSKEY RRset.
+func NewCDNSKEYRRset(dnskeys []*dns.DNSKEY) []dns.RR {
+	var rrset []dns.RR
+	for _, dnskey := range cdsDelegationKeys(dnskeys) {
+		cdnskey := &dns.CDNSKEY{DNSKEY: *dnskey}
+		cdnskey.Hdr.Rrtype = dns.TypeCDNSKEY
+		rrset = append(rrset, cdnskey)
+	}
+	return rrset
+}
+
+// NewDeleteCDSRRset returns the CDS and CDNSKEY RRsets the given child zone
+// publishes to ask the parent zone to remove the DS RRset (RFC 8078 Sect. 4),
+// thus turning the delegation insecure.
+func NewDeleteCDSRRset(zone string, ttl uint32) []dns.RR {
+	hdr := dns.RR_Header{Name: dns.CanonicalName(zone), Class: dns.ClassINET, Ttl: ttl}
+	cds := &dns.CDS{DS: dns.DS{Hdr: hdr, Digest: "00"}}
+	cds.Hdr.Rrtype = dns.TypeCDS
+	cdnskey := &dns.CDNSKEY{DNSKEY: dns.DNSKEY{Hdr: hdr, Protocol: 3, PublicKey: "AA=="}}
+	cdnskey.Hdr.Rrtype = dns.TypeCDNSKEY
+	return []dns.RR{cds, cdnskey}
+}
+
+// CDSToDS converts the given CDS RRset published by the child zone to the
+// DS RRset the parent zone should publish, using the given TTL. It returns
+// an empty RRset when the child asks to remove the DS RRset.
+func CDSToDS(cdss []*dns.CDS, ttl uint32) []*dns.DS {
+	var dss []*dns.DS
+	for _, cds := range cdss {
+		if cds.Algorithm == 0 {
+			continue // RFC 8078 Sect. 4
+		}
+		ds := cds.DS
+		ds.Hdr.Rrtype = dns.TypeDS
+		ds.Hdr.Ttl = ttl
+		dss = append(dss, &ds)
+	}
+	return dss
+}
+
+// VerifyDS verifies that the given DS RRset, which is either the DS RRset of
+// the parent zone or the DS RRset obtained from the CDS RRset of the child
+// zone using [CDSToDS], is consistent with the given DNSKEY RRset of the child
+// zone, i.e., that each DS matches a non-revoked zone key. The returned error
+// wraps [ErrDelegationMismatch] when the RRsets are not consistent.
+func VerifyDS(dss []*dns.DS, dnskeys []*dns.DNSKEY) error {
+	if len(dss) <= 0 {
+		return fmt.Errorf("%w: empty DS RRset", ErrDelegationMismatch)
+	}
+	for _, ds := range dss {
+		if !cdsMatchesAnyKey(ds, dnskeys) {
+			return fmt.Errorf("%w: %s", ErrDelegationMismatch, ds.String())
+		}
+	}
+	return nil
+}
+
+// cdsMatchesAnyKey returns whether the given DS matches any of the given keys.
+func cdsMatchesAnyKey(ds *dns.DS, dnskeys []*dns.DNSKEY) bool {
+	for _, dnskey := range dnskeys {
+		if dnskey.Flags&dns.ZONE == 0 || dnskey.Flags&dns.REVOKE != 0 {
+			continue
+		}
+		if dns.CanonicalName(ds.Hdr.Name) != dns.CanonicalName(dnskey.Hdr.Name) {
+			continue
+		}
+		expect := dnskey.ToDS(ds.DigestType)
+		if expect != nil && expect.KeyTag == ds.KeyTag && expect.Algorithm == ds.Algorithm &&
+			strings.EqualFold(expect.Digest, ds.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// cdsDelegationKeys returns the keys the parent zone should delegate to.
+func cdsDelegationKeys(dnskeys []*dns.DNSKEY) []*dns.DNSKEY {
+	var ksks []*dns.DNSKEY
+	for _, dnskey := range dnskeys {
+		if dnskey.Flags&dns.SEP != 0 {
+			ksks = append(ksks, dnskey)
+		}
+	}
+	if len(ksks) <= 0 {
+		return dnskeys
+	}
+	return ksks
+}
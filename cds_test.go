@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCDSRRset(t *testing.T) {
+	ksk := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)).DNSKEY
+	zsk := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE)).DNSKEY
+
+	t.Run("with key signing keys", func(t *testing.T) {
+		rrset, err := NewCDSRRset([]*dns.DNSKEY{ksk, zsk}, dns.SHA256)
+		assert.NoError(t, err)
+		if assert.Len(t, rrset, 1) {
+			cds := rrset[0].(*dns.CDS)
+			assert.Equal(t, uint16(dns.TypeCDS), cds.Hdr.Rrtype)
+			assert.Equal(t, ksk.KeyTag(), cds.KeyTag)
+		}
+		cdnskeys := NewCDNSKEYRRset([]*dns.DNSKEY{ksk, zsk})
+		if assert.Len(t, cdnskeys, 1) {
+			cdnskey := cdnskeys[0].(*dns.CDNSKEY)
+			assert.Equal(t, uint16(dns.TypeCDNSKEY), cdnskey.Hdr.Rrtype)
+			assert.Equal(t, ksk.PublicKey, cdnskey.PublicKey)
+		}
+		assert.Equal(t, uint16(dns.TypeDNSKEY), ksk.Hdr.Rrtype)
+	})
+
+	t.Run("without key signing keys", func(t *testing.T) {
+		rrset, err := NewCDSRRset([]*dns.DNSKEY{zsk}, dns.SHA384)
+		assert.NoError(t, err)
+		if assert.Len(t, rrset, 1) {
+			assert.Equal(t, zsk.KeyTag(), rrset[0].(*dns.CDS).KeyTag)
+			assert.Len(t, rrset[0].(*dns.CDS).Digest, 96)
+		}
+		assert.Len(t, NewCDNSKEYRRset([]*dns.DNSKEY{zsk}), 1)
+	})
+
+	t.Run("unsupported digest type", func(t *testing.T) {
+		_, err := NewCDSRRset([]*dns.DNSKEY{ksk}, 42)
+		assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	})
+}
+
+func TestNewDeleteCDSRRset(t *testing.T) {
+	var got []string
+	for _, rr := range NewDeleteCDSRRset("Example.COM", 300) {
+		got = append(got, rr.String())
+	}
+	assert.Equal(t, []string{
+		"example.com.\t300\tIN\tCDS\t0 0 0 00",
+		"example.com.\t300\tIN\tCDNSKEY\t0 3 0 AA==",
+	}, got)
+}
+
+func TestCDSToDS(t *testing.T) {
+	ksk := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)).DNSKEY
+	rrset, err := NewCDSRRset([]*dns.DNSKEY{ksk}, dns.SHA256)
+	assert.NoError(t, err)
+	deletion := NewDeleteCDSRRset("example.com", 300)[0].(*dns.CDS)
+
+	dss := CDSToDS([]*dns.CDS{rrset[0].(*dns.CDS), deletion}, 86400)
+	if assert.Len(t, dss, 1) {
+		assert.Equal(t, uint16(dns.TypeDS), dss[0].Hdr.Rrtype)
+		assert.Equal(t, uint32(86400), dss[0].Hdr.Ttl)
+		assert.Equal(t, uint16(dns.TypeCDS), rrset[0].Header().Rrtype)
+	}
+	assert.Empty(t, CDSToDS([]*dns.CDS{deletion}, 86400))
+}
+
+func TestVerifyDS(t *testing.T) {
+	ksk := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)).DNSKEY
+	zsk := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE)).DNSKEY
+	other := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)).DNSKEY
+	revoked := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP|dns.REVOKE)).DNSKEY
+
+	// newDS returns the DS for the given key.
+	newDS := func(dnskey *dns.DNSKEY) *dns.DS {
+		ds, err := NewDS(dnskey, dns.SHA256)
+		assert.NoError(t, err)
+		return ds
+	}
+
+	// create DS records for the KSK whose owner or digest does not match
+	renamed := newDS(ksk)
+	renamed.Hdr.Name = "example.net."
+	corrupted := newDS(ksk)
+	corrupted.Digest = newDS(zsk).Digest
+
+	tests := []struct {
+		name    string
+		dss     []*dns.DS
+		dnskeys []*dns.DNSKEY
+		err     error
+	}{{
+		name:    "matching DS",
+		dss:     []*dns.DS{newDS(ksk)},
+		dnskeys: []*dns.DNSKEY{ksk, zsk},
+	}, {
+		name:    "matching DS for each key during a rollover",
+		dss:     []*dns.DS{newDS(ksk), newDS(other)},
+		dnskeys: []*dns.DNSKEY{ksk, zsk, other},
+	}, {
+		name:    "DS for a key missing from the child",
+		dss:     []*dns.DS{newDS(ksk), newDS(other)},
+		dnskeys: []*dns.DNSKEY{ksk, zsk},
+		err:     ErrDelegationMismatch,
+	}, {
+		name:    "DS for a revoked key",
+		dss:     []*dns.DS{newDS(revoked)},
+		dnskeys: []*dns.DNSKEY{revoked},
+		err:     ErrDelegationMismatch,
+	}, {
+		name:    "DS with another owner",
+		dss:     []*dns.DS{renamed},
+		dnskeys: []*dns.DNSKEY{ksk},
+		err:     ErrDelegationMismatch,
+	}, {
+		name:    "DS with another digest",
+		dss:     []*dns.DS{corrupted},
+		dnskeys: []*dns.DNSKEY{ksk},
+		err:     ErrDelegationMismatch,
+	}, {
+		name:    "empty DS RRset",
+		dnskeys: []*dns.DNSKEY{ksk},
+		err:     ErrDelegationMismatch,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyDS(tt.dss, tt.dnskeys)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Names of the CHAOS-class TXT records identifying a server.
+const (
+	// ChaosVersionBind is the name of the record containing the
+	// software version, which BIND introduced.
+	ChaosVersionBind = "version.bind."
+
+	// ChaosHostnameBind is the name of the record containing the
+	// host name of the server, which BIND introduced.
+	ChaosHostnameBind = "hostname.bind."
+
+	// ChaosIDServer is the name of the record containing the
+	// server identifier, as defined by RFC 4892.
+	ChaosIDServer = "id.server."
+
+	// ChaosVersionServer is the name of the record containing the
+	// software version, as defined by RFC 4892.
+	ChaosVersionServer = "version.server."
+)
+
+// ServerIdentity contains the identifiers of a server obtained using
+// CHAOS-class TXT queries. Identifiers the server does not provide are
+// empty. With anycast services, the identifiers tell which instance
+// answered, which is useful to debug issues affecting a single instance.
+type ServerIdentity struct {
+	// VersionBind is the content of [ChaosVersionBind].
+	VersionBind string
+
+	// HostnameBind is the content of [ChaosHostnameBind].
+	HostnameBind string
+
+	// IDServer is the content of [ChaosIDServer].
+	IDServer string
+
+	// VersionServer is the content of [ChaosVersionServer].
+	VersionServer string
+}
+
+// QueryOptionClass sets the class of the question, e.g., [dns.ClassCHAOS].
+func QueryOptionClass(class uint16) QueryOption {
+	return func(q *dns.Msg) error {
+		for idx := range q.Question {
+			q.Question[idx].Qclass = class
+		}
+		return nil
+	}
+}
+
+// QueryChaosTXT sends a CHAOS-class TXT query for the given name, such as
+// [ChaosIDServer], to the given server using the given transport, and
+// returns the content of the TXT records, joining multiple strings and
+// multiple records using a space. Since only the server itself should
+// answer, we send the query with the RD bit cleared.
+func QueryChaosTXT(ctx context.Context, txp ResolverTransport, addr *ServerAddr, name string) (string, error) {
+	// 1. create the query
+	query, err := NewQueryWithServerAddr(addr, name, dns.TypeTXT, QueryOptionClass(dns.ClassCHAOS))
+	if err != nil {
+		return "", err
+	}
+	query.RecursionDesired = false
+
+	// 2. send the query and validate the response
+	resp, err := txp.Query(ctx, addr, query)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateResponse(query, resp); err != nil {
+		return "", err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return "", RCodeToError(resp)
+	}
+
+	// 3. extract the content of the TXT records
+	answers, err := ValidAnswers(query.Question[0], resp)
+	if err != nil {
+		return "", err
+	}
+	var values []string
+	for _, answer := range answers {
+		if txt, ok := answer.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, " "))
+		}
+	}
+	if len(values) <= 0 {
+		return "", ErrNoData
+	}
+	return strings.Join(values, " "), nil
+}
+
+// IdentifyServer queries all the CHAOS-class TXT records identifying the
+// given server using [QueryChaosTXT] and returns the identifiers. We only
+// return an error, joining the errors of each query, when all queries fail.
+func IdentifyServer(ctx context.Context, txp ResolverTransport, addr *ServerAddr) (*ServerIdentity, error) {
+	identity := &ServerIdentity{}
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{ChaosVersionBind, &identity.VersionBind},
+		{ChaosHostnameBind, &identity.HostnameBind},
+		{ChaosIDServer, &identity.IDServer},
+		{ChaosVersionServer, &identity.VersionServer},
+	}
+	var errs []error
+	for _, field := range fields {
+		value, err := QueryChaosTXT(ctx, txp, addr, field.name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		*field.value = value
+	}
+	if len(errs) == len(fields) {
+		return nil, errors.Join(errs...)
+	}
+	return identity, nil
+}
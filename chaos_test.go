@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryChaosTXT(t *testing.T) {
+	records := map[string][]string{
+		ChaosIDServer:      {"ams01"},
+		ChaosVersionServer: {"1.2.3", "extra"},
+		ChaosVersionBind:   {},
+	}
+	txp := &MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			q0 := query.Question[0]
+			assert.Equal(t, uint16(dns.ClassCHAOS), q0.Qclass)
+			assert.Equal(t, uint16(dns.TypeTXT), q0.Qtype)
+			assert.False(t, query.RecursionDesired)
+			resp := new(dns.Msg)
+			values, found := records[q0.Name]
+			if !found {
+				return resp.SetRcode(query, dns.RcodeRefused), nil
+			}
+			resp.SetReply(query)
+			resp.Authoritative = true
+			for _, value := range values {
+				resp.Answer = append(resp.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: q0.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+					Txt: []string{value},
+				})
+			}
+			return resp, nil
+		},
+	}
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+
+	tests := []struct {
+		name     string
+		qname    string
+		expected string
+		err      error
+	}{{
+		name:     "single record",
+		qname:    ChaosIDServer,
+		expected: "ams01",
+	}, {
+		name:     "multiple records",
+		qname:    ChaosVersionServer,
+		expected: "1.2.3 extra",
+	}, {
+		name:  "no records",
+		qname: ChaosVersionBind,
+		err:   ErrNoData,
+	}, {
+		name:  "refused",
+		qname: ChaosHostnameBind,
+		err:   ErrServerMisbehaving,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := QueryChaosTXT(context.Background(), txp, addr, tt.qname)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+
+	t.Run("invalid response", func(t *testing.T) {
+		txp := &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				resp := new(dns.Msg)
+				resp.SetReply(query)
+				resp.Question[0].Qclass = dns.ClassINET
+				return resp, nil
+			},
+		}
+		_, err := QueryChaosTXT(context.Background(), txp, addr, ChaosIDServer)
+		assert.ErrorIs(t, err, ErrInvalidResponse)
+	})
+}
+
+func TestIdentifyServer(t *testing.T) {
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+
+	t.Run("partial identity", func(t *testing.T) {
+		txp := answerFromRecords(t,
+			`hostname.bind. 0 CH TXT "resolver-7"`,
+			`id.server. 0 CH TXT "ams01"`,
+		)
+		identity, err := IdentifyServer(context.Background(), txp, addr)
+		assert.NoError(t, err)
+		assert.Equal(t, &ServerIdentity{HostnameBind: "resolver-7", IDServer: "ams01"}, identity)
+	})
+
+	t.Run("all queries failing", func(t *testing.T) {
+		expectedErr := errors.New("mocked error")
+		txp := &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				return nil, expectedErr
+			},
+		}
+		identity, err := IdentifyServer(context.Background(), txp, addr)
+		assert.ErrorIs(t, err, expectedErr)
+		assert.Nil(t, identity)
+	})
+}
@@ -0,0 +1,371 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// Command dnsq is a dig-like DNS lookup tool built on top of dnscore.
+//
+// Usage:
+//
+//	dnsq [@server] [name] [type] [+option ...]
+//
+// The server defaults to 8.8.8.8, the name to the root zone, and
+// the type to A (or NS when the name is missing). The server may
+// be an IP address, a host name, an endpoint including the port,
+// or, for HTTP-based protocols, a URL.
+//
+// Protocol options:
+//
+//	+udp          use DNS over UDP (default)
+//	+tcp          use DNS over TCP
+//	+tls          use DNS over TLS
+//	+https        use DNS over HTTPS
+//	+https-json   use the DNS over HTTPS JSON API
+//	+http, +h2c   use DNS over cleartext HTTP/1.1 or HTTP/2
+//	+quic         use DNS over QUIC (not supported)
+//
+// Query options:
+//
+//	+bufsize=N    set the EDNS(0) maximum response size
+//	+dnssec       set the DNSSEC OK bit
+//	+expire       request the zone expire timer (RFC 7314)
+//	+padding      pad the query using EDNS(0) block-length padding
+//	+norecurse    clear the recursion desired bit
+//	+timeout=D    set the query timeout (e.g., 5s, default 5s)
+//	+trace        resolve iteratively from the root servers and print each step
+//
+// Output options:
+//
+//	+json         print the response using RFC 8427 JSON
+//	+short        only print the answer data
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/dnscore"
+)
+
+// defaultServer is the server we use when none is specified.
+const defaultServer = "8.8.8.8"
+
+// defaultTimeout is the default query timeout.
+const defaultTimeout = 5 * time.Second
+
+// errQUICNotSupported indicates that DNS over QUIC is not supported.
+var errQUICNotSupported = errors.New("DNS over QUIC is not supported")
+
+// options contains the parsed command line options.
+type options struct {
+	bufsize   uint16
+	dnssec    bool
+	expire    bool
+	json      bool
+	name      string
+	norecurse bool
+	padding   bool
+	protocol  dnscore.Protocol
+	qtype     uint16
+	server    string
+	short     bool
+	timeout   time.Duration
+	trace     bool
+}
+
+// parseArgs parses the dig-like command line arguments.
+func parseArgs(args []string) (*options, error) {
+	opts := &options{
+		protocol: dnscore.ProtocolUDP,
+		timeout:  defaultTimeout,
+	}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@"):
+			opts.server = arg[1:]
+
+		case strings.HasPrefix(arg, "+"):
+			if err := opts.parsePlusOption(arg[1:]); err != nil {
+				return nil, err
+			}
+
+		case opts.qtype == 0 && dns.StringToType[strings.ToUpper(arg)] != 0:
+			opts.qtype = dns.StringToType[strings.ToUpper(arg)]
+
+		case opts.name == "":
+			opts.name = arg
+
+		default:
+			return nil, fmt.Errorf("unexpected argument: %s", arg)
+		}
+	}
+	if opts.name == "" {
+		opts.name = "."
+		if opts.qtype == 0 {
+			opts.qtype = dns.TypeNS
+		}
+	}
+	if opts.qtype == 0 {
+		opts.qtype = dns.TypeA
+	}
+	if opts.server == "" {
+		opts.server = defaultServer
+	}
+	return opts, nil
+}
+
+// parsePlusOption parses an option starting with a plus sign.
+func (opts *options) parsePlusOption(option string) error {
+	key, value, _ := strings.Cut(option, "=")
+	switch key {
+	case "udp", "notcp":
+		opts.protocol = dnscore.ProtocolUDP
+	case "tcp", "vc":
+		opts.protocol = dnscore.ProtocolTCP
+	case "tls":
+		opts.protocol = dnscore.ProtocolDoT
+	case "https":
+		opts.protocol = dnscore.ProtocolDoH
+	case "https-json":
+		opts.protocol = dnscore.ProtocolDoHJSON
+	case "http":
+		opts.protocol = dnscore.ProtocolHTTP
+	case "h2c":
+		opts.protocol = dnscore.ProtocolH2C
+	case "quic":
+		return errQUICNotSupported
+	case "bufsize":
+		size, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid +bufsize: %s", value)
+		}
+		opts.bufsize = uint16(size)
+	case "dnssec":
+		opts.dnssec = true
+	case "expire":
+		opts.expire = true
+	case "padding":
+		opts.padding = true
+	case "norecurse", "norec":
+		opts.norecurse = true
+	case "timeout":
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid +timeout: %s", value)
+			}
+			timeout = time.Duration(seconds) * time.Second
+		}
+		opts.timeout = timeout
+	case "trace":
+		opts.trace = true
+	case "json":
+		opts.json = true
+	case "short":
+		opts.short = true
+	default:
+		return fmt.Errorf("unknown option: +%s", option)
+	}
+	return nil
+}
+
+// serverAddr returns the server address for the selected protocol.
+func (opts *options) serverAddr() *dnscore.ServerAddr {
+	server := opts.server
+	switch opts.protocol {
+	case dnscore.ProtocolDoH, dnscore.ProtocolDoHJSON, dnscore.ProtocolHTTP, dnscore.ProtocolH2C:
+		if !strings.Contains(server, "://") {
+			scheme, path := "https", "/dns-query"
+			if opts.protocol == dnscore.ProtocolHTTP || opts.protocol == dnscore.ProtocolH2C {
+				scheme = "http"
+			}
+			if opts.protocol == dnscore.ProtocolDoHJSON {
+				path = "/resolve"
+			}
+			if strings.Contains(server, ":") && !strings.Contains(server, "]") &&
+				net.ParseIP(server) != nil {
+				server = "[" + server + "]" // bare IPv6 address
+			}
+			server = scheme + "://" + server + path
+		}
+	default:
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			port := "53"
+			if opts.protocol == dnscore.ProtocolDoT {
+				port = "853"
+			}
+			server = net.JoinHostPort(strings.Trim(server, "[]"), port)
+		}
+	}
+	return dnscore.NewServerAddr(opts.protocol, server)
+}
+
+// queryOptions returns the options to construct the query.
+func (opts *options) queryOptions() []dnscore.QueryOption {
+	var flags int
+	if opts.dnssec {
+		flags |= dnscore.EDNS0FlagDO
+	}
+	if opts.expire {
+		flags |= dnscore.EDNS0FlagExpire
+	}
+	if opts.padding {
+		flags |= dnscore.EDNS0FlagBlockLengthPadding
+	}
+	var options []dnscore.QueryOption
+	if flags != 0 || opts.bufsize > 0 {
+		bufsize := opts.bufsize
+		if bufsize <= 0 {
+			bufsize = dnscore.EDNS0SuggestedMaxResponseSizeUDP
+			if opts.protocol != dnscore.ProtocolUDP {
+				bufsize = dnscore.EDNS0SuggestedMaxResponseSizeOtherwise
+			}
+		}
+		options = append(options, dnscore.QueryOptionEDNS0(bufsize, flags))
+	}
+	if opts.norecurse {
+		options = append(options, func(query *dns.Msg) error {
+			query.RecursionDesired = false
+			return nil
+		})
+	}
+	return options
+}
+
+// result is the result of a lookup.
+type result struct {
+	info     *dnscore.QueryInfo
+	query    *dns.Msg
+	response *dns.Msg
+	server   *dnscore.ServerAddr
+	trace    *dnscore.Trace
+	elapsed  time.Duration
+}
+
+// lookup performs the lookup described by the options.
+func lookup(ctx context.Context, opts *options) (*result, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	txp := &dnscore.Transport{Insecure: true}
+	defer txp.Close()
+
+	if opts.trace {
+		reso := &dnscore.IterativeResolver{Transport: txp}
+		trace, err := reso.Trace(ctx, opts.name, opts.qtype)
+		if err != nil && !opts.json {
+			return nil, fmt.Errorf("%w\n%s", err, trace.String())
+		}
+		return &result{response: trace.Response, trace: trace, elapsed: trace.Duration}, nil
+	}
+
+	server := opts.serverAddr()
+	query, err := dnscore.NewQueryWithServerAddr(server, opts.name, opts.qtype, opts.queryOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	t0 := time.Now()
+	resp, info, err := txp.QueryWithInfo(ctx, server, query)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(t0)
+	if err := dnscore.ValidateResponse(query, resp); err != nil {
+		return nil, err
+	}
+	return &result{info: info, query: query, response: resp, server: server, elapsed: elapsed}, nil
+}
+
+// printShort prints the data of the answer RRs.
+func printShort(w io.Writer, res *result) {
+	for _, rr := range res.response.Answer {
+		data := strings.TrimPrefix(rr.String(), rr.Header().String())
+		fmt.Fprintln(w, data)
+	}
+}
+
+// printJSON prints the query, the response, and the timing as JSON.
+func printJSON(w io.Writer, res *result) error {
+	output := map[string]any{
+		"response":      dnscore.MsgJSON{Msg: res.response},
+		"queryTimeMsec": res.elapsed.Milliseconds(),
+	}
+	if res.query != nil {
+		output["query"] = dnscore.MsgJSON{Msg: res.query}
+	}
+	if res.server != nil {
+		output["serverAddr"] = res.server.Address
+		output["serverProtocol"] = res.server.Protocol
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// printText prints the response along with timing information like dig does.
+func printText(w io.Writer, res *result) {
+	fmt.Fprintf(w, "%s\n", res.response.String())
+	if dnscore.IsRFC8482Answer(res.response.Answer) {
+		fmt.Fprintf(w, ";; NOTE: the server declined to answer ANY (RFC 8482)\n")
+	}
+	fmt.Fprintf(w, ";; Query time: %d msec\n", res.elapsed.Milliseconds())
+	if res.server != nil {
+		fmt.Fprintf(w, ";; SERVER: %s (%s)\n", res.server.Address, res.server.Protocol)
+	} else {
+		fmt.Fprintf(w, ";; SERVER: iterative resolution from the root servers\n")
+	}
+	if info := res.info; info != nil {
+		if !info.ConnectDone.IsZero() && !info.ConnectStart.IsZero() {
+			fmt.Fprintf(w, ";; Connect time: %d msec\n", info.ConnectDone.Sub(info.ConnectStart).Milliseconds())
+		}
+		if !info.TLSHandshakeDone.IsZero() && !info.TLSHandshakeStart.IsZero() {
+			fmt.Fprintf(w, ";; TLS handshake time: %d msec\n",
+				info.TLSHandshakeDone.Sub(info.TLSHandshakeStart).Milliseconds())
+		}
+		fmt.Fprintf(w, ";; MSG SIZE  rcvd: %d\n", len(info.RawResponse))
+	}
+	fmt.Fprintf(w, ";; WHEN: %s\n", time.Now().Format(time.RFC1123Z))
+}
+
+// run parses the arguments, performs the lookup, and prints the result.
+func run(ctx context.Context, args []string, w io.Writer) error {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+	res, err := lookup(ctx, opts)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.trace != nil && opts.json:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(res.trace); err != nil {
+			return err
+		}
+		return res.trace.Err
+	case res.trace != nil && !opts.short:
+		fmt.Fprint(w, res.trace.String())
+	case opts.json:
+		return printJSON(w, res)
+	case opts.short:
+		printShort(w, res)
+	default:
+		printText(w, res)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "dnsq: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
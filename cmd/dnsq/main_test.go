@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/dnscore"
+	"github.com/rbmk-project/dnscore/dnscoretest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		expect    *options
+		expectErr string
+	}{{
+		name: "defaults",
+		args: nil,
+		expect: &options{name: ".", qtype: dns.TypeNS, protocol: dnscore.ProtocolUDP,
+			server: defaultServer, timeout: defaultTimeout},
+	}, {
+		name: "name, type, and server",
+		args: []string{"@1.1.1.1", "example.com", "aaaa"},
+		expect: &options{name: "example.com", qtype: dns.TypeAAAA, protocol: dnscore.ProtocolUDP,
+			server: "1.1.1.1", timeout: defaultTimeout},
+	}, {
+		name: "type before name",
+		args: []string{"MX", "example.com"},
+		expect: &options{name: "example.com", qtype: dns.TypeMX, protocol: dnscore.ProtocolUDP,
+			server: defaultServer, timeout: defaultTimeout},
+	}, {
+		name: "plus options",
+		args: []string{"example.com", "+tls", "+dnssec", "+expire", "+padding", "+bufsize=1400",
+			"+norecurse", "+timeout=2", "+json", "+short", "+trace"},
+		expect: &options{name: "example.com", qtype: dns.TypeA, protocol: dnscore.ProtocolDoT,
+			server: defaultServer, timeout: 2 * time.Second, dnssec: true, expire: true,
+			padding: true, bufsize: 1400, norecurse: true, json: true, short: true, trace: true},
+	}, {
+		name:      "QUIC",
+		args:      []string{"+quic"},
+		expectErr: "DNS over QUIC is not supported",
+	}, {
+		name:      "unknown option",
+		args:      []string{"+nope"},
+		expectErr: "unknown option: +nope",
+	}, {
+		name:      "invalid bufsize",
+		args:      []string{"+bufsize=100000"},
+		expectErr: "invalid +bufsize: 100000",
+	}, {
+		name:      "invalid timeout",
+		args:      []string{"+timeout=soon"},
+		expectErr: "invalid +timeout: soon",
+	}, {
+		name:      "too many arguments",
+		args:      []string{"example.com", "A", "example.org"},
+		expectErr: "unexpected argument: example.org",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseArgs(tt.args)
+			if tt.expectErr != "" {
+				assert.EqualError(t, err, tt.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, opts)
+		})
+	}
+}
+
+func Test_options_serverAddr(t *testing.T) {
+	tests := []struct {
+		server   string
+		protocol dnscore.Protocol
+		expect   string
+	}{
+		{"8.8.8.8", dnscore.ProtocolUDP, "8.8.8.8:53"},
+		{"8.8.8.8:5353", dnscore.ProtocolTCP, "8.8.8.8:5353"},
+		{"2001:db8::1", dnscore.ProtocolUDP, "[2001:db8::1]:53"},
+		{"dns.google", dnscore.ProtocolDoT, "dns.google:853"},
+		{"dns.google", dnscore.ProtocolDoH, "https://dns.google/dns-query"},
+		{"2001:db8::1", dnscore.ProtocolDoH, "https://[2001:db8::1]/dns-query"},
+		{"dns.google", dnscore.ProtocolDoHJSON, "https://dns.google/resolve"},
+		{"127.0.0.1:8080", dnscore.ProtocolH2C, "http://127.0.0.1:8080/dns-query"},
+		{"https://example.com/q", dnscore.ProtocolDoH, "https://example.com/q"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expect, func(t *testing.T) {
+			opts := &options{server: tt.server, protocol: tt.protocol}
+			addr := opts.serverAddr()
+			assert.Equal(t, tt.protocol, addr.Protocol)
+			assert.Equal(t, tt.expect, addr.Address)
+		})
+	}
+}
+
+func Test_options_queryOptions(t *testing.T) {
+	opts := &options{dnssec: true, expire: true, norecurse: true, protocol: dnscore.ProtocolTCP}
+	query, err := dnscore.NewQuery("example.com", dns.TypeA, opts.queryOptions()...)
+	assert.NoError(t, err)
+	assert.False(t, query.RecursionDesired)
+	assert.True(t, query.IsEdns0().Do())
+	assert.Len(t, query.IsEdns0().Option, 1)
+	assert.Equal(t, uint16(dnscore.EDNS0SuggestedMaxResponseSizeOtherwise), query.IsEdns0().UDPSize())
+
+	opts = &options{}
+	assert.Empty(t, opts.queryOptions())
+}
+
+func Test_run(t *testing.T) {
+	server := &dnscoretest.Server{}
+	<-server.StartUDP(dnscoretest.NewExampleComHandler())
+	defer server.Close()
+
+	t.Run("text output", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"@" + server.Addr, "example.com", "+dnssec"}, &out)
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "example.com.\t3600\tIN\tA\t93.184.215.14")
+		assert.Contains(t, out.String(), ";; SERVER: "+server.Addr+" (udp)")
+		assert.Contains(t, out.String(), ";; Query time: ")
+		assert.Contains(t, out.String(), ";; MSG SIZE  rcvd: ")
+	})
+
+	t.Run("short output", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"@" + server.Addr, "example.com", "+short"}, &out)
+		assert.NoError(t, err)
+		assert.Equal(t, "93.184.215.14\n", out.String())
+	})
+
+	t.Run("JSON output", func(t *testing.T) {
+		var out bytes.Buffer
+		err := run(context.Background(), []string{"@" + server.Addr, "example.com", "+json"}, &out)
+		assert.NoError(t, err)
+		var output struct {
+			Response       dnscore.MsgJSON
+			ServerProtocol string
+		}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &output))
+		assert.Equal(t, "udp", output.ServerProtocol)
+		assert.Len(t, output.Response.Msg.Answer, 1)
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		err := run(context.Background(), []string{"+quic"}, &bytes.Buffer{})
+		assert.ErrorIs(t, err, errQUICNotSupported)
+	})
+
+	t.Run("query failure", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := run(ctx, []string{"@" + server.Addr, "example.com"}, &bytes.Buffer{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,331 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// Package config builds a fully wired [*dnscore.Resolver] from a YAML
+// configuration document, such that dnscore can back a standalone daemon.
+//
+// A configuration document looks like the following:
+//
+//	upstreams:
+//	  - protocol: doh
+//	    address: https://dns.google/dns-query
+//	    timeout: 3s
+//	  - protocol: udp
+//	    address: 8.8.8.8:53
+//	selection: fastest
+//	attempts: 2
+//	cache:
+//	  maxEntries: 8192
+//	  prefetchWindow: 10s
+//	blocklists:
+//	  - path: blocklist.rpz
+//	    origin: rpz.example
+//	transport:
+//	  reuseConns: true
+//
+// The package does not configure listeners, since dnscore does not
+// include a DNS server, and we reject documents containing unknown fields.
+// To update a running resolver after changing the document, use [Reload].
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rbmk-project/dnscore"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidConfig indicates that a configuration document is invalid.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config is the configuration of a [*dnscore.Resolver].
+//
+// Construct using [Parse] or [Load].
+type Config struct {
+	// Upstreams contains the upstream servers, which the resolver tries in
+	// order, unless Selection is "fastest". If empty, the resolver uses the
+	// default servers of [*dnscore.ResolverConfig].
+	Upstreams []*Upstream `yaml:"upstreams"`
+
+	// Selection is the server selection policy, which is either "ordered"
+	// or "fastest". If empty, we use "ordered".
+	Selection string `yaml:"selection"`
+
+	// Attempts is the number of attempts for each query.
+	// If zero, we use [dnscore.DefaultAttempts].
+	Attempts int `yaml:"attempts"`
+
+	// Cache is the optional cache configuration. If nil, we do not cache.
+	Cache *Cache `yaml:"cache"`
+
+	// Blocklists contains the optional response policy zones used to
+	// filter the resolutions, which we apply in order.
+	Blocklists []*Blocklist `yaml:"blocklists"`
+
+	// Transport is the transport configuration.
+	Transport Transport `yaml:"transport"`
+}
+
+// Upstream is the configuration of an upstream server.
+type Upstream struct {
+	// Protocol is the protocol, which is "udp", "tcp", "dot", "doh",
+	// or "doh+json". If empty, we use "udp".
+	Protocol dnscore.Protocol `yaml:"protocol"`
+
+	// Address is the server address or URL, as documented by [dnscore.ServerAddr].
+	Address string `yaml:"address"`
+
+	// Timeout is the timeout of each query.
+	// If zero, we use [dnscore.DefaultQueryTimeout].
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Cache is the configuration of the [*dnscore.Cache].
+type Cache struct {
+	// MaxEntries is the maximum number of entries.
+	// If zero, we use [dnscore.DefaultCacheMaxEntries].
+	MaxEntries int `yaml:"maxEntries"`
+
+	// PrefetchWindow is the prefetch window.
+	// If zero, we do not prefetch entries.
+	PrefetchWindow time.Duration `yaml:"prefetchWindow"`
+}
+
+// Blocklist is the configuration of a response policy zone.
+type Blocklist struct {
+	// Path is the path of the zone file. When loading the configuration
+	// using [Load], relative paths are relative to the configuration file.
+	Path string `yaml:"path"`
+
+	// Origin is the origin of the policy zone.
+	Origin string `yaml:"origin"`
+}
+
+// Transport is the configuration of the [*dnscore.Transport].
+type Transport struct {
+	// ReuseConns enables reusing TCP and TLS connections.
+	ReuseConns bool `yaml:"reuseConns"`
+
+	// PipelineQueries enables pipelining queries over TCP and TLS connections.
+	PipelineQueries bool `yaml:"pipelineQueries"`
+
+	// MaxConcurrentQueries is the maximum number of in-flight queries.
+	// If zero, we do not limit the number of in-flight queries.
+	MaxConcurrentQueries int `yaml:"maxConcurrentQueries"`
+}
+
+// Parse parses and validates the given YAML configuration document,
+// applying the defaults. The returned error wraps [ErrInvalidConfig]
+// when the document is syntactically valid but its content is not.
+func Parse(r io.Reader) (*Config, error) {
+	// 1. decode the document, rejecting unknown fields
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	config := &Config{}
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// 2. apply the defaults and validate
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Load is like [Parse] but reads the document from the given file.
+func Load(path string) (*Config, error) {
+	// 1. parse the file
+	filep, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer filep.Close()
+	config, err := Parse(filep)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// 2. make the blocklist paths relative to the file
+	for _, blocklist := range config.Blocklists {
+		if !filepath.IsAbs(blocklist.Path) {
+			blocklist.Path = filepath.Join(filepath.Dir(path), blocklist.Path)
+		}
+	}
+	return config, nil
+}
+
+// setDefaults applies the defaults.
+func (c *Config) setDefaults() {
+	for _, upstream := range c.Upstreams {
+		if upstream != nil && upstream.Protocol == "" {
+			upstream.Protocol = dnscore.ProtocolUDP
+		}
+	}
+	if c.Selection == "" {
+		c.Selection = "ordered"
+	}
+	if c.Attempts == 0 {
+		c.Attempts = dnscore.DefaultAttempts
+	}
+}
+
+// Validate returns an error wrapping [ErrInvalidConfig]
+// when the configuration is not valid.
+func (c *Config) Validate() error {
+	for idx, upstream := range c.Upstreams {
+		switch {
+		case upstream == nil || upstream.Address == "":
+			return fmt.Errorf("%w: upstreams[%d]: missing address", ErrInvalidConfig, idx)
+		case !validProtocol(upstream.Protocol):
+			return fmt.Errorf("%w: upstreams[%d]: unknown protocol: %s", ErrInvalidConfig, idx, upstream.Protocol)
+		case upstream.Timeout < 0:
+			return fmt.Errorf("%w: upstreams[%d]: negative timeout", ErrInvalidConfig, idx)
+		}
+	}
+	if _, found := selections[c.Selection]; !found {
+		return fmt.Errorf("%w: unknown selection: %s", ErrInvalidConfig, c.Selection)
+	}
+	if c.Attempts < 0 {
+		return fmt.Errorf("%w: negative attempts", ErrInvalidConfig)
+	}
+	if c.Cache != nil && (c.Cache.MaxEntries < 0 || c.Cache.PrefetchWindow < 0) {
+		return fmt.Errorf("%w: negative cache settings", ErrInvalidConfig)
+	}
+	for idx, blocklist := range c.Blocklists {
+		if blocklist == nil || blocklist.Path == "" || blocklist.Origin == "" {
+			return fmt.Errorf("%w: blocklists[%d]: missing path or origin", ErrInvalidConfig, idx)
+		}
+	}
+	if c.Transport.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("%w: negative maxConcurrentQueries", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// selections maps the names of the server selection policies to their values.
+var selections = map[string]dnscore.ServerSelection{
+	"ordered": dnscore.ServerSelectionOrdered,
+	"fastest": dnscore.ServerSelectionFastest,
+}
+
+// validProtocol returns whether the given protocol is supported. We do not
+// support the cleartext "http" and "h2c" protocols, since the transport we
+// create does not enable [dnscore.Transport.Insecure].
+func validProtocol(protocol dnscore.Protocol) bool {
+	switch protocol {
+	case dnscore.ProtocolUDP, dnscore.ProtocolTCP, dnscore.ProtocolDoT, dnscore.ProtocolDoH,
+		dnscore.ProtocolDoHJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewResolver creates a new [*dnscore.Resolver] using the configuration,
+// loading the blocklists, and returns it along with the underlying
+// [*dnscore.Transport], which the caller should close when done. The
+// resolver uses a [*dnscore.RPZTransport] even without blocklists, such
+// that [*Config.Apply] can later add blocklists.
+func (c *Config) NewResolver() (*dnscore.Resolver, *dnscore.Transport, error) {
+	// 1. load the blocklists
+	policies, err := c.loadBlocklists()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 2. create the transport
+	txp := &dnscore.Transport{
+		ReuseStreamConns:      c.Transport.ReuseConns,
+		PipelineStreamQueries: c.Transport.PipelineQueries,
+		MaxConcurrentQueries:  c.Transport.MaxConcurrentQueries,
+	}
+	reso := &dnscore.Resolver{
+		Config:    c.newResolverConfig(),
+		Transport: dnscore.NewRPZTransport(txp, policies...),
+	}
+
+	// 3. create the cache
+	if c.Cache != nil {
+		reso.Cache = &dnscore.Cache{
+			MaxEntries:     c.Cache.MaxEntries,
+			PrefetchWindow: c.Cache.PrefetchWindow,
+		}
+	}
+	return reso, txp, nil
+}
+
+// ErrNotReloadable indicates that [*Config.Apply] cannot
+// update a resolver not created by [*Config.NewResolver].
+var ErrNotReloadable = errors.New("resolver not created by NewResolver")
+
+// Apply applies the upstreams, the server selection, the attempts, and the
+// blocklists of the configuration to a running resolver created by
+// [*Config.NewResolver]. We replace the upstreams, the server selection, and
+// the attempts at once using [*dnscore.ResolverConfig.Replace], such that
+// each lookup uses either the previous or the new settings, and we keep the
+// cache. Serving local zones is out of scope for this package. We do not
+// apply the cache and transport settings, since changing them requires a
+// new resolver. On failure, we do not change the resolver.
+func (c *Config) Apply(reso *dnscore.Resolver) error {
+	// 1. make sure we can update the resolver
+	rpz, ok := reso.Transport.(*dnscore.RPZTransport)
+	if !ok || reso.Config == nil {
+		return ErrNotReloadable
+	}
+
+	// 2. load the blocklists
+	policies, err := c.loadBlocklists()
+	if err != nil {
+		return err
+	}
+
+	// 3. replace the settings
+	reso.Config.Replace(c.newResolverConfig())
+	rpz.SetPolicies(policies...)
+	return nil
+}
+
+// Reload loads the configuration from the given file, as [Load] does,
+// and applies it to the given resolver, as [*Config.Apply] does. Call
+// this function, e.g., when the daemon receives SIGHUP.
+func Reload(path string, reso *dnscore.Resolver) error {
+	config, err := Load(path)
+	if err != nil {
+		return err
+	}
+	return config.Apply(reso)
+}
+
+// newResolverConfig creates the [*dnscore.ResolverConfig].
+func (c *Config) newResolverConfig() *dnscore.ResolverConfig {
+	rc := dnscore.NewConfig()
+	rc.SetAttempts(c.Attempts)
+	rc.SetServerSelection(selections[c.Selection])
+	for _, upstream := range c.Upstreams {
+		var options []dnscore.AddServerOption
+		if upstream.Timeout > 0 {
+			options = append(options, dnscore.ServerOptionQueryTimeout(upstream.Timeout))
+		}
+		rc.AddServer(dnscore.NewServerAddr(upstream.Protocol, upstream.Address), options...)
+	}
+	return rc
+}
+
+// loadBlocklists loads the response policy zones.
+func (c *Config) loadBlocklists() ([]*dnscore.RPZ, error) {
+	var policies []*dnscore.RPZ
+	for _, blocklist := range c.Blocklists {
+		zone, err := dnscore.ParseZoneFile(blocklist.Path, blocklist.Origin)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, dnscore.NewRPZ(zone))
+	}
+	return policies, nil
+}
@@ -0,0 +1,250 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/dnscore"
+	"github.com/stretchr/testify/assert"
+)
+
+// testBlocklist is a response policy zone blocking example.com.
+const testBlocklist = `$ORIGIN rpz.example.
+@ 0 IN SOA invalid. invalid. 1 3600 600 86400 0
+@ 0 IN NS invalid.
+example.com 0 IN CNAME .
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected *Config
+		err      error
+	}{{
+		name: "empty document",
+		data: "",
+		expected: &Config{
+			Selection: "ordered",
+			Attempts:  dnscore.DefaultAttempts,
+		},
+	}, {
+		name: "full document",
+		data: `upstreams:
+  - protocol: doh
+    address: https://dns.google/dns-query
+    timeout: 3s
+  - address: 8.8.8.8:53
+selection: fastest
+attempts: 3
+cache:
+  maxEntries: 8192
+  prefetchWindow: 10s
+blocklists:
+  - path: blocklist.rpz
+    origin: rpz.example
+transport:
+  reuseConns: true
+  pipelineQueries: true
+  maxConcurrentQueries: 64
+`,
+		expected: &Config{
+			Upstreams: []*Upstream{{
+				Protocol: dnscore.ProtocolDoH,
+				Address:  "https://dns.google/dns-query",
+				Timeout:  3 * time.Second,
+			}, {
+				Protocol: dnscore.ProtocolUDP,
+				Address:  "8.8.8.8:53",
+			}},
+			Selection: "fastest",
+			Attempts:  3,
+			Cache: &Cache{
+				MaxEntries:     8192,
+				PrefetchWindow: 10 * time.Second,
+			},
+			Blocklists: []*Blocklist{{
+				Path:   "blocklist.rpz",
+				Origin: "rpz.example",
+			}},
+			Transport: Transport{
+				ReuseConns:           true,
+				PipelineQueries:      true,
+				MaxConcurrentQueries: 64,
+			},
+		},
+	}, {
+		name: "unknown field",
+		data: "listeners:\n  - 127.0.0.1:53\n",
+	}, {
+		name: "missing upstream address",
+		data: "upstreams:\n  - protocol: udp\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "unknown protocol",
+		data: "upstreams:\n  - protocol: quic\n    address: 8.8.8.8:853\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "cleartext protocol",
+		data: "upstreams:\n  - protocol: h2c\n    address: http://127.0.0.1/dns-query\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "negative timeout",
+		data: "upstreams:\n  - address: 8.8.8.8:53\n    timeout: -1s\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "unknown selection",
+		data: "selection: random\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "negative attempts",
+		data: "attempts: -1\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "negative cache settings",
+		data: "cache:\n  maxEntries: -1\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "missing blocklist origin",
+		data: "blocklists:\n  - path: blocklist.rpz\n",
+		err:  ErrInvalidConfig,
+	}, {
+		name: "negative maxConcurrentQueries",
+		data: "transport:\n  maxConcurrentQueries: -1\n",
+		err:  ErrInvalidConfig,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Parse(strings.NewReader(tt.data))
+			switch {
+			case tt.expected != nil:
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, config)
+			case tt.err != nil:
+				assert.ErrorIs(t, err, tt.err)
+			default:
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	// 1. write the configuration and the blocklist
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dnscore.yaml")
+	data := "upstreams:\n  - address: 8.8.8.8:53\nblocklists:\n  - path: blocklist.rpz\n    origin: rpz.example\n"
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blocklist.rpz"), []byte(testBlocklist), 0600))
+
+	// 2. load the configuration
+	config, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "blocklist.rpz"), config.Blocklists[0].Path)
+
+	// 3. make sure we handle errors
+	_, err = Load(filepath.Join(dir, "nonexistent.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, os.WriteFile(path, []byte("attempts: -1\n"), 0600))
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), path)
+}
+
+func TestConfig_NewResolver(t *testing.T) {
+	t.Run("without blocklists", func(t *testing.T) {
+		config, err := Parse(strings.NewReader("upstreams:\n  - address: 8.8.8.8:53\ncache: {}\n" +
+			"transport:\n  reuseConns: true\n  maxConcurrentQueries: 16\n"))
+		assert.NoError(t, err)
+		reso, txp, err := config.NewResolver()
+		assert.NoError(t, err)
+		assert.Same(t, txp, reso.Transport.(*dnscore.RPZTransport).Transport)
+		assert.True(t, txp.ReuseStreamConns)
+		assert.Equal(t, 16, txp.MaxConcurrentQueries)
+		assert.NotNil(t, reso.Cache)
+		assert.Equal(t, dnscore.DefaultAttempts, reso.Config.Attempts())
+	})
+
+	t.Run("with blocklists", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "blocklist.rpz")
+		assert.NoError(t, os.WriteFile(path, []byte(testBlocklist), 0600))
+		config := &Config{
+			Upstreams:  []*Upstream{{Protocol: dnscore.ProtocolUDP, Address: "127.0.0.1:1"}},
+			Selection:  "ordered",
+			Attempts:   1,
+			Blocklists: []*Blocklist{{Path: path, Origin: "rpz.example"}},
+		}
+		reso, txp, err := config.NewResolver()
+		assert.NoError(t, err)
+		defer txp.Close()
+		assert.IsType(t, &dnscore.RPZTransport{}, reso.Transport)
+		assert.Nil(t, reso.Cache)
+
+		// the blocked name fails without contacting the upstream
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		resp, err := reso.Transport.Query(context.Background(), dnscore.NewServerAddr(dnscore.ProtocolUDP, "127.0.0.1:1"), query)
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+	})
+
+	t.Run("missing blocklist", func(t *testing.T) {
+		config := &Config{Blocklists: []*Blocklist{{Path: "/nonexistent", Origin: "rpz.example"}}}
+		_, _, err := config.NewResolver()
+		assert.Error(t, err)
+	})
+}
+
+func TestReload(t *testing.T) {
+	// 1. create a resolver without blocklists
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dnscore.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("upstreams:\n  - address: 127.0.0.1:1\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blocklist.rpz"), []byte(testBlocklist), 0600))
+	config, err := Load(path)
+	assert.NoError(t, err)
+	reso, txp, err := config.NewResolver()
+	assert.NoError(t, err)
+	defer txp.Close()
+	reso.Cache = &dnscore.Cache{}
+
+	// queryBlocked returns whether the resolver blocks example.com.
+	queryBlocked := func() bool {
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // the upstream is never contacted when blocking
+		resp, err := reso.Transport.Query(ctx, dnscore.NewServerAddr(dnscore.ProtocolUDP, "127.0.0.1:1"), query)
+		return err == nil && resp.Rcode == dns.RcodeNameError
+	}
+	assert.False(t, queryBlocked())
+
+	// 2. reload adding the blocklist
+	data := "upstreams:\n  - address: 127.0.0.1:1\nattempts: 5\nselection: fastest\n" +
+		"blocklists:\n  - path: blocklist.rpz\n    origin: rpz.example\n"
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	cache := reso.Cache
+	assert.NoError(t, Reload(path, reso))
+	assert.True(t, queryBlocked())
+	assert.Equal(t, 5, reso.Config.Attempts())
+	assert.Same(t, cache, reso.Cache)
+
+	// 3. a failing reload does not change the resolver
+	assert.NoError(t, os.WriteFile(path, []byte(data+"  - path: missing.rpz\n    origin: rpz.example\n"), 0600))
+	assert.Error(t, Reload(path, reso))
+	assert.NoError(t, os.WriteFile(path, []byte("attempts: -1\n"), 0600))
+	assert.ErrorIs(t, Reload(path, reso), ErrInvalidConfig)
+	assert.True(t, queryBlocked())
+	assert.Equal(t, 5, reso.Config.Attempts())
+
+	// 4. we cannot apply the configuration to other resolvers
+	assert.ErrorIs(t, config.Apply(&dnscore.Resolver{}), ErrNotReloadable)
+}
@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ConnEventKind is the kind of a [*ConnEvent].
+type ConnEventKind int
+
+const (
+	// ConnEventOpen indicates that we created a new connection,
+	// including completing the TLS handshake, if needed.
+	ConnEventOpen = ConnEventKind(iota)
+
+	// ConnEventReuse indicates that we reused a connection.
+	ConnEventReuse
+
+	// ConnEventHandshakeFailure indicates that the TLS handshake failed.
+	ConnEventHandshakeFailure
+
+	// ConnEventClose indicates that we closed a connection.
+	ConnEventClose
+)
+
+// String returns the string representation of the kind.
+func (k ConnEventKind) String() string {
+	switch k {
+	case ConnEventOpen:
+		return "open"
+	case ConnEventReuse:
+		return "reuse"
+	case ConnEventHandshakeFailure:
+		return "handshake_failure"
+	case ConnEventClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnCloseReason explains why we closed a connection.
+type ConnCloseReason string
+
+const (
+	// ConnCloseDone indicates that we closed a connection
+	// we do not reuse once the query using it was done.
+	ConnCloseDone = ConnCloseReason("done")
+
+	// ConnCloseIdleTimeout indicates that an idle connection expired.
+	ConnCloseIdleTimeout = ConnCloseReason("idle_timeout")
+
+	// ConnCloseServerRequest indicates that the server asked us to
+	// close the connection using the RFC 7828 edns-tcp-keepalive option.
+	ConnCloseServerRequest = ConnCloseReason("server_request")
+
+	// ConnCloseMaxAge indicates that the connection became older
+	// than the [*Transport] MaxConnectionAge.
+	ConnCloseMaxAge = ConnCloseReason("max_age")
+
+	// ConnCloseProbeFailure indicates that probing an idle connection
+	// showed that the server closed it or that the path is broken.
+	ConnCloseProbeFailure = ConnCloseReason("probe_failure")
+
+	// ConnCloseUnresponsive indicates that the server stopped answering
+	// the queries sent over a pipelined connection, which timed out.
+	ConnCloseUnresponsive = ConnCloseReason("unresponsive")
+
+	// ConnCloseError indicates that using the connection failed.
+	ConnCloseError = ConnCloseReason("error")
+
+	// ConnCloseTransport indicates that we closed the connection because of
+	// [*Transport.Close] or [*Transport.Shutdown].
+	ConnCloseTransport = ConnCloseReason("transport")
+)
+
+// ConnEvent is a connection lifecycle event emitted by the [*Transport],
+// which allows to alert on conditions such as TLS handshakes failing
+// with a given server without parsing the logs.
+//
+// We emit open and reuse events for all the protocols, handshake failures
+// for DNS over TLS and DNS over HTTPS, and close events for all the protocols
+// except DNS over HTTPS, whose connections the HTTP client manages. When the
+// DialTLSContext field is set, we cannot distinguish handshake failures from
+// other dialing failures, hence we do not emit handshake failure events.
+type ConnEvent struct {
+	// Kind is the kind of event.
+	Kind ConnEventKind
+
+	// Server is the server the connection is for.
+	Server *ServerAddr
+
+	// LocalAddr is the local address of the connection, if known.
+	LocalAddr netip.AddrPort
+
+	// RemoteAddr is the remote address of the connection, if known.
+	RemoteAddr netip.AddrPort
+
+	// Reason explains why we closed the connection, for [ConnEventClose].
+	Reason ConnCloseReason
+
+	// Err is the handshake error, for [ConnEventHandshakeFailure], or the
+	// error that caused closing the connection, if any, for [ConnEventClose].
+	Err error
+
+	// Time is when the event occurred.
+	Time time.Time
+}
+
+// emitConnEvent invokes the OnConnEvent callback, if set, with a new event
+// for the given server and connection, which may be nil if unknown.
+func (t *Transport) emitConnEvent(kind ConnEventKind,
+	addr *ServerAddr, conn net.Conn, reason ConnCloseReason, err error) {
+	if t.OnConnEvent == nil {
+		return
+	}
+	ev := &ConnEvent{Kind: kind, Server: addr, Reason: reason, Err: err, Time: t.timeNow()}
+	if conn != nil {
+		ev.LocalAddr = addrToAddrPort(conn.LocalAddr())
+		ev.RemoteAddr = addrToAddrPort(conn.RemoteAddr())
+	}
+	t.OnConnEvent(ev)
+}
+
+// newConn accounts for a new connection to the given server and, when
+// the OnConnEvent field is set, emits the open event and returns a wrapper
+// emitting the close event, which [closeConn] annotates with the reason.
+// We also return the wrapper when the Capture field is set, to forget the
+// TCP sequence numbers of the connection once we close it.
+func (t *Transport) newConn(addr *ServerAddr, conn net.Conn) net.Conn {
+	t.stats.onConn(addr, false)
+	if t.OnConnEvent == nil && t.Capture == nil {
+		return conn
+	}
+	t.emitConnEvent(ConnEventOpen, addr, conn, "", nil)
+	return &eventConn{Conn: conn, addr: addr, t: t}
+}
+
+// reuseConn accounts for reusing a connection to the given server.
+func (t *Transport) reuseConn(addr *ServerAddr, conn net.Conn) {
+	t.stats.onConn(addr, true)
+	t.emitConnEvent(ConnEventReuse, addr, conn, "", nil)
+}
+
+// maybeEmitHandshakeFailure emits the handshake failure event
+// when the given dialing error is a [*tlsHandshakeError].
+func (t *Transport) maybeEmitHandshakeFailure(addr *ServerAddr, err error) {
+	var hsErr *tlsHandshakeError
+	if errors.As(err, &hsErr) {
+		t.emitConnEvent(ConnEventHandshakeFailure, addr, nil, "", hsErr.err)
+	}
+}
+
+// tlsHandshakeError wraps a TLS handshake error such that we can distinguish
+// it from other dialing errors. It is otherwise indistinguishable from the
+// wrapped error, whose message it uses and which it unwraps to.
+type tlsHandshakeError struct {
+	err error
+}
+
+// Error implements error.
+func (e *tlsHandshakeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *tlsHandshakeError) Unwrap() error {
+	return e.err
+}
+
+// eventConn is a connection emitting the close event once.
+type eventConn struct {
+	net.Conn
+	addr *ServerAddr
+	once sync.Once
+	t    *Transport
+}
+
+// NetConn returns the underlying connection.
+func (c *eventConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Close closes the connection using the [ConnCloseDone] reason.
+func (c *eventConn) Close() error {
+	return c.closeWithReason(ConnCloseDone, nil)
+}
+
+// closeWithReason closes the connection emitting the close event with
+// the given reason and error the first time we close the connection.
+func (c *eventConn) closeWithReason(reason ConnCloseReason, err error) error {
+	c.once.Do(func() {
+		c.t.emitConnEvent(ConnEventClose, c.addr, c.Conn, reason, err)
+		if c.t.Capture != nil {
+			c.t.Capture.endFlow(addrToAddrPort(c.Conn.LocalAddr()), addrToAddrPort(c.Conn.RemoteAddr()))
+		}
+	})
+	return c.Conn.Close()
+}
+
+// closeConn closes the given connection, annotating the close
+// event, if any, with the given reason and error.
+func closeConn(conn net.Conn, reason ConnCloseReason, err error) {
+	if ec, ok := conn.(*eventConn); ok {
+		ec.closeWithReason(reason, err)
+		return
+	}
+	conn.Close()
+}
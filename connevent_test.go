@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnEventKind_String(t *testing.T) {
+	tests := []struct {
+		kind     ConnEventKind
+		expected string
+	}{
+		{ConnEventOpen, "open"},
+		{ConnEventReuse, "reuse"},
+		{ConnEventHandshakeFailure, "handshake_failure"},
+		{ConnEventClose, "close"},
+		{ConnEventKind(100), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.kind.String())
+		})
+	}
+}
+
+// connEventRecorder records the [*ConnEvent] emitted by a [*Transport].
+type connEventRecorder struct {
+	events []*ConnEvent
+	mu     sync.Mutex
+}
+
+// record is the OnConnEvent callback.
+func (r *connEventRecorder) record(ev *ConnEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// summary returns the kind and, for close events, the reason of each event.
+func (r *connEventRecorder) summary() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, ev := range r.events {
+		if ev.Kind == ConnEventClose {
+			out = append(out, ev.Kind.String()+"/"+string(ev.Reason))
+			continue
+		}
+		out = append(out, ev.Kind.String())
+	}
+	return out
+}
+
+func TestTransport_OnConnEvent(t *testing.T) {
+	newQuery := func() *dns.Msg {
+		query, _ := NewQuery("example.com", dns.TypeA, QueryOptionEDNS0(4096, 0))
+		return query
+	}
+
+	t.Run("reused connections", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerKeepalive(50)))
+		now := time.Now()
+		recorder := &connEventRecorder{}
+		txp := &Transport{ReuseStreamConns: true, OnConnEvent: recorder.record,
+			TimeNow: func() time.Time { return now }}
+
+		for idx := 0; idx < 2; idx++ {
+			_, err := txp.Query(context.Background(), addr, newQuery())
+			assert.NoError(t, err)
+		}
+		now = now.Add(5 * time.Second)
+		_, err := txp.Query(context.Background(), addr, newQuery())
+		assert.NoError(t, err)
+		assert.NoError(t, txp.Close())
+
+		assert.Equal(t, []string{"open", "reuse", "close/idle_timeout", "open", "close/transport"},
+			recorder.summary())
+		ev := recorder.events[0]
+		assert.Equal(t, addr, ev.Server)
+		assert.Equal(t, addr.Address, ev.RemoteAddr.String())
+		assert.True(t, ev.LocalAddr.IsValid())
+		assert.Equal(t, now.Add(-5*time.Second), ev.Time)
+	})
+
+	t.Run("connections the server asks to close", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerKeepalive(0)))
+		recorder := &connEventRecorder{}
+		txp := &Transport{ReuseStreamConns: true, OnConnEvent: recorder.record}
+		defer txp.Close()
+		_, err := txp.Query(context.Background(), addr, newQuery())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"open", "close/server_request"}, recorder.summary())
+	})
+
+	t.Run("pipelined connections", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+		recorder := &connEventRecorder{}
+		txp := &Transport{PipelineStreamQueries: true, OnConnEvent: recorder.record}
+		for idx := 0; idx < 2; idx++ {
+			_, err := txp.Query(context.Background(), addr, newQuery())
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, txp.Close())
+		assert.Equal(t, []string{"open", "reuse", "close/transport"}, recorder.summary())
+	})
+
+	t.Run("single-use connections", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+		recorder := &connEventRecorder{}
+		txp := &Transport{OnConnEvent: recorder.record}
+		_, err := txp.Query(context.Background(), addr, newQuery())
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return slices.Equal([]string{"open", "close/done"}, recorder.summary())
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("TLS handshake failures", func(t *testing.T) {
+		// a server closing the connection without handshaking
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+
+		recorder := &connEventRecorder{}
+		txp := &Transport{OnConnEvent: recorder.record}
+		addr := NewServerAddr(ProtocolDoT, listener.Addr().String())
+		_, err = txp.Query(context.Background(), addr, newQuery())
+		assert.Error(t, err)
+		assert.Equal(t, []string{"handshake_failure"}, recorder.summary())
+		assert.ErrorIs(t, err, recorder.events[0].Err)
+	})
+
+	t.Run("no events without the callback", func(t *testing.T) {
+		txp := &Transport{}
+		conn := &net.TCPConn{}
+		assert.Same(t, conn, txp.newConn(NewServerAddr(ProtocolTCP, "127.0.0.1:53"), conn))
+	})
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DDRName is the special-use name used to discover the designated
+// resolvers of an unencrypted resolver, as defined by RFC 9462.
+const DDRName = "_dns.resolver.arpa."
+
+// DiscoverDesignatedResolvers discovers the DNS over TLS and DNS over HTTPS
+// endpoints of the unencrypted resolver at addr using the Discovery of
+// Designated Resolvers (DDR) mechanism (RFC 9462), and returns them in
+// priority order. The address of addr must be an IP address and a port.
+//
+// We only return the endpoints reachable at the IP address of the unencrypted
+// resolver, where the TLS handshake verifies that the certificate covers
+// such an address, as required by verified discovery (RFC 9462 Sect. 4.2).
+// Therefore, we ignore the endpoints whose address hints do not include the
+// IP address of the unencrypted resolver, as well as the endpoints using
+// protocols that [*Transport] does not support, such as DNS over QUIC.
+func DiscoverDesignatedResolvers(ctx context.Context,
+	txp ResolverTransport, addr *ServerAddr) ([]*ServerAddr, error) {
+	// 1. parse the address of the unencrypted resolver
+	addrport, err := netip.ParseAddrPort(addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	ipAddr := addrport.Addr().Unmap()
+
+	// 2. query for the SVCB records
+	query, err := NewQueryWithServerAddr(addr, DDRName, dns.TypeSVCB)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := txp.Query(ctx, addr, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateResponse(query, resp); err != nil {
+		return nil, err
+	}
+	if err := RCodeToError(resp); err != nil {
+		return nil, err
+	}
+
+	// 3. collect the service records in priority order
+	var records []*dns.SVCB
+	for _, rr := range resp.Answer {
+		if svcb, ok := rr.(*dns.SVCB); ok && svcb.Priority > 0 && ddrHintsInclude(svcb, ipAddr) {
+			records = append(records, svcb)
+		}
+	}
+	slices.SortStableFunc(records, func(a, b *dns.SVCB) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+
+	// 4. map the records to endpoints at the address of the resolver
+	var endpoints []*ServerAddr
+	for _, svcb := range records {
+		for _, endpoint := range ddrEndpoints(svcb, ipAddr) {
+			if !slices.ContainsFunc(endpoints, func(other *ServerAddr) bool {
+				return other.Protocol == endpoint.Protocol && other.Address == endpoint.Address
+			}) {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	}
+	if len(endpoints) <= 0 {
+		return nil, ErrNoData
+	}
+	return endpoints, nil
+}
+
+// ddrHintsInclude returns whether the address hints of the given record
+// include the given address or whether the record has no address hints.
+func ddrHintsInclude(svcb *dns.SVCB, ipAddr netip.Addr) bool {
+	var hints []net.IP
+	for _, kv := range svcb.Value {
+		switch kv := kv.(type) {
+		case *dns.SVCBIPv4Hint:
+			hints = append(hints, kv.Hint...)
+		case *dns.SVCBIPv6Hint:
+			hints = append(hints, kv.Hint...)
+		}
+	}
+	if len(hints) <= 0 {
+		return true
+	}
+	for _, hint := range hints {
+		if hintAddr, ok := netip.AddrFromSlice(hint); ok && hintAddr.Unmap() == ipAddr {
+			return true
+		}
+	}
+	return false
+}
+
+// ddrEndpoints returns the endpoints described by the given record.
+func ddrEndpoints(svcb *dns.SVCB, ipAddr netip.Addr) []*ServerAddr {
+	// 1. collect the relevant parameters
+	var (
+		alpn    []string
+		dohpath string
+		port    uint16
+	)
+	for _, kv := range svcb.Value {
+		switch kv := kv.(type) {
+		case *dns.SVCBAlpn:
+			alpn = kv.Alpn
+		case *dns.SVCBDoHPath:
+			dohpath = kv.Template
+		case *dns.SVCBPort:
+			port = kv.Port
+		}
+	}
+
+	// 2. create the endpoints for the supported protocols
+	var endpoints []*ServerAddr
+	for _, proto := range alpn {
+		switch {
+		case proto == "dot":
+			dotPort := port
+			if dotPort == 0 {
+				dotPort = 853
+			}
+			address := netip.AddrPortFrom(ipAddr, dotPort).String()
+			endpoints = append(endpoints, NewServerAddr(ProtocolDoT, address))
+
+		case (proto == "h2" || proto == "http/1.1") && strings.HasPrefix(dohpath, "/"):
+			host := ipAddr.String()
+			if ipAddr.Is6() {
+				host = "[" + host + "]"
+			}
+			if port != 0 && port != 443 {
+				host += ":" + strconv.Itoa(int(port))
+			}
+			path, _, _ := strings.Cut(dohpath, "{")
+			endpoints = append(endpoints, NewServerAddr(ProtocolDoH, "https://"+host+path))
+		}
+	}
+	return endpoints
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverDesignatedResolvers(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		records  []string
+		expected []string
+		err      error
+	}{{
+		name:    "DoT and DoH in priority order",
+		address: "1.1.1.1:53",
+		records: []string{
+			`_dns.resolver.arpa. 300 IN SVCB 2 one.one.one.one. alpn="h2,http/1.1" ipv4hint=1.1.1.1,1.0.0.1 dohpath="/dns-query{?dns}"`,
+			`_dns.resolver.arpa. 300 IN SVCB 1 one.one.one.one. alpn="dot" ipv4hint=1.1.1.1,1.0.0.1`,
+		},
+		expected: []string{"dot 1.1.1.1:853", "doh https://1.1.1.1/dns-query"},
+	}, {
+		name:    "IPv6 and custom ports",
+		address: "[2001:db8::1]:53",
+		records: []string{
+			`_dns.resolver.arpa. 300 IN SVCB 1 dns.example. alpn="dot,h2" port=8853 dohpath="/q{?dns}"`,
+		},
+		expected: []string{"dot [2001:db8::1]:8853", "doh https://[2001:db8::1]:8853/q"},
+	}, {
+		name:    "ignored records",
+		address: "192.0.2.1:53",
+		records: []string{
+			`_dns.resolver.arpa. 300 IN SVCB 0 dns.example.`,
+			`_dns.resolver.arpa. 300 IN SVCB 1 dns.example. alpn="dot" ipv4hint=192.0.2.2`,
+			`_dns.resolver.arpa. 300 IN SVCB 2 dns.example. alpn="doq,h3"`,
+			`_dns.resolver.arpa. 300 IN SVCB 3 dns.example. alpn="h2"`,
+		},
+		err: ErrNoData,
+	}, {
+		name:    "no records",
+		address: "192.0.2.1:53",
+		records: []string{`_dns.resolver.arpa. 300 IN TXT "no designated resolvers"`},
+		err:     ErrNoData,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txp := answerFromRecords(t, tt.records...)
+			endpoints, err := DiscoverDesignatedResolvers(context.Background(), txp, NewServerAddr(ProtocolUDP, tt.address))
+			assert.ErrorIs(t, err, tt.err)
+			var got []string
+			for _, endpoint := range endpoints {
+				got = append(got, string(endpoint.Protocol)+" "+endpoint.Address)
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+
+	t.Run("invalid address", func(t *testing.T) {
+		_, err := DiscoverDesignatedResolvers(context.Background(), answerFromRecords(t),
+			NewServerAddr(ProtocolUDP, "dns.google:53"))
+		assert.Error(t, err)
+	})
+
+	t.Run("query failure", func(t *testing.T) {
+		expectedErr := errors.New("mocked error")
+		txp := &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				return nil, expectedErr
+			},
+		}
+		_, err := DiscoverDesignatedResolvers(context.Background(), txp, NewServerAddr(ProtocolUDP, "1.1.1.1:53"))
+		assert.ErrorIs(t, err, expectedErr)
+	})
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DefaultConnectionAttemptDelay is the default delay after which
+// [*Dialer] starts the next connection attempt (RFC 8305 Sect. 5).
+const DefaultConnectionAttemptDelay = 250 * time.Millisecond
+
+// Dialer establishes TCP connections to HTTPS services using Happy
+// Eyeballs, taking the HTTPS records into account as described by
+// the Happy Eyeballs Version 3 draft. We obtain the endpoints using
+// [*Resolver.LookupHTTPSEndpoints], which falls back to the address
+// hints and to the A and AAAA records, and we order the connection
+// attempts by preferring the endpoints supporting ECH, then by the
+// priority of the records, interleaving the address families, starting
+// with IPv6. We skip the endpoints whose ALPN protocols all require
+// QUIC. We start a new attempt when the previous one fails or after
+// ConnectionAttemptDelay, and return the first established connection.
+//
+// The zero value is ready to use.
+type Dialer struct {
+	// ConnectionAttemptDelay is the optional delay after which we start
+	// the next connection attempt if the previous one is still pending.
+	//
+	// If zero, we use [DefaultConnectionAttemptDelay].
+	ConnectionAttemptDelay time.Duration
+
+	// DialAddrContext is the optional function to dial each address. If
+	// this field is nil, we use a [*net.Dialer].
+	DialAddrContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Resolver is the optional resolver to use.
+	//
+	// If nil, we use a zero-value [*Resolver].
+	Resolver *Resolver
+}
+
+// dialerAttempt is a connection attempt of the [*Dialer].
+type dialerAttempt struct {
+	// addr is the address to dial.
+	addr netip.AddrPort
+
+	// endpoint is the endpoint the address belongs to.
+	endpoint *HTTPSEndpoint
+}
+
+// dialerResult is the result of a [dialerAttempt].
+type dialerResult struct {
+	attempt dialerAttempt
+	conn    net.Conn
+	err     error
+}
+
+// resolver returns the resolver to use.
+func (d *Dialer) resolver() *Resolver {
+	if d.Resolver != nil {
+		return d.Resolver
+	}
+	return &Resolver{}
+}
+
+// connectionAttemptDelay returns the delay between connection attempts.
+func (d *Dialer) connectionAttemptDelay() time.Duration {
+	if d.ConnectionAttemptDelay > 0 {
+		return d.ConnectionAttemptDelay
+	}
+	return DefaultConnectionAttemptDelay
+}
+
+// dialContext dials the given address.
+func (d *Dialer) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.DialAddrContext != nil {
+		return d.DialAddrContext(ctx, network, address)
+	}
+	dialer := &net.Dialer{}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// DialContext establishes a connection to the HTTPS service at the
+// given address using the given network, which must be "tcp", "tcp4",
+// or "tcp6". This method is suitable for [*http.Transport].
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, _, err := d.DialEndpoint(ctx, network, address)
+	return conn, err
+}
+
+// DialEndpoint is like [*Dialer.DialContext] but also returns the endpoint
+// to which we connected, whose ALPN protocols and ECH configuration the
+// caller should use to configure the TLS handshake.
+func (d *Dialer) DialEndpoint(ctx context.Context, network, address string) (net.Conn, *HTTPSEndpoint, error) {
+	// 1. parse the network and the address
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, nil, fmt.Errorf("unsupported network: %s", network)
+	}
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid port: %s", portString)
+	}
+
+	// 2. obtain the endpoints and order the connection attempts
+	endpoints, err := d.resolver().LookupHTTPSEndpoints(ctx, host, uint16(port))
+	if err != nil {
+		return nil, nil, err
+	}
+	attempts := dialerOrderAttempts(network, endpoints)
+	if len(attempts) <= 0 {
+		return nil, nil, fmt.Errorf("%w: no %s addresses for %s", ErrNoData, network, host)
+	}
+
+	// 3. race the connection attempts
+	return d.race(ctx, network, attempts)
+}
+
+// dialerOrderAttempts returns the connection attempts for the given
+// endpoints, which are in priority order, as documented by [*Dialer].
+func dialerOrderAttempts(network string, endpoints []*HTTPSEndpoint) []dialerAttempt {
+	// 1. prefer the endpoints supporting ECH, skipping the QUIC-only ones
+	var usable []*HTTPSEndpoint
+	for _, endpoint := range endpoints {
+		if len(endpoint.ALPN) <= 0 || slices.ContainsFunc(endpoint.ALPN, dialerIsTCPProtocol) {
+			usable = append(usable, endpoint)
+		}
+	}
+	slices.SortStableFunc(usable, func(a, b *HTTPSEndpoint) int {
+		switch {
+		case len(a.ECHConfig) > 0 && len(b.ECHConfig) <= 0:
+			return -1
+		case len(a.ECHConfig) <= 0 && len(b.ECHConfig) > 0:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	// 2. interleave the address families of each endpoint, skipping the
+	// duplicate addresses and the addresses unsuitable for the network
+	var attempts []dialerAttempt
+	seen := make(map[netip.AddrPort]bool)
+	for _, endpoint := range usable {
+		var ipv4, ipv6 []dialerAttempt
+		for _, addr := range endpoint.Addrs {
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			switch {
+			case addr.Addr().Is4() && network != "tcp6":
+				ipv4 = append(ipv4, dialerAttempt{addr: addr, endpoint: endpoint})
+			case addr.Addr().Is6() && network != "tcp4":
+				ipv6 = append(ipv6, dialerAttempt{addr: addr, endpoint: endpoint})
+			}
+		}
+		for len(ipv4) > 0 || len(ipv6) > 0 {
+			if len(ipv6) > 0 {
+				attempts, ipv6 = append(attempts, ipv6[0]), ipv6[1:]
+			}
+			if len(ipv4) > 0 {
+				attempts, ipv4 = append(attempts, ipv4[0]), ipv4[1:]
+			}
+		}
+	}
+	return attempts
+}
+
+// dialerIsTCPProtocol returns whether the given ALPN protocol runs over TCP.
+func dialerIsTCPProtocol(protocol string) bool {
+	return protocol != "h3" && protocol != "doq"
+}
+
+// race runs the connection attempts, starting each of them when the previous
+// one fails or after the connection attempt delay, and returns the first
+// established connection, closing the connections established later.
+func (d *Dialer) race(ctx context.Context, network string, attempts []dialerAttempt) (net.Conn, *HTTPSEndpoint, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *dialerResult, len(attempts))
+	var next, running int
+	start := func() {
+		attempt := attempts[next]
+		next, running = next+1, running+1
+		go func() {
+			conn, err := d.dialContext(ctx, network, attempt.addr.String())
+			results <- &dialerResult{attempt: attempt, conn: conn, err: err}
+		}()
+	}
+
+	start()
+	var errs []error
+	for running > 0 {
+		var delay <-chan time.Time
+		if next < len(attempts) {
+			delay = time.After(d.connectionAttemptDelay())
+		}
+		select {
+		case result := <-results:
+			running--
+			if result.err == nil {
+				go dialerCloseLateConns(results, running)
+				return result.conn, result.attempt.endpoint, nil
+			}
+			errs = append(errs, result.err)
+			if next < len(attempts) {
+				start()
+			}
+		case <-delay:
+			start()
+		}
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// dialerCloseLateConns closes the connections established by the given
+// number of attempts still running after another attempt succeeded.
+func dialerCloseLateConns(results <-chan *dialerResult, running int) {
+	for range running {
+		if result := <-results; result.conn != nil {
+			result.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialerOrderAttempts(t *testing.T) {
+	addrs := func(values ...string) []netip.AddrPort {
+		var result []netip.AddrPort
+		for _, value := range values {
+			result = append(result, netip.MustParseAddrPort(value))
+		}
+		return result
+	}
+	endpoints := []*HTTPSEndpoint{{
+		Priority: 1,
+		Target:   "quic.example.com.",
+		Addrs:    addrs("192.0.2.1:443"),
+		ALPN:     []string{"h3"},
+	}, {
+		Priority: 2,
+		Target:   "primary.example.com.",
+		Addrs:    addrs("192.0.2.2:443", "192.0.2.3:443", "[2001:db8::2]:443"),
+		ALPN:     []string{"h3", "h2", "http/1.1"},
+	}, {
+		Priority:  3,
+		Target:    "ech.example.com.",
+		Addrs:     addrs("192.0.2.4:443", "192.0.2.2:443"),
+		ALPN:      []string{"h2"},
+		ECHConfig: []byte{0x00},
+	}, {
+		Target: "fallback.example.com.",
+		Addrs:  addrs("[2001:db8::5]:443", "192.0.2.2:443"),
+	}}
+
+	tests := []struct {
+		network  string
+		expected []string
+	}{{
+		network: "tcp",
+		expected: []string{
+			"192.0.2.4:443", "192.0.2.2:443",
+			"[2001:db8::2]:443", "192.0.2.3:443",
+			"[2001:db8::5]:443",
+		},
+	}, {
+		network:  "tcp4",
+		expected: []string{"192.0.2.4:443", "192.0.2.2:443", "192.0.2.3:443"},
+	}, {
+		network:  "tcp6",
+		expected: []string{"[2001:db8::2]:443", "[2001:db8::5]:443"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			var got []string
+			for _, attempt := range dialerOrderAttempts(tt.network, endpoints) {
+				got = append(got, attempt.addr.String())
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestDialer_DialEndpoint(t *testing.T) {
+	reso := &Resolver{Transport: answerFromRecords(t,
+		`example.com. 300 IN HTTPS 1 . alpn="h2" ipv4hint=192.0.2.1 ipv6hint=2001:db8::1`,
+	)}
+	expectedErr := errors.New("mocked error")
+
+	t.Run("the next attempt starts after the delay", func(t *testing.T) {
+		var closed atomic.Bool
+		dialer := &Dialer{
+			ConnectionAttemptDelay: 10 * time.Millisecond,
+			DialAddrContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				conn := &mocks.Conn{MockClose: func() error {
+					closed.Store(true)
+					return nil
+				}}
+				if address == "[2001:db8::1]:443" {
+					<-ctx.Done() // the IPv6 attempt completes after the IPv4 one
+				}
+				return conn, nil
+			},
+			Resolver: reso,
+		}
+		conn, endpoint, err := dialer.DialEndpoint(context.Background(), "tcp", "example.com:443")
+		assert.NoError(t, err)
+		assert.NotNil(t, conn)
+		assert.Equal(t, []string{"h2", "http/1.1"}, endpoint.ALPN)
+		assert.Eventually(t, closed.Load, time.Second, time.Millisecond)
+	})
+
+	t.Run("the next attempt starts after a failure", func(t *testing.T) {
+		var dialed []string
+		dialer := &Dialer{
+			ConnectionAttemptDelay: time.Hour,
+			DialAddrContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialed = append(dialed, address)
+				if address == "[2001:db8::1]:443" {
+					return nil, expectedErr
+				}
+				return &mocks.Conn{}, nil
+			},
+			Resolver: reso,
+		}
+		conn, err := dialer.DialContext(context.Background(), "tcp", "example.com:443")
+		assert.NoError(t, err)
+		assert.NotNil(t, conn)
+		assert.Equal(t, []string{"[2001:db8::1]:443", "192.0.2.1:443"}, dialed)
+	})
+
+	t.Run("all the attempts fail", func(t *testing.T) {
+		dialer := &Dialer{
+			DialAddrContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, expectedErr
+			},
+			Resolver: reso,
+		}
+		_, err := dialer.DialContext(context.Background(), "tcp", "example.com:443")
+		assert.ErrorIs(t, err, expectedErr)
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		dialer := &Dialer{Resolver: reso}
+		for _, args := range [][2]string{
+			{"udp", "example.com:443"},
+			{"tcp", "example.com"},
+			{"tcp", "example.com:https"},
+		} {
+			_, err := dialer.DialContext(context.Background(), args[0], args[1])
+			assert.Error(t, err)
+		}
+	})
+
+	t.Run("no addresses for the network", func(t *testing.T) {
+		reso := &Resolver{Transport: answerFromRecords(t, `example.com. 300 IN A 192.0.2.1`)}
+		dialer := &Dialer{Resolver: reso}
+		_, err := dialer.DialContext(context.Background(), "tcp6", "example.com:443")
+		assert.ErrorIs(t, err, ErrNoData)
+	})
+}
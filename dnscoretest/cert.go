@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscoretest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/rbmk-project/common/selfsignedcert"
+)
+
+// serverCert is the lazily-generated certificate used by all servers.
+var serverCert struct {
+	cert *tls.Certificate
+	once sync.Once
+	pool *x509.CertPool
+}
+
+// certificate returns a self-signed certificate valid for www.example.com,
+// 127.0.0.1, and ::1, along with a pool containing it. We generate the
+// certificate on first use, such that it never expires while testing.
+//
+// This function panics in case of failure.
+func certificate() (*tls.Certificate, *x509.CertPool) {
+	serverCert.once.Do(func() {
+		generated := selfsignedcert.New(selfsignedcert.NewConfigExampleCom())
+		cert := runtimex.Try1(tls.X509KeyPair(generated.CertPEM, generated.KeyPEM))
+		serverCert.cert = &cert
+		serverCert.pool = x509.NewCertPool()
+		runtimex.Assert(serverCert.pool.AppendCertsFromPEM(generated.CertPEM), "cannot add the certificate")
+	})
+	return serverCert.cert, serverCert.pool
+}
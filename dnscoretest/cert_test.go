@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscoretest
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificate(t *testing.T) {
+	cert, pool := certificate()
+	again, _ := certificate()
+	assert.Same(t, cert, again)
+
+	for _, name := range []string{"www.example.com", "127.0.0.1", "::1"} {
+		_, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: pool})
+		assert.NoError(t, err, name)
+	}
+	assert.True(t, cert.Leaf.NotBefore.Before(time.Now()))
+	assert.True(t, cert.Leaf.NotAfter.After(time.Now().Add(24*time.Hour)))
+}
@@ -1,4 +1,5 @@
 // SPDX-License-Identifier: GPL-3.0-or-later
 
-// Package dnscoretest contains fake servers to test dnscore.
+// Package dnscoretest contains fake servers and a fake transport to
+// test dnscore and applications using dnscore without network access.
 package dnscoretest
@@ -4,12 +4,14 @@ package dnscoretest
 
 import (
 	"crypto/tls"
-	"crypto/x509"
+	"encoding/base64"
 	"io"
 	"net/http"
 	"net/url"
 
 	"github.com/rbmk-project/common/runtimex"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // StartHTTPS starts an HTTPS server and handles incoming DNS queries.
@@ -19,12 +21,14 @@ func (s *Server) StartHTTPS(handler Handler) <-chan struct{} {
 	runtimex.Assert(!s.started, "already started")
 	ready := make(chan struct{})
 	go func() {
-		cert := runtimex.Try1(tls.X509KeyPair(certPEM, keyPEM))
-		config := &tls.Config{Certificates: []tls.Certificate{cert}}
+		cert, pool := certificate()
+		config := &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
 		listener := runtimex.Try1(s.listenTLS("tcp", "127.0.0.1:0", config))
 		s.Addr = listener.Addr().String()
-		s.RootCAs = x509.NewCertPool()
-		runtimex.Assert(s.RootCAs.AppendCertsFromPEM(certPEM), "cannot append PEM cert")
+		s.RootCAs = pool
 		s.URL = (&url.URL{Scheme: "https", Host: s.Addr, Path: "/dns-query"}).String()
 		s.ioclosers = append(s.ioclosers, listener)
 		s.started = true
@@ -37,9 +41,47 @@ func (s *Server) StartHTTPS(handler Handler) <-chan struct{} {
 	return ready
 }
 
+// StartHTTP starts a cleartext HTTP server, which supports both
+// HTTP/1.1 and HTTP/2 with prior knowledge (h2c), and handles
+// incoming DNS queries.
+//
+// This method panics in case of failure.
+func (s *Server) StartHTTP(handler Handler) <-chan struct{} {
+	runtimex.Assert(!s.started, "already started")
+	ready := make(chan struct{})
+	go func() {
+		listener := runtimex.Try1(s.listen("tcp", "127.0.0.1:0"))
+		s.Addr = listener.Addr().String()
+		s.URL = (&url.URL{Scheme: "http", Host: s.Addr, Path: "/dns-query"}).String()
+		s.ioclosers = append(s.ioclosers, listener)
+		s.started = true
+		srv := &http.Server{
+			Handler: h2c.NewHandler(newHTTPHandler(handler), &http2.Server{}),
+		}
+		close(ready)
+		_ = srv.Serve(listener)
+	}()
+	return ready
+}
+
+// newHTTPHandler returns an [http.Handler] reading the query from the
+// body of POST requests or from the dns parameter of GET requests.
 func newHTTPHandler(handler Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rawQuery := runtimex.Try1(io.ReadAll(r.Body))
+		var (
+			rawQuery []byte
+			err      error
+		)
+		switch r.Method {
+		case http.MethodGet:
+			rawQuery, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		default:
+			rawQuery, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		rw := &responseWriterHTTPS{w}
 		handler.Handle(rw, rawQuery)
 	})
@@ -28,7 +28,7 @@ func (s *Server) StartTCP(handler Handler) <-chan struct{} {
 			if err != nil {
 				return
 			}
-			s.serveConn(handler, conn)
+			go s.serveConn(handler, conn)
 		}
 	}()
 	return ready
@@ -42,22 +42,29 @@ func (s *Server) listen(network, address string) (net.Listener, error) {
 	return net.Listen(network, address)
 }
 
-// serveConn serves a single DNS query over TCP or TLS.
+// serveConn serves DNS queries over TCP or TLS until the client
+// closes the connection or sends an incomplete message.
 func (s *Server) serveConn(handler Handler, conn net.Conn) {
 	// Close the connection when done serving
 	defer conn.Close()
 
-	// Wrap the conn into a bufio.Reader and read the whole message
+	// Wrap the conn into a bufio.Reader and read each whole message
 	br := bufio.NewReader(conn)
-	header := make([]byte, 2)
-	_ = runtimex.Try1(io.ReadFull(br, header))
-	length := int(header[0])<<8 | int(header[1])
-	rawQuery := make([]byte, length)
-	_ = runtimex.Try1(io.ReadFull(br, rawQuery))
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return
+		}
+		length := int(header[0])<<8 | int(header[1])
+		rawQuery := make([]byte, length)
+		if _, err := io.ReadFull(br, rawQuery); err != nil {
+			return
+		}
 
-	// Wrap into a response writer and serve
-	rw := &responseWriterStream{conn: conn}
-	handler.Handle(rw, rawQuery)
+		// Wrap into a response writer and serve
+		rw := &responseWriterStream{conn: conn}
+		handler.Handle(rw, rawQuery)
+	}
 }
 
 // responseWriterStream is a response writer for TCP or TLS.
@@ -4,6 +4,7 @@ package dnscoretest_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"io"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/rbmk-project/common/runtimex"
+	"github.com/rbmk-project/dnscore"
 	"github.com/rbmk-project/dnscore/dnscoretest"
 	"github.com/stretchr/testify/assert"
 )
@@ -134,3 +136,63 @@ func TestFakeDNSServer_HTTPS(t *testing.T) {
 	// Validate the results
 	checkResult(t, resp, err)
 }
+
+func TestFakeDNSServer_HTTP(t *testing.T) {
+	// Create a fake cleartext HTTP server using the example.com handler
+	server := &dnscoretest.Server{}
+	handler := dnscoretest.NewExampleComHandler()
+	<-server.StartHTTP(handler)
+	defer server.Close()
+
+	// Query using dnscore with both HTTP/1.1 and h2c
+	txp := &dnscore.Transport{Insecure: true}
+	defer txp.Close()
+	for _, protocol := range []dnscore.Protocol{dnscore.ProtocolHTTP, dnscore.ProtocolH2C} {
+		addr := dnscore.NewServerAddr(protocol, server.URL)
+		query := runtimex.Try1(dnscore.NewQueryWithServerAddr(addr, "example.com", dns.TypeA))
+		resp, err := txp.Query(context.Background(), addr, query)
+
+		// Validate the results
+		checkResult(t, resp, err)
+	}
+}
+
+func TestFakeDNSServer_dnscoreTransport(t *testing.T) {
+	// Create a fake transport answering for example.com
+	fake := dnscoretest.NewTransport().AddAnswer(
+		"example.com", dns.TypeA, "example.com. 3600 IN A 93.184.215.14")
+
+	// Serve its rules using every server and query using dnscore
+	for _, entry := range []struct {
+		start    func(*dnscoretest.Server, dnscoretest.Handler) <-chan struct{}
+		protocol dnscore.Protocol
+		useURL   bool
+	}{
+		{(*dnscoretest.Server).StartUDP, dnscore.ProtocolUDP, false},
+		{(*dnscoretest.Server).StartTCP, dnscore.ProtocolTCP, false},
+		{(*dnscoretest.Server).StartTLS, dnscore.ProtocolDoT, false},
+		{(*dnscoretest.Server).StartHTTPS, dnscore.ProtocolDoH, true},
+	} {
+		t.Run(string(entry.protocol), func(t *testing.T) {
+			server := &dnscoretest.Server{}
+			<-entry.start(server, fake.Handler())
+			defer server.Close()
+
+			address := server.Addr
+			if entry.useURL {
+				address = server.URL
+			}
+			txp := &dnscore.Transport{
+				HTTPClient: &http.Client{Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{RootCAs: server.RootCAs},
+				}},
+				RootCAs: server.RootCAs,
+			}
+			defer txp.Close()
+			addr := dnscore.NewServerAddr(entry.protocol, address)
+			query := runtimex.Try1(dnscore.NewQueryWithServerAddr(addr, "example.com", dns.TypeA))
+			resp, err := txp.Query(context.Background(), addr, query)
+			checkResult(t, resp, err)
+		})
+	}
+}
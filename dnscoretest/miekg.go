@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscoretest
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// connAddrs is implemented by the [ResponseWriter] implementations that
+// know the endpoints of the underlying connection.
+type connAddrs interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// LocalAddr implements connAddrs.
+func (r *responseWriterUDP) LocalAddr() net.Addr {
+	return r.pconn.LocalAddr()
+}
+
+// RemoteAddr implements connAddrs.
+func (r *responseWriterUDP) RemoteAddr() net.Addr {
+	return r.addr
+}
+
+// LocalAddr implements connAddrs.
+func (r *responseWriterStream) LocalAddr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// RemoteAddr implements connAddrs.
+func (r *responseWriterStream) RemoteAddr() net.Addr {
+	return r.conn.RemoteAddr()
+}
+
+// NewHandlerFromMiekg returns a [Handler] that parses the raw query and
+// serves it using the given [dns.Handler], which allows reusing handlers
+// written for [github.com/miekg/dns] servers with the fake servers.
+//
+// The handler ignores queries that cannot be parsed.
+func NewHandlerFromMiekg(handler dns.Handler) Handler {
+	return HandlerFunc(func(rw ResponseWriter, rawQuery []byte) {
+		query := &dns.Msg{}
+		if err := query.Unpack(rawQuery); err != nil {
+			return
+		}
+		handler.ServeDNS(&miekgResponseWriter{rw}, query)
+	})
+}
+
+// miekgResponseWriter adapts a [ResponseWriter] to [dns.ResponseWriter].
+type miekgResponseWriter struct {
+	rw ResponseWriter
+}
+
+// Ensure miekgResponseWriter implements dns.ResponseWriter.
+var _ dns.ResponseWriter = &miekgResponseWriter{}
+
+// LocalAddr implements dns.ResponseWriter.
+func (w *miekgResponseWriter) LocalAddr() net.Addr {
+	if addrs, ok := w.rw.(connAddrs); ok {
+		return addrs.LocalAddr()
+	}
+	return &net.TCPAddr{}
+}
+
+// RemoteAddr implements dns.ResponseWriter.
+func (w *miekgResponseWriter) RemoteAddr() net.Addr {
+	if addrs, ok := w.rw.(connAddrs); ok {
+		return addrs.RemoteAddr()
+	}
+	return &net.TCPAddr{}
+}
+
+// WriteMsg implements dns.ResponseWriter.
+func (w *miekgResponseWriter) WriteMsg(msg *dns.Msg) error {
+	rawMsg, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.rw.Write(rawMsg)
+	return err
+}
+
+// Write implements dns.ResponseWriter.
+func (w *miekgResponseWriter) Write(rawMsg []byte) (int, error) {
+	return w.rw.Write(rawMsg)
+}
+
+// Close implements dns.ResponseWriter.
+func (w *miekgResponseWriter) Close() error {
+	return nil
+}
+
+// TsigStatus implements dns.ResponseWriter.
+func (w *miekgResponseWriter) TsigStatus() error {
+	return nil
+}
+
+// TsigTimersOnly implements dns.ResponseWriter.
+func (w *miekgResponseWriter) TsigTimersOnly(bool) {}
+
+// Hijack implements dns.ResponseWriter.
+func (w *miekgResponseWriter) Hijack() {}
+
+// NewMiekgHandler returns a [dns.Handler] that serves queries using
+// the given [Handler], which allows using handlers written for the fake
+// servers with [github.com/miekg/dns] servers.
+func NewMiekgHandler(handler Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+		rawQuery, err := query.Pack()
+		if err != nil {
+			return
+		}
+		handler.Handle(w, rawQuery)
+	})
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscoretest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHandlerFromMiekg(t *testing.T) {
+	// miekgHandler answers with the client network and address
+	miekgHandler := dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(query)
+		resp.Answer = append(resp.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+			Txt: []string{w.LocalAddr().Network(), w.LocalAddr().String(), w.RemoteAddr().String()},
+		})
+		assert.NoError(t, w.WriteMsg(resp))
+		assert.NoError(t, w.Close())
+		assert.NoError(t, w.TsigStatus())
+	})
+	handler := NewHandlerFromMiekg(miekgHandler)
+
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			server := &Server{}
+			defer server.Close()
+			switch network {
+			case "udp":
+				<-server.StartUDP(handler)
+			default:
+				<-server.StartTCP(handler)
+			}
+			client := &dns.Client{Net: network}
+			query := new(dns.Msg)
+			query.SetQuestion("example.com.", dns.TypeTXT)
+			resp, _, err := client.Exchange(query, server.Addr)
+			assert.NoError(t, err)
+			assert.Len(t, resp.Answer, 1)
+			txt := resp.Answer[0].(*dns.TXT).Txt
+			assert.Equal(t, network, txt[0])
+			assert.Equal(t, server.Addr, txt[1])
+			assert.NotEqual(t, net.JoinHostPort("0.0.0.0", "0"), txt[2])
+		})
+	}
+
+	t.Run("without connection addresses", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		w := &miekgResponseWriter{buf}
+		assert.Equal(t, &net.TCPAddr{}, w.LocalAddr())
+		assert.Equal(t, &net.TCPAddr{}, w.RemoteAddr())
+		count, err := w.Write([]byte{1, 2, 3})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, []byte{1, 2, 3}, buf.Bytes())
+	})
+
+	t.Run("ignores invalid queries", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		handler.Handle(buf, []byte{1, 2, 3})
+		assert.Zero(t, buf.Len())
+	})
+}
+
+func TestNewMiekgHandler(t *testing.T) {
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pconn,
+		Handler:           NewMiekgHandler(NewExampleComHandler()),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	<-started
+
+	client := &dns.Client{Net: "udp"}
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	resp, _, err := client.Exchange(query, pconn.LocalAddr().String())
+	assert.NoError(t, err)
+	assert.Len(t, resp.Answer, 1)
+	assert.Equal(t, ExampleComAddrA.String(), resp.Answer[0].(*dns.A).A.String())
+}
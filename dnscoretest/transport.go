@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/rbmk-project/dnscore"
+)
+
+// ErrNoMatchingRule is the error returned by [*Transport] when
+// none of its rules matches the question of a query.
+var ErrNoMatchingRule = errors.New("dnscoretest: no matching rule")
+
+// Rule tells [*Transport] how to respond to matching queries.
+//
+// The zero value matches every query and returns an empty
+// NOERROR response, which is seldom what you want.
+type Rule struct {
+	// Name is the optional name to match, compared without
+	// considering the case and the trailing dot.
+	Name string
+
+	// Type is the optional query type to match.
+	Type uint16
+
+	// Server is the optional server address to match.
+	Server string
+
+	// Delay is the optional delay before responding, which
+	// is interrupted when the context is done.
+	Delay time.Duration
+
+	// Err is the optional error to return.
+	Err error
+
+	// Rcode is the response code to use.
+	Rcode int
+
+	// Answer, Ns, and Extra contain the records to copy
+	// into the corresponding sections of the response.
+	Answer, Ns, Extra []dns.RR
+
+	// Handler is the optional function generating the response,
+	// which takes precedence over Err, Rcode, and the records.
+	Handler func(ctx context.Context, addr *dnscore.ServerAddr, query *dns.Msg) (*dns.Msg, error)
+}
+
+// match returns whether the rule matches the query sent to addr.
+func (r *Rule) match(addr *dnscore.ServerAddr, q0 dns.Question) bool {
+	if r.Name != "" && !strings.EqualFold(dns.Fqdn(r.Name), q0.Name) {
+		return false
+	}
+	if r.Type != 0 && r.Type != q0.Qtype {
+		return false
+	}
+	return r.Server == "" || r.Server == addr.Address
+}
+
+// respond generates the response to the query.
+func (r *Rule) respond(ctx context.Context, addr *dnscore.ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. honour the delay
+	if r.Delay > 0 {
+		timer := time.NewTimer(r.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// 2. use the handler or the canned response
+	if r.Handler != nil {
+		return r.Handler(ctx, addr, query)
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	resp := &dns.Msg{}
+	resp.SetRcode(query, r.Rcode)
+	resp.RecursionAvailable = true
+	for _, rr := range r.Answer {
+		resp.Answer = append(resp.Answer, dns.Copy(rr))
+	}
+	for _, rr := range r.Ns {
+		resp.Ns = append(resp.Ns, dns.Copy(rr))
+	}
+	for _, rr := range r.Extra {
+		resp.Extra = append(resp.Extra, dns.Copy(rr))
+	}
+	return resp, nil
+}
+
+// Transport is a fake [dnscore.ResolverTransport] that responds to
+// queries using the first matching [*Rule] without using the network,
+// which allows to unit test code using [*dnscore.Resolver] and other
+// types that depend on a transport.
+//
+// The zero value is ready to use but has no rules.
+type Transport struct {
+	// Rules contains the rules in order of precedence. You should
+	// not modify this field while the transport is in use; use
+	// [*Transport.Add] to add rules concurrently.
+	Rules []*Rule
+
+	// mu provides mutual exclusion.
+	mu sync.Mutex
+
+	// queries contains the queries we received.
+	queries []*dns.Msg
+}
+
+// Ensure that [*Transport] implements [dnscore.ResolverTransport].
+var _ dnscore.ResolverTransport = (*Transport)(nil)
+
+// NewTransport creates a new [*Transport] with the given rules.
+func NewTransport(rules ...*Rule) *Transport {
+	return &Transport{Rules: rules}
+}
+
+// Add adds a rule with lower precedence than the existing ones.
+func (t *Transport) Add(rule *Rule) *Transport {
+	t.mu.Lock()
+	t.Rules = append(t.Rules, rule)
+	t.mu.Unlock()
+	return t
+}
+
+// AddAnswer is a convenience method for adding a rule responding
+// to name and qtype with the given records in presentation format.
+//
+// This method panics if the records cannot be parsed.
+func (t *Transport) AddAnswer(name string, qtype uint16, records ...string) *Transport {
+	rule := &Rule{Name: name, Type: qtype}
+	for _, record := range records {
+		rule.Answer = append(rule.Answer, MustNewRR(record))
+	}
+	return t.Add(rule)
+}
+
+// Queries returns a copy of the queries received so far.
+func (t *Transport) Queries() []*dns.Msg {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*dns.Msg{}, t.queries...)
+}
+
+// Query implements [dnscore.ResolverTransport].
+func (t *Transport) Query(ctx context.Context, addr *dnscore.ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. immediately fail if the context is already done
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// 2. record the query and make sure it is well formed
+	t.mu.Lock()
+	t.queries = append(t.queries, query.Copy())
+	rules := t.Rules
+	t.mu.Unlock()
+	if len(query.Question) != 1 {
+		return nil, dnscore.ErrInvalidQuery
+	}
+
+	// 3. find the first matching rule and respond
+	q0 := query.Question[0]
+	for _, rule := range rules {
+		if rule.match(addr, q0) {
+			return rule.respond(ctx, addr, query)
+		}
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoMatchingRule, q0.Name, dns.TypeToString[q0.Qtype])
+}
+
+// Handler returns a [Handler] that responds to queries using the rules,
+// which allows [*Server] to serve programmable responses and exercise the
+// real client code paths. Since the handler does not know the address the
+// client used, rules with a non-empty Server field never match. The handler
+// responds with SERVFAIL when no rule matches or the matching rule fails
+// and does not respond at all when it cannot parse the query.
+func (t *Transport) Handler() Handler {
+	return HandlerFunc(func(rw ResponseWriter, rawQuery []byte) {
+		query := &dns.Msg{}
+		if err := query.Unpack(rawQuery); err != nil {
+			return
+		}
+		resp, err := t.Query(context.Background(), &dnscore.ServerAddr{}, query)
+		if err != nil {
+			resp = &dns.Msg{}
+			resp.SetRcode(query, dns.RcodeServerFailure)
+		}
+		if rawResp, err := resp.Pack(); err == nil {
+			_, _ = rw.Write(rawResp)
+		}
+	})
+}
+
+// MustNewRR parses a record in presentation format.
+//
+// This function panics if the record cannot be parsed.
+func MustNewRR(record string) dns.RR {
+	rr := runtimex.Try1(dns.NewRR(record))
+	runtimex.Assert(rr != nil, "empty record")
+	return rr
+}
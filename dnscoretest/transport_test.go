@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscoretest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/dnscore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	addr := dnscore.NewServerAddr(dnscore.ProtocolUDP, "8.8.8.8:53")
+	newQuery := func(name string, qtype uint16) *dns.Msg {
+		query := &dns.Msg{}
+		query.SetQuestion(dns.Fqdn(name), qtype)
+		return query
+	}
+
+	t.Run("canned answers", func(t *testing.T) {
+		txp := NewTransport().AddAnswer("Example.COM", dns.TypeA, "example.com. 300 IN A 93.184.215.14")
+		query := newQuery("example.com", dns.TypeA)
+		resp, err := txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		assert.NoError(t, dnscore.ValidateResponse(query, resp))
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, 1)
+		assert.Len(t, txp.Queries(), 1)
+
+		_, err = txp.Query(context.Background(), addr, newQuery("example.com", dns.TypeAAAA))
+		assert.ErrorIs(t, err, ErrNoMatchingRule)
+	})
+
+	t.Run("rules are matched in order", func(t *testing.T) {
+		txp := NewTransport(
+			&Rule{Server: "1.1.1.1:53", Err: expectedErr},
+			&Rule{Name: "nx.example.com", Rcode: dns.RcodeNameError},
+			&Rule{Handler: func(ctx context.Context, addr *dnscore.ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				resp := &dns.Msg{}
+				resp.SetRcode(query, dns.RcodeServerFailure)
+				return resp, nil
+			}},
+		)
+		other := dnscore.NewServerAddr(dnscore.ProtocolUDP, "1.1.1.1:53")
+		_, err := txp.Query(context.Background(), other, newQuery("nx.example.com", dns.TypeA))
+		assert.ErrorIs(t, err, expectedErr)
+
+		resp, err := txp.Query(context.Background(), addr, newQuery("nx.example.com", dns.TypeA))
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+
+		resp, err = txp.Query(context.Background(), addr, newQuery("www.example.com", dns.TypeA))
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeServerFailure, resp.Rcode)
+	})
+
+	t.Run("delays honour the context", func(t *testing.T) {
+		txp := NewTransport(&Rule{Delay: time.Hour})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := txp.Query(ctx, addr, newQuery("example.com", dns.TypeA))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		_, err = txp.Query(ctx, addr, newQuery("example.com", dns.TypeA))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		txp = NewTransport(&Rule{Delay: time.Millisecond})
+		_, err = txp.Query(context.Background(), addr, newQuery("example.com", dns.TypeA))
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		txp := NewTransport(&Rule{})
+		_, err := txp.Query(context.Background(), addr, &dns.Msg{})
+		assert.ErrorIs(t, err, dnscore.ErrInvalidQuery)
+	})
+
+	t.Run("with a resolver", func(t *testing.T) {
+		txp := NewTransport().
+			AddAnswer("www.example.com", dns.TypeA,
+				"www.example.com. 300 IN CNAME example.com.",
+				"example.com. 300 IN A 93.184.215.14").
+			Add(&Rule{Name: "www.example.com", Type: dns.TypeAAAA})
+		reso := &dnscore.Resolver{Transport: txp}
+		addrs, err := reso.LookupHost(context.Background(), "www.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"93.184.215.14"}, addrs)
+	})
+
+	t.Run("MustNewRR", func(t *testing.T) {
+		rr := MustNewRR("example.com. 300 IN A 93.184.215.14")
+		assert.True(t, rr.(*dns.A).A.Equal(net.IPv4(93, 184, 215, 14)))
+		assert.Panics(t, func() { MustNewRR("") })
+		assert.Panics(t, func() { MustNewRR("example.com. IN A x") })
+	})
+}
+
+func TestTransport_Handler(t *testing.T) {
+	txp := NewTransport(
+		&Rule{Name: "example.com", Type: dns.TypeA, Answer: []dns.RR{MustNewRR("example.com. 300 IN A 93.184.215.14")}},
+		&Rule{Name: "www.example.com", Err: errors.New("mocked error")},
+	)
+	handler := txp.Handler()
+	exchange := func(rawQuery []byte) *dns.Msg {
+		var buf bytes.Buffer
+		handler.Handle(&buf, rawQuery)
+		if buf.Len() <= 0 {
+			return nil
+		}
+		resp := &dns.Msg{}
+		assert.NoError(t, resp.Unpack(buf.Bytes()))
+		return resp
+	}
+	pack := func(name string) []byte {
+		query := &dns.Msg{}
+		query.SetQuestion(name, dns.TypeA)
+		rawQuery, _ := query.Pack()
+		return rawQuery
+	}
+
+	resp := exchange(pack("example.com."))
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Len(t, resp.Answer, 1)
+	assert.Equal(t, dns.RcodeServerFailure, exchange(pack("www.example.com.")).Rcode)
+	assert.Equal(t, dns.RcodeServerFailure, exchange(pack("nx.example.com.")).Rcode)
+	assert.Nil(t, exchange([]byte{0}))
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrUnsupportedAlgorithm indicates that we do not support a DNSSEC algorithm.
+var ErrUnsupportedAlgorithm = errors.New("unsupported DNSSEC algorithm")
+
+// GenerateZoneSigningKey generates a new [*ZoneSigningKey] for the given zone
+// using the given algorithm, which is either [dns.ECDSAP256SHA256] or
+// [dns.ED25519], and the given DNSKEY flags, which usually are [dns.ZONE] for
+// zone signing keys and [dns.ZONE] | [dns.SEP] for key signing keys.
+func GenerateZoneSigningKey(zone string, algorithm uint8, flags uint16) (*ZoneSigningKey, error) {
+	// 1. make sure we support the algorithm
+	var bits int
+	switch algorithm {
+	case dns.ECDSAP256SHA256, dns.ED25519:
+		bits = 256
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	// 2. generate the key
+	dnskey := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   dns.CanonicalName(zone),
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: algorithm,
+	}
+	priv, err := dnskey.Generate(bits)
+	if err != nil {
+		return nil, err
+	}
+	return &ZoneSigningKey{DNSKEY: dnskey, Signer: priv.(crypto.Signer)}, nil
+}
+
+// DS returns the DS record for the key using [NewDS].
+func (k *ZoneSigningKey) DS(digestType uint8) (*dns.DS, error) {
+	return NewDS(k.DNSKEY, digestType)
+}
+
+// SignRRset creates the RRSIG covering the given RRset using the given key,
+// whose owner is the signer name, and the given signature validity period.
+func SignRRset(key *ZoneSigningKey, rrset []dns.RR, inception, expiration time.Time) (*dns.RRSIG, error) {
+	if len(rrset) <= 0 {
+		return nil, errors.New("cannot sign an empty RRset")
+	}
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.DNSKEY.Algorithm,
+		Expiration: uint32(expiration.Unix()),
+		Inception:  uint32(inception.Unix()),
+		KeyTag:     key.DNSKEY.KeyTag(),
+		SignerName: dns.CanonicalName(key.DNSKEY.Hdr.Name),
+	}
+	if err := sig.Sign(key.Signer, rrset); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// NewNSECChain builds the NSEC chain (RFC 4034) for the given authoritative
+// RRs, which must not include glue and the NS RRsets of delegations, using
+// the given TTL. The chain is in canonical order and wraps around to the
+// first name, which should be the zone apex. We ignore existing NSECs and
+// include RRSIG in the type bitmaps, assuming the caller signs the RRsets.
+func NewNSECChain(rrs []dns.RR, ttl uint32) []*dns.NSEC {
+	types := dnssecTypesByName(rrs, dns.TypeNSEC)
+	names := slices.SortedFunc(maps.Keys(types), zoneCanonicalCompare)
+	nsecs := make([]*dns.NSEC, 0, len(names))
+	for idx, name := range names {
+		nsecs = append(nsecs, &dns.NSEC{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeNSEC,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			NextDomain: names[(idx+1)%len(names)],
+			TypeBitMap: dnssecBitmap(types[name], dns.TypeNSEC),
+		})
+	}
+	return nsecs
+}
+
+// NewNSEC3Chain is like [NewNSECChain] but builds the NSEC3 chain (RFC 5155)
+// of the given zone using SHA-1, the given number of additional iterations,
+// and the given hex-encoded salt. RFC 9276 recommends zero iterations and
+// an empty salt. We add the NSEC3 records of the empty non-terminals and we
+// do not use opt-out. Remember to add the matching NSEC3PARAM to the apex.
+func NewNSEC3Chain(zone string, rrs []dns.RR, ttl uint32, iterations uint16, salt string) []*dns.NSEC3 {
+	// 1. collect the types of the names, including the empty non-terminals
+	zone = dns.CanonicalName(zone)
+	types := dnssecTypesByName(rrs, dns.TypeNSEC3)
+	for name := range maps.Clone(types) {
+		for name != zone && name != "." {
+			name = zoneParentName(name)
+			if _, found := types[name]; !found {
+				types[name] = nil
+			}
+		}
+	}
+
+	// 2. hash the names and sort the hashes
+	hashes := make(map[string]string)
+	for name := range types {
+		hashes[dns.HashName(name, dns.SHA1, iterations, salt)] = name
+	}
+	sorted := slices.Sorted(maps.Keys(hashes))
+
+	// 3. build the chain, which wraps around to the first hash, using the
+	// canonical owner names and the uppercase next hashed owner names
+	nsec3s := make([]*dns.NSEC3, 0, len(sorted))
+	for idx, hash := range sorted {
+		var bitmap []uint16
+		if name := hashes[hash]; len(types[name]) > 0 {
+			bitmap = dnssecBitmap(types[name], 0)
+		}
+		next := sorted[(idx+1)%len(sorted)]
+		nsec3s = append(nsec3s, &dns.NSEC3{
+			Hdr: dns.RR_Header{
+				Name:   strings.ToLower(hash) + "." + zone,
+				Rrtype: dns.TypeNSEC3,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Hash:       dns.SHA1,
+			Iterations: iterations,
+			SaltLength: uint8(len(salt) / 2),
+			Salt:       salt,
+			HashLength: uint8(len(next) * 5 / 8),
+			NextDomain: next,
+			TypeBitMap: bitmap,
+		})
+	}
+	return nsec3s
+}
+
+// dnssecTypesByName maps the canonical owner names of the given RRs to the
+// types they own, ignoring RRSIGs and the RRs with the given type.
+func dnssecTypesByName(rrs []dns.RR, ignored uint16) map[string][]uint16 {
+	types := make(map[string][]uint16)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG || hdr.Rrtype == ignored {
+			continue
+		}
+		name := dns.CanonicalName(hdr.Name)
+		types[name] = append(types[name], hdr.Rrtype)
+	}
+	return types
+}
+
+// dnssecBitmap returns the sorted and deduplicated type bitmap containing the
+// given types, RRSIG, and the given extra type, unless it is zero.
+func dnssecBitmap(types []uint16, extra uint16) []uint16 {
+	bitmap := append(slices.Clone(types), dns.TypeRRSIG)
+	if extra != 0 {
+		bitmap = append(bitmap, extra)
+	}
+	slices.Sort(bitmap)
+	return slices.Compact(bitmap)
+}
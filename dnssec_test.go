@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// dnssecTestRecords contains the authoritative RRs of a small zone.
+var dnssecTestRecords = []string{
+	"example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 300",
+	"example.com. 3600 IN NS ns.example.com.",
+	"ns.example.com. 3600 IN A 192.0.2.1",
+	"www.example.com. 3600 IN A 192.0.2.2",
+	"www.example.com. 3600 IN AAAA 2001:db8::2",
+	"a.b.c.example.com. 3600 IN TXT \"deep\"",
+}
+
+func TestGenerateZoneSigningKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm uint8
+		err       error
+	}{{
+		name:      "ECDSA P-256",
+		algorithm: dns.ECDSAP256SHA256,
+	}, {
+		name:      "Ed25519",
+		algorithm: dns.ED25519,
+	}, {
+		name:      "RSA",
+		algorithm: dns.RSASHA256,
+		err:       ErrUnsupportedAlgorithm,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := GenerateZoneSigningKey("Example.COM", tt.algorithm, dns.ZONE|dns.SEP)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				assert.Nil(t, key)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "example.com.", key.DNSKEY.Hdr.Name)
+			assert.Equal(t, uint16(dns.ZONE|dns.SEP), key.DNSKEY.Flags)
+			assert.Equal(t, tt.algorithm, key.DNSKEY.Algorithm)
+
+			// the key signs RRsets that validate using the DNSKEY
+			rrs := parseRRs(t, dnssecTestRecords...)[3:5]
+			now := time.Now()
+			sig, err := SignRRset(key, rrs[:1], now.Add(-time.Hour), now.Add(time.Hour))
+			assert.NoError(t, err)
+			assert.Equal(t, "example.com.", sig.SignerName)
+			assert.Equal(t, key.DNSKEY.KeyTag(), sig.KeyTag)
+			assert.Equal(t, uint16(dns.TypeA), sig.TypeCovered)
+			assert.NoError(t, sig.Verify(key.DNSKEY, rrs[:1]))
+			assert.True(t, sig.ValidityPeriod(now))
+			assert.Error(t, sig.Verify(key.DNSKEY, rrs[1:]))
+		})
+	}
+}
+
+func TestSignRRset_empty(t *testing.T) {
+	key, err := GenerateZoneSigningKey("example.com", dns.ED25519, dns.ZONE)
+	assert.NoError(t, err)
+	_, err = SignRRset(key, nil, time.Now(), time.Now())
+	assert.Error(t, err)
+}
+
+func TestZoneSigningKey_DS(t *testing.T) {
+	key, err := GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)
+	assert.NoError(t, err)
+
+	ds, err := key.DS(dns.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com.", ds.Hdr.Name)
+	assert.Equal(t, key.DNSKEY.KeyTag(), ds.KeyTag)
+	assert.Equal(t, uint8(dns.ECDSAP256SHA256), ds.Algorithm)
+	assert.Len(t, ds.Digest, 64)
+
+	_, err = key.DS(42)
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestNewNSECChain(t *testing.T) {
+	nsecs := NewNSECChain(parseRRs(t, dnssecTestRecords...), 300)
+	var got [][3]any
+	for _, nsec := range nsecs {
+		assert.Equal(t, uint32(300), nsec.Hdr.Ttl)
+		got = append(got, [3]any{nsec.Hdr.Name, nsec.NextDomain, nsec.TypeBitMap})
+	}
+	assert.Equal(t, [][3]any{
+		{"example.com.", "a.b.c.example.com.", []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC}},
+		{"a.b.c.example.com.", "ns.example.com.", []uint16{dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}},
+		{"ns.example.com.", "www.example.com.", []uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC}},
+		{"www.example.com.", "example.com.", []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC}},
+	}, got)
+}
+
+func TestNewNSEC3Chain(t *testing.T) {
+	nsec3s := NewNSEC3Chain("example.com", parseRRs(t, dnssecTestRecords...), 300, 1, "AABB")
+
+	// 1. we have one NSEC3 for each name, including the empty non-terminals
+	names := []string{"example.com.", "ns.example.com.", "www.example.com.",
+		"a.b.c.example.com.", "b.c.example.com.", "c.example.com."}
+	assert.Len(t, nsec3s, len(names))
+	for idx, nsec3 := range nsec3s {
+		assert.Equal(t, uint8(2), nsec3.SaltLength)
+		assert.Equal(t, uint8(20), nsec3.HashLength)
+		next := nsec3s[(idx+1)%len(nsec3s)]
+		assert.Equal(t, next.Hdr.Name, strings.ToLower(nsec3.NextDomain)+".example.com.")
+	}
+
+	// matching returns the NSEC3 matching the given name.
+	matching := func(name string) (matches []*dns.NSEC3) {
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(name) {
+				matches = append(matches, nsec3)
+			}
+		}
+		return
+	}
+	for _, name := range names {
+		assert.Len(t, matching(name), 1, name)
+	}
+
+	// 2. the type bitmaps are correct and empty for empty non-terminals
+	assert.Equal(t, []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG}, matching("example.com.")[0].TypeBitMap)
+	assert.Equal(t, []uint16{dns.TypeTXT, dns.TypeRRSIG}, matching("a.b.c.example.com.")[0].TypeBitMap)
+	assert.Empty(t, matching("c.example.com.")[0].TypeBitMap)
+
+	// 3. exactly one NSEC3 covers a nonexistent name
+	var covering int
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover("nonexistent.example.com.") {
+			covering++
+		}
+	}
+	assert.Equal(t, 1, covering)
+	assert.Empty(t, matching("nonexistent.example.com."))
+}
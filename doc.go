@@ -28,6 +28,12 @@ DNS messages.
 
 - Handling of duplicate responses for DNS over UDP to measure censorship.
 
+- Iterative resolution from the root servers using [*IterativeResolver].
+
+- Latency and loss measurements of DNS servers using [*Pinger].
+
+- Recording and replaying exchanges using [*RecordingTransport] and [*ReplayTransport].
+
 The package is structured to allow users to compose their own workflows
 by providing building blocks for DNS queries and responses. It uses
 the widely-used [github.com/miekg/dns] library for DNS message parsing
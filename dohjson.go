@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/httpslog"
+)
+
+// dohJSONMaxResponseSize is the maximum size of a JSON response body.
+const dohJSONMaxResponseSize = 1 << 20
+
+// dohJSONQuestion is a question in the JSON API response.
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// dohJSONRecord is a record in the JSON API response.
+type dohJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// dohJSONResponse is the response of the JSON API used by
+// Google (https://developers.google.com/speed/public-dns/docs/doh/json)
+// and Cloudflare (https://developers.cloudflare.com/1.1.1.1/encryption/dns-over-https/make-api-requests/dns-json/).
+type dohJSONResponse struct {
+	Status     int               `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AD         bool              `json:"AD"`
+	CD         bool              `json:"CD"`
+	Question   []dohJSONQuestion `json:"Question"`
+	Answer     []dohJSONRecord   `json:"Answer"`
+	Authority  []dohJSONRecord   `json:"Authority"`
+	Additional []dohJSONRecord   `json:"Additional"`
+}
+
+// newDoHJSONURL returns the URL to query the JSON API at the given
+// address for the question contained inside the query.
+func newDoHJSONURL(address string, query *dns.Msg) (string, error) {
+	if len(query.Question) != 1 {
+		return "", ErrInvalidQuery
+	}
+	URL, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+	q0 := query.Question[0]
+	values := URL.Query()
+	values.Set("name", q0.Name)
+	values.Set("type", strconv.Itoa(int(q0.Qtype)))
+	if opt := query.IsEdns0(); opt != nil && opt.Do() {
+		values.Set("do", "1")
+	}
+	if query.CheckingDisabled {
+		values.Set("cd", "1")
+	}
+	URL.RawQuery = values.Encode()
+	return URL.String(), nil
+}
+
+// newMsgFromDoHJSON converts a JSON API response body to a [*dns.Msg]
+// that answers the given query. Records whose data we cannot parse cause
+// the conversion to fail with [ErrServerMisbehaving].
+func newMsgFromDoHJSON(query *dns.Msg, body []byte) (*dns.Msg, error) {
+	// 1. parse the JSON body
+	var jresp dohJSONResponse
+	if err := json.Unmarshal(body, &jresp); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotUnmarshalMessage, err.Error())
+	}
+
+	// 2. fill the message header and question
+	resp := &dns.Msg{}
+	resp.Id = query.Id
+	resp.Response = true
+	resp.Opcode = dns.OpcodeQuery
+	resp.Rcode = jresp.Status
+	resp.Truncated = jresp.TC
+	resp.RecursionDesired = jresp.RD
+	resp.RecursionAvailable = jresp.RA
+	resp.AuthenticatedData = jresp.AD
+	resp.CheckingDisabled = jresp.CD
+	for _, q := range jresp.Question {
+		resp.Question = append(resp.Question, dns.Question{
+			Name: dns.Fqdn(q.Name), Qtype: q.Type, Qclass: dns.ClassINET})
+	}
+
+	// 3. convert the records
+	convert := func(records []dohJSONRecord) (rrs []dns.RR, err error) {
+		for _, record := range records {
+			rrtype, ok := dns.TypeToString[record.Type]
+			if !ok {
+				rrtype = "TYPE" + strconv.Itoa(int(record.Type))
+			}
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s",
+				dns.Fqdn(record.Name), record.TTL, rrtype, record.Data))
+			if err != nil || rr == nil {
+				return nil, fmt.Errorf("%w: cannot parse %s record: %q",
+					ErrServerMisbehaving, rrtype, record.Data)
+			}
+			rrs = append(rrs, rr)
+		}
+		return
+	}
+	var err error
+	if resp.Answer, err = convert(jresp.Answer); err != nil {
+		return nil, err
+	}
+	if resp.Ns, err = convert(jresp.Authority); err != nil {
+		return nil, err
+	}
+	if resp.Extra, err = convert(jresp.Additional); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// isDoHJSONContentType returns whether the content type is one of
+// the content types used by JSON API servers.
+func isDoHJSONContentType(value string) bool {
+	mediatype, _, err := mime.ParseMediaType(value)
+	return err == nil && (mediatype == "application/dns-json" || mediatype == "application/json")
+}
+
+// queryDoHJSON implements [*Transport.Query] for [ProtocolDoHJSON].
+func (t *Transport) queryDoHJSON(ctx context.Context,
+	addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 0. immediately fail if the context is already done, which
+	// is useful to write unit tests
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// 1. Serialize the query for logging and create the URL. We do not
+	// send the raw query, but logging it allows to use the same data format
+	// we use for the other protocols, which is useful for measurements.
+	rawQuery, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	URL, err := newDoHJSONURL(dohURL(addr), query)
+	if err != nil {
+		return nil, err
+	}
+	t0 := t.maybeLogQuery(ctx, addr, rawQuery)
+	tracer := queryTracerFromContext(ctx)
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.RawQuery = rawQuery })
+
+	// 2. The query is sent using GET and the accept header.
+	req, err := t.newHTTPRequestWithContext(ctx, "GET", URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setDoHRequestHeaders(addr, req)
+	req.Header.Set("accept", "application/dns-json")
+	req = t.withClientTrace(addr, tracer, rawQuery, req)
+
+	// 3. Perform the HTTP round trip and log it.
+	httpslog.MaybeLogRoundTripStart(
+		t.logger(ctx),
+		netip.MustParseAddrPort("[::]:0"), // not yet known
+		"tcp",
+		netip.MustParseAddrPort("[::]:0"), // not yet known
+		redactedHTTPRequest(req),
+		t0,
+	)
+	httpResp, laddr, raddr, err := t.httpClientDo(addr, req)
+	httpslog.MaybeLogRoundTripDone(
+		t.logger(ctx),
+		laddr,
+		"tcp",
+		raddr,
+		redactedHTTPRequest(req),
+		httpResp,
+		err,
+		t0,
+		t.timeNow(),
+	)
+
+	// 4. Make sure we close the body, the response code is 200,
+	// and the content type is one of the expected ones.
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	t.stats.onSent(addr, len(URL))
+	if err := t.checkHTTPResponse(httpResp, isDoHJSONContentType); err != nil {
+		return nil, err
+	}
+
+	// 5. Read and convert the JSON body. We store the JSON body as
+	// the raw response, while we log the converted response.
+	body, err := t.readHTTPResponseBody(ctx, httpResp, dohJSONMaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	tracer.stamp(func(info *QueryInfo, now time.Time) {
+		info.LastByte = now
+		info.LocalAddr, info.RemoteAddr = laddr, raddr
+		info.RawResponse = body
+	})
+	resp, err := newMsgFromDoHJSON(query, body)
+	if err != nil {
+		return nil, err
+	}
+	t.stats.onResponse(addr, len(body), resp.Rcode)
+	if rawResp, err := resp.Pack(); err == nil {
+		t.maybeCapture(addr, raddr, laddr, rawResp)
+		t.maybeLogResponseAddrPort(ctx, addr, t0, rawQuery, rawResp, laddr, raddr)
+	}
+	return resp, nil
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// dohJSONTestBody is a JSON API response for www.example.com.
+const dohJSONTestBody = `{
+  "Status": 0, "TC": false, "RD": true, "RA": true, "AD": true, "CD": false,
+  "Question": [{"name": "www.example.com.", "type": 1}],
+  "Answer": [
+    {"name": "www.example.com.", "type": 5, "TTL": 300, "data": "example.com."},
+    {"name": "example.com", "type": 1, "TTL": 300, "data": "93.184.215.14"}
+  ],
+  "Authority": [
+    {"name": "example.com.", "type": 6, "TTL": 300,
+     "data": "ns.icann.org. noc.dns.icann.org. 2024081402 7200 3600 1209600 3600"}
+  ],
+  "Comment": "Response from 199.43.135.53."
+}`
+
+func Test_newDoHJSONURL(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeAAAA)
+	query.SetEdns0(4096, true)
+	query.CheckingDisabled = true
+
+	URL, err := newDoHJSONURL("https://dns.google/resolve?ct=x", query)
+	assert.NoError(t, err)
+	parsed, err := url.Parse(URL)
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"cd":   {"1"},
+		"ct":   {"x"},
+		"do":   {"1"},
+		"name": {"www.example.com."},
+		"type": {"28"},
+	}, parsed.Query())
+
+	_, err = newDoHJSONURL("https://dns.google/resolve", &dns.Msg{})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	_, err = newDoHJSONURL("\t", query)
+	assert.Error(t, err)
+}
+
+func Test_newMsgFromDoHJSON(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+
+	t.Run("valid response", func(t *testing.T) {
+		resp, err := newMsgFromDoHJSON(query, []byte(dohJSONTestBody))
+		assert.NoError(t, err)
+		assert.NoError(t, ValidateResponse(query, resp))
+		assert.True(t, resp.RecursionAvailable)
+		assert.True(t, resp.AuthenticatedData)
+		assert.Len(t, resp.Answer, 2)
+		assert.Equal(t, "example.com.", resp.Answer[1].Header().Name)
+		assert.Len(t, resp.Ns, 1)
+		assert.IsType(t, &dns.SOA{}, resp.Ns[0])
+
+		rrs, err := ValidAnswers(query.Question[0], resp)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := newMsgFromDoHJSON(query, []byte("{"))
+		assert.ErrorIs(t, err, ErrCannotUnmarshalMessage)
+	})
+
+	t.Run("invalid record data", func(t *testing.T) {
+		body := `{"Status": 0, "Answer": [{"name": "x.", "type": 1, "TTL": 1, "data": "not-an-ip"}]}`
+		_, err := newMsgFromDoHJSON(query, []byte(body))
+		assert.ErrorIs(t, err, ErrServerMisbehaving)
+	})
+}
+
+func TestTransport_queryDoHJSON(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+
+	newServer := func(contentType string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" || r.URL.Query().Get("name") != "www.example.com." ||
+				r.Header.Get("accept") != "application/dns-json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("content-type", contentType)
+			w.WriteHeader(status)
+			w.Write([]byte(dohJSONTestBody))
+		}))
+	}
+
+	t.Run("success", func(t *testing.T) {
+		srv := newServer("application/json; charset=UTF-8", 200)
+		defer srv.Close()
+		txp := &Transport{}
+		resp, info, err := txp.QueryWithInfo(context.Background(), NewServerAddr(ProtocolDoHJSON, srv.URL), query)
+		assert.NoError(t, err)
+		assert.NoError(t, ValidateResponse(query, resp))
+		assert.Len(t, resp.Answer, 2)
+		assert.Equal(t, dohJSONTestBody, string(info.RawResponse))
+		assert.Equal(t, int64(1), txp.Stats()[0].Responses)
+	})
+
+	t.Run("unexpected status code", func(t *testing.T) {
+		srv := newServer("application/dns-json", 500)
+		defer srv.Close()
+		_, err := (&Transport{}).Query(context.Background(), NewServerAddr(ProtocolDoHJSON, srv.URL), query)
+		assert.ErrorIs(t, err, ErrServerMisbehaving)
+	})
+
+	t.Run("unexpected content type", func(t *testing.T) {
+		srv := newServer("text/html", 200)
+		defer srv.Close()
+		_, err := (&Transport{}).Query(context.Background(), NewServerAddr(ProtocolDoHJSON, srv.URL), query)
+		assert.ErrorIs(t, err, ErrServerMisbehaving)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := (&Transport{}).Query(ctx, NewServerAddr(ProtocolDoHJSON, "https://dns.google/resolve"), query)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func Fuzz_newMsgFromDoHJSON(f *testing.F) {
+	query := &dns.Msg{}
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	f.Add([]byte(dohJSONTestBody))
+	f.Add([]byte(`{"Status":3,"Answer":[{"name":"x","type":65280,"data":"\\# 0"}]}`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		resp, err := newMsgFromDoHJSON(query, body)
+		if err != nil {
+			return
+		}
+		if resp.Id != query.Id || !resp.Response {
+			t.Fatal("unexpected response header")
+		}
+	})
+}
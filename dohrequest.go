@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"net/http"
+	"strings"
+)
+
+// dohURL returns the URL to use for the given HTTP-based server
+// address. When the address is an RFC 8484 URI template, we remove
+// the template expressions, since we never send the dns variable
+// (i.e., we use POST or, for [ProtocolDoHJSON], other parameters).
+func dohURL(addr *ServerAddr) string {
+	var (
+		builder strings.Builder
+		rest    = addr.Address
+	)
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			break
+		}
+		builder.WriteString(rest[:start])
+		rest = rest[start+end+1:]
+	}
+	builder.WriteString(rest)
+	return builder.String()
+}
+
+// setDoHRequestHeaders sets the custom headers, the User-Agent, and the
+// credentials configured in the given server address. Callers should set
+// the headers required by the protocol after calling this function.
+func setDoHRequestHeaders(addr *ServerAddr, req *http.Request) {
+	for key, values := range addr.Header {
+		req.Header[http.CanonicalHeaderKey(key)] = append([]string{}, values...)
+	}
+	if addr.UserAgent != "" {
+		req.Header.Set("User-Agent", addr.UserAgent)
+	}
+	switch {
+	case addr.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+addr.BearerToken)
+	case addr.Username != "":
+		req.SetBasicAuth(addr.Username, addr.Password)
+	}
+}
+
+// redactedHTTPRequest returns a shallow copy of the request suitable for
+// logging, where we have redacted the Authorization header, if present.
+func redactedHTTPRequest(req *http.Request) *http.Request {
+	if req.Header.Get("Authorization") == "" {
+		return req
+	}
+	redacted := *req
+	redacted.Header = req.Header.Clone()
+	redacted.Header.Set("Authorization", "[REDACTED]")
+	return &redacted
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_dohURL(t *testing.T) {
+	tests := []struct {
+		address string
+		expect  string
+	}{
+		{"https://dns.example.com/dns-query", "https://dns.example.com/dns-query"},
+		{"https://dns.example.com/dns-query{?dns}", "https://dns.example.com/dns-query"},
+		{"https://dns.example.com/q?tenant=x{&dns}", "https://dns.example.com/q?tenant=x"},
+		{"https://dns.example.com/{tenant}/q{?dns}", "https://dns.example.com//q"},
+		{"https://dns.example.com/q{?dns", "https://dns.example.com/q{?dns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equal(t, tt.expect, dohURL(NewServerAddr(ProtocolDoH, tt.address)))
+		})
+	}
+}
+
+func TestTransport_queryHTTPSCustomization(t *testing.T) {
+	// create a server recording the request
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		rawQuery, _ := io.ReadAll(r.Body)
+		w.Header().Set("content-type", "application/dns-message")
+		w.Write(newRawResponse(rawQuery, dns.RcodeSuccess))
+	}))
+	defer srv.Close()
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	t.Run("headers, user agent, and bearer token", func(t *testing.T) {
+		var logs bytes.Buffer
+		txp := &Transport{Insecure: true, Logger: slog.New(slog.NewJSONHandler(&logs, nil))}
+		addr := NewServerAddr(ProtocolHTTP, srv.URL+"/dns-query{?dns}")
+		addr.Header = http.Header{
+			"x-tenant":     {"acme"},
+			"Content-Type": {"text/plain"},
+		}
+		addr.UserAgent = "dnscore/test"
+		addr.BearerToken = "s3cr3t"
+		_, err := txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		assert.Equal(t, "/dns-query", got.URL.Path)
+		assert.Equal(t, "acme", got.Header.Get("X-Tenant"))
+		assert.Equal(t, "application/dns-message", got.Header.Get("Content-Type"))
+		assert.Equal(t, "dnscore/test", got.Header.Get("User-Agent"))
+		assert.Equal(t, "Bearer s3cr3t", got.Header.Get("Authorization"))
+		assert.NotContains(t, logs.String(), "s3cr3t")
+		assert.Contains(t, logs.String(), "[REDACTED]")
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		txp := &Transport{Insecure: true}
+		addr := NewServerAddr(ProtocolHTTP, srv.URL)
+		addr.Username, addr.Password = "user", "pass"
+		_, err := txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		username, password, ok := got.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "pass", password)
+	})
+
+	t.Run("JSON API", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+			w.Header().Set("content-type", "application/dns-json")
+			w.Write([]byte(`{"Status": 0}`))
+		}))
+		defer srv.Close()
+		txp := &Transport{}
+		addr := NewServerAddr(ProtocolDoHJSON, srv.URL+"/resolve{?name,type}")
+		addr.Header = http.Header{"Accept": {"text/html"}}
+		addr.BearerToken = "s3cr3t"
+		_, err := txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		assert.Equal(t, "/resolve", got.URL.Path)
+		assert.Equal(t, "example.com.", got.URL.Query().Get("name"))
+		assert.Equal(t, "application/dns-json", got.Header.Get("Accept"))
+		assert.Equal(t, "Bearer s3cr3t", got.Header.Get("Authorization"))
+	})
+}
+
+func Test_redactedHTTPRequest(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://dns.example.com/", nil)
+	assert.NoError(t, err)
+	assert.Same(t, req, redactedHTTPRequest(req))
+
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	redacted := redactedHTTPRequest(req)
+	assert.Equal(t, "[REDACTED]", redacted.Header.Get("Authorization"))
+	assert.Equal(t, "Bearer s3cr3t", req.Header.Get("Authorization"))
+}
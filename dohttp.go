@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// ErrInsecureProtocol is returned when using [ProtocolHTTP] or
+// [ProtocolH2C] without setting the [*Transport] Insecure field.
+var ErrInsecureProtocol = errors.New("insecure protocol not enabled")
+
+// transportHTTPClient contains a lazily-created default HTTP client.
+type transportHTTPClient struct {
+	// client is the lazily-created client.
+	client *http.Client
+
+	// mu protects client.
+	mu sync.Mutex
+}
+
+// closeIdleConnections closes the idle connections of the client, if any.
+func (h *transportHTTPClient) closeIdleConnections() {
+	h.mu.Lock()
+	client := h.client
+	h.mu.Unlock()
+	if client != nil {
+		client.CloseIdleConnections()
+	}
+}
+
+// h2cClient returns the H2CClient field, if not nil, or a lazily-created
+// HTTP/2 client with prior knowledge that dials using DialContext.
+func (t *Transport) h2cClient() *http.Client {
+	if t.H2CClient != nil {
+		return t.H2CClient
+	}
+	t.h2c.mu.Lock()
+	defer t.h2c.mu.Unlock()
+	if t.h2c.client == nil {
+		txp := &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, address string, _ *tls.Config) (net.Conn, error) {
+				return t.dialContext(ctx, network, address)
+			},
+		}
+		t.configureHTTP2(txp)
+		t.h2c.client = &http.Client{Transport: txp}
+	}
+	return t.h2c.client
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// usesDefaultHTTP2Settings returns whether the HTTP/2 settings of the
+// [*Transport] are the defaults, such that we can use [http.DefaultClient].
+func (t *Transport) usesDefaultHTTP2Settings() bool {
+	return t.HTTP2PingInterval <= 0 && t.HTTP2PingTimeout <= 0 && !t.HTTP2StrictMaxConcurrentStreams
+}
+
+// configureHTTP2 applies the HTTP/2 settings of the [*Transport].
+func (t *Transport) configureHTTP2(txp *http2.Transport) {
+	txp.ReadIdleTimeout = t.HTTP2PingInterval
+	txp.PingTimeout = t.HTTP2PingTimeout
+	txp.StrictMaxConcurrentStreams = t.HTTP2StrictMaxConcurrentStreams
+}
+
+// dohClient returns a lazily-created DNS-over-HTTPS client, which behaves
+// like [http.DefaultClient] except that it uses the HTTP/2 settings and the
+// RootCAs of the [*Transport]. Like [http.DefaultClient], this client shares
+// a single connection among all the queries to the same server.
+func (t *Transport) dohClient() *http.Client {
+	t.doh.mu.Lock()
+	defer t.doh.mu.Unlock()
+	if t.doh.client == nil {
+		// 1. clone the default transport, removing the HTTP/2 support
+		// it may have lazily configured for itself
+		txp := http.DefaultTransport.(*http.Transport).Clone()
+		txp.TLSNextProto = nil
+		txp.TLSClientConfig = &tls.Config{RootCAs: t.RootCAs}
+
+		// 2. enable and configure HTTP/2
+		h2txp, err := http2.ConfigureTransports(txp)
+		if err != nil {
+			panic(err) // cannot happen since we cleared TLSNextProto
+		}
+		t.configureHTTP2(h2txp)
+		t.doh.client = &http.Client{Transport: txp}
+	}
+	return t.doh.client
+}
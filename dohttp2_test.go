@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_HTTP2Settings(t *testing.T) {
+	// startServer starts a DoH server supporting HTTP/2 and returns it along with
+	// its root CAs and the counter of the connections it accepted.
+	startServer := func(t *testing.T) (*httptest.Server, *x509.CertPool, *atomic.Int64) {
+		conns := &atomic.Int64{}
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rawQuery, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			query := &dns.Msg{}
+			assert.NoError(t, query.Unpack(rawQuery))
+			resp := &dns.Msg{}
+			resp.SetReply(query)
+			rawResp, err := resp.Pack()
+			assert.NoError(t, err)
+			w.Header().Set("content-type", "application/dns-message")
+			w.Write(rawResp)
+		}))
+		srv.EnableHTTP2 = true
+		srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+		pool := x509.NewCertPool()
+		pool.AddCert(srv.Certificate())
+		return srv, pool, conns
+	}
+
+	t.Run("we use the default client with the default settings", func(t *testing.T) {
+		txp := &Transport{}
+		assert.Same(t, http.DefaultClient, txp.httpClient())
+	})
+
+	t.Run("we use the dedicated client when RootCAs is set", func(t *testing.T) {
+		srv, pool, _ := startServer(t)
+		txp := &Transport{RootCAs: pool}
+		defer txp.Close()
+		assert.NotSame(t, http.DefaultClient, txp.httpClient())
+		query, err := NewQuery("example.com", dns.TypeA)
+		assert.NoError(t, err)
+		_, err = txp.Query(context.Background(), NewServerAddr(ProtocolDoH, srv.URL), query)
+		assert.NoError(t, err)
+	})
+
+	t.Run("the HTTPClient field takes precedence", func(t *testing.T) {
+		client := &http.Client{}
+		txp := &Transport{HTTPClient: client, HTTP2StrictMaxConcurrentStreams: true}
+		assert.Same(t, client, txp.httpClient())
+	})
+
+	t.Run("queries share a single connection", func(t *testing.T) {
+		srv, pool, conns := startServer(t)
+		txp := &Transport{
+			HTTP2PingInterval:               time.Minute,
+			HTTP2StrictMaxConcurrentStreams: true,
+			RootCAs:                         pool,
+		}
+		defer txp.Close()
+		addr := NewServerAddr(ProtocolDoH, srv.URL)
+		query, err := NewQuery("example.com", dns.TypeA)
+		assert.NoError(t, err)
+
+		// first query to establish the connection, then concurrent queries
+		_, err = txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		var wg sync.WaitGroup
+		for range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := txp.Query(context.Background(), addr, query)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), conns.Load())
+	})
+
+	t.Run("we reconnect after the server closes the connection", func(t *testing.T) {
+		srv, pool, conns := startServer(t)
+		txp := &Transport{HTTP2PingInterval: time.Minute, RootCAs: pool}
+		defer txp.Close()
+		addr := NewServerAddr(ProtocolDoH, srv.URL)
+		query, err := NewQuery("example.com", dns.TypeA)
+		assert.NoError(t, err)
+
+		_, err = txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		srv.CloseClientConnections()
+		assert.Eventually(t, func() bool {
+			_, err := txp.Query(context.Background(), addr, query)
+			return err == nil
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, int64(2), conns.Load())
+	})
+}
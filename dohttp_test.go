@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestTransport_queryCleartextHTTP(t *testing.T) {
+	// newHandler returns a handler answering DNS queries with NXDOMAIN
+	// and recording the major version of the HTTP protocol used by the client.
+	newHandler := func(protoMajor *int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*protoMajor = r.ProtoMajor
+			rawQuery, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("content-type", "application/dns-message")
+			w.Write(newRawResponse(rawQuery, dns.RcodeNameError))
+		})
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	t.Run("disabled unless insecure", func(t *testing.T) {
+		txp := &Transport{}
+		for _, protocol := range []Protocol{ProtocolHTTP, ProtocolH2C} {
+			_, err := txp.Query(context.Background(), NewServerAddr(protocol, "http://127.0.0.1/dns-query"), query)
+			assert.ErrorIs(t, err, ErrInsecureProtocol)
+		}
+	})
+
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		var protoMajor int
+		srv := httptest.NewServer(newHandler(&protoMajor))
+		defer srv.Close()
+
+		txp := &Transport{Insecure: true}
+		resp, err := txp.Query(context.Background(), NewServerAddr(ProtocolHTTP, srv.URL), query)
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+		assert.Equal(t, 1, protoMajor)
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		var protoMajor int
+		srv := httptest.NewServer(h2c.NewHandler(newHandler(&protoMajor), &http2.Server{}))
+		defer srv.Close()
+
+		txp := &Transport{Insecure: true}
+		defer txp.Close()
+		resp, info, err := txp.QueryWithInfo(context.Background(), NewServerAddr(ProtocolH2C, srv.URL), query)
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+		assert.Equal(t, 2, protoMajor)
+		assert.False(t, info.ConnectDone.IsZero())
+		assert.True(t, info.RemoteAddr.IsValid())
+
+		// make sure we reuse the same client
+		assert.Same(t, txp.h2cClient(), txp.h2cClient())
+	})
+
+	t.Run("custom H2C client", func(t *testing.T) {
+		client := &http.Client{}
+		txp := &Transport{H2CClient: client}
+		assert.Same(t, client, txp.h2cClient())
+	})
+}
@@ -11,12 +11,16 @@ package dnscore
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/netip"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
-	"github.com/rbmk-project/common/httpconntrace"
 	"github.com/rbmk-project/common/httpslog"
 )
 
@@ -31,29 +35,125 @@ func (t *Transport) newHTTPRequestWithContext(
 }
 
 // httpClient is a helper function that returns the HTTP client using the
-// specific transport field or the stdlib if the given field is nil.
+// specific transport field or the stdlib if the given field is nil. When
+// the HTTP/2 settings differ from the defaults or RootCAs is not nil, we
+// use a lazily-created client configured accordingly instead of the stdlib
+// client, such that RootCAs applies regardless of the HTTP/2 settings.
 func (t *Transport) httpClient() *http.Client {
 	if t.HTTPClient != nil {
 		return t.HTTPClient
 	}
-	return http.DefaultClient
+	if t.usesDefaultHTTP2Settings() && t.RootCAs == nil {
+		return http.DefaultClient
+	}
+	return t.dohClient()
 }
 
 // httpClientDo performs an HTTP request using one of two methods:
 //
 // 1. if HTTPClientDo is not nil, use it directly;
 //
-// 2. otherwise use [*Transport.httpClient] to obtain a suitable
+// 2. otherwise use [*Transport.h2cClient] for [ProtocolH2C] and
+// [*Transport.httpClient] otherwise to obtain a suitable
 // [*http.Client] and perform the request with it.
-func (t *Transport) httpClientDo(req *http.Request) (*http.Response, netip.AddrPort, netip.AddrPort, error) {
+func (t *Transport) httpClientDo(addr *ServerAddr,
+	req *http.Request) (*http.Response, netip.AddrPort, netip.AddrPort, error) {
 	// If HTTPClientDo isn't nil, use it directly.
 	if t.HTTPClientDo != nil {
 		return t.HTTPClientDo(req)
 	}
 
-	// Otherwise use httpconntrace.Do to perform the request
-	resp, endpoints, err := httpconntrace.Do(t.httpClient(), req)
-	return resp, endpoints.LocalAddr, endpoints.RemoteAddr, err
+	// Otherwise trace the request to collect the connection endpoints. Unlike
+	// httpconntrace.Do, we compose with any trace already present in the
+	// request context, which allows [*Transport.queryHTTPS] to observe
+	// connection reuse and TLS handshakes.
+	var (
+		laddr netip.AddrPort
+		mu    sync.Mutex
+		raddr netip.AddrPort
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if addr, ok := info.Conn.LocalAddr().(*net.TCPAddr); ok {
+				laddr = addr.AddrPort()
+			}
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				raddr = addr.AddrPort()
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	client := t.httpClient()
+	if addr.Protocol == ProtocolH2C {
+		client = t.h2cClient()
+	}
+	resp, err := client.Do(req)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return resp, laddr, raddr, err
+}
+
+// withClientTrace returns a copy of the request that updates the connection
+// and handshake statistics for the given server address as well as the
+// [*QueryInfo] being collected by the tracer, if any, and that captures
+// the raw query once we have written the request.
+func (t *Transport) withClientTrace(addr *ServerAddr,
+	tracer *queryTracer, rawQuery []byte, req *http.Request) *http.Request {
+	var conn net.Conn
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tracer.stamp(func(info *QueryInfo, now time.Time) { info.DNSLookupStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tracer.stamp(func(info *QueryInfo, now time.Time) { info.DNSLookupDone = now })
+		},
+		ConnectStart: func(_, _ string) {
+			tracer.stamp(func(info *QueryInfo, now time.Time) {
+				if info.ConnectStart.IsZero() {
+					info.ConnectStart = now
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				tracer.stamp(func(info *QueryInfo, now time.Time) { info.ConnectDone = now })
+			}
+		},
+		TLSHandshakeStart: func() {
+			tracer.stamp(func(info *QueryInfo, now time.Time) { info.TLSHandshakeStart = now })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				t.emitConnEvent(ConnEventHandshakeFailure, addr, nil, "", err)
+				return
+			}
+			t.stats.onHandshake(addr)
+			tracer.stamp(func(info *QueryInfo, now time.Time) { info.TLSHandshakeDone = now })
+		},
+		GotConn: func(gci httptrace.GotConnInfo) {
+			conn = gci.Conn
+			t.stats.onConn(addr, gci.Reused)
+			if gci.Reused {
+				t.emitConnEvent(ConnEventReuse, addr, gci.Conn, "", nil)
+			} else {
+				t.emitConnEvent(ConnEventOpen, addr, gci.Conn, "", nil)
+			}
+			tracer.stamp(func(info *QueryInfo, _ time.Time) { info.ConnReused = gci.Reused })
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil && conn != nil {
+				t.maybeCaptureSent(addr, conn, rawQuery)
+			}
+			tracer.stamp(func(info *QueryInfo, now time.Time) { info.QuerySent = now })
+		},
+		GotFirstResponseByte: func() {
+			tracer.stamp(func(info *QueryInfo, now time.Time) { info.FirstByte = now })
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 }
 
 // readAllContext is a helper function that reads all from the reader using the
@@ -65,37 +165,68 @@ func (t *Transport) readAllContext(ctx context.Context, r io.Reader, c io.Closer
 	return io.ReadAll(r)
 }
 
-// queryHTTPS implements [*Transport.Query] for DNS over HTTPS.
+// isDoHContentType returns whether the content type is the one used by DoH.
+func isDoHContentType(value string) bool {
+	return value == "application/dns-message"
+}
+
+// queryHTTPS implements [*Transport.Query] for DNS over HTTPS as well
+// as for DNS over cleartext HTTP/1.1 and HTTP/2 ([ProtocolHTTP], [ProtocolH2C]).
 func (t *Transport) queryHTTPS(ctx context.Context,
 	addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
-	// 0. immediately fail if the context is already done, which
-	// is useful to write unit tests
-	if ctx.Err() != nil {
-		return nil, ctx.Err()
-	}
-
-	// 1. Serialize the query and possibly log that we're sending it.
+	// 1. Perform the HTTP round trip.
 	rawQuery, err := query.Pack()
 	if err != nil {
 		return nil, err
 	}
-	t0 := t.maybeLogQuery(ctx, addr, rawQuery)
+	t0, rawResp, laddr, raddr, err := t.roundTripHTTPS(ctx, addr, rawQuery, edns0MaxResponseSize(query))
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Decode the response and possibly log it.
+	resp := new(dns.Msg)
+	if err := resp.Unpack(rawResp); err != nil {
+		return nil, err
+	}
+	t.stats.onResponse(addr, len(rawResp), resp.Rcode)
+	t.maybeLogResponseAddrPort(ctx, addr, t0, rawQuery, rawResp, laddr, raddr)
+	return resp, nil
+}
+
+// roundTripHTTPS sends the raw query using DNS over HTTPS, or cleartext HTTP,
+// and returns the time when we sent the query, the raw response body, which
+// is at most maxSize bytes, and the connection endpoints.
+func (t *Transport) roundTripHTTPS(ctx context.Context, addr *ServerAddr, rawQuery []byte,
+	maxSize uint16) (t0 time.Time, rawResp []byte, laddr, raddr netip.AddrPort, err error) {
+	// 0. immediately fail if the context is already done, which
+	// is useful to write unit tests
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	// 1. Possibly log that we're sending the query.
+	t0 = t.maybeLogQuery(ctx, addr, rawQuery)
+	tracer := queryTracerFromContext(ctx)
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.RawQuery = rawQuery })
 
 	// 2. The query is sent as the body of a POST request. The content-type
 	// header must be set. Otherwise servers may respond with 400.
-	req, err := t.newHTTPRequestWithContext(ctx, "POST", addr.Address, bytes.NewReader(rawQuery))
+	req, err := t.newHTTPRequestWithContext(ctx, "POST", dohURL(addr), bytes.NewReader(rawQuery))
 	if err != nil {
-		return nil, err
+		return
 	}
+	setDoHRequestHeaders(addr, req)
 	req.Header.Set("content-type", "application/dns-message")
+	req = t.withClientTrace(addr, tracer, rawQuery, req)
 
 	// 3. Log the HTTP request we're sending.
 	httpslog.MaybeLogRoundTripStart(
-		t.Logger,
+		t.logger(ctx),
 		netip.MustParseAddrPort("[::]:0"), // not yet known
 		"tcp",
 		netip.MustParseAddrPort("[::]:0"), // not yet known
-		req,
+		redactedHTTPRequest(req),
 		t0,
 	)
 
@@ -103,15 +234,16 @@ func (t *Transport) queryHTTPS(ctx context.Context,
 	// the body, the response code is 200, and the content type
 	// is the expected one. Since servers always include the
 	// content type, we don't need to be flexible here.
-	httpResp, laddr, raddr, err := t.httpClientDo(req)
+	httpResp, laddr, raddr, err := t.httpClientDo(addr, req)
+	t.stats.onSent(addr, len(rawQuery))
 
 	// 5. Log the result of the HTTP transfer.
 	httpslog.MaybeLogRoundTripDone(
-		t.Logger,
+		t.logger(ctx),
 		laddr,
 		"tcp",
 		raddr,
-		req,
+		redactedHTTPRequest(req),
 		httpResp,
 		err,
 		t0,
@@ -122,27 +254,23 @@ func (t *Transport) queryHTTPS(ctx context.Context,
 	// and the content type is the expected one. Since servers
 	// always include the content type, we don't need to be flexible here.
 	if err != nil {
-		return nil, err
+		return
 	}
 	defer httpResp.Body.Close()
-	if httpResp.StatusCode != 200 {
-		return nil, ErrServerMisbehaving
-	}
-	if httpResp.Header.Get("content-type") != "application/dns-message" {
-		return nil, ErrServerMisbehaving
+	if err = t.checkHTTPResponse(httpResp, isDoHContentType); err != nil {
+		return
 	}
 
-	// 7. Now that headers are OK, we read the whole raw response
-	// body, decode it, and possibly log it.
-	reader := io.LimitReader(httpResp.Body, int64(edns0MaxResponseSize(query)))
-	rawResp, err := t.readAllContext(ctx, reader, httpResp.Body)
+	// 7. Now that headers are OK, we read the whole raw response body.
+	rawResp, err = t.readHTTPResponseBody(ctx, httpResp, int64(maxSize))
 	if err != nil {
-		return nil, err
-	}
-	resp := new(dns.Msg)
-	if err := resp.Unpack(rawResp); err != nil {
-		return nil, err
+		return
 	}
-	t.maybeLogResponseAddrPort(ctx, addr, t0, rawQuery, rawResp, laddr, raddr)
-	return resp, nil
+	t.maybeCapture(addr, raddr, laddr, rawResp)
+	tracer.stamp(func(info *QueryInfo, now time.Time) {
+		info.LastByte = now
+		info.LocalAddr, info.RemoteAddr = laddr, raddr
+		info.RawResponse = rawResp
+	})
+	return
 }
@@ -235,7 +235,7 @@ func TestTransport_httpClientDo(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			transport := tt.setupTransport()
 			req := runtimex.Try1(http.NewRequest("GET", "https://example.com", nil))
-			resp, la, ra, err := transport.httpClientDo(req)
+			resp, la, ra, err := transport.httpClientDo(NewServerAddr(ProtocolDoH, "https://example.com"), req)
 			if tt.expectedError != nil {
 				assert.Error(t, err)
 				assert.Equal(t, tt.expectedError.Error(), err.Error())
@@ -387,7 +387,7 @@ func TestTransport_queryHTTPS(t *testing.T) {
 			},
 			questionName:  "example.com.",
 			url:           "https://dns.google/dns-query",
-			expectedError: ErrServerMisbehaving,
+			expectedError: &HTTPError{StatusCode: 500, Err: ErrHTTPServerError},
 		},
 
 		{
@@ -411,7 +411,7 @@ func TestTransport_queryHTTPS(t *testing.T) {
 			},
 			questionName:  "example.com.",
 			url:           "https://dns.google/dns-query",
-			expectedError: ErrServerMisbehaving,
+			expectedError: &HTTPError{StatusCode: 200, ContentType: "text/plain", Err: ErrHTTPContentType},
 		},
 
 		{
@@ -16,6 +16,8 @@ import (
 	"io"
 	"math"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -29,13 +31,23 @@ func (t *Transport) queryTCP(ctx context.Context,
 		return nil, ctx.Err()
 	}
 
-	// 1. Dial the connection
+	// 1. Possibly reuse connections, otherwise dial a new connection
+	if t.ReuseStreamConns || t.PipelineStreamQueries {
+		return t.queryStreamReusingConns(ctx, addr, query, func(ctx context.Context) (net.Conn, error) {
+			conn, err := t.dialContext(ctx, "tcp", addr.Address)
+			if err != nil {
+				return nil, err
+			}
+			return t.newConn(addr, conn), nil
+		})
+	}
 	conn, err := t.dialContext(ctx, "tcp", addr.Address)
 
 	// 2. Handle dialing failure
 	if err != nil {
 		return nil, err
 	}
+	conn = t.newConn(addr, conn)
 
 	// 3. Transfer conn ownership and perform the round trip
 	return t.queryStream(ctx, addr, query, conn)
@@ -60,7 +72,7 @@ func (t *Transport) queryStream(ctx context.Context,
 	// 1. Use a single connection for request, which is what the standard library
 	// does as well for TCP and is more robust in terms of residual censorship.
 	//
-	// In the future, we may want to reuse a TLS connection for multiple queries
+	// See [*Transport.queryStreamReusingConns] for the opt-in connection reuse.
 	//
 	// Make sure we react to context being canceled early.
 	ctx, cancel := context.WithCancel(ctx)
@@ -76,46 +88,106 @@ func (t *Transport) queryStream(ctx context.Context,
 		_ = conn.SetDeadline(deadline)
 	}
 
-	// 3. Serialize the query and possibly log that we're sending it.
-	rawQuery, err := query.Pack()
+	// 3. Perform the exchange. We wrap the conn to avoid issuing too many reads.
+	br := streamReaderPool.Get().(*bufio.Reader)
+	br.Reset(conn)
+	defer func() {
+		br.Reset(nil)
+		streamReaderPool.Put(br)
+	}()
+	return t.exchangeStream(ctx, addr, query, conn, br)
+}
+
+// streamReaderPool contains the [*bufio.Reader] used by [*Transport.queryStream]
+// to avoid allocating a new reader buffer for each query. This is safe because
+// [ReadMsgFrame] copies the response into a newly allocated slice.
+var streamReaderPool = sync.Pool{
+	New: func() any { return bufio.NewReader(nil) },
+}
+
+// exchangeStream sends the query and reads the response over the given
+// TCP/TLS stream, using br to read. This method does not take ownership
+// of the connection and does not enforce any deadline.
+func (t *Transport) exchangeStream(ctx context.Context, addr *ServerAddr,
+	query queryMsg, conn net.Conn, br *bufio.Reader) (*dns.Msg, error) {
+	// 1. Perform the exchange.
+	t0, rawQuery, rawResp, err := t.exchangeStreamRaw(ctx, addr, query, conn, br)
 	if err != nil {
 		return nil, err
 	}
-	t0 := t.maybeLogQuery(ctx, addr, rawQuery)
 
-	// 4. Wrap the query into a frame
+	// 2. Parse the response and possibly log that we received it.
+	resp := new(dns.Msg)
+	if err := resp.Unpack(rawResp); err != nil {
+		return nil, err
+	}
+	t.stats.onResponse(addr, len(rawResp), resp.Rcode)
+	t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
+	return resp, nil
+}
+
+// exchangeStreamRaw is like [*Transport.exchangeStream] but returns the time
+// when we sent the query, the raw query, and the raw response, without parsing
+// and logging the response.
+func (t *Transport) exchangeStreamRaw(ctx context.Context, addr *ServerAddr, query queryMsg,
+	conn net.Conn, br *bufio.Reader) (t0 time.Time, rawQuery, rawResp []byte, err error) {
+	// 1. Serialize the query and possibly log that we're sending it.
+	rawQuery, err = query.Pack()
+	if err != nil {
+		return
+	}
+	t0 = t.maybeLogQuery(ctx, addr, rawQuery)
+	tracer := queryTracerFromContext(ctx)
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.RawQuery = rawQuery })
+
+	// 2. Wrap the query into a frame
 	rawQueryFrame, err := newRawMsgFrame(addr, rawQuery)
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	// 5. Send the query. Do not bother with logging the write call
+	// 3. Send the query. Do not bother with logging the write call
 	// since that should be done by a custom dialer that wraps the
 	// returned connection and implements the desired logging.
-	if _, err := conn.Write(rawQueryFrame); err != nil {
-		return nil, err
+	if _, err = conn.Write(rawQueryFrame); err != nil {
+		return
+	}
+	t.maybeCaptureSent(addr, conn, rawQuery)
+	t.stats.onSent(addr, len(rawQueryFrame))
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.QuerySent = now })
+
+	// 4. Read the response header and body
+	if _, err = br.Peek(2); err != nil {
+		return
+	}
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.FirstByte = now })
+	rawResp, err = ReadMsgFrame(br)
+	if err != nil {
+		return
 	}
+	t.maybeCaptureReceived(addr, conn, rawResp)
+	tracer.stamp(func(info *QueryInfo, now time.Time) {
+		info.LastByte = now
+		info.RawResponse = rawResp
+	})
+	return
+}
 
-	// 6. Wrap the conn to avoid issuing too many reads
-	// then read the response header and query
-	br := bufio.NewReader(conn)
+// ReadMsgFrame reads a message prefixed by its two-byte length, as
+// used by DNS over TCP and TLS, from the given reader. Since the reader
+// is usually a network connection, this function must handle arbitrary
+// input, including truncated frames.
+func ReadMsgFrame(r io.Reader) ([]byte, error) {
 	header := make([]byte, 2)
-	if _, err := io.ReadFull(br, header); err != nil {
+	if _, err := io.ReadFull(r, header); err != nil {
 		return nil, err
 	}
 	length := int(header[0])<<8 | int(header[1])
-	rawResp := make([]byte, length)
-	if _, err := io.ReadFull(br, rawResp); err != nil {
+	rawMsg := make([]byte, length)
+	if _, err := io.ReadFull(r, rawMsg); err != nil {
 		return nil, err
 	}
-
-	// 7. Parse the response and possibly log that we received it.
-	resp := new(dns.Msg)
-	if err := resp.Unpack(rawResp); err != nil {
-		return nil, err
-	}
-	t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
-	return resp, nil
+	return rawMsg, nil
 }
 
 // newRawMsgFrame creates a new raw frame for sending a message over TCP or TLS.
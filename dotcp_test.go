@@ -379,3 +379,30 @@ func Test_newRawMsgFrame(t *testing.T) {
 		})
 	}
 }
+
+func TestReadMsgFrame(t *testing.T) {
+	rawMsg, err := ReadMsgFrame(bytes.NewReader([]byte{0, 3, 1, 2, 3, 4}))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, rawMsg)
+
+	_, err = ReadMsgFrame(bytes.NewReader([]byte{0}))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = ReadMsgFrame(bytes.NewReader([]byte{0, 3, 1}))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func FuzzReadMsgFrame(f *testing.F) {
+	f.Add(newValidRawRespFrame())
+	f.Add(newGarbageRawRespFrame())
+	f.Add([]byte{0xff, 0xff, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rawMsg, err := ReadMsgFrame(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(rawMsg)+2 > len(data) || int(data[0])<<8|int(data[1]) != len(rawMsg) {
+			t.Fatal("inconsistent frame length")
+		}
+	})
+}
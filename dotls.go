@@ -10,15 +10,30 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
 // dialTLSContext is a helper function that dials a network address using the
 // given dialer or the default dialer if the given dialer is nil.
+//
+// When the context contains a query tracer, this method also records
+// the connect and TLS handshake events along with the connection endpoints.
 func (t *Transport) dialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	tracer := queryTracerFromContext(ctx)
 	if t.DialTLSContext != nil {
-		return t.DialTLSContext(ctx, network, address)
+		tracer.stamp(func(info *QueryInfo, now time.Time) { info.ConnectStart = now })
+		conn, err := t.DialTLSContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		tracer.stamp(func(info *QueryInfo, now time.Time) {
+			info.ConnectDone = now
+			info.LocalAddr = addrToAddrPort(conn.LocalAddr())
+			info.RemoteAddr = addrToAddrPort(conn.RemoteAddr())
+		})
+		return conn, nil
 	}
 
 	// Fill in a default TLS config
@@ -33,9 +48,26 @@ func (t *Transport) dialTLSContext(ctx context.Context, network, address string)
 		ServerName:         hostname,
 	}
 
-	// Defer to the stdlib TLS dialer
-	dialer := &tls.Dialer{Config: config}
-	return dialer.DialContext(ctx, network, address)
+	// Dial and handshake separately, like [*tls.Dialer] does,
+	// so that we can trace the two phases.
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.ConnectStart = now })
+	tcpConn, err := t.dialNetContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	tracer.stamp(func(info *QueryInfo, now time.Time) {
+		info.ConnectDone = now
+		info.LocalAddr = addrToAddrPort(tcpConn.LocalAddr())
+		info.RemoteAddr = addrToAddrPort(tcpConn.RemoteAddr())
+		info.TLSHandshakeStart = now
+	})
+	tlsConn := tls.Client(tcpConn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		tcpConn.Close()
+		return nil, &tlsHandshakeError{err}
+	}
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.TLSHandshakeDone = now })
+	return tlsConn, nil
 }
 
 // queryTLS implements [*Transport.Query] for DNS over TLS.
@@ -47,13 +79,27 @@ func (t *Transport) queryTLS(ctx context.Context,
 		return nil, ctx.Err()
 	}
 
-	// 1. Dial the TLS connection
+	// 1. Possibly reuse connections, otherwise dial a new TLS connection
+	if t.ReuseStreamConns || t.PipelineStreamQueries {
+		return t.queryStreamReusingConns(ctx, addr, query, func(ctx context.Context) (net.Conn, error) {
+			conn, err := t.dialTLSContext(ctx, "tcp", addr.Address)
+			if err != nil {
+				t.maybeEmitHandshakeFailure(addr, err)
+				return nil, err
+			}
+			t.stats.onHandshake(addr)
+			return t.newConn(addr, conn), nil
+		})
+	}
 	conn, err := t.dialTLSContext(ctx, "tcp", addr.Address)
 
 	// 2. Handle dialing failure
 	if err != nil {
+		t.maybeEmitHandshakeFailure(addr, err)
 		return nil, err
 	}
+	t.stats.onHandshake(addr)
+	conn = t.newConn(addr, conn)
 
 	// 3. Transfer conn ownership and perform the round trip
 	return t.queryStream(ctx, addr, query, conn)
@@ -9,8 +9,11 @@
 package dnscore
 
 import (
+	"bytes"
 	"context"
+	"math"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -18,12 +21,30 @@ import (
 
 // dialContext is a helper function that dials a network address using the
 // given dialer or the default dialer if the given dialer is nil.
+//
+// When the context contains a query tracer, this method also records
+// the connect events along with the connection endpoints.
 func (t *Transport) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	tracer := queryTracerFromContext(ctx)
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.ConnectStart = now })
+	conn, err := t.dialContextWithoutTracing(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	tracer.stamp(func(info *QueryInfo, now time.Time) {
+		info.ConnectDone = now
+		info.LocalAddr = addrToAddrPort(conn.LocalAddr())
+		info.RemoteAddr = addrToAddrPort(conn.RemoteAddr())
+	})
+	return conn, nil
+}
+
+// dialContextWithoutTracing implements [*Transport.dialContext].
+func (t *Transport) dialContextWithoutTracing(ctx context.Context, network, address string) (net.Conn, error) {
 	if t.DialContext != nil {
 		return t.DialContext(ctx, network, address)
 	}
-	dialer := &net.Dialer{}
-	return dialer.DialContext(ctx, network, address)
+	return t.dialNetContext(ctx, network, address)
 }
 
 // timeNow is a helper function that returns the current time using the
@@ -49,7 +70,7 @@ func (t *Transport) timeNow() time.Time {
 // On success, the caller TAKES OWNERSHIP of the returned connection
 // and is responsible for closing it when done.
 func (t *Transport) sendQueryUDP(ctx context.Context, addr *ServerAddr,
-	query *dns.Msg) (conn net.Conn, t0 time.Time, rawQuery []byte, err error) {
+	query queryMsg) (conn net.Conn, t0 time.Time, rawQuery []byte, err error) {
 	// 1. Dial the connection and handle failure. We do not handle retries at this
 	// level and instead rely on the caller to retry the query if needed. This allows
 	// the [*Resolver] to cycle through multiple servers in case of failure.
@@ -57,6 +78,7 @@ func (t *Transport) sendQueryUDP(ctx context.Context, addr *ServerAddr,
 	if err != nil {
 		return
 	}
+	conn = t.newConn(addr, conn)
 
 	// 2. Use the context deadline to limit the query lifetime
 	// as documented in the [*Transport.Query] function.
@@ -70,11 +92,17 @@ func (t *Transport) sendQueryUDP(ctx context.Context, addr *ServerAddr,
 		return
 	}
 	t0 = t.maybeLogQuery(ctx, addr, rawQuery)
+	queryTracerFromContext(ctx).stamp(func(info *QueryInfo, now time.Time) { info.RawQuery = rawQuery })
 
 	// 4. Send the query. Do not bother with logging the write call
 	// since that should be done by a custom dialer that wraps the
 	// returned connection and implements the desired logging.
-	_, err = conn.Write(rawQuery)
+	if _, err = conn.Write(rawQuery); err != nil {
+		return
+	}
+	t.maybeCaptureSent(addr, conn, rawQuery)
+	t.stats.onSent(addr, len(rawQuery))
+	queryTracerFromContext(ctx).stamp(func(info *QueryInfo, now time.Time) { info.QuerySent = now })
 	return
 }
 
@@ -93,27 +121,91 @@ func edns0MaxResponseSize(query *dns.Msg) (maxSize uint16) {
 	return
 }
 
+// udpBufferPool contains the buffers used by [*Transport.readResponseUDP],
+// which are large enough for any datagram, so that we only allocate memory
+// for the bytes we actually receive, rather than for the maximum
+// response size, which matters when handling many queries.
+var udpBufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, math.MaxUint16)
+		return &buffer
+	},
+}
+
+// readResponseUDP reads a raw response datagram of at most maxSize bytes from
+// the connection, records it into the [*QueryInfo] being collected, if any,
+// and captures it, even if the caller will later discard it.
+func (t *Transport) readResponseUDP(ctx context.Context,
+	addr *ServerAddr, conn net.Conn, maxSize uint16) ([]byte, error) {
+	// Note: we copy the datagram out of the pooled buffer because the
+	// raw response outlives this function, e.g., in the [*QueryInfo].
+	buffer := udpBufferPool.Get().(*[]byte)
+	defer udpBufferPool.Put(buffer)
+	count, err := conn.Read((*buffer)[:maxSize])
+	if err != nil {
+		return nil, err
+	}
+	rawResp := bytes.Clone((*buffer)[:count])
+	t.maybeCaptureReceived(addr, conn, rawResp)
+	queryTracerFromContext(ctx).stamp(func(info *QueryInfo, now time.Time) {
+		info.FirstByte, info.LastByte = now, now
+		info.RawResponse = rawResp
+	})
+	return rawResp, nil
+}
+
 // recvResponseUDP reads and parses the response from the server and
 // possibly logs the response. It returns the parsed response or an error.
 func (t *Transport) recvResponseUDP(ctx context.Context, addr *ServerAddr, conn net.Conn,
 	t0 time.Time, query *dns.Msg, rawQuery []byte) (*dns.Msg, error) {
 	// 1. Read the corresponding raw response
-	buffer := make([]byte, edns0MaxResponseSize(query))
-	count, err := conn.Read(buffer)
+	rawResp, err := t.readResponseUDP(ctx, addr, conn, edns0MaxResponseSize(query))
 	if err != nil {
 		return nil, err
 	}
-	rawResp := buffer[:count]
 
 	// 2. Parse the raw response and possibly log that we received it.
 	resp := &dns.Msg{}
 	if err := resp.Unpack(rawResp); err != nil {
 		return nil, err
 	}
+	t.stats.onResponse(addr, len(rawResp), resp.Rcode)
 	t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
 	return resp, nil
 }
 
+// recvValidResponseUDP is like [*Transport.recvResponseUDP] except that it
+// discards the datagrams that we cannot parse or that fail [ValidateResponse]
+// and keeps reading until we receive a valid response or the context is done
+// (or the connection deadline expires). This allows us to survive the stray
+// or injected packets that would otherwise cause the query to fail.
+func (t *Transport) recvValidResponseUDP(ctx context.Context, addr *ServerAddr, conn net.Conn,
+	t0 time.Time, query *dns.Msg, rawQuery []byte) (*dns.Msg, error) {
+	for {
+		// 1. Stop when the context is done, which we need to check because
+		// we may otherwise keep reading datagrams from a closed connection.
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// 2. Read the next raw response and fail on I/O errors.
+		rawResp, err := t.readResponseUDP(ctx, addr, conn, edns0MaxResponseSize(query))
+		if err != nil {
+			return nil, err
+		}
+
+		// 3. Parse and validate the raw response, discarding it on failure.
+		resp, err := ParseResponse(query, rawResp)
+		if err != nil {
+			t.stats.onDiscarded(addr)
+			continue
+		}
+		t.stats.onResponse(addr, len(rawResp), resp.Rcode)
+		t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
+		return resp, nil
+	}
+}
+
 // queryUDP implements [*Transport.Query] for DNS over UDP.
 func (t *Transport) queryUDP(ctx context.Context,
 	addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
@@ -141,7 +233,7 @@ func (t *Transport) queryUDP(ctx context.Context,
 	}()
 
 	// Read and parse the response and log it if needed.
-	return t.recvResponseUDP(ctx, addr, conn, t0, query, rawQuery)
+	return t.recvValidResponseUDP(ctx, addr, conn, t0, query, rawQuery)
 }
 
 // emitMessageOrError sends a message or error to the output channel
@@ -161,9 +253,10 @@ func (t *Transport) emitMessageOrError(ctx context.Context,
 	}
 }
 
-// queryUDPWithDuplicates implements [*Transport.Query] for DNS over UDP with
+// queryUDPWithDuplicates implements [*Transport.QueryWithDuplicates] for DNS
+// over UDP. The done function is called when the background goroutine exits.
 func (t *Transport) queryUDPWithDuplicates(ctx context.Context,
-	addr *ServerAddr, query *dns.Msg) <-chan *MessageOrError {
+	addr *ServerAddr, query *dns.Msg, done func()) <-chan *MessageOrError {
 	out := make(chan *MessageOrError, 4)
 
 	// Immediately fail if the context is already done, which
@@ -171,11 +264,13 @@ func (t *Transport) queryUDPWithDuplicates(ctx context.Context,
 	if ctx.Err() != nil {
 		out <- &MessageOrError{Err: ctx.Err()}
 		close(out)
+		done()
 		return out
 	}
 
 	go func() {
-		// Ensure the channel is closed when we're done
+		// Ensure the channel is closed and we signal completion when we're done
+		defer done()
 		defer close(out)
 
 		// Send the query and log the query if needed.
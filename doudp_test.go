@@ -273,6 +273,30 @@ func Test_edns0MaxResponseSize(t *testing.T) {
 	}
 }
 
+func TestTransport_readResponseUDP(t *testing.T) {
+	datagrams := [][]byte{{1, 2, 3}, {4, 5, 6, 7}}
+	var lengths []int
+	conn := &mocks.Conn{
+		MockRead: func(b []byte) (int, error) {
+			lengths = append(lengths, len(b))
+			datagram := datagrams[0]
+			datagrams = datagrams[1:]
+			return copy(b, datagram), nil
+		},
+	}
+	txp, addr := &Transport{}, NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	first, err := txp.readResponseUDP(context.Background(), addr, conn, 512)
+	assert.NoError(t, err)
+	second, err := txp.readResponseUDP(context.Background(), addr, conn, 512)
+	assert.NoError(t, err)
+
+	// make sure we do not alias the pooled buffers and we
+	// read at most the maximum response size
+	assert.Equal(t, []byte{1, 2, 3}, first)
+	assert.Equal(t, []byte{4, 5, 6, 7}, second)
+	assert.Equal(t, []int{512, 512}, lengths)
+}
+
 func TestTransport_recvResponseUDP(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -348,13 +372,14 @@ func TestTransport_queryUDP(t *testing.T) {
 			setupTransport: func() *Transport {
 				return &Transport{
 					DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+						var rawQuery []byte
 						return &mocks.Conn{
 							MockWrite: func(b []byte) (int, error) {
+								rawQuery = append([]byte{}, b...)
 								return len(b), nil
 							},
 							MockRead: func(b []byte) (int, error) {
-								copy(b, []byte{0, 0, 0, 0})
-								return len(b), nil
+								return copy(b, newRawResponse(rawQuery, dns.RcodeSuccess)), nil
 							},
 							MockClose: func() error {
 								return nil
@@ -439,15 +464,19 @@ func TestTransport_queryUDP(t *testing.T) {
 		},
 
 		{
-			name: "Garbage response",
+			name: "Garbage response followed by read failure",
 			setupTransport: func() *Transport {
 				return &Transport{
 					DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+						var reads int
 						return &mocks.Conn{
 							MockWrite: func(b []byte) (int, error) {
 								return len(b), nil
 							},
 							MockRead: func(b []byte) (int, error) {
+								if reads++; reads > 1 {
+									return 0, errors.New("read failed")
+								}
 								copy(b, []byte{0xFF})
 								return 1, nil
 							},
@@ -458,7 +487,7 @@ func TestTransport_queryUDP(t *testing.T) {
 					},
 				}
 			},
-			expectedError: errors.New("dns: overflow unpacking uint16"),
+			expectedError: errors.New("read failed"),
 		},
 	}
 
@@ -640,7 +669,7 @@ func TestTransport_queryUDPWithDuplicates(t *testing.T) {
 			query := new(dns.Msg)
 			query.SetQuestion("example.com.", dns.TypeA)
 
-			ch := transport.queryUDPWithDuplicates(context.Background(), addr, query)
+			ch := transport.queryUDPWithDuplicates(context.Background(), addr, query, func() {})
 			messages := []*MessageOrError{}
 			for msgOrErr := range ch {
 				messages = append(messages, msgOrErr)
@@ -658,3 +687,49 @@ func TestTransport_queryUDPWithDuplicates(t *testing.T) {
 		})
 	}
 }
+
+func TestTransport_queryUDPDiscardsInvalidResponses(t *testing.T) {
+	var (
+		rawQuery []byte
+		reads    int
+	)
+	txp := &Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &mocks.Conn{
+				MockWrite: func(b []byte) (int, error) {
+					rawQuery = append([]byte{}, b...)
+					return len(b), nil
+				},
+				MockRead: func(b []byte) (int, error) {
+					reads++
+					switch reads {
+					case 1: // garbage
+						return copy(b, []byte{0xFF}), nil
+					case 2: // injected response with the wrong ID
+						rawResp := newRawResponse(rawQuery, dns.RcodeNameError)
+						rawResp[0] ^= 0xFF
+						return copy(b, rawResp), nil
+					default:
+						return copy(b, newRawResponse(rawQuery, dns.RcodeSuccess)), nil
+					}
+				},
+				MockClose: func() error {
+					return nil
+				},
+			}, nil
+		},
+	}
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	resp, err := txp.queryUDP(context.Background(), addr, query)
+	assert.NoError(t, err)
+	assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+	assert.Equal(t, 3, reads)
+
+	stats := txp.Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, int64(2), stats[0].Discarded)
+	assert.Equal(t, int64(1), stats[0].Responses)
+}
@@ -0,0 +1,440 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Keepalive values we suggest to the server when establishing a
+// [*DSOSession], which the server may override with its own values.
+const (
+	// DefaultDSOInactivityTimeout is the suggested inactivity timeout.
+	DefaultDSOInactivityTimeout = 15 * time.Second
+
+	// DefaultDSOKeepaliveInterval is the suggested keepalive interval.
+	DefaultDSOKeepaliveInterval = time.Hour
+)
+
+// dsoKeepaliveTimeout is the time we wait for the response to a
+// Keepalive request before considering the session broken.
+const dsoKeepaliveTimeout = 10 * time.Second
+
+var (
+	// ErrDSONotSupported indicates that the server does not support DSO.
+	ErrDSONotSupported = errors.New("server does not support DSO")
+
+	// ErrDSOSessionClosed indicates that the [*DSOSession] is closed.
+	ErrDSOSessionClosed = errors.New("DSO session closed")
+
+	// ErrDSORetryDelay indicates that the server terminated the
+	// [*DSOSession] asking us to reconnect after a delay.
+	ErrDSORetryDelay = errors.New("server asked to retry the DSO session later")
+)
+
+// DSOSession is a DNS Stateful Operations session (RFC 8490) over a
+// DNS over TCP or DNS over TLS connection, which is the foundation for
+// stateful features such as DNS Push Notifications (RFC 8765).
+//
+// Construct using [*Transport.NewDSOSession]. The session sends the
+// Keepalive requests required by the keepalive interval advertised
+// by the server, honors the Retry Delay messages, and dispatches the
+// other unidirectional messages sent by the server to the handler.
+type DSOSession struct {
+	// addr is the server address.
+	addr *ServerAddr
+
+	// conn is the session connection.
+	conn net.Conn
+
+	// done is closed when we stop reading from the connection.
+	done chan struct{}
+
+	// handler is the optional handler of unidirectional messages.
+	handler func(msg *DSOMessage)
+
+	// t is the transport that created the session.
+	t *Transport
+
+	// wake wakes up the keepalive goroutine when the timers change.
+	wake chan struct{}
+
+	// writeMu serializes writing messages to conn.
+	writeMu sync.Mutex
+
+	// mu protects the following fields.
+	mu sync.Mutex
+
+	// pending maps the message ID to the outstanding request.
+	pending map[uint16]chan *DSOMessage
+
+	// err is non-nil once the session is broken or closed.
+	err error
+
+	// inactivityTimeout is the inactivity timeout set by the server.
+	inactivityTimeout time.Duration
+
+	// keepaliveInterval is the keepalive interval set by the server.
+	keepaliveInterval time.Duration
+}
+
+// NewDSOSession connects to the given [ProtocolTCP] or [ProtocolDoT]
+// server and establishes a DSO session by sending a Keepalive request.
+// The optional handler receives the unidirectional messages sent by
+// the server, except for Keepalive and Retry Delay, which the session
+// handles. We invoke the handler from the goroutine reading from the
+// connection, so it should not block. Close the session when done.
+//
+// When the server does not support DSO, this method fails with an
+// error wrapping [ErrDSONotSupported], in which case RFC 8490 requires
+// not attempting again to use DSO with the server for some time.
+func (t *Transport) NewDSOSession(ctx context.Context,
+	addr *ServerAddr, handler func(msg *DSOMessage)) (*DSOSession, error) {
+	// 1. connect to the server
+	conn, err := t.dialStream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. start reading from the connection
+	s := &DSOSession{
+		addr:              addr,
+		conn:              conn,
+		done:              make(chan struct{}),
+		handler:           handler,
+		t:                 t,
+		wake:              make(chan struct{}, 1),
+		pending:           make(map[uint16]chan *DSOMessage),
+		inactivityTimeout: DefaultDSOInactivityTimeout,
+		keepaliveInterval: DSOInfinity,
+	}
+	go s.readLoop()
+
+	// 3. establish the session, which succeeds when the server
+	// responds to the Keepalive request with its own timers
+	resp, err := s.Request(ctx, NewDSOKeepaliveTLV(DefaultDSOInactivityTimeout, DefaultDSOKeepaliveInterval))
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+	case RcodeDSOTypeNotImplemented, dns.RcodeNotImplemented, dns.RcodeFormatError:
+		s.Close()
+		return nil, fmt.Errorf("%w: rcode %d", ErrDSONotSupported, resp.Rcode)
+	default:
+		s.Close()
+		return nil, fmt.Errorf("%w: rcode %d", ErrServerMisbehaving, resp.Rcode)
+	}
+	if tlv, found := resp.PrimaryTLV(); !found || tlv.Type != DSOTypeKeepalive {
+		s.Close()
+		return nil, fmt.Errorf("%w: missing Keepalive TLV", ErrInvalidDSOMessage)
+	}
+
+	// 4. start sending keepalives as required by the server
+	go s.keepaliveLoop()
+	return s, nil
+}
+
+// dialStream dials a dedicated TCP or TLS connection for long-lived
+// exchanges, such as [*DSOSession] and [*Transport.TransferZone].
+func (t *Transport) dialStream(ctx context.Context, addr *ServerAddr) (net.Conn, error) {
+	switch addr.Protocol {
+	case ProtocolTCP:
+		conn, err := t.dialContext(ctx, "tcp", addr.Address)
+		if err != nil {
+			return nil, err
+		}
+		return t.newConn(addr, conn), nil
+
+	case ProtocolDoT:
+		conn, err := t.dialTLSContext(ctx, "tcp", addr.Address)
+		if err != nil {
+			t.maybeEmitHandshakeFailure(addr, err)
+			return nil, err
+		}
+		t.stats.onHandshake(addr)
+		return t.newConn(addr, conn), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchTransportProtocol, addr.Protocol)
+	}
+}
+
+// Request sends a DSO request containing the given TLVs, the first of
+// which is the primary TLV, and returns the response. A response with
+// a nonzero RCODE is not an error, since its meaning depends on the
+// operation, and callers should check the response RCODE.
+func (s *DSOSession) Request(ctx context.Context, tlvs ...DSOTLV) (*DSOMessage, error) {
+	// 1. register the request using an unused nonzero message ID
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := RandomID()
+	for _, found := s.pending[id]; id == 0 || found; _, found = s.pending[id] {
+		id = RandomID()
+	}
+	ch := make(chan *DSOMessage, 1)
+	s.pending[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	// 2. send the request
+	if err := s.write(ctx, &DSOMessage{ID: id, TLVs: tlvs}); err != nil {
+		return nil, err
+	}
+
+	// 3. wait for the response, the session to break, or the context to be done
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-s.done:
+		select {
+		case resp := <-ch:
+			return resp, nil
+		default:
+			return nil, s.Err()
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send sends a unidirectional DSO message containing the given
+// TLVs, the first of which is the primary TLV.
+func (s *DSOSession) Send(ctx context.Context, tlvs ...DSOTLV) error {
+	return s.write(ctx, &DSOMessage{TLVs: tlvs})
+}
+
+// write serializes and sends the given message, breaking the
+// session on failure, since the stream may contain a partial frame.
+func (s *DSOSession) write(ctx context.Context, msg *DSOMessage) error {
+	rawMsg, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	rawMsgFrame, err := newRawMsgFrame(s.addr, rawMsg)
+	if err != nil {
+		return err
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	deadline, _ := ctx.Deadline()
+	_ = s.conn.SetWriteDeadline(deadline)
+	_, err = s.conn.Write(rawMsgFrame)
+	s.writeMu.Unlock()
+	if err != nil {
+		s.fail(ConnCloseError, err)
+		return err
+	}
+	s.t.maybeCaptureSent(s.addr, s.conn, rawMsg)
+	s.t.stats.onSent(s.addr, len(rawMsgFrame))
+	return nil
+}
+
+// readLoop reads and handles the messages sent by the server
+// until the session is broken or closed.
+func (s *DSOSession) readLoop() {
+	defer close(s.done)
+	br := bufio.NewReader(s.conn)
+	for {
+		rawMsg, err := ReadMsgFrame(br)
+		if err != nil {
+			s.fail(ConnCloseError, err)
+			return
+		}
+		s.t.maybeCaptureReceived(s.addr, s.conn, rawMsg)
+
+		// RFC 8490 requires closing the connection on protocol errors
+		msg, err := UnpackDSOMessage(rawMsg)
+		if err != nil {
+			s.fail(ConnCloseError, err)
+			return
+		}
+		if msg.Response {
+			s.t.stats.onResponse(s.addr, len(rawMsg), msg.Rcode)
+		}
+		if err := s.handle(msg); err != nil {
+			s.fail(ConnCloseError, err)
+			return
+		}
+	}
+}
+
+// handle handles a message sent by the server.
+func (s *DSOSession) handle(msg *DSOMessage) error {
+	primary, hasPrimary := msg.PrimaryTLV()
+	switch {
+	// 1. responses may update the timers and complete a request
+	case msg.Response:
+		if msg.ID == 0 {
+			return fmt.Errorf("%w: unidirectional response", ErrInvalidDSOMessage)
+		}
+		if hasPrimary && primary.Type == DSOTypeKeepalive && msg.Rcode == dns.RcodeSuccess {
+			if err := s.updateTimers(primary); err != nil {
+				return err
+			}
+		}
+		s.mu.Lock()
+		ch := s.pending[msg.ID]
+		delete(s.pending, msg.ID)
+		s.mu.Unlock()
+		if ch == nil {
+			s.t.stats.onDiscarded(s.addr)
+			return nil
+		}
+		ch <- msg
+		return nil
+
+	// 2. we do not implement requests initiated by the server
+	case msg.ID != 0:
+		ctx, cancel := context.WithTimeout(context.Background(), dsoKeepaliveTimeout)
+		defer cancel()
+		_ = s.write(ctx, &DSOMessage{ID: msg.ID, Response: true, Rcode: RcodeDSOTypeNotImplemented})
+		return nil
+
+	// 3. handle the unidirectional messages
+	case !hasPrimary:
+		return fmt.Errorf("%w: missing primary TLV", ErrInvalidDSOMessage)
+	case primary.Type == DSOTypeKeepalive:
+		return s.updateTimers(primary)
+	case primary.Type == DSOTypeRetryDelay:
+		delay, err := ParseDSORetryDelayTLV(primary)
+		if err != nil {
+			return err
+		}
+		s.fail(ConnCloseServerRequest, fmt.Errorf("%w: %s", ErrDSORetryDelay, delay))
+		return nil
+	default:
+		if s.handler != nil {
+			s.handler(msg)
+		}
+		return nil
+	}
+}
+
+// updateTimers updates the timers using the given Keepalive TLV.
+func (s *DSOSession) updateTimers(tlv DSOTLV) error {
+	inactivityTimeout, keepaliveInterval, err := ParseDSOKeepaliveTLV(tlv)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.inactivityTimeout, s.keepaliveInterval = inactivityTimeout, keepaliveInterval
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// keepaliveLoop sends a Keepalive request every keepalive interval
+// until the session is broken or closed, breaking the session when
+// the server does not respond to the request in time.
+func (s *DSOSession) keepaliveLoop() {
+	for {
+		var (
+			timer   *time.Timer
+			timeout <-chan time.Time
+		)
+		if interval := s.KeepaliveInterval(); interval < DSOInfinity {
+			timer = time.NewTimer(interval)
+			timeout = timer.C
+		}
+		var expired bool
+		select {
+		case <-s.done:
+		case <-s.wake:
+		case <-timeout:
+			expired = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if !expired {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dsoKeepaliveTimeout)
+		_, err := s.Request(ctx, NewDSOKeepaliveTLV(DefaultDSOInactivityTimeout, DefaultDSOKeepaliveInterval))
+		cancel()
+		if err != nil {
+			s.fail(ConnCloseError, err)
+			return
+		}
+	}
+}
+
+// InactivityTimeout returns the inactivity timeout set by the server, after
+// which, when there are no outstanding operations, RFC 8490 requires the
+// client to close the session. The session does not enforce it, since only
+// the caller knows whether there are long-lived operations in progress.
+func (s *DSOSession) InactivityTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inactivityTimeout
+}
+
+// KeepaliveInterval returns the keepalive interval set by the server.
+func (s *DSOSession) KeepaliveInterval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keepaliveInterval
+}
+
+// Done returns a channel closed when the session is broken or closed.
+func (s *DSOSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that broke the session, which wraps [ErrDSORetryDelay]
+// when the server asked to reconnect later, [ErrDSOSessionClosed] when we
+// closed the session, or nil if the session is still working.
+func (s *DSOSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// fail breaks the session for the given reason and error.
+func (s *DSOSession) fail(reason ConnCloseReason, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	if reason == ConnCloseError {
+		closeConn(s.conn, reason, err)
+	} else {
+		closeConn(s.conn, reason, nil)
+	}
+}
+
+// Close closes the session and waits for the reading goroutine to exit.
+func (s *DSOSession) Close() error {
+	s.fail(ConnCloseDone, ErrDSOSessionClosed)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// readDSOTestMessage reads a DSO message or returns nil on failure.
+func readDSOTestMessage(br *bufio.Reader) *DSOMessage {
+	rawMsg, err := ReadMsgFrame(br)
+	if err != nil {
+		return nil
+	}
+	msg, err := UnpackDSOMessage(rawMsg)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// writeDSOTestMessage writes the given DSO message.
+func writeDSOTestMessage(conn net.Conn, msg *DSOMessage) {
+	rawMsg, _ := msg.Pack()
+	frame, _ := newRawMsgFrame(&ServerAddr{}, rawMsg)
+	conn.Write(frame)
+}
+
+// serveDSOTestSession establishes the session using the given timers
+// and then invokes fn, if not nil, for each following request.
+func serveDSOTestSession(inactivity, interval time.Duration,
+	fn func(conn net.Conn, msg *DSOMessage)) func(conn net.Conn, br *bufio.Reader) {
+	return func(conn net.Conn, br *bufio.Reader) {
+		for msg := readDSOTestMessage(br); msg != nil; msg = readDSOTestMessage(br) {
+			if primary, _ := msg.PrimaryTLV(); primary.Type == DSOTypeKeepalive {
+				writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true,
+					TLVs: []DSOTLV{NewDSOKeepaliveTLV(inactivity, interval)}})
+				continue
+			}
+			if fn != nil {
+				fn(conn, msg)
+			}
+		}
+	}
+}
+
+func TestTransport_NewDSOSession(t *testing.T) {
+	t.Run("establishes the session using the server timers", func(t *testing.T) {
+		addr, _ := startStreamServer(t, serveDSOTestSession(30*time.Second, DSOInfinity, nil))
+		txp := &Transport{}
+		session, err := txp.NewDSOSession(context.Background(), addr, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 30*time.Second, session.InactivityTimeout())
+		assert.Equal(t, DSOInfinity, session.KeepaliveInterval())
+		assert.NoError(t, session.Err())
+
+		assert.NoError(t, session.Close())
+		assert.ErrorIs(t, session.Err(), ErrDSOSessionClosed)
+		_, err = session.Request(context.Background(), DSOTLV{Type: 0x40})
+		assert.ErrorIs(t, err, ErrDSOSessionClosed)
+		stats := txp.Stats()[0]
+		assert.Equal(t, int64(1), stats.NewConns)
+		assert.Equal(t, int64(1), stats.Responses)
+	})
+
+	t.Run("servers not supporting DSO", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			rcode    int
+			expected error
+		}{
+			{"DSOTYPENI", RcodeDSOTypeNotImplemented, ErrDSONotSupported},
+			{"NOTIMP", dns.RcodeNotImplemented, ErrDSONotSupported},
+			{"REFUSED", dns.RcodeRefused, ErrServerMisbehaving},
+			{"missing Keepalive TLV", dns.RcodeSuccess, ErrInvalidDSOMessage},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				addr, _ := startStreamServer(t, func(conn net.Conn, br *bufio.Reader) {
+					if msg := readDSOTestMessage(br); msg != nil {
+						writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true, Rcode: tt.rcode})
+					}
+				})
+				_, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+				assert.ErrorIs(t, err, tt.expected)
+			})
+		}
+	})
+
+	t.Run("connection failures", func(t *testing.T) {
+		addr, _ := startStreamServer(t, func(conn net.Conn, br *bufio.Reader) {})
+		_, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("unsupported protocols", func(t *testing.T) {
+		addr := NewServerAddr(ProtocolUDP, "127.0.0.1:53")
+		_, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+		assert.ErrorIs(t, err, ErrNoSuchTransportProtocol)
+	})
+}
+
+func TestDSOSession(t *testing.T) {
+	t.Run("requests", func(t *testing.T) {
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true, Rcode: dns.RcodeRefused, TLVs: msg.TLVs})
+		}))
+		session, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+		assert.NoError(t, err)
+		defer session.Close()
+
+		tlv := DSOTLV{Type: 0x40, Data: []byte("example.com")}
+		resp, err := session.Request(context.Background(), tlv)
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+		assert.Equal(t, []DSOTLV{tlv}, resp.TLVs)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = session.Request(ctx, tlv)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("keepalives", func(t *testing.T) {
+		var keepalives atomic.Int64
+		addr, _ := startStreamServer(t, func(conn net.Conn, br *bufio.Reader) {
+			for msg := readDSOTestMessage(br); msg != nil; msg = readDSOTestMessage(br) {
+				keepalives.Add(1)
+				writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true,
+					TLVs: []DSOTLV{NewDSOKeepaliveTLV(time.Minute, 10*time.Millisecond)}})
+			}
+		})
+		session, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+		assert.NoError(t, err)
+		defer session.Close()
+		assert.Eventually(t, func() bool { return keepalives.Load() >= 3 }, time.Second, time.Millisecond)
+		assert.NoError(t, session.Err())
+	})
+
+	t.Run("unidirectional messages", func(t *testing.T) {
+		push := &DSOMessage{TLVs: []DSOTLV{{Type: 0x41, Data: []byte("update")}}}
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			writeDSOTestMessage(conn, push)
+			writeDSOTestMessage(conn, &DSOMessage{TLVs: []DSOTLV{NewDSOKeepaliveTLV(time.Hour, DSOInfinity)}})
+			writeDSOTestMessage(conn, &DSOMessage{TLVs: []DSOTLV{{Type: DSOTypeRetryDelay, Data: []byte{0, 0, 0x03, 0xe8}}}})
+		}))
+		received := make(chan *DSOMessage, 1)
+		session, err := (&Transport{}).NewDSOSession(context.Background(), addr, func(msg *DSOMessage) {
+			received <- msg
+		})
+		assert.NoError(t, err)
+		defer session.Close()
+
+		assert.NoError(t, session.Send(context.Background(), DSOTLV{Type: 0x40}))
+		assert.Equal(t, push, <-received)
+		<-session.Done()
+		assert.ErrorIs(t, session.Err(), ErrDSORetryDelay)
+		assert.Equal(t, time.Hour, session.InactivityTimeout())
+	})
+
+	t.Run("requests initiated by the server", func(t *testing.T) {
+		responses := make(chan *DSOMessage, 1)
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			if msg.Response {
+				responses <- msg
+				return
+			}
+			writeDSOTestMessage(conn, &DSOMessage{ID: 77, TLVs: []DSOTLV{{Type: 0x40}}})
+		}))
+		session, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+		assert.NoError(t, err)
+		defer session.Close()
+
+		assert.NoError(t, session.Send(context.Background(), DSOTLV{Type: 0x40}))
+		resp := <-responses
+		assert.Equal(t, uint16(77), resp.ID)
+		assert.Equal(t, RcodeDSOTypeNotImplemented, resp.Rcode)
+	})
+
+	t.Run("protocol errors break the session", func(t *testing.T) {
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			writeDSOTestMessage(conn, &DSOMessage{Response: true})
+		}))
+		session, err := (&Transport{}).NewDSOSession(context.Background(), addr, nil)
+		assert.NoError(t, err)
+		defer session.Close()
+
+		_, err = session.Request(context.Background(), DSOTLV{Type: 0x40})
+		assert.ErrorIs(t, err, ErrInvalidDSOMessage)
+	})
+}
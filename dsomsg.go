@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// OpcodeDSO is the DNS Stateful Operations (DSO) opcode defined by RFC 8490.
+const OpcodeDSO = 6
+
+// RcodeDSOTypeNotImplemented is the DSOTYPENI RCODE defined by RFC 8490,
+// which indicates that the server does not implement the primary TLV type.
+const RcodeDSOTypeNotImplemented = 11
+
+// DSO TLV types defined by RFC 8490.
+const (
+	// DSOTypeKeepalive is the Keepalive TLV type.
+	DSOTypeKeepalive = uint16(0x0001)
+
+	// DSOTypeRetryDelay is the Retry Delay TLV type.
+	DSOTypeRetryDelay = uint16(0x0002)
+
+	// DSOTypeEncryptionPadding is the Encryption Padding TLV type.
+	DSOTypeEncryptionPadding = uint16(0x0003)
+)
+
+// DSOInfinity is the infinite duration that RFC 8490 timers may have,
+// e.g., when the server does not want the client to send keepalives.
+const DSOInfinity = time.Duration(math.MaxInt64)
+
+// ErrInvalidDSOMessage indicates that a DSO message is malformed.
+var ErrInvalidDSOMessage = errors.New("invalid DSO message")
+
+// DSOTLV is a DSO type-length-value element.
+type DSOTLV struct {
+	// Type is the TLV type.
+	Type uint16
+
+	// Data is the TLV value.
+	Data []byte
+}
+
+// DSOMessage is a DNS Stateful Operations message as defined by RFC 8490.
+//
+// The first TLV of a request or unidirectional message is the primary TLV,
+// which defines the operation, while the following ones are additional TLVs.
+// Responses may contain a primary TLV of the same type of the request.
+type DSOMessage struct {
+	// ID is the message ID, which is zero for unidirectional messages.
+	ID uint16
+
+	// Response indicates that this is a response.
+	Response bool
+
+	// Rcode is the RCODE, which is only meaningful for responses.
+	Rcode int
+
+	// TLVs contains the TLVs.
+	TLVs []DSOTLV
+}
+
+// PrimaryTLV returns the primary TLV, if any.
+func (m *DSOMessage) PrimaryTLV() (DSOTLV, bool) {
+	if len(m.TLVs) <= 0 {
+		return DSOTLV{}, false
+	}
+	return m.TLVs[0], true
+}
+
+// Pack serializes the message to the wire format.
+func (m *DSOMessage) Pack() ([]byte, error) {
+	// 1. serialize the header, where all the section counts are zero
+	raw := make([]byte, rawHeaderSize, rawHeaderSize+64)
+	binary.BigEndian.PutUint16(raw[0:], m.ID)
+	flags := uint16(OpcodeDSO)<<11 | uint16(m.Rcode&0x0f)
+	if m.Response {
+		flags |= 1 << 15
+	}
+	binary.BigEndian.PutUint16(raw[2:], flags)
+
+	// 2. serialize the TLVs
+	for _, tlv := range m.TLVs {
+		if len(tlv.Data) > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: TLV too large: %d bytes", ErrInvalidDSOMessage, len(tlv.Data))
+		}
+		raw = binary.BigEndian.AppendUint16(raw, tlv.Type)
+		raw = binary.BigEndian.AppendUint16(raw, uint16(len(tlv.Data)))
+		raw = append(raw, tlv.Data...)
+	}
+	return raw, nil
+}
+
+// UnpackDSOMessage parses a DSO message from the wire format.
+func UnpackDSOMessage(raw []byte) (*DSOMessage, error) {
+	// 1. parse the header
+	if len(raw) < rawHeaderSize {
+		return nil, fmt.Errorf("%w: message too short", ErrInvalidDSOMessage)
+	}
+	flags := binary.BigEndian.Uint16(raw[2:])
+	if opcode := int(flags>>11) & 0x0f; opcode != OpcodeDSO {
+		return nil, fmt.Errorf("%w: unexpected opcode: %d", ErrInvalidDSOMessage, opcode)
+	}
+	for off := 4; off < rawHeaderSize; off += 2 {
+		if binary.BigEndian.Uint16(raw[off:]) != 0 {
+			return nil, fmt.Errorf("%w: nonzero section count", ErrInvalidDSOMessage)
+		}
+	}
+	msg := &DSOMessage{
+		ID:       binary.BigEndian.Uint16(raw[0:]),
+		Response: flags&(1<<15) != 0,
+		Rcode:    int(flags & 0x0f),
+	}
+
+	// 2. parse the TLVs
+	for raw = raw[rawHeaderSize:]; len(raw) > 0; {
+		if len(raw) < 4 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidDSOMessage)
+		}
+		length := int(binary.BigEndian.Uint16(raw[2:]))
+		if len(raw) < 4+length {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidDSOMessage)
+		}
+		msg.TLVs = append(msg.TLVs, DSOTLV{
+			Type: binary.BigEndian.Uint16(raw[0:]),
+			Data: raw[4 : 4+length],
+		})
+		raw = raw[4+length:]
+	}
+	return msg, nil
+}
+
+// dsoDurationToMillis converts a duration to RFC 8490 milliseconds.
+func dsoDurationToMillis(d time.Duration) uint32 {
+	if d >= DSOInfinity || d.Milliseconds() >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(max(d.Milliseconds(), 0))
+}
+
+// dsoMillisToDuration converts RFC 8490 milliseconds to a duration.
+func dsoMillisToDuration(millis uint32) time.Duration {
+	if millis == math.MaxUint32 {
+		return DSOInfinity
+	}
+	return time.Duration(millis) * time.Millisecond
+}
+
+// NewDSOKeepaliveTLV returns a Keepalive TLV containing the given
+// inactivity timeout and keepalive interval, where [DSOInfinity]
+// means that the corresponding timer is infinite.
+func NewDSOKeepaliveTLV(inactivityTimeout, keepaliveInterval time.Duration) DSOTLV {
+	data := binary.BigEndian.AppendUint32(nil, dsoDurationToMillis(inactivityTimeout))
+	data = binary.BigEndian.AppendUint32(data, dsoDurationToMillis(keepaliveInterval))
+	return DSOTLV{Type: DSOTypeKeepalive, Data: data}
+}
+
+// ParseDSOKeepaliveTLV returns the inactivity timeout and keepalive
+// interval contained in a Keepalive TLV, where [DSOInfinity] means
+// that the corresponding timer is infinite.
+func ParseDSOKeepaliveTLV(tlv DSOTLV) (inactivityTimeout, keepaliveInterval time.Duration, err error) {
+	if tlv.Type != DSOTypeKeepalive || len(tlv.Data) != 8 {
+		return 0, 0, fmt.Errorf("%w: invalid Keepalive TLV", ErrInvalidDSOMessage)
+	}
+	inactivityTimeout = dsoMillisToDuration(binary.BigEndian.Uint32(tlv.Data[0:]))
+	keepaliveInterval = dsoMillisToDuration(binary.BigEndian.Uint32(tlv.Data[4:]))
+	return
+}
+
+// ParseDSORetryDelayTLV returns the delay contained in a Retry Delay TLV,
+// which is the time the client should wait before reconnecting.
+func ParseDSORetryDelayTLV(tlv DSOTLV) (time.Duration, error) {
+	if tlv.Type != DSOTypeRetryDelay || len(tlv.Data) != 4 {
+		return 0, fmt.Errorf("%w: invalid Retry Delay TLV", ErrInvalidDSOMessage)
+	}
+	return dsoMillisToDuration(binary.BigEndian.Uint32(tlv.Data)), nil
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSOMessage(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		msg := &DSOMessage{
+			ID:       0x1234,
+			Response: true,
+			Rcode:    RcodeDSOTypeNotImplemented,
+			TLVs: []DSOTLV{
+				NewDSOKeepaliveTLV(15*time.Second, DSOInfinity),
+				{Type: DSOTypeEncryptionPadding, Data: []byte{}},
+			},
+		}
+		raw, err := msg.Pack()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x12, 0x34, 0xb0, 0x0b, 0, 0, 0, 0, 0, 0, 0, 0}, raw[:rawHeaderSize])
+
+		parsed, err := UnpackDSOMessage(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, msg, parsed)
+		primary, found := parsed.PrimaryTLV()
+		assert.True(t, found)
+		inactivity, interval, err := ParseDSOKeepaliveTLV(primary)
+		assert.NoError(t, err)
+		assert.Equal(t, 15*time.Second, inactivity)
+		assert.Equal(t, DSOInfinity, interval)
+	})
+
+	t.Run("without TLVs", func(t *testing.T) {
+		raw, err := (&DSOMessage{}).Pack()
+		assert.NoError(t, err)
+		parsed, err := UnpackDSOMessage(raw)
+		assert.NoError(t, err)
+		_, found := parsed.PrimaryTLV()
+		assert.False(t, found)
+	})
+
+	t.Run("TLV too large", func(t *testing.T) {
+		_, err := (&DSOMessage{TLVs: []DSOTLV{{Data: make([]byte, 1<<16)}}}).Pack()
+		assert.ErrorIs(t, err, ErrInvalidDSOMessage)
+	})
+}
+
+func TestUnpackDSOMessage(t *testing.T) {
+	header := []byte{0, 1, 0x30, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"too short", header[:11]},
+		{"unexpected opcode", append([]byte{0, 1, 0, 0}, header[4:]...)},
+		{"nonzero section count", append(append([]byte{}, header[:5]...), 1, 0, 0, 0, 0, 0, 0)},
+		{"truncated TLV header", append(append([]byte{}, header...), 0, 1, 0)},
+		{"truncated TLV data", append(append([]byte{}, header...), 0, 1, 0, 2, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UnpackDSOMessage(tt.raw)
+			assert.ErrorIs(t, err, ErrInvalidDSOMessage)
+		})
+	}
+}
+
+func TestParseDSOTLVs(t *testing.T) {
+	t.Run("Keepalive", func(t *testing.T) {
+		_, _, err := ParseDSOKeepaliveTLV(DSOTLV{Type: DSOTypeKeepalive, Data: []byte{0}})
+		assert.ErrorIs(t, err, ErrInvalidDSOMessage)
+		_, _, err = ParseDSOKeepaliveTLV(DSOTLV{Type: DSOTypeRetryDelay, Data: make([]byte, 8)})
+		assert.ErrorIs(t, err, ErrInvalidDSOMessage)
+		inactivity, interval, err := ParseDSOKeepaliveTLV(NewDSOKeepaliveTLV(-time.Second, 1<<60))
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), inactivity)
+		assert.Equal(t, DSOInfinity, interval)
+	})
+
+	t.Run("Retry Delay", func(t *testing.T) {
+		delay, err := ParseDSORetryDelayTLV(DSOTLV{Type: DSOTypeRetryDelay, Data: []byte{0, 0, 0x03, 0xe8}})
+		assert.NoError(t, err)
+		assert.Equal(t, time.Second, delay)
+		_, err = ParseDSORetryDelayTLV(DSOTLV{Type: DSOTypeRetryDelay})
+		assert.ErrorIs(t, err, ErrInvalidDSOMessage)
+	})
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// QueryOptionClientSubnet adds the EDNS Client Subnet (ECS) option
+// defined by RFC 7871 containing the given subnet, whose address bits
+// beyond the prefix length we clear, as RFC 7871 Sect. 6 requires.
+//
+// Use this option after [QueryOptionEDNS0], whose OPT RR we extend,
+// otherwise we add an OPT RR using [EDNS0SuggestedMaxResponseSizeOtherwise].
+// If the query is padded, we recompute the padding after adding ECS.
+func QueryOptionClientSubnet(subnet netip.Prefix) QueryOption {
+	subnet = subnet.Masked()
+	return func(q *dns.Msg) error {
+		// 1. make sure there is an OPT RR and remove the padding, if any
+		opt := q.IsEdns0()
+		if opt == nil {
+			q.SetEdns0(EDNS0SuggestedMaxResponseSizeOtherwise, false)
+			opt = q.IsEdns0()
+		}
+		var padded bool
+		options := opt.Option[:0]
+		for _, option := range opt.Option {
+			if _, ok := option.(*dns.EDNS0_PADDING); ok {
+				padded = true
+				continue
+			}
+			options = append(options, option)
+		}
+		opt.Option = options
+
+		// 2. add the ECS option
+		ecs := &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(subnet.Bits()),
+			Address:       net.IP(subnet.Addr().AsSlice()),
+		}
+		if subnet.Addr().Is6() {
+			ecs.Family = 2
+		}
+		opt.Option = append(opt.Option, ecs)
+
+		// 3. pad again, if needed
+		if padded {
+			addBlockLengthPadding(q)
+		}
+		return nil
+	}
+}
+
+// ResponseClientSubnet returns the scope of the answer contained in the
+// ECS option of a response, as defined by RFC 7871, and whether the
+// response contains the option. The scope is the subnet of clients to
+// which the answer applies. A zero-length scope means the answer applies
+// to all clients. Request the option by using [QueryOptionClientSubnet].
+func ResponseClientSubnet(resp *dns.Msg) (netip.Prefix, bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return netip.Prefix{}, false
+	}
+	for _, option := range opt.Option {
+		ecs, ok := option.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ecs.Address)
+		if !ok {
+			return netip.Prefix{}, false
+		}
+		if ecs.Family == 1 {
+			addr = addr.Unmap()
+		}
+		scope, err := addr.Prefix(int(ecs.SourceScope))
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		return scope, true
+	}
+	return netip.Prefix{}, false
+}
+
+// clientSubnetScope returns the subnet to use for caching the answer
+// obtained by sending the given subnet, or the zero prefix when the
+// answer applies to all clients. Following RFC 7871 Sect. 7.3.1, we
+// never use a scope longer than the subnet we sent, and we consider
+// responses without ECS as applying to all clients.
+func clientSubnetScope(subnet netip.Prefix, resp *dns.Msg) netip.Prefix {
+	if !subnet.IsValid() {
+		return netip.Prefix{}
+	}
+	subnet = subnet.Masked()
+	scope, found := ResponseClientSubnet(resp)
+	switch {
+	case !found:
+		return netip.Prefix{}
+
+	// a scope for a different subnet is bogus, so we
+	// conservatively restrict the answer to our subnet
+	case scope.Addr().BitLen() != subnet.Addr().BitLen():
+		return subnet
+	case !subnet.Contains(scope.Addr()) && !scope.Contains(subnet.Addr()):
+		return subnet
+
+	case scope.Bits() <= 0:
+		return netip.Prefix{}
+	default:
+		return netip.PrefixFrom(subnet.Addr(), min(scope.Bits(), subnet.Bits())).Masked()
+	}
+}
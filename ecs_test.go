@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryOptionClientSubnet(t *testing.T) {
+	clientSubnet := func(t *testing.T, query *dns.Msg) *dns.EDNS0_SUBNET {
+		for _, option := range query.IsEdns0().Option {
+			if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+				return ecs
+			}
+		}
+		t.Fatal("no ECS option")
+		return nil
+	}
+
+	t.Run("IPv4 without OPT RR", func(t *testing.T) {
+		query, err := NewQuery("example.com", dns.TypeA,
+			QueryOptionClientSubnet(netip.MustParsePrefix("192.0.2.77/24")))
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(EDNS0SuggestedMaxResponseSizeOtherwise), query.IsEdns0().UDPSize())
+		ecs := clientSubnet(t, query)
+		assert.Equal(t, uint16(1), ecs.Family)
+		assert.Equal(t, uint8(24), ecs.SourceNetmask)
+		assert.True(t, net.IPv4(192, 0, 2, 0).Equal(ecs.Address))
+
+		// make sure the query survives a round trip
+		data, err := query.Pack()
+		assert.NoError(t, err)
+		assert.NoError(t, query.Unpack(data))
+		assert.True(t, net.IPv4(192, 0, 2, 0).Equal(clientSubnet(t, query).Address))
+	})
+
+	t.Run("IPv6 recomputing the padding", func(t *testing.T) {
+		query, err := NewQuery("example.com", dns.TypeAAAA,
+			QueryOptionEDNS0(EDNS0SuggestedMaxResponseSizeUDP, EDNS0FlagBlockLengthPadding),
+			QueryOptionClientSubnet(netip.MustParsePrefix("2001:db8:1:2::/56")))
+		assert.NoError(t, err)
+		ecs := clientSubnet(t, query)
+		assert.Equal(t, uint16(2), ecs.Family)
+		assert.Equal(t, uint8(56), ecs.SourceNetmask)
+		assert.Equal(t, uint16(EDNS0SuggestedMaxResponseSizeUDP), query.IsEdns0().UDPSize())
+		options := query.IsEdns0().Option
+		assert.Len(t, options, 2)
+		assert.IsType(t, &dns.EDNS0_PADDING{}, options[1])
+		assert.Equal(t, 0, query.Len()%128)
+	})
+
+	t.Run("sharing the option among concurrent queries", func(t *testing.T) {
+		option := QueryOptionClientSubnet(netip.MustParsePrefix("192.0.2.77/24"))
+		wg := &sync.WaitGroup{}
+		for idx := 0; idx < 4; idx++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				query, err := NewQuery("example.com", dns.TypeA, option)
+				assert.NoError(t, err)
+				assert.True(t, net.IPv4(192, 0, 2, 0).Equal(clientSubnet(t, query).Address))
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestResponseClientSubnet(t *testing.T) {
+	query, _ := NewQuery("example.com", dns.TypeA,
+		QueryOptionClientSubnet(netip.MustParsePrefix("192.0.2.0/24")))
+
+	t.Run("with ECS", func(t *testing.T) {
+		scope, found := ResponseClientSubnet(newClientSubnetResponse(query, 16))
+		assert.True(t, found)
+		assert.Equal(t, netip.MustParsePrefix("192.0.0.0/16"), scope)
+	})
+
+	t.Run("without ECS", func(t *testing.T) {
+		_, found := ResponseClientSubnet(newClientSubnetResponse(&dns.Msg{}, 16))
+		assert.False(t, found)
+		plain, _ := NewQuery("example.com", dns.TypeA, QueryOptionEDNS0(1232, 0))
+		_, found = ResponseClientSubnet(newClientSubnetResponse(plain, 16))
+		assert.False(t, found)
+	})
+
+	t.Run("with an invalid scope", func(t *testing.T) {
+		_, found := ResponseClientSubnet(newClientSubnetResponse(query, 64))
+		assert.False(t, found)
+	})
+}
+
+func Test_clientSubnetScope(t *testing.T) {
+	subnet := netip.MustParsePrefix("192.0.2.0/24")
+	query, _ := NewQuery("example.com", dns.TypeA, QueryOptionClientSubnet(subnet))
+	query6, _ := NewQuery("example.com", dns.TypeA,
+		QueryOptionClientSubnet(netip.MustParsePrefix("2001:db8::/56")))
+	other, _ := NewQuery("example.com", dns.TypeA,
+		QueryOptionClientSubnet(netip.MustParsePrefix("198.51.100.0/24")))
+
+	tests := []struct {
+		name     string
+		subnet   netip.Prefix
+		resp     *dns.Msg
+		expected netip.Prefix
+	}{
+		{"without our subnet", netip.Prefix{}, newClientSubnetResponse(query, 24), netip.Prefix{}},
+		{"without ECS in the response", subnet, newClientSubnetResponse(&dns.Msg{}, 0), netip.Prefix{}},
+		{"with a global scope", subnet, newClientSubnetResponse(query, 0), netip.Prefix{}},
+		{"with a shorter scope", subnet, newClientSubnetResponse(query, 20), netip.MustParsePrefix("192.0.0.0/20")},
+		{"with a longer scope", subnet, newClientSubnetResponse(query, 28), subnet},
+		{"with another family", subnet, newClientSubnetResponse(query6, 0), subnet},
+		{"with another subnet", subnet, newClientSubnetResponse(other, 24), subnet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, clientSubnetScope(tt.subnet, tt.resp))
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResponseExpire returns the zone expire timer contained in the EDNS
+// EXPIRE option of a response, as defined by RFC 7314, and whether the
+// response contains the option. Request the option by using the
+// [EDNS0FlagExpire] flag with [QueryOptionEDNS0].
+//
+// Secondary servers should use this timer, instead of the SOA EXPIRE
+// field, to expire zones transferred from other secondaries, and should
+// not accept a response whose option is empty as containing a timer.
+func ResponseExpire(resp *dns.Msg) (time.Duration, bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, option := range opt.Option {
+		if expire, ok := option.(*dns.EDNS0_EXPIRE); ok && !expire.Empty {
+			return time.Duration(expire.Expire) * time.Second, true
+		}
+	}
+	return 0, false
+}
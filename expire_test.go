@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseExpire(t *testing.T) {
+	// make sure the query contains an empty option that survives the wire
+	query, err := NewQuery("example.com", dns.TypeSOA,
+		QueryOptionEDNS0(EDNS0SuggestedMaxResponseSizeOtherwise, EDNS0FlagExpire|EDNS0FlagBlockLengthPadding))
+	assert.NoError(t, err)
+	rawQuery, err := query.Pack()
+	assert.NoError(t, err)
+	assert.Zero(t, len(rawQuery)%128)
+	parsed := &dns.Msg{}
+	assert.NoError(t, parsed.Unpack(rawQuery))
+	var found bool
+	for _, option := range parsed.IsEdns0().Option {
+		if expire, ok := option.(*dns.EDNS0_EXPIRE); ok {
+			found = expire.Empty
+		}
+	}
+	assert.True(t, found)
+	_, ok := ResponseExpire(parsed)
+	assert.False(t, ok)
+
+	// make sure we parse the timer from a response
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	_, ok = ResponseExpire(resp)
+	assert.False(t, ok)
+
+	resp.SetEdns0(4096, false)
+	_, ok = ResponseExpire(resp)
+	assert.False(t, ok)
+
+	resp.IsEdns0().Option = append(resp.IsEdns0().Option,
+		&dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Expire: 604800})
+	rawResp, err := resp.Pack()
+	assert.NoError(t, err)
+	resp = &dns.Msg{}
+	assert.NoError(t, resp.Unpack(rawResp))
+	expire, ok := ResponseExpire(resp)
+	assert.True(t, ok)
+	assert.Equal(t, 7*24*time.Hour, expire)
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import "expvar"
+
+// ExpvarStats is the value published by [PublishExpvar].
+type ExpvarStats struct {
+	// Servers contains the [*Transport] statistics, if any.
+	Servers []ServerStats `json:"servers,omitempty"`
+
+	// Cache contains the [*Cache] statistics, if any.
+	Cache *CacheStats `json:"cache,omitempty"`
+}
+
+// PublishExpvar publishes the statistics of the given transport and cache,
+// either of which may be nil, using [expvar] with the given name, such that
+// services importing [expvar] expose them on /debug/vars. We compute the
+// statistics each time the variable is read. Like [expvar.Publish], this
+// function panics when a variable with the same name already exists.
+func PublishExpvar(name string, txp *Transport, cache *Cache) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return newExpvarStats(txp, cache)
+	}))
+}
+
+// newExpvarStats returns the current statistics of the given transport and cache.
+func newExpvarStats(txp *Transport, cache *Cache) *ExpvarStats {
+	stats := &ExpvarStats{}
+	if txp != nil {
+		stats.Servers = txp.Stats()
+	}
+	if cache != nil {
+		cacheStats := cache.Stats()
+		stats.Cache = &cacheStats
+	}
+	return stats
+}
@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	// 1. collect some statistics
+	txp := &Transport{}
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	txp.stats.onQuery(addr)
+	txp.stats.onResponse(addr, 128, 0)
+	cache := &Cache{}
+
+	// 2. publish them and read them back
+	PublishExpvar("dnscore_test", txp, cache)
+	var stats ExpvarStats
+	assert.NoError(t, json.Unmarshal([]byte(expvar.Get("dnscore_test").String()), &stats))
+	if assert.Len(t, stats.Servers, 1) {
+		assert.Equal(t, "8.8.8.8:53", stats.Servers[0].Address)
+		assert.Equal(t, int64(1), stats.Servers[0].Queries)
+		assert.Equal(t, map[int]int64{0: 1}, stats.Servers[0].Rcodes)
+	}
+	assert.Equal(t, &CacheStats{}, stats.Cache)
+
+	// 3. make sure we panic on duplicate names
+	assert.Panics(t, func() { PublishExpvar("dnscore_test", nil, nil) })
+}
+
+func TestNewExpvarStats(t *testing.T) {
+	assert.Equal(t, &ExpvarStats{}, newExpvarStats(nil, nil))
+	data, err := json.Marshal(newExpvarStats(nil, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+}
@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ServerHealth is the health state of a configured server.
+type ServerHealth struct {
+	// Addr is the server address.
+	Addr *ServerAddr
+
+	// Healthy indicates whether the last probe succeeded.
+	Healthy bool
+
+	// ConsecutiveFailures is the number of consecutive failed probes.
+	ConsecutiveFailures int
+
+	// LastCheck is when we last probed the server.
+	LastCheck time.Time
+
+	// LastErr is the error returned by the last probe, if any.
+	LastErr error
+}
+
+// ServerHealth returns a snapshot of the health state of the configured
+// servers in the same order in which they have been added. Servers that
+// have not been probed yet are reported as healthy.
+func (c *ResolverConfig) ServerHealth() []ServerHealth {
+	servers := c.servers()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]ServerHealth, 0, len(servers))
+	for _, server := range servers {
+		if state, ok := c.health[server.address.key()]; ok {
+			out = append(out, *state)
+			continue
+		}
+		out = append(out, ServerHealth{Addr: server.address, Healthy: true})
+	}
+	return out
+}
+
+// updateHealth records the result of probing the given server and returns
+// whether the server health changed as a result of the probe.
+func (c *ResolverConfig) updateHealth(addr *ServerAddr, t time.Time, err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.health == nil {
+		c.health = make(map[serverKey]*ServerHealth)
+	}
+	state, ok := c.health[addr.key()]
+	if !ok {
+		state = &ServerHealth{Addr: addr, Healthy: true}
+		c.health[addr.key()] = state
+	}
+	wasHealthy := state.Healthy
+	state.Healthy = err == nil
+	state.LastCheck = t
+	state.LastErr = err
+	if err != nil {
+		state.ConsecutiveFailures++
+	} else {
+		state.ConsecutiveFailures = 0
+	}
+	return wasHealthy != state.Healthy
+}
+
+// healthyServers returns the given servers that are not known to be
+// unhealthy and did not ask us to retry later. If all servers are
+// unhealthy, we return all of them since trying a possibly-broken server
+// beats failing immediately. The now argument is the current time.
+func (c *ResolverConfig) healthyServers(servers []resolverConfigServer, now time.Time) []resolverConfigServer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	healthy := make([]resolverConfigServer, 0, len(servers))
+	for _, server := range servers {
+		if state, ok := c.health[server.address.key()]; ok && !state.Healthy {
+			continue
+		}
+		if now.Before(c.retryAfter[server.address.key()]) {
+			continue
+		}
+		healthy = append(healthy, server)
+	}
+	if len(healthy) <= 0 {
+		return servers
+	}
+	return healthy
+}
+
+// DefaultHealthCheckInterval is the default interval between health checks.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// HealthChecker periodically probes the servers configured inside
+// a [*ResolverConfig] and records whether they are healthy. The
+// [*Resolver] skips unhealthy servers unless all servers are unhealthy.
+//
+// Construct using [NewHealthChecker].
+type HealthChecker struct {
+	// Config is the MANDATORY resolver configuration to check.
+	Config *ResolverConfig
+
+	// Interval is the optional interval between health checks.
+	//
+	// If zero, we use [DefaultHealthCheckInterval].
+	Interval time.Duration
+
+	// Logger is the optional structured logger. When set, we emit
+	// a "dnsServerHealth" event each time a server health changes.
+	Logger *slog.Logger
+
+	// ProbeName is the name to query when probing servers.
+	//
+	// If empty, we query for the root zone.
+	ProbeName string
+
+	// ProbeType is the query type to use when probing servers.
+	//
+	// If zero, we use [dns.TypeNS].
+	ProbeType uint16
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the optional DNS transport to use for probing.
+	//
+	// If nil, we use [DefaultTransport].
+	Transport ResolverTransport
+}
+
+// NewHealthChecker creates a new [*HealthChecker] for the given config.
+func NewHealthChecker(config *ResolverConfig) *HealthChecker {
+	return &HealthChecker{Config: config}
+}
+
+// transport returns the transport to use for probing.
+func (hc *HealthChecker) transport() ResolverTransport {
+	if hc.Transport != nil {
+		return hc.Transport
+	}
+	return DefaultTransport
+}
+
+// timeNow returns the current time.
+func (hc *HealthChecker) timeNow() time.Time {
+	if hc.TimeNow != nil {
+		return hc.TimeNow()
+	}
+	return time.Now()
+}
+
+// interval returns the interval between health checks.
+func (hc *HealthChecker) interval() time.Duration {
+	if hc.Interval > 0 {
+		return hc.Interval
+	}
+	return DefaultHealthCheckInterval
+}
+
+// Run checks the servers health every interval until the context is done.
+//
+// The first check happens immediately.
+func (hc *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(hc.interval())
+	defer ticker.Stop()
+	for {
+		hc.CheckOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckOnce probes all the configured servers in parallel, updates
+// their health state, and returns when all probes are complete.
+func (hc *HealthChecker) CheckOnce(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, server := range hc.Config.servers() {
+		wg.Add(1)
+		go func(server resolverConfigServer) {
+			defer wg.Done()
+			err := hc.probe(ctx, server)
+			if hc.Config.updateHealth(server.address, hc.timeNow(), err) {
+				hc.maybeLogHealthChange(ctx, server.address, err)
+			}
+		}(server)
+	}
+	wg.Wait()
+}
+
+// errProbeFailed indicates that the server did not respond
+// successfully to the health check probe.
+var errProbeFailed = errors.New("health check probe failed")
+
+// probe sends the probe query to the given server and returns
+// nil if the server responded with NOERROR or NXDOMAIN.
+func (hc *HealthChecker) probe(ctx context.Context, server resolverConfigServer) error {
+	if server.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, server.timeout)
+		defer cancel()
+	}
+
+	name, qtype := hc.ProbeName, hc.ProbeType
+	if name == "" {
+		name = "."
+	}
+	if qtype == 0 {
+		qtype = dns.TypeNS
+	}
+	query, err := NewQueryWithServerAddr(server.address, name, qtype, server.queryOptions...)
+	if err != nil {
+		return err
+	}
+
+	resp, err := hc.transport().Query(ctx, server.address, query)
+	if err != nil {
+		return err
+	}
+	if err := ValidateResponse(query, resp); err != nil {
+		return err
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return nil
+	default:
+		return errProbeFailed
+	}
+}
+
+// maybeLogHealthChange logs a server health change if the logger is set.
+func (hc *HealthChecker) maybeLogHealthChange(ctx context.Context, addr *ServerAddr, err error) {
+	if hc.Logger != nil {
+		var errString string
+		if err != nil {
+			errString = err.Error()
+		}
+		hc.Logger.InfoContext(
+			ctx,
+			"dnsServerHealth",
+			slog.String("err", errString),
+			slog.Bool("healthy", err == nil),
+			slog.String("serverAddr", addr.Address),
+			slog.String("serverProtocol", string(addr.Protocol)),
+			slog.Time("t", hc.timeNow()),
+		)
+	}
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecker_CheckOnce(t *testing.T) {
+	// newTransport creates a transport where the server at the
+	// given address fails and all other servers succeed.
+	newTransport := func(failing string) *MockResolverTransport {
+		return &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				if addr.Address == failing {
+					return nil, errors.New("mocked error")
+				}
+				resp := &dns.Msg{}
+				resp.SetReply(query)
+				return resp, nil
+			},
+		}
+	}
+
+	t.Run("marks failing servers as unhealthy", func(t *testing.T) {
+		config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+		logbuf := &bytes.Buffer{}
+		hc := NewHealthChecker(config)
+		hc.Logger = slog.New(slog.NewJSONHandler(logbuf, nil))
+		hc.Transport = newTransport("192.0.2.1:53")
+
+		hc.CheckOnce(context.Background())
+
+		health := config.ServerHealth()
+		assert.Len(t, health, 2)
+		assert.False(t, health[0].Healthy)
+		assert.Equal(t, 1, health[0].ConsecutiveFailures)
+		assert.EqualError(t, health[0].LastErr, "mocked error")
+		assert.False(t, health[0].LastCheck.IsZero())
+		assert.True(t, health[1].Healthy)
+		assert.NoError(t, health[1].LastErr)
+
+		// only the health transition should have been logged
+		assert.Equal(t, 1, strings.Count(logbuf.String(), "dnsServerHealth"))
+
+		servers := config.healthyServers(config.servers(), time.Now())
+		assert.Len(t, servers, 1)
+		assert.Equal(t, "192.0.2.2:53", servers[0].address.Address)
+	})
+
+	t.Run("servers recover after a successful probe", func(t *testing.T) {
+		config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+		hc := NewHealthChecker(config)
+		hc.Transport = newTransport("192.0.2.1:53")
+		hc.CheckOnce(context.Background())
+		hc.CheckOnce(context.Background())
+		assert.Equal(t, 2, config.ServerHealth()[0].ConsecutiveFailures)
+
+		hc.Transport = newTransport("")
+		hc.CheckOnce(context.Background())
+		health := config.ServerHealth()
+		assert.True(t, health[0].Healthy)
+		assert.Equal(t, 0, health[0].ConsecutiveFailures)
+	})
+
+	t.Run("all servers unhealthy", func(t *testing.T) {
+		config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+		hc := NewHealthChecker(config)
+		hc.Transport = &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				resp := &dns.Msg{}
+				resp.SetRcode(query, dns.RcodeServerFailure)
+				return resp, nil
+			},
+		}
+		hc.CheckOnce(context.Background())
+		for _, state := range config.ServerHealth() {
+			assert.False(t, state.Healthy)
+			assert.ErrorIs(t, state.LastErr, errProbeFailed)
+		}
+		assert.Len(t, config.healthyServers(config.servers(), time.Now()), 2)
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+		hc := NewHealthChecker(config)
+		hc.Transport = &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				return &dns.Msg{}, nil
+			},
+		}
+		hc.CheckOnce(context.Background())
+		assert.ErrorIs(t, config.ServerHealth()[0].LastErr, ErrInvalidResponse)
+	})
+
+	t.Run("custom probe name and type", func(t *testing.T) {
+		config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+		hc := NewHealthChecker(config)
+		hc.ProbeName = "example.com"
+		hc.ProbeType = dns.TypeA
+		hc.Transport = &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				assert.Equal(t, "example.com.", query.Question[0].Name)
+				assert.Equal(t, dns.TypeA, query.Question[0].Qtype)
+				resp := &dns.Msg{}
+				resp.SetRcode(query, dns.RcodeNameError)
+				return resp, nil
+			},
+		}
+		hc.CheckOnce(context.Background())
+		for _, state := range config.ServerHealth() {
+			assert.True(t, state.Healthy)
+		}
+	})
+}
+
+func TestHealthChecker_Run(t *testing.T) {
+	config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+	hc := NewHealthChecker(config)
+	hc.Interval = time.Millisecond
+	count := &atomic.Int64{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hc.Transport = &MockResolverTransport{
+		MockQuery: func(_ context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			if count.Add(1) >= 6 {
+				cancel()
+			}
+			return nil, errors.New("mocked error")
+		},
+	}
+	hc.Run(ctx)
+	assert.GreaterOrEqual(t, count.Load(), int64(6))
+}
+
+func TestHealthChecker_defaults(t *testing.T) {
+	hc := &HealthChecker{}
+	assert.Equal(t, DefaultTransport, hc.transport())
+	assert.Equal(t, DefaultHealthCheckInterval, hc.interval())
+	assert.False(t, hc.timeNow().IsZero())
+
+	fixed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hc.TimeNow = func() time.Time { return fixed }
+	assert.Equal(t, fixed, hc.timeNow())
+}
+
+func TestResolver_lookupSkipsUnhealthyServers(t *testing.T) {
+	config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53")
+	config.updateHealth(NewServerAddr(ProtocolUDP, "192.0.2.1:53"), time.Now(), errors.New("mocked error"))
+
+	var queried []string
+	reso := &Resolver{
+		Config: config,
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				queried = append(queried, addr.Address)
+				return nil, errors.New("mocked error")
+			},
+		},
+	}
+	_, _ = reso.LookupA(context.Background(), "example.com")
+	assert.Equal(t, []string{"192.0.2.2:53", "192.0.2.2:53"}, queried)
+}
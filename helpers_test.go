@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// This file contains the helpers shared by the internal tests, which cannot
+// use the dnscoretest package because dnscoretest imports this package.
+
+// startStreamServer starts a TCP server invoking serve for each connection
+// and returns its address along with the number of accepted connections.
+func startStreamServer(t *testing.T, serve func(conn net.Conn, br *bufio.Reader)) (*ServerAddr, *atomic.Int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	conns := &atomic.Int64{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return NewServerAddr(ProtocolTCP, listener.Addr().String()), conns
+}
+
+// answerStreamQueries returns a function for [startStreamServer] that reads
+// batches of the given number of queries and answers each batch in reverse
+// order using the responses returned by respond. When respond returns nil,
+// the function closes the connection.
+func answerStreamQueries(batch int, respond func(query *dns.Msg) []*dns.Msg) func(conn net.Conn, br *bufio.Reader) {
+	return func(conn net.Conn, br *bufio.Reader) {
+		for {
+			var queries []*dns.Msg
+			for len(queries) < batch {
+				rawQuery, err := ReadMsgFrame(br)
+				if err != nil {
+					return
+				}
+				query := &dns.Msg{}
+				if query.Unpack(rawQuery) != nil {
+					return
+				}
+				queries = append(queries, query)
+			}
+			for _, query := range slices.Backward(queries) {
+				resps := respond(query)
+				if resps == nil {
+					return
+				}
+				for _, resp := range resps {
+					rawResp, _ := resp.Pack()
+					frame, _ := newRawMsgFrame(&ServerAddr{}, rawResp)
+					if _, err := conn.Write(frame); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// answerA returns a reply to the query with an A record.
+func answerA(query *dns.Msg) []*dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(10, 0, 0, byte(len(query.Question[0].Name))),
+	})
+	return []*dns.Msg{resp}
+}
+
+// answerKeepalive returns a function like [answerA] that includes the
+// edns-tcp-keepalive option with the given timeout (in units of 100 ms).
+func answerKeepalive(timeout uint16) func(query *dns.Msg) []*dns.Msg {
+	return func(query *dns.Msg) []*dns.Msg {
+		resps := answerA(query)
+		resps[0].SetEdns0(4096, false)
+		resps[0].IsEdns0().Option = append(resps[0].IsEdns0().Option,
+			&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: timeout})
+		return resps
+	}
+}
+
+// newRawResponse returns a raw response for the given raw query.
+func newRawResponse(rawQuery []byte, rcode int) []byte {
+	query := &dns.Msg{}
+	if err := query.Unpack(rawQuery); err != nil {
+		panic(err)
+	}
+	resp := &dns.Msg{}
+	resp.SetRcode(query, rcode)
+	rawResp, err := resp.Pack()
+	if err != nil {
+		panic(err)
+	}
+	return rawResp
+}
+
+// newClientSubnetResponse returns a response to the given query
+// echoing its ECS option, if any, using the given scope length.
+func newClientSubnetResponse(query *dns.Msg, scope uint8) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	if qopt := query.IsEdns0(); qopt != nil {
+		resp.SetEdns0(qopt.UDPSize(), false)
+		for _, option := range qopt.Option {
+			if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+				ecs := *ecs
+				ecs.SourceScope = scope
+				resp.IsEdns0().Option = append(resp.IsEdns0().Option, &ecs)
+			}
+		}
+	}
+	return resp
+}
+
+// parseRRs parses the given RRs in presentation format.
+func parseRRs(t *testing.T, records ...string) []dns.RR {
+	var rrs []dns.RR
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		assert.NoError(t, err)
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+// answerFromRecords returns a transport answering using the given RRs,
+// returning NXDOMAIN for the names without RRs.
+func answerFromRecords(t *testing.T, records ...string) *MockResolverTransport {
+	rrs := parseRRs(t, records...)
+	return &MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			q0 := query.Question[0]
+			resp := new(dns.Msg)
+			resp.SetRcode(query, dns.RcodeNameError)
+			resp.RecursionAvailable = true
+			for _, rr := range rrs {
+				if equalASCIIName(rr.Header().Name, q0.Name) {
+					resp.Rcode = dns.RcodeSuccess
+					if rr.Header().Rrtype == q0.Qtype {
+						resp.Answer = append(resp.Answer, rr)
+					}
+				}
+			}
+			return resp, nil
+		},
+	}
+}
+
+// newUDPResolverConfig returns a [*ResolverConfig] using UDP
+// servers with the given addresses.
+func newUDPResolverConfig(addresses ...string) *ResolverConfig {
+	config := NewConfig()
+	for _, address := range addresses {
+		config.AddServer(NewServerAddr(ProtocolUDP, address))
+	}
+	return config
+}
+
+// generateSIG0Key generates an ED25519 SIG(0) key with the given name.
+func generateSIG0Key(t *testing.T, name string) *SIG0Key {
+	key := &dns.KEY{DNSKEY: dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeKEY, Class: dns.ClassINET},
+		Flags:     512,
+		Protocol:  3,
+		Algorithm: dns.ED25519,
+	}}
+	privkey, err := key.Generate(256)
+	assert.NoError(t, err)
+	return &SIG0Key{KEY: key, Signer: privkey.(crypto.Signer)}
+}
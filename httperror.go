@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These errors classify the failures of HTTP-based protocols. They are
+// wrapped by [*HTTPError], which also wraps [ErrServerMisbehaving].
+var (
+	// ErrHTTPStatus indicates an unexpected HTTP status code.
+	ErrHTTPStatus = errors.New("unexpected HTTP status code")
+
+	// ErrHTTPUnsupportedMediaType indicates that the server responded
+	// with 415, i.e., it does not support the query format.
+	ErrHTTPUnsupportedMediaType = errors.New("HTTP unsupported media type")
+
+	// ErrHTTPTooManyRequests indicates that the server responded with 429.
+	ErrHTTPTooManyRequests = errors.New("HTTP too many requests")
+
+	// ErrHTTPServerError indicates that the server responded with 5xx.
+	ErrHTTPServerError = errors.New("HTTP server error")
+
+	// ErrHTTPContentType indicates an unexpected content type.
+	ErrHTTPContentType = errors.New("unexpected HTTP content type")
+
+	// ErrHTTPResponseTooLarge indicates that the response body
+	// is larger than the maximum size we are willing to read.
+	ErrHTTPResponseTooLarge = errors.New("HTTP response body too large")
+)
+
+// HTTPError is the error returned when an HTTP-based query fails
+// because of the HTTP response. Use [errors.Is] with the ErrHTTP
+// errors to classify the failure and [errors.As] to access the
+// fields, including the delay requested using Retry-After.
+//
+// For backward compatibility, HTTPError also matches [ErrServerMisbehaving].
+type HTTPError struct {
+	// StatusCode is the HTTP status code.
+	StatusCode int
+
+	// ContentType is the value of the content-type header.
+	ContentType string
+
+	// RetryAfter is the delay requested by the server using the
+	// Retry-After header, or zero if the header was missing.
+	RetryAfter time.Duration
+
+	// Err is one of the ErrHTTP errors.
+	Err error
+}
+
+var _ error = &HTTPError{}
+
+// Error implements error.
+func (e *HTTPError) Error() string {
+	var builder strings.Builder
+	builder.WriteString(e.Err.Error())
+	switch {
+	case errors.Is(e.Err, ErrHTTPContentType):
+		fmt.Fprintf(&builder, ": %q", e.ContentType)
+	case !errors.Is(e.Err, ErrHTTPResponseTooLarge):
+		fmt.Fprintf(&builder, ": %d", e.StatusCode)
+	}
+	if e.RetryAfter > 0 {
+		fmt.Fprintf(&builder, " (retry after %s)", e.RetryAfter)
+	}
+	return builder.String()
+}
+
+// Unwrap allows using [errors.Is] with the wrapped error and [ErrServerMisbehaving].
+func (e *HTTPError) Unwrap() []error {
+	return []error{e.Err, ErrServerMisbehaving}
+}
+
+// Temporary returns whether retrying the query later, possibly after
+// RetryAfter, could succeed, which is the case for 429 and 5xx.
+func (e *HTTPError) Temporary() bool {
+	return errors.Is(e.Err, ErrHTTPTooManyRequests) || errors.Is(e.Err, ErrHTTPServerError)
+}
+
+// httpStatusError maps a non-200 status code to the corresponding ErrHTTP error.
+func httpStatusError(statusCode int) error {
+	switch {
+	case statusCode == http.StatusUnsupportedMediaType:
+		return ErrHTTPUnsupportedMediaType
+	case statusCode == http.StatusTooManyRequests:
+		return ErrHTTPTooManyRequests
+	case statusCode >= 500 && statusCode <= 599:
+		return ErrHTTPServerError
+	default:
+		return ErrHTTPStatus
+	}
+}
+
+// recordRetryAfter records that the given server asked us not
+// to query it again until the given time.
+func (c *ResolverConfig) recordRetryAfter(addr *ServerAddr, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.retryAfter == nil {
+		c.retryAfter = make(map[serverKey]time.Time)
+	}
+	c.retryAfter[addr.key()] = until
+}
+
+// parseRetryAfter parses the value of the Retry-After header, which is
+// either a number of seconds or an HTTP date, and returns the delay with
+// respect to now or zero if the value is missing, invalid, or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// newHTTPError creates an [*HTTPError] for the given response and error.
+func (t *Transport) newHTTPError(resp *http.Response, err error) *HTTPError {
+	return &HTTPError{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("content-type"),
+		RetryAfter:  parseRetryAfter(resp.Header.Get("retry-after"), t.timeNow()),
+		Err:         err,
+	}
+}
+
+// checkHTTPResponse returns an [*HTTPError] if the status code is not 200
+// or if the content type is not accepted by the given function.
+func (t *Transport) checkHTTPResponse(resp *http.Response, acceptContentType func(string) bool) error {
+	if resp.StatusCode != http.StatusOK {
+		return t.newHTTPError(resp, httpStatusError(resp.StatusCode))
+	}
+	if !acceptContentType(resp.Header.Get("content-type")) {
+		return t.newHTTPError(resp, ErrHTTPContentType)
+	}
+	return nil
+}
+
+// readHTTPResponseBody reads the response body, failing with an [*HTTPError]
+// wrapping [ErrHTTPResponseTooLarge] when the body exceeds the given limit.
+func (t *Transport) readHTTPResponseBody(ctx context.Context, resp *http.Response, limit int64) ([]byte, error) {
+	if resp.ContentLength > limit {
+		return nil, t.newHTTPError(resp, ErrHTTPResponseTooLarge)
+	}
+	reader := io.LimitReader(resp.Body, limit+1)
+	body, err := t.readAllContext(ctx, reader, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, t.newHTTPError(resp, ErrHTTPResponseTooLarge)
+	}
+	return body, nil
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		expect time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"-1", 0},
+		{"Mon, 01 Jan 2024 00:00:30 GMT", 30 * time.Second},
+		{"Sun, 31 Dec 2023 23:59:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.expect, parseRetryAfter(tt.value, now))
+		})
+	}
+}
+
+func Test_httpStatusError(t *testing.T) {
+	assert.Equal(t, ErrHTTPUnsupportedMediaType, httpStatusError(415))
+	assert.Equal(t, ErrHTTPTooManyRequests, httpStatusError(429))
+	assert.Equal(t, ErrHTTPServerError, httpStatusError(500))
+	assert.Equal(t, ErrHTTPServerError, httpStatusError(503))
+	assert.Equal(t, ErrHTTPStatus, httpStatusError(404))
+}
+
+func TestHTTPError(t *testing.T) {
+	err := error(&HTTPError{StatusCode: 429, RetryAfter: time.Minute, Err: ErrHTTPTooManyRequests})
+	assert.Equal(t, "HTTP too many requests: 429 (retry after 1m0s)", err.Error())
+	assert.ErrorIs(t, err, ErrHTTPTooManyRequests)
+	assert.ErrorIs(t, err, ErrServerMisbehaving)
+	assert.True(t, err.(*HTTPError).Temporary())
+
+	err = &HTTPError{StatusCode: 415, Err: ErrHTTPUnsupportedMediaType}
+	assert.False(t, err.(*HTTPError).Temporary())
+
+	err = &HTTPError{StatusCode: 200, Err: ErrHTTPResponseTooLarge}
+	assert.Equal(t, "HTTP response body too large", err.Error())
+}
+
+func TestTransport_queryHTTPSErrors(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		expectErr  error
+		retryAfter time.Duration
+	}{{
+		name: "415",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		},
+		expectErr: ErrHTTPUnsupportedMediaType,
+	}, {
+		name: "429 with Retry-After",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		expectErr:  ErrHTTPTooManyRequests,
+		retryAfter: 30 * time.Second,
+	}, {
+		name: "503",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+		expectErr: ErrHTTPServerError,
+	}, {
+		name: "wrong content type",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "text/html")
+		},
+		expectErr: ErrHTTPContentType,
+	}, {
+		name: "too large body",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/dns-message")
+			w.Write([]byte(strings.Repeat("x", 1<<17)))
+		},
+		expectErr: ErrHTTPResponseTooLarge,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			txp := &Transport{Insecure: true}
+			_, err := txp.Query(context.Background(), NewServerAddr(ProtocolHTTP, srv.URL), query)
+			assert.ErrorIs(t, err, tt.expectErr)
+			assert.ErrorIs(t, err, ErrServerMisbehaving)
+			var httpErr *HTTPError
+			assert.True(t, errors.As(err, &httpErr))
+			assert.Equal(t, tt.retryAfter, httpErr.RetryAfter)
+		})
+	}
+}
+
+func TestResolver_lookupHonorsRetryAfter(t *testing.T) {
+	config := NewConfig()
+	config.SetAttempts(4)
+	config.AddServer(NewServerAddr(ProtocolDoH, "https://a.example/dns-query"))
+	config.AddServer(NewServerAddr(ProtocolDoH, "https://b.example/dns-query"))
+
+	var queried []string
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reso := &Resolver{
+		Config:  config,
+		TimeNow: func() time.Time { return now },
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				queried = append(queried, addr.Address)
+				if addr.Address == "https://a.example/dns-query" {
+					return nil, &HTTPError{StatusCode: 429, RetryAfter: time.Hour, Err: ErrHTTPTooManyRequests}
+				}
+				return nil, &HTTPError{StatusCode: 502, Err: ErrHTTPServerError}
+			},
+		},
+	}
+
+	// the first server is used once, the second one for the remaining attempts
+	_, err := reso.LookupA(context.Background(), "example.com")
+	assert.ErrorIs(t, err, ErrHTTPServerError)
+	assert.Equal(t, []string{
+		"https://a.example/dns-query",
+		"https://b.example/dns-query",
+		"https://b.example/dns-query",
+		"https://b.example/dns-query",
+	}, queried)
+
+	// subsequent lookups skip the first server until the deadline
+	queried = nil
+	_, _ = reso.LookupA(context.Background(), "example.com")
+	assert.NotContains(t, queried, "https://a.example/dns-query")
+
+	// and use it again once the deadline has expired
+	now = now.Add(time.Hour + time.Second)
+	queried = nil
+	_, _ = reso.LookupA(context.Background(), "example.com")
+	assert.Contains(t, queried, "https://a.example/dns-query")
+}
+
+func Fuzz_parseRetryAfter(f *testing.F) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.Add("120")
+	f.Add("Mon, 01 Jan 2024 00:02:00 GMT")
+	f.Add("-1")
+	f.Fuzz(func(t *testing.T, value string) {
+		if parseRetryAfter(value, now) < 0 {
+			t.Fatal("negative Retry-After")
+		}
+	})
+}
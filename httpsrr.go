@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// httpsMaxAliasChain is the maximum number of AliasMode records we follow.
+const httpsMaxAliasChain = 8
+
+// ErrHTTPSAliasLoop indicates that the AliasMode HTTPS records form a
+// loop or a chain longer than we are willing to follow.
+var ErrHTTPSAliasLoop = errors.New("HTTPS alias chain too long")
+
+// HTTPSEndpoint is an endpoint of an HTTPS service returned by
+// [*Resolver.LookupHTTPSEndpoints], which is ready to dial.
+type HTTPSEndpoint struct {
+	// Priority is the priority of the ServiceMode HTTPS record, where
+	// lower values are preferred, or zero when the name has no HTTPS
+	// records and we return the endpoint using the A and AAAA records.
+	Priority uint16
+
+	// Target is the name whose addresses we use. Note that the TLS
+	// server name remains the name of the service.
+	Target string
+
+	// Addrs contains the addresses to dial, which include the port.
+	Addrs []netip.AddrPort
+
+	// ALPN contains the ALPN protocol identifiers to offer, including
+	// "http/1.1" unless the record contains the no-default-alpn key.
+	// It is empty when the name has no HTTPS records.
+	ALPN []string
+
+	// ECHConfig contains the optional ECHConfigList to use with
+	// Encrypted Client Hello, if the record contains it.
+	ECHConfig []byte
+}
+
+// LookupHTTPSEndpoints implements the client logic of RFC 9460 for the
+// HTTPS service at the given host and port. We query for the HTTPS records,
+// following the AliasMode records, and return the endpoints described by
+// the ServiceMode records in priority order, resolving the addresses of
+// their targets, or using the address hints when such a resolution fails.
+// We skip the records requiring keys we do not understand. When the name
+// has no HTTPS records, we return a single endpoint using the A and AAAA
+// records of the host, as an HTTPS client without HTTPS records would do.
+func (r *Resolver) LookupHTTPSEndpoints(ctx context.Context, host string, port uint16) ([]*HTTPSEndpoint, error) {
+	// 1. obtain the ServiceMode records, following the aliases
+	name := dns.Fqdn(host)
+	if port != 443 {
+		name = "_" + strconv.Itoa(int(port)) + "._https." + name // RFC 9460 Sect. 9.1
+	}
+	target, records, err := r.lookupHTTPSServices(ctx, name)
+	if errors.Is(err, ErrNoData) || errors.Is(err, ErrNoName) {
+		return r.httpsFallbackEndpoint(ctx, host, port)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. build the endpoints in priority order
+	slices.SortStableFunc(records, func(a, b *dns.HTTPS) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+	var endpoints []*HTTPSEndpoint
+	for _, record := range records {
+		endpoint, ok := r.newHTTPSEndpoint(ctx, target, port, record)
+		if ok {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) <= 0 {
+		return r.httpsFallbackEndpoint(ctx, host, port)
+	}
+	return endpoints, nil
+}
+
+// lookupHTTPSServices returns the ServiceMode records and their owner name,
+// which we obtain by following the AliasMode records starting from name.
+func (r *Resolver) lookupHTTPSServices(ctx context.Context, name string) (string, []*dns.HTTPS, error) {
+	for range httpsMaxAliasChain {
+		rrs, err := r.lookup(ctx, name, dns.TypeHTTPS)
+		if err != nil {
+			return "", nil, err
+		}
+		var (
+			alias    string
+			services []*dns.HTTPS
+		)
+		for _, rr := range rrs {
+			record, ok := rr.(*dns.HTTPS)
+			switch {
+			case !ok:
+				continue
+			case record.Priority == 0:
+				alias = record.Target
+			default:
+				services = append(services, record)
+			}
+		}
+		switch {
+		case len(services) > 0:
+			return name, services, nil // RFC 9460 Sect. 2.4.2: ignore the aliases
+		case alias == "" || alias == ".":
+			return "", nil, ErrNoData
+		}
+		name = alias
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrHTTPSAliasLoop, name)
+}
+
+// newHTTPSEndpoint returns the endpoint described by the given record, whose
+// owner is the given name, or false if we cannot use the record.
+func (r *Resolver) newHTTPSEndpoint(ctx context.Context,
+	name string, port uint16, record *dns.HTTPS) (*HTTPSEndpoint, bool) {
+	// 1. collect the parameters, skipping records with unknown mandatory keys
+	endpoint := &HTTPSEndpoint{Priority: record.Priority, Target: record.Target}
+	if endpoint.Target == "." {
+		endpoint.Target = name // RFC 9460 Sect. 2.5.2
+	}
+	defaultALPN := true
+	var hints []net.IP
+	for _, kv := range record.Value {
+		switch kv := kv.(type) {
+		case *dns.SVCBMandatory:
+			for _, key := range kv.Code {
+				if !slices.Contains(httpsKnownKeys, key) {
+					return nil, false
+				}
+			}
+		case *dns.SVCBAlpn:
+			endpoint.ALPN = append(endpoint.ALPN, kv.Alpn...)
+		case *dns.SVCBNoDefaultAlpn:
+			defaultALPN = false
+		case *dns.SVCBPort:
+			port = kv.Port
+		case *dns.SVCBIPv4Hint:
+			hints = append(hints, kv.Hint...)
+		case *dns.SVCBIPv6Hint:
+			hints = append(hints, kv.Hint...)
+		case *dns.SVCBECHConfig:
+			endpoint.ECHConfig = kv.ECH
+		}
+	}
+	if defaultALPN && !slices.Contains(endpoint.ALPN, "http/1.1") {
+		endpoint.ALPN = append(endpoint.ALPN, "http/1.1")
+	}
+
+	// 2. resolve the addresses, falling back to the hints
+	addrs, err := r.LookupHost(ctx, endpoint.Target)
+	if err != nil {
+		for _, hint := range hints {
+			addrs = append(addrs, hint.String())
+		}
+	}
+	endpoint.Addrs = httpsAddrPorts(addrs, port)
+	return endpoint, len(endpoint.Addrs) > 0
+}
+
+// httpsKnownKeys contains the SvcParamKeys we understand.
+var httpsKnownKeys = []dns.SVCBKey{
+	dns.SVCB_MANDATORY, dns.SVCB_ALPN, dns.SVCB_NO_DEFAULT_ALPN, dns.SVCB_PORT,
+	dns.SVCB_IPV4HINT, dns.SVCB_ECHCONFIG, dns.SVCB_IPV6HINT,
+}
+
+// httpsFallbackEndpoint returns the endpoint using the A and AAAA records.
+func (r *Resolver) httpsFallbackEndpoint(ctx context.Context, host string, port uint16) ([]*HTTPSEndpoint, error) {
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := &HTTPSEndpoint{Target: dns.Fqdn(host), Addrs: httpsAddrPorts(addrs, port)}
+	return []*HTTPSEndpoint{endpoint}, nil
+}
+
+// httpsAddrPorts returns the valid addresses joined with the given port.
+func httpsAddrPorts(addrs []string, port uint16) []netip.AddrPort {
+	var addrports []netip.AddrPort
+	for _, addr := range addrs {
+		if ipAddr, err := netip.ParseAddr(addr); err == nil {
+			addrports = append(addrports, netip.AddrPortFrom(ipAddr.Unmap(), port))
+		}
+	}
+	return addrports
+}
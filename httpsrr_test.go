@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"encoding/base64"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver_LookupHTTPSEndpoints(t *testing.T) {
+	const echConfig = "AEX+DQBBpQAgACAZQxGx54Ts06bFDFyQlbrbDP1uQSWi1mSuO3b9KbUjLwAEAAEAAQASY2xvdWRmbGFyZS1lY2guY29tAAA="
+	ech, err := base64.StdEncoding.DecodeString(echConfig)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		records  []string
+		host     string
+		port     uint16
+		expected []*HTTPSEndpoint
+		err      error
+	}{{
+		name: "service records in priority order",
+		records: []string{
+			`example.com. 300 IN HTTPS 2 . alpn="h2" ipv4hint=192.0.2.9`,
+			`example.com. 300 IN HTTPS 1 svc.example.net. alpn="h3,h2" port=8443 ech="` + echConfig + `"`,
+			`example.com. 300 IN A 192.0.2.1`,
+			`svc.example.net. 300 IN A 192.0.2.2`,
+			`svc.example.net. 300 IN AAAA 2001:db8::2`,
+		},
+		host: "example.com",
+		port: 443,
+		expected: []*HTTPSEndpoint{{
+			Priority: 1,
+			Target:   "svc.example.net.",
+			Addrs: []netip.AddrPort{
+				netip.MustParseAddrPort("192.0.2.2:8443"),
+				netip.MustParseAddrPort("[2001:db8::2]:8443"),
+			},
+			ALPN:      []string{"h3", "h2", "http/1.1"},
+			ECHConfig: ech,
+		}, {
+			Priority: 2,
+			Target:   "example.com.",
+			Addrs:    []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:443")},
+			ALPN:     []string{"h2", "http/1.1"},
+		}},
+	}, {
+		name: "aliases, hints, and no-default-alpn",
+		records: []string{
+			`_8443._https.example.com. 300 IN HTTPS 0 pool.example.net.`,
+			`pool.example.net. 300 IN HTTPS 0 cdn.example.org.`,
+			`cdn.example.org. 300 IN HTTPS 1 . alpn="h2" no-default-alpn ipv6hint=2001:db8::3`,
+		},
+		host: "example.com",
+		port: 8443,
+		expected: []*HTTPSEndpoint{{
+			Priority: 1,
+			Target:   "cdn.example.org.",
+			Addrs:    []netip.AddrPort{netip.MustParseAddrPort("[2001:db8::3]:8443")},
+			ALPN:     []string{"h2"},
+		}},
+	}, {
+		name: "records with unknown mandatory keys",
+		records: []string{
+			`example.com. 300 IN HTTPS 1 . mandatory=key667 key667="x" ipv4hint=192.0.2.7`,
+			`example.com. 300 IN HTTPS 2 . mandatory=alpn alpn="h2" ipv4hint=192.0.2.8`,
+		},
+		host: "example.com",
+		port: 443,
+		expected: []*HTTPSEndpoint{{
+			Priority: 2,
+			Target:   "example.com.",
+			Addrs:    []netip.AddrPort{netip.MustParseAddrPort("192.0.2.8:443")},
+			ALPN:     []string{"h2", "http/1.1"},
+		}},
+	}, {
+		name: "without HTTPS records",
+		records: []string{
+			`example.com. 300 IN A 192.0.2.1`,
+		},
+		host: "example.com",
+		port: 8080,
+		expected: []*HTTPSEndpoint{{
+			Target: "example.com.",
+			Addrs:  []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:8080")},
+		}},
+	}, {
+		name: "alias loop",
+		records: []string{
+			`example.com. 300 IN HTTPS 0 loop.example.com.`,
+			`loop.example.com. 300 IN HTTPS 0 example.com.`,
+		},
+		host: "example.com",
+		port: 443,
+		err:  ErrHTTPSAliasLoop,
+	}, {
+		name: "nonexistent host",
+		host: "example.com",
+		port: 443,
+		err:  ErrNoName,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reso := &Resolver{Transport: answerFromRecords(t, tt.records...)}
+			endpoints, err := reso.LookupHTTPSEndpoints(context.Background(), tt.host, tt.port)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.expected, endpoints)
+		})
+	}
+}
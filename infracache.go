@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"cmp"
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// iterativeInitialRTT is the RTT we assume for name servers we have not
+// queried yet, which is the same value used by unbound.
+const iterativeInitialRTT = 376 * time.Millisecond
+
+// iterativeMaxFailures is the number of consecutive failures after
+// which we hold down a name server.
+const iterativeMaxFailures = 3
+
+// NameserverInfo contains what the [*IterativeResolver] knows
+// about an authoritative name server.
+type NameserverInfo struct {
+	// Addr is the name server address.
+	Addr netip.Addr
+
+	// SmoothedRTT is the smoothed round-trip time.
+	SmoothedRTT time.Duration
+
+	// ConsecutiveFailures is the number of consecutive queries
+	// for which the name server did not respond.
+	ConsecutiveFailures int
+
+	// HeldDownUntil is the time until which we will not query the
+	// name server because of repeated failures.
+	HeldDownUntil time.Time
+
+	// LameZones maps the zones for which the name server is
+	// lame to the time until which we will not query it.
+	LameZones map[string]time.Time
+}
+
+// iterativeNameserver contains the state of a name server.
+type iterativeNameserver struct {
+	// info is the name server info.
+	info NameserverInfo
+
+	// samples is the number of RTT samples.
+	samples int
+}
+
+// holdDown returns the hold-down period or the default.
+func (r *IterativeResolver) holdDown() time.Duration {
+	if r.HoldDown > 0 {
+		return r.HoldDown
+	}
+	return DefaultIterativeHoldDown
+}
+
+// nameserverLocked returns the state of the given name server,
+// creating it if needed. This method MUST be called with mu held.
+func (r *IterativeResolver) nameserverLocked(addr netip.Addr) *iterativeNameserver {
+	if r.nameservers == nil {
+		r.nameservers = make(map[netip.Addr]*iterativeNameserver)
+	}
+	ns, ok := r.nameservers[addr]
+	if !ok {
+		ns = &iterativeNameserver{info: NameserverInfo{Addr: addr, SmoothedRTT: iterativeInitialRTT}}
+		r.nameservers[addr] = ns
+	}
+	return ns
+}
+
+// recordNameserverRTT records a successful exchange with the given name server.
+func (r *IterativeResolver) recordNameserverRTT(addr netip.Addr, rtt time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns := r.nameserverLocked(addr)
+	if ns.samples <= 0 {
+		ns.info.SmoothedRTT = rtt
+	} else {
+		ns.info.SmoothedRTT = (7*ns.info.SmoothedRTT + rtt) / 8
+	}
+	ns.samples++
+	ns.info.ConsecutiveFailures = 0
+}
+
+// recordNameserverFailure records that the given name server did not
+// respond and holds it down after too many consecutive failures.
+func (r *IterativeResolver) recordNameserverFailure(addr netip.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns := r.nameserverLocked(addr)
+	ns.info.ConsecutiveFailures++
+	if ns.info.ConsecutiveFailures >= iterativeMaxFailures {
+		ns.info.HeldDownUntil = r.timeNow().Add(r.holdDown())
+		ns.info.ConsecutiveFailures = 0
+	}
+}
+
+// recordLameNameserver records that the given name server is lame for the given zone.
+func (r *IterativeResolver) recordLameNameserver(addr netip.Addr, zone string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ns := r.nameserverLocked(addr)
+	if ns.info.LameZones == nil {
+		ns.info.LameZones = make(map[string]time.Time)
+	}
+	ns.info.LameZones[zone] = r.timeNow().Add(r.holdDown())
+}
+
+// usableNameservers returns the name servers we can query for the given
+// zone sorted by increasing smoothed RTT, excluding the name servers that
+// are lame for the zone or are held down after repeated failures.
+func (r *IterativeResolver) usableNameservers(zone string, addrs []netip.Addr) []netip.Addr {
+	now := r.timeNow()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	type candidate struct {
+		addr netip.Addr
+		srtt time.Duration
+	}
+	var candidates []candidate
+	for _, addr := range addrs {
+		ns, ok := r.nameservers[addr]
+		if !ok {
+			candidates = append(candidates, candidate{addr, iterativeInitialRTT})
+			continue
+		}
+		if now.Before(ns.info.HeldDownUntil) || now.Before(ns.info.LameZones[zone]) {
+			continue
+		}
+		candidates = append(candidates, candidate{addr, ns.info.SmoothedRTT})
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(a.srtt, b.srtt)
+	})
+	out := make([]netip.Addr, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, c.addr)
+	}
+	return out
+}
+
+// iterativeIsLame returns whether the response to a query for the given
+// name shows that the server is lame for the given zone, i.e., the server
+// refuses the query or is not authoritative and does not refer us to a
+// child zone (e.g., it returns an upward referral).
+func iterativeIsLame(zone, name string, resp *dns.Msg) bool {
+	if resp.Rcode == dns.RcodeRefused {
+		return true
+	}
+	if resp.Rcode != dns.RcodeSuccess || resp.Authoritative || len(resp.Answer) > 0 {
+		return false
+	}
+	_, referral := iterativeReferral(zone, name, resp)
+	return !referral
+}
+
+// Nameservers returns a snapshot of what we know about the name
+// servers we have queried so far, sorted by address.
+func (r *IterativeResolver) Nameservers() []NameserverInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]NameserverInfo, 0, len(r.nameservers))
+	for _, ns := range r.nameservers {
+		info := ns.info
+		info.LameZones = maps.Clone(ns.info.LameZones)
+		out = append(out, info)
+	}
+	slices.SortFunc(out, func(a, b NameserverInfo) int {
+		return a.Addr.Compare(b.Addr)
+	})
+	return out
+}
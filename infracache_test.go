@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_iterativeIsLame(t *testing.T) {
+	h := &iterativeTestHierarchy{}
+	newResp := func(rcode int, aa bool, ns ...string) *dns.Msg {
+		resp := &dns.Msg{}
+		resp.Rcode, resp.Authoritative = rcode, aa
+		for _, s := range ns {
+			resp.Ns = append(resp.Ns, h.rr(s))
+		}
+		return resp
+	}
+
+	assert.True(t, iterativeIsLame("example.com.", "www.example.com.", newResp(dns.RcodeRefused, false)))
+	assert.True(t, iterativeIsLame("example.com.", "www.example.com.", newResp(dns.RcodeSuccess, false)))
+	assert.True(t, iterativeIsLame("example.com.", "www.example.com.",
+		newResp(dns.RcodeSuccess, false, "com. 3600 IN NS a.gtld.com.")))
+	assert.False(t, iterativeIsLame("example.com.", "www.example.com.", newResp(dns.RcodeSuccess, true)))
+	assert.False(t, iterativeIsLame("example.com.", "www.example.com.", newResp(dns.RcodeNameError, false)))
+	assert.False(t, iterativeIsLame("com.", "www.example.com.",
+		newResp(dns.RcodeSuccess, false, "example.com. 3600 IN NS ns.example.com.")))
+}
+
+func TestIterativeResolver_usableNameservers(t *testing.T) {
+	now := time.Now()
+	reso := &IterativeResolver{TimeNow: func() time.Time { return now }}
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("192.0.2.3"),
+		netip.MustParseAddr("192.0.2.4"),
+	}
+	reso.recordNameserverRTT(addrs[0], 500*time.Millisecond)
+	reso.recordNameserverRTT(addrs[1], 10*time.Millisecond)
+	reso.recordLameNameserver(addrs[2], "example.com.")
+
+	// the unknown server uses the initial RTT and sorts between the others
+	assert.Equal(t, []netip.Addr{addrs[1], addrs[3], addrs[0]}, reso.usableNameservers("example.com.", addrs))
+
+	// the server is only lame for the given zone
+	assert.Equal(t, []netip.Addr{addrs[1], addrs[2], addrs[3], addrs[0]}, reso.usableNameservers("example.org.", addrs))
+
+	// the server is held down after repeated failures
+	for idx := 0; idx < iterativeMaxFailures; idx++ {
+		reso.recordNameserverFailure(addrs[1])
+	}
+	assert.Equal(t, []netip.Addr{addrs[3], addrs[0]}, reso.usableNameservers("example.com.", addrs))
+
+	// the hold down expires
+	now = now.Add(DefaultIterativeHoldDown)
+	assert.Equal(t, addrs[1], reso.usableNameservers("example.com.", addrs)[0])
+}
+
+func TestIterativeResolver_lameDelegations(t *testing.T) {
+	t.Run("skips lame name servers during the hold down", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		now := time.Now()
+		reso := h.resolver()
+		reso.TimeNow = func() time.Time { return now }
+
+		_, err := reso.Lookup(context.Background(), "www.mixed.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"192.0.2.4:53", "192.0.2.3:53"}, h.queried[len(h.queried)-2:])
+
+		h.queried = nil
+		_, err = reso.Lookup(context.Background(), "www.mixed.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"192.0.2.3:53"}, h.queried)
+
+		nameservers := reso.Nameservers()
+		var lame NameserverInfo
+		for _, info := range nameservers {
+			if info.Addr == netip.MustParseAddr("192.0.2.4") {
+				lame = info
+			}
+		}
+		assert.Contains(t, lame.LameZones, "mixed.com.")
+	})
+
+	t.Run("all name servers are lame", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		_, err := reso.Lookup(context.Background(), "www.refused.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrLameDelegation)
+		_, err = reso.Lookup(context.Background(), "www.refused.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrLameDelegation)
+	})
+
+	t.Run("holds down unresponsive name servers", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		reso := h.resolver()
+		reso.RootHints = []RootHint{{Name: "a.root.test.", Addrs: []netip.Addr{netip.MustParseAddr("192.0.2.99")}}}
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.EqualError(t, err, "mocked error")
+
+		h.queried = nil
+		_, err = reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrLameDelegation)
+		assert.Empty(t, h.queried)
+	})
+}
+
+func TestIterativeResolver_recordsRTTUsingTimeNow(t *testing.T) {
+	h := &iterativeTestHierarchy{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reso := h.resolver()
+	reso.TimeNow = func() time.Time { return now }
+	reso.Transport = &MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			now = now.Add(42 * time.Millisecond)
+			return h.Query(ctx, addr, query)
+		},
+	}
+
+	_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+	assert.NoError(t, err)
+	nameservers := reso.Nameservers()
+	assert.NotEmpty(t, nameservers)
+	for _, info := range nameservers {
+		assert.Equal(t, 42*time.Millisecond, info.SmoothedRTT)
+	}
+}
@@ -5,7 +5,11 @@ package dnscore_test
 import (
 	"context"
 	"crypto/tls"
+	"io"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -126,6 +130,36 @@ func TestTransport_RoundTrip_TLS(t *testing.T) {
 	checkResult(t, resp, err)
 }
 
+func TestTransport_RoundTrip_TLSReusingConns(t *testing.T) {
+	// create and start a testing server
+	server := &dnscoretest.Server{}
+	handler := dnscoretest.NewExampleComHandler()
+	<-server.StartTLS(handler)
+	defer server.Close()
+
+	// create transport and server addr
+	txp := &dnscore.Transport{RootCAs: server.RootCAs, ReuseStreamConns: true}
+	defer txp.Close()
+	serverAddr := dnscore.NewServerAddr(dnscore.ProtocolDoT, server.Addr)
+
+	// issue several queries and verify the results
+	for idx := 0; idx < 3; idx++ {
+		query, err := dnscore.NewQueryWithServerAddr(serverAddr, "example.com", dns.TypeA,
+			dnscore.QueryOptionEDNS0(dnscore.EDNS0SuggestedMaxResponseSizeOtherwise, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := txp.Query(context.Background(), serverAddr, query)
+		checkResult(t, resp, err)
+	}
+
+	// make sure we only performed a single handshake
+	stats := txp.Stats()
+	if len(stats) != 1 || stats[0].Handshakes != 1 || stats[0].ReusedConns != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
 func TestTransport_RoundTrip_HTTPS(t *testing.T) {
 	// create and start a testing server
 	server := &dnscoretest.Server{}
@@ -166,3 +200,195 @@ func TestTransport_RoundTrip_HTTPS(t *testing.T) {
 	// verify the results
 	checkResult(t, resp, err)
 }
+
+func TestTransport_QueryWithInfo_TCP(t *testing.T) {
+	// create and start a testing server
+	server := &dnscoretest.Server{}
+	handler := dnscoretest.NewExampleComHandler()
+	<-server.StartTCP(handler)
+	defer server.Close()
+
+	// create transport, server addr, and query
+	txp := &dnscore.Transport{}
+	serverAddr := dnscore.NewServerAddr(dnscore.ProtocolTCP, server.Addr)
+	query, err := dnscore.NewQueryWithServerAddr(serverAddr, "example.com", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// issue the query and get the response
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, info, err := txp.QueryWithInfo(ctx, serverAddr, query)
+
+	// verify the results
+	checkResult(t, resp, err)
+	assert.Equal(t, server.Addr, info.RemoteAddr.String())
+	assert.True(t, info.LocalAddr.IsValid())
+	assert.False(t, info.ConnectDone.Before(info.ConnectStart))
+	assert.False(t, info.QuerySent.Before(info.ConnectDone))
+	assert.False(t, info.FirstByte.Before(info.QuerySent))
+	assert.False(t, info.LastByte.Before(info.FirstByte))
+}
+
+func TestTransport_ConcurrentStress(t *testing.T) {
+	// 1. start a server for each protocol
+	handler := dnscoretest.NewExampleComHandler()
+	udpServer, tcpServer, tlsServer, httpsServer := &dnscoretest.Server{},
+		&dnscoretest.Server{}, &dnscoretest.Server{}, &dnscoretest.Server{}
+	<-udpServer.StartUDP(handler)
+	defer udpServer.Close()
+	<-tcpServer.StartTCP(handler)
+	defer tcpServer.Close()
+	<-tlsServer.StartTLS(handler)
+	defer tlsServer.Close()
+	<-httpsServer.StartHTTPS(handler)
+	defer httpsServer.Close()
+	addrs := []*dnscore.ServerAddr{
+		dnscore.NewServerAddr(dnscore.ProtocolUDP, udpServer.Addr),
+		dnscore.NewServerAddr(dnscore.ProtocolTCP, tcpServer.Addr),
+		dnscore.NewServerAddr(dnscore.ProtocolDoT, tlsServer.Addr),
+		dnscore.NewServerAddr(dnscore.ProtocolDoH, httpsServer.URL),
+	}
+
+	tests := []struct {
+		name      string
+		reuse     bool
+		pipelines bool
+	}{
+		{"new connections", false, false},
+		{"reused connections", true, false},
+		{"pipelined queries", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 2. share a transport using all the stateful features
+			capture, err := dnscore.NewPcapngWriter(io.Discard)
+			assert.NoError(t, err)
+			var connEvents atomic.Int64
+			txp := &dnscore.Transport{
+				Capture: capture,
+				HTTPClient: &http.Client{
+					Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: httpsServer.RootCAs}},
+				},
+				Logger:                slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug})),
+				LogSampleRate:         2,
+				MaxConcurrentQueries:  8,
+				MaxBackgroundQueries:  4,
+				OnConnEvent:           func(ev *dnscore.ConnEvent) { connEvents.Add(1) },
+				PipelineStreamQueries: tt.pipelines,
+				ReuseStreamConns:      tt.reuse,
+				RootCAs:               tlsServer.RootCAs,
+			}
+
+			// 3. send queries from many goroutines while reading the stats
+			const goroutines, queries = 16, 8
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for ctx.Err() == nil {
+					_ = txp.Stats()
+					time.Sleep(time.Millisecond)
+				}
+			}()
+			wg := &sync.WaitGroup{}
+			for idx := range goroutines {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					qctx := ctx
+					if idx%2 == 0 {
+						qctx = dnscore.ContextWithQueryPriority(ctx, dnscore.QueryPriorityBackground)
+					}
+					for count := range queries {
+						addr := addrs[(idx+count)%len(addrs)]
+						query, err := dnscore.NewQueryWithServerAddr(addr, "example.com", dns.TypeA,
+							dnscore.QueryOptionEDNS0(dnscore.EDNS0SuggestedMaxResponseSizeOtherwise, 0))
+						assert.NoError(t, err)
+						resp, err := txp.Query(qctx, addr, query)
+						checkResult(t, resp, err)
+					}
+				}()
+			}
+			wg.Wait()
+			cancel()
+			<-done
+
+			// 4. make sure we accounted for all the queries and shut down
+			var responses int64
+			for _, stats := range txp.Stats() {
+				responses += stats.Responses
+			}
+			assert.Equal(t, int64(goroutines*queries), responses)
+			assert.NoError(t, txp.Shutdown(context.Background()))
+			assert.True(t, connEvents.Load() > 0)
+		})
+	}
+}
+
+func BenchmarkTransport_Query(b *testing.B) {
+	handler := dnscoretest.NewExampleComHandler()
+	udpServer, tcpServer, tlsServer, httpsServer := &dnscoretest.Server{},
+		&dnscoretest.Server{}, &dnscoretest.Server{}, &dnscoretest.Server{}
+	<-udpServer.StartUDP(handler)
+	defer udpServer.Close()
+	<-tcpServer.StartTCP(handler)
+	defer tcpServer.Close()
+	<-tlsServer.StartTLS(handler)
+	defer tlsServer.Close()
+	<-httpsServer.StartHTTPS(handler)
+	defer httpsServer.Close()
+
+	benchmarks := []struct {
+		name string
+		txp  *dnscore.Transport
+		addr *dnscore.ServerAddr
+	}{{
+		name: "udp",
+		txp:  &dnscore.Transport{},
+		addr: dnscore.NewServerAddr(dnscore.ProtocolUDP, udpServer.Addr),
+	}, {
+		name: "tcp",
+		txp:  &dnscore.Transport{},
+		addr: dnscore.NewServerAddr(dnscore.ProtocolTCP, tcpServer.Addr),
+	}, {
+		name: "tcp reusing connections",
+		txp:  &dnscore.Transport{ReuseStreamConns: true},
+		addr: dnscore.NewServerAddr(dnscore.ProtocolTCP, tcpServer.Addr),
+	}, {
+		name: "tcp pipelining queries",
+		txp:  &dnscore.Transport{PipelineStreamQueries: true},
+		addr: dnscore.NewServerAddr(dnscore.ProtocolTCP, tcpServer.Addr),
+	}, {
+		name: "dot reusing connections",
+		txp:  &dnscore.Transport{RootCAs: tlsServer.RootCAs, ReuseStreamConns: true},
+		addr: dnscore.NewServerAddr(dnscore.ProtocolDoT, tlsServer.Addr),
+	}, {
+		name: "doh",
+		txp: &dnscore.Transport{HTTPClient: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: httpsServer.RootCAs}},
+		}},
+		addr: dnscore.NewServerAddr(dnscore.ProtocolDoH, httpsServer.URL),
+	}}
+
+	for _, bb := range benchmarks {
+		defer bb.txp.Close()
+		b.Run(bb.name, func(b *testing.B) {
+			query, err := dnscore.NewQueryWithServerAddr(bb.addr, "example.com", dns.TypeA,
+				dnscore.QueryOptionEDNS0(dnscore.EDNS0SuggestedMaxResponseSizeOtherwise, 0))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := bb.txp.Query(context.Background(), bb.addr, query); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +0,0 @@
-// SPDX-License-Identifier: GPL-3.0-or-later
-
-package main
-
-import "testing"
-
-func Test_main(_ *testing.T) {
-	destdir = "testdata"
-	main()
-}
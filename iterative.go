@@ -0,0 +1,528 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Errors returned by the [*IterativeResolver].
+var (
+	// ErrIterationLimit indicates that we followed too many referrals,
+	// aliases, or nested name server lookups while resolving a name.
+	ErrIterationLimit = errors.New("iterative resolution limit exceeded")
+
+	// ErrLameDelegation indicates that all the name servers of a zone
+	// are lame or held down after repeated failures.
+	ErrLameDelegation = errors.New("lame delegation")
+
+	// ErrNoNameservers indicates that we could not obtain a valid
+	// response from any of the name servers of a zone.
+	ErrNoNameservers = errors.New("no reachable name servers")
+)
+
+// Default values used by the [*IterativeResolver].
+const (
+	// DefaultIterativeHoldDown is the default amount of time during which
+	// we avoid querying lame or repeatedly failing name servers.
+	DefaultIterativeHoldDown = 15 * time.Minute
+
+	// DefaultIterativeMaxReferrals is the default maximum number of
+	// referrals we follow to resolve a single name.
+	DefaultIterativeMaxReferrals = 24
+
+	// DefaultIterativeMaxDepth is the default maximum nesting depth when
+	// following aliases and resolving the addresses of name servers.
+	DefaultIterativeMaxDepth = 8
+
+	// DefaultIterativeQueryTimeout is the default timeout for each query
+	// sent to an authoritative name server.
+	DefaultIterativeQueryTimeout = 2 * time.Second
+)
+
+// IterativeResolver resolves names iteratively starting from the root
+// servers and following referrals, thus without depending on any upstream
+// recursive resolver. It caches the delegations it learns about and finds
+// the root servers by priming using the root hints (see [*IterativeResolver.Prime]).
+//
+// We also track the RTT of each name server to query the fastest name
+// servers first, and we avoid querying lame name servers and name servers
+// that repeatedly fail for a hold-down period.
+//
+// The zero value is ready to use.
+type IterativeResolver struct {
+	// MaxDepth is the optional maximum nesting depth when following
+	// aliases and resolving the addresses of name servers.
+	//
+	// If zero, we use [DefaultIterativeMaxDepth].
+	MaxDepth int
+
+	// HoldDown is the optional amount of time during which we avoid
+	// querying lame or repeatedly failing name servers.
+	//
+	// If zero, we use [DefaultIterativeHoldDown].
+	HoldDown time.Duration
+
+	// DisableQNameMinimization optionally disables QNAME minimization
+	// (see RFC 9156), such that all the servers see the full name.
+	DisableQNameMinimization bool
+
+	// MaxReferrals is the optional maximum number of referrals to
+	// follow when resolving a single name.
+	//
+	// If zero, we use [DefaultIterativeMaxReferrals].
+	MaxReferrals int
+
+	// QueryTimeout is the optional timeout for each query.
+	//
+	// If zero, we use [DefaultIterativeQueryTimeout].
+	QueryTimeout time.Duration
+
+	// RootHints optionally overrides the root hints used to find
+	// the root servers. See also [LoadRootHintsFile].
+	//
+	// If empty, we use the built-in [DefaultRootHints].
+	RootHints []RootHint
+
+	// TimeNow is the optional function to get the current time.
+	//
+	// If nil, we use [time.Now].
+	TimeNow func() time.Time
+
+	// TTLPolicy is the optional policy to clamp and override the TTL
+	// of the cached delegations and of the RRs returned by
+	// [*IterativeResolver.Lookup].
+	//
+	// If nil, we use the TTLs received from the servers.
+	TTLPolicy *TTLPolicy
+
+	// Transport is the optional DNS transport to use for sending
+	// queries to the authoritative name servers.
+	//
+	// If nil, we use [DefaultTransport].
+	Transport ResolverTransport
+
+	// delegations caches the delegations we know about.
+	delegations map[string]*iterativeDelegation
+
+	// mu protects delegations, nameservers, and root.
+	mu sync.Mutex
+
+	// nameservers contains the RTT and failures of each name server.
+	nameservers map[netip.Addr]*iterativeNameserver
+
+	// root is the root delegation obtained using [*IterativeResolver.Prime].
+	root *iterativeDelegation
+}
+
+// iterativeDelegation is a zone delegation learned from a referral.
+type iterativeDelegation struct {
+	// zone is the canonical name of the delegated zone.
+	zone string
+
+	// addrs contains the addresses of the zone name servers.
+	addrs []netip.Addr
+
+	// nameservers contains the names of the zone name servers.
+	nameservers []string
+
+	// expires is when the delegation expires.
+	expires time.Time
+}
+
+// maxDepth returns the maximum depth or the default.
+func (r *IterativeResolver) maxDepth() int {
+	if r.MaxDepth > 0 {
+		return r.MaxDepth
+	}
+	return DefaultIterativeMaxDepth
+}
+
+// maxReferrals returns the maximum number of referrals or the default.
+func (r *IterativeResolver) maxReferrals() int {
+	if r.MaxReferrals > 0 {
+		return r.MaxReferrals
+	}
+	return DefaultIterativeMaxReferrals
+}
+
+// queryTimeout returns the query timeout or the default.
+func (r *IterativeResolver) queryTimeout() time.Duration {
+	if r.QueryTimeout > 0 {
+		return r.QueryTimeout
+	}
+	return DefaultIterativeQueryTimeout
+}
+
+// timeNow returns the current time using the configured function or [time.Now].
+func (r *IterativeResolver) timeNow() time.Time {
+	if r.TimeNow != nil {
+		return r.TimeNow()
+	}
+	return time.Now()
+}
+
+// transport returns the configured transport or [DefaultTransport].
+func (r *IterativeResolver) transport() ResolverTransport {
+	if r.Transport != nil {
+		return r.Transport
+	}
+	return DefaultTransport
+}
+
+// Lookup resolves the given name and query type and returns the valid
+// answer RRs, including the aliases encountered along the way. Errors are
+// mapped like [*Resolver] does, e.g., NXDOMAIN becomes [ErrNoName].
+func (r *IterativeResolver) Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	resp, err := r.Resolve(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if err := RCodeToError(resp); err != nil {
+		return nil, err
+	}
+	rrs, err := ValidAnswers(resp.Question[0], resp)
+	if err != nil {
+		return nil, err
+	}
+	return r.TTLPolicy.Apply(rrs), nil
+}
+
+// Resolve resolves the given name and query type and returns the final
+// authoritative response. When the name is an alias, we follow the alias and
+// the answer section contains the whole chain of aliases followed by the
+// final answer. The question section always contains the original question.
+func (r *IterativeResolver) Resolve(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	// Make sure we can apply the TTL policy to the delegations
+	if err := r.TTLPolicy.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Use a query to normalize the name and obtain the question
+	query, err := NewQueryWithServerAddr(&ServerAddr{}, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return r.resolve(ctx, query.Question[0], 0)
+}
+
+// resolve implements [*IterativeResolver.Resolve] at the given depth.
+func (r *IterativeResolver) resolve(ctx context.Context, q0 dns.Question, depth int) (*dns.Msg, error) {
+	var chain []dns.RR
+	current := q0
+	for {
+		// 1. resolve the current name
+		if depth > r.maxDepth() {
+			return nil, ErrIterationLimit
+		}
+		resp, err := r.resolveOnce(ctx, current, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		// 2. figure out whether we need to follow an alias
+		target, ok := iterativeAliasTarget(current, resp)
+		if !ok {
+			out := resp.Copy()
+			out.Question = []dns.Question{q0}
+			out.Answer = append(chain, resp.Answer...)
+			return out, nil
+		}
+		chain = append(chain, resp.Answer...)
+		current.Name = target
+		depth++
+	}
+}
+
+// iterativeAliasTarget returns the name to resolve next when the response
+// only contains an alias chain for the given question.
+func iterativeAliasTarget(q0 dns.Question, resp *dns.Msg) (string, bool) {
+	if resp.Rcode != dns.RcodeSuccess || q0.Qtype == dns.TypeCNAME {
+		return "", false
+	}
+	target := q0.Name
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && equalASCIIName(cname.Hdr.Name, target) {
+			target = cname.Target
+		}
+	}
+	if equalASCIIName(target, q0.Name) {
+		return "", false
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == q0.Qtype && equalASCIIName(rr.Header().Name, target) {
+			return "", false
+		}
+	}
+	return target, true
+}
+
+// resolveOnce walks the delegations from the closest known zone until it
+// obtains a response that is not a referral for the given question.
+func (r *IterativeResolver) resolveOnce(ctx context.Context, q0 dns.Question, depth int) (*dns.Msg, error) {
+	deleg := r.closestDelegation(q0.Name)
+	if deleg == nil {
+		deleg = r.rootDelegation(ctx)
+	}
+	qmin := newIterativeQNameMinimizer(q0, deleg.zone, !r.DisableQNameMinimization)
+	for referrals := 0; referrals <= r.maxReferrals(); {
+		// 1. query the name servers of the current zone using
+		// the possibly minimized question
+		question := qmin.question()
+		minimized := question != q0
+		resp, err := r.queryZone(ctx, deleg, question, depth)
+
+		// 2. fallback to the full name when servers misbehave
+		// with minimized questions (see RFC 9156 Sect. 2.3)
+		if minimized && (err != nil || resp.Rcode != dns.RcodeSuccess) {
+			qmin.disable()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// 3. handle responses that are not referrals
+		child, ok := iterativeReferral(deleg.zone, question.Name, resp)
+		if !ok {
+			if minimized {
+				qmin.advance(question.Name)
+				continue
+			}
+			return resp, nil
+		}
+
+		// 4. follow the referral and remember it
+		deleg = r.newDelegation(child, resp.Ns, resp.Extra)
+		r.cacheDelegation(deleg)
+		qmin.advance(child)
+		referrals++
+	}
+	return nil, ErrIterationLimit
+}
+
+// iterativeReferral returns the child zone when the response is a
+// referral from zone to a zone closer to the given name.
+func iterativeReferral(zone, name string, resp *dns.Msg) (string, bool) {
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) > 0 {
+		return "", false
+	}
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		child := dns.CanonicalName(ns.Hdr.Name)
+		if child != zone && dns.IsSubDomain(zone, child) && dns.IsSubDomain(child, dns.CanonicalName(name)) {
+			return child, true
+		}
+	}
+	return "", false
+}
+
+// newDelegation creates a delegation for the given zone using the
+// NS records for the zone along with the available glue.
+func (r *IterativeResolver) newDelegation(zone string, nsRRs, glue []dns.RR) *iterativeDelegation {
+	deleg := &iterativeDelegation{zone: zone}
+	var ttl uint32
+	for _, rr := range nsRRs {
+		if ns, ok := rr.(*dns.NS); ok && dns.CanonicalName(ns.Hdr.Name) == zone {
+			deleg.nameservers = append(deleg.nameservers, dns.CanonicalName(ns.Ns))
+			if ttl == 0 || ns.Hdr.Ttl < ttl {
+				ttl = ns.Hdr.Ttl
+			}
+		}
+	}
+	deleg.expires = r.timeNow().Add(time.Duration(r.TTLPolicy.TTL(zone, ttl)) * time.Second)
+
+	// collect glue, using IPv4 addresses before IPv6 addresses
+	var v4, v6 []netip.Addr
+	for _, rr := range glue {
+		name := dns.CanonicalName(rr.Header().Name)
+		if !iterativeContains(deleg.nameservers, name) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(rr.A.To4()); ok {
+				v4 = append(v4, addr)
+			}
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(rr.AAAA.To16()); ok {
+				v6 = append(v6, addr)
+			}
+		}
+	}
+	deleg.addrs = append(v4, v6...)
+	return deleg
+}
+
+// iterativeContains returns whether the list contains the given name.
+func iterativeContains(names []string, name string) bool {
+	for _, entry := range names {
+		if entry == name {
+			return true
+		}
+	}
+	return false
+}
+
+// closestDelegation returns the cached delegation closest to the
+// given name or nil, meaning that we should start from the root.
+func (r *IterativeResolver) closestDelegation(name string) *iterativeDelegation {
+	name = dns.CanonicalName(name)
+	now := r.timeNow()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if deleg, ok := r.delegations[name[off:]]; ok {
+			if now.Before(deleg.expires) {
+				return deleg
+			}
+			delete(r.delegations, name[off:])
+		}
+	}
+	return nil
+}
+
+// cacheDelegation adds the given delegation to the cache.
+func (r *IterativeResolver) cacheDelegation(deleg *iterativeDelegation) {
+	if len(deleg.addrs) <= 0 {
+		return // do not cache delegations we could not fully resolve
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.delegations == nil {
+		r.delegations = make(map[string]*iterativeDelegation)
+	}
+	r.delegations[deleg.zone] = deleg
+}
+
+// queryZone queries the name servers of a zone in sequence until one of
+// them returns a valid response. When the referral did not contain glue, we
+// resolve the addresses of the name servers first.
+func (r *IterativeResolver) queryZone(ctx context.Context,
+	deleg *iterativeDelegation, q0 dns.Question, depth int) (*dns.Msg, error) {
+	// 1. resolve the name server addresses if needed
+	if len(deleg.addrs) <= 0 {
+		deleg.addrs = r.resolveNameservers(ctx, deleg, depth)
+		r.cacheDelegation(deleg)
+	}
+
+	// 2. query each usable name server, fastest first, until one succeeds
+	addrs := r.usableNameservers(deleg.zone, deleg.addrs)
+	if len(addrs) <= 0 && len(deleg.addrs) > 0 {
+		return nil, ErrLameDelegation
+	}
+	lastErr := ErrNoNameservers
+	for _, addr := range addrs {
+		// 2.1. send the query, update the server RTT and failures,
+		// and remove the RRs the server is not authoritative for
+		t0 := r.timeNow()
+		resp, err := r.queryServer(ctx, deleg.zone, addr, q0, depth)
+		if err != nil {
+			r.recordNameserverFailure(addr)
+			lastErr = err
+			continue
+		}
+		r.recordNameserverRTT(addr, r.timeNow().Sub(t0))
+		ScrubOutOfBailiwick(deleg.zone, resp)
+
+		// 2.2. only accept NOERROR and NXDOMAIN from non-lame servers
+		switch {
+		case iterativeIsLame(deleg.zone, q0.Name, resp):
+			r.recordLameNameserver(addr, deleg.zone)
+			lastErr = ErrLameDelegation
+		case resp.Rcode == dns.RcodeServerFailure:
+			lastErr = ErrServerTemporarilyMisbehaving
+		case resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError:
+			lastErr = ErrServerMisbehaving
+		default:
+			return resp, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// resolveNameservers resolves the addresses of the name servers of a
+// delegation that did not include glue records.
+func (r *IterativeResolver) resolveNameservers(ctx context.Context,
+	deleg *iterativeDelegation, depth int) (addrs []netip.Addr) {
+	for _, name := range deleg.nameservers {
+		// avoid looping when the name server is inside the zone
+		if dns.IsSubDomain(deleg.zone, name) {
+			continue
+		}
+		q0 := dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		resp, err := r.resolve(ctx, q0, depth+1)
+		if err != nil {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if rr, ok := rr.(*dns.A); ok {
+				if addr, ok := netip.AddrFromSlice(rr.A.To4()); ok {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+		if len(addrs) > 0 {
+			return // one resolved name server is enough to make progress
+		}
+	}
+	return
+}
+
+// queryServer sends the question to the given authoritative server of
+// the given zone over UDP and retries over TCP if the response is truncated.
+// It returns an error if the response is invalid or the server does not
+// answer. When tracing, we record each query along with its outcome.
+func (r *IterativeResolver) queryServer(ctx context.Context,
+	zone string, addr netip.Addr, q0 dns.Question, depth int) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.queryTimeout())
+	defer cancel()
+
+	tr := traceFromContext(ctx)
+	var flags int
+	if tr != nil {
+		flags |= EDNS0FlagDO
+	}
+	endpoint := netip.AddrPortFrom(addr, 53)
+	for _, protocol := range []Protocol{ProtocolUDP, ProtocolTCP} {
+		// 1. create the query without requesting recursion
+		saddr := NewServerAddr(protocol, endpoint.String())
+		query, err := NewQueryWithServerAddr(saddr, q0.Name, q0.Qtype,
+			QueryOptionEDNS0(EDNS0SuggestedMaxResponseSizeUDP, flags))
+		if err != nil {
+			return nil, err
+		}
+		query.RecursionDesired = false
+
+		// 2. send the query, validate the response, and trace
+		t0 := r.timeNow()
+		resp, err := r.transport().Query(ctx, saddr, query)
+		if err == nil {
+			err = ValidateResponse(query, resp)
+		}
+		if tr != nil {
+			var traced *dns.Msg
+			if err == nil {
+				traced = resp
+			}
+			tr.add(newTraceStep(zone, q0, depth, endpoint, protocol, r.timeNow().Sub(t0), traced, err))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.Truncated && protocol == ProtocolUDP {
+			continue
+		}
+		return resp, nil
+	}
+	return nil, ErrInvalidResponse
+}
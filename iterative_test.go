@@ -0,0 +1,250 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// iterativeTestHierarchy simulates a hierarchy of authoritative servers
+// and records the addresses of the servers it has been queried at.
+type iterativeTestHierarchy struct {
+	mu      sync.Mutex
+	primes  int
+	qnames  []string
+	queried []string
+}
+
+// rr parses an RR or panics.
+func (h *iterativeTestHierarchy) rr(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+// Query implements [ResolverTransport].
+func (h *iterativeTestHierarchy) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	h.mu.Lock()
+	h.queried = append(h.queried, addr.Address)
+	h.qnames = append(h.qnames, query.Question[0].Name)
+	h.mu.Unlock()
+
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	name := query.Question[0].Name
+	dnssec := query.IsEdns0() != nil && query.IsEdns0().Do()
+	switch addr.Address {
+	case "192.0.2.1:53": // root
+		if name == "." && query.Question[0].Qtype == dns.TypeNS {
+			h.mu.Lock()
+			h.primes++
+			h.mu.Unlock()
+			resp.Authoritative = true
+			resp.Answer = append(resp.Answer, h.rr(". 518400 IN NS a.root.test."))
+			resp.Extra = append(resp.Extra, h.rr("a.root.test. 518400 IN A 192.0.2.1"))
+			break
+		}
+		resp.Ns = append(resp.Ns, h.rr("com. 172800 IN NS a.gtld.com."))
+		resp.Extra = append(resp.Extra, h.rr("a.gtld.com. 172800 IN A 192.0.2.2"))
+
+	case "192.0.2.2:53": // com
+		switch {
+		case dns.IsSubDomain("example.com.", name):
+			resp.Ns = append(resp.Ns, h.rr("example.com. 3600 IN NS ns.example.com."))
+			resp.Extra = append(resp.Extra, h.rr("ns.example.com. 3600 IN A 192.0.2.3"))
+			if dnssec {
+				resp.Ns = append(resp.Ns, h.rr("example.com. 3600 IN DS 12345 13 2 "+
+					"0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"))
+			}
+		case dns.IsSubDomain("glueless.com.", name):
+			resp.Ns = append(resp.Ns, h.rr("glueless.com. 3600 IN NS ns.example.com."))
+		case dns.IsSubDomain("lame.com.", name):
+			resp.Ns = append(resp.Ns, h.rr("lame.com. 3600 IN NS ns.lame.com."))
+		case dns.IsSubDomain("mixed.com.", name):
+			resp.Ns = append(resp.Ns, h.rr("mixed.com. 3600 IN NS ns1.mixed.com."))
+			resp.Ns = append(resp.Ns, h.rr("mixed.com. 3600 IN NS ns2.mixed.com."))
+			resp.Extra = append(resp.Extra, h.rr("ns1.mixed.com. 3600 IN A 192.0.2.4"))
+			resp.Extra = append(resp.Extra, h.rr("ns2.mixed.com. 3600 IN A 192.0.2.3"))
+		case dns.IsSubDomain("refused.com.", name):
+			resp.Ns = append(resp.Ns, h.rr("refused.com. 3600 IN NS ns.refused.com."))
+			resp.Extra = append(resp.Extra, h.rr("ns.refused.com. 3600 IN A 192.0.2.5"))
+		default:
+			resp.Rcode = dns.RcodeNameError
+		}
+
+	case "192.0.2.3:53": // example.com and glueless.com
+		resp.Authoritative = true
+		switch name {
+		case "www.example.com.", "www.glueless.com.", "www.mixed.com.":
+			resp.Answer = append(resp.Answer, h.rr(name+" 300 IN A 198.51.100.1"))
+			if dnssec && name == "www.example.com." {
+				resp.Answer = append(resp.Answer, h.rr(name+" 300 IN RRSIG A 13 3 300 "+
+					"20300101000000 20200101000000 12345 example.com. dGVzdA=="))
+			}
+		case "host.ent.example.com.":
+			resp.Answer = append(resp.Answer, h.rr(name+" 300 IN A 198.51.100.3"))
+		case "ns.example.com.":
+			resp.Answer = append(resp.Answer, h.rr(name+" 300 IN A 192.0.2.3"))
+		case "poisoned.example.com.":
+			resp.Answer = append(resp.Answer, h.rr(name+" 300 IN CNAME www.glueless.com."))
+			resp.Answer = append(resp.Answer, h.rr("www.glueless.com. 300 IN A 203.0.113.66"))
+			resp.Extra = append(resp.Extra, h.rr("www.glueless.com. 300 IN A 203.0.113.66"))
+		case "alias.example.com.":
+			resp.Answer = append(resp.Answer, h.rr(name+" 300 IN CNAME www.glueless.com."))
+		case "big.example.com.":
+			if addr.Protocol == ProtocolUDP {
+				resp.Truncated = true
+				break
+			}
+			resp.Answer = append(resp.Answer, h.rr(name+" 300 IN A 198.51.100.2"))
+		default:
+			resp.Rcode = dns.RcodeNameError
+			resp.Ns = append(resp.Ns, h.rr("example.com. 300 IN SOA ns.example.com. admin.example.com. 1 2 3 4 5"))
+		}
+
+	case "192.0.2.4:53": // lame server returning an upward referral
+		resp.Ns = append(resp.Ns, h.rr("com. 172800 IN NS a.gtld.com."))
+
+	case "192.0.2.5:53": // lame server refusing queries
+		resp.Rcode = dns.RcodeRefused
+
+	default:
+		return nil, errors.New("mocked error")
+	}
+	return resp, nil
+}
+
+// resolver returns an [*IterativeResolver] querying the hierarchy.
+func (h *iterativeTestHierarchy) resolver() *IterativeResolver {
+	return &IterativeResolver{
+		RootHints: []RootHint{{
+			Name:  "a.root.test.",
+			Addrs: []netip.Addr{netip.MustParseAddr("192.0.2.1")},
+		}},
+		Transport: h,
+	}
+}
+
+func TestIterativeResolver_Lookup(t *testing.T) {
+	t.Run("follows referrals and caches delegations", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		reso := h.resolver()
+
+		rrs, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+		assert.Equal(t, net.IPv4(198, 51, 100, 1).To4(), rrs[0].(*dns.A).A.To4())
+		assert.Equal(t, []string{"192.0.2.1:53", "192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}, h.queried)
+		assert.Equal(t, 1, h.primes)
+
+		h.queried = nil
+		_, err = reso.Lookup(context.Background(), "ns.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"192.0.2.3:53"}, h.queried)
+	})
+
+	t.Run("expired delegations are not used", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		now := time.Now()
+		reso := h.resolver()
+		reso.TimeNow = func() time.Time { return now }
+
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+
+		now = now.Add(2 * time.Hour) // example.com expired, com still valid
+		h.queried = nil
+		_, err = reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"192.0.2.2:53", "192.0.2.3:53"}, h.queried)
+	})
+
+	t.Run("resolves name servers without glue", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		rrs, err := reso.Lookup(context.Background(), "www.glueless.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+	})
+
+	t.Run("follows aliases across zones", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		resp, err := reso.Resolve(context.Background(), "alias.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, "alias.example.com.", resp.Question[0].Name)
+		assert.Len(t, resp.Answer, 2)
+		assert.IsType(t, &dns.CNAME{}, resp.Answer[0])
+		assert.IsType(t, &dns.A{}, resp.Answer[1])
+
+		rrs, err := reso.Lookup(context.Background(), "alias.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+	})
+
+	t.Run("ignores out-of-bailiwick records", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		resp, err := reso.Resolve(context.Background(), "poisoned.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, resp.Answer, 2)
+		assert.Equal(t, net.IPv4(198, 51, 100, 1).To4(), resp.Answer[1].(*dns.A).A.To4())
+	})
+
+	t.Run("retries truncated responses over TCP", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		rrs, err := reso.Lookup(context.Background(), "big.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+	})
+
+	t.Run("NXDOMAIN", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		_, err := reso.Lookup(context.Background(), "nonexistent.example.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrNoName)
+	})
+
+	t.Run("in-zone name servers without glue", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		_, err := reso.Lookup(context.Background(), "www.lame.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrNoNameservers)
+	})
+
+	t.Run("too many referrals", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		reso.MaxReferrals = 1
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrIterationLimit)
+	})
+
+	t.Run("unreachable root servers", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		reso.RootHints = []RootHint{{Name: "a.root.test.", Addrs: []netip.Addr{netip.MustParseAddr("192.0.2.99")}}}
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.EqualError(t, err, "mocked error")
+	})
+}
+
+func Test_iterativeReferral(t *testing.T) {
+	h := &iterativeTestHierarchy{}
+	resp := &dns.Msg{}
+	resp.Ns = append(resp.Ns, h.rr("org. 3600 IN NS a.gtld.org."))
+	_, ok := iterativeReferral(".", "www.example.com.", resp)
+	assert.False(t, ok, "referral to a zone not containing the name")
+
+	resp.Ns = []dns.RR{h.rr("com. 3600 IN NS a.gtld.com.")}
+	_, ok = iterativeReferral("com.", "www.example.com.", resp)
+	assert.False(t, ok, "referral to the same zone")
+
+	child, ok := iterativeReferral(".", "www.example.com.", resp)
+	assert.True(t, ok)
+	assert.Equal(t, "com.", child)
+}
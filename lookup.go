@@ -16,6 +16,8 @@ package dnscore
 import (
 	"context"
 	"errors"
+	"net/netip"
+	"slices"
 
 	"github.com/miekg/dns"
 )
@@ -32,10 +34,19 @@ func (r *Resolver) transport() ResolverTransport {
 // exchange implements [*Resolver.lookup] with a specific server.
 func (r *Resolver) exchange(ctx context.Context,
 	name string, qtype uint16, server resolverConfigServer) ([]dns.RR, error) {
+	rrs, _, err := r.exchangeWithScope(ctx, name, qtype, server)
+	return rrs, err
+}
+
+// exchangeWithScope is like [*Resolver.exchange] but also returns the ECS
+// scope of the answer, which is the zero prefix if the answer applies to
+// all clients, as documented by [clientSubnetScope].
+func (r *Resolver) exchangeWithScope(ctx context.Context,
+	name string, qtype uint16, server resolverConfigServer) ([]dns.RR, netip.Prefix, error) {
 	// Handle the case of domains that should not be resolved
 	labels := dns.SplitDomainName(dns.CanonicalName(name))
 	if len(labels) > 0 && labels[len(labels)-1] == "onion" {
-		return nil, ErrNoData
+		return nil, netip.Prefix{}, ErrNoData
 	}
 
 	// Enforce an operation timeout
@@ -45,59 +56,106 @@ func (r *Resolver) exchange(ctx context.Context,
 		defer cancel()
 	}
 
-	// Encode the query
-	query, err := NewQueryWithServerAddr(server.address, name, qtype, server.queryOptions...)
+	// Encode the query, adding ECS after the server options
+	options := server.queryOptions
+	if r.ClientSubnet.IsValid() {
+		options = append(slices.Clip(options), QueryOptionClientSubnet(r.ClientSubnet))
+	}
+	query, err := newQueryWithIDGenerator(server.address, name, qtype, r.idGenerator(), options...)
 	if err != nil {
-		return nil, err
+		return nil, netip.Prefix{}, err
 	}
 	q0 := query.Question[0] // we know it's present because we just created it
 
-	// Obtain the transport and perform the query
-	resp, err := r.transport().Query(ctx, server.address, query)
+	// Obtain the transport, perform the query, and update the server statistics
+	t0 := r.timeNow()
+	resp, err := r.query(ctx, server.address, query)
+	r.config().recordExchange(server.address, r.timeNow().Sub(t0), exchangeFailed(query, resp, err))
 	if err != nil {
-		return nil, err
+		return nil, netip.Prefix{}, err
 	}
 
 	// Validate the response, check for errors and extract RRs
 	if err := ValidateResponse(query, resp); err != nil {
-		return nil, err
+		return nil, netip.Prefix{}, err
 	}
 	if err := RCodeToError(resp); err != nil {
-		return nil, err
+		return nil, netip.Prefix{}, err
+	}
+	rrs, err := ValidAnswers(q0, resp)
+	if err != nil {
+		return nil, netip.Prefix{}, err
 	}
-	return ValidAnswers(q0, resp)
+	return rrs, clientSubnetScope(r.ClientSubnet, resp), nil
 }
 
 // lookup is the internal implementation of the Lookup* functions.
 func (r *Resolver) lookup(ctx context.Context,
 	name string, qtype uint16) ([]dns.RR, error) {
+	if r.Cache != nil {
+		return r.lookupCached(ctx, name, qtype)
+	}
+	return r.lookupUncached(ctx, name, qtype)
+}
+
+// lookupUncached implements [*Resolver.lookup] by querying the servers.
+func (r *Resolver) lookupUncached(ctx context.Context,
+	name string, qtype uint16) ([]dns.RR, error) {
+	rrs, _, err := r.lookupUncachedWithScope(ctx, name, qtype)
+	return rrs, err
+}
+
+// lookupUncachedWithScope is like [*Resolver.lookupUncached] but also
+// returns the ECS scope of the answer, like [*Resolver.exchangeWithScope].
+func (r *Resolver) lookupUncachedWithScope(ctx context.Context,
+	name string, qtype uint16) ([]dns.RR, netip.Prefix, error) {
+	// make sure we can apply the TTL policy to the answers
+	if err := r.TTLPolicy.Validate(); err != nil {
+		return nil, netip.Prefix{}, err
+	}
+
 	// by default, on failure, we return the EAI_NODATA equivalent
 	lastErr := ErrNoData
 
-	// obtain the list of servers and prepare to walk it
+	// obtain the list of servers and prepare to walk it, reading the
+	// settings once such that a concurrent reload cannot affect us
 	var (
 		config   = r.config()
-		attempts = config.Attempts()
-		servers  = config.servers()
+		settings = config.snapshot()
+		attempts = settings.attempts
+		servers  = config.orderServers(settings.selection,
+			config.healthyServers(settings.servers(), r.timeNow()))
 	)
 	for idx := 0; len(servers) > 0 && idx < attempts; idx++ {
 		// select a server and exchange the query
 		server := servers[uint32(idx)%uint32(len(servers))]
-		rrs, err := r.exchange(ctx, name, qtype, server)
+		rrs, scope, err := r.exchangeWithScope(ctx, name, qtype, server)
 
 		// immediately handle success and stop on NXDOMAIN
 		//
 		// note: it's not so common to use NXDOMAIN for censorship
 		// so this is a trade off to privilege fast convergence
 		if err == nil {
-			return rrs, nil
+			return r.TTLPolicy.Apply(rrs), scope, nil
 		}
 		if errors.Is(err, ErrNoName) {
-			return nil, err
+			return nil, netip.Prefix{}, err
 		}
 
 		lastErr = err
+
+		// stop using servers that asked us to retry later or that cannot
+		// handle the query, and remember when we can use them again
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && (httpErr.RetryAfter > 0 || !httpErr.Temporary()) {
+			if httpErr.RetryAfter > 0 {
+				config.recordRetryAfter(server.address, r.timeNow().Add(httpErr.RetryAfter))
+			}
+			servers = slices.DeleteFunc(slices.Clone(servers), func(s resolverConfigServer) bool {
+				return s.address == server.address
+			})
+		}
 	}
 
-	return nil, lastErr
+	return nil, netip.Prefix{}, lastErr
 }
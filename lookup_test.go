@@ -129,9 +129,11 @@ func TestResolver_lookup(t *testing.T) {
 		}
 		resolver := &Resolver{Transport: mockTransport}
 		config := &ResolverConfig{
-			attempts: DefaultAttempts,
-			list: []resolverConfigServer{
-				{address: &ServerAddr{Address: "8.8.8.8:53"}},
+			settings: &resolverConfigSettings{
+				attempts: DefaultAttempts,
+				list: []resolverConfigServer{
+					{address: &ServerAddr{Address: "8.8.8.8:53"}},
+				},
 			},
 		}
 		resolver.Config = config
@@ -154,9 +156,11 @@ func TestResolver_lookup(t *testing.T) {
 		}
 		resolver := &Resolver{Transport: mockTransport}
 		config := &ResolverConfig{
-			attempts: DefaultAttempts,
-			list: []resolverConfigServer{
-				{address: &ServerAddr{Address: "8.8.8.8:53"}},
+			settings: &resolverConfigSettings{
+				attempts: DefaultAttempts,
+				list: []resolverConfigServer{
+					{address: &ServerAddr{Address: "8.8.8.8:53"}},
+				},
 			},
 		}
 		resolver.Config = config
@@ -177,9 +181,11 @@ func TestResolver_lookup(t *testing.T) {
 		}
 		resolver := &Resolver{Transport: mockTransport}
 		config := &ResolverConfig{
-			attempts: DefaultAttempts,
-			list: []resolverConfigServer{
-				{address: &ServerAddr{Address: "8.8.8.8:53"}},
+			settings: &resolverConfigSettings{
+				attempts: DefaultAttempts,
+				list: []resolverConfigServer{
+					{address: &ServerAddr{Address: "8.8.8.8:53"}},
+				},
 			},
 		}
 		resolver.Config = config
@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrInvalidMsgJSON indicates that a JSON object does not
+// represent a valid DNS message according to RFC 8427.
+var ErrInvalidMsgJSON = errors.New("invalid DNS message JSON")
+
+// MsgJSON wraps a [*dns.Msg] such that it can be serialized to and from
+// JSON using the DNS-in-JSON mapping defined by RFC 8427.
+//
+// When marshalling, we emit the header members, the members of the first
+// question, and the answerRRs, authorityRRs, and additionalRRs arrays. Each
+// RR contains the RDATAHEX member and, for common types such as A, AAAA,
+// CNAME, and NS, the rdata<TYPE> member in presentation format.
+//
+// When unmarshalling, we use RDATAHEX to decode each RR and fall back to
+// the rdata<TYPE> member when RDATAHEX is missing. The boolean flags may be
+// either JSON booleans or the integers 0 and 1, which are both used in practice.
+type MsgJSON struct {
+	// Msg is the wrapped message.
+	Msg *dns.Msg
+}
+
+// MarshalMsgJSON serializes the given message as RFC 8427 JSON.
+func MarshalMsgJSON(msg *dns.Msg) ([]byte, error) {
+	return json.Marshal(MsgJSON{Msg: msg})
+}
+
+// UnmarshalMsgJSON parses a message from RFC 8427 JSON.
+func UnmarshalMsgJSON(data []byte) (*dns.Msg, error) {
+	var mj MsgJSON
+	if err := json.Unmarshal(data, &mj); err != nil {
+		return nil, err
+	}
+	return mj.Msg, nil
+}
+
+// msgJSONFlag is a flag that unmarshals from a boolean or from 0 and 1.
+type msgJSONFlag bool
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (f *msgJSONFlag) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*f = true
+	case "false", "0":
+		*f = false
+	default:
+		return fmt.Errorf("%w: invalid flag: %s", ErrInvalidMsgJSON, string(data))
+	}
+	return nil
+}
+
+// msgJSON is the RFC 8427 representation of a message.
+type msgJSON struct {
+	ID            uint16      `json:"ID"`
+	QR            msgJSONFlag `json:"QR"`
+	Opcode        int         `json:"Opcode"`
+	AA            msgJSONFlag `json:"AA"`
+	TC            msgJSONFlag `json:"TC"`
+	RD            msgJSONFlag `json:"RD"`
+	RA            msgJSONFlag `json:"RA"`
+	AD            msgJSONFlag `json:"AD"`
+	CD            msgJSONFlag `json:"CD"`
+	RCODE         int         `json:"RCODE"`
+	QDCOUNT       int         `json:"QDCOUNT"`
+	ANCOUNT       int         `json:"ANCOUNT"`
+	NSCOUNT       int         `json:"NSCOUNT"`
+	ARCOUNT       int         `json:"ARCOUNT"`
+	QNAME         string      `json:"QNAME,omitempty"`
+	QTYPE         uint16      `json:"QTYPE,omitempty"`
+	QTYPEname     string      `json:"QTYPEname,omitempty"`
+	QCLASS        uint16      `json:"QCLASS,omitempty"`
+	QCLASSname    string      `json:"QCLASSname,omitempty"`
+	AnswerRRs     []rrJSON    `json:"answerRRs,omitempty"`
+	AuthorityRRs  []rrJSON    `json:"authorityRRs,omitempty"`
+	AdditionalRRs []rrJSON    `json:"additionalRRs,omitempty"`
+}
+
+// rrJSON is the RFC 8427 representation of an RR.
+type rrJSON struct {
+	NAME       string `json:"NAME"`
+	TYPE       uint16 `json:"TYPE"`
+	TYPEname   string `json:"TYPEname,omitempty"`
+	CLASS      uint16 `json:"CLASS"`
+	CLASSname  string `json:"CLASSname,omitempty"`
+	TTL        uint32 `json:"TTL"`
+	RDLENGTH   int    `json:"RDLENGTH"`
+	RDATAHEX   string `json:"RDATAHEX,omitempty"`
+	RdataA     string `json:"rdataA,omitempty"`
+	RdataAAAA  string `json:"rdataAAAA,omitempty"`
+	RdataCNAME string `json:"rdataCNAME,omitempty"`
+	RdataDNAME string `json:"rdataDNAME,omitempty"`
+	RdataNS    string `json:"rdataNS,omitempty"`
+	RdataPTR   string `json:"rdataPTR,omitempty"`
+}
+
+// MarshalJSON implements [json.Marshaler].
+func (m MsgJSON) MarshalJSON() ([]byte, error) {
+	if m.Msg == nil {
+		return []byte("null"), nil
+	}
+	msg := m.Msg
+	out := msgJSON{
+		ID:      msg.Id,
+		QR:      msgJSONFlag(msg.Response),
+		Opcode:  msg.Opcode,
+		AA:      msgJSONFlag(msg.Authoritative),
+		TC:      msgJSONFlag(msg.Truncated),
+		RD:      msgJSONFlag(msg.RecursionDesired),
+		RA:      msgJSONFlag(msg.RecursionAvailable),
+		AD:      msgJSONFlag(msg.AuthenticatedData),
+		CD:      msgJSONFlag(msg.CheckingDisabled),
+		RCODE:   msg.Rcode,
+		QDCOUNT: len(msg.Question),
+		ANCOUNT: len(msg.Answer),
+		NSCOUNT: len(msg.Ns),
+		ARCOUNT: len(msg.Extra),
+	}
+	if len(msg.Question) > 0 {
+		q0 := msg.Question[0]
+		out.QNAME = q0.Name
+		out.QTYPE, out.QTYPEname = q0.Qtype, dns.Type(q0.Qtype).String()
+		out.QCLASS, out.QCLASSname = q0.Qclass, dns.Class(q0.Qclass).String()
+	}
+	var err error
+	if out.AnswerRRs, err = newRRsJSON(msg.Answer); err != nil {
+		return nil, err
+	}
+	if out.AuthorityRRs, err = newRRsJSON(msg.Ns); err != nil {
+		return nil, err
+	}
+	if out.AdditionalRRs, err = newRRsJSON(msg.Extra); err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// newRRsJSON converts a list of RRs to their JSON representation.
+func newRRsJSON(rrs []dns.RR) (out []rrJSON, err error) {
+	for _, rr := range rrs {
+		// 1. obtain the RDATA by packing the RR and skipping the header,
+		// whose length is the length of the name plus ten bytes
+		buf := make([]byte, dns.Len(rr)+1)
+		off, err := dns.PackRR(rr, buf, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		hdr := rr.Header()
+		nameLen, err := dns.PackDomainName(hdr.Name, make([]byte, 256), 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		rdata := buf[nameLen+10 : off]
+
+		// 2. fill the JSON representation
+		entry := rrJSON{
+			NAME:      hdr.Name,
+			TYPE:      hdr.Rrtype,
+			TYPEname:  dns.Type(hdr.Rrtype).String(),
+			CLASS:     hdr.Class,
+			CLASSname: dns.Class(hdr.Class).String(),
+			TTL:       hdr.Ttl,
+			RDLENGTH:  len(rdata),
+			RDATAHEX:  strings.ToUpper(hex.EncodeToString(rdata)),
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			entry.RdataA = rr.A.String()
+		case *dns.AAAA:
+			entry.RdataAAAA = rr.AAAA.String()
+		case *dns.CNAME:
+			entry.RdataCNAME = rr.Target
+		case *dns.DNAME:
+			entry.RdataDNAME = rr.Target
+		case *dns.NS:
+			entry.RdataNS = rr.Ns
+		case *dns.PTR:
+			entry.RdataPTR = rr.Ptr
+		}
+		out = append(out, entry)
+	}
+	return
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (m *MsgJSON) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		m.Msg = nil
+		return nil
+	}
+	var in msgJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	msg := &dns.Msg{}
+	msg.Id = in.ID
+	msg.Response = bool(in.QR)
+	msg.Opcode = in.Opcode
+	msg.Authoritative = bool(in.AA)
+	msg.Truncated = bool(in.TC)
+	msg.RecursionDesired = bool(in.RD)
+	msg.RecursionAvailable = bool(in.RA)
+	msg.AuthenticatedData = bool(in.AD)
+	msg.CheckingDisabled = bool(in.CD)
+	msg.Rcode = in.RCODE
+	if in.QNAME != "" {
+		msg.Question = []dns.Question{{Name: dns.Fqdn(in.QNAME), Qtype: in.QTYPE, Qclass: in.QCLASS}}
+	}
+	var err error
+	if msg.Answer, err = parseRRsJSON(in.AnswerRRs); err != nil {
+		return err
+	}
+	if msg.Ns, err = parseRRsJSON(in.AuthorityRRs); err != nil {
+		return err
+	}
+	if msg.Extra, err = parseRRsJSON(in.AdditionalRRs); err != nil {
+		return err
+	}
+	m.Msg = msg
+	return nil
+}
+
+// parseRRsJSON converts JSON representations to a list of RRs.
+func parseRRsJSON(entries []rrJSON) (out []dns.RR, err error) {
+	for _, entry := range entries {
+		rr, err := parseRRJSON(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		// make sure we can serialize the RR, since unpacking accepts
+		// some RRs that we cannot pack (e.g., unknown types without RDATA)
+		if _, err := dns.PackRR(rr, make([]byte, dns.Len(rr)+1), 0, nil, false); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMsgJSON, err.Error())
+		}
+		out = append(out, rr)
+	}
+	return
+}
+
+// parseRRJSON converts the JSON representation to an RR.
+func parseRRJSON(entry rrJSON) (dns.RR, error) {
+	hdr := dns.RR_Header{
+		Name:   dns.Fqdn(entry.NAME),
+		Rrtype: entry.TYPE,
+		Class:  entry.CLASS,
+		Ttl:    entry.TTL,
+	}
+
+	// 1. prefer RDATAHEX, which is available for every type
+	if entry.RDATAHEX != "" || entry.RDLENGTH == 0 {
+		rdata, err := hex.DecodeString(entry.RDATAHEX)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMsgJSON, err.Error())
+		}
+		hdr.Rdlength = uint16(len(rdata))
+		rr, _, err := dns.UnpackRRWithHeader(hdr, rdata, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMsgJSON, err.Error())
+		}
+		return rr, nil
+	}
+
+	// 2. otherwise use the rdata<TYPE> member in presentation format
+	var rdata string
+	switch entry.TYPE {
+	case dns.TypeA:
+		rdata = entry.RdataA
+	case dns.TypeAAAA:
+		rdata = entry.RdataAAAA
+	case dns.TypeCNAME:
+		rdata = entry.RdataCNAME
+	case dns.TypeDNAME:
+		rdata = entry.RdataDNAME
+	case dns.TypeNS:
+		rdata = entry.RdataNS
+	case dns.TypePTR:
+		rdata = entry.RdataPTR
+	}
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s %s", hdr.Name, hdr.Ttl,
+		dns.Class(hdr.Class).String(), dns.Type(hdr.Rrtype).String(), rdata))
+	if err != nil || rr == nil || rdata == "" {
+		return nil, fmt.Errorf("%w: missing or invalid RDATA for %s", ErrInvalidMsgJSON, hdr.Name)
+	}
+	return rr, nil
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgJSON(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		msg := new(dns.Msg)
+		msg.SetQuestion("www.example.com.", dns.TypeA)
+		msg.Id = 1234
+		msg.Response, msg.RecursionAvailable = true, true
+		msg.Answer = []dns.RR{
+			h.rr("www.example.com. 300 IN CNAME example.com."),
+			h.rr("example.com. 300 IN A 93.184.215.14"),
+			h.rr("example.com. 300 IN TXT \"v=spf1 -all\""),
+		}
+		msg.Ns = []dns.RR{h.rr("example.com. 300 IN NS a.iana-servers.net.")}
+		msg.SetEdns0(1232, true)
+
+		data, err := MarshalMsgJSON(msg)
+		assert.NoError(t, err)
+		got, err := UnmarshalMsgJSON(data)
+		assert.NoError(t, err)
+
+		expectRaw, err := msg.Pack()
+		assert.NoError(t, err)
+		gotRaw, err := got.Pack()
+		assert.NoError(t, err)
+		assert.Equal(t, expectRaw, gotRaw)
+	})
+
+	t.Run("marshalled members", func(t *testing.T) {
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeAAAA)
+		msg.Id = 7
+		msg.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   []byte{192, 0, 2, 1},
+		}}
+		data, err := json.Marshal(MsgJSON{Msg: msg})
+		assert.NoError(t, err)
+
+		var members map[string]any
+		assert.NoError(t, json.Unmarshal(data, &members))
+		assert.Equal(t, 7.0, members["ID"])
+		assert.Equal(t, true, members["RD"])
+		assert.Equal(t, "example.com.", members["QNAME"])
+		assert.Equal(t, "AAAA", members["QTYPEname"])
+		assert.Equal(t, "IN", members["QCLASSname"])
+		assert.Equal(t, 1.0, members["ANCOUNT"])
+		rr := members["answerRRs"].([]any)[0].(map[string]any)
+		assert.Equal(t, "C0000201", rr["RDATAHEX"])
+		assert.Equal(t, 4.0, rr["RDLENGTH"])
+		assert.Equal(t, "192.0.2.1", rr["rdataA"])
+	})
+
+	t.Run("RFC 8427 example with integer flags", func(t *testing.T) {
+		data := []byte(`{ "ID": 19678, "QR": 0, "Opcode": 0, "AA": 0, "TC": 0, "RD": 1,
+			"RA": 0, "AD": 0, "CD": 0, "RCODE": 0, "QDCOUNT": 1, "ANCOUNT": 1,
+			"NSCOUNT": 0, "ARCOUNT": 0, "QNAME": "example.com", "QTYPE": 1, "QCLASS": 1,
+			"answerRRs": [{ "NAME": "example.com.", "TYPE": 1, "CLASS": 1, "TTL": 3600,
+			"RDLENGTH": 4, "rdataA": "192.0.2.100" }] }`)
+		msg, err := UnmarshalMsgJSON(data)
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(19678), msg.Id)
+		assert.True(t, msg.RecursionDesired)
+		assert.Equal(t, "example.com.", msg.Question[0].Name)
+		assert.Equal(t, "192.0.2.100", msg.Answer[0].(*dns.A).A.String())
+	})
+
+	t.Run("null", func(t *testing.T) {
+		data, err := json.Marshal(MsgJSON{})
+		assert.NoError(t, err)
+		assert.Equal(t, "null", string(data))
+		msg, err := UnmarshalMsgJSON(data)
+		assert.NoError(t, err)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, data := range []string{
+			`{"QR": 2}`,
+			`{"answerRRs": [{"NAME": "x.", "TYPE": 1, "CLASS": 1, "RDATAHEX": "ZZ"}]}`,
+			`{"answerRRs": [{"NAME": "x.", "TYPE": 1, "CLASS": 1, "RDATAHEX": "C000"}]}`,
+			`{"answerRRs": [{"NAME": "x.", "TYPE": 1, "CLASS": 1, "RDLENGTH": 4}]}`,
+		} {
+			_, err := UnmarshalMsgJSON([]byte(data))
+			assert.ErrorIs(t, err, ErrInvalidMsgJSON, data)
+		}
+		_, err := UnmarshalMsgJSON([]byte(`{`))
+		assert.Error(t, err)
+	})
+}
+
+func FuzzUnmarshalMsgJSON(f *testing.F) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Answer = []dns.RR{(&iterativeTestHierarchy{}).rr("www.example.com. 300 IN A 198.51.100.1")}
+	data, _ := MarshalMsgJSON(msg)
+	f.Add(data)
+	f.Add([]byte(`{"QNAME":"example.com","answerRRs":[{"NAME":"example.com","TYPE":1,"rdataA":"1.2.3.4"}]}`))
+	f.Add([]byte(`{"QR":1,"answerRRs":[{"NAME":".","TYPE":1,"RDLENGTH":1,"RDATAHEX":"zz"}]}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := UnmarshalMsgJSON(data)
+		if err != nil || msg == nil {
+			return
+		}
+		if _, err := MarshalMsgJSON(msg); err != nil {
+			t.Fatal("cannot marshal a successfully unmarshalled message", err)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrInvalidNAPTRRegexp indicates that the substitution expression
+// of a NAPTR record is not valid according to RFC 3402.
+var ErrInvalidNAPTRRegexp = errors.New("invalid NAPTR substitution expression")
+
+// NAPTRRecord is a decoded NAPTR record (RFC 3403) returned
+// by [*Resolver.LookupNAPTR].
+type NAPTRRecord struct {
+	// Order is the order in which the records must be processed.
+	Order uint16
+
+	// Preference orders the records with the same Order.
+	Preference uint16
+
+	// Flags contains the flags, e.g., "u" for records whose
+	// substitution expression produces a terminal URI.
+	Flags string
+
+	// Service is the service parameters, e.g., "E2U+sip" for ENUM.
+	Service string
+
+	// Regexp is the substitution expression, e.g., "!^.*$!sip:info@example.com!",
+	// without the escaping of the DNS presentation format.
+	Regexp string
+
+	// Replacement is the name to query next when Regexp is empty.
+	Replacement string
+}
+
+// NewNAPTRRecord decodes the given NAPTR RR.
+func NewNAPTRRecord(rr *dns.NAPTR) *NAPTRRecord {
+	return &NAPTRRecord{
+		Order:       rr.Order,
+		Preference:  rr.Preference,
+		Flags:       rr.Flags,
+		Service:     rr.Service,
+		Regexp:      naptrUnescape(rr.Regexp),
+		Replacement: rr.Replacement,
+	}
+}
+
+// naptrUnescape removes the escaping of the DNS presentation format.
+func naptrUnescape(s string) string {
+	var b strings.Builder
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] != '\\' || idx+1 >= len(s) {
+			b.WriteByte(s[idx])
+			continue
+		}
+		idx++
+		if idx+2 < len(s) && isDigit(s[idx]) && isDigit(s[idx+1]) && isDigit(s[idx+2]) {
+			if value, err := strconv.Atoi(s[idx : idx+3]); err == nil && value <= 255 {
+				b.WriteByte(byte(value))
+				idx += 2
+				continue
+			}
+		}
+		b.WriteByte(s[idx])
+	}
+	return b.String()
+}
+
+// isDigit returns whether the given byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Apply applies the substitution expression to the given input, such as
+// the E.164 number of an ENUM query, and returns the result, such as a
+// SIP URI. Following RFC 3402, the expression consists of a delimiter, a
+// POSIX extended regular expression, the delimiter, the replacement, which
+// may reference the groups as "\1" through "\9", the delimiter, and the
+// optional "i" flag for matching regardless of the case.
+func (r *NAPTRRecord) Apply(input string) (string, error) {
+	// 1. split the expression
+	if len(r.Regexp) < 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidNAPTRRegexp, r.Regexp)
+	}
+	delim := r.Regexp[:1]
+	if delim == "\\" || isDigit(delim[0]) || delim == "i" {
+		return "", fmt.Errorf("%w: invalid delimiter: %q", ErrInvalidNAPTRRegexp, r.Regexp)
+	}
+	parts := strings.Split(r.Regexp[1:], delim)
+	if len(parts) != 3 || (parts[2] != "" && parts[2] != "i") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidNAPTRRegexp, r.Regexp)
+	}
+
+	// 2. compile the regular expression
+	expr := parts[0]
+	if parts[2] == "i" {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidNAPTRRegexp, err)
+	}
+	re.Longest() // POSIX leftmost-longest semantics
+
+	// 3. convert the replacement to the [regexp.Regexp.Expand] syntax
+	var template strings.Builder
+	repl := parts[1]
+	for idx := 0; idx < len(repl); idx++ {
+		switch {
+		case repl[idx] == '\\' && idx+1 < len(repl) && isDigit(repl[idx+1]):
+			template.WriteString("${" + repl[idx+1:idx+2] + "}")
+			idx++
+		case repl[idx] == '\\' && idx+1 < len(repl):
+			template.WriteByte(repl[idx+1])
+			idx++
+		case repl[idx] == '$':
+			template.WriteString("$$")
+		default:
+			template.WriteByte(repl[idx])
+		}
+	}
+
+	// 4. apply the substitution
+	match := re.FindStringSubmatchIndex(input)
+	if match == nil {
+		return "", fmt.Errorf("%w: %q does not match %q", ErrInvalidNAPTRRegexp, input, r.Regexp)
+	}
+	return string(re.ExpandString(nil, template.String(), input, match)), nil
+}
+
+// LookupNAPTR resolves the NAPTR records of the given name, returning
+// them sorted by order and then by preference, as RFC 3403 requires.
+func (r *Resolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTRRecord, error) {
+	// 1. obtain the RRs
+	rrs, err := r.lookup(ctx, name, dns.TypeNAPTR)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. decode the records, skipping the CNAMEs
+	var records []*NAPTRRecord
+	for _, rr := range rrs {
+		if naptr, ok := rr.(*dns.NAPTR); ok {
+			records = append(records, NewNAPTRRecord(naptr))
+		}
+	}
+	if len(records) <= 0 {
+		return nil, ErrNoData
+	}
+
+	// 3. sort the records
+	slices.SortStableFunc(records, func(a, b *NAPTRRecord) int {
+		return cmp.Or(cmp.Compare(a.Order, b.Order), cmp.Compare(a.Preference, b.Preference))
+	})
+	return records, nil
+}
+
+// ENUMName returns the name owning the ENUM NAPTR records of the given
+// E.164 number (RFC 6116), ignoring the characters other than digits,
+// e.g., "4.3.2.1.5.5.5.1.e164.arpa." for "+1-555-1234".
+func ENUMName(number string) (string, error) {
+	var digits []string
+	for idx := len(number) - 1; idx >= 0; idx-- {
+		if isDigit(number[idx]) {
+			digits = append(digits, number[idx:idx+1])
+		}
+	}
+	if len(digits) <= 0 {
+		return "", fmt.Errorf("invalid E.164 number: %q", number)
+	}
+	return strings.Join(digits, ".") + ".e164.arpa.", nil
+}
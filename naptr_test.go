@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNAPTRRecord_Apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		rr       string
+		input    string
+		expected string
+		err      error
+	}{{
+		name:     "constant URI",
+		rr:       `4.3.2.1.e164.arpa. 300 IN NAPTR 100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`,
+		input:    "+1234",
+		expected: "sip:info@example.com",
+	}, {
+		name:     "back references",
+		rr:       `4.3.2.1.e164.arpa. 300 IN NAPTR 100 10 "u" "E2U+sip" "!^\\+1(.*)$!sip:\\1@example.com!" .`,
+		input:    "+1234",
+		expected: "sip:234@example.com",
+	}, {
+		name:     "case insensitive matching and dollar signs",
+		rr:       `example.com. 300 IN NAPTR 100 10 "u" "x" "/^FOO(.*)$/x$\\1/i" .`,
+		input:    "foobar",
+		expected: "x$bar",
+	}, {
+		name:  "not matching",
+		rr:    `example.com. 300 IN NAPTR 100 10 "u" "x" "!^\\+44(.*)$!sip:\\1@example.com!" .`,
+		input: "+1234",
+		err:   ErrInvalidNAPTRRegexp,
+	}, {
+		name:  "invalid flags",
+		rr:    `example.com. 300 IN NAPTR 100 10 "u" "x" "!^.*$!sip:info@example.com!x" .`,
+		input: "+1234",
+		err:   ErrInvalidNAPTRRegexp,
+	}, {
+		name:  "invalid delimiter",
+		rr:    `example.com. 300 IN NAPTR 100 10 "u" "x" "1^.*$1sip:info@example.com1" .`,
+		input: "+1234",
+		err:   ErrInvalidNAPTRRegexp,
+	}, {
+		name:  "invalid regular expression",
+		rr:    `example.com. 300 IN NAPTR 100 10 "u" "x" "!^(.*$!x!" .`,
+		input: "+1234",
+		err:   ErrInvalidNAPTRRegexp,
+	}, {
+		name:  "empty expression",
+		rr:    `example.com. 300 IN NAPTR 100 10 "s" "SIP+D2U" "" _sip._udp.example.com.`,
+		input: "+1234",
+		err:   ErrInvalidNAPTRRegexp,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, err := dns.NewRR(tt.rr)
+			assert.NoError(t, err)
+			result, err := NewNAPTRRecord(rr.(*dns.NAPTR)).Apply(tt.input)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestENUMName(t *testing.T) {
+	tests := []struct {
+		number   string
+		expected string
+		wantErr  bool
+	}{
+		{"+1-555-1234", "4.3.2.1.5.5.5.1.e164.arpa.", false},
+		{"+44 20 7946 0000", "0.0.0.0.6.4.9.7.0.2.4.4.e164.arpa.", false},
+		{"+", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			name, err := ENUMName(tt.number)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.expected, name)
+		})
+	}
+}
+
+func TestResolver_LookupNAPTR(t *testing.T) {
+	reso := &Resolver{
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				q0 := query.Question[0]
+				assert.Equal(t, dns.TypeNAPTR, q0.Qtype)
+				resp := new(dns.Msg)
+				resp.SetReply(query)
+				resp.RecursionAvailable = true
+				if q0.Name == "4.3.2.1.e164.arpa." {
+					for _, s := range []string{
+						`4.3.2.1.e164.arpa. 300 IN NAPTR 200 10 "u" "E2U+mailto" "!^.*$!mailto:info@example.com!" .`,
+						`4.3.2.1.e164.arpa. 300 IN NAPTR 100 20 "u" "E2U+tel" "!^.*$!tel:+1234!" .`,
+						`4.3.2.1.e164.arpa. 300 IN NAPTR 100 10 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`,
+					} {
+						rr, err := dns.NewRR(s)
+						assert.NoError(t, err)
+						resp.Answer = append(resp.Answer, rr)
+					}
+				}
+				return resp, nil
+			},
+		},
+	}
+
+	t.Run("records in order and preference", func(t *testing.T) {
+		name, err := ENUMName("+1234")
+		assert.NoError(t, err)
+		records, err := reso.LookupNAPTR(context.Background(), name)
+		assert.NoError(t, err)
+		var services []string
+		for _, record := range records {
+			services = append(services, record.Service)
+		}
+		assert.Equal(t, []string{"E2U+sip", "E2U+tel", "E2U+mailto"}, services)
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		_, err := reso.LookupNAPTR(context.Background(), "example.com")
+		assert.ErrorIs(t, err, ErrNoData)
+	})
+}
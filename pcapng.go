@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// pcapngLinkTypeRaw is the link type of raw IPv4 and IPv6 packets.
+const pcapngLinkTypeRaw = 101
+
+// pcapngMaxSeqs is the maximum number of TCP directions whose
+// sequence numbers a [*PcapngWriter] keeps track of.
+const pcapngMaxSeqs = 4096
+
+// PcapngWriter writes the DNS messages exchanged by a [*Transport] to a
+// pcapng file, which tools such as Wireshark can open, to debug at the
+// protocol level. Set the Capture field of [*Transport] to enable capturing.
+//
+// Since we capture the DNS messages rather than the packets, we synthesize
+// the IP, UDP, and TCP headers using the addresses of the connections. We
+// write the messages exchanged using TCP, DNS over TLS, and DNS over HTTPS as
+// DNS over TCP segments using the server port, hence the messages of encrypted
+// protocols appear decrypted. Use the "Decode As" feature of Wireshark when
+// the server port is not 53. When the addresses are unknown, we use the
+// unspecified address and a zero port.
+//
+// We write each query when we send it and each message when we receive it,
+// including the ones we discard, e.g., the UDP datagrams that do not match
+// the query, such that the capture also shows failed and timed out queries.
+//
+// Construct using [NewPcapngWriter].
+type PcapngWriter struct {
+	// err is the first error that occurred writing.
+	err error
+
+	// mu provides mutual exclusion.
+	mu sync.Mutex
+
+	// seqs contains the next TCP sequence number of each direction, which
+	// we delete when the connection is closed. Since we do not see closing
+	// the connections managed by the HTTP client, we forget all directions
+	// once we track pcapngMaxSeqs of them, which restarts their sequence
+	// numbers from one, rather than growing without bound.
+	seqs map[[2]netip.AddrPort]uint32
+
+	// w is the underlying writer.
+	w io.Writer
+}
+
+// NewPcapngWriter creates a new [*PcapngWriter] writing to w, where we
+// immediately write the section header and interface description blocks.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	// 1. create the section header block (pcapng Sect. 4.1)
+	shb := binary.LittleEndian.AppendUint32(nil, 0x0A0D0D0A)
+	shb = binary.LittleEndian.AppendUint32(shb, 28)
+	shb = binary.LittleEndian.AppendUint32(shb, 0x1A2B3C4D)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, 0xFFFFFFFFFFFFFFFF)
+	shb = binary.LittleEndian.AppendUint32(shb, 28)
+
+	// 2. create the interface description block (pcapng Sect. 4.2),
+	// using the default microseconds timestamp resolution
+	idb := binary.LittleEndian.AppendUint32(nil, 1)
+	idb = binary.LittleEndian.AppendUint32(idb, 20)
+	idb = binary.LittleEndian.AppendUint16(idb, pcapngLinkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, 20)
+
+	// 3. write the blocks
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+	return &PcapngWriter{seqs: make(map[[2]netip.AddrPort]uint32), w: w}, nil
+}
+
+// Err returns the first error that occurred writing messages, if any.
+// Failing to write does not cause queries to fail, since we do not want
+// capturing to break the code using the [*Transport].
+func (pw *PcapngWriter) Err() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+// WriteMessage writes a DNS message sent from src to dst at the given
+// time using the given network, which is either "udp" or "tcp".
+func (pw *PcapngWriter) WriteMessage(t time.Time, network string, src, dst netip.AddrPort, rawMsg []byte) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.err != nil {
+		return
+	}
+
+	// 1. make sure both addresses are valid and belong to the same family
+	src, dst = pcapngAddrPorts(src, dst)
+
+	// 2. create the transport segment
+	var (
+		proto   uint8
+		segment []byte
+	)
+	switch network {
+	case "tcp":
+		proto = 6
+		seq := pw.nextSeq(src, dst, 2+len(rawMsg))
+		ack := pw.nextSeq(dst, src, 0)
+		segment = binary.BigEndian.AppendUint16(nil, src.Port())
+		segment = binary.BigEndian.AppendUint16(segment, dst.Port())
+		segment = binary.BigEndian.AppendUint32(segment, seq)
+		segment = binary.BigEndian.AppendUint32(segment, ack)
+		segment = append(segment, 5<<4, 0x18) // data offset and PSH|ACK
+		segment = binary.BigEndian.AppendUint16(segment, 65535)
+		segment = append(segment, 0, 0, 0, 0) // checksum and urgent pointer
+		segment = binary.BigEndian.AppendUint16(segment, uint16(len(rawMsg)))
+		segment = append(segment, rawMsg...)
+		binary.BigEndian.PutUint16(segment[16:], pcapngChecksum(src.Addr(), dst.Addr(), proto, segment))
+	default:
+		proto = 17
+		segment = binary.BigEndian.AppendUint16(nil, src.Port())
+		segment = binary.BigEndian.AppendUint16(segment, dst.Port())
+		segment = binary.BigEndian.AppendUint16(segment, uint16(8+len(rawMsg)))
+		segment = append(segment, 0, 0) // checksum
+		segment = append(segment, rawMsg...)
+		binary.BigEndian.PutUint16(segment[6:], pcapngChecksum(src.Addr(), dst.Addr(), proto, segment))
+	}
+
+	// 3. create the IP packet
+	var packet []byte
+	if src.Addr().Is4() {
+		packet = append(packet, 0x45, 0)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(20+len(segment)))
+		packet = append(packet, 0, 0, 0x40, 0, 64, proto, 0, 0) // ID, DF, TTL, protocol, checksum
+		packet = append(packet, src.Addr().AsSlice()...)
+		packet = append(packet, dst.Addr().AsSlice()...)
+		binary.BigEndian.PutUint16(packet[10:], ^pcapngSum(0, packet))
+	} else {
+		packet = append(packet, 0x60, 0, 0, 0)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(segment)))
+		packet = append(packet, proto, 64)
+		packet = append(packet, src.Addr().AsSlice()...)
+		packet = append(packet, dst.Addr().AsSlice()...)
+	}
+	packet = append(packet, segment...)
+
+	// 4. write the enhanced packet block (pcapng Sect. 4.3)
+	padded := (len(packet) + 3) &^ 3
+	micros := uint64(t.UnixMicro())
+	epb := binary.LittleEndian.AppendUint32(nil, 6)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(32+padded))
+	epb = binary.LittleEndian.AppendUint32(epb, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(micros>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(micros))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+	epb = append(epb, packet...)
+	epb = append(epb, make([]byte, padded-len(packet))...)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(32+padded))
+	if _, err := pw.w.Write(epb); err != nil {
+		pw.err = err
+	}
+}
+
+// pcapngAddrPorts returns the given addresses replacing the invalid ones
+// with the unspecified address and using the same family for both.
+func pcapngAddrPorts(src, dst netip.AddrPort) (netip.AddrPort, netip.AddrPort) {
+	if !src.IsValid() {
+		src = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
+	if !dst.IsValid() {
+		dst = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
+	src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if src.Addr().Is4() != dst.Addr().Is4() {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+	return src, dst
+}
+
+// endFlow forgets the TCP sequence numbers of both directions of the
+// connection between the given addresses, which has been closed.
+func (pw *PcapngWriter) endFlow(laddr, raddr netip.AddrPort) {
+	laddr, raddr = pcapngAddrPorts(laddr, raddr)
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	delete(pw.seqs, [2]netip.AddrPort{laddr, raddr})
+	delete(pw.seqs, [2]netip.AddrPort{raddr, laddr})
+}
+
+// nextSeq returns the next TCP sequence number from src to dst and
+// advances it by size, starting from one for each direction.
+func (pw *PcapngWriter) nextSeq(src, dst netip.AddrPort, size int) uint32 {
+	key := [2]netip.AddrPort{src, dst}
+	seq, found := pw.seqs[key]
+	if !found {
+		seq = 1
+		if len(pw.seqs) >= pcapngMaxSeqs {
+			clear(pw.seqs)
+		}
+	}
+	pw.seqs[key] = seq + uint32(size)
+	return seq
+}
+
+// maybeCapture writes the message sent from src to dst using the given
+// server address to the Capture field, if set, using the current time.
+func (t *Transport) maybeCapture(addr *ServerAddr, src, dst netip.AddrPort, rawMsg []byte) {
+	if t.Capture != nil {
+		t.Capture.WriteMessage(t.timeNow(), protocolMap[addr.Protocol], src, dst, rawMsg)
+	}
+}
+
+// maybeCaptureSent is like maybeCapture for a message we sent using conn.
+func (t *Transport) maybeCaptureSent(addr *ServerAddr, conn net.Conn, rawMsg []byte) {
+	if t.Capture != nil {
+		t.maybeCapture(addr, addrToAddrPort(conn.LocalAddr()), addrToAddrPort(conn.RemoteAddr()), rawMsg)
+	}
+}
+
+// maybeCaptureReceived is like maybeCapture for a message we received using conn.
+func (t *Transport) maybeCaptureReceived(addr *ServerAddr, conn net.Conn, rawMsg []byte) {
+	if t.Capture != nil {
+		t.maybeCapture(addr, addrToAddrPort(conn.RemoteAddr()), addrToAddrPort(conn.LocalAddr()), rawMsg)
+	}
+}
+
+// pcapngChecksum returns the UDP or TCP checksum of the given segment.
+func pcapngChecksum(src, dst netip.Addr, proto uint8, segment []byte) uint16 {
+	pseudo := append(src.AsSlice(), dst.AsSlice()...)
+	pseudo = append(pseudo, 0, proto)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	sum := ^pcapngSum(pcapngSum(0, pseudo), segment)
+	if sum == 0 && proto == 17 {
+		return 0xFFFF
+	}
+	return sum
+}
+
+// pcapngSum computes the ones' complement sum of the given data, as
+// defined by RFC 1071, starting from the given partial sum.
+func pcapngSum(initial uint16, data []byte) uint16 {
+	sum := uint32(initial)
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) > 0 {
+		sum += uint32(data[0]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return uint16(sum)
+}
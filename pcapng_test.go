@@ -0,0 +1,277 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// pcapngTestPacket is a packet read from a pcapng file.
+type pcapngTestPacket struct {
+	t    time.Time
+	data []byte
+}
+
+// readPcapngTestPackets parses the blocks written by [*PcapngWriter].
+func readPcapngTestPackets(t *testing.T, data []byte) []pcapngTestPacket {
+	// 1. check the section header and interface description blocks
+	assert.Equal(t, []byte{
+		0x0A, 0x0D, 0x0D, 0x0A, 28, 0, 0, 0, 0x4D, 0x3C, 0x2B, 0x1A, 1, 0, 0, 0,
+		0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 28, 0, 0, 0,
+		1, 0, 0, 0, 20, 0, 0, 0, 101, 0, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0,
+	}, data[:48])
+	data = data[48:]
+
+	// 2. parse the enhanced packet blocks
+	var packets []pcapngTestPacket
+	for len(data) > 0 {
+		size := binary.LittleEndian.Uint32(data[4:])
+		assert.Equal(t, uint32(6), binary.LittleEndian.Uint32(data))
+		assert.Equal(t, size, binary.LittleEndian.Uint32(data[size-4:]))
+		micros := uint64(binary.LittleEndian.Uint32(data[12:]))<<32 | uint64(binary.LittleEndian.Uint32(data[16:]))
+		length := binary.LittleEndian.Uint32(data[20:])
+		packets = append(packets, pcapngTestPacket{
+			t:    time.UnixMicro(int64(micros)).UTC(),
+			data: data[28 : 28+length],
+		})
+		data = data[size:]
+	}
+	return packets
+}
+
+// checkPcapngTestChecksum makes sure the checksum of the given segment is valid.
+func checkPcapngTestChecksum(t *testing.T, src, dst netip.Addr, proto uint8, segment []byte) {
+	pseudo := append(src.AsSlice(), dst.AsSlice()...)
+	pseudo = append(pseudo, 0, proto)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	assert.Equal(t, uint16(0xFFFF), pcapngSum(pcapngSum(0, pseudo), segment))
+}
+
+func TestPcapngWriter(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 1000, time.UTC)
+	client4 := netip.MustParseAddrPort("10.0.0.1:54321")
+	server4 := netip.MustParseAddrPort("8.8.8.8:53")
+	client6 := netip.MustParseAddrPort("[2001:db8::1]:54321")
+	server6 := netip.MustParseAddrPort("[2001:4860:4860::8888]:853")
+	msg := []byte{0xAB, 0xCD, 1, 0, 0, 1}
+
+	var buf bytes.Buffer
+	pw, err := NewPcapngWriter(&buf)
+	assert.NoError(t, err)
+	pw.WriteMessage(t0, "udp", client4, server4, msg)
+	pw.WriteMessage(t0, "tcp", client6, server6, msg)
+	pw.WriteMessage(t0, "tcp", server6, client6, msg[:5])
+	pw.WriteMessage(t0, "tcp", client6, server6, msg)
+	pw.WriteMessage(t0, "udp", netip.AddrPortFrom(netip.IPv6Unspecified(), 0), server4, msg)
+	assert.NoError(t, pw.Err())
+
+	packets := readPcapngTestPackets(t, buf.Bytes())
+	assert.Len(t, packets, 5)
+
+	t.Run("IPv4 UDP", func(t *testing.T) {
+		packet := packets[0].data
+		assert.Equal(t, t0, packets[0].t)
+		assert.Equal(t, byte(0x45), packet[0])
+		assert.Equal(t, uint16(20+8+len(msg)), binary.BigEndian.Uint16(packet[2:]))
+		assert.Equal(t, byte(17), packet[9])
+		assert.Equal(t, uint16(0xFFFF), pcapngSum(0, packet[:20]))
+		assert.Equal(t, client4.Addr().AsSlice(), packet[12:16])
+		assert.Equal(t, server4.Addr().AsSlice(), packet[16:20])
+		assert.Equal(t, uint16(54321), binary.BigEndian.Uint16(packet[20:]))
+		assert.Equal(t, uint16(53), binary.BigEndian.Uint16(packet[22:]))
+		assert.Equal(t, msg, packet[28:])
+		checkPcapngTestChecksum(t, client4.Addr(), server4.Addr(), 17, packet[20:])
+	})
+
+	t.Run("IPv6 TCP", func(t *testing.T) {
+		var seqs, acks []uint32
+		for _, p := range packets[1:4] {
+			packet := p.data
+			assert.Equal(t, byte(0x60), packet[0])
+			assert.Equal(t, byte(6), packet[6])
+			segment := packet[40:]
+			assert.Equal(t, uint16(len(segment)), binary.BigEndian.Uint16(packet[4:]))
+			checkPcapngTestChecksum(t, netip.AddrFrom16([16]byte(packet[8:24])),
+				netip.AddrFrom16([16]byte(packet[24:40])), 6, segment)
+			seqs = append(seqs, binary.BigEndian.Uint32(segment[4:]))
+			acks = append(acks, binary.BigEndian.Uint32(segment[8:]))
+			assert.Equal(t, uint16(len(segment)-22), binary.BigEndian.Uint16(segment[20:]))
+		}
+		assert.Equal(t, []uint32{1, 1, 9}, seqs)
+		assert.Equal(t, []uint32{1, 9, 8}, acks)
+		assert.Equal(t, msg, packets[1].data[62:])
+	})
+
+	t.Run("mixed families", func(t *testing.T) {
+		packet := packets[4].data
+		assert.Equal(t, byte(0x60), packet[0])
+		assert.Equal(t, netip.IPv6Unspecified().AsSlice(), packet[8:24])
+		assert.Equal(t, netip.AddrFrom16(server4.Addr().As16()).AsSlice(), packet[24:40])
+	})
+}
+
+func TestPcapngWriter_writeError(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+
+	t.Run("header", func(t *testing.T) {
+		w := &mocks.Conn{MockWrite: func(b []byte) (int, error) { return 0, expectedErr }}
+		pw, err := NewPcapngWriter(w)
+		assert.ErrorIs(t, err, expectedErr)
+		assert.Nil(t, pw)
+	})
+
+	t.Run("message", func(t *testing.T) {
+		var writes int
+		w := &mocks.Conn{MockWrite: func(b []byte) (int, error) {
+			writes++
+			if writes > 1 {
+				return 0, expectedErr
+			}
+			return len(b), nil
+		}}
+		pw, err := NewPcapngWriter(w)
+		assert.NoError(t, err)
+		addr := netip.MustParseAddrPort("8.8.8.8:53")
+		pw.WriteMessage(time.Now(), "udp", addr, addr, []byte{0})
+		pw.WriteMessage(time.Now(), "udp", addr, addr, []byte{0})
+		assert.ErrorIs(t, pw.Err(), expectedErr)
+		assert.Equal(t, 2, writes)
+	})
+}
+
+func TestPcapngWriter_endFlow(t *testing.T) {
+	client := netip.MustParseAddrPort("10.0.0.1:54321")
+	server := netip.MustParseAddrPort("8.8.8.8:53")
+	pw, err := NewPcapngWriter(&bytes.Buffer{})
+	assert.NoError(t, err)
+
+	// make sure we forget both directions of a closed flow
+	pw.WriteMessage(time.Now(), "tcp", client, server, []byte{0})
+	pw.WriteMessage(time.Now(), "tcp", server, client, []byte{0})
+	assert.Len(t, pw.seqs, 2)
+	pw.endFlow(netip.AddrPortFrom(netip.AddrFrom16(client.Addr().As16()), client.Port()), server)
+	assert.Empty(t, pw.seqs)
+
+	// make sure we do not track more than pcapngMaxSeqs directions
+	for port := 1; port <= pcapngMaxSeqs; port++ {
+		pw.WriteMessage(time.Now(), "tcp", netip.AddrPortFrom(client.Addr(), uint16(port)), server, []byte{0})
+		assert.LessOrEqual(t, len(pw.seqs), pcapngMaxSeqs)
+	}
+}
+
+func TestTransport_Capture(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// datagrams returns the datagrams the server sends.
+		datagrams func(rawQuery []byte) [][]byte
+
+		// expectErr is the expected query error.
+		expectErr error
+
+		// expectPackets is the expected number of captured packets.
+		expectPackets int
+	}{{
+		name: "successful query",
+		datagrams: func(rawQuery []byte) [][]byte {
+			return [][]byte{newRawResponse(rawQuery, dns.RcodeSuccess)}
+		},
+		expectPackets: 2,
+	}, {
+		name: "discarded datagrams",
+		datagrams: func(rawQuery []byte) [][]byte {
+			return [][]byte{{0xde, 0xad}, newRawResponse(rawQuery, dns.RcodeSuccess)}
+		},
+		expectPackets: 3,
+	}, {
+		name: "timed out query",
+		datagrams: func(rawQuery []byte) [][]byte {
+			return [][]byte{{0xde, 0xad}}
+		},
+		expectErr:     context.DeadlineExceeded,
+		expectPackets: 2,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1. create a transport capturing the messages
+			var (
+				buf       bytes.Buffer
+				datagrams [][]byte
+				rawQuery  []byte
+			)
+			pw, err := NewPcapngWriter(&buf)
+			assert.NoError(t, err)
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			txp := &Transport{
+				Capture: pw,
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					return &mocks.Conn{
+						MockSetDeadline: func(time.Time) error {
+							return nil
+						},
+						MockWrite: func(b []byte) (int, error) {
+							rawQuery = append([]byte{}, b...)
+							datagrams = tt.datagrams(rawQuery)
+							return len(b), nil
+						},
+						MockRead: func(b []byte) (int, error) {
+							if len(datagrams) <= 0 {
+								<-ctx.Done()
+								return 0, ctx.Err()
+							}
+							datagram := datagrams[0]
+							datagrams = datagrams[1:]
+							return copy(b, datagram), nil
+						},
+						MockClose: func() error {
+							return nil
+						},
+						MockLocalAddr: func() net.Addr {
+							return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}
+						},
+						MockRemoteAddr: func() net.Addr {
+							return &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}
+						},
+					}, nil
+				},
+				TimeNow: func() time.Time {
+					now = now.Add(time.Millisecond)
+					return now
+				},
+			}
+
+			// 2. send the query
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			query := new(dns.Msg)
+			query.SetQuestion("example.com.", dns.TypeA)
+			_, err = txp.Query(ctx, NewServerAddr(ProtocolUDP, "8.8.8.8:53"), query)
+			assert.ErrorIs(t, err, tt.expectErr)
+
+			// 3. make sure we captured the query and all the datagrams
+			packets := readPcapngTestPackets(t, buf.Bytes())
+			if assert.Len(t, packets, tt.expectPackets) {
+				assert.Equal(t, rawQuery, packets[0].data[28:])
+				assert.Equal(t, []byte{10, 0, 0, 1}, packets[0].data[12:16])
+				received := tt.datagrams(rawQuery)
+				for idx, packet := range packets[1:] {
+					assert.Equal(t, received[idx], packet.data[28:])
+					assert.Equal(t, []byte{8, 8, 8, 8}, packet.data[12:16])
+					assert.True(t, packet.t.After(packets[idx].t))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Default values used by [*Pinger].
+const (
+	// DefaultPingCount is the default number of queries to send.
+	DefaultPingCount = 5
+
+	// DefaultPingInterval is the default interval between queries.
+	DefaultPingInterval = time.Second
+
+	// DefaultPingTimeout is the default timeout for each query.
+	DefaultPingTimeout = 2 * time.Second
+)
+
+// Pinger measures the latency and the loss of a DNS server by
+// sending periodic queries, like ping does for ICMP.
+//
+// The zero value is ready to use.
+type Pinger struct {
+	// Count is the number of queries to send.
+	//
+	// If zero, we use [DefaultPingCount].
+	Count int
+
+	// Interval is the interval between the start of two queries.
+	//
+	// If zero, we use [DefaultPingInterval].
+	Interval time.Duration
+
+	// Name is the name to query.
+	//
+	// If empty, we query for the root zone.
+	Name string
+
+	// QueryOptions contains optional options for constructing queries.
+	QueryOptions []QueryOption
+
+	// Timeout is the timeout for each query.
+	//
+	// If zero, we use [DefaultPingTimeout].
+	Timeout time.Duration
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the optional DNS transport to use.
+	//
+	// If nil, we use [DefaultTransport].
+	Transport ResolverTransport
+
+	// Type is the query type to use.
+	//
+	// If zero, we use [dns.TypeNS].
+	Type uint16
+}
+
+// PingReply is the result of a single query sent by [*Pinger].
+type PingReply struct {
+	// Seq is the zero-based sequence number of the query.
+	Seq int
+
+	// RTT is the time elapsed until we received the response or failed.
+	RTT time.Duration
+
+	// Rcode is the response code, meaningful only when Err is nil.
+	Rcode int
+
+	// Err is the error that occurred, if any.
+	Err error
+}
+
+// PingStats summarizes the replies collected by [*Pinger].
+type PingStats struct {
+	// Addr is the server address.
+	Addr *ServerAddr
+
+	// Replies contains the replies in the order in which we sent queries.
+	Replies []PingReply
+
+	// Sent is the number of queries sent.
+	Sent int
+
+	// Received is the number of valid responses received.
+	Received int
+
+	// Min, Avg, P95, and Max are the RTT statistics of the
+	// valid responses, or zero if we have not received any.
+	Min, Avg, P95, Max time.Duration
+
+	// Rcodes maps each RCODE to the number of responses containing it.
+	Rcodes map[int]int
+}
+
+// Loss returns the fraction of queries without a valid response
+// in the [0, 1] range. It returns zero when no query was sent.
+func (s *PingStats) Loss() float64 {
+	if s.Sent <= 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+// String returns a ping-like summary of the statistics.
+func (s *PingStats) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "--- %s/%s dns ping statistics ---\n", s.Addr.Address, s.Addr.Protocol)
+	fmt.Fprintf(&builder, "%d queries sent, %d responses received, %.1f%% loss\n",
+		s.Sent, s.Received, 100*s.Loss())
+	if s.Received > 0 {
+		fmt.Fprintf(&builder, "rtt min/avg/p95/max = %s/%s/%s/%s\n", s.Min, s.Avg, s.P95, s.Max)
+	}
+	rcodes := make([]int, 0, len(s.Rcodes))
+	for rcode := range s.Rcodes {
+		rcodes = append(rcodes, rcode)
+	}
+	slices.Sort(rcodes)
+	for idx, rcode := range rcodes {
+		if idx > 0 {
+			builder.WriteString(" ")
+		} else {
+			builder.WriteString("rcodes: ")
+		}
+		fmt.Fprintf(&builder, "%s=%d", dns.RcodeToString[rcode], s.Rcodes[rcode])
+	}
+	if len(rcodes) > 0 {
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// transport returns the transport to use.
+func (p *Pinger) transport() ResolverTransport {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return DefaultTransport
+}
+
+// timeNow returns the current time.
+func (p *Pinger) timeNow() time.Time {
+	if p.TimeNow != nil {
+		return p.TimeNow()
+	}
+	return time.Now()
+}
+
+// count returns the number of queries to send.
+func (p *Pinger) count() int {
+	if p.Count > 0 {
+		return p.Count
+	}
+	return DefaultPingCount
+}
+
+// interval returns the interval between queries.
+func (p *Pinger) interval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+	return DefaultPingInterval
+}
+
+// timeout returns the timeout for each query.
+func (p *Pinger) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultPingTimeout
+}
+
+// Ping sends the configured number of queries to the given server and
+// returns the statistics. When the context is done, we stop sending
+// queries and return the statistics collected so far along with the
+// context error. The onReply function, if not nil, is called after
+// each query, which allows printing the replies as they arrive.
+func (p *Pinger) Ping(ctx context.Context, addr *ServerAddr, onReply func(PingReply)) (*PingStats, error) {
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+	stats := &PingStats{Addr: addr, Rcodes: make(map[int]int)}
+	for seq := 0; seq < p.count(); seq++ {
+		// 1. wait for the next tick except for the first query
+		if seq > 0 {
+			select {
+			case <-ctx.Done():
+				return stats.finish(), ctx.Err()
+			case <-ticker.C:
+			}
+		}
+
+		// 2. send the query and record the reply
+		reply := p.pingOnce(ctx, addr, seq)
+		stats.Replies = append(stats.Replies, reply)
+		stats.Sent++
+		if reply.Err == nil {
+			stats.Received++
+			stats.Rcodes[reply.Rcode]++
+		}
+		if onReply != nil {
+			onReply(reply)
+		}
+	}
+	return stats.finish(), nil
+}
+
+// pingOnce sends a single query and returns the reply.
+func (p *Pinger) pingOnce(ctx context.Context, addr *ServerAddr, seq int) PingReply {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
+	defer cancel()
+
+	name, qtype := p.Name, p.Type
+	if name == "" {
+		name = "."
+	}
+	if qtype == 0 {
+		qtype = dns.TypeNS
+	}
+	reply := PingReply{Seq: seq}
+	query, err := NewQueryWithServerAddr(addr, name, qtype, p.QueryOptions...)
+	if err != nil {
+		reply.Err = err
+		return reply
+	}
+
+	t0 := p.timeNow()
+	resp, err := p.transport().Query(ctx, addr, query)
+	reply.RTT = p.timeNow().Sub(t0)
+	if err == nil {
+		err = ValidateResponse(query, resp)
+	}
+	if err != nil {
+		reply.Err = err
+		return reply
+	}
+	reply.Rcode = resp.Rcode
+	return reply
+}
+
+// finish computes the RTT statistics and returns the stats.
+func (s *PingStats) finish() *PingStats {
+	var rtts []time.Duration
+	for _, reply := range s.Replies {
+		if reply.Err == nil {
+			rtts = append(rtts, reply.RTT)
+		}
+	}
+	if len(rtts) <= 0 {
+		return s
+	}
+	slices.Sort(rtts)
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	s.Min, s.Max = rtts[0], rtts[len(rtts)-1]
+	s.Avg = sum / time.Duration(len(rtts))
+
+	// use the nearest-rank method for the 95th percentile
+	rank := (95*len(rtts) + 99) / 100
+	s.P95 = rtts[rank-1]
+	return s
+}
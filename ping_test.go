@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinger_Ping(t *testing.T) {
+	t.Run("statistics", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		var seq int
+		pinger := &Pinger{
+			Count:    4,
+			Interval: time.Millisecond,
+			TimeNow:  func() time.Time { return now },
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					seq++
+					now = now.Add(time.Duration(seq) * 10 * time.Millisecond)
+					assert.Equal(t, "example.com.", query.Question[0].Name)
+					assert.Equal(t, dns.TypeA, query.Question[0].Qtype)
+					resp := &dns.Msg{}
+					switch seq {
+					case 2:
+						return nil, errors.New("mocked error")
+					case 3:
+						resp.SetRcode(query, dns.RcodeNameError)
+					default:
+						resp.SetReply(query)
+					}
+					return resp, nil
+				},
+			},
+			Name: "example.com",
+			Type: dns.TypeA,
+		}
+
+		var replies []PingReply
+		addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+		stats, err := pinger.Ping(context.Background(), addr, func(reply PingReply) {
+			replies = append(replies, reply)
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, stats.Replies, replies)
+		assert.Equal(t, 4, stats.Sent)
+		assert.Equal(t, 3, stats.Received)
+		assert.Equal(t, 0.25, stats.Loss())
+		assert.Equal(t, 10*time.Millisecond, stats.Min)
+		assert.Equal(t, 26666666*time.Nanosecond, stats.Avg)
+		assert.Equal(t, 40*time.Millisecond, stats.P95)
+		assert.Equal(t, 40*time.Millisecond, stats.Max)
+		assert.Equal(t, map[int]int{dns.RcodeSuccess: 2, dns.RcodeNameError: 1}, stats.Rcodes)
+		assert.EqualError(t, stats.Replies[1].Err, "mocked error")
+		assert.Equal(t, "--- 8.8.8.8:53/udp dns ping statistics ---\n"+
+			"4 queries sent, 3 responses received, 25.0% loss\n"+
+			"rtt min/avg/p95/max = 10ms/26.666666ms/40ms/40ms\n"+
+			"rcodes: NOERROR=2 NXDOMAIN=1\n", stats.String())
+	})
+
+	t.Run("invalid responses count as lost", func(t *testing.T) {
+		pinger := &Pinger{
+			Count: 1,
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					return &dns.Msg{}, nil
+				},
+			},
+		}
+		stats, err := pinger.Ping(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1.0, stats.Loss())
+		assert.ErrorIs(t, stats.Replies[0].Err, ErrInvalidResponse)
+		assert.Equal(t, "--- 8.8.8.8:53/udp dns ping statistics ---\n"+
+			"1 queries sent, 0 responses received, 100.0% loss\n", stats.String())
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		pinger := &Pinger{
+			Count: 10,
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					cancel()
+					resp := &dns.Msg{}
+					resp.SetReply(query)
+					return resp, nil
+				},
+			},
+		}
+		stats, err := pinger.Ping(ctx, NewServerAddr(ProtocolUDP, "8.8.8.8:53"), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, stats.Sent)
+		assert.Equal(t, 1, stats.Received)
+	})
+}
+
+func TestPinger_defaults(t *testing.T) {
+	pinger := &Pinger{}
+	assert.Equal(t, DefaultPingCount, pinger.count())
+	assert.Equal(t, DefaultPingInterval, pinger.interval())
+	assert.Equal(t, DefaultPingTimeout, pinger.timeout())
+	assert.Equal(t, DefaultTransport, pinger.transport())
+	assert.False(t, pinger.timeNow().IsZero())
+	assert.Equal(t, 0.0, (&PingStats{}).Loss())
+}
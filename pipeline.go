@@ -0,0 +1,429 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrTooManyPipelinedQueries is returned when all the message IDs of
+// a pipelined TCP or TLS connection are used by outstanding queries.
+var ErrTooManyPipelinedQueries = errors.New("too many pipelined queries")
+
+// pipelineMaxTimeouts is the number of consecutive queries timing out over
+// a pipelined connection after which we assume the server stopped answering
+// and drain the connection, such that new queries use a new connection.
+const pipelineMaxTimeouts = 3
+
+// pipelineMaxIDAttempts is the number of new message IDs we try when the
+// ID of a pipelined query is already used by another outstanding query.
+const pipelineMaxIDAttempts = 16
+
+// pipelinedQuery is a query waiting for its response.
+type pipelinedQuery struct {
+	// query is the query as sent on the wire.
+	query *dns.Msg
+
+	// ch receives the response or the error.
+	ch chan pipelinedResult
+}
+
+// pipelinedResult is the result of a [*pipelinedQuery].
+type pipelinedResult struct {
+	rawResp []byte
+	resp    *dns.Msg
+	err     error
+}
+
+// pipelinedConn is a TCP or TLS connection shared by concurrent queries
+// whose responses may arrive in any order. A background goroutine reads
+// the responses and dispatches them to the matching outstanding query.
+type pipelinedConn struct {
+	// conn is the underlying connection.
+	conn net.Conn
+
+	// writeMu serializes writing queries to conn.
+	writeMu sync.Mutex
+
+	// mu protects the following fields.
+	mu sync.Mutex
+
+	// pending maps the message ID to the outstanding query.
+	pending map[uint16]*pipelinedQuery
+
+	// err is non-nil once the connection is broken or closed.
+	err error
+
+	// draining is the reason for closing the connection once the
+	// outstanding queries have been answered, if not empty, e.g., when
+	// the server asked us to do so or the connection is too old.
+	draining ConnCloseReason
+
+	// expires is when the connection expires if it is still idle.
+	expires time.Time
+
+	// timeout is the most recent idle timeout advertised by the server.
+	timeout time.Duration
+
+	// retires is when the connection becomes too old to use for new
+	// queries, or the zero value if it does not have a maximum age.
+	retires time.Time
+
+	// timeouts counts the consecutive queries that timed out.
+	timeouts int
+}
+
+// newPipelinedConn creates a new [*pipelinedConn] that becomes
+// too old to use at the given time, unless it's the zero value.
+func newPipelinedConn(conn net.Conn, retires time.Time) *pipelinedConn {
+	return &pipelinedConn{
+		conn:    conn,
+		pending: make(map[uint16]*pipelinedQuery),
+		timeout: DefaultStreamIdleTimeout,
+		retires: retires,
+	}
+}
+
+// usable returns whether we can send new queries using the connection,
+// closing the connection if it is idle and has expired. When the
+// connection is too old, we drain it, closing it once idle.
+func (pc *pipelinedConn) usable(now time.Time) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err != nil || pc.draining != "" {
+		return false
+	}
+	if !pc.retires.IsZero() && !now.Before(pc.retires) {
+		pc.draining = ConnCloseMaxAge
+		pc.maybeIdleLocked(now)
+		return false
+	}
+	if len(pc.pending) <= 0 && !now.Before(pc.expires) {
+		pc.closeLocked(ConnCloseIdleTimeout, net.ErrClosed)
+		return false
+	}
+	return true
+}
+
+// register adds an outstanding query, using a new message ID generated
+// by newID if its own ID is already used by another outstanding query, and
+// returns the query to send on the wire along with the channel receiving
+// the result. We return [ErrTooManyPipelinedQueries] when newID does not
+// return an unused ID within pipelineMaxIDAttempts attempts.
+func (pc *pipelinedConn) register(query *dns.Msg, newID IDGenerator) (*dns.Msg, <-chan pipelinedResult, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.err != nil {
+		return nil, nil, pc.err
+	}
+	if len(pc.pending) > 0xffff {
+		return nil, nil, ErrTooManyPipelinedQueries
+	}
+	if _, found := pc.pending[query.Id]; found {
+		query = query.Copy()
+		for attempt := 0; ; attempt++ {
+			if attempt >= pipelineMaxIDAttempts {
+				return nil, nil, ErrTooManyPipelinedQueries
+			}
+			if query.Id = newID(); pc.pending[query.Id] == nil {
+				break
+			}
+		}
+	}
+	pq := &pipelinedQuery{query: query, ch: make(chan pipelinedResult, 1)}
+	pc.pending[query.Id] = pq
+	return query, pq.ch, nil
+}
+
+// forget removes the outstanding query with the given ID and, when the
+// connection becomes idle, either closes it, if the server asked us to
+// do so, or starts counting the idle timeout.
+func (pc *pipelinedConn) forget(id uint16, now time.Time) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.pending, id)
+	pc.maybeIdleLocked(now)
+}
+
+// timedOut is like forget for a query that timed out. After pipelineMaxTimeouts
+// consecutive timeouts, we drain the connection, since the server may have
+// silently stopped answering, such that new queries use a new connection.
+func (pc *pipelinedConn) timedOut(id uint16, now time.Time) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	delete(pc.pending, id)
+	if pc.timeouts++; pc.timeouts >= pipelineMaxTimeouts && pc.draining == "" {
+		pc.draining = ConnCloseUnresponsive
+	}
+	pc.maybeIdleLocked(now)
+}
+
+// maybeIdleLocked is like forget but assumes we hold the mutex.
+func (pc *pipelinedConn) maybeIdleLocked(now time.Time) {
+	if len(pc.pending) > 0 {
+		return
+	}
+	if pc.draining != "" {
+		pc.closeLocked(pc.draining, net.ErrClosed)
+		return
+	}
+	pc.expires = now.Add(pc.timeout)
+}
+
+// dispatch delivers the response to the matching outstanding query
+// and returns false if there is no such query.
+func (pc *pipelinedConn) dispatch(rawResp []byte, resp *dns.Msg, now time.Time) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if timeout := streamIdleTimeout(resp); timeout <= 0 {
+		pc.draining = ConnCloseServerRequest
+	} else {
+		pc.timeout = timeout
+	}
+	pq := pc.pending[resp.Id]
+	if pq == nil || ValidateResponse(pq.query, resp) != nil {
+		return false
+	}
+	delete(pc.pending, resp.Id)
+	pc.timeouts = 0
+	pq.ch <- pipelinedResult{rawResp: rawResp, resp: resp}
+	pc.maybeIdleLocked(now)
+	return true
+}
+
+// fail closes the connection for the given reason and fails all
+// the outstanding queries with the given error.
+func (pc *pipelinedConn) fail(reason ConnCloseReason, err error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.closeLocked(reason, err)
+}
+
+// closeLocked is like fail but assumes we hold the mutex.
+func (pc *pipelinedConn) closeLocked(reason ConnCloseReason, err error) {
+	if pc.err != nil {
+		return
+	}
+	pc.err = err
+	if reason == ConnCloseError {
+		closeConn(pc.conn, reason, err)
+	} else {
+		closeConn(pc.conn, reason, nil)
+	}
+	for id, pq := range pc.pending {
+		pq.ch <- pipelinedResult{err: err}
+		delete(pc.pending, id)
+	}
+}
+
+// pipelineSet contains the shared TCP and TLS connections.
+//
+// The zero value is ready to use.
+type pipelineSet struct {
+	// conns maps each server and priority to its shared connection.
+	conns map[streamKey]*pipelinedConn
+
+	// dialing maps each server to a channel closed when we're
+	// done dialing, to avoid creating redundant connections.
+	dialing map[streamKey]chan struct{}
+
+	// mu protects conns and dialing.
+	mu sync.Mutex
+}
+
+// closeAll closes all the shared connections.
+func (ps *pipelineSet) closeAll() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, pc := range ps.conns {
+		pc.fail(ConnCloseTransport, net.ErrClosed)
+	}
+	ps.conns = nil
+}
+
+// pipelinedConn returns the usable shared connection to the given server,
+// if any, or creates a new one using dial. The boolean return value is
+// true when we're reusing an existing connection.
+func (t *Transport) pipelinedConn(ctx context.Context, addr *ServerAddr,
+	dial func(ctx context.Context) (net.Conn, error)) (*pipelinedConn, bool, error) {
+	ps, key := &t.pipelines, streamKeyFor(ctx, addr)
+	for {
+		// 1. reuse the existing connection, if possible
+		ps.mu.Lock()
+		if pc := ps.conns[key]; pc != nil && pc.usable(t.timeNow()) {
+			ps.mu.Unlock()
+			t.reuseConn(addr, pc.conn)
+			return pc, true, nil
+		}
+
+		// 2. wait for a concurrent dial to complete, if any
+		if ch := ps.dialing[key]; ch != nil {
+			ps.mu.Unlock()
+			select {
+			case <-ch:
+				continue
+			case <-ctx.Done():
+				return nil, false, ctx.Err()
+			}
+		}
+
+		// 3. otherwise, dial a new connection
+		if ps.dialing == nil {
+			ps.dialing = make(map[streamKey]chan struct{})
+		}
+		ch := make(chan struct{})
+		ps.dialing[key] = ch
+		ps.mu.Unlock()
+		conn, err := dial(ctx)
+
+		// 4. register the connection and start reading responses
+		ps.mu.Lock()
+		delete(ps.dialing, key)
+		close(ch)
+		if err != nil {
+			ps.mu.Unlock()
+			return nil, false, err
+		}
+		maybeEnableTCPKeepalive(conn, t.StreamProbeInterval)
+		pc := newPipelinedConn(conn, t.connRetireTime())
+		if ps.conns == nil {
+			ps.conns = make(map[streamKey]*pipelinedConn)
+		}
+		ps.conns[key] = pc
+		ps.mu.Unlock()
+		go t.readPipelined(addr, pc)
+		return pc, false, nil
+	}
+}
+
+// readPipelined reads responses from the given connection and dispatches
+// them to the outstanding queries until the connection is closed.
+func (t *Transport) readPipelined(addr *ServerAddr, pc *pipelinedConn) {
+	br := bufio.NewReader(pc.conn)
+	for {
+		rawResp, err := ReadMsgFrame(br)
+		if err != nil {
+			pc.fail(ConnCloseError, err)
+			return
+		}
+		t.maybeCaptureReceived(addr, pc.conn, rawResp)
+		resp := &dns.Msg{}
+		if err := resp.Unpack(rawResp); err != nil || !pc.dispatch(rawResp, resp, t.timeNow()) {
+			t.stats.onDiscarded(addr)
+		}
+	}
+}
+
+// queryStreamPipelined implements [*Transport.Query] for DNS over TCP and
+// TLS when PipelineStreamQueries is true. Like [*Transport.queryStreamReusingConns],
+// we retry with a new connection if the shared connection was already
+// open and fails, since the server may have closed it in the meanwhile,
+// unless retrying could cause duplicate side effects.
+func (t *Transport) queryStreamPipelined(ctx context.Context, addr *ServerAddr,
+	query *dns.Msg, dial func(ctx context.Context) (net.Conn, error)) (*dns.Msg, error) {
+	for {
+		pc, reused, err := t.pipelinedConn(ctx, addr, dial)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.exchangePipelined(ctx, addr, query, pc)
+		if err == nil || !reused || ctx.Err() != nil || !canRetryQuery(query, err) {
+			return resp, err
+		}
+	}
+}
+
+// exchangePipelined sends the query over the shared connection and waits
+// for the matching response, which may arrive in any order.
+func (t *Transport) exchangePipelined(ctx context.Context,
+	addr *ServerAddr, query *dns.Msg, pc *pipelinedConn) (*dns.Msg, error) {
+	// 1. Register the query, which may change the message ID to avoid
+	// clashing with the other outstanding queries.
+	wireQuery, ch, err := pc.register(query, RandomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Serialize the query and possibly log that we're sending it,
+	// using the original message ID, since the new one is an internal
+	// detail of the connection, which only the capture shows.
+	rawWireQuery, err := wireQuery.Pack()
+	if err != nil {
+		pc.forget(wireQuery.Id, t.timeNow())
+		return nil, err
+	}
+	rawQuery := withRawMsgID(rawWireQuery, query.Id)
+	t0 := t.maybeLogQuery(ctx, addr, rawQuery)
+	tracer := queryTracerFromContext(ctx)
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.RawQuery = rawQuery })
+	rawQueryFrame, err := newRawMsgFrame(addr, rawWireQuery)
+	if err != nil {
+		pc.forget(wireQuery.Id, t.timeNow())
+		return nil, err
+	}
+
+	// 3. Send the query. A failed write may leave a partial frame
+	// on the stream, so we must close the connection in such a case.
+	pc.writeMu.Lock()
+	deadline, _ := ctx.Deadline()
+	_ = pc.conn.SetWriteDeadline(deadline)
+	_, err = pc.conn.Write(rawQueryFrame)
+	pc.writeMu.Unlock()
+	if err != nil {
+		pc.fail(ConnCloseError, err)
+		return nil, err
+	}
+	t.maybeCaptureSent(addr, pc.conn, rawWireQuery)
+	t.stats.onSent(addr, len(rawQueryFrame))
+	tracer.stamp(func(info *QueryInfo, now time.Time) { info.QuerySent = now })
+
+	// 4. Wait for the response or for the context to be done, counting
+	// the timeouts but not the cancellations, e.g., when a concurrent
+	// query to another server already succeeded.
+	var result pipelinedResult
+	select {
+	case result = <-ch:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			pc.timedOut(wireQuery.Id, t.timeNow())
+		} else {
+			pc.forget(wireQuery.Id, t.timeNow())
+		}
+		return nil, ctx.Err()
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	// 5. Restore the original message ID and possibly log.
+	resp, rawResp := result.resp, withRawMsgID(result.rawResp, query.Id)
+	resp.Id = query.Id
+	tracer.stamp(func(info *QueryInfo, now time.Time) {
+		info.FirstByte = now
+		info.LastByte = now
+		info.RawResponse = rawResp
+	})
+	t.stats.onResponse(addr, len(rawResp), resp.Rcode)
+	t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, pc.conn)
+	return resp, nil
+}
+
+// withRawMsgID returns the raw message using the given message ID,
+// copying the message only when its ID is different.
+func withRawMsgID(rawMsg []byte, id uint16) []byte {
+	if len(rawMsg) < 2 || binary.BigEndian.Uint16(rawMsg) == id {
+		return rawMsg
+	}
+	rawMsg = bytes.Clone(rawMsg)
+	binary.BigEndian.PutUint16(rawMsg, id)
+	return rawMsg
+}
@@ -0,0 +1,278 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_PipelineStreamQueries(t *testing.T) {
+	// queryConcurrently sends the queries concurrently and returns the errors
+	queryConcurrently := func(ctx context.Context, txp *Transport, addr *ServerAddr, queries ...*dns.Msg) []error {
+		errs := make([]error, len(queries))
+		wg := &sync.WaitGroup{}
+		for idx, query := range queries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := txp.Query(ctx, addr, query)
+				if err == nil {
+					err = ValidateResponse(query, resp)
+				}
+				if err == nil && len(resp.Answer) != 1 {
+					err = errors.New("unexpected number of answers")
+				}
+				errs[idx] = err
+			}()
+		}
+		wg.Wait()
+		return errs
+	}
+
+	newQuery := func(name string) *dns.Msg {
+		query, _ := NewQuery(name, dns.TypeA, QueryOptionEDNS0(4096, 0))
+		return query
+	}
+
+	t.Run("matches responses arriving out of order", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(3, answerA))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		errs := queryConcurrently(context.Background(), txp, addr,
+			newQuery("a.example.com"), newQuery("bb.example.com"), newQuery("ccc.example.com"))
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+		assert.Equal(t, int64(1), conns.Load())
+		stats := txp.Stats()[0]
+		assert.Equal(t, int64(1), stats.NewConns)
+		assert.Equal(t, int64(2), stats.ReusedConns)
+		assert.Equal(t, int64(3), stats.Responses)
+	})
+
+	t.Run("handles queries using the same message ID", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(2, answerA))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		first, second := newQuery("a.example.com"), newQuery("bb.example.com")
+		second.Id = first.Id
+		errs := queryConcurrently(context.Background(), txp, addr, first, second)
+		assert.Equal(t, []error{nil, nil}, errs)
+	})
+
+	t.Run("uses a new message ID and restores the original one", func(t *testing.T) {
+		var (
+			mu  sync.Mutex
+			ids []uint16
+		)
+		addr, _ := startStreamServer(t, answerStreamQueries(2, func(query *dns.Msg) []*dns.Msg {
+			mu.Lock()
+			ids = append(ids, query.Id)
+			mu.Unlock()
+			return answerA(query)
+		}))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		first, second := newQuery("a.example.com"), newQuery("bb.example.com")
+		first.Id, second.Id = 1, 1
+		infos := make([]*QueryInfo, 2)
+		wg := &sync.WaitGroup{}
+		for idx, query := range []*dns.Msg{first, second} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, info, err := txp.QueryWithInfo(context.Background(), addr, query)
+				assert.NoError(t, err)
+				assert.Equal(t, uint16(1), resp.Id)
+				infos[idx] = info
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, ids, 2)
+		assert.Contains(t, ids, uint16(1))
+		assert.NotEqual(t, ids[0], ids[1])
+		for _, info := range infos {
+			assert.Equal(t, []byte{0, 1}, info.RawQuery[:2])
+			assert.Equal(t, []byte{0, 1}, info.RawResponse[:2])
+		}
+	})
+
+	t.Run("discards responses not matching any query", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, func(query *dns.Msg) []*dns.Msg {
+			bogus := &dns.Msg{}
+			bogus.SetQuestion("other.example.com.", dns.TypeA)
+			bogus.Id = query.Id
+			bogus.Response = true
+			return append([]*dns.Msg{bogus}, answerA(query)...)
+		}))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		errs := queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		assert.Equal(t, []error{nil}, errs)
+		assert.Equal(t, int64(1), txp.Stats()[0].Discarded)
+	})
+
+	t.Run("forgets queries when the context is done", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(2, answerA))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		errs := queryConcurrently(ctx, txp, addr, newQuery("a.example.com"))
+		assert.ErrorIs(t, errs[0], context.DeadlineExceeded)
+		pc := txp.pipelines.conns[streamKey{server: addr.key()}]
+		assert.Empty(t, pc.pending)
+		assert.NoError(t, pc.err)
+	})
+
+	t.Run("redials once the server stops answering", func(t *testing.T) {
+		var count atomic.Int64
+		addr, conns := startStreamServer(t, answerStreamQueries(1, func(query *dns.Msg) []*dns.Msg {
+			if count.Add(1) <= pipelineMaxTimeouts {
+				return []*dns.Msg{}
+			}
+			return answerA(query)
+		}))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		for idx := 0; idx < pipelineMaxTimeouts; idx++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			errs := queryConcurrently(ctx, txp, addr, newQuery("a.example.com"))
+			cancel()
+			assert.ErrorIs(t, errs[0], context.DeadlineExceeded)
+		}
+		assert.Equal(t, []error{nil}, queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com")))
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("fails outstanding queries and redials when the server closes the connection", func(t *testing.T) {
+		var closed atomic.Bool
+		addr, conns := startStreamServer(t, answerStreamQueries(1, func(query *dns.Msg) []*dns.Msg {
+			if closed.CompareAndSwap(false, true) {
+				return nil
+			}
+			return answerA(query)
+		}))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		errs := queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		assert.Error(t, errs[0])
+		errs = queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		assert.Equal(t, []error{nil}, errs)
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("closes the connection when the server asks to", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, func(query *dns.Msg) []*dns.Msg {
+			resps := answerA(query)
+			resps[0].SetEdns0(4096, false)
+			resps[0].IsEdns0().Option = append(resps[0].IsEdns0().Option,
+				&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+			return resps
+		}))
+		txp := &Transport{PipelineStreamQueries: true}
+		defer txp.Close()
+		for idx := 0; idx < 2; idx++ {
+			errs := queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+			assert.Equal(t, []error{nil}, errs)
+		}
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("closes the connection once the idle timeout expires", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		now := time.Now()
+		var mu sync.Mutex
+		txp := &Transport{PipelineStreamQueries: true, TimeNow: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}}
+		defer txp.Close()
+		queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		assert.Equal(t, int64(1), conns.Load())
+		mu.Lock()
+		now = now.Add(DefaultStreamIdleTimeout)
+		mu.Unlock()
+		queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("stops using connections older than MaxConnectionAge", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		now := time.Now()
+		var mu sync.Mutex
+		txp := &Transport{PipelineStreamQueries: true, MaxConnectionAge: 8 * time.Second, TimeNow: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}}
+		defer txp.Close()
+		for _, expected := range []int64{1, 1, 2} {
+			assert.Equal(t, []error{nil}, queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com")))
+			assert.Equal(t, expected, conns.Load())
+			mu.Lock()
+			now = now.Add(5 * time.Second)
+			mu.Unlock()
+		}
+	})
+
+	t.Run("Close closes the connections", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{PipelineStreamQueries: true}
+		queryConcurrently(context.Background(), txp, addr, newQuery("a.example.com"))
+		pc := txp.pipelines.conns[streamKey{server: addr.key()}]
+		assert.NoError(t, txp.Close())
+		assert.Nil(t, txp.pipelines.conns)
+		assert.ErrorIs(t, pc.err, net.ErrClosed)
+	})
+
+	t.Run("dial failure", func(t *testing.T) {
+		expectedErr := errors.New("mocked error")
+		txp := &Transport{
+			PipelineStreamQueries: true,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, expectedErr
+			},
+			DialTLSContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, expectedErr
+			},
+		}
+		for _, protocol := range []Protocol{ProtocolTCP, ProtocolDoT} {
+			_, err := txp.Query(context.Background(), NewServerAddr(protocol, "127.0.0.1:1"), newQuery("a.example.com"))
+			assert.ErrorIs(t, err, expectedErr)
+		}
+	})
+}
+
+func Test_pipelinedConn_register(t *testing.T) {
+	t.Run("fails when all the message IDs are in use", func(t *testing.T) {
+		pc := newPipelinedConn(nil, time.Time{})
+		for id := 0; id <= 0xffff; id++ {
+			pc.pending[uint16(id)] = &pipelinedQuery{}
+		}
+		query, _ := NewQuery("example.com", dns.TypeA)
+		_, _, err := pc.register(query, RandomID)
+		assert.ErrorIs(t, err, ErrTooManyPipelinedQueries)
+	})
+
+	t.Run("fails when the generator does not return an unused ID", func(t *testing.T) {
+		pc := newPipelinedConn(nil, time.Time{})
+		query, _ := NewQuery("example.com", dns.TypeA)
+		query.Id = 1
+		_, _, err := pc.register(query, RandomID)
+		assert.NoError(t, err)
+		var calls int
+		_, _, err = pc.register(query, func() uint16 { calls++; return 1 })
+		assert.ErrorIs(t, err, ErrTooManyPipelinedQueries)
+		assert.Equal(t, pipelineMaxIDAttempts, calls)
+	})
+}
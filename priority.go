@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// QueryPriority is the priority hint of a query, which allows latency-critical
+// lookups to share a [*Transport] with bulk scans without being starved.
+//
+// Attach the hint to the query context using [ContextWithQueryPriority].
+type QueryPriority int
+
+const (
+	// QueryPriorityInteractive is the priority of latency-critical
+	// queries, e.g., those a user is waiting for. This is the default.
+	QueryPriorityInteractive = QueryPriority(iota)
+
+	// QueryPriorityBackground is the priority of bulk queries, e.g.,
+	// those of measurement scans and of cache prefetching, which
+	// yield to interactive queries when concurrency is limited.
+	QueryPriorityBackground
+)
+
+// String returns the string representation of the priority.
+func (p QueryPriority) String() string {
+	switch p {
+	case QueryPriorityBackground:
+		return "background"
+	default:
+		return "interactive"
+	}
+}
+
+// queryPriorityKey is the context key for the [QueryPriority].
+type queryPriorityKey struct{}
+
+// ContextWithQueryPriority returns a copy of the context carrying the given
+// [QueryPriority], which the [*Transport] honors for queries using the context.
+func ContextWithQueryPriority(ctx context.Context, priority QueryPriority) context.Context {
+	return context.WithValue(ctx, queryPriorityKey{}, priority)
+}
+
+// QueryPriorityFromContext returns the [QueryPriority] inside the context
+// or [QueryPriorityInteractive] if the context does not carry any.
+func QueryPriorityFromContext(ctx context.Context) QueryPriority {
+	if priority, ok := ctx.Value(queryPriorityKey{}).(QueryPriority); ok && priority == QueryPriorityBackground {
+		return priority
+	}
+	return QueryPriorityInteractive
+}
+
+// streamKey identifies the reusable TCP and TLS connections to a server
+// for a given priority, such that background queries do not delay
+// interactive queries sharing a connection with them.
+type streamKey struct {
+	server   serverKey
+	priority QueryPriority
+}
+
+// streamKeyFor returns the [streamKey] for the given server and context.
+func streamKeyFor(ctx context.Context, addr *ServerAddr) streamKey {
+	return streamKey{server: addr.key(), priority: QueryPriorityFromContext(ctx)}
+}
+
+// queryLimiterWaiter is a query waiting for the [*queryLimiter].
+type queryLimiterWaiter struct {
+	// ch is closed when we grant the slot.
+	ch chan struct{}
+
+	// granted indicates that we granted the slot.
+	granted bool
+}
+
+// queryLimiter limits the number of in-flight queries, granting the
+// available slots to the waiting interactive queries first.
+//
+// The zero value is ready to use.
+type queryLimiter struct {
+	// inflight is the number of in-flight queries.
+	inflight int
+
+	// background is the number of in-flight background queries.
+	background int
+
+	// waiters contains the waiting queries for each priority.
+	waiters [2][]*queryLimiterWaiter
+
+	// mu protects the fields above.
+	mu sync.Mutex
+}
+
+// canRunLocked returns whether a query with the given priority can
+// start given the limits. The caller must hold the mutex.
+func (l *queryLimiter) canRunLocked(priority QueryPriority, maxInflight, maxBackground int) bool {
+	if l.inflight >= maxInflight {
+		return false
+	}
+	if priority == QueryPriorityInteractive {
+		return true
+	}
+	return len(l.waiters[QueryPriorityInteractive]) <= 0 && (maxBackground <= 0 || l.background < maxBackground)
+}
+
+// startLocked accounts for a query starting. The caller must hold the mutex.
+func (l *queryLimiter) startLocked(priority QueryPriority) {
+	l.inflight++
+	if priority == QueryPriorityBackground {
+		l.background++
+	}
+}
+
+// acquire waits for a slot for a query with the given priority and returns
+// the function to release the slot, or the context error. We only start a
+// query when no query with the same or higher priority is waiting.
+func (l *queryLimiter) acquire(ctx context.Context,
+	priority QueryPriority, maxInflight, maxBackground int) (func(), error) {
+	release := func() { l.release(priority, maxInflight, maxBackground) }
+
+	// 1. start immediately if possible
+	l.mu.Lock()
+	if len(l.waiters[priority]) <= 0 && l.canRunLocked(priority, maxInflight, maxBackground) {
+		l.startLocked(priority)
+		l.mu.Unlock()
+		return release, nil
+	}
+
+	// 2. otherwise, wait for our turn
+	waiter := &queryLimiterWaiter{ch: make(chan struct{})}
+	l.waiters[priority] = append(l.waiters[priority], waiter)
+	l.mu.Unlock()
+	select {
+	case <-waiter.ch:
+		return release, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		granted := waiter.granted
+		if !granted {
+			l.waiters[priority] = slices.DeleteFunc(l.waiters[priority], func(w *queryLimiterWaiter) bool {
+				return w == waiter
+			})
+			// removing an interactive waiter may unblock background waiters
+			l.wakeLocked(maxInflight, maxBackground)
+		}
+		l.mu.Unlock()
+		if granted {
+			release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// release releases the slot of a query with the given priority.
+func (l *queryLimiter) release(priority QueryPriority, maxInflight, maxBackground int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	if priority == QueryPriorityBackground {
+		l.background--
+	}
+	l.wakeLocked(maxInflight, maxBackground)
+}
+
+// wakeLocked grants the available slots to the waiting queries, interactive
+// ones first, in arrival order. The caller must hold the mutex.
+func (l *queryLimiter) wakeLocked(maxInflight, maxBackground int) {
+	for _, priority := range []QueryPriority{QueryPriorityInteractive, QueryPriorityBackground} {
+		for len(l.waiters[priority]) > 0 && l.canRunLocked(priority, maxInflight, maxBackground) {
+			waiter := l.waiters[priority][0]
+			l.waiters[priority] = l.waiters[priority][1:]
+			waiter.granted = true
+			close(waiter.ch)
+			l.startLocked(priority)
+		}
+	}
+}
+
+// acquireQuerySlot waits until the query can start according to the
+// MaxConcurrentQueries and MaxBackgroundQueries fields and returns the
+// function to call when the query is complete, or the context error.
+func (t *Transport) acquireQuerySlot(ctx context.Context) (func(), error) {
+	if t.MaxConcurrentQueries <= 0 {
+		return func() {}, nil
+	}
+	return t.limiter.acquire(ctx, QueryPriorityFromContext(ctx), t.MaxConcurrentQueries, t.MaxBackgroundQueries)
+}
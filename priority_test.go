@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryPriorityFromContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected QueryPriority
+	}{
+		{"default", context.Background(), QueryPriorityInteractive},
+		{"background", ContextWithQueryPriority(context.Background(), QueryPriorityBackground), QueryPriorityBackground},
+		{"unknown", ContextWithQueryPriority(context.Background(), QueryPriority(17)), QueryPriorityInteractive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priority := QueryPriorityFromContext(tt.ctx)
+			assert.Equal(t, tt.expected, priority)
+			assert.NotEmpty(t, priority.String())
+		})
+	}
+}
+
+func Test_queryLimiter(t *testing.T) {
+	// waitForWaiters waits until the given number of queries are waiting.
+	waitForWaiters := func(l *queryLimiter, priority QueryPriority, count int) {
+		assert.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.waiters[priority]) == count
+		}, time.Second, time.Millisecond)
+	}
+
+	t.Run("grants slots to interactive queries first", func(t *testing.T) {
+		l := &queryLimiter{}
+		ctx := context.Background()
+		release, err := l.acquire(ctx, QueryPriorityBackground, 1, 0)
+		assert.NoError(t, err)
+
+		order := make(chan QueryPriority, 2)
+		for _, priority := range []QueryPriority{QueryPriorityBackground, QueryPriorityInteractive} {
+			go func() {
+				release, err := l.acquire(ctx, priority, 1, 0)
+				assert.NoError(t, err)
+				order <- priority
+				release()
+			}()
+			waitForWaiters(l, priority, 1)
+		}
+
+		release()
+		assert.Equal(t, QueryPriorityInteractive, <-order)
+		assert.Equal(t, QueryPriorityBackground, <-order)
+		assert.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return l.inflight == 0
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("reserves capacity for interactive queries", func(t *testing.T) {
+		l := &queryLimiter{}
+		release, err := l.acquire(context.Background(), QueryPriorityBackground, 2, 1)
+		assert.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = l.acquire(ctx, QueryPriorityBackground, 2, 1)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		waitForWaiters(l, QueryPriorityBackground, 0)
+
+		release2, err := l.acquire(context.Background(), QueryPriorityInteractive, 2, 1)
+		assert.NoError(t, err)
+		release2()
+	})
+
+	t.Run("canceling an interactive waiter unblocks background waiters", func(t *testing.T) {
+		l := &queryLimiter{}
+		release, err := l.acquire(context.Background(), QueryPriorityInteractive, 2, 0)
+		assert.NoError(t, err)
+		defer release()
+		release2, err := l.acquire(context.Background(), QueryPriorityInteractive, 2, 0)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go l.acquire(ctx, QueryPriorityInteractive, 2, 0)
+		waitForWaiters(l, QueryPriorityInteractive, 1)
+		done := make(chan error)
+		go func() {
+			release, err := l.acquire(context.Background(), QueryPriorityBackground, 2, 0)
+			if err == nil {
+				release()
+			}
+			done <- err
+		}()
+		waitForWaiters(l, QueryPriorityBackground, 1)
+
+		// the canceled interactive waiter must not block the background one
+		cancel()
+		waitForWaiters(l, QueryPriorityInteractive, 0)
+		release2()
+		assert.NoError(t, <-done)
+	})
+}
+
+func TestTransport_QueryPriority(t *testing.T) {
+	newQuery := func() *dns.Msg {
+		query, _ := NewQuery("example.com", dns.TypeA, QueryOptionEDNS0(EDNS0SuggestedMaxResponseSizeOtherwise, 0))
+		return query
+	}
+
+	t.Run("uses distinct connections for each priority", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{ReuseStreamConns: true}
+		defer txp.Close()
+		background := ContextWithQueryPriority(context.Background(), QueryPriorityBackground)
+		for _, ctx := range []context.Context{context.Background(), background, context.Background(), background} {
+			_, err := txp.Query(ctx, addr, newQuery())
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int64(2), conns.Load())
+		assert.Len(t, txp.streams.idle[streamKey{server: addr.key(), priority: QueryPriorityBackground}], 1)
+	})
+
+	t.Run("limits the in-flight queries", func(t *testing.T) {
+		txp := &Transport{MaxConcurrentQueries: 1}
+		release, err := txp.acquireQuerySlot(context.Background())
+		assert.NoError(t, err)
+		defer release()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = txp.Query(ctx, NewServerAddr(ProtocolUDP, "127.0.0.1:53"), newQuery())
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultProbeTimeout is the default timeout for each query sent by [*Prober].
+const DefaultProbeTimeout = 5 * time.Second
+
+// Prober probes the capabilities of a DNS server, such as the protocols
+// it supports, which is useful for automatically configuring upstreams
+// and for research scans.
+//
+// The zero value is ready to use.
+type Prober struct {
+	// Name is the name to query, which should be signed to probe the
+	// DNSSEC capabilities. If empty, we query for the root zone.
+	Name string
+
+	// Timeout is the timeout for each query.
+	//
+	// If zero, we use [DefaultProbeTimeout].
+	Timeout time.Duration
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the optional DNS transport to use.
+	//
+	// If nil, we use [DefaultTransport].
+	Transport ResolverTransport
+}
+
+// ProbeProtocol is the result of probing a single protocol.
+type ProbeProtocol struct {
+	// Addr is the server address we probed.
+	Addr *ServerAddr
+
+	// Supported indicates whether we received a valid response.
+	Supported bool
+
+	// RTT is the time elapsed until we received the response or failed.
+	RTT time.Duration
+
+	// Err is the error that occurred, if any.
+	Err error
+}
+
+// ProbeReport contains the capabilities of a server probed by [*Prober].
+type ProbeReport struct {
+	// Protocols contains the result of probing each protocol.
+	Protocols []ProbeProtocol
+
+	// EDNS indicates whether the server supports EDNS(0) (RFC 6891).
+	EDNS bool
+
+	// Cookies indicates whether the server returns DNS cookies (RFC 7873).
+	Cookies bool
+
+	// Padding indicates whether the server pads the responses sent over
+	// encrypted protocols (RFC 7830, RFC 8467).
+	Padding bool
+
+	// DNSSEC indicates whether the server returns the RRSIGs when
+	// the query has the DNSSEC OK (DO) bit set.
+	DNSSEC bool
+
+	// Validating indicates whether the server validates DNSSEC, which we
+	// infer from the Authentic Data (AD) bit of the response.
+	Validating bool
+}
+
+// Supports returns whether the server supports the given protocol.
+func (r *ProbeReport) Supports(protocol Protocol) bool {
+	for _, result := range r.Protocols {
+		if result.Addr.Protocol == protocol && result.Supported {
+			return true
+		}
+	}
+	return false
+}
+
+// transport returns the transport to use.
+func (p *Prober) transport() ResolverTransport {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return DefaultTransport
+}
+
+// timeNow returns the current time.
+func (p *Prober) timeNow() time.Time {
+	if p.TimeNow != nil {
+		return p.TimeNow()
+	}
+	return time.Now()
+}
+
+// timeout returns the timeout for each query.
+func (p *Prober) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return DefaultProbeTimeout
+}
+
+// name returns the name to query.
+func (p *Prober) name() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return "."
+}
+
+// Probe probes the server at the given host, which is an IP address or a
+// domain name, using DNS over UDP, TCP, TLS, and HTTPS on the default ports.
+// We do not probe DNS over HTTP/3 and DNS over QUIC, which [*Transport] does
+// not support. We then probe the EDNS(0), cookies, and DNSSEC capabilities
+// using the first supported unencrypted protocol, and padding using the first
+// supported encrypted protocol. Errors are reported in the [*ProbeReport].
+func (p *Prober) Probe(ctx context.Context, host string) *ProbeReport {
+	// 1. probe the protocols
+	report := &ProbeReport{}
+	var plain, encrypted *ServerAddr
+	for _, addr := range probeServerAddrs(host) {
+		result := p.probeProtocol(ctx, addr)
+		report.Protocols = append(report.Protocols, result)
+		if !result.Supported {
+			continue
+		}
+		switch addr.Protocol {
+		case ProtocolUDP, ProtocolTCP:
+			if plain == nil {
+				plain = addr
+			}
+		default:
+			if encrypted == nil {
+				encrypted = addr
+			}
+		}
+	}
+
+	// 2. probe the features
+	if plain == nil {
+		plain = encrypted
+	}
+	if plain != nil {
+		p.probeFeatures(ctx, plain, report)
+	}
+	if encrypted != nil {
+		report.Padding = p.probePadding(ctx, encrypted)
+	}
+	return report
+}
+
+// probeServerAddrs returns the server addresses to probe for the given host.
+func probeServerAddrs(host string) []*ServerAddr {
+	urlHost := host
+	if strings.Contains(host, ":") {
+		urlHost = "[" + host + "]"
+	}
+	return []*ServerAddr{
+		NewServerAddr(ProtocolUDP, net.JoinHostPort(host, "53")),
+		NewServerAddr(ProtocolTCP, net.JoinHostPort(host, "53")),
+		NewServerAddr(ProtocolDoT, net.JoinHostPort(host, "853")),
+		NewServerAddr(ProtocolDoH, "https://"+urlHost+"/dns-query"),
+	}
+}
+
+// probeProtocol probes whether the server supports the protocol of addr.
+func (p *Prober) probeProtocol(ctx context.Context, addr *ServerAddr) ProbeProtocol {
+	result := ProbeProtocol{Addr: addr}
+	query, err := NewQueryWithServerAddr(addr, p.name(), dns.TypeSOA)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	t0 := p.timeNow()
+	_, err = p.exchange(ctx, addr, query)
+	result.RTT = p.timeNow().Sub(t0)
+	result.Err = err
+	result.Supported = err == nil
+	return result
+}
+
+// probeFeatures probes the EDNS(0), cookies, and DNSSEC capabilities.
+func (p *Prober) probeFeatures(ctx context.Context, addr *ServerAddr, report *ProbeReport) {
+	// 1. create a query with a client cookie, the DO bit, and the AD bit,
+	// which requests the AD bit in the response (RFC 6840 Sect. 5.7)
+	query, err := NewQueryWithServerAddr(addr, p.name(), dns.TypeSOA,
+		QueryOptionEDNS0(EDNS0SuggestedMaxResponseSizeUDP, EDNS0FlagDO))
+	if err != nil {
+		return
+	}
+	query.AuthenticatedData = true
+	clientCookie := make([]byte, 8)
+	rand.Read(clientCookie)
+	cookie := &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: hex.EncodeToString(clientCookie)}
+	query.IsEdns0().Option = append(query.IsEdns0().Option, cookie)
+
+	// 2. send the query and inspect the response
+	resp, err := p.exchange(ctx, addr, query)
+	if err != nil {
+		return
+	}
+	opt := resp.IsEdns0()
+	report.EDNS = opt != nil
+	if opt != nil {
+		for _, option := range opt.Option {
+			if c, ok := option.(*dns.EDNS0_COOKIE); ok && len(c.Cookie) > len(cookie.Cookie) &&
+				strings.EqualFold(c.Cookie[:len(cookie.Cookie)], cookie.Cookie) {
+				report.Cookies = true
+			}
+		}
+	}
+	for _, rr := range resp.Answer {
+		if _, ok := rr.(*dns.RRSIG); ok {
+			report.DNSSEC = true
+		}
+	}
+	report.Validating = resp.AuthenticatedData
+}
+
+// probePadding probes whether the server pads the responses.
+func (p *Prober) probePadding(ctx context.Context, addr *ServerAddr) bool {
+	query, err := NewQueryWithServerAddr(addr, p.name(), dns.TypeSOA,
+		QueryOptionEDNS0(EDNS0SuggestedMaxResponseSizeOtherwise, EDNS0FlagBlockLengthPadding))
+	if err != nil {
+		return false
+	}
+	resp, err := p.exchange(ctx, addr, query)
+	if err != nil || resp.IsEdns0() == nil {
+		return false
+	}
+	for _, option := range resp.IsEdns0().Option {
+		if _, ok := option.(*dns.EDNS0_PADDING); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange sends the query and validates the response.
+func (p *Prober) exchange(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
+	defer cancel()
+	resp, err := p.transport().Query(ctx, addr, query)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateResponse(query, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
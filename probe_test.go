@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// probeTestServer configures the behavior of the server mocked in
+// [TestProber_Probe], which answers using the given protocols.
+type probeTestServer struct {
+	protocols  []Protocol
+	edns       bool
+	cookies    bool
+	padding    bool
+	dnssec     bool
+	validating bool
+}
+
+func TestProber_Probe(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		server    probeTestServer
+		addresses []string
+		supported []Protocol
+		expected  ProbeReport
+	}{{
+		name: "full-featured server",
+		host: "8.8.8.8",
+		server: probeTestServer{
+			protocols: []Protocol{ProtocolUDP, ProtocolTCP, ProtocolDoT, ProtocolDoH},
+			edns:      true, cookies: true, padding: true, dnssec: true, validating: true,
+		},
+		addresses: []string{"8.8.8.8:53", "8.8.8.8:53", "8.8.8.8:853", "https://8.8.8.8/dns-query"},
+		supported: []Protocol{ProtocolUDP, ProtocolTCP, ProtocolDoT, ProtocolDoH},
+		expected:  ProbeReport{EDNS: true, Cookies: true, Padding: true, DNSSEC: true, Validating: true},
+	}, {
+		name: "legacy server",
+		host: "2001:db8::1",
+		server: probeTestServer{
+			protocols: []Protocol{ProtocolUDP, ProtocolTCP},
+		},
+		addresses: []string{"[2001:db8::1]:53", "[2001:db8::1]:53", "[2001:db8::1]:853", "https://[2001:db8::1]/dns-query"},
+		supported: []Protocol{ProtocolUDP, ProtocolTCP},
+	}, {
+		name: "DoH-only server",
+		host: "dns.example.com",
+		server: probeTestServer{
+			protocols: []Protocol{ProtocolDoH},
+			edns:      true, dnssec: true,
+		},
+		addresses: []string{"dns.example.com:53", "dns.example.com:53", "dns.example.com:853", "https://dns.example.com/dns-query"},
+		supported: []Protocol{ProtocolDoH},
+		expected:  ProbeReport{EDNS: true, DNSSEC: true},
+	}, {
+		name:      "unreachable server",
+		host:      "192.0.2.1",
+		addresses: []string{"192.0.2.1:53", "192.0.2.1:53", "192.0.2.1:853", "https://192.0.2.1/dns-query"},
+	}}
+
+	// newTransport returns a transport mocking the given server.
+	newTransport := func(server probeTestServer) *MockResolverTransport {
+		expectedErr := errors.New("connection refused")
+		return &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				supported := false
+				for _, protocol := range server.protocols {
+					supported = supported || protocol == addr.Protocol
+				}
+				if !supported {
+					return nil, expectedErr
+				}
+				resp := new(dns.Msg)
+				resp.SetReply(query)
+				qopt := query.IsEdns0()
+				if qopt == nil || !server.edns {
+					return resp, nil
+				}
+				resp.SetEdns0(qopt.UDPSize(), qopt.Do())
+				for _, option := range qopt.Option {
+					switch option := option.(type) {
+					case *dns.EDNS0_COOKIE:
+						if server.cookies {
+							cookie := &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: option.Cookie + "0102030405060708"}
+							resp.IsEdns0().Option = append(resp.IsEdns0().Option, cookie)
+						}
+					case *dns.EDNS0_PADDING:
+						if server.padding {
+							resp.IsEdns0().Option = append(resp.IsEdns0().Option, &dns.EDNS0_PADDING{Padding: make([]byte, 8)})
+						}
+					}
+				}
+				if qopt.Do() && server.dnssec {
+					resp.Answer = append(resp.Answer, &dns.RRSIG{
+						Hdr:         dns.RR_Header{Name: ".", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET},
+						TypeCovered: dns.TypeSOA,
+					})
+				}
+				resp.AuthenticatedData = query.AuthenticatedData && server.validating
+				return resp, nil
+			},
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			prober := &Prober{
+				Transport: newTransport(tt.server),
+				TimeNow: func() time.Time {
+					now = now.Add(time.Millisecond)
+					return now
+				},
+			}
+			report := prober.Probe(context.Background(), tt.host)
+
+			var addresses []string
+			var supported []Protocol
+			for _, result := range report.Protocols {
+				addresses = append(addresses, result.Addr.Address)
+				assert.Equal(t, time.Millisecond, result.RTT)
+				assert.Equal(t, result.Supported, result.Err == nil)
+				if result.Supported {
+					supported = append(supported, result.Addr.Protocol)
+				}
+			}
+			assert.Equal(t, tt.addresses, addresses)
+			assert.Equal(t, tt.supported, supported)
+			for _, protocol := range tt.supported {
+				assert.True(t, report.Supports(protocol))
+			}
+			report.Protocols = nil
+			assert.Equal(t, tt.expected, *report)
+		})
+	}
+}
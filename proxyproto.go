@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is the default maximum amount of time
+// [*ProxyListener] waits for the PROXY protocol header.
+const DefaultProxyHeaderTimeout = 10 * time.Second
+
+// ErrInvalidProxyHeader indicates that a message or a connection did
+// not start with a valid PROXY protocol version 2 header.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxySignature is the signature starting PROXY protocol version 2 headers.
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyHeader is a PROXY protocol version 2 header, which load balancers
+// and proxies send before the proxied data to tell the server the original
+// source and destination addresses.
+type ProxyHeader struct {
+	// Local is true when the proxy itself originated the connection, e.g.,
+	// to perform health checks, in which case the addresses are invalid.
+	Local bool
+
+	// Source is the original client address.
+	Source netip.AddrPort
+
+	// Destination is the original server address.
+	Destination netip.AddrPort
+}
+
+// ReadProxyHeader reads a PROXY protocol version 2 header from the given
+// reader, leaving the proxied data in the reader. Servers receiving DNS over
+// UDP from a proxy can use this function with a [*bytes.Reader] wrapping each
+// datagram, while servers accepting connections can use [*ProxyListener].
+//
+// We return the original addresses for the TCP and UDP over IPv4 and IPv6
+// address families, and invalid addresses for the other address families,
+// as documented by the specification. We ignore the TLVs.
+func ReadProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	// 1. read and check the fixed-size part of the header
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(fixed[:12], proxySignature) {
+		return nil, fmt.Errorf("%w: missing signature", ErrInvalidProxyHeader)
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("%w: unsupported version: %d", ErrInvalidProxyHeader, version)
+	}
+	command := fixed[12] & 0x0f
+	if command > 1 {
+		return nil, fmt.Errorf("%w: unsupported command: %d", ErrInvalidProxyHeader, command)
+	}
+
+	// 2. read the variable-size part of the header
+	rest := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	header := &ProxyHeader{Local: command == 0}
+	if header.Local {
+		return header, nil
+	}
+
+	// 3. parse the addresses
+	var size int
+	switch family := fixed[13] >> 4; family {
+	case 1: // AF_INET
+		size = 4
+	case 2: // AF_INET6
+		size = 16
+	default:
+		return header, nil
+	}
+	if len(rest) < 2*size+4 {
+		return nil, fmt.Errorf("%w: truncated addresses", ErrInvalidProxyHeader)
+	}
+	srcAddr, _ := netip.AddrFromSlice(rest[:size])
+	dstAddr, _ := netip.AddrFromSlice(rest[size : 2*size])
+	header.Source = netip.AddrPortFrom(srcAddr, binary.BigEndian.Uint16(rest[2*size:]))
+	header.Destination = netip.AddrPortFrom(dstAddr, binary.BigEndian.Uint16(rest[2*size+2:]))
+	return header, nil
+}
+
+// ProxyListener wraps a TCP listener behind a load balancer or proxy using
+// the PROXY protocol version 2, such that the RemoteAddr and LocalAddr of
+// the accepted connections return the original client and server addresses.
+// For DNS over TLS, wrap the [*ProxyListener] using [tls.NewListener].
+//
+// We read the header when first reading from the connection or obtaining
+// its addresses, rather than in Accept, so that slow clients do not block
+// accepting connections. When the header is invalid, reading fails with
+// [ErrInvalidProxyHeader] and the connection addresses are the ones of the
+// proxy, thus the server should close the connection. Obtaining the
+// addresses may block for up to HeaderTimeout while reading the header.
+type ProxyListener struct {
+	// Listener is the MANDATORY underlying listener.
+	net.Listener
+
+	// HeaderTimeout is the optional maximum amount of time to wait for the
+	// header. While reading the header, we use this timeout instead of the
+	// read deadline set using SetDeadline or SetReadDeadline, which we
+	// restore once we have read the header.
+	//
+	// If zero, we use [DefaultProxyHeaderTimeout].
+	HeaderTimeout time.Duration
+}
+
+// Accept implements [net.Listener].
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &proxyConn{Conn: conn, timeout: timeout}, nil
+}
+
+// proxyConn is a connection accepted by [*ProxyListener].
+type proxyConn struct {
+	net.Conn
+
+	// err is the error reading the header.
+	err error
+
+	// header is the header, if we successfully read it.
+	header *ProxyHeader
+
+	// once ensures we read the header once.
+	once sync.Once
+
+	// mu provides mutual exclusion for deadline.
+	mu sync.Mutex
+
+	// deadline is the read deadline set by the caller.
+	deadline time.Time
+
+	// timeout is the timeout for reading the header.
+	timeout time.Duration
+}
+
+// readHeader reads the header once.
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = ReadProxyHeader(c.Conn)
+		c.mu.Lock()
+		_ = c.Conn.SetReadDeadline(c.deadline)
+		c.mu.Unlock()
+	})
+}
+
+// SetDeadline implements [net.Conn].
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements [net.Conn].
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Read implements [net.Conn].
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr implements [net.Conn]. When we have not read the header yet,
+// we read it, thus this method may block for up to the header timeout.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.err == nil && c.header.Source.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr implements [net.Conn]. Like RemoteAddr, this
+// method may block for up to the header timeout.
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.readHeader(); c.err == nil && c.header.Destination.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Destination)
+	}
+	return c.Conn.LocalAddr()
+}
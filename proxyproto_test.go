@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	// header returns a header with the given version and command, address
+	// family, and variable-size part, which may contain src and dst.
+	header := func(verCmd, family byte, src, dst string, tlvs ...byte) []byte {
+		var rest []byte
+		if src != "" {
+			srcAP, dstAP := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+			rest = append(srcAP.Addr().AsSlice(), dstAP.Addr().AsSlice()...)
+			rest = binary.BigEndian.AppendUint16(rest, srcAP.Port())
+			rest = binary.BigEndian.AppendUint16(rest, dstAP.Port())
+		}
+		rest = append(rest, tlvs...)
+		data := append([]byte{}, proxySignature...)
+		data = append(data, verCmd, family)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(rest)))
+		return append(data, rest...)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected *ProxyHeader
+		err      error
+	}{{
+		name: "TCP over IPv4",
+		data: header(0x21, 0x11, "192.0.2.1:50000", "198.51.100.1:53"),
+		expected: &ProxyHeader{
+			Source:      netip.MustParseAddrPort("192.0.2.1:50000"),
+			Destination: netip.MustParseAddrPort("198.51.100.1:53"),
+		},
+	}, {
+		name: "UDP over IPv6 with TLVs",
+		data: header(0x21, 0x22, "[2001:db8::1]:50000", "[2001:db8::53]:53", 0x04, 0x00, 0x01, 0x00),
+		expected: &ProxyHeader{
+			Source:      netip.MustParseAddrPort("[2001:db8::1]:50000"),
+			Destination: netip.MustParseAddrPort("[2001:db8::53]:53"),
+		},
+	}, {
+		name:     "LOCAL command",
+		data:     header(0x20, 0x00, "", ""),
+		expected: &ProxyHeader{Local: true},
+	}, {
+		name:     "unspecified address family",
+		data:     header(0x21, 0x00, "", ""),
+		expected: &ProxyHeader{},
+	}, {
+		name: "missing signature",
+		data: []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"),
+		err:  ErrInvalidProxyHeader,
+	}, {
+		name: "unsupported version",
+		data: header(0x11, 0x11, "192.0.2.1:50000", "198.51.100.1:53"),
+		err:  ErrInvalidProxyHeader,
+	}, {
+		name: "unsupported command",
+		data: header(0x22, 0x11, "192.0.2.1:50000", "198.51.100.1:53"),
+		err:  ErrInvalidProxyHeader,
+	}, {
+		name: "truncated addresses",
+		data: header(0x21, 0x21, "192.0.2.1:50000", "198.51.100.1:53"),
+		err:  ErrInvalidProxyHeader,
+	}, {
+		name: "truncated header",
+		data: header(0x21, 0x11, "192.0.2.1:50000", "198.51.100.1:53")[:20],
+		err:  io.ErrUnexpectedEOF,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(append(tt.data, "payload"...))
+			header, err := ReadProxyHeader(r)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.expected, header)
+			if err == nil {
+				rest, _ := io.ReadAll(r)
+				assert.Equal(t, "payload", string(rest))
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	// newListener returns a listener and a function accepting a
+	// connection after a client wrote the given data.
+	newListener := func(t *testing.T, timeout time.Duration) func(data []byte) net.Conn {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		listener := &ProxyListener{Listener: ln, HeaderTimeout: timeout}
+		return func(data []byte) net.Conn {
+			client, err := net.Dial("tcp", ln.Addr().String())
+			assert.NoError(t, err)
+			t.Cleanup(func() { client.Close() })
+			_, err = client.Write(data)
+			assert.NoError(t, err)
+			conn, err := listener.Accept()
+			assert.NoError(t, err)
+			t.Cleanup(func() { conn.Close() })
+			return conn
+		}
+	}
+
+	t.Run("we use the original addresses", func(t *testing.T) {
+		accept := newListener(t, 0)
+		header := append([]byte{}, proxySignature...)
+		header = append(header, 0x21, 0x11, 0, 12, 192, 0, 2, 1, 198, 51, 100, 1, 0xC3, 0x50, 0, 53)
+		conn := accept(append(header, "payload"...))
+		assert.Equal(t, "192.0.2.1:50000", conn.RemoteAddr().String())
+		assert.Equal(t, "198.51.100.1:53", conn.LocalAddr().String())
+		data := make([]byte, len("payload"))
+		_, err := io.ReadFull(conn, data)
+		assert.NoError(t, err)
+		assert.Equal(t, "payload", string(data))
+	})
+
+	t.Run("we use the proxy addresses for LOCAL connections", func(t *testing.T) {
+		accept := newListener(t, 0)
+		conn := accept(append(append([]byte{}, proxySignature...), 0x20, 0x00, 0, 0))
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		accept := newListener(t, 0)
+		conn := accept([]byte("not a PROXY protocol header"))
+		_, err := conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+		assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+	})
+
+	t.Run("header timeout", func(t *testing.T) {
+		accept := newListener(t, 10*time.Millisecond)
+		conn := accept(nil)
+		_, err := conn.Read(make([]byte, 1))
+		var netErr net.Error
+		assert.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+	})
+
+	t.Run("we restore the read deadline after reading the header", func(t *testing.T) {
+		accept := newListener(t, 0)
+		conn := accept(append(append([]byte{}, proxySignature...), 0x20, 0x00, 0, 0))
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+		_, err := conn.Read(make([]byte, 1))
+		var netErr net.Error
+		assert.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+	})
+}
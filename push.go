@@ -0,0 +1,357 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DSO TLV types defined by RFC 8765 for DNS Push Notifications.
+const (
+	// DSOTypeSubscribe is the SUBSCRIBE TLV type.
+	DSOTypeSubscribe = uint16(0x0040)
+
+	// DSOTypePush is the PUSH TLV type.
+	DSOTypePush = uint16(0x0041)
+
+	// DSOTypeUnsubscribe is the UNSUBSCRIBE TLV type.
+	DSOTypeUnsubscribe = uint16(0x0042)
+)
+
+// TTL values of the RRs in a PUSH TLV indicating removals.
+const (
+	// pushTTLRemove indicates removing a single RR.
+	pushTTLRemove = math.MaxUint32
+
+	// pushTTLRemoveAll indicates removing all the RRs of a given name
+	// and type, or all the RRs of a given name when the type is ANY.
+	pushTTLRemoveAll = math.MaxUint32 - 1
+)
+
+// ErrPushSubscribe indicates that the server refused a subscription.
+var ErrPushSubscribe = errors.New("push subscription failed")
+
+// PushEventKind is the kind of a [*PushEvent].
+type PushEventKind int
+
+const (
+	// PushEventAdd indicates that the server added the RR.
+	PushEventAdd = PushEventKind(iota)
+
+	// PushEventRemove indicates that the server removed the RR.
+	PushEventRemove
+
+	// PushEventRemoveAll indicates that the server removed all the RRs
+	// with the name and type of the RR, which has no data, or all the
+	// RRs with the name of the RR when its type is [dns.TypeANY].
+	PushEventRemoveAll
+)
+
+// String returns the string representation of the kind.
+func (k PushEventKind) String() string {
+	switch k {
+	case PushEventAdd:
+		return "add"
+	case PushEventRemove:
+		return "remove"
+	case PushEventRemoveAll:
+		return "remove_all"
+	default:
+		return "unknown"
+	}
+}
+
+// PushEvent is a change notification received by a [*PushSubscription].
+type PushEvent struct {
+	// Kind is the kind of change.
+	Kind PushEventKind
+
+	// RR is the RR the change applies to. For removals,
+	// we set the TTL of the RR to zero.
+	RR dns.RR
+}
+
+// PushClient is a DNS Push Notifications (RFC 8765) client, which allows to
+// subscribe to the changes of the RRs of a given name and type, rather than
+// polling, e.g., for DNS-based service discovery.
+//
+// Construct using [*Transport.NewPushClient].
+type PushClient struct {
+	// session is the underlying DSO session.
+	session *DSOSession
+
+	// mu protects session, while it is being set, and subs.
+	mu sync.Mutex
+
+	// subs contains the active subscriptions.
+	subs []*PushSubscription
+}
+
+// NewPushClient establishes a [*DSOSession] with the given [ProtocolTCP] or
+// [ProtocolDoT] server for receiving DNS Push Notifications. RFC 8765 requires
+// using TLS except in controlled environments. Close the client when done.
+func (t *Transport) NewPushClient(ctx context.Context, addr *ServerAddr) (*PushClient, error) {
+	c := &PushClient{}
+	session, err := t.NewDSOSession(ctx, addr, c.handle)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	go func() {
+		<-session.Done()
+		c.closeAll()
+	}()
+	return c, nil
+}
+
+// PushSubscription is a subscription created by [*PushClient.Subscribe].
+type PushSubscription struct {
+	// client is the client that created the subscription.
+	client *PushClient
+
+	// events receives the events.
+	events chan *PushEvent
+
+	// id is the message ID of the SUBSCRIBE request.
+	id uint16
+
+	// name is the canonical subscribed name.
+	name string
+
+	// qtype is the subscribed type.
+	qtype uint16
+
+	// stop is closed when the subscription ends.
+	stop chan struct{}
+
+	// stopOnce ensures we close stop once.
+	stopOnce sync.Once
+
+	// wake wakes up the goroutine delivering the events.
+	wake chan struct{}
+
+	// mu protects queue.
+	mu sync.Mutex
+
+	// queue contains the events to deliver.
+	queue []*PushEvent
+}
+
+// Subscribe subscribes to the changes of the RRs with the given name and type,
+// which may be [dns.TypeANY] to receive the changes of all the types. The
+// server first sends the RRs currently existing as additions. When the server
+// does not support DNS Push Notifications, this method fails with an error
+// wrapping [ErrDSONotSupported], otherwise, when the server refuses the
+// subscription, with an error wrapping [ErrPushSubscribe].
+func (c *PushClient) Subscribe(ctx context.Context, name string, qtype uint16) (*PushSubscription, error) {
+	// 1. serialize the SUBSCRIBE TLV
+	name = dns.CanonicalName(name)
+	data := make([]byte, 255+4)
+	off, err := dns.PackDomainName(name, data, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	data = binary.BigEndian.AppendUint16(data[:off], qtype)
+	data = binary.BigEndian.AppendUint16(data, dns.ClassINET)
+
+	// 2. register the subscription before subscribing, since the server
+	// may push the existing RRs right after responding
+	sub := &PushSubscription{
+		client: c,
+		events: make(chan *PushEvent),
+		name:   name,
+		qtype:  qtype,
+		stop:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+	}
+	c.mu.Lock()
+	c.subs = append(c.subs, sub)
+	c.mu.Unlock()
+	go sub.deliver()
+
+	// 3. subscribe and handle failures
+	resp, err := c.session.Request(ctx, DSOTLV{Type: DSOTypeSubscribe, Data: data})
+	switch {
+	case err != nil:
+		c.remove(sub)
+		return nil, err
+	case resp.Rcode == RcodeDSOTypeNotImplemented:
+		c.remove(sub)
+		return nil, fmt.Errorf("%w: rcode %d", ErrDSONotSupported, resp.Rcode)
+	case resp.Rcode != dns.RcodeSuccess:
+		c.remove(sub)
+		return nil, fmt.Errorf("%w: %s", ErrPushSubscribe, dns.RcodeToString[resp.Rcode])
+	}
+	sub.id = resp.ID
+	return sub, nil
+}
+
+// handle handles the unidirectional messages of the session.
+func (c *PushClient) handle(msg *DSOMessage) {
+	primary, _ := msg.PrimaryTLV()
+	if primary.Type != DSOTypePush {
+		return
+	}
+	events, err := parsePushTLV(primary)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		// RFC 8765 requires treating malformed PUSH messages as protocol errors
+		if c.session != nil {
+			c.session.fail(ConnCloseError, err)
+		}
+		return
+	}
+	for _, sub := range c.subs {
+		var matching []*PushEvent
+		for _, ev := range events {
+			if sub.matches(ev) {
+				matching = append(matching, ev)
+			}
+		}
+		if len(matching) > 0 {
+			sub.enqueue(matching)
+		}
+	}
+}
+
+// parsePushTLV parses the events contained in a PUSH TLV.
+func parsePushTLV(tlv DSOTLV) ([]*PushEvent, error) {
+	var events []*PushEvent
+	for off := 0; off < len(tlv.Data); {
+		rr, next, err := dns.UnpackRR(tlv.Data, off)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid PUSH TLV: %s", ErrInvalidDSOMessage, err.Error())
+		}
+		off = next
+		ev := &PushEvent{Kind: PushEventAdd, RR: rr}
+		switch rr.Header().Ttl {
+		case pushTTLRemove:
+			ev.Kind = PushEventRemove
+		case pushTTLRemoveAll:
+			ev.Kind = PushEventRemoveAll
+		}
+		if ev.Kind != PushEventAdd {
+			rr.Header().Ttl = 0
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// matches returns whether the event applies to the subscription.
+func (sub *PushSubscription) matches(ev *PushEvent) bool {
+	hdr := ev.RR.Header()
+	if !strings.EqualFold(hdr.Name, sub.name) {
+		return false
+	}
+	return sub.qtype == dns.TypeANY || hdr.Rrtype == sub.qtype ||
+		(ev.Kind == PushEventRemoveAll && hdr.Rrtype == dns.TypeANY)
+}
+
+// enqueue adds the events to the queue of the events to deliver.
+func (sub *PushSubscription) enqueue(events []*PushEvent) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, events...)
+	sub.mu.Unlock()
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver delivers the queued events until the subscription ends. Since
+// we queue the events without bounds, a slow consumer does not prevent
+// the session from reading the other messages, e.g., keepalives.
+func (sub *PushSubscription) deliver() {
+	defer close(sub.events)
+	for {
+		sub.mu.Lock()
+		queue := sub.queue
+		sub.queue = nil
+		sub.mu.Unlock()
+		for _, ev := range queue {
+			select {
+			case sub.events <- ev:
+			case <-sub.stop:
+				return
+			}
+		}
+		select {
+		case <-sub.wake:
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+// Events returns the channel receiving the events, which we close when
+// the subscription ends because of [*PushSubscription.Unsubscribe], or
+// because the session ended, in which case [*PushClient.Err] is not nil.
+func (sub *PushSubscription) Events() <-chan *PushEvent {
+	return sub.events
+}
+
+// Unsubscribe cancels the subscription.
+func (sub *PushSubscription) Unsubscribe(ctx context.Context) error {
+	if !sub.client.remove(sub) {
+		return nil
+	}
+	data := binary.BigEndian.AppendUint16(nil, sub.id)
+	return sub.client.session.Send(ctx, DSOTLV{Type: DSOTypeUnsubscribe, Data: data})
+}
+
+// end ends the subscription.
+func (sub *PushSubscription) end() {
+	sub.stopOnce.Do(func() { close(sub.stop) })
+}
+
+// remove ends and removes the given subscription, returning
+// whether the subscription was still active.
+func (c *PushClient) remove(sub *PushSubscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	idx := slices.Index(c.subs, sub)
+	if idx < 0 {
+		return false
+	}
+	c.subs = slices.Delete(c.subs, idx, idx+1)
+	sub.end()
+	return true
+}
+
+// closeAll ends all the subscriptions.
+func (c *PushClient) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, sub := range c.subs {
+		sub.end()
+	}
+	c.subs = nil
+}
+
+// Done returns a channel closed when the session with the server ends.
+func (c *PushClient) Done() <-chan struct{} {
+	return c.session.Done()
+}
+
+// Err returns the error that ended the session, if any. See [*DSOSession.Err].
+func (c *PushClient) Err() error {
+	return c.session.Err()
+}
+
+// Close closes the session, ending all the subscriptions.
+func (c *PushClient) Close() error {
+	return c.session.Close()
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushEventKind_String(t *testing.T) {
+	tests := []struct {
+		kind     PushEventKind
+		expected string
+	}{
+		{PushEventAdd, "add"},
+		{PushEventRemove, "remove"},
+		{PushEventRemoveAll, "remove_all"},
+		{PushEventKind(100), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.kind.String())
+		})
+	}
+}
+
+func TestPushClient(t *testing.T) {
+	// pushTLV returns a PUSH TLV containing the given RRs.
+	pushTLV := func(rrs ...string) DSOTLV {
+		data := make([]byte, 4096)
+		var off int
+		for _, text := range rrs {
+			rr, _ := dns.NewRR(text)
+			off, _ = dns.PackRR(rr, data, off, nil, false)
+		}
+		return DSOTLV{Type: DSOTypePush, Data: data[:off]}
+	}
+
+	t.Run("subscriptions", func(t *testing.T) {
+		unsubscribed := make(chan uint16, 1)
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			primary, _ := msg.PrimaryTLV()
+			switch primary.Type {
+			case DSOTypeSubscribe:
+				name, off, _ := dns.UnpackDomainName(primary.Data, 0)
+				if name != "example.com." || binary.BigEndian.Uint16(primary.Data[off:]) != dns.TypeA {
+					writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true, Rcode: dns.RcodeRefused})
+					return
+				}
+				writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true})
+				writeDSOTestMessage(conn, &DSOMessage{TLVs: []DSOTLV{pushTLV(
+					"example.com. 300 IN A 93.184.215.14",
+					"example.com. 300 IN AAAA 2001:db8::1",
+					"example.org. 300 IN A 10.0.0.1",
+				)}})
+				writeDSOTestMessage(conn, &DSOMessage{TLVs: []DSOTLV{pushTLV(
+					"EXAMPLE.com. 4294967295 IN A 93.184.215.14",
+					"example.com. 4294967294 IN TYPE255 \\# 0",
+				)}})
+			case DSOTypeUnsubscribe:
+				unsubscribed <- binary.BigEndian.Uint16(primary.Data)
+			}
+		}))
+		client, err := (&Transport{}).NewPushClient(context.Background(), addr)
+		assert.NoError(t, err)
+		defer client.Close()
+
+		_, err = client.Subscribe(context.Background(), "example.org", dns.TypeA)
+		assert.ErrorIs(t, err, ErrPushSubscribe)
+
+		sub, err := client.Subscribe(context.Background(), "Example.COM", dns.TypeA)
+		assert.NoError(t, err)
+		var events []string
+		for len(events) < 3 {
+			ev := <-sub.Events()
+			events = append(events, ev.Kind.String()+" "+ev.RR.String())
+		}
+		assert.Equal(t, []string{
+			"add example.com.\t300\tIN\tA\t93.184.215.14",
+			"remove EXAMPLE.com.\t0\tIN\tA\t93.184.215.14",
+			"remove_all example.com.\t0\tIN\tANY\t",
+		}, events)
+
+		assert.NoError(t, sub.Unsubscribe(context.Background()))
+		assert.Equal(t, sub.id, <-unsubscribed)
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+		assert.NoError(t, sub.Unsubscribe(context.Background()))
+		assert.NoError(t, client.Err())
+	})
+
+	t.Run("servers not supporting push", func(t *testing.T) {
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true, Rcode: RcodeDSOTypeNotImplemented})
+		}))
+		client, err := (&Transport{}).NewPushClient(context.Background(), addr)
+		assert.NoError(t, err)
+		defer client.Close()
+		_, err = client.Subscribe(context.Background(), "example.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrDSONotSupported)
+	})
+
+	t.Run("malformed PUSH messages end the subscriptions", func(t *testing.T) {
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, func(conn net.Conn, msg *DSOMessage) {
+			writeDSOTestMessage(conn, &DSOMessage{ID: msg.ID, Response: true})
+			writeDSOTestMessage(conn, &DSOMessage{TLVs: []DSOTLV{{Type: DSOTypePush, Data: []byte{1}}}})
+		}))
+		client, err := (&Transport{}).NewPushClient(context.Background(), addr)
+		assert.NoError(t, err)
+		defer client.Close()
+		sub, err := client.Subscribe(context.Background(), "example.com", dns.TypeANY)
+		assert.NoError(t, err)
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+		<-client.Done()
+		assert.ErrorIs(t, client.Err(), ErrInvalidDSOMessage)
+	})
+
+	t.Run("connection failures", func(t *testing.T) {
+		addr := NewServerAddr(ProtocolUDP, "127.0.0.1:53")
+		_, err := (&Transport{}).NewPushClient(context.Background(), addr)
+		assert.ErrorIs(t, err, ErrNoSuchTransportProtocol)
+	})
+
+	t.Run("invalid names", func(t *testing.T) {
+		addr, _ := startStreamServer(t, serveDSOTestSession(time.Minute, DSOInfinity, nil))
+		client, err := (&Transport{}).NewPushClient(context.Background(), addr)
+		assert.NoError(t, err)
+		defer client.Close()
+		_, err = client.Subscribe(context.Background(), "a..example.com", dns.TypeA)
+		assert.Error(t, err)
+	})
+}
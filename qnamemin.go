@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import "github.com/miekg/dns"
+
+// Limits to the number of minimized queries as suggested by RFC 9156 Sect. 2.3.
+const (
+	// iterativeMaxMinimiseCount is the maximum number of minimized queries
+	// we send for a single name before sending the full name.
+	iterativeMaxMinimiseCount = 10
+
+	// iterativeMinimiseOneLab is the number of minimized queries for
+	// which we only add a single label to the name.
+	iterativeMinimiseOneLab = 4
+)
+
+// iterativeQNameMinimizer implements QNAME minimization (see RFC 9156)
+// while the [*IterativeResolver] walks delegations.
+type iterativeQNameMinimizer struct {
+	// count is the number of minimized questions we returned.
+	count int
+
+	// enabled indicates whether we should minimize.
+	enabled bool
+
+	// known is the number of labels of the longest name we know
+	// about, i.e., either a zone cut or a name that is not a cut.
+	known int
+
+	// q0 is the original question.
+	q0 dns.Question
+}
+
+// newIterativeQNameMinimizer creates a minimizer for the given question
+// starting from the given zone, which is a parent of the question name.
+func newIterativeQNameMinimizer(q0 dns.Question, zone string, enabled bool) *iterativeQNameMinimizer {
+	return &iterativeQNameMinimizer{
+		enabled: enabled,
+		known:   dns.CountLabel(zone),
+		q0:      q0,
+	}
+}
+
+// question returns the next question to send. We add one label at
+// a time for the first questions, then we add more labels at a time
+// such that we never send more than [iterativeMaxMinimiseCount]
+// minimized questions. The returned question is the original one when
+// minimization is disabled or we have reached the full name.
+func (qm *iterativeQNameMinimizer) question() dns.Question {
+	labels := dns.CountLabel(qm.q0.Name)
+	if !qm.enabled || qm.known >= labels-1 || qm.count >= iterativeMaxMinimiseCount {
+		return qm.q0
+	}
+	step := 1
+	if qm.count >= iterativeMinimiseOneLab {
+		step = max(1, (labels-qm.known)/(iterativeMaxMinimiseCount-qm.count))
+	}
+	if qm.known+step >= labels {
+		return qm.q0
+	}
+	qm.count++
+	indexes := dns.Split(qm.q0.Name)
+	name := qm.q0.Name[indexes[labels-qm.known-step]:]
+	return dns.Question{Name: name, Qtype: dns.TypeA, Qclass: qm.q0.Qclass}
+}
+
+// advance records that we know about the given name, which is either a
+// zone cut or a name that is not a zone cut, so we can add more labels.
+func (qm *iterativeQNameMinimizer) advance(name string) {
+	qm.known = max(qm.known, dns.CountLabel(name))
+}
+
+// disable disables minimization, such that we send the full name.
+func (qm *iterativeQNameMinimizer) disable() {
+	qm.enabled = false
+}
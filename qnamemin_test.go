@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_iterativeQNameMinimizer(t *testing.T) {
+	t.Run("adds one label at a time", func(t *testing.T) {
+		q0 := dns.Question{Name: "www.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+		qm := newIterativeQNameMinimizer(q0, ".", true)
+
+		question := qm.question()
+		assert.Equal(t, dns.Question{Name: "com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, question)
+		qm.advance(question.Name)
+
+		question = qm.question()
+		assert.Equal(t, "example.com.", question.Name)
+		qm.advance(question.Name)
+
+		assert.Equal(t, q0, qm.question())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		q0 := dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		assert.Equal(t, q0, newIterativeQNameMinimizer(q0, ".", false).question())
+
+		qm := newIterativeQNameMinimizer(q0, ".", true)
+		qm.disable()
+		assert.Equal(t, q0, qm.question())
+	})
+
+	t.Run("bounds the number of minimized questions", func(t *testing.T) {
+		q0 := dns.Question{Name: "a.b.c.d.e.f.g.h.i.j.k.l.m.n.o.p.q.r.s.t.example.com.", Qtype: dns.TypeA}
+		qm := newIterativeQNameMinimizer(q0, ".", true)
+		var count int
+		for question := qm.question(); question != q0; question = qm.question() {
+			qm.advance(question.Name)
+			count++
+		}
+		assert.LessOrEqual(t, count, iterativeMaxMinimiseCount)
+		assert.Greater(t, count, iterativeMinimiseOneLab)
+	})
+}
+
+func TestIterativeResolver_qnameMinimization(t *testing.T) {
+	t.Run("servers only see the labels they need", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		reso := h.resolver()
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{".", "com.", "example.com.", "www.example.com."}, h.qnames)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		reso := h.resolver()
+		reso.DisableQNameMinimization = true
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{".", "www.example.com.", "www.example.com.", "www.example.com."}, h.qnames)
+	})
+
+	t.Run("fallback to the full name on NXDOMAIN", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		reso := h.resolver()
+		rrs, err := reso.Lookup(context.Background(), "host.ent.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+		assert.Equal(t, []string{
+			".", "com.", "example.com.", "ent.example.com.", "host.ent.example.com.",
+		}, h.qnames)
+	})
+}
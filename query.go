@@ -9,6 +9,10 @@
 package dnscore
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+
 	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
 )
@@ -28,6 +32,12 @@ const (
 	//
 	// This flag implies [QueryFlagEDNS0].
 	EDNS0FlagBlockLengthPadding
+
+	// EDNS0FlagExpire requests the zone expire timer by including an
+	// empty EDNS EXPIRE option as defined by RFC 7314. Secondary servers
+	// should use this flag for SOA, AXFR, and IXFR queries and obtain
+	// the timer using [ResponseExpire].
+	EDNS0FlagExpire
 )
 
 // EDNS0SuggestedMaxResponseSizeUDP is the suggested max-response size
@@ -48,33 +58,44 @@ const EDNS0SuggestedMaxResponseSizeOtherwise = 4096
 // 2. DNSSEC using [EDNS0FlagDO].
 //
 // 3. Block-length padding using [EDNS0FlagBlockLengthPadding].
+//
+// 4. The RFC 7314 EXPIRE option using [EDNS0FlagExpire].
 func QueryOptionEDNS0(maxResponseSize uint16, flags int) QueryOption {
 	return func(q *dns.Msg) error {
 		// 1. DNSSEC OK (DO)
 		q.SetEdns0(maxResponseSize, flags&EDNS0FlagDO != 0)
 
-		// 2. padding
-		//
-		// Clients SHOULD pad queries to the closest multiple of
-		// 128 octets RFC8467#section-4.1. We inflate the query
-		// length by the size of the option (i.e. 4 octets). The
-		// cast to uint is necessary to make the modulus operation
-		// work as intended when the desiredBlockSize is smaller
-		// than (query.Len()+4) ¯\_(ツ)_/¯.
-		if flags&EDNS0FlagBlockLengthPadding != 0 {
-			const desiredBlockSize = 128
-			remainder := (desiredBlockSize - uint16(q.Len()+4)) % desiredBlockSize
-			opt := new(dns.EDNS0_PADDING)
-			opt.Padding = make([]byte, remainder)
+		// 2. expire, which we must add before padding
+		if flags&EDNS0FlagExpire != 0 {
+			opt := &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Empty: true}
 			q.IsEdns0().Option = append(q.IsEdns0().Option, opt)
 		}
+
+		// 3. padding
+		if flags&EDNS0FlagBlockLengthPadding != 0 {
+			addBlockLengthPadding(q)
+		}
 		return nil
 	}
 }
 
+// addBlockLengthPadding adds the padding option to a query containing
+// the OPT RR. Clients SHOULD pad queries to the closest multiple of
+// 128 octets RFC8467#section-4.1. We inflate the query length by the
+// size of the option (i.e. 4 octets). The cast to uint is necessary
+// to make the modulus operation work as intended when the desiredBlockSize
+// is smaller than (query.Len()+4) ¯\_(ツ)_/¯.
+func addBlockLengthPadding(q *dns.Msg) {
+	const desiredBlockSize = 128
+	remainder := (desiredBlockSize - uint16(q.Len()+4)) % desiredBlockSize
+	opt := new(dns.EDNS0_PADDING)
+	opt.Padding = make([]byte, remainder)
+	q.IsEdns0().Option = append(q.IsEdns0().Option, opt)
+}
+
 // QueryOptionID allows setting an arbitrary query ID.
 //
-// Otherwise, the default is using [dns.Id] for all protocols
+// Otherwise, the default is using [RandomID] for all protocols
 // except DNS-over-HTTPS and DNS-over-QUIC, where we use
 // zero, thus following RFC 9250 Sect 4.2.1.
 func QueryOptionID(id uint16) QueryOption {
@@ -84,6 +105,58 @@ func QueryOptionID(id uint16) QueryOption {
 	}
 }
 
+// IDGenerator is a function generating the IDs of the queries sent using
+// the protocols requiring a nonzero query ID, such as DNS over UDP.
+type IDGenerator func() uint16
+
+// RandomID is the default [IDGenerator], which uses [crypto/rand] such that
+// off-path attackers cannot predict the IDs of the queries sent using the
+// cleartext protocols and spoof their responses (RFC 5452 Sect. 9.2).
+//
+// Unlike [dns.Id], which is a variable any package could replace, this
+// function cannot be changed, and you should use the IDGenerator field of
+// [*Resolver] to generate the query IDs differently.
+func RandomID() uint16 {
+	var buf [2]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err) // predictable IDs would be worse than crashing
+	}
+	return binary.BigEndian.Uint16(buf[:])
+}
+
+// queryToASCII IDNA encodes the given name, except for the ASCII labels
+// starting with an underscore, which IDNA rejects but which service names
+// such as _dns.resolver.arpa and _8443._https.example.com use.
+func queryToASCII(name string) (string, error) {
+	if !strings.Contains(name, "_") {
+		return idna.Lookup.ToASCII(name)
+	}
+	labels := strings.Split(name, ".")
+	for idx, label := range labels {
+		if strings.HasPrefix(label, "_") && isServiceLabel(label[1:]) {
+			labels[idx] = strings.ToLower(label)
+			continue
+		}
+		encoded, err := idna.Lookup.ToASCII(label)
+		if err != nil {
+			return "", err
+		}
+		labels[idx] = encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// isServiceLabel returns whether the given label only contains ASCII
+// letters, digits, hyphens, and underscores.
+func isServiceLabel(label string) bool {
+	for _, c := range label {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
 // NewQueryWithServerAddr constructs a [*dns.Message] containing a
 // query for the given domain, query type and [*ServerAddr]. We use
 // the [*ServerAddr] to enforce protocol-specific query settings,
@@ -99,8 +172,15 @@ func QueryOptionID(id uint16) QueryOption {
 // The [QueryOption] functions can be used to set additional options.
 func NewQueryWithServerAddr(serverAddr *ServerAddr, name string, qtype uint16,
 	options ...QueryOption) (*dns.Msg, error) {
+	return newQueryWithIDGenerator(serverAddr, name, qtype, RandomID, options...)
+}
+
+// newQueryWithIDGenerator is like [NewQueryWithServerAddr] but uses the
+// given [IDGenerator] for the protocols requiring a nonzero query ID.
+func newQueryWithIDGenerator(serverAddr *ServerAddr, name string, qtype uint16,
+	idGenerator IDGenerator, options ...QueryOption) (*dns.Msg, error) {
 	// IDNA encode the domain name.
-	punyName, err := idna.Lookup.ToASCII(name)
+	punyName, err := queryToASCII(name)
 	if err != nil {
 		return nil, err
 	}
@@ -125,11 +205,11 @@ func NewQueryWithServerAddr(serverAddr *ServerAddr, name string, qtype uint16,
 	// require a nonzero queryID to be set.
 	// TODO(bassosimone,roopeshsn): update for DoQ
 	switch serverAddr.Protocol {
-	case ProtocolDoH:
+	case ProtocolDoH, ProtocolHTTP, ProtocolH2C:
 		// for DoH/DoQ, by default we leave the query ID to
 		// zero, which is what the RFCs suggest/require.
 	default:
-		query.Id = dns.Id()
+		query.Id = idGenerator()
 	}
 
 	// Apply the query options.
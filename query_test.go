@@ -68,6 +68,21 @@ func TestNewQueryWithServerAddr(t *testing.T) {
 			qtype:      dns.TypeA,
 			wantErr:    true,
 		},
+		{
+			name:       "service name with underscores",
+			serverAddr: NewServerAddr(ProtocolUDP, "8.8.8.8:53"),
+			qname:      "_8443._HTTPS.bücher.example",
+			qtype:      dns.TypeHTTPS,
+			wantName:   "_8443._https.xn--bcher-kva.example.",
+			wantId:     expectedNonZeroQueryID,
+		},
+		{
+			name:       "invalid service name",
+			serverAddr: NewServerAddr(ProtocolUDP, "8.8.8.8:53"),
+			qname:      "_invalid service.example.com",
+			qtype:      dns.TypeA,
+			wantErr:    true,
+		},
 		{
 			name:       "with failing option",
 			serverAddr: NewServerAddr(ProtocolUDP, "8.8.8.8:53"),
@@ -158,3 +173,14 @@ func TestQueryOptionID(t *testing.T) {
 		t.Errorf("QueryOptionID() did not set ID")
 	}
 }
+
+func TestRandomID(t *testing.T) {
+	// with 64 IDs, all of them being equal means we are not using randomness
+	ids := make(map[uint16]bool)
+	for range 64 {
+		ids[RandomID()] = true
+	}
+	if len(ids) <= 1 {
+		t.Errorf("RandomID() returned the same ID %d times", 64)
+	}
+}
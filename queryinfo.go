@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryInfo contains metadata about a query performed
+// using [*Transport.QueryWithInfo].
+//
+// Timestamps are zero when the corresponding event did not
+// occur or cannot be observed with the given protocol. For example,
+// DNSLookupStart and DNSLookupDone are only available for
+// [ProtocolDoH], and a custom DialTLSContext prevents separating
+// the TCP connect from the TLS handshake.
+type QueryInfo struct {
+	// Start is when the query started.
+	Start time.Time
+
+	// DNSLookupStart is when we started resolving the server domain name.
+	DNSLookupStart time.Time
+
+	// DNSLookupDone is when we finished resolving the server domain name.
+	DNSLookupDone time.Time
+
+	// ConnectStart is when we started connecting to the server.
+	ConnectStart time.Time
+
+	// ConnectDone is when we finished connecting to the server.
+	ConnectDone time.Time
+
+	// TLSHandshakeStart is when we started the TLS handshake.
+	TLSHandshakeStart time.Time
+
+	// TLSHandshakeDone is when we finished the TLS handshake.
+	TLSHandshakeDone time.Time
+
+	// ConnReused indicates we reused an existing connection, in which case
+	// the connect and handshake timestamps are zero.
+	ConnReused bool
+
+	// QuerySent is when we finished sending the query.
+	QuerySent time.Time
+
+	// FirstByte is when we received the first byte of the response.
+	FirstByte time.Time
+
+	// LastByte is when we received the last byte of the response.
+	LastByte time.Time
+
+	// LocalAddr is the local address of the connection, if known.
+	LocalAddr netip.AddrPort
+
+	// RemoteAddr is the remote address of the connection, if known.
+	RemoteAddr netip.AddrPort
+
+	// RawQuery is the raw query we sent, exactly as it was serialized
+	// and excluding the framing used by stream transports.
+	RawQuery []byte
+
+	// RawResponse is the raw response we received, excluding the framing
+	// used by stream transports. We set this field before parsing the
+	// response, so it is available also when the response is invalid.
+	// For [ProtocolDoHJSON], this field contains the JSON body.
+	RawResponse []byte
+}
+
+// queryTracer collects a [QueryInfo] while a query is in progress.
+//
+// A nil *queryTracer is valid and ignores all events.
+type queryTracer struct {
+	// info is the info being collected.
+	info QueryInfo
+
+	// mu protects info.
+	mu sync.Mutex
+
+	// timeNow returns the current time.
+	timeNow func() time.Time
+}
+
+// newQueryTracer creates a new [*queryTracer] using the given time source.
+func newQueryTracer(timeNow func() time.Time) *queryTracer {
+	tr := &queryTracer{timeNow: timeNow}
+	tr.info.Start = timeNow()
+	return tr
+}
+
+// queryTracerKey is the context key for the [*queryTracer].
+type queryTracerKey struct{}
+
+// withQueryTracer returns a copy of the context containing the given tracer.
+func withQueryTracer(ctx context.Context, tr *queryTracer) context.Context {
+	return context.WithValue(ctx, queryTracerKey{}, tr)
+}
+
+// queryTracerFromContext returns the [*queryTracer] inside the context
+// or nil, which is a valid tracer that ignores events.
+func queryTracerFromContext(ctx context.Context) *queryTracer {
+	tr, _ := ctx.Value(queryTracerKey{}).(*queryTracer)
+	return tr
+}
+
+// stamp calls fn with the info and the current time while holding the mutex.
+func (tr *queryTracer) stamp(fn func(info *QueryInfo, now time.Time)) {
+	if tr != nil {
+		tr.mu.Lock()
+		fn(&tr.info, tr.timeNow())
+		tr.mu.Unlock()
+	}
+}
+
+// snapshot returns a copy of the collected [*QueryInfo].
+func (tr *queryTracer) snapshot() *QueryInfo {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	info := tr.info
+	return &info
+}
+
+// QueryWithInfo is like [*Transport.Query] but also returns a [*QueryInfo]
+// containing metadata about the query, including timing information useful
+// to debug performance issues. The [*QueryInfo] is non-nil and contains the
+// events observed so far even when the query fails, unless the
+// [*Transport] has been closed.
+func (t *Transport) QueryWithInfo(ctx context.Context,
+	addr *ServerAddr, query *dns.Msg) (*dns.Msg, *QueryInfo, error) {
+	ctx, done, err := t.beginQuery(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+
+	tracer := newQueryTracer(t.timeNow)
+	resp, err := t.queryAndRecord(withQueryTracer(ctx, tracer), addr, query)
+	return resp, tracer.snapshot(), err
+}
+
+// queryAndRecord sends the query after [*Transport.beginQuery] succeeded,
+// updating the statistics and logging the errors. Unlike [*Transport.QueryWithInfo],
+// [*Transport.Query] calls this method without adding a tracer to the context,
+// thus avoiding the allocations needed to collect the [*QueryInfo].
+func (t *Transport) queryAndRecord(ctx context.Context,
+	addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	ctx = t.withLogSample(ctx)
+	t.stats.onQuery(addr)
+	resp, err := t.query(ctx, addr, query)
+	if err != nil {
+		t.stats.onError(addr, err)
+		t.maybeLogQueryError(ctx, addr, err)
+	}
+	return resp, err
+}
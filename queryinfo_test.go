@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_QueryWithInfo(t *testing.T) {
+	// newClock returns a TimeNow func that advances
+	// by one second every time it is called.
+	newClock := func() func() time.Time {
+		var (
+			mu  sync.Mutex
+			now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		)
+		return func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			now = now.Add(time.Second)
+			return now
+		}
+	}
+
+	// mockConn returns a mocked conn for the given network
+	// that responds to queries using the given read function.
+	mockConn := func(network string, read func(b []byte) (int, error)) net.Conn {
+		laddr, raddr := netip.MustParseAddrPort("[::1]:54321"), netip.MustParseAddrPort("[::2]:53")
+		return &mocks.Conn{
+			MockWrite:       func(b []byte) (int, error) { return len(b), nil },
+			MockRead:        read,
+			MockClose:       func() error { return nil },
+			MockSetDeadline: func(time.Time) error { return nil },
+			MockLocalAddr: func() net.Addr {
+				if network == "udp" {
+					return net.UDPAddrFromAddrPort(laddr)
+				}
+				return net.TCPAddrFromAddrPort(laddr)
+			},
+			MockRemoteAddr: func() net.Addr {
+				if network == "udp" {
+					return net.UDPAddrFromAddrPort(raddr)
+				}
+				return net.TCPAddrFromAddrPort(raddr)
+			},
+		}
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	query.Id = 0
+
+	t.Run("UDP", func(t *testing.T) {
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return mockConn(network, func(b []byte) (int, error) {
+					rawQuery, _ := query.Pack()
+					return copy(b, newRawResponse(rawQuery, dns.RcodeSuccess)), nil
+				}), nil
+			},
+			TimeNow: newClock(),
+		}
+		addr := NewServerAddr(ProtocolUDP, "[::2]:53")
+
+		resp, info, err := txp.QueryWithInfo(context.Background(), addr, query)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+
+		assert.True(t, info.Start.Before(info.ConnectStart))
+		assert.True(t, info.ConnectStart.Before(info.ConnectDone))
+		assert.True(t, info.ConnectDone.Before(info.QuerySent))
+		assert.True(t, info.QuerySent.Before(info.FirstByte))
+		assert.Equal(t, info.FirstByte, info.LastByte)
+		assert.True(t, info.DNSLookupStart.IsZero())
+		assert.True(t, info.TLSHandshakeStart.IsZero())
+		assert.Equal(t, "[::1]:54321", info.LocalAddr.String())
+		assert.Equal(t, "[::2]:53", info.RemoteAddr.String())
+
+		rawQuery, _ := query.Pack()
+		assert.Equal(t, rawQuery, info.RawQuery)
+		assert.Equal(t, newRawResponse(rawQuery, dns.RcodeSuccess), info.RawResponse)
+	})
+
+	t.Run("invalid response is still available", func(t *testing.T) {
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return mockConn(network, func(b []byte) (int, error) {
+					return copy(b, []byte{0xde, 0xad}), nil
+				}), nil
+			},
+		}
+		addr := NewServerAddr(ProtocolUDP, "[::2]:53")
+
+		// we discard invalid UDP responses until the deadline
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		resp, info, err := txp.QueryWithInfo(ctx, addr, query)
+		assert.Error(t, err)
+		assert.Nil(t, resp)
+		assert.Equal(t, []byte{0xde, 0xad}, info.RawResponse)
+	})
+
+	t.Run("DoT with custom dialer", func(t *testing.T) {
+		txp := &Transport{
+			DialTLSContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return mockConn(network, bytes.NewReader(newValidRawRespFrame()).Read), nil
+			},
+			TimeNow: newClock(),
+		}
+		addr := NewServerAddr(ProtocolDoT, "[::2]:853")
+
+		_, info, err := txp.QueryWithInfo(context.Background(), addr, query)
+		assert.NoError(t, err)
+
+		assert.True(t, info.ConnectStart.Before(info.ConnectDone))
+		assert.True(t, info.TLSHandshakeStart.IsZero())
+		assert.True(t, info.ConnectDone.Before(info.QuerySent))
+		assert.True(t, info.QuerySent.Before(info.FirstByte))
+		assert.True(t, info.FirstByte.Before(info.LastByte))
+		assert.Equal(t, "[::1]:54321", info.LocalAddr.String())
+		assert.Equal(t, newValidRawRespFrame()[2:], info.RawResponse)
+	})
+
+	t.Run("DoH", func(t *testing.T) {
+		txp := &Transport{
+			HTTPClient: &http.Client{
+				Transport: &mocks.HTTPTransport{
+					MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+						rawQuery, err := io.ReadAll(req.Body)
+						if err != nil {
+							return nil, err
+						}
+						trace := httptrace.ContextClientTrace(req.Context())
+						trace.DNSStart(httptrace.DNSStartInfo{})
+						trace.DNSDone(httptrace.DNSDoneInfo{})
+						trace.ConnectStart("tcp", "[::2]:443")
+						trace.ConnectDone("tcp", "[::2]:443", nil)
+						trace.TLSHandshakeStart()
+						trace.TLSHandshakeDone(tls.ConnectionState{}, nil)
+						trace.GotConn(httptrace.GotConnInfo{
+							Conn: mockConn("tcp", nil),
+						})
+						trace.WroteRequest(httptrace.WroteRequestInfo{})
+						trace.GotFirstResponseByte()
+						resp := &http.Response{
+							StatusCode: 200,
+							Header:     make(http.Header),
+							Body:       io.NopCloser(bytes.NewReader(newRawResponse(rawQuery, dns.RcodeSuccess))),
+						}
+						resp.Header.Set("content-type", "application/dns-message")
+						return resp, nil
+					},
+				},
+			},
+			TimeNow: newClock(),
+		}
+		addr := NewServerAddr(ProtocolDoH, "https://dns.google/dns-query")
+
+		_, info, err := txp.QueryWithInfo(context.Background(), addr, query)
+		assert.NoError(t, err)
+
+		events := []time.Time{
+			info.Start,
+			info.DNSLookupStart,
+			info.DNSLookupDone,
+			info.ConnectStart,
+			info.ConnectDone,
+			info.TLSHandshakeStart,
+			info.TLSHandshakeDone,
+			info.QuerySent,
+			info.FirstByte,
+			info.LastByte,
+		}
+		for idx := 1; idx < len(events); idx++ {
+			assert.True(t, events[idx-1].Before(events[idx]), "event %d", idx)
+		}
+		assert.False(t, info.ConnReused)
+		assert.NotEmpty(t, info.RawQuery)
+		assert.NotEmpty(t, info.RawResponse)
+		assert.Equal(t, "[::1]:54321", info.LocalAddr.String())
+		assert.Equal(t, "[::2]:53", info.RemoteAddr.String())
+	})
+
+	t.Run("failure returns partial info", func(t *testing.T) {
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errors.New("mocked error")
+			},
+		}
+		addr := NewServerAddr(ProtocolTCP, "[::2]:53")
+
+		resp, info, err := txp.QueryWithInfo(context.Background(), addr, query)
+		assert.EqualError(t, err, "mocked error")
+		assert.Nil(t, resp)
+		assert.False(t, info.ConnectStart.IsZero())
+		assert.True(t, info.ConnectDone.IsZero())
+	})
+
+	t.Run("closed transport", func(t *testing.T) {
+		txp := &Transport{}
+		assert.NoError(t, txp.Close())
+		_, info, err := txp.QueryWithInfo(context.Background(), NewServerAddr(ProtocolUDP, "[::2]:53"), query)
+		assert.ErrorIs(t, err, ErrTransportClosed)
+		assert.Nil(t, info)
+	})
+}
+
+func Test_queryTracer(t *testing.T) {
+	t.Run("nil tracer ignores events", func(t *testing.T) {
+		var tracer *queryTracer
+		tracer.stamp(func(info *QueryInfo, now time.Time) {
+			t.Fatal("should not be called")
+		})
+	})
+
+	t.Run("context without tracer", func(t *testing.T) {
+		assert.Nil(t, queryTracerFromContext(context.Background()))
+	})
+
+	t.Run("snapshot is a copy", func(t *testing.T) {
+		tracer := newQueryTracer(time.Now)
+		ctx := withQueryTracer(context.Background(), tracer)
+		assert.Equal(t, tracer, queryTracerFromContext(ctx))
+		snapshot := tracer.snapshot()
+		tracer.stamp(func(info *QueryInfo, now time.Time) { info.ConnReused = true })
+		assert.False(t, snapshot.ConnReused)
+	})
+}
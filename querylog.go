@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Default number of bits of the client subnets written by [*QueryLogTransport].
+const (
+	DefaultQueryLogClientSubnetBitsIPv4 = 24
+	DefaultQueryLogClientSubnetBitsIPv6 = 48
+)
+
+// QueryLogEntry is an entry written by [*QueryLogTransport]. We serialize
+// entries as JSON, one per line, which log pipelines easily ingest.
+type QueryLogEntry struct {
+	// Time is when we sent the query.
+	Time time.Time `json:"t"`
+
+	// ServerAddr is the server address.
+	ServerAddr string `json:"serverAddr"`
+
+	// ServerProtocol is the protocol we used.
+	ServerProtocol Protocol `json:"serverProtocol"`
+
+	// QName is the query name or, when hashing, its keyed hash.
+	QName string `json:"qname"`
+
+	// QType is the query type.
+	QType string `json:"qtype"`
+
+	// ClientSubnet is the truncated EDNS Client Subnet sent
+	// with the query, if any, using the CIDR notation.
+	ClientSubnet string `json:"clientSubnet,omitempty"`
+
+	// Rcode is the response code, or empty on failure.
+	Rcode string `json:"rcode,omitempty"`
+
+	// Answers is the number of records in the answer section.
+	Answers int `json:"answers"`
+
+	// Err is the error string, or empty on success.
+	Err string `json:"err,omitempty"`
+
+	// RTT is the time elapsed until we received the response or failed.
+	RTT time.Duration `json:"rtt"`
+}
+
+// QueryLogTransport is a [ResolverTransport] that forwards queries to the
+// underlying transport and writes a [*QueryLogEntry] for each query. Unlike
+// [*RecordingTransport], which captures the raw messages for replaying them,
+// this transport writes compact entries suitable for logging at scale, with
+// options to sample queries and to redact personal data. To rotate the log,
+// use a writer that rotates the underlying files.
+//
+// Construct using [NewQueryLogTransport].
+type QueryLogTransport struct {
+	// ClientSubnetBitsIPv4 is the optional number of bits of the IPv4
+	// client subnets that we keep when logging, which anonymizes the
+	// client addresses that queries carry using ECS.
+	//
+	// If zero, we use [DefaultQueryLogClientSubnetBitsIPv4].
+	ClientSubnetBitsIPv4 int
+
+	// ClientSubnetBitsIPv6 is like ClientSubnetBitsIPv4 but for IPv6.
+	//
+	// If zero, we use [DefaultQueryLogClientSubnetBitsIPv6].
+	ClientSubnetBitsIPv6 int
+
+	// QNameHashKey is the optional key for replacing the query names with
+	// their HMAC-SHA256, which allows correlating the queries for the same
+	// name without revealing it, unless one knows the key.
+	//
+	// If nil, we log the query names.
+	QNameHashKey []byte
+
+	// SampleRate is the optional sampling rate. When greater than one, we
+	// only log one successful query every SampleRate queries. We always log
+	// the failed queries and the SERVFAIL responses.
+	//
+	// If zero, we log all the queries.
+	SampleRate int
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the underlying transport.
+	Transport ResolverTransport
+
+	// count counts the successful queries for sampling.
+	count atomic.Uint64
+
+	// encoder encodes entries to the writer.
+	encoder *json.Encoder
+
+	// err is the first error that occurred writing entries.
+	err error
+
+	// mu provides mutual exclusion.
+	mu sync.Mutex
+}
+
+// Ensure that [*QueryLogTransport] implements [ResolverTransport].
+var _ ResolverTransport = (*QueryLogTransport)(nil)
+
+// NewQueryLogTransport creates a new [*QueryLogTransport] that
+// uses the given transport and writes the entries to w.
+func NewQueryLogTransport(txp ResolverTransport, w io.Writer) *QueryLogTransport {
+	return &QueryLogTransport{Transport: txp, encoder: json.NewEncoder(w)}
+}
+
+// timeNow returns the current time.
+func (t *QueryLogTransport) timeNow() time.Time {
+	if t.TimeNow != nil {
+		return t.TimeNow()
+	}
+	return time.Now()
+}
+
+// Err returns the first error that occurred writing entries, if
+// any. Failing to write does not cause queries to fail, since we do
+// not want logging to break the code using the transport.
+func (t *QueryLogTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Query implements [ResolverTransport].
+func (t *QueryLogTransport) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. perform the query using the underlying transport
+	t0 := t.timeNow()
+	resp, err := t.Transport.Query(ctx, addr, query)
+	rtt := t.timeNow().Sub(t0)
+
+	// 2. sample the successful queries
+	if err == nil && resp.Rcode != dns.RcodeServerFailure && t.SampleRate > 1 {
+		if (t.count.Add(1)-1)%uint64(t.SampleRate) != 0 {
+			return resp, err
+		}
+	}
+
+	// 3. fill the entry, redacting the personal data
+	entry := &QueryLogEntry{
+		Time:           t0,
+		ServerAddr:     addr.Address,
+		ServerProtocol: addr.Protocol,
+		RTT:            rtt,
+	}
+	if len(query.Question) > 0 {
+		entry.QName = t.qname(query.Question[0].Name)
+		entry.QType = dns.TypeToString[query.Question[0].Qtype]
+	}
+	if subnet, ok := t.clientSubnet(query); ok {
+		entry.ClientSubnet = subnet.String()
+	}
+	switch {
+	case err != nil:
+		entry.Err = err.Error()
+	default:
+		entry.Rcode = dns.RcodeToString[resp.Rcode]
+		entry.Answers = len(resp.Answer)
+	}
+
+	// 4. write the entry
+	t.mu.Lock()
+	if werr := t.encoder.Encode(entry); werr != nil && t.err == nil {
+		t.err = werr
+	}
+	t.mu.Unlock()
+	return resp, err
+}
+
+// qname returns the query name to log.
+func (t *QueryLogTransport) qname(name string) string {
+	if t.QNameHashKey == nil {
+		return name
+	}
+	mac := hmac.New(sha256.New, t.QNameHashKey)
+	mac.Write([]byte(dns.CanonicalName(name)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// clientSubnet returns the truncated ECS subnet of the query, if any.
+func (t *QueryLogTransport) clientSubnet(query *dns.Msg) (netip.Prefix, bool) {
+	// 1. find the ECS option
+	opt := query.IsEdns0()
+	if opt == nil {
+		return netip.Prefix{}, false
+	}
+	var ecs *dns.EDNS0_SUBNET
+	for _, option := range opt.Option {
+		if v, ok := option.(*dns.EDNS0_SUBNET); ok {
+			ecs = v
+		}
+	}
+	if ecs == nil {
+		return netip.Prefix{}, false
+	}
+	addr, ok := netip.AddrFromSlice(ecs.Address)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+
+	// 2. truncate the subnet
+	bits := t.ClientSubnetBitsIPv6
+	if bits <= 0 {
+		bits = DefaultQueryLogClientSubnetBitsIPv6
+	}
+	if ecs.Family == 1 {
+		addr = addr.Unmap()
+		bits = t.ClientSubnetBitsIPv4
+		if bits <= 0 {
+			bits = DefaultQueryLogClientSubnetBitsIPv4
+		}
+	}
+	bits = min(bits, int(ecs.SourceNetmask), addr.BitLen())
+	subnet, err := addr.Prefix(bits)
+	return subnet, err == nil
+}
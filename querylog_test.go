@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// readQueryLogTestEntries parses the entries written to the given buffer.
+func readQueryLogTestEntries(t *testing.T, buf *bytes.Buffer) []*QueryLogEntry {
+	var entries []*QueryLogEntry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := &QueryLogEntry{}
+		assert.NoError(t, json.Unmarshal([]byte(line), entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestQueryLogTransport(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+
+	// the upstream fails AAAA queries, answers TXT queries with
+	// SERVFAIL, and answers the other queries
+	upstream := &MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			resp := &dns.Msg{}
+			resp.SetReply(query)
+			switch query.Question[0].Qtype {
+			case dns.TypeAAAA:
+				return nil, expectedErr
+			case dns.TypeTXT:
+				resp.Rcode = dns.RcodeServerFailure
+			default:
+				rr, _ := dns.NewRR(query.Question[0].Name + " 300 IN A 93.184.215.14")
+				resp.Answer = append(resp.Answer, rr)
+			}
+			return resp, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(txp *QueryLogTransport)
+		options  []QueryOption
+		qtype    uint16
+		expected QueryLogEntry
+	}{{
+		name:  "success",
+		qtype: dns.TypeA,
+		expected: QueryLogEntry{
+			QName:   "example.com.",
+			QType:   "A",
+			Rcode:   "NOERROR",
+			Answers: 1,
+		},
+	}, {
+		name:  "failure",
+		qtype: dns.TypeAAAA,
+		expected: QueryLogEntry{
+			QName: "example.com.",
+			QType: "AAAA",
+			Err:   "mocked error",
+		},
+	}, {
+		name: "hashed query name",
+		setup: func(txp *QueryLogTransport) {
+			txp.QNameHashKey = []byte("secret")
+		},
+		qtype: dns.TypeA,
+		expected: QueryLogEntry{
+			QName:   "713c2916d682aebb4189e28df99aa8b15474c89896cb5aed299915105683023b",
+			QType:   "A",
+			Rcode:   "NOERROR",
+			Answers: 1,
+		},
+	}, {
+		name:    "truncated IPv4 client subnet",
+		options: []QueryOption{QueryOptionClientSubnet(netip.MustParsePrefix("192.0.2.130/32"))},
+		qtype:   dns.TypeA,
+		expected: QueryLogEntry{
+			QName:        "example.com.",
+			QType:        "A",
+			ClientSubnet: "192.0.2.0/24",
+			Rcode:        "NOERROR",
+			Answers:      1,
+		},
+	}, {
+		name:    "truncated IPv6 client subnet",
+		options: []QueryOption{QueryOptionClientSubnet(netip.MustParsePrefix("2001:db8:1:2::1/128"))},
+		qtype:   dns.TypeA,
+		expected: QueryLogEntry{
+			QName:        "example.com.",
+			QType:        "A",
+			ClientSubnet: "2001:db8:1::/48",
+			Rcode:        "NOERROR",
+			Answers:      1,
+		},
+	}, {
+		name: "configured client subnet bits",
+		setup: func(txp *QueryLogTransport) {
+			txp.ClientSubnetBitsIPv4 = 16
+		},
+		options: []QueryOption{QueryOptionClientSubnet(netip.MustParsePrefix("192.0.2.130/32"))},
+		qtype:   dns.TypeA,
+		expected: QueryLogEntry{
+			QName:        "example.com.",
+			QType:        "A",
+			ClientSubnet: "192.0.0.0/16",
+			Rcode:        "NOERROR",
+			Answers:      1,
+		},
+	}, {
+		name:    "shorter client subnet",
+		options: []QueryOption{QueryOptionClientSubnet(netip.MustParsePrefix("192.0.0.0/16"))},
+		qtype:   dns.TypeA,
+		expected: QueryLogEntry{
+			QName:        "example.com.",
+			QType:        "A",
+			ClientSubnet: "192.0.0.0/16",
+			Rcode:        "NOERROR",
+			Answers:      1,
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1. create the transport using a fake clock
+			var buf bytes.Buffer
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			txp := NewQueryLogTransport(upstream, &buf)
+			txp.TimeNow = func() time.Time {
+				now = now.Add(10 * time.Millisecond)
+				return now
+			}
+			if tt.setup != nil {
+				tt.setup(txp)
+			}
+
+			// 2. send the query
+			query, err := NewQuery("example.com", tt.qtype, tt.options...)
+			assert.NoError(t, err)
+			_, err = txp.Query(context.Background(), addr, query)
+			assert.Equal(t, tt.expected.Err != "", err != nil)
+			assert.NoError(t, txp.Err())
+
+			// 3. check the entry
+			entries := readQueryLogTestEntries(t, &buf)
+			if assert.Len(t, entries, 1) {
+				tt.expected.Time = time.Date(2024, 1, 1, 0, 0, 0, 10*int(time.Millisecond), time.UTC)
+				tt.expected.ServerAddr = "8.8.8.8:53"
+				tt.expected.ServerProtocol = ProtocolUDP
+				tt.expected.RTT = 10 * time.Millisecond
+				assert.Equal(t, tt.expected, *entries[0])
+			}
+		})
+	}
+
+	t.Run("sampling", func(t *testing.T) {
+		var buf bytes.Buffer
+		txp := NewQueryLogTransport(upstream, &buf)
+		txp.SampleRate = 3
+		for _, qtype := range []uint16{
+			dns.TypeA, dns.TypeA, dns.TypeAAAA, dns.TypeA, dns.TypeTXT, dns.TypeA, dns.TypeA, dns.TypeA,
+		} {
+			query, _ := NewQuery("example.com", qtype)
+			txp.Query(context.Background(), addr, query)
+		}
+
+		var qtypes []string
+		for _, entry := range readQueryLogTestEntries(t, &buf) {
+			qtypes = append(qtypes, entry.QType)
+		}
+		assert.Equal(t, []string{"A", "AAAA", "TXT", "A"}, qtypes)
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		writeErr := errors.New("mocked write error")
+		w := &mocks.Conn{MockWrite: func(b []byte) (int, error) { return 0, writeErr }}
+		txp := NewQueryLogTransport(upstream, w)
+		query, _ := NewQuery("example.com", dns.TypeA)
+		resp, err := txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.ErrorIs(t, txp.Err(), writeErr)
+	})
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// ErrRawQueryTooShort indicates that a raw query is too short for a [RawQueryMutator].
+var ErrRawQueryTooShort = errors.New("raw query too short")
+
+// rawHeaderSize is the size of the DNS message header.
+const rawHeaderSize = 12
+
+// RawQueryMutator modifies a serialized query, for example to produce an
+// intentionally malformed query to send using [*Transport.QueryRaw] when
+// measuring how middleboxes and servers react to unusual messages.
+type RawQueryMutator func(rawQuery []byte) ([]byte, error)
+
+// NewRawQuery serializes the query and applies the given mutators in order.
+//
+// Unlike [NewQueryWithServerAddr], which may force a zero query ID, and
+// [*dns.Msg.Pack], which computes the section counts, the mutators operate
+// on the wire format and therefore allow setting any ID, flag, or count.
+func NewRawQuery(query *dns.Msg, mutators ...RawQueryMutator) ([]byte, error) {
+	rawQuery, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	for _, mutator := range mutators {
+		if rawQuery, err = mutator(rawQuery); err != nil {
+			return nil, err
+		}
+	}
+	return rawQuery, nil
+}
+
+// rawHeaderMutator returns a [RawQueryMutator] that calls fn with
+// the header after making sure that the query contains a header.
+func rawHeaderMutator(fn func(header []byte)) RawQueryMutator {
+	return func(rawQuery []byte) ([]byte, error) {
+		if len(rawQuery) < rawHeaderSize {
+			return nil, fmt.Errorf("%w: %d bytes", ErrRawQueryTooShort, len(rawQuery))
+		}
+		fn(rawQuery[:rawHeaderSize])
+		return rawQuery, nil
+	}
+}
+
+// RawQuerySetID returns a [RawQueryMutator] that sets the message ID.
+func RawQuerySetID(id uint16) RawQueryMutator {
+	return rawHeaderMutator(func(header []byte) {
+		binary.BigEndian.PutUint16(header[0:], id)
+	})
+}
+
+// RawQuerySetFlags returns a [RawQueryMutator] that sets the 16 bits
+// following the message ID, which include QR, the opcode, AA, TC, RD,
+// RA, the reserved Z bit, AD, CD, and the rcode.
+func RawQuerySetFlags(flags uint16) RawQueryMutator {
+	return rawHeaderMutator(func(header []byte) {
+		binary.BigEndian.PutUint16(header[2:], flags)
+	})
+}
+
+// RawQuerySetCounts returns a [RawQueryMutator] that sets the number of
+// entries in the question, answer, authority, and additional sections
+// without changing the sections themselves.
+func RawQuerySetCounts(qdcount, ancount, nscount, arcount uint16) RawQueryMutator {
+	return rawHeaderMutator(func(header []byte) {
+		binary.BigEndian.PutUint16(header[4:], qdcount)
+		binary.BigEndian.PutUint16(header[6:], ancount)
+		binary.BigEndian.PutUint16(header[8:], nscount)
+		binary.BigEndian.PutUint16(header[10:], arcount)
+	})
+}
+
+// RawQueryTruncate returns a [RawQueryMutator] that truncates the query
+// to the given size, if the query is longer than that.
+func RawQueryTruncate(size int) RawQueryMutator {
+	return func(rawQuery []byte) ([]byte, error) {
+		if size >= 0 && size < len(rawQuery) {
+			rawQuery = rawQuery[:size]
+		}
+		return rawQuery, nil
+	}
+}
+
+// RawQueryAppend returns a [RawQueryMutator] that appends the given
+// bytes to the query, for example, to add trailing garbage.
+func RawQueryAppend(data []byte) RawQueryMutator {
+	return func(rawQuery []byte) ([]byte, error) {
+		return append(rawQuery, data...), nil
+	}
+}
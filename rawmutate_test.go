@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRawQuery(t *testing.T) {
+	newQuery := func() *dns.Msg {
+		query, _ := NewQueryWithServerAddr(NewServerAddr(ProtocolDoH, "https://dns.google/dns-query"),
+			"example.com", dns.TypeA)
+		return query
+	}
+
+	tests := []struct {
+		name     string
+		query    *dns.Msg
+		mutators []RawQueryMutator
+		check    func(t *testing.T, rawQuery []byte)
+		err      error
+	}{
+		{
+			name:  "without mutators",
+			query: newQuery(),
+			check: func(t *testing.T, rawQuery []byte) {
+				expected, _ := newQuery().Pack()
+				assert.Equal(t, expected, rawQuery)
+			},
+		},
+
+		{
+			name:     "setting the ID and the flags",
+			query:    newQuery(),
+			mutators: []RawQueryMutator{RawQuerySetID(0x1234), RawQuerySetFlags(0x8140)},
+			check: func(t *testing.T, rawQuery []byte) {
+				msg := &dns.Msg{}
+				assert.NoError(t, msg.Unpack(rawQuery))
+				assert.Equal(t, uint16(0x1234), msg.Id)
+				assert.True(t, msg.Response)
+				assert.True(t, msg.RecursionDesired)
+				assert.True(t, msg.Zero)
+			},
+		},
+
+		{
+			name:     "setting the section counts",
+			query:    newQuery(),
+			mutators: []RawQueryMutator{RawQuerySetCounts(2, 0, 0, 1)},
+			check: func(t *testing.T, rawQuery []byte) {
+				assert.Equal(t, []byte{0, 2, 0, 0, 0, 0, 0, 1}, rawQuery[4:12])
+				assert.Error(t, (&dns.Msg{}).Unpack(rawQuery))
+			},
+		},
+
+		{
+			name:     "truncating and appending",
+			query:    newQuery(),
+			mutators: []RawQueryMutator{RawQueryTruncate(14), RawQueryAppend([]byte{0xff}), RawQueryTruncate(100)},
+			check: func(t *testing.T, rawQuery []byte) {
+				assert.Len(t, rawQuery, 15)
+				assert.Equal(t, byte(0xff), rawQuery[14])
+			},
+		},
+
+		{
+			name:     "header mutator with a short query",
+			query:    newQuery(),
+			mutators: []RawQueryMutator{RawQueryTruncate(4), RawQuerySetID(1)},
+			err:      ErrRawQueryTooShort,
+		},
+
+		{
+			name:  "query that cannot be packed",
+			query: &dns.Msg{Question: []dns.Question{{Name: "example.com", Qtype: dns.TypeA}}},
+			err:   errors.New("dns: domain must be fully qualified"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawQuery, err := NewRawQuery(tt.query, tt.mutators...)
+			switch {
+			case tt.err != nil && errors.Is(tt.err, ErrRawQueryTooShort):
+				assert.ErrorIs(t, err, tt.err)
+			case tt.err != nil:
+				assert.EqualError(t, err, tt.err.Error())
+			default:
+				assert.NoError(t, err)
+				tt.check(t, rawQuery)
+			}
+		})
+	}
+}
+
+func TestNewRawQuery_QueryRaw(t *testing.T) {
+	// a query the server cannot parse should cause the server to close the connection
+	addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+	query, _ := NewQuery("example.com", dns.TypeA)
+	rawQuery, err := NewRawQuery(query, RawQuerySetCounts(0, 0, 0, 1))
+	assert.NoError(t, err)
+	_, err = (&Transport{}).QueryRaw(context.Background(), addr, rawQuery)
+	assert.Error(t, err)
+}
@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+// ErrTransportCannotSendRawQueries is returned when the transport cannot send raw queries.
+var ErrTransportCannotSendRawQueries = errors.New("transport cannot send raw queries")
+
+// rawQueryMsg is a raw query implementing [queryMsg].
+type rawQueryMsg []byte
+
+// Pack implements queryMsg.
+func (m rawQueryMsg) Pack() ([]byte, error) {
+	return m, nil
+}
+
+// QueryRaw is like [*Transport.Query] but sends the given raw query as is
+// and returns the raw response without parsing or validating it. This is
+// useful for proxies that only forward wire-format messages and to send
+// intentionally malformed queries when researching middlebox behavior.
+//
+// Because we do not parse the query, we read responses of up to 65535 bytes
+// regardless of the EDNS(0) options, and, for [ProtocolUDP], we return the
+// first datagram we receive, without discarding unrelated datagrams.
+//
+// This method does not support [ProtocolDoHJSON], whose queries are not
+// wire-format messages, and fails with [ErrTransportCannotSendRawQueries].
+// Connections are never reused, regardless of the ReuseStreamConns and
+// PipelineStreamQueries fields, since malformed queries could leave
+// the connection in an unexpected state.
+func (t *Transport) QueryRaw(ctx context.Context, addr *ServerAddr, rawQuery []byte) ([]byte, error) {
+	ctx, done, err := t.beginQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	t.stats.onQuery(addr)
+	rawResp, err := t.queryRaw(ctx, addr, rawQuery)
+	if err != nil {
+		t.stats.onError(addr, err)
+	}
+	return rawResp, err
+}
+
+// queryRaw dispatches the raw query to the protocol-specific implementation.
+func (t *Transport) queryRaw(ctx context.Context, addr *ServerAddr, rawQuery []byte) ([]byte, error) {
+	// 0. immediately fail if the context is already done, which
+	// is useful to write unit tests
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	switch addr.Protocol {
+	case ProtocolUDP:
+		return t.queryRawUDP(ctx, addr, rawQuery)
+
+	case ProtocolTCP:
+		return t.queryRawStream(ctx, addr, rawQuery, func(ctx context.Context) (net.Conn, error) {
+			return t.dialContext(ctx, "tcp", addr.Address)
+		})
+
+	case ProtocolDoT:
+		return t.queryRawStream(ctx, addr, rawQuery, func(ctx context.Context) (net.Conn, error) {
+			conn, err := t.dialTLSContext(ctx, "tcp", addr.Address)
+			if err != nil {
+				t.maybeEmitHandshakeFailure(addr, err)
+				return nil, err
+			}
+			t.stats.onHandshake(addr)
+			return conn, nil
+		})
+
+	case ProtocolHTTP, ProtocolH2C:
+		if !t.Insecure {
+			return nil, fmt.Errorf("%w: %s", ErrInsecureProtocol, addr.Protocol)
+		}
+		return t.queryRawHTTPS(ctx, addr, rawQuery)
+
+	case ProtocolDoH:
+		return t.queryRawHTTPS(ctx, addr, rawQuery)
+
+	case ProtocolDoHJSON:
+		return nil, fmt.Errorf("%w: %s", ErrTransportCannotSendRawQueries, addr.Protocol)
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchTransportProtocol, addr.Protocol)
+	}
+}
+
+// queryRawUDP implements [*Transport.QueryRaw] for DNS over UDP.
+func (t *Transport) queryRawUDP(ctx context.Context, addr *ServerAddr, rawQuery []byte) ([]byte, error) {
+	// 1. Send the query and log the query if needed.
+	conn, t0, _, err := t.sendQueryUDP(ctx, addr, rawQueryMsg(rawQuery))
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, err
+	}
+
+	// 2. Make sure we react to context being canceled early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer conn.Close()
+		<-ctx.Done()
+	}()
+
+	// 3. Read the first datagram and log it if needed.
+	rawResp, err := t.readResponseUDP(ctx, addr, conn, math.MaxUint16)
+	if err != nil {
+		return nil, err
+	}
+	t.stats.onRawResponse(addr, rawResp)
+	t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
+	return rawResp, nil
+}
+
+// queryRawStream implements [*Transport.QueryRaw] for DNS over TCP and TLS
+// using a new connection created with dial, which we close when done.
+func (t *Transport) queryRawStream(ctx context.Context, addr *ServerAddr,
+	rawQuery []byte, dial func(ctx context.Context) (net.Conn, error)) ([]byte, error) {
+	// 1. Dial a new connection.
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn = t.newConn(addr, conn)
+
+	// 2. Make sure we react to context being canceled early and use
+	// the context deadline to limit the query lifetime.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer conn.Close()
+		<-ctx.Done()
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// 3. Perform the exchange and log the response if needed.
+	br := streamReaderPool.Get().(*bufio.Reader)
+	br.Reset(conn)
+	defer func() {
+		br.Reset(nil)
+		streamReaderPool.Put(br)
+	}()
+	t0, _, rawResp, err := t.exchangeStreamRaw(ctx, addr, rawQueryMsg(rawQuery), conn, br)
+	if err != nil {
+		return nil, err
+	}
+	t.stats.onRawResponse(addr, rawResp)
+	t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
+	return rawResp, nil
+}
+
+// queryRawHTTPS implements [*Transport.QueryRaw] for DNS over HTTPS as well
+// as for DNS over cleartext HTTP/1.1 and HTTP/2 ([ProtocolHTTP], [ProtocolH2C]).
+func (t *Transport) queryRawHTTPS(ctx context.Context, addr *ServerAddr, rawQuery []byte) ([]byte, error) {
+	t0, rawResp, laddr, raddr, err := t.roundTripHTTPS(ctx, addr, rawQuery, math.MaxUint16)
+	if err != nil {
+		return nil, err
+	}
+	t.stats.onRawResponse(addr, rawResp)
+	t.maybeLogResponseAddrPort(ctx, addr, t0, rawQuery, rawResp, laddr, raddr)
+	return rawResp, nil
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_QueryRaw(t *testing.T) {
+	newRawQuery := func() []byte {
+		query, _ := NewQuery("example.com", dns.TypeA)
+		rawQuery, _ := query.Pack()
+		return rawQuery
+	}
+
+	// checkRawResp makes sure the raw response is valid for the raw query
+	checkRawResp := func(t *testing.T, rawQuery, rawResp []byte) {
+		query := &dns.Msg{}
+		assert.NoError(t, query.Unpack(rawQuery))
+		_, err := ParseResponse(query, rawResp)
+		assert.NoError(t, err)
+	}
+
+	t.Run("UDP", func(t *testing.T) {
+		var sent []byte
+		rawResp := []byte{0, 1, 0x80, 0x03, 0, 0, 0, 0, 0, 0, 0, 0}
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &mocks.Conn{
+					MockSetDeadline: func(time.Time) error { return nil },
+					MockWrite: func(b []byte) (int, error) {
+						sent = b
+						return len(b), nil
+					},
+					MockRead: func(b []byte) (int, error) {
+						assert.Len(t, b, 65535)
+						return copy(b, rawResp), nil
+					},
+					MockClose: func() error { return nil },
+				}, nil
+			},
+		}
+
+		// we should be able to send arbitrary bytes
+		rawQuery := []byte("not a DNS message")
+		got, err := txp.QueryRaw(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), rawQuery)
+		assert.NoError(t, err)
+		assert.Equal(t, rawQuery, sent)
+		assert.Equal(t, rawResp, got)
+		stats := txp.Stats()[0]
+		assert.Equal(t, int64(1), stats.Responses)
+		assert.Equal(t, int64(1), stats.Rcodes[dns.RcodeNameError])
+	})
+
+	t.Run("UDP write failure", func(t *testing.T) {
+		expectedErr := errors.New("mocked error")
+		var closed bool
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &mocks.Conn{
+					MockSetDeadline: func(time.Time) error { return nil },
+					MockWrite:       func(b []byte) (int, error) { return 0, expectedErr },
+					MockClose: func() error {
+						closed = true
+						return nil
+					},
+				}, nil
+			},
+		}
+		_, err := txp.QueryRaw(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), newRawQuery())
+		assert.ErrorIs(t, err, expectedErr)
+		assert.True(t, closed)
+		assert.Equal(t, int64(1), txp.Stats()[0].Errors)
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{}
+		rawQuery := newRawQuery()
+		rawResp, err := txp.QueryRaw(context.Background(), addr, rawQuery)
+		assert.NoError(t, err)
+		checkRawResp(t, rawQuery, rawResp)
+	})
+
+	t.Run("TCP with a query the server cannot parse", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{}
+		_, err := txp.QueryRaw(context.Background(), addr, []byte{0})
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("DoT dial failure", func(t *testing.T) {
+		expectedErr := errors.New("mocked error")
+		txp := &Transport{
+			DialTLSContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, expectedErr
+			},
+		}
+		_, err := txp.QueryRaw(context.Background(), NewServerAddr(ProtocolDoT, "8.8.8.8:853"), newRawQuery())
+		assert.ErrorIs(t, err, expectedErr)
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rawQuery, _ := io.ReadAll(r.Body)
+			query := &dns.Msg{}
+			if query.Unpack(rawQuery) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			resp := &dns.Msg{}
+			resp.SetReply(query)
+			rawResp, _ := resp.Pack()
+			w.Header().Set("Content-Type", "application/dns-message")
+			w.Write(rawResp)
+		}))
+		defer srv.Close()
+		addr := NewServerAddr(ProtocolHTTP, srv.URL)
+
+		_, err := (&Transport{}).QueryRaw(context.Background(), addr, newRawQuery())
+		assert.ErrorIs(t, err, ErrInsecureProtocol)
+
+		txp := &Transport{Insecure: true}
+		rawQuery := newRawQuery()
+		rawResp, err := txp.QueryRaw(context.Background(), addr, rawQuery)
+		assert.NoError(t, err)
+		checkRawResp(t, rawQuery, rawResp)
+
+		_, err = txp.QueryRaw(context.Background(), addr, []byte{0})
+		assert.ErrorIs(t, err, ErrServerMisbehaving)
+	})
+
+	t.Run("unsupported protocols", func(t *testing.T) {
+		txp := &Transport{}
+		_, err := txp.QueryRaw(context.Background(), NewServerAddr(ProtocolDoHJSON, "https://dns.google/resolve"), newRawQuery())
+		assert.ErrorIs(t, err, ErrTransportCannotSendRawQueries)
+		_, err = txp.QueryRaw(context.Background(), NewServerAddr("quic", "8.8.8.8:853"), newRawQuery())
+		assert.ErrorIs(t, err, ErrNoSuchTransportProtocol)
+	})
+
+	t.Run("context already done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := (&Transport{}).QueryRaw(ctx, NewServerAddr(ProtocolUDP, "8.8.8.8:53"), newRawQuery())
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("closed transport", func(t *testing.T) {
+		txp := &Transport{}
+		assert.NoError(t, txp.Close())
+		_, err := txp.QueryRaw(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), newRawQuery())
+		assert.ErrorIs(t, err, ErrTransportClosed)
+	})
+}
+
+func Test_rawQueryMsg(t *testing.T) {
+	rawQuery, err := rawQueryMsg("abc").Pack()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abc"), rawQuery)
+}
@@ -0,0 +1,265 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoRecordedExchange indicates that [*ReplayTransport] does not
+// have any unused recorded exchange matching a query.
+var ErrNoRecordedExchange = errors.New("no recorded exchange matching the query")
+
+// ErrRecordedFailure wraps the failures replayed by [*ReplayTransport]. Since
+// we only record the error string, we cannot replay the original error type.
+var ErrRecordedFailure = errors.New("recorded failure")
+
+// RecordedExchange is a query and the corresponding response or
+// error recorded by [*RecordingTransport]. We serialize exchanges
+// as JSON, one per line, such that files are easy to inspect.
+type RecordedExchange struct {
+	// ServerAddr is the server address.
+	ServerAddr string `json:"serverAddr"`
+
+	// ServerProtocol is the protocol we used.
+	ServerProtocol Protocol `json:"serverProtocol"`
+
+	// QName and QType describe the question, for readability.
+	QName string `json:"qname"`
+	QType string `json:"qtype"`
+
+	// Query is the raw query.
+	Query []byte `json:"query"`
+
+	// Response is the raw response, or nil on failure.
+	Response []byte `json:"response,omitempty"`
+
+	// Err is the error string, or empty on success.
+	Err string `json:"err,omitempty"`
+
+	// Start is when we sent the query.
+	Start time.Time `json:"t0"`
+
+	// RTT is the time elapsed until we received the response or failed.
+	RTT time.Duration `json:"rtt"`
+}
+
+// RecordingTransport is a [ResolverTransport] that forwards queries
+// to the underlying transport and writes each exchange, including its
+// timing, to a writer, which [*ReplayTransport] can later replay.
+//
+// Construct using [NewRecordingTransport].
+type RecordingTransport struct {
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the underlying transport.
+	Transport ResolverTransport
+
+	// encoder encodes exchanges to the writer.
+	encoder *json.Encoder
+
+	// err is the first error that occurred writing exchanges.
+	err error
+
+	// mu provides mutual exclusion.
+	mu sync.Mutex
+}
+
+// Ensure that [*RecordingTransport] implements [ResolverTransport].
+var _ ResolverTransport = (*RecordingTransport)(nil)
+
+// NewRecordingTransport creates a new [*RecordingTransport] that
+// uses the given transport and writes the exchanges to w.
+func NewRecordingTransport(txp ResolverTransport, w io.Writer) *RecordingTransport {
+	return &RecordingTransport{Transport: txp, encoder: json.NewEncoder(w)}
+}
+
+// timeNow returns the current time.
+func (t *RecordingTransport) timeNow() time.Time {
+	if t.TimeNow != nil {
+		return t.TimeNow()
+	}
+	return time.Now()
+}
+
+// Err returns the first error that occurred writing exchanges, if
+// any. Failing to write does not cause queries to fail, since we do
+// not want recording to break the code using the transport.
+func (t *RecordingTransport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Query implements [ResolverTransport].
+func (t *RecordingTransport) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. perform the query using the underlying transport
+	t0 := t.timeNow()
+	resp, err := t.Transport.Query(ctx, addr, query)
+	exchange := &RecordedExchange{
+		ServerAddr:     addr.Address,
+		ServerProtocol: addr.Protocol,
+		Start:          t0,
+		RTT:            t.timeNow().Sub(t0),
+	}
+
+	// 2. fill the exchange, noting that packing may fail
+	if len(query.Question) > 0 {
+		exchange.QName = query.Question[0].Name
+		exchange.QType = dns.TypeToString[query.Question[0].Qtype]
+	}
+	exchange.Query, _ = query.Pack()
+	switch {
+	case err != nil:
+		exchange.Err = err.Error()
+	default:
+		exchange.Response, _ = resp.Pack()
+	}
+
+	// 3. write the exchange
+	t.mu.Lock()
+	if werr := t.encoder.Encode(exchange); werr != nil && t.err == nil {
+		t.err = werr
+	}
+	t.mu.Unlock()
+	return resp, err
+}
+
+// ReadRecordedExchanges reads the exchanges written by [*RecordingTransport].
+func ReadRecordedExchanges(r io.Reader) ([]*RecordedExchange, error) {
+	var exchanges []*RecordedExchange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1<<16), 1<<22)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		exchange := &RecordedExchange{}
+		if err := json.Unmarshal([]byte(line), exchange); err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return exchanges, nil
+}
+
+// ReplayTransport is a [ResolverTransport] that deterministically
+// replays the exchanges recorded by [*RecordingTransport] without
+// using the network. Each recorded exchange is replayed once, in the
+// order in which it was recorded, when a query for the same server
+// and question arrives. We rewrite the ID of the replayed response
+// to match the query, so that validation succeeds.
+//
+// Construct using [NewReplayTransport].
+type ReplayTransport struct {
+	// Realtime indicates whether to wait for the recorded RTT
+	// before replaying each exchange. By default, we replay
+	// exchanges immediately.
+	Realtime bool
+
+	// exchanges contains the recorded exchanges.
+	exchanges []*RecordedExchange
+
+	// used tracks which exchanges we already replayed.
+	used []bool
+
+	// mu provides mutual exclusion.
+	mu sync.Mutex
+}
+
+// Ensure that [*ReplayTransport] implements [ResolverTransport].
+var _ ResolverTransport = (*ReplayTransport)(nil)
+
+// NewReplayTransport creates a new [*ReplayTransport] using
+// the exchanges read by [ReadRecordedExchanges].
+func NewReplayTransport(exchanges []*RecordedExchange) *ReplayTransport {
+	return &ReplayTransport{exchanges: exchanges, used: make([]bool, len(exchanges))}
+}
+
+// Remaining returns the number of exchanges not replayed yet, which is
+// useful to check whether a regression test sent all the expected queries.
+func (t *ReplayTransport) Remaining() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var count int
+	for _, used := range t.used {
+		if !used {
+			count++
+		}
+	}
+	return count
+}
+
+// next returns the next unused exchange matching the query or nil.
+func (t *ReplayTransport) next(addr *ServerAddr, q0 dns.Question) *RecordedExchange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for idx, exchange := range t.exchanges {
+		if t.used[idx] || exchange.ServerAddr != addr.Address || exchange.ServerProtocol != addr.Protocol {
+			continue
+		}
+		recorded := &dns.Msg{}
+		if err := recorded.Unpack(exchange.Query); err != nil || len(recorded.Question) != 1 {
+			continue
+		}
+		r0 := recorded.Question[0]
+		if !strings.EqualFold(r0.Name, q0.Name) || r0.Qtype != q0.Qtype || r0.Qclass != q0.Qclass {
+			continue
+		}
+		t.used[idx] = true
+		return exchange
+	}
+	return nil
+}
+
+// Query implements [ResolverTransport].
+func (t *ReplayTransport) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. find the matching exchange
+	if len(query.Question) != 1 {
+		return nil, ErrInvalidQuery
+	}
+	q0 := query.Question[0]
+	exchange := t.next(addr, q0)
+	if exchange == nil {
+		return nil, fmt.Errorf("%w: %s %s %s", ErrNoRecordedExchange,
+			addr.Address, q0.Name, dns.TypeToString[q0.Qtype])
+	}
+
+	// 2. possibly wait for the recorded RTT
+	if t.Realtime && exchange.RTT > 0 {
+		timer := time.NewTimer(exchange.RTT)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// 3. replay the failure or the response
+	if exchange.Err != "" {
+		return nil, fmt.Errorf("%w: %s", ErrRecordedFailure, exchange.Err)
+	}
+	resp := &dns.Msg{}
+	if err := resp.Unpack(exchange.Response); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotUnmarshalMessage, err.Error())
+	}
+	resp.Id = query.Id
+	return resp, nil
+}
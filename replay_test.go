@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	mock := &MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			if query.Question[0].Qtype == dns.TypeAAAA {
+				return nil, expectedErr
+			}
+			resp := &dns.Msg{}
+			resp.SetReply(query)
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+				A:   []byte{93, 184, 215, 14},
+			})
+			return resp, nil
+		},
+	}
+
+	// 1. record two exchanges using a fake clock
+	var buf bytes.Buffer
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recorder := NewRecordingTransport(mock, &buf)
+	recorder.TimeNow = func() time.Time {
+		now = now.Add(10 * time.Millisecond)
+		return now
+	}
+	queryA, _ := NewQuery("example.com", dns.TypeA)
+	respA, err := recorder.Query(context.Background(), addr, queryA)
+	assert.NoError(t, err)
+	queryAAAA, _ := NewQuery("example.com", dns.TypeAAAA)
+	_, err = recorder.Query(context.Background(), addr, queryAAAA)
+	assert.ErrorIs(t, err, expectedErr)
+	assert.NoError(t, recorder.Err())
+
+	exchanges, err := ReadRecordedExchanges(strings.NewReader(buf.String() + "\n"))
+	assert.NoError(t, err)
+	assert.Len(t, exchanges, 2)
+	assert.Equal(t, "example.com.", exchanges[0].QName)
+	assert.Equal(t, "A", exchanges[0].QType)
+	assert.Equal(t, 10*time.Millisecond, exchanges[0].RTT)
+	assert.Equal(t, "mocked error", exchanges[1].Err)
+
+	// 2. replay them using new queries and in a different order
+	replay := NewReplayTransport(exchanges)
+	assert.Equal(t, 2, replay.Remaining())
+	query, _ := NewQuery("EXAMPLE.com", dns.TypeAAAA)
+	_, err = replay.Query(context.Background(), addr, query)
+	assert.ErrorIs(t, err, ErrRecordedFailure)
+	assert.Contains(t, err.Error(), "mocked error")
+
+	query, _ = NewQuery("example.com", dns.TypeA)
+	resp, err := replay.Query(context.Background(), addr, query)
+	assert.NoError(t, err)
+	assert.NoError(t, ValidateResponse(query, resp))
+	assert.Equal(t, respA.Answer[0].String(), resp.Answer[0].String())
+	assert.Equal(t, 0, replay.Remaining())
+
+	// 3. each exchange is replayed only once
+	_, err = replay.Query(context.Background(), addr, query)
+	assert.ErrorIs(t, err, ErrNoRecordedExchange)
+}
+
+func TestRecordingTransport_writeError(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	mock := &MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			return nil, context.Canceled
+		},
+	}
+	w := &mocks.Conn{MockWrite: func(b []byte) (int, error) { return 0, expectedErr }}
+	recorder := NewRecordingTransport(mock, w)
+	query, _ := NewQuery("example.com", dns.TypeA)
+	_, err := recorder.Query(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), query)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, recorder.Err(), expectedErr)
+}
+
+func TestReadRecordedExchanges(t *testing.T) {
+	_, err := ReadRecordedExchanges(strings.NewReader("{\n"))
+	assert.Error(t, err)
+}
+
+func TestReplayTransport_Query(t *testing.T) {
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	query, _ := NewQuery("example.com", dns.TypeA)
+	rawQuery, _ := query.Pack()
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	rawResp, _ := resp.Pack()
+
+	t.Run("matching considers the server", func(t *testing.T) {
+		replay := NewReplayTransport([]*RecordedExchange{
+			{ServerAddr: "1.1.1.1:53", ServerProtocol: ProtocolUDP, Query: rawQuery, Response: rawResp},
+			{ServerAddr: "8.8.8.8:53", ServerProtocol: ProtocolTCP, Query: rawQuery, Response: rawResp},
+			{ServerAddr: "8.8.8.8:53", ServerProtocol: ProtocolUDP, Query: []byte{0}},
+		})
+		_, err := replay.Query(context.Background(), addr, query)
+		assert.ErrorIs(t, err, ErrNoRecordedExchange)
+		assert.Equal(t, 3, replay.Remaining())
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		replay := NewReplayTransport(nil)
+		_, err := replay.Query(context.Background(), addr, &dns.Msg{})
+		assert.ErrorIs(t, err, ErrInvalidQuery)
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		replay := NewReplayTransport([]*RecordedExchange{
+			{ServerAddr: addr.Address, ServerProtocol: addr.Protocol, Query: rawQuery, Response: []byte{0}},
+		})
+		_, err := replay.Query(context.Background(), addr, query)
+		assert.ErrorIs(t, err, ErrCannotUnmarshalMessage)
+	})
+
+	t.Run("realtime", func(t *testing.T) {
+		newReplay := func(rtt time.Duration) *ReplayTransport {
+			replay := NewReplayTransport([]*RecordedExchange{
+				{ServerAddr: addr.Address, ServerProtocol: addr.Protocol, Query: rawQuery, Response: rawResp, RTT: rtt},
+			})
+			replay.Realtime = true
+			return replay
+		}
+		t0 := time.Now()
+		_, err := newReplay(20*time.Millisecond).Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(t0), 20*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = newReplay(time.Hour).Query(ctx, addr, query)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func FuzzReadRecordedExchanges(f *testing.F) {
+	f.Add([]byte(`{"serverAddr":"8.8.8.8:53","serverProtocol":"udp","query":"AAE=","rtt":1}` + "\n"))
+	f.Add([]byte("{}\n\n{\"err\":\"x\"}"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		exchanges, err := ReadRecordedExchanges(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		replay := NewReplayTransport(exchanges)
+		query, _ := NewQuery("example.com", dns.TypeA)
+		_, _ = replay.Query(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), query)
+	})
+}
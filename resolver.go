@@ -10,7 +10,9 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -18,6 +20,10 @@ import (
 // ResolverTransport is the interface defining the [*Transport]
 // methods used by the [*Resolver] struct.
 //
+// Implementations MUST NOT modify the query, such that callers can reuse
+// the same query and send it concurrently. Implementations needing to
+// send a different query, e.g., to change its ID, must use a copy.
+//
 // The [*Transport] type implements this interface.
 type ResolverTransport interface {
 	Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error)
@@ -28,15 +34,50 @@ type ResolverTransport interface {
 //
 // The zero value is ready to use.
 type Resolver struct {
+	// Cache is the optional cache of the answers. Note that lookups
+	// served from the cache do not perform any exchange, hence
+	// [*Resolver.LookupWithExchanges] returns no exchanges for them.
+	//
+	// If nil, we do not cache answers, which is what measurements
+	// usually need, since we want to observe the network.
+	Cache *Cache
+
+	// ClientSubnet is the optional client subnet to send to the servers
+	// using the EDNS Client Subnet (ECS) option defined by RFC 7871, after
+	// the query options of each server. When using a [*Cache], we cache
+	// answers by the subnet scope returned by the servers, such that we
+	// do not serve answers tailored to a subnet to other subnets.
+	//
+	// If the zero value, we do not send ECS.
+	ClientSubnet netip.Prefix
+
 	// Config is the optional resolver configuration.
 	//
 	// If nil, we use an empty [*ResolverConfig].
 	Config *ResolverConfig
 
+	// IDGenerator is the optional [IDGenerator] for the IDs of the queries
+	// sent using the protocols requiring a nonzero query ID. We always use
+	// a zero ID for DNS over HTTPS, regardless of this field.
+	//
+	// If nil, we use [RandomID].
+	IDGenerator IDGenerator
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
 	// Transport is the optional DNS transport to use for resolving queries.
 	//
 	// If nil, we use [DefaultTransport].
 	Transport ResolverTransport
+
+	// TTLPolicy is the optional policy to clamp and override the TTL
+	// of the RRs returned by [*Resolver.LookupWithExchanges] and
+	// [*Resolver.LookupANY].
+	//
+	// If nil, we return the TTLs received from the servers.
+	TTLPolicy *TTLPolicy
 }
 
 // config returns the resolver configuration or a default one.
@@ -47,6 +88,22 @@ func (r *Resolver) config() *ResolverConfig {
 	return r.Config
 }
 
+// idGenerator returns the [IDGenerator] to use.
+func (r *Resolver) idGenerator() IDGenerator {
+	if r.IDGenerator != nil {
+		return r.IDGenerator
+	}
+	return RandomID
+}
+
+// timeNow returns the current time.
+func (r *Resolver) timeNow() time.Time {
+	if r.TimeNow != nil {
+		return r.TimeNow()
+	}
+	return time.Now()
+}
+
 // resolverLookupResult is the result of a lookup operation.
 type resolverLookupResult struct {
 	addrs []string
@@ -55,7 +55,7 @@ func TestResolver_config(t *testing.T) {
 		},
 		{
 			name:     "Non-nil config returns the same config",
-			config:   &ResolverConfig{attempts: 128},
+			config:   &ResolverConfig{settings: &resolverConfigSettings{attempts: 128}},
 			attempts: 128,
 		},
 	}
@@ -64,8 +64,45 @@ func TestResolver_config(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			resolver := &Resolver{Config: tt.config}
 			result := resolver.config()
-			if result.attempts != tt.attempts {
-				t.Fatalf("expected attempts %d, got %d", tt.attempts, result.attempts)
+			if result.Attempts() != tt.attempts {
+				t.Fatalf("expected attempts %d, got %d", tt.attempts, result.Attempts())
+			}
+		})
+	}
+}
+
+func TestResolver_IDGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol Protocol
+		address  string
+		expected uint16
+	}{
+		{"UDP uses the generator", ProtocolUDP, "8.8.8.8:53", 0x1234},
+		{"DoH uses a zero ID", ProtocolDoH, "https://dns.google/dns-query", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewConfig()
+			config.AddServer(NewServerAddr(tt.protocol, tt.address))
+			var got []uint16
+			resolver := &Resolver{
+				Config:      config,
+				IDGenerator: func() uint16 { return 0x1234 },
+				Transport: &MockResolverTransport{
+					MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+						got = append(got, query.Id)
+						resp := new(dns.Msg)
+						resp.SetRcode(query, dns.RcodeNameError)
+						resp.RecursionAvailable = true
+						return resp, nil
+					},
+				},
+			}
+			_, _ = resolver.LookupA(context.Background(), "example.com")
+			if len(got) <= 0 || got[0] != tt.expected {
+				t.Fatalf("expected ID %#x, got %v", tt.expected, got)
 			}
 		})
 	}
@@ -4,6 +4,7 @@ package dnscore
 
 import (
 	"net"
+	"slices"
 	"sync"
 	"time"
 )
@@ -17,14 +18,40 @@ import (
 // If the configuration is empty, it uses the "8.8.8.8:53/udp"
 // and "8.8.4.4:53/udp" servers as the default servers.
 type ResolverConfig struct {
+	// health contains the health state of servers
+	// as determined by a [*HealthChecker].
+	health map[serverKey]*ServerHealth
+
+	// explorationRate is the exploration rate for [ServerSelectionFastest].
+	explorationRate float64
+
+	// latency contains the latency statistics of servers.
+	latency map[serverKey]*ServerLatency
+
+	// mu is the mutex for the config.
+	mu sync.RWMutex
+
+	// retryAfter contains the time until which servers asked
+	// us not to query them using the HTTP Retry-After header.
+	retryAfter map[serverKey]time.Time
+
+	// settings contains the settings used by lookups, which we never
+	// modify in place but rather replace with an updated copy.
+	settings *resolverConfigSettings
+}
+
+// resolverConfigSettings is an immutable snapshot of the settings that
+// each lookup reads once, such that replacing the settings of a running
+// [*Resolver] never causes a lookup to mix old and new settings.
+type resolverConfigSettings struct {
 	// attempts is the number of attempts to make for each query.
 	attempts int
 
 	// list contains the list of configured servers.
 	list []resolverConfigServer
 
-	// mu is the mutex for the config.
-	mu sync.RWMutex
+	// selection is the server selection policy.
+	selection ServerSelection
 }
 
 // DefaultAttempts is the default number of attempts to make for each query.
@@ -33,24 +60,47 @@ const DefaultAttempts = 2
 // NewConfig creates a new resolver configuration.
 func NewConfig() *ResolverConfig {
 	return &ResolverConfig{
-		attempts: DefaultAttempts,
-		list:     []resolverConfigServer{},
-		mu:       sync.RWMutex{},
+		mu: sync.RWMutex{},
+		settings: &resolverConfigSettings{
+			attempts: DefaultAttempts,
+			list:     []resolverConfigServer{},
+		},
 	}
 }
 
+// snapshot returns the current settings, which the caller MUST NOT modify.
+func (c *ResolverConfig) snapshot() *resolverConfigSettings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.settings == nil {
+		return &resolverConfigSettings{}
+	}
+	return c.settings
+}
+
+// updateSettings replaces the settings with a copy modified by the given func.
+func (c *ResolverConfig) updateSettings(modify func(settings *resolverConfigSettings)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var settings resolverConfigSettings
+	if c.settings != nil {
+		settings = *c.settings
+	}
+	settings.list = slices.Clone(settings.list)
+	modify(&settings)
+	c.settings = &settings
+}
+
 // SetAttempts sets the number of attempts to make for each query.
 func (c *ResolverConfig) SetAttempts(attempts int) {
-	c.mu.Lock()
-	c.attempts = attempts
-	c.mu.Unlock()
+	c.updateSettings(func(settings *resolverConfigSettings) {
+		settings.attempts = attempts
+	})
 }
 
 // Attempts returns the number of attempts to make for each query.
 func (c *ResolverConfig) Attempts() int {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.attempts
+	return c.snapshot().attempts
 }
 
 // resolverConfigServer contains configuration for a single resolver server.
@@ -103,12 +153,12 @@ func newResolverConfigServer(address *ServerAddr, options ...AddServerOption) re
 
 	// apply the default query options suitable for the protocol used by the server
 	switch address.Protocol {
-	case ProtocolDoH, ProtocolDoT:
+	case ProtocolDoH, ProtocolDoHJSON, ProtocolDoT:
 		server.queryOptions = append(server.queryOptions, QueryOptionEDNS0(
 			EDNS0SuggestedMaxResponseSizeOtherwise,
 			EDNS0FlagDO|EDNS0FlagBlockLengthPadding))
 
-	case ProtocolTCP:
+	case ProtocolTCP, ProtocolHTTP, ProtocolH2C:
 		server.queryOptions = append(server.queryOptions, QueryOptionEDNS0(
 			EDNS0SuggestedMaxResponseSizeOtherwise, 0))
 
@@ -127,17 +177,35 @@ func newResolverConfigServer(address *ServerAddr, options ...AddServerOption) re
 
 // AddServer adds a new server to the resolver configuration.
 func (c *ResolverConfig) AddServer(address *ServerAddr, options ...AddServerOption) {
+	server := newResolverConfigServer(address, options...)
+	c.updateSettings(func(settings *resolverConfigSettings) {
+		settings.list = append(settings.list, server)
+	})
+}
+
+// Replace atomically replaces the servers, the attempts, and the server
+// selection policy with the ones of other, e.g., when reloading the
+// configuration of a running [*Resolver]. In-flight lookups keep using the
+// previous settings, and each lookup uses either the previous or the new
+// settings, never a mix of them. We keep the health and latency statistics
+// of the servers and the exploration rate.
+func (c *ResolverConfig) Replace(other *ResolverConfig) {
+	settings := other.snapshot()
 	c.mu.Lock()
-	c.list = append(c.list, newResolverConfigServer(address, options...))
+	c.settings = settings
 	c.mu.Unlock()
 }
 
 // servers returns the list of configured servers.
 func (c *ResolverConfig) servers() []resolverConfigServer {
+	return c.snapshot().servers()
+}
+
+// servers returns a copy of the configured servers or the default
+// servers, if the settings do not contain any server.
+func (s *resolverConfigSettings) servers() []resolverConfigServer {
 	// copy the list of servers
-	c.mu.RLock()
-	list := append([]resolverConfigServer(nil), c.list...)
-	c.mu.RUnlock()
+	list := slices.Clone(s.list)
 
 	// if empty, create the default servers
 	if len(list) == 0 {
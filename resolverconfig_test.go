@@ -89,3 +89,62 @@ func TestServerOptionQueryOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestReplace(t *testing.T) {
+	config := NewConfig()
+	config.AddServer(NewServerAddr(ProtocolUDP, "1.1.1.1:53"))
+
+	other := NewConfig()
+	other.SetAttempts(5)
+	other.SetServerSelection(ServerSelectionFastest)
+	other.AddServer(NewServerAddr(ProtocolTCP, "8.8.8.8:53"), ServerOptionQueryTimeout(time.Second))
+	other.AddServer(NewServerAddr(ProtocolDoT, "8.8.4.4:853"))
+	config.Replace(other)
+
+	servers := config.servers()
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].address.Address != "8.8.8.8:53" || servers[0].timeout != time.Second {
+		t.Fatalf("Unexpected first server: %+v", servers[0])
+	}
+	if servers[1].address.Protocol != ProtocolDoT {
+		t.Fatalf("Unexpected second server: %+v", servers[1])
+	}
+	if config.Attempts() != 5 || config.snapshot().selection != ServerSelectionFastest {
+		t.Fatalf("Unexpected settings: %+v", config.snapshot())
+	}
+
+	// modifying other does not modify the replaced settings
+	other.AddServer(NewServerAddr(ProtocolUDP, "9.9.9.9:53"))
+	other.SetAttempts(1)
+	if len(config.servers()) != 2 || config.Attempts() != 5 {
+		t.Fatalf("Unexpected settings: %+v", config.snapshot())
+	}
+
+	// replacing using an empty configuration restores the default servers
+	config.Replace(NewConfig())
+	if servers := config.servers(); len(servers) != 2 || servers[0].address.Address != "8.8.8.8:53" {
+		t.Fatalf("Expected the default servers, got %+v", servers)
+	}
+	if config.Attempts() != DefaultAttempts {
+		t.Fatalf("Expected the default attempts, got %d", config.Attempts())
+	}
+}
+
+func TestResolverConfig_snapshot(t *testing.T) {
+	// a snapshot is not affected by later changes
+	config := NewConfig()
+	config.AddServer(NewServerAddr(ProtocolUDP, "1.1.1.1:53"))
+	settings := config.snapshot()
+	config.AddServer(NewServerAddr(ProtocolUDP, "8.8.8.8:53"))
+	config.SetAttempts(7)
+	if len(settings.list) != 1 || settings.attempts != DefaultAttempts {
+		t.Fatalf("Unexpected snapshot: %+v", settings)
+	}
+
+	// the zero value has no settings and uses the default servers
+	if servers := (&ResolverConfig{}).servers(); len(servers) != 2 {
+		t.Fatalf("Expected the default servers, got %+v", servers)
+	}
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ResolverExchange describes a query sent by the [*Resolver] to
+// a server along with its outcome. Measurement tools can use the
+// raw messages in Info to archive the exact wire data.
+type ResolverExchange struct {
+	// ServerAddr is the server we sent the query to.
+	ServerAddr *ServerAddr
+
+	// Query is the query we sent.
+	Query *dns.Msg
+
+	// Response is the response we received or nil on failure.
+	Response *dns.Msg
+
+	// Info contains metadata about the query, including the raw query
+	// and response. It is nil when the [ResolverTransport] does not implement
+	// the QueryWithInfo method (note that [*Transport] implements it).
+	Info *QueryInfo
+
+	// Err is the error that occurred or nil on success.
+	Err error
+}
+
+// resolverTransportWithInfo is the optional [ResolverTransport] extension
+// allowing the [*Resolver] to obtain a [*QueryInfo] for each query.
+type resolverTransportWithInfo interface {
+	QueryWithInfo(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, *QueryInfo, error)
+}
+
+// resolverExchangeRecorder records the exchanges performed by a lookup.
+type resolverExchangeRecorder struct {
+	// exchanges contains the recorded exchanges.
+	exchanges []*ResolverExchange
+
+	// mu protects exchanges.
+	mu sync.Mutex
+}
+
+// add adds the given exchange to the recorder.
+func (rec *resolverExchangeRecorder) add(ex *ResolverExchange) {
+	rec.mu.Lock()
+	rec.exchanges = append(rec.exchanges, ex)
+	rec.mu.Unlock()
+}
+
+// resolverExchangeRecorderKey is the context key for the [*resolverExchangeRecorder].
+type resolverExchangeRecorderKey struct{}
+
+// resolverExchangeRecorderFromContext returns the [*resolverExchangeRecorder]
+// inside the context or nil, meaning we should not record exchanges.
+func resolverExchangeRecorderFromContext(ctx context.Context) *resolverExchangeRecorder {
+	rec, _ := ctx.Value(resolverExchangeRecorderKey{}).(*resolverExchangeRecorder)
+	return rec
+}
+
+// query sends the query using the transport and, if the context contains
+// a [*resolverExchangeRecorder], records the corresponding exchange.
+func (r *Resolver) query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	rec := resolverExchangeRecorderFromContext(ctx)
+	if rec == nil {
+		return r.transport().Query(ctx, addr, query)
+	}
+	ex := &ResolverExchange{ServerAddr: addr, Query: query}
+	if txp, ok := r.transport().(resolverTransportWithInfo); ok {
+		ex.Response, ex.Info, ex.Err = txp.QueryWithInfo(ctx, addr, query)
+	} else {
+		ex.Response, ex.Err = r.transport().Query(ctx, addr, query)
+	}
+	rec.add(ex)
+	return ex.Response, ex.Err
+}
+
+// LookupWithExchanges resolves the given name and query type like the
+// Lookup* methods do, returning the valid answer RRs along with all the
+// exchanges performed, including failed attempts and retries. The list
+// of exchanges is returned also when the lookup fails.
+func (r *Resolver) LookupWithExchanges(ctx context.Context,
+	name string, qtype uint16) ([]dns.RR, []*ResolverExchange, error) {
+	rec := &resolverExchangeRecorder{}
+	ctx = context.WithValue(ctx, resolverExchangeRecorderKey{}, rec)
+	rrs, err := r.lookup(ctx, name, qtype)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rrs, rec.exchanges, err
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver_LookupWithExchanges(t *testing.T) {
+	t.Run("records retries with a transport without info", func(t *testing.T) {
+		config := NewConfig()
+		config.AddServer(NewServerAddr(ProtocolUDP, "192.0.2.1:53"))
+		config.AddServer(NewServerAddr(ProtocolUDP, "192.0.2.2:53"))
+		expected := errors.New("mocked error")
+		reso := &Resolver{
+			Config: config,
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					if addr.Address == "192.0.2.1:53" {
+						return nil, expected
+					}
+					resp := &dns.Msg{}
+					resp.SetReply(query)
+					resp.Answer = append(resp.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+						A:   net.IPv4(8, 8, 8, 8),
+					})
+					return resp, nil
+				},
+			},
+		}
+
+		rrs, exchanges, err := reso.LookupWithExchanges(context.Background(), "example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Len(t, rrs, 1)
+		assert.Len(t, exchanges, 2)
+		assert.Equal(t, "192.0.2.1:53", exchanges[0].ServerAddr.Address)
+		assert.ErrorIs(t, exchanges[0].Err, expected)
+		assert.Nil(t, exchanges[0].Response)
+		assert.Equal(t, "192.0.2.2:53", exchanges[1].ServerAddr.Address)
+		assert.NoError(t, exchanges[1].Err)
+		assert.NotNil(t, exchanges[1].Response)
+		assert.Equal(t, exchanges[1].Query.Id, exchanges[1].Response.Id)
+		assert.Nil(t, exchanges[1].Info)
+	})
+
+	t.Run("records raw messages with a transport with info", func(t *testing.T) {
+		var rawResp []byte
+		config := NewConfig()
+		config.AddServer(NewServerAddr(ProtocolUDP, "[::2]:53"))
+		reso := &Resolver{
+			Config: config,
+			Transport: &Transport{
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					var rawQuery []byte
+					return &mocks.Conn{
+						MockSetDeadline: func(t time.Time) error { return nil },
+						MockWrite: func(b []byte) (int, error) {
+							rawQuery = append([]byte{}, b...)
+							return len(b), nil
+						},
+						MockRead: func(b []byte) (int, error) {
+							rawResp = newRawResponse(rawQuery, dns.RcodeNameError)
+							return copy(b, rawResp), nil
+						},
+						MockClose:      func() error { return nil },
+						MockLocalAddr:  func() net.Addr { return &net.UDPAddr{} },
+						MockRemoteAddr: func() net.Addr { return &net.UDPAddr{} },
+					}, nil
+				},
+			},
+		}
+
+		rrs, exchanges, err := reso.LookupWithExchanges(context.Background(), "example.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrNoName)
+		assert.Nil(t, rrs)
+		assert.Len(t, exchanges, 1)
+		assert.NotNil(t, exchanges[0].Info)
+		rawQuery, _ := exchanges[0].Query.Pack()
+		assert.Equal(t, rawQuery, exchanges[0].Info.RawQuery)
+		assert.Equal(t, rawResp, exchanges[0].Info.RawResponse)
+	})
+
+	t.Run("no exchanges for onion domains", func(t *testing.T) {
+		reso := &Resolver{}
+		_, exchanges, err := reso.LookupWithExchanges(context.Background(), "example.onion", dns.TypeA)
+		assert.ErrorIs(t, err, ErrNoData)
+		assert.Empty(t, exchanges)
+	})
+}
@@ -14,6 +14,7 @@ package dnscore
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/miekg/dns"
 )
@@ -24,6 +25,20 @@ var (
 	ErrInvalidQuery = errors.New("invalid query")
 )
 
+// ParseResponse parses the raw response to the given query and
+// validates it using [ValidateResponse]. Since raw responses come
+// from the network, this function must handle arbitrary input.
+func ParseResponse(query *dns.Msg, rawResp []byte) (*dns.Msg, error) {
+	resp := &dns.Msg{}
+	if err := resp.Unpack(rawResp); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCannotUnmarshalMessage, err.Error())
+	}
+	if err := ValidateResponse(query, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 // ValidateResponse validates a given DNS response
 // message for a given query message.
 func ValidateResponse(query, resp *dns.Msg) error {
@@ -3,6 +3,7 @@
 package dnscore
 
 import (
+	"errors"
 	"net"
 	"testing"
 
@@ -313,3 +314,49 @@ func TestValidAnswers(t *testing.T) {
 		})
 	}
 }
+
+func TestParseResponse(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	rawResp, _ := resp.Pack()
+
+	parsed, err := ParseResponse(query, rawResp)
+	if err != nil || parsed.Id != query.Id {
+		t.Fatal("expected valid response", err)
+	}
+	if _, err := ParseResponse(query, rawResp[:5]); !errors.Is(err, ErrCannotUnmarshalMessage) {
+		t.Fatal("expected", ErrCannotUnmarshalMessage, "got", err)
+	}
+	rawQuery, _ := query.Pack()
+	if _, err := ParseResponse(query, rawQuery); !errors.Is(err, ErrInvalidResponse) {
+		t.Fatal("expected", ErrInvalidResponse, "got", err)
+	}
+}
+
+func FuzzParseResponse(f *testing.F) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+	query.Id = 1234
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(93, 184, 215, 14),
+	})
+	rawResp, _ := resp.Pack()
+	f.Add(rawResp)
+	f.Add([]byte{0x04, 0xd2, 0x80, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resp, err := ParseResponse(query, data)
+		if err != nil {
+			return
+		}
+		if resp.Id != query.Id || !resp.Response || len(resp.Question) != 1 {
+			t.Fatal("ParseResponse accepted an invalid response")
+		}
+		_, _ = ValidAnswers(query.Question[0], resp)
+		_ = RCodeToError(resp)
+	})
+}
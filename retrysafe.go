@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/miekg/dns"
+)
+
+// IsRetrySafe returns whether the given error returned by [*Transport.Query]
+// guarantees that the server did not receive the query, such that sending
+// the query again cannot cause duplicate side effects. This is the case of
+// the errors occurring before sending the query, e.g., when we cannot resolve
+// the server hostname, connect to the server, or complete the TLS handshake
+// of the default dialer, and when the server refuses the connection.
+//
+// Otherwise, e.g., when the query times out after we sent it or the server
+// closes the connection before responding, the server may have processed
+// the query, and we return false. Likewise, we return false for nil errors.
+//
+// Use this function along with [IsIdempotentQuery] to decide whether to
+// retry a failed query: we can always retry idempotent queries, while we
+// should only retry the others, such as UPDATE, when the error is retry safe.
+func IsRetrySafe(err error) bool {
+	// 1. handle the errors returned before dialing
+	if errors.Is(err, ErrTransportClosed) ||
+		errors.Is(err, ErrInsecureProtocol) ||
+		errors.Is(err, ErrNoSuchTransportProtocol) ||
+		errors.Is(err, ErrTransportCannotReceiveDuplicates) {
+		return true
+	}
+
+	// 2. handle the dialing errors and the refused connections
+	var (
+		dnsErr *net.DNSError
+		hsErr  *tlsHandshakeError
+		opErr  *net.OpError
+	)
+	switch {
+	case errors.As(err, &dnsErr), errors.As(err, &hsErr):
+		return true
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return true // nobody is listening, including for UDP
+	default:
+		return false
+	}
+}
+
+// IsIdempotentQuery returns whether sending the given query more than once
+// has the same effect of sending it once, which is true for the QUERY,
+// IQUERY, STATUS, and NOTIFY opcodes, and false for the others, notably
+// UPDATE (RFC 2136), which may modify the zone each time we send it.
+func IsIdempotentQuery(query *dns.Msg) bool {
+	switch query.Opcode {
+	case dns.OpcodeQuery, dns.OpcodeIQuery, dns.OpcodeStatus, dns.OpcodeNotify:
+		return true
+	default:
+		return false
+	}
+}
+
+// canRetryQuery returns whether we can send the given query again after
+// it failed with the given error according to [IsIdempotentQuery] and
+// [IsRetrySafe].
+func canRetryQuery(query *dns.Msg, err error) bool {
+	return IsIdempotentQuery(query) || IsRetrySafe(err)
+}
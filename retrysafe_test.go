@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetrySafe(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{{
+		name:     "nil error",
+		err:      nil,
+		expected: false,
+	}, {
+		name:     "transport closed",
+		err:      ErrTransportClosed,
+		expected: true,
+	}, {
+		name:     "insecure protocol",
+		err:      fmt.Errorf("%w: %s", ErrInsecureProtocol, ProtocolH2C),
+		expected: true,
+	}, {
+		name:     "server hostname resolution failure",
+		err:      &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "dns.example.com"}},
+		expected: true,
+	}, {
+		name:     "connection refused while dialing",
+		err:      &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		expected: true,
+	}, {
+		name:     "connection refused while reading a UDP response",
+		err:      &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)},
+		expected: true,
+	}, {
+		name:     "TLS handshake failure",
+		err:      &tlsHandshakeError{errors.New("tls: handshake failure")},
+		expected: true,
+	}, {
+		name:     "timeout after sending the query",
+		err:      &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded},
+		expected: false,
+	}, {
+		name:     "write failure",
+		err:      &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)},
+		expected: false,
+	}, {
+		name:     "context deadline exceeded",
+		err:      context.DeadlineExceeded,
+		expected: false,
+	}, {
+		name:     "HTTP error",
+		err:      &HTTPError{StatusCode: 503},
+		expected: false,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsRetrySafe(tt.err))
+		})
+	}
+}
+
+func TestIsIdempotentQuery(t *testing.T) {
+	tests := []struct {
+		opcode   int
+		expected bool
+	}{
+		{dns.OpcodeQuery, true},
+		{dns.OpcodeIQuery, true},
+		{dns.OpcodeStatus, true},
+		{dns.OpcodeNotify, true},
+		{dns.OpcodeUpdate, false},
+		{OpcodeDSO, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(dns.OpcodeToString[tt.opcode], func(t *testing.T) {
+			query := &dns.Msg{}
+			query.Opcode = tt.opcode
+			assert.Equal(t, tt.expected, IsIdempotentQuery(query))
+		})
+	}
+}
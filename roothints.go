@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoRootHints indicates that the root hints do not contain
+// any root server with at least one address.
+var ErrNoRootHints = errors.New("no usable root hints")
+
+// RootHint contains the name and addresses of a root server.
+type RootHint struct {
+	// Name is the fully-qualified name of the root server.
+	Name string
+
+	// Addrs contains the IPv4 and IPv6 addresses of the root server.
+	Addrs []netip.Addr
+}
+
+// builtinRootHints contains the root hints published by IANA.
+var builtinRootHints = []RootHint{
+	{"a.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("198.41.0.4"), netip.MustParseAddr("2001:503:ba3e::2:30")}},
+	{"b.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("170.247.170.2"), netip.MustParseAddr("2801:1b8:10::b")}},
+	{"c.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("192.33.4.12"), netip.MustParseAddr("2001:500:2::c")}},
+	{"d.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("199.7.91.13"), netip.MustParseAddr("2001:500:2d::d")}},
+	{"e.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("192.203.230.10"), netip.MustParseAddr("2001:500:a8::e")}},
+	{"f.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("192.5.5.241"), netip.MustParseAddr("2001:500:2f::f")}},
+	{"g.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("192.112.36.4"), netip.MustParseAddr("2001:500:12::d0d")}},
+	{"h.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("198.97.190.53"), netip.MustParseAddr("2001:500:1::53")}},
+	{"i.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("192.36.148.17"), netip.MustParseAddr("2001:7fe::53")}},
+	{"j.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("192.58.128.30"), netip.MustParseAddr("2001:503:c27::2:30")}},
+	{"k.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("193.0.14.129"), netip.MustParseAddr("2001:7fd::1")}},
+	{"l.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("199.7.83.42"), netip.MustParseAddr("2001:500:9f::42")}},
+	{"m.root-servers.net.", []netip.Addr{
+		netip.MustParseAddr("202.12.27.33"), netip.MustParseAddr("2001:dc3::35")}},
+}
+
+// DefaultRootHints returns a copy of the built-in root hints.
+func DefaultRootHints() []RootHint {
+	out := make([]RootHint, 0, len(builtinRootHints))
+	for _, hint := range builtinRootHints {
+		out = append(out, RootHint{Name: hint.Name, Addrs: slices.Clone(hint.Addrs)})
+	}
+	return out
+}
+
+// ParseRootHints parses root hints using the zone file format used
+// by the named.root file distributed by IANA. We only return the root
+// servers having at least one address and fail with [ErrNoRootHints]
+// if there is no such root server.
+func ParseRootHints(r io.Reader) ([]RootHint, error) {
+	// 1. collect the NS names and addresses
+	var (
+		names []string
+		addrs = make(map[string][]netip.Addr)
+	)
+	zp := dns.NewZoneParser(r, ".", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := dns.CanonicalName(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.NS:
+			if name == "." {
+				names = append(names, dns.CanonicalName(rr.Ns))
+			}
+		case *dns.A:
+			if addr, ok := netip.AddrFromSlice(rr.A.To4()); ok {
+				addrs[name] = append(addrs[name], addr)
+			}
+		case *dns.AAAA:
+			if addr, ok := netip.AddrFromSlice(rr.AAAA.To16()); ok {
+				addrs[name] = append(addrs[name], addr)
+			}
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	// 2. assemble the hints using the NS order
+	var hints []RootHint
+	for _, name := range names {
+		if len(addrs[name]) > 0 {
+			hints = append(hints, RootHint{Name: name, Addrs: addrs[name]})
+		}
+	}
+	if len(hints) <= 0 {
+		return nil, ErrNoRootHints
+	}
+	return hints, nil
+}
+
+// LoadRootHintsFile is like [ParseRootHints] but reads the given file.
+func LoadRootHintsFile(path string) ([]RootHint, error) {
+	filep, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer filep.Close()
+	return ParseRootHints(filep)
+}
+
+// rootHints returns the configured root hints or the built-in ones.
+func (r *IterativeResolver) rootHints() []RootHint {
+	if len(r.RootHints) > 0 {
+		return r.RootHints
+	}
+	return builtinRootHints
+}
+
+// hintsDelegation returns a delegation for the root zone using the root hints.
+func (r *IterativeResolver) hintsDelegation() *iterativeDelegation {
+	deleg := &iterativeDelegation{zone: "."}
+	var v4, v6 []netip.Addr
+	for _, hint := range r.rootHints() {
+		deleg.nameservers = append(deleg.nameservers, dns.CanonicalName(hint.Name))
+		for _, addr := range hint.Addrs {
+			if addr.Unmap().Is4() {
+				v4 = append(v4, addr.Unmap())
+				continue
+			}
+			v6 = append(v6, addr)
+		}
+	}
+	deleg.addrs = append(v4, v6...)
+	return deleg
+}
+
+// Prime sends a priming query (see RFC 8109) for the root zone NS
+// RRset to the servers in the root hints and uses the response as the
+// list of root servers until the NS RRset TTL expires. When we need
+// the root servers and they have expired, we prime again, and we use
+// the root hints if priming fails. Calling this method at startup is
+// optional but avoids paying the priming cost with the first lookup.
+func (r *IterativeResolver) Prime(ctx context.Context) error {
+	// 1. query the root hints servers for the root NS RRset
+	q0 := dns.Question{Name: ".", Qtype: dns.TypeNS, Qclass: dns.ClassINET}
+	resp, err := r.queryZone(ctx, r.hintsDelegation(), q0, 0)
+	if err != nil {
+		return err
+	}
+
+	// 2. build the root delegation using the answer and the glue
+	root := r.newDelegation(".", resp.Answer, resp.Extra)
+	if len(root.nameservers) <= 0 || len(root.addrs) <= 0 {
+		return ErrInvalidResponse
+	}
+
+	// 3. remember the primed root delegation
+	r.mu.Lock()
+	r.root = root
+	r.mu.Unlock()
+	return nil
+}
+
+// rootDelegation returns the primed root delegation, priming again
+// when it is expired, or the root hints when priming fails.
+func (r *IterativeResolver) rootDelegation(ctx context.Context) *iterativeDelegation {
+	r.mu.Lock()
+	root := r.root
+	r.mu.Unlock()
+	if root != nil && r.timeNow().Before(root.expires) {
+		return root
+	}
+	if err := r.Prime(ctx); err != nil {
+		return r.hintsDelegation()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.root
+}
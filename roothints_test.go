@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultRootHints(t *testing.T) {
+	hints := DefaultRootHints()
+	assert.Len(t, hints, 13)
+	assert.Equal(t, "a.root-servers.net.", hints[0].Name)
+	assert.Len(t, hints[0].Addrs, 2)
+
+	// make sure we return a copy of the built-in hints
+	hints[0].Addrs[0] = netip.MustParseAddr("192.0.2.1")
+	assert.Equal(t, "198.41.0.4", DefaultRootHints()[0].Addrs[0].String())
+}
+
+// rootHintsTestFile is an excerpt of the named.root file.
+const rootHintsTestFile = `;       This file holds the information on root name servers needed to
+;       initialize cache of Internet domain name servers
+.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+;
+.                        3600000      NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+;
+.                        3600000      NS    X.ROOT-SERVERS.NET.
+`
+
+func TestParseRootHints(t *testing.T) {
+	t.Run("valid hints", func(t *testing.T) {
+		hints, err := ParseRootHints(strings.NewReader(rootHintsTestFile))
+		assert.NoError(t, err)
+		assert.Equal(t, []RootHint{{
+			Name: "a.root-servers.net.",
+			Addrs: []netip.Addr{
+				netip.MustParseAddr("198.41.0.4"),
+				netip.MustParseAddr("2001:503:ba3e::2:30"),
+			},
+		}, {
+			Name:  "b.root-servers.net.",
+			Addrs: []netip.Addr{netip.MustParseAddr("170.247.170.2")},
+		}}, hints)
+	})
+
+	t.Run("no usable hints", func(t *testing.T) {
+		_, err := ParseRootHints(strings.NewReader(". 3600000 NS X.ROOT-SERVERS.NET.\n"))
+		assert.ErrorIs(t, err, ErrNoRootHints)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := ParseRootHints(strings.NewReader(". 3600000 NS\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadRootHintsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "named.root")
+	assert.NoError(t, os.WriteFile(path, []byte(rootHintsTestFile), 0600))
+	hints, err := LoadRootHintsFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, hints, 2)
+
+	_, err = LoadRootHintsFile(filepath.Join(t.TempDir(), "nonexistent"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestIterativeResolver_Prime(t *testing.T) {
+	t.Run("replaces the hints with the primed root servers", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		reso := h.resolver()
+		reso.RootHints = []RootHint{{
+			Name:  "stale.root.test.",
+			Addrs: []netip.Addr{netip.MustParseAddr("::ffff:192.0.2.1")},
+		}}
+		assert.NoError(t, reso.Prime(context.Background()))
+		assert.Equal(t, []string{"a.root.test."}, reso.root.nameservers)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, reso.root.addrs)
+	})
+
+	t.Run("primes again when the root NS RRset expires", func(t *testing.T) {
+		h := &iterativeTestHierarchy{}
+		now := time.Now()
+		reso := h.resolver()
+		reso.TimeNow = func() time.Time { return now }
+
+		assert.NoError(t, reso.Prime(context.Background()))
+		_, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, h.primes)
+
+		now = now.Add(7 * 24 * time.Hour)
+		_, err = reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, h.primes)
+	})
+
+	t.Run("invalid priming response", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		reso.RootHints = []RootHint{{Name: "a.root.test.", Addrs: []netip.Addr{netip.MustParseAddr("192.0.2.2")}}}
+		assert.ErrorIs(t, reso.Prime(context.Background()), ErrInvalidResponse)
+	})
+}
@@ -0,0 +1,495 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// ErrRPZDropped indicates that a response policy dropped the query,
+// which emulates a server that does not respond.
+var ErrRPZDropped = errors.New("query dropped by response policy")
+
+// RPZTrigger is the kind of trigger of an [*RPZRule].
+type RPZTrigger int
+
+const (
+	// RPZTriggerQName matches the query name.
+	RPZTriggerQName = RPZTrigger(iota)
+
+	// RPZTriggerResponseIP matches the addresses in the answer section.
+	RPZTriggerResponseIP
+
+	// RPZTriggerNSDName matches the names of the authoritative servers.
+	RPZTriggerNSDName
+
+	// RPZTriggerNSIP matches the addresses of the authoritative servers.
+	RPZTriggerNSIP
+)
+
+// String returns the string representation of the trigger.
+func (t RPZTrigger) String() string {
+	switch t {
+	case RPZTriggerQName:
+		return "qname"
+	case RPZTriggerResponseIP:
+		return "response_ip"
+	case RPZTriggerNSDName:
+		return "nsdname"
+	case RPZTriggerNSIP:
+		return "nsip"
+	default:
+		return "unknown"
+	}
+}
+
+// RPZAction is the action of an [*RPZRule].
+type RPZAction int
+
+const (
+	// RPZActionNXDOMAIN responds with NXDOMAIN.
+	RPZActionNXDOMAIN = RPZAction(iota)
+
+	// RPZActionNODATA responds with NOERROR and no answers.
+	RPZActionNODATA
+
+	// RPZActionPassthru forwards the query ignoring the other rules.
+	RPZActionPassthru
+
+	// RPZActionDrop fails with [ErrRPZDropped].
+	RPZActionDrop
+
+	// RPZActionTCPOnly responds with a truncated response to
+	// the queries sent over [ProtocolUDP], to force TCP.
+	RPZActionTCPOnly
+
+	// RPZActionLocalData responds with the records of the rule.
+	RPZActionLocalData
+)
+
+// String returns the string representation of the action.
+func (a RPZAction) String() string {
+	switch a {
+	case RPZActionNXDOMAIN:
+		return "nxdomain"
+	case RPZActionNODATA:
+		return "nodata"
+	case RPZActionPassthru:
+		return "passthru"
+	case RPZActionDrop:
+		return "drop"
+	case RPZActionTCPOnly:
+		return "tcp_only"
+	case RPZActionLocalData:
+		return "local_data"
+	default:
+		return "unknown"
+	}
+}
+
+// RPZRule is a rule of a response policy zone.
+type RPZRule struct {
+	// Trigger is the kind of trigger.
+	Trigger RPZTrigger
+
+	// Action is the action.
+	Action RPZAction
+
+	// Data contains the records to respond with for [RPZActionLocalData].
+	Data []dns.RR
+}
+
+// rpzPrefixRule is an [*RPZRule] triggered by an address prefix.
+type rpzPrefixRule struct {
+	prefix netip.Prefix
+	rule   *RPZRule
+}
+
+// RPZ is a response policy zone, which allows to filter DNS resolutions using
+// the format described by draft-vixie-dnsop-dns-rpz, widely used to distribute
+// threat intelligence feeds. We support QNAME, Response IP, NSDNAME, and NSIP
+// triggers, and the standard policy actions. Since the package does not
+// know the clients addresses, we ignore the Client IP triggers.
+//
+// Construct using [NewRPZ].
+type RPZ struct {
+	// origin is the canonical policy zone origin.
+	origin string
+
+	// qnames contains the QNAME rules, including the wildcard ones.
+	qnames map[string]*RPZRule
+
+	// nsdnames contains the NSDNAME rules, including the wildcard ones.
+	nsdnames map[string]*RPZRule
+
+	// ips contains the Response IP rules.
+	ips []rpzPrefixRule
+
+	// nsips contains the NSIP rules.
+	nsips []rpzPrefixRule
+}
+
+// NewRPZ creates a new [*RPZ] from the rules contained in the given zone,
+// which may be loaded using [ParseZoneFile] or kept in sync using [*Secondary].
+// Following common practice, we ignore malformed triggers rather than failing,
+// such that a single broken record does not disable the whole policy.
+func NewRPZ(zone *Zone) *RPZ {
+	zone.mu.RLock()
+	defer zone.mu.RUnlock()
+	p := &RPZ{
+		origin:   zone.origin,
+		qnames:   make(map[string]*RPZRule),
+		nsdnames: make(map[string]*RPZRule),
+	}
+	for name, rrsets := range zone.nodes {
+		trigger, found := strings.CutSuffix(name, "."+p.origin)
+		if !found || len(rrsets) <= 0 {
+			continue
+		}
+		rule := newRPZRule(rrsets)
+		switch {
+		case strings.HasSuffix(trigger, ".rpz-client-ip") || trigger == "rpz-client-ip":
+		case strings.HasSuffix(trigger, ".rpz-ip"):
+			if prefix, ok := parseRPZPrefix(strings.TrimSuffix(trigger, ".rpz-ip")); ok {
+				rule.Trigger = RPZTriggerResponseIP
+				p.ips = append(p.ips, rpzPrefixRule{prefix, rule})
+			}
+		case strings.HasSuffix(trigger, ".rpz-nsip"):
+			if prefix, ok := parseRPZPrefix(strings.TrimSuffix(trigger, ".rpz-nsip")); ok {
+				rule.Trigger = RPZTriggerNSIP
+				p.nsips = append(p.nsips, rpzPrefixRule{prefix, rule})
+			}
+		case strings.HasSuffix(trigger, ".rpz-nsdname"):
+			rule.Trigger = RPZTriggerNSDName
+			p.nsdnames[strings.TrimSuffix(trigger, ".rpz-nsdname")+"."] = rule
+		default:
+			rule.Trigger = RPZTriggerQName
+			p.qnames[trigger+"."] = rule
+		}
+	}
+	return p
+}
+
+// newRPZRule creates the [*RPZRule] defined by the given RRsets.
+func newRPZRule(rrsets map[uint16][]dns.RR) *RPZRule {
+	rule := &RPZRule{Action: RPZActionLocalData}
+	if cnames := rrsets[dns.TypeCNAME]; len(cnames) > 0 {
+		switch dns.CanonicalName(cnames[0].(*dns.CNAME).Target) {
+		case ".":
+			rule.Action = RPZActionNXDOMAIN
+		case "*.":
+			rule.Action = RPZActionNODATA
+		case "rpz-passthru.":
+			rule.Action = RPZActionPassthru
+		case "rpz-drop.":
+			rule.Action = RPZActionDrop
+		case "rpz-tcp-only.":
+			rule.Action = RPZActionTCPOnly
+		}
+		if rule.Action != RPZActionLocalData {
+			return rule
+		}
+	}
+	for _, rrset := range rrsets {
+		for _, rr := range rrset {
+			if rr.Header().Rrtype != dns.TypeRRSIG && rr.Header().Rrtype != dns.TypeNSEC {
+				rule.Data = append(rule.Data, rr)
+			}
+		}
+	}
+	return rule
+}
+
+// parseRPZPrefix parses the prefix encoded by IP triggers, which consist of
+// the prefix length followed by the address labels in reverse order, where
+// "zz" replaces the longest run of zeros of IPv6 addresses.
+func parseRPZPrefix(trigger string) (netip.Prefix, bool) {
+	labels := strings.Split(trigger, ".")
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil || len(labels) < 2 {
+		return netip.Prefix{}, false
+	}
+	labels = labels[1:]
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	var address string
+	if len(labels) == 4 && !slices.Contains(labels, "zz") {
+		address = strings.Join(labels, ".")
+	} else {
+		address = strings.Join(labels, ":")
+		address = strings.Replace(address, "zz", "", 1)
+		if address == "" {
+			address = "::"
+		}
+		if strings.HasPrefix(address, ":") && address != "::" {
+			address = ":" + address
+		}
+		if strings.HasSuffix(address, ":") && !strings.HasSuffix(address, "::") {
+			address += ":"
+		}
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil || bits > addr.BitLen() {
+		return netip.Prefix{}, false
+	}
+	prefix := netip.PrefixFrom(addr, bits)
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, false
+	}
+	return prefix, true
+}
+
+// matchRPZName returns the rule matching the given canonical name, preferring
+// exact matches and then the wildcards closest to the name, or nil.
+func matchRPZName(rules map[string]*RPZRule, name string) *RPZRule {
+	if rule := rules[name]; rule != nil {
+		return rule
+	}
+	for name != "." {
+		name = zoneParentName(name)
+		if rule := rules["*."+name]; rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// matchRPZAddr returns the rule with the longest prefix matching the
+// given address, or nil.
+func matchRPZAddr(rules []rpzPrefixRule, addr netip.Addr) *RPZRule {
+	var (
+		match *RPZRule
+		bits  = -1
+	)
+	for _, entry := range rules {
+		if entry.prefix.Bits() > bits && entry.prefix.Contains(addr.Unmap()) {
+			match, bits = entry.rule, entry.prefix.Bits()
+		}
+	}
+	return match
+}
+
+// hasResponseTriggers returns whether the policy contains rules that
+// we can only evaluate after receiving the response.
+func (p *RPZ) hasResponseTriggers() bool {
+	return len(p.ips) > 0 || len(p.nsdnames) > 0 || len(p.nsips) > 0
+}
+
+// matchResponse returns the rule matching the given response, if any,
+// using the precedence of triggers defined by the specification.
+func (p *RPZ) matchResponse(resp *dns.Msg) *RPZRule {
+	// 1. match the addresses in the answer section
+	for _, rr := range resp.Answer {
+		if addr, ok := rpzRecordAddr(rr); ok {
+			if rule := matchRPZAddr(p.ips, addr); rule != nil {
+				return rule
+			}
+		}
+	}
+
+	// 2. match the names of the name servers in the authority section
+	// and then their addresses, when included in the additional section
+	nsnames := make(map[string]bool)
+	for _, rr := range resp.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			nsnames[dns.CanonicalName(ns.Ns)] = true
+			if rule := matchRPZName(p.nsdnames, dns.CanonicalName(ns.Ns)); rule != nil {
+				return rule
+			}
+		}
+	}
+	for _, rr := range resp.Extra {
+		if addr, ok := rpzRecordAddr(rr); ok && nsnames[dns.CanonicalName(rr.Header().Name)] {
+			if rule := matchRPZAddr(p.nsips, addr); rule != nil {
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+// rpzRecordAddr returns the address contained in A and AAAA records.
+func rpzRecordAddr(rr dns.RR) (netip.Addr, bool) {
+	switch v := rr.(type) {
+	case *dns.A:
+		return netip.AddrFromSlice(v.A.To4())
+	case *dns.AAAA:
+		return netip.AddrFromSlice(v.AAAA)
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+// RPZTransport is a [ResolverTransport] that applies response policy zones
+// to the queries forwarded to the underlying transport. We evaluate the
+// policies in order, and the first matching policy wins. Within a policy,
+// QNAME rules take precedence over Response IP rules, which take precedence
+// over NSDNAME rules, which take precedence over NSIP rules. We apply QNAME
+// rules before forwarding the query, unless a previous policy contains rules
+// depending on the response. We evaluate NSDNAME and NSIP rules using the
+// authority and additional sections of the response, which recursive
+// resolvers usually leave empty, hence these rules are best effort. When the
+// query uses EDNS(0), the responses we synthesize include an Extended DNS
+// Error (RFC 8914) saying that we blocked the query or forged the answer.
+//
+// Construct using [NewRPZTransport].
+type RPZTransport struct {
+	// Transport is the underlying transport.
+	Transport ResolverTransport
+
+	// policies contains the policies.
+	policies atomic.Pointer[[]*RPZ]
+}
+
+// Ensure that [*RPZTransport] implements [ResolverTransport].
+var _ ResolverTransport = (*RPZTransport)(nil)
+
+// NewRPZTransport creates a new [*RPZTransport] that uses the
+// given transport and applies the given policies.
+func NewRPZTransport(txp ResolverTransport, policies ...*RPZ) *RPZTransport {
+	t := &RPZTransport{Transport: txp}
+	t.SetPolicies(policies...)
+	return t
+}
+
+// SetPolicies replaces the policies, e.g., when a policy zone changes,
+// without affecting the queries in progress.
+func (t *RPZTransport) SetPolicies(policies ...*RPZ) {
+	t.policies.Store(&policies)
+}
+
+// Query implements [ResolverTransport].
+func (t *RPZTransport) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. skip the policies for queries we do not understand
+	policies := *t.policies.Load()
+	if len(query.Question) != 1 || len(policies) <= 0 {
+		return t.Transport.Query(ctx, addr, query)
+	}
+	qname := dns.CanonicalName(query.Question[0].Name)
+
+	// 2. apply the first matching QNAME rule before forwarding the query,
+	// unless a previous policy could match the response
+	for _, policy := range policies {
+		if rule := matchRPZName(policy.qnames, qname); rule != nil {
+			return t.apply(ctx, addr, query, rule)
+		}
+		if policy.hasResponseTriggers() {
+			break
+		}
+	}
+
+	// 3. forward the query and apply the first matching rule
+	resp, err := t.Transport.Query(ctx, addr, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		rule := matchRPZName(policy.qnames, qname)
+		if rule == nil {
+			rule = policy.matchResponse(resp)
+		}
+		if rule == nil {
+			continue
+		}
+		if rule.Action == RPZActionPassthru || (rule.Action == RPZActionTCPOnly && addr.Protocol != ProtocolUDP) {
+			return resp, nil
+		}
+		return t.apply(ctx, addr, query, rule)
+	}
+	return resp, nil
+}
+
+// apply applies the given rule to the given query.
+func (t *RPZTransport) apply(ctx context.Context, addr *ServerAddr, query *dns.Msg, rule *RPZRule) (*dns.Msg, error) {
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	resp.RecursionAvailable = true
+	switch rule.Action {
+	case RPZActionNXDOMAIN:
+		resp.Rcode = dns.RcodeNameError
+		rpzSetExtendedError(query, resp, dns.ExtendedErrorCodeBlocked)
+	case RPZActionNODATA:
+		rpzSetExtendedError(query, resp, dns.ExtendedErrorCodeBlocked)
+	case RPZActionDrop:
+		return nil, ErrRPZDropped
+	case RPZActionTCPOnly:
+		if addr.Protocol != ProtocolUDP {
+			return t.Transport.Query(ctx, addr, query)
+		}
+		resp.Truncated = true
+	case RPZActionLocalData:
+		rpzSetExtendedError(query, resp, dns.ExtendedErrorCodeForgedAnswer)
+		return t.localData(ctx, addr, query, resp, rule)
+	default:
+		return t.Transport.Query(ctx, addr, query)
+	}
+	return resp, nil
+}
+
+// rpzSetExtendedError adds to the response the Extended DNS Error (RFC 8914)
+// with the given code, such that clients can tell that the response was
+// synthesized by a policy. We only add it when the query uses EDNS(0).
+func rpzSetExtendedError(query, resp *dns.Msg, code uint16) {
+	opt := query.IsEdns0()
+	if opt == nil {
+		return
+	}
+	resp.SetEdns0(opt.UDPSize(), opt.Do())
+	resp.IsEdns0().Option = append(resp.IsEdns0().Option, &dns.EDNS0_EDE{
+		InfoCode:  code,
+		ExtraText: "response policy zone",
+	})
+}
+
+// localData fills the response using the local data of the given rule. When
+// the rule contains a CNAME, we resolve the target using the underlying
+// transport, without applying the policies, to avoid loops.
+func (t *RPZTransport) localData(ctx context.Context,
+	addr *ServerAddr, query, resp *dns.Msg, rule *RPZRule) (*dns.Msg, error) {
+	// 1. answer using the records matching the query type
+	q0 := query.Question[0]
+	var cname *dns.CNAME
+	for _, rr := range rule.Data {
+		hdr := rr.Header()
+		switch {
+		case hdr.Rrtype == q0.Qtype || q0.Qtype == dns.TypeANY:
+		case hdr.Rrtype == dns.TypeCNAME:
+		default:
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = q0.Name
+		if v, ok := rr.(*dns.CNAME); ok {
+			// "CNAME *.example." rewrites the query name below example.
+			if suffix, found := strings.CutPrefix(v.Target, "*."); found {
+				v.Target = dns.Fqdn(q0.Name) + suffix
+			}
+			cname = v
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+
+	// 2. follow the CNAME, if any
+	if cname == nil || q0.Qtype == dns.TypeCNAME || q0.Qtype == dns.TypeANY {
+		return resp, nil
+	}
+	resp.Answer = []dns.RR{cname}
+	target := query.Copy()
+	target.Question[0].Name = cname.Target
+	tresp, err := t.Transport.Query(ctx, addr, target)
+	if err != nil {
+		return nil, err
+	}
+	resp.Rcode = tresp.Rcode
+	resp.Answer = append(resp.Answer, tresp.Answer...)
+	return resp, nil
+}
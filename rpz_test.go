@@ -0,0 +1,408 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/stretchr/testify/assert"
+)
+
+// rpzTestData is the policy zone used by the tests.
+const rpzTestData = `$ORIGIN rpz.invalid.
+@ 0 IN SOA invalid. invalid. 1 3600 600 86400 0
+@ 0 IN NS invalid.
+bad.example.com 0 IN CNAME .
+*.wild.example.com 0 IN CNAME *.
+ok.wild.example.com 0 IN CNAME rpz-passthru.
+drop.example.com 0 IN CNAME rpz-drop.
+tcp.example.com 0 IN CNAME rpz-tcp-only.
+local.example.com 0 IN A 10.0.0.1
+local.example.com 0 IN TXT "blocked"
+garden.example.com 0 IN CNAME *.walled.invalid.
+24.0.2.0.192.rpz-ip 0 IN CNAME .
+32.66.2.0.192.rpz-ip 0 IN CNAME rpz-passthru.
+ns.evil.rpz-nsdname 0 IN CNAME .
+32.53.113.0.203.rpz-nsip 0 IN CNAME *.
+32.1.0.0.10.rpz-client-ip 0 IN CNAME .
+bad.rpz-ip 0 IN CNAME .
+`
+
+func TestRPZTrigger_String(t *testing.T) {
+	tests := []struct {
+		trigger  RPZTrigger
+		expected string
+	}{
+		{RPZTriggerQName, "qname"},
+		{RPZTriggerResponseIP, "response_ip"},
+		{RPZTriggerNSDName, "nsdname"},
+		{RPZTriggerNSIP, "nsip"},
+		{RPZTrigger(100), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.trigger.String())
+		})
+	}
+}
+
+func TestRPZAction_String(t *testing.T) {
+	tests := []struct {
+		action   RPZAction
+		expected string
+	}{
+		{RPZActionNXDOMAIN, "nxdomain"},
+		{RPZActionNODATA, "nodata"},
+		{RPZActionPassthru, "passthru"},
+		{RPZActionDrop, "drop"},
+		{RPZActionTCPOnly, "tcp_only"},
+		{RPZActionLocalData, "local_data"},
+		{RPZAction(100), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.action.String())
+		})
+	}
+}
+
+func TestParseRPZPrefix(t *testing.T) {
+	tests := []struct {
+		trigger  string
+		expected string
+	}{
+		{"32.1.2.0.192", "192.0.2.1/32"},
+		{"24.0.2.0.192", "192.0.2.0/24"},
+		{"128.1.zz.db8.2001", "2001:db8::1/128"},
+		{"48.zz.db8.2001", "2001:db8::/48"},
+		{"128.1.zz", "::1/128"},
+		{"0.zz", "::/0"},
+		{"24.1.2.0.192", ""},
+		{"33.1.2.0.192", ""},
+		{"x.1.2.0.192", ""},
+		{"32", ""},
+		{"32.1.2.192", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.trigger, func(t *testing.T) {
+			prefix, ok := parseRPZPrefix(tt.trigger)
+			assert.Equal(t, tt.expected != "", ok)
+			if ok {
+				assert.Equal(t, netip.MustParsePrefix(tt.expected), prefix)
+			}
+		})
+	}
+}
+
+func TestNewRPZ(t *testing.T) {
+	p := NewRPZ(runtimex.Try1(ParseZone(strings.NewReader(rpzTestData), "rpz.invalid", "")))
+
+	tests := []struct {
+		name     string
+		rule     *RPZRule
+		trigger  RPZTrigger
+		action   RPZAction
+		dataSize int
+	}{
+		{"exact", matchRPZName(p.qnames, "bad.example.com."), RPZTriggerQName, RPZActionNXDOMAIN, 0},
+		{"wildcard", matchRPZName(p.qnames, "a.b.wild.example.com."), RPZTriggerQName, RPZActionNODATA, 0},
+		{"exact over wildcard", matchRPZName(p.qnames, "ok.wild.example.com."), RPZTriggerQName, RPZActionPassthru, 0},
+		{"drop", matchRPZName(p.qnames, "drop.example.com."), RPZTriggerQName, RPZActionDrop, 0},
+		{"tcp only", matchRPZName(p.qnames, "tcp.example.com."), RPZTriggerQName, RPZActionTCPOnly, 0},
+		{"local data", matchRPZName(p.qnames, "local.example.com."), RPZTriggerQName, RPZActionLocalData, 2},
+		{"response ip", matchRPZAddr(p.ips, netip.MustParseAddr("192.0.2.1")), RPZTriggerResponseIP, RPZActionNXDOMAIN, 0},
+		{"longest prefix", matchRPZAddr(p.ips, netip.MustParseAddr("192.0.2.66")), RPZTriggerResponseIP, RPZActionPassthru, 0},
+		{"nsdname", matchRPZName(p.nsdnames, "ns.evil."), RPZTriggerNSDName, RPZActionNXDOMAIN, 0},
+		{"nsip", matchRPZAddr(p.nsips, netip.MustParseAddr("203.0.113.53")), RPZTriggerNSIP, RPZActionNODATA, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if assert.NotNil(t, tt.rule) {
+				assert.Equal(t, tt.trigger, tt.rule.Trigger)
+				assert.Equal(t, tt.action, tt.rule.Action)
+				assert.Len(t, tt.rule.Data, tt.dataSize)
+			}
+		})
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		assert.Nil(t, matchRPZName(p.qnames, "example.com."))
+		assert.Nil(t, matchRPZName(p.qnames, "wild.example.com."))
+		assert.Nil(t, matchRPZAddr(p.ips, netip.MustParseAddr("198.51.100.1")))
+		assert.Nil(t, matchRPZName(p.qnames, "32.1.0.0.10.rpz-client-ip."))
+		assert.Len(t, p.ips, 2)
+	})
+}
+
+func TestRPZTransport(t *testing.T) {
+	addrs := map[string]string{
+		"www.example.com.":                   "198.51.100.1",
+		"tcp.example.com.":                   "198.51.100.2",
+		"bad.example.com.":                   "198.51.100.3",
+		"blocked.example.com.":               "192.0.2.1",
+		"allowed.example.com.":               "192.0.2.66",
+		"garden.example.com.walled.invalid.": "198.51.100.4",
+		"ok.wild.example.com.":               "198.51.100.5",
+	}
+	udp := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	tcp := NewServerAddr(ProtocolTCP, "8.8.8.8:53")
+
+	// newUpstream returns a transport answering A queries using the
+	// addresses above and counting the queries it receives.
+	newUpstream := func(count *int) *MockResolverTransport {
+		return &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				*count++
+				resp := &dns.Msg{}
+				resp.SetReply(query)
+				q0 := query.Question[0]
+				switch name := dns.CanonicalName(q0.Name); {
+				case name == "delegated.example.com.":
+					ns, _ := dns.NewRR("delegated.example.com. 0 IN NS ns.evil.")
+					glue, _ := dns.NewRR("ns.evil. 0 IN A 203.0.113.53")
+					resp.Ns = []dns.RR{ns}
+					resp.Extra = []dns.RR{glue}
+				case addrs[name] != "":
+					rr, _ := dns.NewRR(q0.Name + " 0 IN A " + addrs[name])
+					resp.Answer = []dns.RR{rr}
+				default:
+					resp.Rcode = dns.RcodeNameError
+				}
+				return resp, nil
+			},
+		}
+	}
+
+	// parsePolicy parses the given policy zone.
+	parsePolicy := func(data string) *RPZ {
+		return NewRPZ(runtimex.Try1(ParseZone(strings.NewReader(data), "rpz.invalid", "")))
+	}
+
+	tests := []struct {
+		name     string
+		addr     *ServerAddr
+		qname    string
+		qtype    uint16
+		rcode    int
+		answer   []string
+		trunc    bool
+		queries  int
+		err      error
+		policies []string
+	}{{
+		name:    "no match",
+		qname:   "www.example.com",
+		rcode:   dns.RcodeSuccess,
+		answer:  []string{"www.example.com.\t0\tIN\tA\t198.51.100.1"},
+		queries: 1,
+	}, {
+		name:    "nxdomain",
+		qname:   "bad.example.com",
+		rcode:   dns.RcodeNameError,
+		queries: 0,
+	}, {
+		name:    "nodata",
+		qname:   "x.wild.example.com",
+		rcode:   dns.RcodeSuccess,
+		queries: 0,
+	}, {
+		name:    "passthru",
+		qname:   "ok.wild.example.com",
+		rcode:   dns.RcodeSuccess,
+		answer:  []string{"ok.wild.example.com.\t0\tIN\tA\t198.51.100.5"},
+		queries: 1,
+	}, {
+		name:  "drop",
+		qname: "drop.example.com",
+		err:   ErrRPZDropped,
+	}, {
+		name:    "tcp only over UDP",
+		qname:   "tcp.example.com",
+		rcode:   dns.RcodeSuccess,
+		trunc:   true,
+		queries: 0,
+	}, {
+		name:    "tcp only over TCP",
+		addr:    tcp,
+		qname:   "tcp.example.com",
+		rcode:   dns.RcodeSuccess,
+		answer:  []string{"tcp.example.com.\t0\tIN\tA\t198.51.100.2"},
+		queries: 1,
+	}, {
+		name:    "local data",
+		qname:   "LOCAL.example.com",
+		rcode:   dns.RcodeSuccess,
+		answer:  []string{"LOCAL.example.com.\t0\tIN\tA\t10.0.0.1"},
+		queries: 0,
+	}, {
+		name:    "local data without matching type",
+		qname:   "local.example.com",
+		qtype:   dns.TypeAAAA,
+		rcode:   dns.RcodeSuccess,
+		queries: 0,
+	}, {
+		name:  "local data with CNAME",
+		qname: "garden.example.com",
+		rcode: dns.RcodeSuccess,
+		answer: []string{
+			"garden.example.com.\t0\tIN\tCNAME\tgarden.example.com.walled.invalid.",
+			"garden.example.com.walled.invalid.\t0\tIN\tA\t198.51.100.4",
+		},
+		queries: 1,
+	}, {
+		name:    "response ip",
+		qname:   "blocked.example.com",
+		rcode:   dns.RcodeNameError,
+		queries: 1,
+	}, {
+		name:    "response ip passthru",
+		qname:   "allowed.example.com",
+		rcode:   dns.RcodeSuccess,
+		answer:  []string{"allowed.example.com.\t0\tIN\tA\t192.0.2.66"},
+		queries: 1,
+	}, {
+		name:    "nsdname",
+		qname:   "delegated.example.com",
+		rcode:   dns.RcodeNameError,
+		queries: 1,
+		policies: []string{
+			"ns.evil.rpz-nsdname 0 IN CNAME .\n",
+		},
+	}, {
+		name:    "nsip",
+		qname:   "delegated.example.com",
+		rcode:   dns.RcodeSuccess,
+		queries: 1,
+		policies: []string{
+			"32.53.113.0.203.rpz-nsip 0 IN CNAME *.\n",
+		},
+	}, {
+		name:    "first policy wins",
+		qname:   "bad.example.com",
+		rcode:   dns.RcodeSuccess,
+		answer:  []string{"bad.example.com.\t0\tIN\tA\t198.51.100.3"},
+		queries: 1,
+		policies: []string{
+			"bad.example.com 0 IN CNAME rpz-passthru.\n",
+			"bad.example.com 0 IN CNAME .\n",
+		},
+	}, {
+		name:    "response triggers of previous policies",
+		qname:   "bad.example.com",
+		rcode:   dns.RcodeNameError,
+		queries: 1,
+		policies: []string{
+			"24.0.2.0.192.rpz-ip 0 IN CNAME .\n",
+			"bad.example.com 0 IN CNAME .\n",
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1. create the policies
+			var policies []*RPZ
+			for _, data := range tt.policies {
+				policies = append(policies, parsePolicy(strings.Split(rpzTestData, "bad.")[0]+data))
+			}
+			if len(policies) <= 0 {
+				policies = append(policies, parsePolicy(rpzTestData))
+			}
+
+			// 2. send the query
+			var count int
+			txp := NewRPZTransport(newUpstream(&count), policies...)
+			addr := tt.addr
+			if addr == nil {
+				addr = udp
+			}
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dns.TypeA
+			}
+			query := &dns.Msg{}
+			query.SetQuestion(dns.Fqdn(tt.qname), qtype)
+			resp, err := txp.Query(context.Background(), addr, query)
+
+			// 3. check the response
+			assert.ErrorIs(t, err, tt.err)
+			if tt.err != nil {
+				return
+			}
+			assert.Equal(t, tt.queries, count)
+			assert.Equal(t, tt.rcode, resp.Rcode)
+			assert.Equal(t, tt.trunc, resp.Truncated)
+			assert.Equal(t, query.Id, resp.Id)
+			var answer []string
+			for _, rr := range resp.Answer {
+				answer = append(answer, rr.String())
+			}
+			assert.Equal(t, tt.answer, answer)
+		})
+	}
+
+	t.Run("SetPolicies", func(t *testing.T) {
+		var count int
+		txp := NewRPZTransport(newUpstream(&count))
+		query := &dns.Msg{}
+		query.SetQuestion("bad.example.com.", dns.TypeA)
+		resp, err := txp.Query(context.Background(), udp, query)
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+
+		txp.SetPolicies(parsePolicy(rpzTestData))
+		resp, err = txp.Query(context.Background(), udp, query)
+		assert.NoError(t, err)
+		assert.Equal(t, dns.RcodeNameError, resp.Rcode)
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestRPZTransport_extendedError(t *testing.T) {
+	tests := []struct {
+		name     string
+		qname    string
+		edns0    bool
+		expected []uint16
+	}{
+		{"blocked", "bad.example.com", true, []uint16{dns.ExtendedErrorCodeBlocked}},
+		{"nodata", "x.wild.example.com", true, []uint16{dns.ExtendedErrorCodeBlocked}},
+		{"forged", "local.example.com", true, []uint16{dns.ExtendedErrorCodeForgedAnswer}},
+		{"forwarded", "www.example.com", true, nil},
+		{"without EDNS(0)", "bad.example.com", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txp := NewRPZTransport(answerFromRecords(t, "www.example.com. 0 IN A 198.51.100.1"),
+				NewRPZ(runtimex.Try1(ParseZone(strings.NewReader(rpzTestData), "rpz.invalid", ""))))
+			query := &dns.Msg{}
+			query.SetQuestion(dns.Fqdn(tt.qname), dns.TypeA)
+			if tt.edns0 {
+				query.SetEdns0(1232, true)
+			}
+			resp, err := txp.Query(context.Background(), NewServerAddr(ProtocolUDP, "8.8.8.8:53"), query)
+			assert.NoError(t, err)
+
+			var codes []uint16
+			if opt := resp.IsEdns0(); opt != nil {
+				assert.Equal(t, uint16(1232), opt.UDPSize())
+				assert.True(t, opt.Do())
+				for _, option := range opt.Option {
+					if ede, ok := option.(*dns.EDNS0_EDE); ok {
+						codes = append(codes, ede.InfoCode)
+					}
+				}
+			}
+			assert.Equal(t, tt.expected, codes)
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultSecondaryRetryInterval is the default interval between attempts
+// to transfer a zone we have not transferred yet.
+const DefaultSecondaryRetryInterval = 30 * time.Second
+
+// Secondary keeps a local copy of a zone in sync with its primary server,
+// like a secondary server does. We poll the primary SOA according to the
+// SOA refresh and retry timers, transfer the zone using [*Transport.TransferZone]
+// when the serial increases, and stop serving the zone when we cannot reach
+// the primary for longer than the SOA expire timer. Since the package does
+// not include a server, callers receiving NOTIFY messages (RFC 1996) should
+// use [*Secondary.HandleNotify] to check the primary immediately.
+//
+// Construct using [NewSecondary].
+type Secondary struct {
+	// Origin is the MANDATORY zone origin.
+	Origin string
+
+	// OnChange is the optional callback invoked after each successful
+	// transfer with the previous zone, which is nil after the first
+	// transfer, and the new zone. We invoke this callback from the
+	// goroutine refreshing the zone, so it should not block.
+	OnChange func(prev, zone *Zone)
+
+	// Primary is the MANDATORY primary server address, which must
+	// use either [ProtocolTCP] or [ProtocolDoT].
+	Primary *ServerAddr
+
+	// RetryInterval is the optional interval between attempts to
+	// transfer the zone until the first transfer succeeds.
+	//
+	// If zero, we use [DefaultSecondaryRetryInterval].
+	RetryInterval time.Duration
+
+	// VerifyDigest optionally enables verifying the transferred zones
+	// using [*Zone.VerifyDigest], which is useful for mirroring zones
+	// publishing a ZONEMD record (RFC 8976), e.g., the root zone.
+	VerifyDigest bool
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the optional DNS transport to use.
+	//
+	// If nil, we use [DefaultTransport].
+	Transport *Transport
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// zone is the current zone or nil.
+	zone *Zone
+
+	// checked is when the primary last confirmed the zone serial.
+	checked time.Time
+
+	// notify wakes up [*Secondary.Run] to check the primary.
+	notify chan struct{}
+}
+
+// NewSecondary creates a new [*Secondary] for the given origin and primary.
+func NewSecondary(origin string, primary *ServerAddr) *Secondary {
+	return &Secondary{Origin: origin, Primary: primary}
+}
+
+// transport returns the transport to use.
+func (s *Secondary) transport() *Transport {
+	if s.Transport != nil {
+		return s.Transport
+	}
+	return DefaultTransport
+}
+
+// timeNow returns the current time.
+func (s *Secondary) timeNow() time.Time {
+	if s.TimeNow != nil {
+		return s.TimeNow()
+	}
+	return time.Now()
+}
+
+// notifyChan returns the channel used to wake up [*Secondary.Run].
+func (s *Secondary) notifyChan() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.notify == nil {
+		s.notify = make(chan struct{}, 1)
+	}
+	return s.notify
+}
+
+// Zone returns the current zone or nil when we have not transferred
+// the zone yet or the zone expired, since the primary has not confirmed
+// the zone serial for longer than the SOA expire timer.
+func (s *Secondary) Zone() *Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.zone == nil {
+		return nil
+	}
+	expire := time.Duration(s.zone.SOA().Expire) * time.Second
+	if s.timeNow().Sub(s.checked) > expire {
+		return nil
+	}
+	return s.zone
+}
+
+// Run keeps the zone in sync until the context is done. The first check
+// happens immediately. We check again after the SOA refresh timer, or after
+// the SOA retry timer when the check fails, or when notified.
+func (s *Secondary) Run(ctx context.Context) {
+	notify := s.notifyChan()
+	for {
+		_, err := s.RefreshOnce(ctx)
+		timer := time.NewTimer(s.nextCheck(err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// nextCheck returns how long to wait before checking the primary again
+// given the error returned by the last check.
+func (s *Secondary) nextCheck(err error) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.zone == nil && s.RetryInterval > 0:
+		return s.RetryInterval
+	case s.zone == nil:
+		return DefaultSecondaryRetryInterval
+	case err != nil:
+		return time.Duration(s.zone.SOA().Retry) * time.Second
+	default:
+		return time.Duration(s.zone.SOA().Refresh) * time.Second
+	}
+}
+
+// RefreshOnce queries the primary SOA and transfers the zone if we do not
+// have it yet or the primary serial is greater than ours, according to the
+// serial number arithmetic (RFC 1982). We return whether the zone changed.
+func (s *Secondary) RefreshOnce(ctx context.Context) (bool, error) {
+	// 1. query the primary SOA
+	txp := s.transport()
+	query, err := NewQueryWithServerAddr(s.Primary, s.Origin, dns.TypeSOA)
+	if err != nil {
+		return false, err
+	}
+	resp, err := txp.Query(ctx, s.Primary, query)
+	if err != nil {
+		return false, err
+	}
+	if err := ValidateResponse(query, resp); err != nil {
+		return false, err
+	}
+	var (
+		serial uint32
+		found  bool
+	)
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && dns.CanonicalName(soa.Hdr.Name) == dns.CanonicalName(s.Origin) {
+			serial, found = soa.Serial, true
+		}
+	}
+	if resp.Rcode != dns.RcodeSuccess || !found {
+		return false, fmt.Errorf("%w: cannot get the primary SOA", ErrZoneTransfer)
+	}
+
+	// 2. do nothing if our zone is up to date
+	prev := s.current()
+	if prev != nil && int32(serial-prev.SOA().Serial) <= 0 {
+		s.mu.Lock()
+		s.checked = s.timeNow()
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	// 3. transfer and possibly verify the zone, then notify the change
+	zone, err := txp.TransferZone(ctx, s.Primary, s.Origin)
+	if err != nil {
+		return false, err
+	}
+	if s.VerifyDigest {
+		if err := zone.VerifyDigest(); err != nil {
+			return false, err
+		}
+	}
+	s.mu.Lock()
+	s.zone, s.checked = zone, s.timeNow()
+	s.mu.Unlock()
+	if s.OnChange != nil {
+		s.OnChange(prev, zone)
+	}
+	return true, nil
+}
+
+// current returns the current zone, including when it expired.
+func (s *Secondary) current() *Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.zone
+}
+
+// HandleNotify handles a NOTIFY message (RFC 1996) received by the caller,
+// returning the response to send. When the message is a valid NOTIFY for
+// the zone, we wake up [*Secondary.Run] to check the primary immediately.
+// Callers should only accept NOTIFY messages sent by the primary.
+func (s *Secondary) HandleNotify(query *dns.Msg) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	switch {
+	case query.Opcode != dns.OpcodeNotify || len(query.Question) != 1:
+		resp.Rcode = dns.RcodeFormatError
+	case query.Question[0].Qtype != dns.TypeSOA ||
+		dns.CanonicalName(query.Question[0].Name) != dns.CanonicalName(s.Origin):
+		resp.Rcode = dns.RcodeNotAuth
+	default:
+		resp.Authoritative = true
+		select {
+		case s.notifyChan() <- struct{}{}:
+		default:
+		}
+	}
+	return resp
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecondary_RefreshOnce(t *testing.T) {
+	serial := &atomic.Uint32{}
+	serial.Store(10)
+	now := time.Now()
+	primary, _ := startStreamServer(t, serveXfrTest(func() []string {
+		return xfrTestRecordsWithSerial(serial.Load())
+	}, nil))
+	sec := NewSecondary("example.com", primary)
+	sec.TimeNow = func() time.Time { return now }
+	var changes []string
+	sec.OnChange = func(prev, zone *Zone) {
+		var prevSerial uint32
+		if prev != nil {
+			prevSerial = prev.SOA().Serial
+		}
+		changes = append(changes, fmt.Sprintf("%d -> %d", prevSerial, zone.SOA().Serial))
+	}
+	assert.Nil(t, sec.Zone())
+
+	steps := []struct {
+		serial  uint32
+		changed bool
+	}{
+		{10, true},
+		{10, false},
+		{11, true},
+		{5, false},
+		{farthestSecondaryTestSerial(11), true},
+		{3, true},
+	}
+	for _, step := range steps {
+		serial.Store(step.serial)
+		changed, err := sec.RefreshOnce(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, step.changed, changed, step.serial)
+	}
+	farthest := farthestSecondaryTestSerial(11)
+	assert.Equal(t, []string{"0 -> 10", "10 -> 11", fmt.Sprintf("11 -> %d", farthest), fmt.Sprintf("%d -> 3", farthest)}, changes)
+	assert.NotNil(t, sec.Zone())
+
+	now = now.Add(86401 * time.Second)
+	assert.Nil(t, sec.Zone())
+
+	t.Run("unreachable primaries", func(t *testing.T) {
+		sec := NewSecondary("example.com", NewServerAddr(ProtocolTCP, "127.0.0.1:1"))
+		_, err := sec.RefreshOnce(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, DefaultSecondaryRetryInterval, sec.nextCheck(err))
+	})
+}
+
+// farthestSecondaryTestSerial returns the farthest serial that is still greater
+// than the given one according to the serial number arithmetic (RFC 1982).
+func farthestSecondaryTestSerial(serial uint32) uint32 {
+	return serial + 1<<31 - 1
+}
+
+func TestSecondary_HandleNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		opcode   int
+		qname    string
+		expected int
+	}{
+		{"valid", dns.OpcodeNotify, "EXAMPLE.com.", dns.RcodeSuccess},
+		{"not a NOTIFY", dns.OpcodeQuery, "example.com.", dns.RcodeFormatError},
+		{"another zone", dns.OpcodeNotify, "example.org.", dns.RcodeNotAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sec := NewSecondary("example.com", nil)
+			query := &dns.Msg{}
+			query.SetNotify(tt.qname)
+			query.Opcode = tt.opcode
+			resp := sec.HandleNotify(query)
+			assert.Equal(t, tt.expected, resp.Rcode)
+			assert.Equal(t, tt.expected == dns.RcodeSuccess, resp.Authoritative)
+		})
+	}
+}
+
+func TestSecondary_Run(t *testing.T) {
+	serial := &atomic.Uint32{}
+	serial.Store(1)
+	primary, _ := startStreamServer(t, serveXfrTest(func() []string {
+		return xfrTestRecordsWithSerial(serial.Load())
+	}, nil))
+	sec := NewSecondary("example.com", primary)
+	changes := make(chan uint32, 2)
+	sec.OnChange = func(prev, zone *Zone) {
+		changes <- zone.SOA().Serial
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sec.Run(ctx)
+	}()
+
+	assert.Equal(t, uint32(1), <-changes)
+	assert.Equal(t, time.Hour, sec.nextCheck(nil))
+	assert.Equal(t, 10*time.Minute, sec.nextCheck(ErrZoneTransfer))
+
+	serial.Store(2)
+	query := &dns.Msg{}
+	query.SetNotify("example.com.")
+	sec.HandleNotify(query)
+	assert.Equal(t, uint32(2), <-changes)
+
+	cancel()
+	<-done
+}
@@ -2,6 +2,8 @@
 
 package dnscore
 
+import "net/http"
+
 // Protocol is a transport protocol.
 type Protocol string
 
@@ -18,6 +20,18 @@ const (
 
 	// ProtocolDoH is DNS over HTTPS.
 	ProtocolDoH = Protocol("doh")
+
+	// ProtocolDoHJSON is DNS over HTTPS using the JSON API
+	// (application/dns-json) offered by Google and Cloudflare.
+	ProtocolDoHJSON = Protocol("doh+json")
+
+	// ProtocolHTTP is DNS over cleartext HTTP/1.1, which is only
+	// available when the [*Transport] Insecure field is true.
+	ProtocolHTTP = Protocol("http")
+
+	// ProtocolH2C is DNS over cleartext HTTP/2 with prior knowledge, which
+	// is only available when the [*Transport] Insecure field is true.
+	ProtocolH2C = Protocol("h2c")
 )
 
 // Name aliases for DNS protocols.
@@ -31,9 +45,9 @@ const (
 
 // ServerAddr is a DNS server address.
 //
-// While currently minimal, ServerAddr is designed as a pointer type to
-// allow for future extensions of server-specific properties (e.g., custom
-// headers for DoH) without requiring breaking API changes.
+// ServerAddr is designed as a pointer type to allow for server-specific
+// properties (e.g., custom headers for DoH) without requiring breaking
+// API changes.
 //
 // Construct using [NewServerAddr].
 type ServerAddr struct {
@@ -45,6 +59,9 @@ type ServerAddr struct {
 	// - [ProtocolTCP]
 	// - [ProtocolDoT]
 	// - [ProtocolDoH]
+	// - [ProtocolDoHJSON]
+	// - [ProtocolHTTP]
+	// - [ProtocolH2C]
 	Protocol Protocol
 
 	// Address is the network address of the server.
@@ -52,8 +69,28 @@ type ServerAddr struct {
 	// For [ProtocolUDP], [ProtocolTCP], and [ProtocolDoT] this is
 	// a string in the form returned by [net.JoinHostPort].
 	//
-	// For [ProtocolDoH] this is a URL.
+	// For [ProtocolDoH], [ProtocolDoHJSON], [ProtocolHTTP], and
+	// [ProtocolH2C] this is a URL or an RFC 8484 URI template such
+	// as "https://dns.example.com/dns-query{?dns}".
 	Address string
+
+	// Header contains optional extra HTTP headers to send along with
+	// each HTTP-based query. The headers required by the protocol (e.g.,
+	// content-type for [ProtocolDoH]) always take precedence.
+	Header http.Header
+
+	// UserAgent is the optional User-Agent for HTTP-based queries.
+	UserAgent string
+
+	// BearerToken is the optional token to send in the Authorization
+	// header of HTTP-based queries using the Bearer scheme.
+	BearerToken string
+
+	// Username and Password are the optional credentials to send
+	// in the Authorization header of HTTP-based queries using the
+	// Basic scheme. We only use them when Username is not empty and
+	// BearerToken is empty.
+	Username, Password string
 }
 
 // NewServerAddr constructs a new [*ServerAddr] with the given protocol and address.
@@ -63,3 +100,15 @@ func NewServerAddr(protocol Protocol, address string) *ServerAddr {
 		Address:  address,
 	}
 }
+
+// serverKey uniquely identifies a server by protocol and address
+// and is suitable for use as a map key.
+type serverKey struct {
+	protocol Protocol
+	address  string
+}
+
+// key returns the [serverKey] for this [*ServerAddr].
+func (a *ServerAddr) key() serverKey {
+	return serverKey{protocol: a.Protocol, address: a.Address}
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverAddrRefreshTimeout is the maximum amount of time we
+// spend asynchronously refreshing the addresses of a server.
+const serverAddrRefreshTimeout = 10 * time.Second
+
+// serverAddrCache caches the addresses of the servers configured
+// using a hostname when ServerAddrCacheTTL is positive.
+//
+// The zero value is ready to use.
+type serverAddrCache struct {
+	// entries maps a network and a hostname to the cached entry.
+	entries map[serverAddrCacheKey]*serverAddrCacheEntry
+
+	// lookup is the optional function to resolve hostnames, which
+	// is useful for testing. If nil, we use [net.DefaultResolver].
+	lookup func(ctx context.Context, network, host string) ([]netip.Addr, error)
+
+	// mu protects the fields above and the entries.
+	mu sync.Mutex
+}
+
+// serverAddrCacheKey is the key of a [*serverAddrCacheEntry].
+type serverAddrCacheKey struct {
+	network string
+	host    string
+}
+
+// serverAddrCacheEntry is an entry of the [*serverAddrCache].
+type serverAddrCacheEntry struct {
+	// addrs contains the resolved addresses.
+	addrs []netip.Addr
+
+	// expires is when the entry expires.
+	expires time.Time
+
+	// refreshing indicates an asynchronous refresh is in progress.
+	refreshing bool
+}
+
+// dialNetContext dials the given address using a [*net.Dialer]. When
+// ServerAddrCacheTTL is positive and the address contains a hostname,
+// we dial the cached addresses of the hostname, one after the other,
+// rather than having the dialer resolve the hostname for each query,
+// and we asynchronously refresh the addresses if all the attempts fail.
+func (t *Transport) dialNetContext(ctx context.Context, network, address string) (net.Conn, error) {
+	// 1. check whether we should use the cache
+	dialer := &net.Dialer{}
+	host, port, err := net.SplitHostPort(address)
+	if t.ServerAddrCacheTTL <= 0 || err != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	// 2. obtain the addresses of the hostname
+	key := serverAddrCacheKey{network: serverAddrLookupNetwork(network), host: host}
+	addrs, err := t.serverAddrs.get(ctx, key, t.ServerAddrCacheTTL, t.timeNow)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. try the addresses in order, stopping at the first success
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// 4. the addresses may be stale, so refresh them for the next queries
+	if ctx.Err() == nil {
+		t.serverAddrs.refresh(key, t.ServerAddrCacheTTL, t.timeNow)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// serverAddrLookupNetwork maps the dial network to the lookup network.
+func serverAddrLookupNetwork(network string) string {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return "ip4"
+	case strings.HasSuffix(network, "6"):
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// get returns the cached addresses or resolves them when they are
+// missing or expired, caching them for the given TTL.
+func (c *serverAddrCache) get(ctx context.Context, key serverAddrCacheKey,
+	ttl time.Duration, timeNow func() time.Time) ([]netip.Addr, error) {
+	// 1. use the cached entry if it's still valid
+	c.mu.Lock()
+	entry := c.entries[key]
+	if entry != nil && timeNow().Before(entry.expires) {
+		addrs := entry.addrs
+		c.mu.Unlock()
+		return addrs, nil
+	}
+	c.mu.Unlock()
+
+	// 2. otherwise, resolve the hostname and update the cache
+	return c.resolve(ctx, key, ttl, timeNow)
+}
+
+// refresh asynchronously resolves the addresses of the given key, unless
+// another refresh is already in progress, and updates the cache.
+func (c *serverAddrCache) refresh(key serverAddrCacheKey, ttl time.Duration, timeNow func() time.Time) {
+	c.mu.Lock()
+	entry := c.entries[key]
+	if entry == nil || entry.refreshing {
+		c.mu.Unlock()
+		return
+	}
+	entry.refreshing = true
+	c.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), serverAddrRefreshTimeout)
+		defer cancel()
+		if _, err := c.resolve(ctx, key, ttl, timeNow); err != nil {
+			c.mu.Lock()
+			entry.refreshing = false
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// resolve resolves the addresses of the given key and caches them.
+func (c *serverAddrCache) resolve(ctx context.Context, key serverAddrCacheKey,
+	ttl time.Duration, timeNow func() time.Time) ([]netip.Addr, error) {
+	// 1. perform the lookup
+	lookup := c.lookup
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupNetIP
+	}
+	addrs, err := lookup(ctx, key.network, key.host)
+	if err != nil {
+		return nil, err
+	}
+	for idx, addr := range addrs {
+		addrs[idx] = addr.Unmap()
+	}
+
+	// 2. replace the cached entry
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[serverAddrCacheKey]*serverAddrCacheEntry)
+	}
+	c.entries[key] = &serverAddrCacheEntry{addrs: addrs, expires: timeNow().Add(ttl)}
+	return addrs, nil
+}
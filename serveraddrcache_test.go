@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_ServerAddrCacheTTL(t *testing.T) {
+	server, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+	_, port, err := net.SplitHostPort(server.Address)
+	assert.NoError(t, err)
+	addr := NewServerAddr(ProtocolTCP, net.JoinHostPort("dns.example.com", port))
+
+	// newTransport returns a transport whose lookups return the given
+	// addresses in sequence, repeating the last one, and the lookups counter.
+	newTransport := func(ttl time.Duration, values ...string) (*Transport, *atomic.Int64) {
+		lookups := &atomic.Int64{}
+		txp := &Transport{ServerAddrCacheTTL: ttl}
+		txp.serverAddrs.lookup = func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+			assert.Equal(t, "ip", network)
+			assert.Equal(t, "dns.example.com", host)
+			idx := min(int(lookups.Add(1))-1, len(values)-1)
+			if values[idx] == "" {
+				return nil, errors.New("mocked error")
+			}
+			return []netip.Addr{netip.MustParseAddr(values[idx])}, nil
+		}
+		return txp, lookups
+	}
+	msg, err := NewQuery("example.com", dns.TypeA)
+	assert.NoError(t, err)
+	query := func(txp *Transport) error {
+		_, err := txp.Query(context.Background(), addr, msg)
+		return err
+	}
+
+	t.Run("we cache the addresses", func(t *testing.T) {
+		txp, lookups := newTransport(time.Hour, "127.0.0.1")
+		assert.NoError(t, query(txp))
+		assert.NoError(t, query(txp))
+		assert.Equal(t, int64(1), lookups.Load())
+	})
+
+	t.Run("we resolve again after the TTL", func(t *testing.T) {
+		txp, lookups := newTransport(time.Minute, "127.0.0.1")
+		now := time.Now()
+		txp.TimeNow = func() time.Time { return now }
+		assert.NoError(t, query(txp))
+		now = now.Add(2 * time.Minute)
+		assert.NoError(t, query(txp))
+		assert.Equal(t, int64(2), lookups.Load())
+	})
+
+	t.Run("we refresh the addresses after a connection failure", func(t *testing.T) {
+		txp, lookups := newTransport(time.Hour, "127.0.0.2", "127.0.0.1")
+		assert.Error(t, query(txp))
+		assert.Eventually(t, func() bool { return lookups.Load() == 2 }, time.Second, time.Millisecond)
+		assert.Eventually(t, func() bool { return query(txp) == nil }, time.Second, time.Millisecond)
+		assert.Equal(t, int64(2), lookups.Load())
+	})
+
+	t.Run("the lookup fails", func(t *testing.T) {
+		txp, _ := newTransport(time.Hour, "")
+		assert.Error(t, query(txp))
+	})
+
+	t.Run("we do not resolve IP addresses", func(t *testing.T) {
+		txp, lookups := newTransport(time.Hour, "127.0.0.1")
+		_, err := txp.Query(context.Background(), server, msg)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), lookups.Load())
+	})
+}
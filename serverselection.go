@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ServerSelection is the policy the [*Resolver] uses
+// to choose the order in which servers are tried.
+type ServerSelection int
+
+const (
+	// ServerSelectionOrdered tries servers in the order
+	// in which they have been added. This is the default.
+	ServerSelectionOrdered = ServerSelection(iota)
+
+	// ServerSelectionFastest tries servers in increasing order of smoothed
+	// RTT, while occasionally trying another server first to refresh its RTT
+	// estimate. Servers without RTT samples are tried first.
+	ServerSelectionFastest
+)
+
+// DefaultExplorationRate is the default probability with which
+// [ServerSelectionFastest] tries a random server first.
+const DefaultExplorationRate = 0.05
+
+// ServerLatency contains the latency statistics of a server.
+type ServerLatency struct {
+	// Addr is the server address.
+	Addr *ServerAddr
+
+	// SmoothedRTT is the exponentially-weighted moving average of
+	// the RTT samples. A failed exchange counts as a sample equal
+	// to the time it took to fail, which is usually the timeout.
+	SmoothedRTT time.Duration
+
+	// FailureRate is the exponentially-weighted moving
+	// average of failures in the [0, 1] range.
+	FailureRate float64
+
+	// Samples is the number of samples collected so far.
+	Samples int
+}
+
+// SetServerSelection sets the server selection policy.
+func (c *ResolverConfig) SetServerSelection(policy ServerSelection) {
+	c.updateSettings(func(settings *resolverConfigSettings) {
+		settings.selection = policy
+	})
+}
+
+// SetExplorationRate sets the probability with which [ServerSelectionFastest]
+// tries a random server first. If not set, we use [DefaultExplorationRate].
+// Use a negative value to disable exploration.
+func (c *ResolverConfig) SetExplorationRate(rate float64) {
+	c.mu.Lock()
+	c.explorationRate = rate
+	c.mu.Unlock()
+}
+
+// ServerLatency returns a snapshot of the latency statistics of
+// the configured servers in the same order in which they have been
+// added. Servers without samples have a zero SmoothedRTT.
+func (c *ResolverConfig) ServerLatency() []ServerLatency {
+	servers := c.servers()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]ServerLatency, 0, len(servers))
+	for _, server := range servers {
+		if stats, ok := c.latency[server.address.key()]; ok {
+			out = append(out, *stats)
+			continue
+		}
+		out = append(out, ServerLatency{Addr: server.address})
+	}
+	return out
+}
+
+// recordExchange updates the latency statistics of a server using
+// the given RTT sample and whether the exchange failed.
+//
+// We use the same smoothing factors used by TCP (see RFC 6298).
+func (c *ResolverConfig) recordExchange(addr *ServerAddr, rtt time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latency == nil {
+		c.latency = make(map[serverKey]*ServerLatency)
+	}
+	stats, ok := c.latency[addr.key()]
+	if !ok {
+		stats = &ServerLatency{Addr: addr, SmoothedRTT: rtt}
+		c.latency[addr.key()] = stats
+	}
+	var failure float64
+	if failed {
+		failure = 1
+	}
+	if stats.Samples > 0 {
+		stats.SmoothedRTT = (7*stats.SmoothedRTT + rtt) / 8
+		stats.FailureRate = 0.875*stats.FailureRate + 0.125*failure
+	} else {
+		stats.FailureRate = failure
+	}
+	stats.Samples++
+}
+
+// orderServers returns the given servers sorted according
+// to the given [ServerSelection] policy.
+func (c *ResolverConfig) orderServers(selection ServerSelection,
+	servers []resolverConfigServer) []resolverConfigServer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if selection != ServerSelectionFastest || len(servers) < 2 {
+		return servers
+	}
+
+	// sort by increasing smoothed RTT with unknown servers first
+	srtt := func(server resolverConfigServer) time.Duration {
+		if stats, ok := c.latency[server.address.key()]; ok {
+			return stats.SmoothedRTT
+		}
+		return 0
+	}
+	ordered := slices.Clone(servers)
+	slices.SortStableFunc(ordered, func(a, b resolverConfigServer) int {
+		return cmp.Compare(srtt(a), srtt(b))
+	})
+
+	// occasionally promote another server to refresh its estimate
+	rate := c.explorationRate
+	if rate == 0 {
+		rate = DefaultExplorationRate
+	}
+	if rand.Float64() < rate {
+		idx := 1 + rand.IntN(len(ordered)-1)
+		explore := ordered[idx]
+		ordered = slices.Delete(ordered, idx, idx+1)
+		ordered = slices.Insert(ordered, 0, explore)
+	}
+	return ordered
+}
+
+// exchangeFailed returns whether the exchange should count as a
+// failure for the purpose of computing the server statistics.
+func exchangeFailed(query, resp *dns.Msg, err error) bool {
+	if err != nil || ValidateResponse(query, resp) != nil {
+		return true
+	}
+	return resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// orderedAddresses returns the addresses of the servers in the given order.
+func orderedAddresses(servers []resolverConfigServer) (out []string) {
+	for _, server := range servers {
+		out = append(out, server.address.Address)
+	}
+	return
+}
+
+func TestResolverConfig_recordExchange(t *testing.T) {
+	config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53")
+	addr := NewServerAddr(ProtocolUDP, "192.0.2.1:53")
+
+	config.recordExchange(addr, 80*time.Millisecond, false)
+	latency := config.ServerLatency()
+	assert.Len(t, latency, 3)
+	assert.Equal(t, 80*time.Millisecond, latency[0].SmoothedRTT)
+	assert.Equal(t, 0.0, latency[0].FailureRate)
+	assert.Equal(t, 1, latency[0].Samples)
+	assert.Equal(t, 0, latency[1].Samples)
+
+	config.recordExchange(addr, 160*time.Millisecond, true)
+	latency = config.ServerLatency()
+	assert.Equal(t, 90*time.Millisecond, latency[0].SmoothedRTT)
+	assert.Equal(t, 0.125, latency[0].FailureRate)
+	assert.Equal(t, 2, latency[0].Samples)
+}
+
+func TestResolverConfig_orderServers(t *testing.T) {
+	// newConfig creates a config with three UDP servers
+	// using the given policy and without exploration.
+	newConfig := func(policy ServerSelection) *ResolverConfig {
+		config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53")
+		config.SetServerSelection(policy)
+		config.SetExplorationRate(-1)
+		return config
+	}
+
+	t.Run("ordered policy keeps the configured order", func(t *testing.T) {
+		config := newConfig(ServerSelectionOrdered)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.1:53"), time.Second, false)
+		got := orderedAddresses(config.orderServers(config.snapshot().selection, config.servers()))
+		assert.Equal(t, []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}, got)
+	})
+
+	t.Run("fastest policy sorts by smoothed RTT", func(t *testing.T) {
+		config := newConfig(ServerSelectionFastest)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.1:53"), 300*time.Millisecond, false)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.2:53"), 100*time.Millisecond, false)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.3:53"), 200*time.Millisecond, false)
+		got := orderedAddresses(config.orderServers(config.snapshot().selection, config.servers()))
+		assert.Equal(t, []string{"192.0.2.2:53", "192.0.2.3:53", "192.0.2.1:53"}, got)
+	})
+
+	t.Run("fastest policy tries unknown servers first", func(t *testing.T) {
+		config := newConfig(ServerSelectionFastest)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.1:53"), 100*time.Millisecond, false)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.2:53"), 50*time.Millisecond, false)
+		got := orderedAddresses(config.orderServers(config.snapshot().selection, config.servers()))
+		assert.Equal(t, []string{"192.0.2.3:53", "192.0.2.2:53", "192.0.2.1:53"}, got)
+	})
+
+	t.Run("exploration promotes another server", func(t *testing.T) {
+		config := newConfig(ServerSelectionFastest)
+		config.SetExplorationRate(1)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.1:53"), 100*time.Millisecond, false)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.2:53"), 200*time.Millisecond, false)
+		config.recordExchange(NewServerAddr(ProtocolUDP, "192.0.2.3:53"), 300*time.Millisecond, false)
+		got := orderedAddresses(config.orderServers(config.snapshot().selection, config.servers()))
+		assert.Len(t, got, 3)
+		assert.NotEqual(t, "192.0.2.1:53", got[0])
+		assert.ElementsMatch(t, []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}, got)
+	})
+}
+
+func Test_exchangeFailed(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeA)
+	reply := func(rcode int) *dns.Msg {
+		resp := &dns.Msg{}
+		resp.SetRcode(query, rcode)
+		return resp
+	}
+
+	assert.True(t, exchangeFailed(query, nil, errors.New("mocked error")))
+	assert.True(t, exchangeFailed(query, &dns.Msg{}, nil))
+	assert.True(t, exchangeFailed(query, reply(dns.RcodeServerFailure), nil))
+	assert.True(t, exchangeFailed(query, reply(dns.RcodeRefused), nil))
+	assert.False(t, exchangeFailed(query, reply(dns.RcodeSuccess), nil))
+	assert.False(t, exchangeFailed(query, reply(dns.RcodeNameError), nil))
+}
+
+func TestResolver_lookupPrefersFastestServer(t *testing.T) {
+	config := newUDPResolverConfig("192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53")
+	config.SetServerSelection(ServerSelectionFastest)
+	config.SetExplorationRate(-1)
+	delays := map[string]time.Duration{
+		"192.0.2.1:53": 20 * time.Millisecond,
+		"192.0.2.2:53": time.Millisecond,
+		"192.0.2.3:53": 10 * time.Millisecond,
+	}
+	var last string
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reso := &Resolver{
+		Config:  config,
+		TimeNow: func() time.Time { return now },
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				last = addr.Address
+				now = now.Add(delays[addr.Address])
+				resp := &dns.Msg{}
+				resp.SetRcode(query, dns.RcodeNameError)
+				return resp, nil
+			},
+		},
+	}
+
+	// the first three lookups collect one sample per server
+	for idx := 0; idx < 3; idx++ {
+		_, _ = reso.LookupA(context.Background(), "example.com")
+	}
+	for _, stats := range config.ServerLatency() {
+		assert.Equal(t, 1, stats.Samples)
+		assert.Equal(t, delays[stats.Addr.Address], stats.SmoothedRTT)
+	}
+
+	// from now on, we should prefer the fastest server
+	_, _ = reso.LookupA(context.Background(), "example.com")
+	assert.Equal(t, "192.0.2.2:53", last)
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultSIG0Fudge is the default clock skew we tolerate when signing
+// messages using SIG(0), which also is the validity of the signatures.
+const DefaultSIG0Fudge = 5 * time.Minute
+
+// ErrInvalidSIG0 indicates that a response lacks a valid SIG(0) signature.
+var ErrInvalidSIG0 = errors.New("invalid SIG(0) signature")
+
+// SIG0Key is a private key signing messages using SIG(0) (RFC 2931), which
+// authenticates messages using public-key cryptography rather than the shared
+// secrets used by TSIG, e.g., to send dynamic updates. The server must know
+// the KEY record, which is usually published in the DNS.
+type SIG0Key struct {
+	// KEY is the KEY record containing the public key, whose
+	// owner name is the signer name of the signatures.
+	KEY *dns.KEY
+
+	// Signer is the private key.
+	Signer crypto.Signer
+
+	// Fudge is the optional clock skew we tolerate, i.e., the signatures
+	// are valid from Fudge before to Fudge after we sign the message.
+	// If zero, we use [DefaultSIG0Fudge].
+	Fudge time.Duration
+
+	// TimeNow is the optional function returning the current time.
+	// If nil, we use [time.Now].
+	TimeNow func() time.Time
+}
+
+// Sign signs the given message and returns the signed raw message, whose
+// last additional record is the SIG(0) signature. We do not modify msg.
+func (k *SIG0Key) Sign(msg *dns.Msg) ([]byte, error) {
+	// 1. determine the validity period
+	timeNow := time.Now
+	if k.TimeNow != nil {
+		timeNow = k.TimeNow
+	}
+	fudge := k.Fudge
+	if fudge <= 0 {
+		fudge = DefaultSIG0Fudge
+	}
+	now := timeNow()
+
+	// 2. sign the message
+	sig := &dns.SIG{RRSIG: dns.RRSIG{
+		Algorithm:  k.KEY.Algorithm,
+		Expiration: uint32(now.Add(fudge).Unix()),
+		Inception:  uint32(now.Add(-fudge).Unix()),
+		KeyTag:     k.KEY.KeyTag(),
+		SignerName: dns.CanonicalName(k.KEY.Hdr.Name),
+	}}
+	return sig.Sign(k.Signer, msg)
+}
+
+// VerifySIG0 verifies that the last additional record of the given response,
+// parsed from the given raw response, is a valid SIG(0) signature created
+// using the private key matching the given KEY record. The returned error
+// wraps [ErrInvalidSIG0] when the signature is missing or invalid.
+func VerifySIG0(key *dns.KEY, resp *dns.Msg, rawResp []byte) error {
+	if len(resp.Extra) <= 0 {
+		return fmt.Errorf("%w: missing signature", ErrInvalidSIG0)
+	}
+	sig, ok := resp.Extra[len(resp.Extra)-1].(*dns.SIG)
+	if !ok || sig.TypeCovered != 0 {
+		return fmt.Errorf("%w: missing signature", ErrInvalidSIG0)
+	}
+	if sig.KeyTag != key.KeyTag() || sig.Algorithm != key.Algorithm {
+		return fmt.Errorf("%w: signed using another key", ErrInvalidSIG0)
+	}
+	if err := sig.Verify(key, rawResp); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSIG0, err.Error())
+	}
+	return nil
+}
+
+// QuerySIG0 is like [*Transport.Query] but signs the query using the given
+// key and, when serverKey is not nil, verifies the signature of the response
+// using [VerifySIG0]. Because we send the signed query using
+// [*Transport.QueryRaw], the same protocol restrictions apply.
+func (t *Transport) QuerySIG0(ctx context.Context, addr *ServerAddr,
+	query *dns.Msg, key *SIG0Key, serverKey *dns.KEY) (*dns.Msg, error) {
+	// 1. sign the query
+	rawQuery, err := key.Sign(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. send the query and parse the response
+	rawResp, err := t.QueryRaw(ctx, addr, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ParseResponse(query, rawResp)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. verify the response signature
+	if serverKey != nil {
+		if err := VerifySIG0(serverKey, resp, rawResp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
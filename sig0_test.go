@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSIG0Key_Sign(t *testing.T) {
+	key := generateSIG0Key(t, "client.example.com.")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key.TimeNow = func() time.Time { return now }
+	query, _ := NewQuery("example.com", dns.TypeSOA)
+
+	rawQuery, err := key.Sign(query)
+	assert.NoError(t, err)
+	assert.Empty(t, query.Extra)
+
+	signed := &dns.Msg{}
+	assert.NoError(t, signed.Unpack(rawQuery))
+	if assert.NotEmpty(t, signed.Extra) {
+		sig := signed.Extra[len(signed.Extra)-1].(*dns.SIG)
+		assert.Equal(t, "client.example.com.", sig.SignerName)
+		assert.Equal(t, key.KEY.KeyTag(), sig.KeyTag)
+		assert.Equal(t, uint32(now.Add(-DefaultSIG0Fudge).Unix()), sig.Inception)
+		assert.Equal(t, uint32(now.Add(DefaultSIG0Fudge).Unix()), sig.Expiration)
+	}
+}
+
+func TestVerifySIG0(t *testing.T) {
+	key := generateSIG0Key(t, "server.example.com.")
+	other := generateSIG0Key(t, "server.example.com.")
+
+	// newSigned returns the given message signed using the given key.
+	newSigned := func(key *SIG0Key, msg *dns.Msg) (*dns.Msg, []byte) {
+		rawMsg, err := key.Sign(msg)
+		assert.NoError(t, err)
+		signed := &dns.Msg{}
+		assert.NoError(t, signed.Unpack(rawMsg))
+		return signed, rawMsg
+	}
+	msg, _ := NewQuery("example.com", dns.TypeA)
+
+	t.Run("valid signature", func(t *testing.T) {
+		signed, rawMsg := newSigned(key, msg)
+		assert.NoError(t, VerifySIG0(key.KEY, signed, rawMsg))
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		rawMsg, _ := msg.Pack()
+		assert.ErrorIs(t, VerifySIG0(key.KEY, msg, rawMsg), ErrInvalidSIG0)
+	})
+
+	t.Run("another key", func(t *testing.T) {
+		signed, rawMsg := newSigned(other, msg)
+		assert.ErrorIs(t, VerifySIG0(key.KEY, signed, rawMsg), ErrInvalidSIG0)
+	})
+
+	t.Run("modified message", func(t *testing.T) {
+		signed, rawMsg := newSigned(key, msg)
+		rawMsg[2] ^= 0x01 // toggle the RD flag
+		assert.ErrorIs(t, VerifySIG0(key.KEY, signed, rawMsg), ErrInvalidSIG0)
+	})
+
+	t.Run("expired signature", func(t *testing.T) {
+		expired := *key
+		expired.TimeNow = func() time.Time { return time.Now().Add(-time.Hour) }
+		signed, rawMsg := newSigned(&expired, msg)
+		assert.ErrorIs(t, VerifySIG0(key.KEY, signed, rawMsg), ErrInvalidSIG0)
+	})
+}
+
+func TestTransport_QuerySIG0(t *testing.T) {
+	clientKey := generateSIG0Key(t, "client.example.com.")
+	serverKey := generateSIG0Key(t, "server.example.com.")
+
+	// newTransport returns a transport whose mocked server verifies the queries
+	// signed using clientKey and signs the responses using serverKey.
+	newTransport := func(t *testing.T, serverKey *SIG0Key) *Transport {
+		var rawResp []byte
+		return &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &mocks.Conn{
+					MockSetDeadline: func(time.Time) error { return nil },
+					MockWrite: func(b []byte) (int, error) {
+						query := &dns.Msg{}
+						assert.NoError(t, query.Unpack(b))
+						assert.NoError(t, VerifySIG0(clientKey.KEY, query, b))
+						resp := new(dns.Msg)
+						resp.SetRcode(query, dns.RcodeSuccess)
+						resp.Extra = nil
+						var err error
+						rawResp, err = serverKey.Sign(resp)
+						assert.NoError(t, err)
+						return len(b), nil
+					},
+					MockRead: func(b []byte) (int, error) {
+						return copy(b, rawResp), nil
+					},
+					MockClose: func() error { return nil },
+				}, nil
+			},
+		}
+	}
+
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+
+	t.Run("verified response", func(t *testing.T) {
+		txp := newTransport(t, serverKey)
+		query, _ := NewQuery("example.com", dns.TypeSOA)
+		resp, err := txp.QuerySIG0(context.Background(), addr, query, clientKey, serverKey.KEY)
+		assert.NoError(t, err)
+		assert.Equal(t, query.Id, resp.Id)
+	})
+
+	t.Run("response signed using another key", func(t *testing.T) {
+		txp := newTransport(t, generateSIG0Key(t, "server.example.com."))
+		query, _ := NewQuery("example.com", dns.TypeSOA)
+		_, err := txp.QuerySIG0(context.Background(), addr, query, clientKey, serverKey.KEY)
+		assert.ErrorIs(t, err, ErrInvalidSIG0)
+	})
+
+	t.Run("without verifying the response", func(t *testing.T) {
+		txp := newTransport(t, generateSIG0Key(t, "server.example.com."))
+		query, _ := NewQuery("example.com", dns.TypeSOA)
+		_, err := txp.QuerySIG0(context.Background(), addr, query, clientKey, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("query failure", func(t *testing.T) {
+		txp := newTransport(t, serverKey)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		query, _ := NewQuery("example.com", dns.TypeSOA)
+		_, err := txp.QuerySIG0(ctx, addr, query, clientKey, serverKey.KEY)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -17,10 +17,13 @@ var addrToAddrPort = netipx.AddrToAddrPort
 
 // protocolMap maps the DNS protocol to the corresponding network protocol.
 var protocolMap = map[Protocol]string{
-	ProtocolDoH: "tcp",
-	ProtocolTCP: "tcp",
-	ProtocolDoT: "tcp",
-	ProtocolUDP: "udp",
+	ProtocolDoH:     "tcp",
+	ProtocolDoHJSON: "tcp",
+	ProtocolHTTP:    "tcp",
+	ProtocolH2C:     "tcp",
+	ProtocolTCP:     "tcp",
+	ProtocolDoT:     "tcp",
+	ProtocolUDP:     "udp",
 }
 
 // maybeLogQuery is a helper function that logs the query if the logger is set
@@ -28,8 +31,8 @@ var protocolMap = map[Protocol]string{
 func (t *Transport) maybeLogQuery(
 	ctx context.Context, addr *ServerAddr, rawQuery []byte) time.Time {
 	t0 := t.timeNow()
-	if t.Logger != nil {
-		t.Logger.InfoContext(
+	if logger := t.logger(ctx); logger != nil {
+		logger.InfoContext(
 			ctx,
 			"dnsQuery",
 			slog.Any("dnsRawQuery", rawQuery),
@@ -46,16 +49,16 @@ func (t *Transport) maybeLogQuery(
 func (t *Transport) maybeLogResponseAddrPort(ctx context.Context,
 	addr *ServerAddr, t0 time.Time, rawQuery, rawResp []byte,
 	laddr, raddr netip.AddrPort) {
-	if t.Logger != nil {
-		// Convert zero values to unspecified
-		if !laddr.IsValid() {
-			laddr = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
-		}
-		if !raddr.IsValid() {
-			raddr = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
-		}
+	// Convert zero values to unspecified
+	if !laddr.IsValid() {
+		laddr = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
+	if !raddr.IsValid() {
+		raddr = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
 
-		t.Logger.InfoContext(
+	if logger := t.logger(ctx); logger != nil {
+		logger.InfoContext(
 			ctx,
 			"dnsResponse",
 			slog.String("localAddr", laddr.String()),
@@ -71,11 +74,11 @@ func (t *Transport) maybeLogResponseAddrPort(ctx context.Context,
 	}
 }
 
-// maybeLogResponseConn is a helper function that logs the response if the logger is set.
+// maybeLogResponseConn is like maybeLogResponseAddrPort but uses the connection addresses.
 func (t *Transport) maybeLogResponseConn(ctx context.Context,
 	addr *ServerAddr, t0 time.Time, rawQuery, rawResp []byte,
 	conn net.Conn) {
-	if t.Logger != nil {
+	if t.logger(ctx) != nil {
 		t.maybeLogResponseAddrPort(
 			ctx,
 			addr,
@@ -87,3 +90,41 @@ func (t *Transport) maybeLogResponseConn(ctx context.Context,
 		)
 	}
 }
+
+// maybeLogQueryError is a helper function that logs a failed query if
+// the logger is set, regardless of the LogSampleRate sampling.
+func (t *Transport) maybeLogQueryError(ctx context.Context, addr *ServerAddr, err error) {
+	if t.Logger != nil {
+		t.Logger.InfoContext(
+			ctx,
+			"dnsQueryError",
+			slog.Any("err", err),
+			slog.String("serverAddr", addr.Address),
+			slog.String("serverProtocol", string(addr.Protocol)),
+			slog.Time("t", t.timeNow()),
+			slog.String("protocol", protocolMap[addr.Protocol]),
+		)
+	}
+}
+
+// logSampleKey is the context key for the log sampling decision.
+type logSampleKey struct{}
+
+// withLogSample returns a context recording whether to emit the logs of
+// the query being started, according to the LogSampleRate sampling.
+func (t *Transport) withLogSample(ctx context.Context) context.Context {
+	if t.Logger == nil || t.LogSampleRate <= 1 {
+		return ctx
+	}
+	sampled := (t.logSamples.Add(1)-1)%uint64(t.LogSampleRate) == 0
+	return context.WithValue(ctx, logSampleKey{}, sampled)
+}
+
+// logger returns the logger to use for the query, or nil when
+// the logger is not set or the sampling excluded the query.
+func (t *Transport) logger(ctx context.Context) *slog.Logger {
+	if sampled, ok := ctx.Value(logSampleKey{}).(bool); ok && !sampled {
+		return nil
+	}
+	return t.Logger
+}
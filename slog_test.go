@@ -5,13 +5,17 @@ package dnscore
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net"
 	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/rbmk-project/common/mocks"
 	"github.com/stretchr/testify/assert"
 )
@@ -252,3 +256,111 @@ func TestTransport_maybeLogResponseConn(t *testing.T) {
 		})
 	}
 }
+
+func TestTransport_maybeLogQueryError(t *testing.T) {
+	tests := []struct {
+		name      string
+		newLogger func(w io.Writer) *slog.Logger
+		expectLog string
+	}{
+		{
+			name: "Logger set",
+			newLogger: func(w io.Writer) *slog.Logger {
+				return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+					ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+						if attr.Key == slog.TimeKey {
+							return slog.Attr{}
+						}
+						return attr
+					},
+				}))
+			},
+			expectLog: "{\"level\":\"INFO\",\"msg\":\"dnsQueryError\",\"err\":\"mocked error\",\"serverAddr\":\"8.8.8.8:53\",\"serverProtocol\":\"udp\",\"t\":\"2020-01-01T00:00:00Z\",\"protocol\":\"udp\"}\n",
+		},
+
+		{
+			name:      "Logger not set",
+			newLogger: func(w io.Writer) *slog.Logger { return nil },
+			expectLog: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			transport := &Transport{
+				Logger: tt.newLogger(&out),
+				TimeNow: func() time.Time {
+					return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+				},
+			}
+
+			addr := &ServerAddr{Address: "8.8.8.8:53", Protocol: ProtocolUDP}
+			transport.maybeLogQueryError(context.Background(), addr, errors.New("mocked error"))
+
+			actualLog := out.String()
+			assert.Equal(t, tt.expectLog, actualLog)
+		})
+	}
+}
+
+func TestTransport_LogSampleRate(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	var out bytes.Buffer
+	txp := &Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if address == "8.8.4.4:53" {
+				return nil, expectedErr
+			}
+			var rawQuery []byte
+			return &mocks.Conn{
+				MockWrite: func(b []byte) (int, error) {
+					rawQuery = append([]byte{}, b...)
+					return len(b), nil
+				},
+				MockRead: func(b []byte) (int, error) {
+					return copy(b, newRawResponse(rawQuery, dns.RcodeSuccess)), nil
+				},
+				MockClose: func() error {
+					return nil
+				},
+				MockLocalAddr: func() net.Addr {
+					return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+				},
+				MockRemoteAddr: func() net.Addr {
+					return &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}
+				},
+			}, nil
+		},
+		Logger:        slog.New(slog.NewJSONHandler(&out, nil)),
+		LogSampleRate: 3,
+	}
+
+	// 1. send successful and failing queries
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	for _, address := range []string{
+		"8.8.8.8:53", "8.8.8.8:53", "8.8.4.4:53", "8.8.8.8:53", "8.8.8.8:53", "8.8.4.4:53",
+	} {
+		txp.Query(context.Background(), NewServerAddr(ProtocolUDP, address), query)
+	}
+
+	// 2. make sure we sampled the logs but always logged the errors
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event struct {
+			Msg        string `json:"msg"`
+			ServerAddr string `json:"serverAddr"`
+		}
+		assert.NoError(t, json.Unmarshal([]byte(line), &event))
+		events = append(events, event.Msg+" "+event.ServerAddr)
+	}
+	assert.Equal(t, []string{
+		"dnsQuery 8.8.8.8:53",
+		"dnsResponse 8.8.8.8:53",
+		"dnsQueryError 8.8.4.4:53",
+		"dnsQuery 8.8.8.8:53",
+		"dnsResponse 8.8.8.8:53",
+		"dnsQueryError 8.8.4.4:53",
+	}, events)
+}
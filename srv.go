@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"cmp"
+	"context"
+	"math/rand/v2"
+	"net"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// LookupSRV resolves the SRV records of the given service, returning
+// the canonical name and the records in the order in which the caller
+// should try them, as documented by [SortSRV]. Like [*net.Resolver.LookupSRV],
+// we query for "_service._proto.name", or just for name when both service
+// and proto are empty.
+//
+// Note that a single record whose target is "." means that the service is
+// not available at the name (RFC 2782), which we leave to the caller.
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	// 1. obtain the RRs
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	rrs, err := r.lookup(ctx, target, dns.TypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 2. decode the records, skipping the CNAMEs
+	cname := dns.Fqdn(target)
+	var srvs []*net.SRV
+	for _, rr := range rrs {
+		if srv, ok := rr.(*dns.SRV); ok {
+			cname = srv.Hdr.Name
+			srvs = append(srvs, &net.SRV{
+				Target:   srv.Target,
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			})
+		}
+	}
+	if len(srvs) <= 0 {
+		return "", nil, ErrNoData
+	}
+
+	// 3. order the records
+	SortSRV(srvs)
+	return cname, srvs, nil
+}
+
+// SortSRV sorts the given SRV records in the order in which the caller
+// should try them according to RFC 2782: by ascending priority and,
+// within the same priority, randomly such that the probability of a
+// record coming first is proportional to its weight. Records with zero
+// weight come after the records with a nonzero weight.
+func SortSRV(srvs []*net.SRV) {
+	// 1. group the records by priority
+	slices.SortStableFunc(srvs, func(a, b *net.SRV) int {
+		return cmp.Compare(a.Priority, b.Priority)
+	})
+
+	// 2. shuffle each group by weight
+	for start := 0; start < len(srvs); {
+		end := start + 1
+		for end < len(srvs) && srvs[end].Priority == srvs[start].Priority {
+			end++
+		}
+		srvShuffleByWeight(srvs[start:end])
+		start = end
+	}
+}
+
+// srvShuffleByWeight implements the weighted selection of RFC 2782
+// for records having the same priority.
+func srvShuffleByWeight(srvs []*net.SRV) {
+	var sum int
+	for _, srv := range srvs {
+		sum += int(srv.Weight)
+	}
+	for sum > 0 && len(srvs) > 1 {
+		var running int
+		threshold := rand.IntN(sum)
+		for idx, srv := range srvs {
+			running += int(srv.Weight)
+			if running > threshold {
+				srvs[0], srvs[idx] = srvs[idx], srvs[0]
+				break
+			}
+		}
+		sum -= int(srvs[0].Weight)
+		srvs = srvs[1:]
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortSRV(t *testing.T) {
+	t.Run("priority groups", func(t *testing.T) {
+		srvs := []*net.SRV{
+			{Target: "c.", Priority: 20, Weight: 10},
+			{Target: "a.", Priority: 10, Weight: 10},
+			{Target: "d.", Priority: 30},
+			{Target: "b.", Priority: 10, Weight: 10},
+		}
+		SortSRV(srvs)
+		var priorities []uint16
+		for _, srv := range srvs {
+			priorities = append(priorities, srv.Priority)
+		}
+		assert.Equal(t, []uint16{10, 10, 20, 30}, priorities)
+		assert.Equal(t, "c.", srvs[2].Target)
+		assert.Equal(t, "d.", srvs[3].Target)
+	})
+
+	t.Run("zero weight comes last", func(t *testing.T) {
+		for range 100 {
+			srvs := []*net.SRV{
+				{Target: "zero.", Priority: 10},
+				{Target: "weighted.", Priority: 10, Weight: 1},
+			}
+			SortSRV(srvs)
+			assert.Equal(t, "weighted.", srvs[0].Target)
+		}
+	})
+
+	t.Run("weighted distribution", func(t *testing.T) {
+		const rounds = 10000
+		var heavy int
+		for range rounds {
+			srvs := []*net.SRV{
+				{Target: "light.", Priority: 10, Weight: 10},
+				{Target: "heavy.", Priority: 10, Weight: 90},
+			}
+			SortSRV(srvs)
+			if srvs[0].Target == "heavy." {
+				heavy++
+			}
+		}
+		// with 10000 rounds, the expected value is 9000 and the standard
+		// deviation is 30, so this check is practically never flaky
+		assert.InDelta(t, 9000, heavy, 300)
+	})
+}
+
+func TestResolver_LookupSRV(t *testing.T) {
+	reso := &Resolver{
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				q0 := query.Question[0]
+				assert.Equal(t, dns.TypeSRV, q0.Qtype)
+				resp := new(dns.Msg)
+				resp.SetReply(query)
+				resp.RecursionAvailable = true
+				if q0.Name == "_sip._udp.example.com." {
+					for _, s := range []string{
+						"_sip._udp.example.com. 300 IN CNAME _sip._udp.example.net.",
+						"_sip._udp.example.net. 300 IN SRV 20 0 5060 backup.example.net.",
+						"_sip._udp.example.net. 300 IN SRV 10 0 5060 sip.example.net.",
+					} {
+						rr, err := dns.NewRR(s)
+						assert.NoError(t, err)
+						resp.Answer = append(resp.Answer, rr)
+					}
+				}
+				return resp, nil
+			},
+		},
+	}
+
+	t.Run("records in priority order", func(t *testing.T) {
+		cname, srvs, err := reso.LookupSRV(context.Background(), "sip", "udp", "example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "_sip._udp.example.net.", cname)
+		assert.Equal(t, []*net.SRV{
+			{Target: "sip.example.net.", Port: 5060, Priority: 10},
+			{Target: "backup.example.net.", Port: 5060, Priority: 20},
+		}, srvs)
+	})
+
+	t.Run("name without service and proto", func(t *testing.T) {
+		_, srvs, err := reso.LookupSRV(context.Background(), "", "", "_sip._udp.example.com")
+		assert.NoError(t, err)
+		assert.Len(t, srvs, 2)
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		_, _, err := reso.LookupSRV(context.Background(), "xmpp", "tcp", "example.com")
+		assert.ErrorIs(t, err, ErrNoData)
+	})
+}
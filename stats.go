@@ -0,0 +1,191 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"sync"
+)
+
+// ServerStats contains the [*Transport] statistics for a server.
+type ServerStats struct {
+	// Protocol is the server protocol.
+	Protocol Protocol
+
+	// Address is the server address.
+	Address string
+
+	// Queries is the number of queries sent to the server.
+	Queries int64
+
+	// Responses is the number of responses received from the server,
+	// including duplicate responses received by [*Transport.QueryWithDuplicates].
+	Responses int64
+
+	// Rcodes maps each RCODE to the number of responses containing it.
+	Rcodes map[int]int64
+
+	// Discarded is the number of UDP datagrams we discarded because we
+	// could not parse them or they did not match the query (e.g., packets
+	// injected by middleboxes or late responses to previous queries).
+	Discarded int64
+
+	// Timeouts is the number of queries that failed with a timeout.
+	Timeouts int64
+
+	// Errors is the number of queries that failed with other errors.
+	Errors int64
+
+	// BytesSent is the number of DNS message bytes sent to the server,
+	// including the framing used by stream transports.
+	BytesSent int64
+
+	// BytesReceived is the number of DNS message bytes received from
+	// the server, excluding the framing used by stream transports.
+	BytesReceived int64
+
+	// Handshakes is the number of successful TLS handshakes.
+	Handshakes int64
+
+	// NewConns is the number of new connections.
+	NewConns int64
+
+	// ReusedConns is the number of times we reused a connection.
+	ReusedConns int64
+}
+
+// ConnReuseRatio returns the ratio of queries that reused a connection
+// over all the queries that used a connection. It returns zero when no
+// connection has been used yet.
+func (s *ServerStats) ConnReuseRatio() float64 {
+	total := s.NewConns + s.ReusedConns
+	if total <= 0 {
+		return 0
+	}
+	return float64(s.ReusedConns) / float64(total)
+}
+
+// transportStats collects per-server [*Transport] statistics.
+//
+// The zero value is ready to use.
+type transportStats struct {
+	// mu protects servers.
+	mu sync.Mutex
+
+	// servers maps each server to its stats.
+	servers map[serverKey]*ServerStats
+}
+
+// update calls fn with the stats of the given server while holding the mutex.
+func (ts *transportStats) update(addr *ServerAddr, fn func(stats *ServerStats)) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.servers == nil {
+		ts.servers = make(map[serverKey]*ServerStats)
+	}
+	stats, ok := ts.servers[addr.key()]
+	if !ok {
+		stats = &ServerStats{
+			Protocol: addr.Protocol,
+			Address:  addr.Address,
+			Rcodes:   make(map[int]int64),
+		}
+		ts.servers[addr.key()] = stats
+	}
+	fn(stats)
+}
+
+// onQuery records that we're sending a query.
+func (ts *transportStats) onQuery(addr *ServerAddr) {
+	ts.update(addr, func(stats *ServerStats) { stats.Queries++ })
+}
+
+// onConn records that we're using a new or reused connection.
+func (ts *transportStats) onConn(addr *ServerAddr, reused bool) {
+	ts.update(addr, func(stats *ServerStats) {
+		if reused {
+			stats.ReusedConns++
+			return
+		}
+		stats.NewConns++
+	})
+}
+
+// onHandshake records a successful TLS handshake.
+func (ts *transportStats) onHandshake(addr *ServerAddr) {
+	ts.update(addr, func(stats *ServerStats) { stats.Handshakes++ })
+}
+
+// onSent records the number of bytes sent.
+func (ts *transportStats) onSent(addr *ServerAddr, count int) {
+	ts.update(addr, func(stats *ServerStats) { stats.BytesSent += int64(count) })
+}
+
+// onResponse records a response along with its size.
+func (ts *transportStats) onResponse(addr *ServerAddr, count int, rcode int) {
+	ts.update(addr, func(stats *ServerStats) {
+		stats.BytesReceived += int64(count)
+		stats.Responses++
+		stats.Rcodes[rcode]++
+	})
+}
+
+// onRawResponse is like onResponse but reads the rcode from the
+// header of the raw response, if the response is long enough.
+func (ts *transportStats) onRawResponse(addr *ServerAddr, rawResp []byte) {
+	ts.update(addr, func(stats *ServerStats) {
+		stats.BytesReceived += int64(len(rawResp))
+		stats.Responses++
+		if len(rawResp) >= 4 {
+			stats.Rcodes[int(rawResp[3]&0x0f)]++
+		}
+	})
+}
+
+// onDiscarded records a discarded UDP datagram.
+func (ts *transportStats) onDiscarded(addr *ServerAddr) {
+	ts.update(addr, func(stats *ServerStats) { stats.Discarded++ })
+}
+
+// onError records a failed query.
+func (ts *transportStats) onError(addr *ServerAddr, err error) {
+	ts.update(addr, func(stats *ServerStats) {
+		if isTimeout(err) {
+			stats.Timeouts++
+			return
+		}
+		stats.Errors++
+	})
+}
+
+// isTimeout returns whether the given error is a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Stats returns a snapshot of the per-server statistics collected
+// by the [*Transport], sorted by protocol and address.
+func (t *Transport) Stats() []ServerStats {
+	t.stats.mu.Lock()
+	defer t.stats.mu.Unlock()
+	out := make([]ServerStats, 0, len(t.stats.servers))
+	for _, stats := range t.stats.servers {
+		snapshot := *stats
+		snapshot.Rcodes = maps.Clone(stats.Rcodes)
+		out = append(out, snapshot)
+	}
+	slices.SortFunc(out, func(a, b ServerStats) int {
+		return cmp.Or(cmp.Compare(a.Protocol, b.Protocol), cmp.Compare(a.Address, b.Address))
+	})
+	return out
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerStats_ConnReuseRatio(t *testing.T) {
+	assert.Equal(t, 0.0, (&ServerStats{}).ConnReuseRatio())
+	assert.Equal(t, 0.75, (&ServerStats{NewConns: 1, ReusedConns: 3}).ConnReuseRatio())
+}
+
+func Test_isTimeout(t *testing.T) {
+	assert.True(t, isTimeout(context.DeadlineExceeded))
+	assert.True(t, isTimeout(os.ErrDeadlineExceeded))
+	assert.True(t, isTimeout(&net.DNSError{IsTimeout: true}))
+	assert.False(t, isTimeout(context.Canceled))
+	assert.False(t, isTimeout(errors.New("mocked error")))
+}
+
+func TestTransport_Stats(t *testing.T) {
+	t.Run("UDP", func(t *testing.T) {
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var rawQuery []byte
+				return &mocks.Conn{
+					MockWrite: func(b []byte) (int, error) {
+						rawQuery = append([]byte{}, b...)
+						return len(b), nil
+					},
+					MockRead: func(b []byte) (int, error) {
+						return copy(b, newRawResponse(rawQuery, dns.RcodeNameError)), nil
+					},
+					MockClose:      func() error { return nil },
+					MockLocalAddr:  func() net.Addr { return &net.UDPAddr{} },
+					MockRemoteAddr: func() net.Addr { return &net.UDPAddr{} },
+				}, nil
+			},
+		}
+		addr := NewServerAddr(ProtocolUDP, "192.0.2.1:53")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		rawQuery, err := query.Pack()
+		assert.NoError(t, err)
+
+		for idx := 0; idx < 2; idx++ {
+			_, err := txp.Query(context.Background(), addr, query)
+			assert.NoError(t, err)
+		}
+
+		stats := txp.Stats()
+		assert.Len(t, stats, 1)
+		assert.Equal(t, ProtocolUDP, stats[0].Protocol)
+		assert.Equal(t, "192.0.2.1:53", stats[0].Address)
+		assert.Equal(t, int64(2), stats[0].Queries)
+		assert.Equal(t, int64(2), stats[0].Responses)
+		assert.Equal(t, map[int]int64{dns.RcodeNameError: 2}, stats[0].Rcodes)
+		assert.Equal(t, int64(2*len(rawQuery)), stats[0].BytesSent)
+		assert.Equal(t, int64(2*len(rawQuery)), stats[0].BytesReceived)
+		assert.Equal(t, int64(2), stats[0].NewConns)
+		assert.Equal(t, int64(0), stats[0].Handshakes)
+
+		// make sure the snapshot is not aliased to the internal state
+		stats[0].Rcodes[dns.RcodeSuccess] = 1
+		assert.NotContains(t, txp.Stats()[0].Rcodes, dns.RcodeSuccess)
+	})
+
+	t.Run("DoT", func(t *testing.T) {
+		txp := &Transport{
+			DialTLSContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &mocks.Conn{
+					MockWrite:      func(b []byte) (int, error) { return len(b), nil },
+					MockRead:       bytes.NewReader(newValidRawRespFrame()).Read,
+					MockClose:      func() error { return nil },
+					MockLocalAddr:  func() net.Addr { return &net.TCPAddr{} },
+					MockRemoteAddr: func() net.Addr { return &net.TCPAddr{} },
+				}, nil
+			},
+		}
+		addr := NewServerAddr(ProtocolDoT, "192.0.2.1:853")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		rawQuery, err := query.Pack()
+		assert.NoError(t, err)
+		_, err = txp.Query(context.Background(), addr, query)
+		assert.NoError(t, err)
+
+		stats := txp.Stats()
+		assert.Len(t, stats, 1)
+		assert.Equal(t, int64(1), stats[0].Handshakes)
+		assert.Equal(t, int64(1), stats[0].NewConns)
+		assert.Equal(t, int64(len(rawQuery)+2), stats[0].BytesSent)
+		assert.Equal(t, int64(len(newValidRawRespFrame())-2), stats[0].BytesReceived)
+	})
+
+	t.Run("errors and timeouts", func(t *testing.T) {
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				if network == "udp" {
+					return nil, os.ErrDeadlineExceeded
+				}
+				return nil, errors.New("mocked error")
+			},
+		}
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		_, _ = txp.Query(context.Background(), NewServerAddr(ProtocolUDP, "192.0.2.1:53"), query)
+		_, _ = txp.Query(context.Background(), NewServerAddr(ProtocolTCP, "192.0.2.1:53"), query)
+
+		stats := txp.Stats()
+		assert.Len(t, stats, 2)
+		assert.Equal(t, ProtocolTCP, stats[0].Protocol)
+		assert.Equal(t, int64(1), stats[0].Errors)
+		assert.Equal(t, int64(0), stats[0].Timeouts)
+		assert.Equal(t, ProtocolUDP, stats[1].Protocol)
+		assert.Equal(t, int64(0), stats[1].Errors)
+		assert.Equal(t, int64(1), stats[1].Timeouts)
+	})
+
+	t.Run("DoH connection reuse and handshakes", func(t *testing.T) {
+		var reused bool
+		txp := &Transport{
+			HTTPClient: &http.Client{
+				Transport: &mocks.HTTPTransport{
+					MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+						rawQuery, err := io.ReadAll(req.Body)
+						if err != nil {
+							return nil, err
+						}
+						trace := httptrace.ContextClientTrace(req.Context())
+						if !reused {
+							trace.TLSHandshakeDone(tls.ConnectionState{}, nil)
+						}
+						trace.GotConn(httptrace.GotConnInfo{Conn: &mocks.Conn{
+							MockLocalAddr:  func() net.Addr { return &net.TCPAddr{} },
+							MockRemoteAddr: func() net.Addr { return &net.TCPAddr{} },
+						}, Reused: reused})
+						reused = true
+						resp := &http.Response{
+							StatusCode: 200,
+							Header:     make(http.Header),
+							Body:       io.NopCloser(bytes.NewReader(newRawResponse(rawQuery, dns.RcodeSuccess))),
+						}
+						resp.Header.Set("content-type", "application/dns-message")
+						return resp, nil
+					},
+				},
+			},
+		}
+		addr := NewServerAddr(ProtocolDoH, "https://dns.google/dns-query")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		for idx := 0; idx < 4; idx++ {
+			_, err := txp.Query(context.Background(), addr, query)
+			assert.NoError(t, err)
+		}
+
+		stats := txp.Stats()
+		assert.Len(t, stats, 1)
+		assert.Equal(t, int64(4), stats[0].Queries)
+		assert.Equal(t, int64(1), stats[0].Handshakes)
+		assert.Equal(t, int64(1), stats[0].NewConns)
+		assert.Equal(t, int64(3), stats[0].ReusedConns)
+		assert.Equal(t, 0.75, stats[0].ConnReuseRatio())
+		assert.Equal(t, map[int]int64{dns.RcodeSuccess: 4}, stats[0].Rcodes)
+	})
+
+	t.Run("DoH bytes sent when the round trip fails", func(t *testing.T) {
+		txp := &Transport{
+			HTTPClient: &http.Client{
+				Transport: &mocks.HTTPTransport{
+					MockRoundTrip: func(req *http.Request) (*http.Response, error) {
+						return nil, errors.New("mocked error")
+					},
+				},
+			},
+		}
+		addr := NewServerAddr(ProtocolDoH, "https://dns.google/dns-query")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		rawQuery, err := query.Pack()
+		assert.NoError(t, err)
+		_, err = txp.Query(context.Background(), addr, query)
+		assert.Error(t, err)
+
+		stats := txp.Stats()
+		assert.Len(t, stats, 1)
+		assert.Equal(t, int64(1), stats[0].Errors)
+		assert.Equal(t, int64(len(rawQuery)), stats[0].BytesSent)
+	})
+}
@@ -0,0 +1,352 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultStreamIdleTimeout is the time for which we keep idle TCP and
+// TLS connections open when [*Transport] ReuseStreamConns is true and
+// the server does not advertise an RFC 7828 idle timeout.
+const DefaultStreamIdleTimeout = 10 * time.Second
+
+// streamProbeTimeout is the time we wait for an idle connection to
+// become readable when probing it. Since the server should not send
+// anything on an idle connection, we expect to always hit the timeout.
+const streamProbeTimeout = time.Millisecond
+
+// streamConn is a TCP or TLS connection we may reuse.
+type streamConn struct {
+	// conn is the underlying connection.
+	conn net.Conn
+
+	// br is the reader wrapping conn, which we must preserve
+	// across queries since it may contain buffered bytes.
+	br *bufio.Reader
+
+	// expires is when the idle connection expires.
+	expires time.Time
+
+	// keepalive indicates that we enabled TCP keepalives.
+	keepalive bool
+
+	// retires is when the connection becomes too old to reuse, or
+	// the zero value if the connection does not have a maximum age.
+	retires time.Time
+}
+
+// expiryReason returns the reason for closing the connection once expired.
+func (sc *streamConn) expiryReason() ConnCloseReason {
+	if !sc.retires.IsZero() && !sc.expires.Before(sc.retires) {
+		return ConnCloseMaxAge
+	}
+	return ConnCloseIdleTimeout
+}
+
+// streamPool contains the idle TCP and TLS connections.
+//
+// The zero value is ready to use.
+type streamPool struct {
+	// idle maps each server and priority to its idle connections.
+	idle map[streamKey][]*streamConn
+
+	// probing indicates that the probing goroutine is running.
+	probing bool
+
+	// stop is closed to stop the probing goroutine.
+	stop chan struct{}
+
+	// mu protects idle, probing, and stop.
+	mu sync.Mutex
+}
+
+// get returns the most recently used idle connection to the given
+// server that has not expired yet, closing the expired ones, or nil.
+func (p *streamPool) get(key streamKey, now time.Time) *streamConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conns := p.idle[key]; len(conns) > 0; conns = p.idle[key] {
+		sc := conns[len(conns)-1]
+		p.idle[key] = conns[:len(conns)-1]
+		if now.Before(sc.expires) {
+			return sc
+		}
+		closeConn(sc.conn, sc.expiryReason(), nil)
+	}
+	return nil
+}
+
+// put adds an idle connection to the given server.
+func (p *streamPool) put(key streamKey, sc *streamConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idle == nil {
+		p.idle = make(map[streamKey][]*streamConn)
+	}
+	p.idle[key] = append(p.idle[key], sc)
+}
+
+// closeIdle closes all the idle connections and stops probing them.
+func (p *streamPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conns := range p.idle {
+		for _, sc := range conns {
+			closeConn(sc.conn, ConnCloseTransport, nil)
+		}
+	}
+	p.idle = nil
+	if p.probing {
+		close(p.stop)
+		p.probing = false
+	}
+}
+
+// startProbing starts the goroutine probing the idle connections
+// every interval, unless it's already running.
+func (p *streamPool) startProbing(interval time.Duration, timeNow func() time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.probing || interval <= 0 {
+		return
+	}
+	p.probing, p.stop = true, make(chan struct{})
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.probeIdle(timeNow())
+			}
+		}
+	}(p.stop)
+}
+
+// probeIdle closes the idle connections that have expired or that fail
+// probing. To avoid blocking queries, we probe without holding the mutex,
+// hence queries cannot use the connections while we're probing them.
+func (p *streamPool) probeIdle(now time.Time) {
+	// 1. take the idle connections
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	// 2. probe them and keep the alive ones
+	alive := make(map[streamKey][]*streamConn)
+	for key, conns := range idle {
+		for _, sc := range conns {
+			if !now.Before(sc.expires) {
+				closeConn(sc.conn, sc.expiryReason(), nil)
+				continue
+			}
+			if !probeStreamConn(sc) {
+				closeConn(sc.conn, ConnCloseProbeFailure, nil)
+				continue
+			}
+			alive[key] = append(alive[key], sc)
+		}
+	}
+
+	// 3. return them before the connections added meanwhile, which
+	// are more recently used, unless we have been closed meanwhile
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conns := range alive {
+		if !p.probing {
+			for _, sc := range conns {
+				closeConn(sc.conn, ConnCloseTransport, nil)
+			}
+			continue
+		}
+		if p.idle == nil {
+			p.idle = make(map[streamKey][]*streamConn)
+		}
+		p.idle[key] = append(conns, p.idle[key]...)
+	}
+}
+
+// probeStreamConn returns whether the given idle connection is alive, which
+// is the case when reading times out, since the server should not send any
+// data on an idle connection. Reading fails when the server has closed the
+// connection or, with TCP keepalives enabled, when the path is broken.
+func probeStreamConn(sc *streamConn) bool {
+	if sc.br == nil {
+		sc.br = bufio.NewReader(sc.conn)
+	}
+	_ = sc.conn.SetReadDeadline(time.Now().Add(streamProbeTimeout))
+	_, err := sc.br.Peek(1)
+	_ = sc.conn.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// maybeEnableTCPKeepalive enables TCP keepalives with the given period on
+// the TCP connection underlying conn, if any, when the period is positive.
+func maybeEnableTCPKeepalive(conn net.Conn, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     period,
+			Interval: period,
+			Count:    3,
+		})
+	}
+}
+
+// newTCPKeepaliveQuery returns a copy of the query including the RFC 7828
+// edns-tcp-keepalive option, or the query itself when it does not use EDNS(0)
+// or already includes the option. Since the option changes the query size,
+// we recompute the block-length padding, if present.
+func newTCPKeepaliveQuery(query *dns.Msg) *dns.Msg {
+	opt := query.IsEdns0()
+	if opt == nil {
+		return query
+	}
+	for _, option := range opt.Option {
+		if _, ok := option.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+			return query
+		}
+	}
+	query = query.Copy()
+	opt = query.IsEdns0()
+	var padded bool
+	opt.Option = slices.DeleteFunc(opt.Option, func(option dns.EDNS0) bool {
+		_, ok := option.(*dns.EDNS0_PADDING)
+		padded = padded || ok
+		return ok
+	})
+	opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+	if padded {
+		addBlockLengthPadding(query)
+	}
+	return query
+}
+
+// streamIdleTimeout returns the idle timeout advertised by the server using
+// the RFC 7828 edns-tcp-keepalive option or [DefaultStreamIdleTimeout]. A zero
+// return value means that the server asked us to close the connection.
+func streamIdleTimeout(resp *dns.Msg) time.Duration {
+	if opt := resp.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if keepalive, ok := option.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+				return time.Duration(keepalive.Timeout) * 100 * time.Millisecond
+			}
+		}
+	}
+	return DefaultStreamIdleTimeout
+}
+
+// queryStreamReusingConns implements [*Transport.Query] for DNS over TCP
+// and TLS when ReuseStreamConns or PipelineStreamQueries is true. We first try with an idle connection,
+// if any, and retry with a new connection created using dial on failure, since
+// the server may have closed the idle connection in the meanwhile, unless
+// retrying could cause duplicate side effects according to [canRetryQuery].
+func (t *Transport) queryStreamReusingConns(ctx context.Context, addr *ServerAddr,
+	query *dns.Msg, dial func(ctx context.Context) (net.Conn, error)) (*dns.Msg, error) {
+	// 1. request the server to keep the connection open
+	query = newTCPKeepaliveQuery(query)
+	if t.PipelineStreamQueries {
+		return t.queryStreamPipelined(ctx, addr, query, dial)
+	}
+
+	// 2. try with an idle connection
+	if sc := t.streams.get(streamKeyFor(ctx, addr), t.timeNow()); sc != nil {
+		t.reuseConn(addr, sc.conn)
+		resp, err := t.exchangeStreamConn(ctx, addr, query, sc)
+		if err == nil || ctx.Err() != nil || !canRetryQuery(query, err) {
+			return resp, err
+		}
+	}
+
+	// 3. fallback to a new connection
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sc := &streamConn{conn: conn, br: bufio.NewReader(conn), retires: t.connRetireTime()}
+	return t.exchangeStreamConn(ctx, addr, query, sc)
+}
+
+// connRetireTime returns when a connection created now becomes too old
+// to reuse according to MaxConnectionAge, or the zero value.
+func (t *Transport) connRetireTime() time.Time {
+	if t.MaxConnectionAge <= 0 {
+		return time.Time{}
+	}
+	return t.timeNow().Add(t.MaxConnectionAge)
+}
+
+// exchangeStreamConn performs the round trip over the given connection and
+// either returns it to the pool, on success, or closes it, on failure.
+func (t *Transport) exchangeStreamConn(ctx context.Context,
+	addr *ServerAddr, query *dns.Msg, sc *streamConn) (*dns.Msg, error) {
+	// 1. Use the context deadline to limit the query lifetime and make
+	// sure we interrupt the I/O when the context is done. A zero deadline
+	// clears the deadline set when the connection was previously used.
+	deadline, _ := ctx.Deadline()
+	_ = sc.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		_ = sc.conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	// 2. Perform the exchange and close the connection on failure
+	// or when the context was done during the exchange, since the
+	// latter case may cause the connection deadline to be in the past.
+	resp, err := t.exchangeStream(ctx, addr, query, sc.conn, sc.br)
+	if !stop() || err != nil {
+		closeConn(sc.conn, ConnCloseError, cmp.Or(err, ctx.Err()))
+		return resp, err
+	}
+
+	// 3. Reuse the connection unless the server asked us to close it,
+	// the response is for another query, which means that the stream
+	// is not in the state we expect, or the connection is too old.
+	now := t.timeNow()
+	timeout := streamIdleTimeout(resp)
+	switch {
+	case timeout <= 0:
+		closeConn(sc.conn, ConnCloseServerRequest, nil)
+		return resp, nil
+	case resp.Id != query.Id:
+		closeConn(sc.conn, ConnCloseError, nil)
+		return resp, nil
+	case !sc.retires.IsZero() && !now.Before(sc.retires):
+		closeConn(sc.conn, ConnCloseMaxAge, nil)
+		return resp, nil
+	}
+	sc.expires = now.Add(timeout)
+	if !sc.retires.IsZero() && sc.retires.Before(sc.expires) {
+		sc.expires = sc.retires
+	}
+	if !sc.keepalive {
+		maybeEnableTCPKeepalive(sc.conn, t.StreamProbeInterval)
+		sc.keepalive = true
+	}
+	t.streams.put(streamKeyFor(ctx, addr), sc)
+	t.streams.startProbing(t.StreamProbeInterval, t.timeNow)
+	return resp, nil
+}
@@ -0,0 +1,286 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_ReuseStreamConns(t *testing.T) {
+	newQuery := func() *dns.Msg {
+		query, _ := NewQuery("example.com", dns.TypeA, QueryOptionEDNS0(4096, 0))
+		return query
+	}
+	queryN := func(txp *Transport, addr *ServerAddr, count int) {
+		for idx := 0; idx < count; idx++ {
+			query := newQuery()
+			resp, err := txp.Query(context.Background(), addr, query)
+			assert.NoError(t, err)
+			assert.NoError(t, ValidateResponse(query, resp))
+		}
+	}
+
+	t.Run("reuses connections for the advertised timeout", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerKeepalive(50)))
+		now := time.Now()
+		txp := &Transport{ReuseStreamConns: true, TimeNow: func() time.Time { return now }}
+		defer txp.Close()
+
+		queryN(txp, addr, 3)
+		assert.Equal(t, int64(1), conns.Load())
+		stats := txp.Stats()[0]
+		assert.Equal(t, int64(1), stats.NewConns)
+		assert.Equal(t, int64(2), stats.ReusedConns)
+
+		now = now.Add(5 * time.Second)
+		queryN(txp, addr, 1)
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("uses the default idle timeout", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		now := time.Now()
+		txp := &Transport{ReuseStreamConns: true, TimeNow: func() time.Time { return now }}
+		defer txp.Close()
+
+		queryN(txp, addr, 2)
+		assert.Equal(t, int64(1), conns.Load())
+		now = now.Add(DefaultStreamIdleTimeout)
+		queryN(txp, addr, 1)
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("stops reusing connections older than MaxConnectionAge", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		now := time.Now()
+		txp := &Transport{ReuseStreamConns: true, MaxConnectionAge: 8 * time.Second,
+			TimeNow: func() time.Time { return now }}
+		defer txp.Close()
+		for _, expected := range []int64{1, 1, 2} {
+			queryN(txp, addr, 1)
+			assert.Equal(t, expected, conns.Load())
+			now = now.Add(5 * time.Second)
+		}
+	})
+
+	t.Run("closes connections when the server asks to", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerKeepalive(0)))
+		txp := &Transport{ReuseStreamConns: true}
+		defer txp.Close()
+		queryN(txp, addr, 2)
+		assert.Equal(t, int64(2), conns.Load())
+	})
+
+	t.Run("retries when the idle connection is broken", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{ReuseStreamConns: true}
+		defer txp.Close()
+		var closed bool
+		broken := &mocks.Conn{
+			MockSetDeadline: func(time.Time) error { return nil },
+			MockWrite:       func(b []byte) (int, error) { return 0, errors.New("broken pipe") },
+			MockClose: func() error {
+				closed = true
+				return nil
+			},
+		}
+		txp.streams.put(streamKey{server: addr.key()}, &streamConn{conn: broken, expires: time.Now().Add(time.Hour)})
+		queryN(txp, addr, 1)
+		assert.True(t, closed)
+		assert.Equal(t, int64(1), conns.Load())
+	})
+
+	t.Run("does not retry updates when the idle connection is broken", func(t *testing.T) {
+		addr, conns := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{ReuseStreamConns: true}
+		defer txp.Close()
+		broken := &mocks.Conn{
+			MockSetDeadline: func(time.Time) error { return nil },
+			MockWrite:       func(b []byte) (int, error) { return 0, errors.New("broken pipe") },
+			MockClose:       func() error { return nil },
+		}
+		txp.streams.put(streamKey{server: addr.key()}, &streamConn{conn: broken, expires: time.Now().Add(time.Hour)})
+		update := &dns.Msg{}
+		update.SetUpdate("example.com.")
+		_, err := txp.Query(context.Background(), addr, update)
+		assert.Error(t, err)
+		assert.Equal(t, int64(0), conns.Load())
+	})
+
+	t.Run("does not retry when the context is done", func(t *testing.T) {
+		txp := &Transport{ReuseStreamConns: true}
+		addr := NewServerAddr(ProtocolTCP, "127.0.0.1:1")
+		ctx, cancel := context.WithCancel(context.Background())
+		broken := &mocks.Conn{
+			MockSetDeadline: func(time.Time) error { return nil },
+			MockWrite: func(b []byte) (int, error) {
+				cancel()
+				return 0, errors.New("broken pipe")
+			},
+			MockClose: func() error { return nil },
+		}
+		txp.streams.put(streamKey{server: addr.key()}, &streamConn{conn: broken, expires: time.Now().Add(time.Hour)})
+		_, err := txp.Query(ctx, addr, newQuery())
+		assert.Error(t, err)
+		assert.Empty(t, txp.streams.idle[streamKey{server: addr.key()}])
+	})
+
+	t.Run("Close closes idle connections", func(t *testing.T) {
+		addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+		txp := &Transport{ReuseStreamConns: true}
+		queryN(txp, addr, 1)
+		assert.Len(t, txp.streams.idle[streamKey{server: addr.key()}], 1)
+		assert.NoError(t, txp.Close())
+		assert.Nil(t, txp.streams.idle)
+	})
+
+	t.Run("dial failure", func(t *testing.T) {
+		expectedErr := errors.New("mocked error")
+		txp := &Transport{
+			ReuseStreamConns: true,
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, expectedErr
+			},
+			DialTLSContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, expectedErr
+			},
+		}
+		for _, protocol := range []Protocol{ProtocolTCP, ProtocolDoT} {
+			_, err := txp.Query(context.Background(), NewServerAddr(protocol, "127.0.0.1:1"), newQuery())
+			assert.ErrorIs(t, err, expectedErr)
+		}
+	})
+}
+
+func Test_newTCPKeepaliveQuery(t *testing.T) {
+	hasKeepalive := func(query *dns.Msg) bool {
+		for _, option := range query.IsEdns0().Option {
+			if _, ok := option.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("without EDNS(0)", func(t *testing.T) {
+		query, _ := NewQuery("example.com", dns.TypeA)
+		assert.Same(t, query, newTCPKeepaliveQuery(query))
+	})
+
+	t.Run("with EDNS(0) and padding", func(t *testing.T) {
+		query, _ := NewQuery("example.com", dns.TypeA, QueryOptionEDNS0(4096, EDNS0FlagBlockLengthPadding))
+		out := newTCPKeepaliveQuery(query)
+		assert.NotSame(t, query, out)
+		assert.False(t, hasKeepalive(query))
+		assert.True(t, hasKeepalive(out))
+		rawQuery, err := out.Pack()
+		assert.NoError(t, err)
+		assert.Zero(t, len(rawQuery)%128)
+		assert.Same(t, out, newTCPKeepaliveQuery(out))
+	})
+}
+
+func Test_streamIdleTimeout(t *testing.T) {
+	resp := &dns.Msg{}
+	assert.Equal(t, DefaultStreamIdleTimeout, streamIdleTimeout(resp))
+	resp.SetEdns0(4096, false)
+	assert.Equal(t, DefaultStreamIdleTimeout, streamIdleTimeout(resp))
+	resp.IsEdns0().Option = append(resp.IsEdns0().Option,
+		&dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: 1200})
+	assert.Equal(t, 2*time.Minute, streamIdleTimeout(resp))
+}
+
+func Test_probeStreamConn(t *testing.T) {
+	// newConnPair returns both ends of a TCP connection.
+	newConnPair := func(t *testing.T) (net.Conn, net.Conn) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer listener.Close()
+		client, err := net.Dial("tcp", listener.Addr().String())
+		assert.NoError(t, err)
+		server, err := listener.Accept()
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		return client, server
+	}
+
+	tests := []struct {
+		name     string
+		server   func(conn net.Conn)
+		expected bool
+	}{
+		{"alive", func(conn net.Conn) {}, true},
+		{"closed by the server", func(conn net.Conn) { conn.Close() }, false},
+		{"unexpected data", func(conn net.Conn) { conn.Write([]byte{0}) }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := newConnPair(t)
+			tt.server(server)
+			time.Sleep(10 * time.Millisecond) // let the client see the server actions
+			sc := &streamConn{conn: client}
+			assert.Equal(t, tt.expected, probeStreamConn(sc))
+			assert.Equal(t, tt.expected, probeStreamConn(sc))
+		})
+	}
+}
+
+func TestTransport_StreamProbeInterval(t *testing.T) {
+	// a server answering a single query per connection and then closing it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rawQuery, err := ReadMsgFrame(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				query := &dns.Msg{}
+				if query.Unpack(rawQuery) != nil {
+					return
+				}
+				resp := &dns.Msg{}
+				resp.SetReply(query)
+				rawResp, _ := resp.Pack()
+				frame, _ := newRawMsgFrame(&ServerAddr{}, rawResp)
+				conn.Write(frame)
+			}()
+		}
+	}()
+
+	txp := &Transport{ReuseStreamConns: true, StreamProbeInterval: 10 * time.Millisecond}
+	addr := NewServerAddr(ProtocolTCP, listener.Addr().String())
+	query, _ := NewQuery("example.com", dns.TypeA, QueryOptionEDNS0(4096, 0))
+	_, err = txp.Query(context.Background(), addr, query)
+	assert.NoError(t, err)
+
+	// the probing goroutine should evict the connection closed by the server
+	assert.Eventually(t, func() bool {
+		txp.streams.mu.Lock()
+		defer txp.streams.mu.Unlock()
+		return len(txp.streams.idle[streamKey{server: addr.key()}]) == 0
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, txp.Close())
+	assert.False(t, txp.streams.probing)
+}
@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// SVCBRecord is a parsed SVCB or HTTPS record (RFC 9460) returned
+// by [*Resolver.LookupSVCB] and [*Resolver.LookupHTTPS].
+type SVCBRecord struct {
+	// Name is the owner name of the record.
+	Name string
+
+	// Priority is the SvcPriority, which is zero for AliasMode
+	// records and otherwise orders the ServiceMode records, where
+	// lower values are preferred.
+	Priority uint16
+
+	// Target is the TargetName, where "." means the owner name
+	// for ServiceMode records and no service for AliasMode ones.
+	Target string
+
+	// Params maps the SvcParamKeys to their SvcParamValues, both in
+	// presentation format, e.g., "alpn" to "h3,h2" and "port" to "8443".
+	// The keys without a value, e.g., "no-default-alpn", map to "".
+	Params map[string]string
+
+	// values contains the parsed SvcParams.
+	values []dns.SVCBKeyValue
+}
+
+// NewSVCBRecord parses the given SVCB or HTTPS RR, returning false when
+// the RR has another type.
+func NewSVCBRecord(rr dns.RR) (*SVCBRecord, bool) {
+	var svcb *dns.SVCB
+	switch rr := rr.(type) {
+	case *dns.SVCB:
+		svcb = rr
+	case *dns.HTTPS:
+		svcb = &rr.SVCB
+	default:
+		return nil, false
+	}
+	record := &SVCBRecord{
+		Name:     svcb.Hdr.Name,
+		Priority: svcb.Priority,
+		Target:   svcb.Target,
+		Params:   make(map[string]string),
+		values:   svcb.Value,
+	}
+	for _, kv := range svcb.Value {
+		record.Params[kv.Key().String()] = kv.String()
+	}
+	return record, true
+}
+
+// IsAlias returns whether this is an AliasMode record.
+func (r *SVCBRecord) IsAlias() bool {
+	return r.Priority == 0
+}
+
+// ALPN returns the ALPN protocol identifiers of the alpn key.
+func (r *SVCBRecord) ALPN() []string {
+	for _, kv := range r.values {
+		if alpn, ok := kv.(*dns.SVCBAlpn); ok {
+			return alpn.Alpn
+		}
+	}
+	return nil
+}
+
+// Port returns the port of the port key, or false if the key is missing.
+func (r *SVCBRecord) Port() (uint16, bool) {
+	for _, kv := range r.values {
+		if port, ok := kv.(*dns.SVCBPort); ok {
+			return port.Port, true
+		}
+	}
+	return 0, false
+}
+
+// IPHints returns the addresses of the ipv4hint and ipv6hint keys.
+func (r *SVCBRecord) IPHints() []netip.Addr {
+	var addrs []netip.Addr
+	for _, kv := range r.values {
+		var hints []net.IP
+		switch kv := kv.(type) {
+		case *dns.SVCBIPv4Hint:
+			hints = kv.Hint
+		case *dns.SVCBIPv6Hint:
+			hints = kv.Hint
+		}
+		for _, hint := range hints {
+			if addr, ok := netip.AddrFromSlice(hint); ok {
+				addrs = append(addrs, addr.Unmap())
+			}
+		}
+	}
+	return addrs
+}
+
+// ECHConfig returns the ECHConfigList of the ech key, if any.
+func (r *SVCBRecord) ECHConfig() []byte {
+	for _, kv := range r.values {
+		if ech, ok := kv.(*dns.SVCBECHConfig); ok {
+			return ech.ECH
+		}
+	}
+	return nil
+}
+
+// LookupSVCB resolves the SVCB records of the given name, such as
+// "_dns.resolver.arpa", returning them in priority order.
+func (r *Resolver) LookupSVCB(ctx context.Context, name string) ([]*SVCBRecord, error) {
+	return r.lookupSVCB(ctx, name, dns.TypeSVCB)
+}
+
+// LookupHTTPS resolves the HTTPS records of the given name, returning
+// them in priority order. Use [*Resolver.LookupHTTPSEndpoints] instead
+// to obtain the endpoints to dial following the AliasMode records.
+func (r *Resolver) LookupHTTPS(ctx context.Context, name string) ([]*SVCBRecord, error) {
+	return r.lookupSVCB(ctx, name, dns.TypeHTTPS)
+}
+
+// lookupSVCB implements [*Resolver.LookupSVCB] and [*Resolver.LookupHTTPS].
+func (r *Resolver) lookupSVCB(ctx context.Context, name string, qtype uint16) ([]*SVCBRecord, error) {
+	// 1. obtain the RRs
+	rrs, err := r.lookup(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. parse the records, skipping the CNAMEs
+	var records []*SVCBRecord
+	for _, rr := range rrs {
+		if record, ok := NewSVCBRecord(rr); ok {
+			records = append(records, record)
+		}
+	}
+	if len(records) <= 0 {
+		return nil, ErrNoData
+	}
+
+	// 3. sort by priority
+	slices.SortStableFunc(records, func(a, b *SVCBRecord) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+	return records, nil
+}
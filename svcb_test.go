@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSVCBRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		rr     string
+		ok     bool
+		params map[string]string
+		alpn   []string
+		port   uint16
+		hints  []netip.Addr
+		alias  bool
+	}{{
+		name:   "HTTPS service record",
+		rr:     `example.com. 300 IN HTTPS 1 . alpn="h3,h2" no-default-alpn port=8443 ipv4hint=192.0.2.1 ipv6hint=2001:db8::1`,
+		ok:     true,
+		params: map[string]string{"alpn": "h3,h2", "no-default-alpn": "", "port": "8443", "ipv4hint": "192.0.2.1", "ipv6hint": "2001:db8::1"},
+		alpn:   []string{"h3", "h2"},
+		port:   8443,
+		hints:  []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")},
+	}, {
+		name:   "SVCB alias record",
+		rr:     `_dns.example.com. 300 IN SVCB 0 dns.example.net.`,
+		ok:     true,
+		params: map[string]string{},
+		alias:  true,
+	}, {
+		name:   "unknown keys",
+		rr:     `example.com. 300 IN SVCB 1 svc.example.net. key667="hello"`,
+		ok:     true,
+		params: map[string]string{"key667": "hello"},
+	}, {
+		name: "other types",
+		rr:   `example.com. 300 IN A 192.0.2.1`,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, err := dns.NewRR(tt.rr)
+			assert.NoError(t, err)
+			record, ok := NewSVCBRecord(rr)
+			assert.Equal(t, tt.ok, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, tt.params, record.Params)
+			assert.Equal(t, tt.alpn, record.ALPN())
+			port, found := record.Port()
+			assert.Equal(t, tt.port, port)
+			assert.Equal(t, tt.port != 0, found)
+			assert.Equal(t, tt.hints, record.IPHints())
+			assert.Equal(t, tt.alias, record.IsAlias())
+			assert.Nil(t, record.ECHConfig())
+		})
+	}
+}
+
+func TestResolver_LookupHTTPS(t *testing.T) {
+	reso := &Resolver{Transport: answerFromRecords(t,
+		`example.com. 300 IN HTTPS 2 backup.example.net. alpn="h2"`,
+		`example.com. 300 IN HTTPS 1 . alpn="h3" ech="AAA="`,
+		`_dns.example.com. 300 IN SVCB 1 dns.example.net. alpn="dot"`,
+	)}
+
+	t.Run("HTTPS records in priority order", func(t *testing.T) {
+		records, err := reso.LookupHTTPS(context.Background(), "example.com")
+		assert.NoError(t, err)
+		var targets []string
+		for _, record := range records {
+			targets = append(targets, record.Target)
+		}
+		assert.Equal(t, []string{".", "backup.example.net."}, targets)
+		assert.Equal(t, []byte{0, 0}, records[0].ECHConfig())
+		assert.Equal(t, "AAA=", records[0].Params["ech"])
+	})
+
+	t.Run("SVCB records", func(t *testing.T) {
+		records, err := reso.LookupSVCB(context.Background(), "_dns.example.com")
+		assert.NoError(t, err)
+		assert.Len(t, records, 1)
+		assert.Equal(t, []string{"dot"}, records[0].ALPN())
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		_, err := reso.LookupSVCB(context.Background(), "example.com")
+		assert.ErrorIs(t, err, ErrNoData)
+	})
+
+	t.Run("no name", func(t *testing.T) {
+		_, err := reso.LookupHTTPS(context.Background(), "nonexistent.example.com")
+		assert.ErrorIs(t, err, ErrNoName)
+	})
+}
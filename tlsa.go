@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TLSAUsage is the certificate usage field of a TLSA record (RFC 6698).
+type TLSAUsage uint8
+
+const (
+	// TLSAUsagePKIXTA constrains the CA that must appear in the PKIX chain.
+	TLSAUsagePKIXTA = TLSAUsage(0)
+
+	// TLSAUsagePKIXEE constrains the end entity certificate, which
+	// must also pass PKIX validation.
+	TLSAUsagePKIXEE = TLSAUsage(1)
+
+	// TLSAUsageDANETA specifies the trust anchor of the chain.
+	TLSAUsageDANETA = TLSAUsage(2)
+
+	// TLSAUsageDANEEE specifies the end entity certificate, without
+	// requiring PKIX validation.
+	TLSAUsageDANEEE = TLSAUsage(3)
+)
+
+// String returns the string representation of the usage.
+func (u TLSAUsage) String() string {
+	switch u {
+	case TLSAUsagePKIXTA:
+		return "pkix_ta"
+	case TLSAUsagePKIXEE:
+		return "pkix_ee"
+	case TLSAUsageDANETA:
+		return "dane_ta"
+	case TLSAUsageDANEEE:
+		return "dane_ee"
+	default:
+		return "unknown"
+	}
+}
+
+// TLSASelector is the selector field of a TLSA record (RFC 6698).
+type TLSASelector uint8
+
+const (
+	// TLSASelectorCert selects the full certificate.
+	TLSASelectorCert = TLSASelector(0)
+
+	// TLSASelectorSPKI selects the SubjectPublicKeyInfo.
+	TLSASelectorSPKI = TLSASelector(1)
+)
+
+// String returns the string representation of the selector.
+func (s TLSASelector) String() string {
+	switch s {
+	case TLSASelectorCert:
+		return "cert"
+	case TLSASelectorSPKI:
+		return "spki"
+	default:
+		return "unknown"
+	}
+}
+
+// TLSAMatchingType is the matching type field of a TLSA record (RFC 6698).
+type TLSAMatchingType uint8
+
+const (
+	// TLSAMatchingFull compares the selected content as is.
+	TLSAMatchingFull = TLSAMatchingType(0)
+
+	// TLSAMatchingSHA256 compares the SHA-256 hash of the selected content.
+	TLSAMatchingSHA256 = TLSAMatchingType(1)
+
+	// TLSAMatchingSHA512 compares the SHA-512 hash of the selected content.
+	TLSAMatchingSHA512 = TLSAMatchingType(2)
+)
+
+// String returns the string representation of the matching type.
+func (m TLSAMatchingType) String() string {
+	switch m {
+	case TLSAMatchingFull:
+		return "full"
+	case TLSAMatchingSHA256:
+		return "sha2_256"
+	case TLSAMatchingSHA512:
+		return "sha2_512"
+	default:
+		return "unknown"
+	}
+}
+
+// TLSARecord is a decoded TLSA record returned by [*Resolver.LookupTLSA].
+type TLSARecord struct {
+	// Usage is the certificate usage.
+	Usage TLSAUsage
+
+	// Selector selects which part of the certificate to match.
+	Selector TLSASelector
+
+	// MatchingType is how we match the selected content.
+	MatchingType TLSAMatchingType
+
+	// Data is the certificate association data.
+	Data []byte
+}
+
+// NewTLSARecord decodes the given TLSA RR.
+func NewTLSARecord(rr *dns.TLSA) (*TLSARecord, error) {
+	data, err := hex.DecodeString(rr.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLSA certificate association data: %w", err)
+	}
+	record := &TLSARecord{
+		Usage:        TLSAUsage(rr.Usage),
+		Selector:     TLSASelector(rr.Selector),
+		MatchingType: TLSAMatchingType(rr.MatchingType),
+		Data:         data,
+	}
+	return record, nil
+}
+
+// Matches returns whether the given certificate matches the record
+// according to its selector and matching type. Note that this function
+// does not take the usage into account, so the caller should choose the
+// certificate of the chain to match according to the usage.
+func (r *TLSARecord) Matches(cert *x509.Certificate) bool {
+	// 1. select the content
+	var content []byte
+	switch r.Selector {
+	case TLSASelectorCert:
+		content = cert.Raw
+	case TLSASelectorSPKI:
+		content = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	// 2. match the content
+	switch r.MatchingType {
+	case TLSAMatchingFull:
+		return bytes.Equal(content, r.Data)
+	case TLSAMatchingSHA256:
+		digest := sha256.Sum256(content)
+		return bytes.Equal(digest[:], r.Data)
+	case TLSAMatchingSHA512:
+		digest := sha512.Sum512(content)
+		return bytes.Equal(digest[:], r.Data)
+	default:
+		return false
+	}
+}
+
+// TLSAName returns the name owning the TLSA records of the given service
+// (RFC 6698 Sect. 3), such as "_443._tcp.example.com." for the "443" or
+// "https" service over "tcp" at "example.com". The service is either a
+// port number or a service name, which we map to the port number.
+func TLSAName(service, proto, host string) (string, error) {
+	// 1. map the service to the port number
+	port, err := strconv.ParseUint(service, 10, 16)
+	if err != nil {
+		portnum, err := net.LookupPort(proto, service)
+		if err != nil {
+			return "", err
+		}
+		port = uint64(portnum)
+	}
+
+	// 2. build the name
+	proto = strings.TrimPrefix(strings.ToLower(proto), "_")
+	return "_" + strconv.FormatUint(port, 10) + "._" + proto + "." + dns.Fqdn(host), nil
+}
+
+// LookupTLSA resolves the TLSA records of the given service, formatted
+// according to [TLSAName], returning the decoded records.
+//
+// Note that DANE requires DNSSEC-validated records, so you should only
+// use these records when you trust the resolver to validate them.
+func (r *Resolver) LookupTLSA(ctx context.Context, service, proto, host string) ([]*TLSARecord, error) {
+	// 1. obtain the RRs
+	name, err := TLSAName(service, proto, host)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := r.lookup(ctx, name, dns.TypeTLSA)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. decode the records, skipping the CNAMEs
+	var records []*TLSARecord
+	for _, rr := range rrs {
+		tlsa, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+		record, err := NewTLSARecord(tlsa)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if len(records) <= 0 {
+		return nil, ErrNoData
+	}
+	return records, nil
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/rbmk-project/common/selfsignedcert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSAEnums_String(t *testing.T) {
+	tests := []struct {
+		value    interface{ String() string }
+		expected string
+	}{
+		{TLSAUsagePKIXTA, "pkix_ta"},
+		{TLSAUsagePKIXEE, "pkix_ee"},
+		{TLSAUsageDANETA, "dane_ta"},
+		{TLSAUsageDANEEE, "dane_ee"},
+		{TLSAUsage(4), "unknown"},
+		{TLSASelectorCert, "cert"},
+		{TLSASelectorSPKI, "spki"},
+		{TLSASelector(2), "unknown"},
+		{TLSAMatchingFull, "full"},
+		{TLSAMatchingSHA256, "sha2_256"},
+		{TLSAMatchingSHA512, "sha2_512"},
+		{TLSAMatchingType(3), "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.value.String())
+		})
+	}
+}
+
+func TestTLSAName(t *testing.T) {
+	tests := []struct {
+		service  string
+		proto    string
+		host     string
+		expected string
+		wantErr  bool
+	}{
+		{"443", "tcp", "example.com", "_443._tcp.example.com.", false},
+		{"https", "tcp", "example.com.", "_443._tcp.example.com.", false},
+		{"853", "_UDP", "dns.example", "_853._udp.dns.example.", false},
+		{"nonexistent-service", "tcp", "example.com", "", true},
+		{"70000", "tcp", "example.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service+"/"+tt.proto, func(t *testing.T) {
+			name, err := TLSAName(tt.service, tt.proto, tt.host)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.expected, name)
+		})
+	}
+}
+
+func TestTLSARecord_Matches(t *testing.T) {
+	selfsigned := selfsignedcert.New(selfsignedcert.NewConfigExampleCom())
+	cert := runtimex.Try1(tls.X509KeyPair(selfsigned.CertPEM, selfsigned.KeyPEM)).Leaf
+	selfsigned = selfsignedcert.New(selfsignedcert.NewConfigExampleCom())
+	other := runtimex.Try1(tls.X509KeyPair(selfsigned.CertPEM, selfsigned.KeyPEM)).Leaf
+	certSHA256 := sha256.Sum256(cert.Raw)
+	spkiSHA512 := sha512.Sum512(cert.RawSubjectPublicKeyInfo)
+
+	tests := []struct {
+		name     string
+		record   *TLSARecord
+		expected bool
+	}{{
+		name:     "full certificate",
+		record:   &TLSARecord{Selector: TLSASelectorCert, MatchingType: TLSAMatchingFull, Data: cert.Raw},
+		expected: true,
+	}, {
+		name:     "SHA-256 of the certificate",
+		record:   &TLSARecord{Selector: TLSASelectorCert, MatchingType: TLSAMatchingSHA256, Data: certSHA256[:]},
+		expected: true,
+	}, {
+		name:     "SHA-512 of the SPKI",
+		record:   &TLSARecord{Selector: TLSASelectorSPKI, MatchingType: TLSAMatchingSHA512, Data: spkiSHA512[:]},
+		expected: true,
+	}, {
+		name:     "another certificate",
+		record:   &TLSARecord{Selector: TLSASelectorSPKI, MatchingType: TLSAMatchingFull, Data: other.RawSubjectPublicKeyInfo},
+		expected: false,
+	}, {
+		name:     "unknown selector",
+		record:   &TLSARecord{Selector: TLSASelector(7), MatchingType: TLSAMatchingFull, Data: cert.Raw},
+		expected: false,
+	}, {
+		name:     "unknown matching type",
+		record:   &TLSARecord{Selector: TLSASelectorCert, MatchingType: TLSAMatchingType(7), Data: cert.Raw},
+		expected: false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.record.Matches(cert))
+		})
+	}
+}
+
+func TestResolver_LookupTLSA(t *testing.T) {
+	selfsigned := selfsignedcert.New(selfsignedcert.NewConfigExampleCom())
+	cert := runtimex.Try1(tls.X509KeyPair(selfsigned.CertPEM, selfsigned.KeyPEM)).Leaf
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	var queried []string
+	reso := &Resolver{
+		Transport: &MockResolverTransport{
+			MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+				q0 := query.Question[0]
+				assert.Equal(t, dns.TypeTLSA, q0.Qtype)
+				queried = append(queried, q0.Name)
+				resp := new(dns.Msg)
+				resp.SetReply(query)
+				resp.RecursionAvailable = true
+				switch q0.Name {
+				case "_443._tcp.example.com.":
+					rr, err := dns.NewRR("_443._tcp.example.com. 300 IN TLSA 3 1 1 " + hex.EncodeToString(digest[:]))
+					assert.NoError(t, err)
+					resp.Answer = append(resp.Answer, rr)
+				case "_25._tcp.example.com.":
+					rr, err := dns.NewRR("_25._tcp.example.com. 300 IN TLSA 3 1 1 00")
+					assert.NoError(t, err)
+					rr.(*dns.TLSA).Certificate = "zz" // invalid hex
+					resp.Answer = append(resp.Answer, rr)
+				}
+				return resp, nil
+			},
+		},
+	}
+
+	t.Run("valid records", func(t *testing.T) {
+		records, err := reso.LookupTLSA(context.Background(), "https", "tcp", "example.com")
+		assert.NoError(t, err)
+		assert.Len(t, records, 1)
+		assert.Equal(t, TLSAUsageDANEEE, records[0].Usage)
+		assert.Equal(t, TLSASelectorSPKI, records[0].Selector)
+		assert.Equal(t, TLSAMatchingSHA256, records[0].MatchingType)
+		assert.True(t, records[0].Matches(cert))
+	})
+
+	t.Run("invalid records", func(t *testing.T) {
+		_, err := reso.LookupTLSA(context.Background(), "25", "tcp", "example.com")
+		assert.Error(t, err)
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		_, err := reso.LookupTLSA(context.Background(), "853", "tcp", "example.com")
+		assert.ErrorIs(t, err, ErrNoData)
+	})
+
+	t.Run("invalid service", func(t *testing.T) {
+		queried = nil
+		_, err := reso.LookupTLSA(context.Background(), "nonexistent-service", "tcp", "example.com")
+		assert.Error(t, err)
+		assert.Empty(t, queried)
+	})
+}
@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TraceStep describes a query sent to an authoritative name
+// server during the iterative resolution of a [*Trace].
+type TraceStep struct {
+	// Depth is the nesting depth, which is zero for the name we are
+	// tracing and increases when we follow aliases or resolve the
+	// addresses of name servers lacking glue.
+	Depth int
+
+	// Zone is the zone whose name servers we queried.
+	Zone string
+
+	// Question is the possibly minimized question we sent.
+	Question dns.Question
+
+	// Server is the address of the name server.
+	Server netip.AddrPort
+
+	// Protocol is the protocol we used.
+	Protocol Protocol
+
+	// RTT is the time elapsed until we received the response or failed.
+	RTT time.Duration
+
+	// Response is the response or nil on failure.
+	Response *dns.Msg
+
+	// Referral is the child zone when the response is a referral.
+	Referral string
+
+	// Nameservers contains the name servers of the Referral zone.
+	Nameservers []string
+
+	// Lame indicates that the name server is lame for the zone.
+	Lame bool
+
+	// Signed indicates that the response contains RRSIG records.
+	//
+	// We do not validate signatures, so this field only tells whether
+	// the zone appears to be signed. Likewise, for a referral, SignedDelegation
+	// tells whether the response contains the DS RRset of the child zone.
+	Signed, SignedDelegation bool
+
+	// Err is the error that occurred, if any.
+	Err error
+}
+
+// Trace contains every step of the iterative resolution of
+// a name, like `dig +trace` does. Use [*IterativeResolver.Trace]
+// to create a new trace. Use [*Trace.String] to render it
+// as text and [json.Marshal] to render it as JSON.
+type Trace struct {
+	// Question is the question we traced.
+	Question dns.Question
+
+	// Steps contains the steps in the order in which they occurred.
+	Steps []*TraceStep
+
+	// Response is the final response or nil on failure.
+	Response *dns.Msg
+
+	// Err is the error that occurred, if any.
+	Err error
+
+	// Duration is the overall duration of the resolution.
+	Duration time.Duration
+
+	// mu protects Steps while the trace is in progress.
+	mu sync.Mutex
+}
+
+// add adds a step to the trace. A nil *Trace ignores all steps.
+func (tr *Trace) add(step *TraceStep) {
+	if tr != nil {
+		tr.mu.Lock()
+		tr.Steps = append(tr.Steps, step)
+		tr.mu.Unlock()
+	}
+}
+
+// traceKey is the context key for the [*Trace] being collected.
+type traceKey struct{}
+
+// traceFromContext returns the [*Trace] inside the context or nil.
+func traceFromContext(ctx context.Context) *Trace {
+	tr, _ := ctx.Value(traceKey{}).(*Trace)
+	return tr
+}
+
+// Trace is like [*IterativeResolver.Resolve] but also returns a [*Trace]
+// recording every query sent to authoritative name servers. When tracing,
+// we set the DNSSEC OK bit, such that the trace shows which zones are signed.
+//
+// The trace reflects the state of the caches, so it does not include the
+// queries we avoided thanks to the delegations learned by previous lookups.
+// Use a new [*IterativeResolver] to always start from the root servers.
+//
+// The returned trace is never nil and contains the error, if any.
+func (r *IterativeResolver) Trace(ctx context.Context, name string, qtype uint16) (*Trace, error) {
+	tr := &Trace{}
+	query, err := NewQueryWithServerAddr(&ServerAddr{}, name, qtype)
+	if err != nil {
+		tr.Err = err
+		return tr, err
+	}
+	tr.Question = query.Question[0]
+	t0 := r.timeNow()
+	resp, err := r.resolve(context.WithValue(ctx, traceKey{}, tr), tr.Question, 0)
+	tr.Duration = r.timeNow().Sub(t0)
+	tr.Response, tr.Err = resp, err
+	return tr, err
+}
+
+// newTraceStep creates a [*TraceStep] for the given exchange.
+func newTraceStep(zone string, q0 dns.Question, depth int, server netip.AddrPort,
+	protocol Protocol, rtt time.Duration, resp *dns.Msg, err error) *TraceStep {
+	step := &TraceStep{
+		Depth:    depth,
+		Zone:     zone,
+		Question: q0,
+		Server:   server,
+		Protocol: protocol,
+		RTT:      rtt,
+		Response: resp,
+		Err:      err,
+	}
+	if resp == nil {
+		return step
+	}
+	if child, ok := iterativeReferral(zone, q0.Name, resp); ok {
+		step.Referral = child
+		for _, rr := range resp.Ns {
+			switch rr := rr.(type) {
+			case *dns.NS:
+				if dns.CanonicalName(rr.Hdr.Name) == child {
+					step.Nameservers = append(step.Nameservers, dns.CanonicalName(rr.Ns))
+				}
+			case *dns.DS:
+				step.SignedDelegation = true
+			}
+		}
+	}
+	step.Lame = iterativeIsLame(zone, q0.Name, resp)
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns} {
+		for _, rr := range section {
+			if _, ok := rr.(*dns.RRSIG); ok {
+				step.Signed = true
+			}
+		}
+	}
+	return step
+}
+
+// String renders the step as a single line of text.
+func (step *TraceStep) String() string {
+	var builder strings.Builder
+	builder.WriteString(strings.Repeat("  ", step.Depth))
+	fmt.Fprintf(&builder, "%s %s @%s (%s, zone %s) %s: ", step.Question.Name,
+		dns.TypeToString[step.Question.Qtype], step.Server, step.Protocol, step.Zone,
+		step.RTT.Round(time.Microsecond))
+	switch {
+	case step.Err != nil:
+		fmt.Fprintf(&builder, "error: %s", step.Err.Error())
+	case step.Referral != "":
+		fmt.Fprintf(&builder, "referral to %s [%s]", step.Referral, strings.Join(step.Nameservers, " "))
+		if step.SignedDelegation {
+			builder.WriteString(" signed delegation")
+		}
+	case step.Lame:
+		fmt.Fprintf(&builder, "%s lame", dns.RcodeToString[step.Response.Rcode])
+	default:
+		fmt.Fprintf(&builder, "%s", dns.RcodeToString[step.Response.Rcode])
+		if step.Response.Authoritative {
+			builder.WriteString(" authoritative")
+		}
+		fmt.Fprintf(&builder, " with %d answer RRs", len(step.Response.Answer))
+		if step.Response.Truncated {
+			builder.WriteString(" truncated")
+		}
+	}
+	if step.Signed {
+		builder.WriteString(" signed")
+	}
+	return builder.String()
+}
+
+// String renders the trace as text.
+func (tr *Trace) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, ";; trace of %s %s\n", tr.Question.Name, dns.TypeToString[tr.Question.Qtype])
+	for _, step := range tr.Steps {
+		fmt.Fprintf(&builder, "%s\n", step.String())
+	}
+	if tr.Err != nil {
+		fmt.Fprintf(&builder, ";; error: %s\n", tr.Err.Error())
+	}
+	if tr.Response != nil {
+		for _, rr := range tr.Response.Answer {
+			fmt.Fprintf(&builder, "%s\n", rr.String())
+		}
+	}
+	fmt.Fprintf(&builder, ";; resolved in %s with %d queries\n",
+		tr.Duration.Round(time.Microsecond), len(tr.Steps))
+	return builder.String()
+}
+
+// traceStepJSON is the JSON representation of a [*TraceStep].
+type traceStepJSON struct {
+	Depth            int      `json:"depth"`
+	Zone             string   `json:"zone"`
+	QName            string   `json:"qname"`
+	QType            string   `json:"qtype"`
+	Server           string   `json:"server"`
+	Protocol         Protocol `json:"protocol"`
+	RTTMsec          float64  `json:"rttMsec"`
+	Rcode            string   `json:"rcode,omitempty"`
+	Authoritative    bool     `json:"authoritative"`
+	Referral         string   `json:"referral,omitempty"`
+	Nameservers      []string `json:"nameservers,omitempty"`
+	Lame             bool     `json:"lame"`
+	Signed           bool     `json:"signed"`
+	SignedDelegation bool     `json:"signedDelegation"`
+	Err              string   `json:"err,omitempty"`
+}
+
+// MarshalJSON implements [json.Marshaler].
+func (tr *Trace) MarshalJSON() ([]byte, error) {
+	out := struct {
+		QName        string          `json:"qname"`
+		QType        string          `json:"qtype"`
+		Steps        []traceStepJSON `json:"steps"`
+		Response     *MsgJSON        `json:"response,omitempty"`
+		Err          string          `json:"err,omitempty"`
+		DurationMsec float64         `json:"durationMsec"`
+	}{
+		QName:        tr.Question.Name,
+		QType:        dns.TypeToString[tr.Question.Qtype],
+		Steps:        []traceStepJSON{},
+		DurationMsec: float64(tr.Duration) / float64(time.Millisecond),
+	}
+	for _, step := range tr.Steps {
+		entry := traceStepJSON{
+			Depth:            step.Depth,
+			Zone:             step.Zone,
+			QName:            step.Question.Name,
+			QType:            dns.TypeToString[step.Question.Qtype],
+			Server:           step.Server.String(),
+			Protocol:         step.Protocol,
+			RTTMsec:          float64(step.RTT) / float64(time.Millisecond),
+			Referral:         step.Referral,
+			Nameservers:      step.Nameservers,
+			Lame:             step.Lame,
+			Signed:           step.Signed,
+			SignedDelegation: step.SignedDelegation,
+		}
+		if step.Response != nil {
+			entry.Rcode = dns.RcodeToString[step.Response.Rcode]
+			entry.Authoritative = step.Response.Authoritative
+		}
+		if step.Err != nil {
+			entry.Err = step.Err.Error()
+		}
+		out.Steps = append(out.Steps, entry)
+	}
+	if tr.Response != nil {
+		out.Response = &MsgJSON{Msg: tr.Response}
+	}
+	if tr.Err != nil {
+		out.Err = tr.Err.Error()
+	}
+	return json.Marshal(out)
+}
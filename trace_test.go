@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIterativeResolver_Trace(t *testing.T) {
+	t.Run("records every step", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		reso.DisableQNameMinimization = true
+		tr, err := reso.Trace(context.Background(), "www.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		assert.NotNil(t, tr.Response)
+		assert.Equal(t, "www.example.com.", tr.Question.Name)
+
+		// priming, root, com, and example.com
+		assert.Len(t, tr.Steps, 4)
+		prime, root, com, example := tr.Steps[0], tr.Steps[1], tr.Steps[2], tr.Steps[3]
+		assert.Equal(t, ".", prime.Question.Name)
+		assert.Equal(t, "192.0.2.1:53", root.Server.String())
+		assert.Equal(t, ProtocolUDP, root.Protocol)
+		assert.Equal(t, "com.", root.Referral)
+		assert.Equal(t, []string{"a.gtld.com."}, root.Nameservers)
+		assert.False(t, root.SignedDelegation)
+		assert.Equal(t, "example.com.", com.Referral)
+		assert.True(t, com.SignedDelegation)
+		assert.Equal(t, "example.com.", example.Zone)
+		assert.Empty(t, example.Referral)
+		assert.True(t, example.Signed)
+		assert.True(t, example.Response.Authoritative)
+
+		text := tr.String()
+		assert.True(t, strings.HasPrefix(text, ";; trace of www.example.com. A\n"))
+		assert.Contains(t, text, "www.example.com. A @192.0.2.1:53 (udp, zone .) ")
+		assert.Contains(t, text, "referral to example.com. [ns.example.com.] signed delegation\n")
+		assert.Contains(t, text, "NOERROR authoritative with 2 answer RRs signed\n")
+		assert.Contains(t, text, ";; resolved in ")
+
+		data, err := json.Marshal(tr)
+		assert.NoError(t, err)
+		var out struct {
+			QName string
+			Steps []struct {
+				Zone             string
+				Referral         string
+				Rcode            string
+				SignedDelegation bool
+			}
+			Response MsgJSON
+		}
+		assert.NoError(t, json.Unmarshal(data, &out))
+		assert.Equal(t, "www.example.com.", out.QName)
+		assert.Len(t, out.Steps, 4)
+		assert.Equal(t, "NOERROR", out.Steps[2].Rcode)
+		assert.True(t, out.Steps[2].SignedDelegation)
+		assert.Len(t, out.Response.Msg.Answer, 2)
+	})
+
+	t.Run("nested resolution, truncation, and lame servers", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		reso.DisableQNameMinimization = true
+		tr, err := reso.Trace(context.Background(), "big.example.com", dns.TypeA)
+		assert.NoError(t, err)
+		last := tr.Steps[len(tr.Steps)-1]
+		assert.Equal(t, ProtocolTCP, last.Protocol)
+		assert.Contains(t, tr.Steps[len(tr.Steps)-2].String(), "truncated")
+
+		tr, err = reso.Trace(context.Background(), "www.glueless.com", dns.TypeA)
+		assert.NoError(t, err)
+		var nested bool
+		for _, step := range tr.Steps {
+			nested = nested || step.Depth > 0
+		}
+		assert.True(t, nested)
+
+		tr, err = reso.Trace(context.Background(), "www.refused.com", dns.TypeA)
+		assert.ErrorIs(t, err, ErrLameDelegation)
+		assert.Equal(t, err, tr.Err)
+		assert.True(t, tr.Steps[len(tr.Steps)-1].Lame)
+		assert.Contains(t, tr.String(), "REFUSED lame")
+		assert.Contains(t, tr.String(), ";; error: lame delegation\n")
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		reso := (&iterativeTestHierarchy{}).resolver()
+		reso.RootHints[0].Addrs[0] = reso.RootHints[0].Addrs[0].Next().Next().Next().Next().Next().Next()
+		tr, err := reso.Trace(context.Background(), "www.example.com", dns.TypeA)
+		assert.Error(t, err)
+		assert.Contains(t, tr.String(), "error: mocked error")
+		data, err := json.Marshal(tr)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"err":"mocked error"`)
+
+		tr, err = reso.Trace(context.Background(), "\t", dns.TypeA)
+		assert.Error(t, err)
+		assert.Equal(t, err, tr.Err)
+	})
+}
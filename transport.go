@@ -12,6 +12,7 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -24,7 +25,24 @@ import (
 // A [*Transport] is safe for concurrent use by multiple goroutines
 // as long as you don't modify its fields after construction and the
 // underlying fields you may set (e.g., DialContext) are also safe.
+//
+// In particular, we protect the internal state (i.e., the reused and
+// pipelined connections, the statistics, the concurrency limits, and the
+// log sampling) using locks or atomic operations, such that you can share
+// a single [*Transport] among many goroutines and concurrently invoke
+// [*Transport.Stats] and [*Transport.Shutdown] while queries are in flight.
+// Because of that, we invoke the functions you set (e.g., DialContext and
+// OnConnEvent) and write to the Logger and Capture from many goroutines at
+// the same time. See [*Transport.Query] for the queries you may share.
+//
+// A [*Transport] MUST NOT be copied after first use. Use
+// [*Transport.Shutdown] or [*Transport.Close] to stop it.
 type Transport struct {
+	// Capture is the optional [*PcapngWriter] where we write the DNS
+	// messages exchanged with the servers, for debugging. If this field
+	// is nil, we do not capture messages.
+	Capture *PcapngWriter
+
 	// DialContext is the optional dialer for creating new
 	// TCP and UDP connections. If this field is nil, the default
 	// dialer from the [net] package will be used.
@@ -32,16 +50,50 @@ type Transport struct {
 
 	// DialTLSContext is like DialContext but for creating new
 	// TLS connections. If this field is nil, we will configure
-	// a suitable [*tls.Config] and use [*tls.Dialer].
+	// a suitable [*tls.Config] and use it to perform the TLS
+	// handshake over a connection created using [*net.Dialer].
 	DialTLSContext func(ctx context.Context, network, address string) (net.Conn, error)
 
 	// HTTPClient is the optional HTTP client to use for DNS-over-HTTPS.
-	// If this field is nil, we use the  default HTTP client from [net/http].
+	// If this field is nil, we use the  default HTTP client from [net/http],
+	// unless the HTTP/2 settings or RootCAs require a dedicated client.
 	//
 	// When HTTPClientDo is nil and this field is not nil, we use this client to
 	// perform queries and http/httptrace to obtain connection information.
 	HTTPClient *http.Client
 
+	// H2CClient is the optional HTTP client to use for [ProtocolH2C]. If
+	// this field is nil, we use an HTTP/2 client with prior knowledge
+	// that creates cleartext connections using DialContext.
+	H2CClient *http.Client
+
+	// HTTP2PingInterval is the optional interval after which, when we did
+	// not receive any frame over the HTTP/2 connection used by the default
+	// DNS-over-HTTPS and [ProtocolH2C] clients, we send a PING frame to check
+	// the connection health, closing it if the server does not answer within
+	// HTTP2PingTimeout, such that the next queries use a new connection
+	// rather than timing out over a dead one.
+	//
+	// If zero, we do not perform health checks.
+	HTTP2PingInterval time.Duration
+
+	// HTTP2PingTimeout is the optional timeout of the PING frames sent
+	// when HTTP2PingInterval is positive.
+	//
+	// If zero, we use the default of [golang.org/x/net/http2].
+	HTTP2PingTimeout time.Duration
+
+	// HTTP2StrictMaxConcurrentStreams controls what the default
+	// DNS-over-HTTPS and [ProtocolH2C] clients do when the queries in
+	// flight reach the maximum number of concurrent streams advertised by
+	// the server. When true, further queries wait for a stream to become
+	// available, such that all the queries to the same server share a
+	// single HTTP/2 connection. When false, we open additional connections.
+	//
+	// Regardless of this setting, when the server sends a GOAWAY frame, we
+	// stop sending new queries over the connection and open a new one.
+	HTTP2StrictMaxConcurrentStreams bool
+
 	// HTTPClientDo optionally allows full control over how HTTP requests
 	// are performed and how to obtain connection information. When this
 	// field is non-nil, it takes precedence over HTTPClient.
@@ -50,16 +102,65 @@ type Transport struct {
 	// precise control over connection handling and addressing information.
 	HTTPClientDo func(req *http.Request) (*http.Response, netip.AddrPort, netip.AddrPort, error)
 
+	// Insecure enables the cleartext [ProtocolHTTP] and [ProtocolH2C]
+	// protocols, which are only suitable for lab setups and for sidecar
+	// deployments where TLS is terminated elsewhere. When this field is
+	// false, queries using such protocols fail with [ErrInsecureProtocol].
+	Insecure bool
+
 	// Logger is the optional structured logger for emitting
 	// structured diagnostic events. If this field is nil, we
 	// will not be emitting structured logs.
 	Logger *slog.Logger
 
+	// LogSampleRate is the optional sampling rate of the logs emitted
+	// for each query. When greater than one, we only emit the logs of one
+	// query every LogSampleRate queries, which avoids overwhelming the
+	// logging backend at high query rates. We always emit the dnsQueryError
+	// log of failed queries, regardless of the sampling.
+	//
+	// If zero, we emit the logs of all the queries.
+	LogSampleRate int
+
+	// MaxConnectionAge is the optional maximum age of the TCP and TLS
+	// connections kept when ReuseStreamConns or PipelineStreamQueries is
+	// true. Once a connection is older, we stop sending new queries over it
+	// and close it when its outstanding queries complete, such that we
+	// periodically reconnect, spreading the load across anycast instances
+	// and avoiding staying pinned to a degraded backend. This does not apply
+	// to DNS over HTTPS, since the HTTP client manages its connections.
+	//
+	// If zero, connections do not have a maximum age.
+	MaxConnectionAge time.Duration
+
+	// MaxConcurrentQueries is the optional maximum number of in-flight
+	// queries. When the limit is reached, new queries wait for in-flight
+	// queries to complete, and we start waiting interactive queries before
+	// background ones, according to the [QueryPriority] of their context.
+	//
+	// If zero, we do not limit the number of in-flight queries.
+	MaxConcurrentQueries int
+
+	// MaxBackgroundQueries is the optional maximum number of in-flight
+	// [QueryPriorityBackground] queries when MaxConcurrentQueries is
+	// positive. Setting it lower than MaxConcurrentQueries reserves
+	// capacity for interactive queries arriving during bulk scans.
+	//
+	// If zero, background queries may use all the capacity.
+	MaxBackgroundQueries int
+
 	// NewHTTPRequestWithContext is an optional function that creates a new
 	// HTTP request with the given context. If this field is nil, the
 	// [http.NewRequestWithContext] function will be used.
 	NewHTTPRequestWithContext func(ctx context.Context, method, url string, body io.Reader) (*http.Request, error)
 
+	// OnConnEvent is the optional function invoked synchronously for each
+	// connection lifecycle [*ConnEvent], such as creating, reusing, failing
+	// to handshake, and closing connections. It must be fast, safe for
+	// concurrent use, and must not call the [*Transport] methods, since we
+	// may invoke it while holding locks. If nil, we do not emit events.
+	OnConnEvent func(ev *ConnEvent)
+
 	// ReadAllContext is the optional function to read the whole HTTP response
 	// body in DNS-over-HTTPS. If this field is nil, we use the [io.ReadAll] function
 	// instead. Compared to [io.ReadAll], this function has a context argument
@@ -69,14 +170,87 @@ type Transport struct {
 	// interruption useful to avoid being blocked ~forever.
 	ReadAllContext func(ctx context.Context, r io.Reader, c io.Closer) ([]byte, error)
 
+	// ReuseStreamConns enables reusing DNS over TCP and DNS over TLS
+	// connections for subsequent queries to the same server. By default,
+	// we use a new connection for each query, which is more robust against
+	// residual censorship and more suitable for measurements. When reusing
+	// connections, we request the RFC 7828 edns-tcp-keepalive option for
+	// queries using EDNS(0) and keep idle connections open for the timeout
+	// advertised by the server or, if missing, [DefaultStreamIdleTimeout].
+	ReuseStreamConns bool
+
+	// PipelineStreamQueries enables sending concurrent DNS over TCP and
+	// DNS over TLS queries to the same server using a single connection
+	// without waiting for the previous responses, matching responses by
+	// message ID and question since they may arrive in any order. This
+	// option implies the connection reuse enabled by ReuseStreamConns.
+	//
+	// When reusing or pipelining, we use distinct connections for each
+	// [QueryPriority], such that background queries do not delay the
+	// interactive ones sharing a connection with them.
+	PipelineStreamQueries bool
+
 	// RootCAs contains the [*x509.CertPool] used by DNS-over-TLS
-	// when the DialTLSContext function pointer is nil. Leaving this
-	// field nil implies using the system's root CAs.
+	// when the DialTLSContext function pointer is nil and by DNS-over-HTTPS
+	// when the HTTPClient and HTTPClientDo fields are nil, regardless of
+	// the HTTP/2 settings. Leaving this field nil implies using the
+	// system's root CAs.
 	RootCAs *x509.CertPool
 
+	// ServerAddrCacheTTL is the optional amount of time for which we cache
+	// the addresses of the servers whose Address contains a hostname rather
+	// than an IP address, such that we do not resolve the hostname for each
+	// query using the system resolver. When all the connection attempts
+	// using the cached addresses fail, we asynchronously resolve the hostname
+	// again. This only applies when the DialContext and DialTLSContext fields
+	// are nil, and does not apply to DNS over HTTPS, except when using the
+	// default [ProtocolH2C] client, since the HTTP client manages its connections.
+	//
+	// If zero, we resolve the hostname each time we dial.
+	ServerAddrCacheTTL time.Duration
+
+	// StreamProbeInterval is the optional interval at which we probe the
+	// idle TCP and TLS connections kept when ReuseStreamConns is true,
+	// closing those that the server closed or that contain unexpected data,
+	// such that the first query after an idle period does not fail or time
+	// out using a dead connection. We also enable TCP keepalives with this
+	// period on reused and pipelined connections, such that the kernel
+	// detects broken network paths without us sending DNS queries.
+	//
+	// If zero, we only detect dead connections when using them.
+	StreamProbeInterval time.Duration
+
 	// TimeNow is an optional function that returns the current time.
 	// If this field is nil, the [time.Now] function will be used.
 	TimeNow func() time.Time
+
+	// closeState tracks in-flight queries for [*Transport.Shutdown].
+	closeState transportCloseState
+
+	// doh contains the default DNS-over-HTTPS client used when
+	// the HTTP/2 settings differ from the defaults.
+	doh transportHTTPClient
+
+	// h2c contains the default [ProtocolH2C] client.
+	h2c transportHTTPClient
+
+	// limiter enforces MaxConcurrentQueries and MaxBackgroundQueries.
+	limiter queryLimiter
+
+	// logSamples counts the queries for sampling the logs.
+	logSamples atomic.Uint64
+
+	// pipelines contains the shared TCP and TLS connections.
+	pipelines pipelineSet
+
+	// serverAddrs caches the server addresses for ServerAddrCacheTTL.
+	serverAddrs serverAddrCache
+
+	// stats contains the statistics returned by [*Transport.Stats].
+	stats transportStats
+
+	// streams contains the idle TCP and TLS connections.
+	streams streamPool
 }
 
 // DefaultTransport is the default transport used by the package.
@@ -91,10 +265,29 @@ var ErrNoSuchTransportProtocol = errors.New("no such transport protocol")
 // cancelled or times out, the query will be aborted and an error will
 // be immediately returned to the caller.
 //
+// After [*Transport.Shutdown] or [*Transport.Close], this method
+// fails with [ErrTransportClosed].
+//
 // The returned DNS message is the first message received from the server and
 // it is not guaranteed to be valid for the query. You will still need to
 // validate the response using the [ValidateResponse] function.
+//
+// This method does not modify the query, hence you can reuse the same query
+// and send it concurrently, provided that you do not modify it while queries
+// using it are in flight. When we need to send a different query, e.g., to
+// avoid reusing the ID of an in-flight pipelined query, we use a copy.
 func (t *Transport) Query(ctx context.Context,
+	addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	ctx, done, err := t.beginQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return t.queryAndRecord(ctx, addr, query)
+}
+
+// query dispatches the query to the protocol-specific implementation.
+func (t *Transport) query(ctx context.Context,
 	addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
 	switch addr.Protocol {
 	case ProtocolUDP:
@@ -109,6 +302,15 @@ func (t *Transport) Query(ctx context.Context,
 	case ProtocolDoH:
 		return t.queryHTTPS(ctx, addr, query)
 
+	case ProtocolDoHJSON:
+		return t.queryDoHJSON(ctx, addr, query)
+
+	case ProtocolHTTP, ProtocolH2C:
+		if !t.Insecure {
+			return nil, fmt.Errorf("%w: %s", ErrInsecureProtocol, addr.Protocol)
+		}
+		return t.queryHTTPS(ctx, addr, query)
+
 	default:
 		return nil, fmt.Errorf("%w: %s", ErrNoSuchTransportProtocol, addr.Protocol)
 	}
@@ -149,5 +351,15 @@ func (t *Transport) QueryWithDuplicates(ctx context.Context,
 		return ch
 	}
 
-	return t.queryUDPWithDuplicates(ctx, addr, query)
+	ctx, done, err := t.beginQuery(ctx)
+	if err != nil {
+		ch := make(chan *MessageOrError, 1)
+		ch <- &MessageOrError{Err: err}
+		close(ch)
+		return ch
+	}
+
+	ctx = t.withLogSample(ctx)
+	t.stats.onQuery(addr)
+	return t.queryUDPWithDuplicates(ctx, addr, query, done)
 }
@@ -5,9 +5,11 @@ package dnscore
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 
 	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestTransportQuery(t *testing.T) {
@@ -23,6 +25,7 @@ func TestTransportQuery(t *testing.T) {
 		{protocol: ProtocolTCP, expectErr: context.Canceled},
 		{protocol: ProtocolDoT, expectErr: context.Canceled},
 		{protocol: ProtocolDoH, expectErr: context.Canceled},
+		{protocol: ProtocolDoHJSON, expectErr: context.Canceled},
 		{protocol: "", expectErr: ErrNoSuchTransportProtocol},
 	}
 
@@ -44,6 +47,48 @@ func TestTransportQuery(t *testing.T) {
 	}
 }
 
+func TestTransportQueryDoesNotModifyQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		txp  *Transport
+	}{
+		{"new connections", &Transport{}},
+		{"reused connections", &Transport{ReuseStreamConns: true}},
+		{"pipelined queries", &Transport{PipelineStreamQueries: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 1. create a query the stream transports would modify, if they
+			// did not copy it, and send it concurrently several times
+			addr, _ := startStreamServer(t, answerStreamQueries(1, answerA))
+			defer tt.txp.Close()
+			query, err := NewQuery("example.com", dns.TypeA,
+				QueryOptionEDNS0(4096, EDNS0FlagBlockLengthPadding))
+			assert.NoError(t, err)
+			rawBefore, err := query.Pack()
+			assert.NoError(t, err)
+
+			wg := &sync.WaitGroup{}
+			for range 4 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := tt.txp.Query(context.Background(), addr, query)
+					assert.NoError(t, err)
+					assert.NoError(t, ValidateResponse(query, resp))
+				}()
+			}
+			wg.Wait()
+
+			// 2. make sure the query did not change
+			rawAfter, err := query.Pack()
+			assert.NoError(t, err)
+			assert.Equal(t, rawBefore, rawAfter)
+		})
+	}
+}
+
 func TestTransportQueryWithDuplicates(t *testing.T) {
 	// create a canceled context so that we do not actually perform the query
 	ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTransportClosed is returned when using a closed [*Transport].
+var ErrTransportClosed = errors.New("transport closed")
+
+// ErrCloseDefaultTransport is returned when closing [DefaultTransport].
+var ErrCloseDefaultTransport = errors.New("cannot close the default transport")
+
+// DefaultCloseTimeout is the maximum amount of time [*Transport.Close]
+// waits for in-flight queries before interrupting them.
+const DefaultCloseTimeout = 5 * time.Second
+
+// transportCloseState tracks in-flight queries to allow
+// [*Transport.Shutdown] to drain them gracefully.
+//
+// The zero value is ready to use.
+type transportCloseState struct {
+	// abortCtx is canceled to interrupt in-flight queries.
+	abortCtx context.Context
+
+	// abortCancel cancels abortCtx.
+	abortCancel context.CancelFunc
+
+	// closed indicates we're not accepting new queries.
+	closed bool
+
+	// inflight tracks the in-flight queries.
+	inflight sync.WaitGroup
+
+	// mu protects the fields above.
+	mu sync.Mutex
+}
+
+// initLocked lazily initializes the abort context.
+//
+// This method MUST be called while holding the mutex.
+func (s *transportCloseState) initLocked() {
+	if s.abortCtx == nil {
+		s.abortCtx, s.abortCancel = context.WithCancel(context.Background())
+	}
+}
+
+// beginQuery registers a new in-flight query or fails with
+// [ErrTransportClosed] if the transport has been closed. Then, it
+// waits for the query to be allowed to start by the concurrency
+// limits, failing if the context is done while waiting.
+//
+// On success, it returns a context that is canceled when the
+// transport is forcibly closed along with a function that
+// the caller MUST call when the query is complete.
+func (t *Transport) beginQuery(ctx context.Context) (context.Context, func(), error) {
+	// 1. register the in-flight query
+	t.closeState.mu.Lock()
+	if t.closeState.closed {
+		t.closeState.mu.Unlock()
+		return nil, nil, ErrTransportClosed
+	}
+	t.closeState.initLocked()
+	t.closeState.inflight.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(t.closeState.abortCtx, cancel)
+	t.closeState.mu.Unlock()
+	done := func() {
+		stop()
+		cancel()
+		t.closeState.inflight.Done()
+	}
+
+	// 2. wait for the concurrency limits, which we do after registering
+	// the query such that forcibly closing interrupts the wait
+	release, err := t.acquireQuerySlot(ctx)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return ctx, func() {
+		release()
+		done()
+	}, nil
+}
+
+// Shutdown gracefully closes the [*Transport]. It immediately stops
+// accepting new queries, which fail with [ErrTransportClosed], and then
+// waits for in-flight queries to complete. If the context is done before
+// all in-flight queries complete, Shutdown interrupts them, waits for
+// them to return, and returns the context error.
+//
+// Shutdown also closes the idle connections of the HTTPClient and
+// H2CClient fields, when they are not nil, of the default [ProtocolH2C]
+// client, and the TCP and TLS connections kept when ReuseStreamConns or
+// PipelineStreamQueries is true. We do not touch [http.DefaultClient] because it's shared.
+//
+// Calling Shutdown more than once is safe.
+//
+// Shutdown refuses to close [DefaultTransport] and returns [ErrCloseDefaultTransport]
+// without changing it, since closing it would break the package-level functions
+// and the zero-value [*Resolver], which share it. Create your own [*Transport]
+// if you need to close it.
+func (t *Transport) Shutdown(ctx context.Context) (err error) {
+	// 0. make sure we do not break the users of the shared transport
+	if t == DefaultTransport {
+		return ErrCloseDefaultTransport
+	}
+
+	// 1. stop accepting new queries
+	t.closeState.mu.Lock()
+	t.closeState.closed = true
+	t.closeState.initLocked()
+	abort := t.closeState.abortCancel
+	t.closeState.mu.Unlock()
+
+	// 2. wait for in-flight queries to drain
+	drained := make(chan struct{})
+	go func() {
+		t.closeState.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		abort()
+		<-drained
+		err = ctx.Err()
+	}
+
+	// 3. tear down idle connections
+	if t.HTTPClient != nil {
+		t.HTTPClient.CloseIdleConnections()
+	}
+	if t.H2CClient != nil {
+		t.H2CClient.CloseIdleConnections()
+	}
+	t.doh.closeIdleConnections()
+	t.h2c.closeIdleConnections()
+	t.streams.closeIdle()
+	t.pipelines.closeAll()
+	return
+}
+
+// Close is like [*Transport.Shutdown] but waits at most for
+// [DefaultCloseTimeout] for in-flight queries to complete.
+//
+// Like Shutdown, Close returns [ErrCloseDefaultTransport] without
+// changing [DefaultTransport], which the whole package shares.
+func (t *Transport) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+	defer cancel()
+	return t.Shutdown(ctx)
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// closeIdlerRoundTripper is an [http.RoundTripper] recording
+// whether CloseIdleConnections has been called.
+type closeIdlerRoundTripper struct {
+	closed atomic.Bool
+}
+
+func (rt *closeIdlerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (rt *closeIdlerRoundTripper) CloseIdleConnections() {
+	rt.closed.Store(true)
+}
+
+func TestTransport_Shutdown(t *testing.T) {
+	t.Run("new queries fail after shutdown", func(t *testing.T) {
+		txp := &Transport{}
+		assert.NoError(t, txp.Shutdown(context.Background()))
+
+		addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+
+		resp, err := txp.Query(context.Background(), addr, query)
+		assert.ErrorIs(t, err, ErrTransportClosed)
+		assert.Nil(t, resp)
+
+		var results []*MessageOrError
+		for result := range txp.QueryWithDuplicates(context.Background(), addr, query) {
+			results = append(results, result)
+		}
+		assert.Len(t, results, 1)
+		assert.ErrorIs(t, results[0].Err, ErrTransportClosed)
+
+		// calling shutdown again is safe
+		assert.NoError(t, txp.Shutdown(context.Background()))
+	})
+
+	t.Run("waits for in-flight queries", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				close(started)
+				<-release
+				return nil, errors.New("mocked error")
+			},
+		}
+		addr := NewServerAddr(ProtocolTCP, "8.8.8.8:53")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+
+		queryDone := make(chan error, 1)
+		go func() {
+			_, err := txp.Query(context.Background(), addr, query)
+			queryDone <- err
+		}()
+		<-started
+
+		shutdownDone := make(chan error, 1)
+		go func() {
+			shutdownDone <- txp.Shutdown(context.Background())
+		}()
+
+		select {
+		case <-shutdownDone:
+			t.Fatal("shutdown returned before in-flight query completed")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		assert.EqualError(t, <-queryDone, "mocked error")
+		assert.NoError(t, <-shutdownDone)
+	})
+
+	t.Run("interrupts in-flight queries when the context is done", func(t *testing.T) {
+		started := make(chan struct{})
+		txp := &Transport{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				close(started)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		}
+		addr := NewServerAddr(ProtocolTCP, "8.8.8.8:53")
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+
+		queryDone := make(chan error, 1)
+		go func() {
+			_, err := txp.Query(context.Background(), addr, query)
+			queryDone <- err
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, txp.Shutdown(ctx), context.DeadlineExceeded)
+		assert.ErrorIs(t, <-queryDone, context.Canceled)
+	})
+
+	t.Run("closes idle HTTP connections", func(t *testing.T) {
+		rt := &closeIdlerRoundTripper{}
+		txp := &Transport{HTTPClient: &http.Client{Transport: rt}}
+		assert.NoError(t, txp.Shutdown(context.Background()))
+		assert.True(t, rt.closed.Load())
+	})
+
+	t.Run("refuses to close the default transport", func(t *testing.T) {
+		assert.ErrorIs(t, DefaultTransport.Shutdown(context.Background()), ErrCloseDefaultTransport)
+		assert.ErrorIs(t, DefaultTransport.Close(), ErrCloseDefaultTransport)
+		DefaultTransport.closeState.mu.Lock()
+		defer DefaultTransport.closeState.mu.Unlock()
+		assert.False(t, DefaultTransport.closeState.closed)
+	})
+}
+
+func TestTransport_Close(t *testing.T) {
+	txp := &Transport{}
+	assert.NoError(t, txp.Close())
+
+	addr := NewServerAddr(ProtocolUDP, "8.8.8.8:53")
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeA)
+	_, err := txp.Query(context.Background(), addr, query)
+	assert.ErrorIs(t, err, ErrTransportClosed)
+}
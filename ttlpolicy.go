@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrInvalidTTLPolicy indicates that a [*TTLPolicy] contains
+// several overrides, or zone overrides, for the same name.
+var ErrInvalidTTLPolicy = errors.New("invalid TTL policy")
+
+// TTLPolicy clamps and overrides the TTL of the RRs returned, and cached,
+// by the [*Resolver] and the [*IterativeResolver]. This is useful, e.g., to
+// cap absurdly long TTLs and to floor zero TTLs in embedded deployments.
+//
+// A nil [*TTLPolicy] does not change the TTLs. We index the overrides
+// when first using the policy, thus you should not modify the policy
+// after setting it, and lookups fail when [*TTLPolicy.Validate] fails.
+type TTLPolicy struct {
+	// MinTTL is the optional minimum TTL. Lower TTLs are raised to it.
+	MinTTL time.Duration
+
+	// MaxTTL is the optional maximum TTL. Higher TTLs are lowered to it.
+	MaxTTL time.Duration
+
+	// Overrides optionally maps domain names to the TTL to use for
+	// their RRs regardless of MinTTL and MaxTTL. Names are compared
+	// case-insensitively and need not be fully qualified.
+	Overrides map[string]time.Duration
+
+	// ZoneOverrides optionally maps zone names to the TTL to use for the
+	// RRs of the zone apex and of all the names below it, unless Overrides
+	// contains the name. When zones are nested, the closest enclosing zone
+	// wins. Names are compared as in Overrides.
+	ZoneOverrides map[string]time.Duration
+
+	// once ensures we index the overrides once.
+	once sync.Once
+
+	// overrides maps the canonical names of Overrides to their TTL.
+	overrides map[string]uint32
+
+	// zoneOverrides is like overrides but for ZoneOverrides.
+	zoneOverrides map[string]uint32
+
+	// err is the error indexing the overrides, if any.
+	err error
+}
+
+// init indexes the overrides by canonical name, such that two
+// keys with the same canonical name make the policy invalid.
+func (p *TTLPolicy) init() {
+	p.once.Do(func() {
+		p.overrides, p.err = indexTTLOverrides(p.Overrides)
+		if p.err == nil {
+			p.zoneOverrides, p.err = indexTTLOverrides(p.ZoneOverrides)
+		}
+	})
+}
+
+// indexTTLOverrides maps the canonical names of the given overrides to their TTL.
+func indexTTLOverrides(overrides map[string]time.Duration) (map[string]uint32, error) {
+	index := make(map[string]uint32, len(overrides))
+	for key, value := range overrides {
+		name := dns.CanonicalName(key)
+		if _, found := index[name]; found {
+			return nil, fmt.Errorf("%w: duplicate override for %s", ErrInvalidTTLPolicy, name)
+		}
+		index[name] = ttlSeconds(value)
+	}
+	return index, nil
+}
+
+// Validate returns an error wrapping [ErrInvalidTTLPolicy] when several
+// overrides, or several zone overrides, have the same canonical name, e.g.,
+// "example.com" and "Example.COM.", since we could not choose the TTL.
+func (p *TTLPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	p.init()
+	return p.err
+}
+
+// ttlSeconds converts a duration to a TTL in seconds, saturating.
+func ttlSeconds(d time.Duration) uint32 {
+	return uint32(min(max(d/time.Second, 0), math.MaxUint32))
+}
+
+// override returns the override for the given canonical name, if any.
+func (p *TTLPolicy) override(name string) (uint32, bool) {
+	ttl, found := p.overrides[name]
+	return ttl, found
+}
+
+// zoneOverride returns the override of the closest zone
+// enclosing the given canonical name, if any.
+func (p *TTLPolicy) zoneOverride(name string) (uint32, bool) {
+	if len(p.zoneOverrides) <= 0 {
+		return 0, false
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if ttl, found := p.zoneOverrides[name[off:]]; found {
+			return ttl, true
+		}
+	}
+	ttl, found := p.zoneOverrides["."]
+	return ttl, found
+}
+
+// TTL returns the TTL, in seconds, to use for an RR with the given owner
+// name and the given original TTL, in seconds, according to the policy.
+func (p *TTLPolicy) TTL(name string, ttl uint32) uint32 {
+	if p == nil {
+		return ttl
+	}
+	p.init()
+	name = dns.CanonicalName(name)
+	if value, found := p.override(name); found {
+		return value
+	}
+	if value, found := p.zoneOverride(name); found {
+		return value
+	}
+	if p.MaxTTL > 0 {
+		ttl = min(ttl, ttlSeconds(p.MaxTTL))
+	}
+	return max(ttl, ttlSeconds(p.MinTTL))
+}
+
+// Apply returns the given RRs with their TTL modified according to the
+// policy. To avoid modifying the responses, which the caller may archive,
+// we copy the RRs whose TTL changes. We never modify OPT pseudo-RRs,
+// whose TTL field contains EDNS(0) flags.
+func (p *TTLPolicy) Apply(rrs []dns.RR) []dns.RR {
+	if p == nil {
+		return rrs
+	}
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if ttl := p.TTL(hdr.Name, hdr.Ttl); hdr.Rrtype != dns.TypeOPT && ttl != hdr.Ttl {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = ttl
+		}
+		out = append(out, rr)
+	}
+	return out
+}
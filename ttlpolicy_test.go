@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLPolicy_TTL(t *testing.T) {
+	policy := &TTLPolicy{
+		MinTTL:    30 * time.Second,
+		MaxTTL:    time.Hour,
+		Overrides: map[string]time.Duration{"Pinned.Example.COM": 10 * time.Second},
+	}
+	zonePolicy := &TTLPolicy{
+		MaxTTL:        time.Hour,
+		Overrides:     map[string]time.Duration{"pinned.cdn.example.org": 10 * time.Second},
+		ZoneOverrides: map[string]time.Duration{"Example.ORG": time.Minute, "cdn.example.org.": 5 * time.Second},
+	}
+
+	tests := []struct {
+		name     string
+		policy   *TTLPolicy
+		rrName   string
+		ttl      uint32
+		expected uint32
+	}{
+		{"nil policy", nil, "example.com.", 0, 0},
+		{"within bounds", policy, "example.com.", 300, 300},
+		{"below the minimum", policy, "example.com.", 0, 30},
+		{"above the maximum", policy, "example.com.", 86400 * 7, 3600},
+		{"override", policy, "pinned.example.com.", 86400, 10},
+		{"zone apex", zonePolicy, "EXAMPLE.org.", 300, 60},
+		{"below the zone", zonePolicy, "www.example.org.", 86400, 60},
+		{"closest zone", zonePolicy, "a.cdn.example.org.", 300, 5},
+		{"override over zone", zonePolicy, "pinned.cdn.example.org.", 300, 10},
+		{"outside the zone", zonePolicy, "notexample.org.", 86400, 3600},
+		{"root zone", &TTLPolicy{ZoneOverrides: map[string]time.Duration{".": time.Minute}}, "example.com.", 0, 60},
+		{"only maximum", &TTLPolicy{MaxTTL: time.Minute}, "example.com.", 0, 0},
+		{"saturating", &TTLPolicy{MinTTL: 200 * 365 * 24 * time.Hour}, "example.com.", 0, 1<<32 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.policy.TTL(tt.rrName, tt.ttl))
+		})
+	}
+}
+
+func TestTTLPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *TTLPolicy
+		err    error
+	}{
+		{"nil policy", nil, nil},
+		{"distinct overrides", &TTLPolicy{Overrides: map[string]time.Duration{
+			"example.com": time.Minute, "www.example.com": time.Hour}}, nil},
+		{"duplicate overrides", &TTLPolicy{Overrides: map[string]time.Duration{
+			"example.com": time.Minute, "Example.COM.": time.Hour}}, ErrInvalidTTLPolicy},
+		{"duplicate zone overrides", &TTLPolicy{ZoneOverrides: map[string]time.Duration{
+			"example.org.": time.Minute, "EXAMPLE.org": time.Hour}}, ErrInvalidTTLPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.policy.Validate(), tt.err)
+		})
+	}
+}
+
+func TestTTLPolicy_Apply(t *testing.T) {
+	a, _ := dns.NewRR("example.com. 86400 IN A 93.184.215.14")
+	aaaa, _ := dns.NewRR("example.com. 300 IN AAAA 2001:db8::1")
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT, Ttl: 1 << 15}}
+	rrs := []dns.RR{a, aaaa, opt}
+
+	t.Run("nil policy", func(t *testing.T) {
+		var policy *TTLPolicy
+		assert.Equal(t, rrs, policy.Apply(rrs))
+	})
+
+	t.Run("copies the modified RRs", func(t *testing.T) {
+		policy := &TTLPolicy{MaxTTL: time.Hour}
+		out := policy.Apply(rrs)
+		assert.Len(t, out, 3)
+		assert.Equal(t, uint32(3600), out[0].Header().Ttl)
+		assert.Equal(t, uint32(86400), a.Header().Ttl)
+		assert.Same(t, aaaa, out[1])
+		assert.Same(t, opt, out[2])
+	})
+}
+
+func TestResolver_TTLPolicy(t *testing.T) {
+	newResolver := func(policy *TTLPolicy) *Resolver {
+		return &Resolver{
+			TTLPolicy: policy,
+			Transport: &MockResolverTransport{
+				MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+					resp := &dns.Msg{}
+					resp.SetReply(query)
+					rr, _ := dns.NewRR("example.com. 0 IN A 93.184.215.14")
+					resp.Answer = append(resp.Answer, rr)
+					return resp, nil
+				},
+			},
+		}
+	}
+
+	rrs, exchanges, err := newResolver(&TTLPolicy{MinTTL: time.Minute}).
+		LookupWithExchanges(context.Background(), "example.com", dns.TypeA)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(60), rrs[0].Header().Ttl)
+	assert.Equal(t, uint32(0), exchanges[0].Response.Answer[0].Header().Ttl)
+
+	rrs, _, err = newResolver(nil).LookupWithExchanges(context.Background(), "example.com", dns.TypeA)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), rrs[0].Header().Ttl)
+
+	invalid := &TTLPolicy{Overrides: map[string]time.Duration{"example.com": 0, "example.com.": time.Hour}}
+	_, err = newResolver(invalid).LookupA(context.Background(), "example.com")
+	assert.ErrorIs(t, err, ErrInvalidTTLPolicy)
+}
+
+func TestIterativeResolver_TTLPolicy(t *testing.T) {
+	h := &iterativeTestHierarchy{}
+	reso := h.resolver()
+	now := time.Now()
+	reso.TimeNow = func() time.Time { return now }
+	reso.TTLPolicy = &TTLPolicy{MaxTTL: time.Minute, Overrides: map[string]time.Duration{"com": time.Hour}}
+
+	rrs, err := reso.Lookup(context.Background(), "www.example.com", dns.TypeA)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(60), rrs[0].Header().Ttl)
+
+	reso.mu.Lock()
+	defer reso.mu.Unlock()
+	assert.Equal(t, now.Add(time.Hour), reso.delegations["com."].expires)
+	assert.Equal(t, now.Add(time.Minute), reso.delegations["example.com."].expires)
+}
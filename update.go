@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTSIGFudge is the default clock skew tolerated by the TSIG
+// signatures of the responses sent by [*UpdateHandler].
+const DefaultTSIGFudge = 5 * time.Minute
+
+// Update is a validated RFC 2136 UPDATE message passed to the
+// Apply callback of [*UpdateHandler].
+type Update struct {
+	// Zone is the canonical name of the zone to update.
+	Zone string
+
+	// KeyName is the canonical name of the TSIG key that signed
+	// the message or empty when we accepted an unsigned message.
+	KeyName string
+
+	// Prerequisites contains the prerequisite section (RFC 2136 Sect. 2.4).
+	Prerequisites []dns.RR
+
+	// Updates contains the update section (RFC 2136 Sect. 2.5).
+	Updates []dns.RR
+}
+
+// UpdateHandler handles the RFC 2136 UPDATE messages received by the
+// caller, which owns the server sockets, since the package does not include
+// a server. We check the message format, authenticate the message using
+// TSIG (RFC 8945), check that the message is for the zone, and prescan the
+// prerequisite and update sections as documented by RFC 2136 Sect. 3.2 and
+// Sect. 3.4.1. Then, we invoke the Apply callback, which evaluates the
+// prerequisites against the zone data and applies the updates atomically.
+//
+// The zero value is not ready to use; you MUST set Apply and Origin.
+type UpdateHandler struct {
+	// Apply is the MANDATORY callback evaluating the prerequisites and
+	// applying the updates, which must return the response code, e.g.,
+	// [dns.RcodeSuccess] or, when a prerequisite is not satisfied, one of
+	// [dns.RcodeNameError], [dns.RcodeYXDomain], [dns.RcodeYXRrset], and
+	// [dns.RcodeNXRrset]. We may invoke Apply from many goroutines at
+	// the same time, so it should serialize the updates.
+	Apply func(update *Update) int
+
+	// Origin is the MANDATORY origin of the zone to update.
+	Origin string
+
+	// TSIGKeys maps the names of the TSIG keys allowed to update the zone
+	// to their base64-encoded secrets. When this field is empty, we accept
+	// unsigned messages, which is only suitable for lab setups and for
+	// servers reachable exclusively by trusted clients.
+	TSIGKeys map[string]string
+}
+
+// HandleUpdate handles an UPDATE message received by the caller, returning
+// the raw response to send or an error when we cannot parse the message,
+// in which case the caller should not respond. When the message is signed,
+// we sign the response using the same TSIG key.
+func (h *UpdateHandler) HandleUpdate(rawQuery []byte) ([]byte, error) {
+	// 1. parse the message
+	query := &dns.Msg{}
+	if err := query.Unpack(rawQuery); err != nil {
+		return nil, err
+	}
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+
+	// 2. authenticate the message, responding without a signature
+	// when the message is not signed
+	tsig, secret := h.verifyTSIG(rawQuery, query)
+	if tsig == nil {
+		resp.Rcode = dns.RcodeRefused
+		if len(h.TSIGKeys) <= 0 {
+			resp.Rcode = h.handle(query, "")
+		}
+		return resp.Pack()
+	}
+
+	// 3. handle the message and sign the response, noting that
+	// we send an unsigned TSIG record for BADKEY and BADSIG
+	if tsig.Error != dns.RcodeSuccess {
+		resp.Rcode = dns.RcodeNotAuth
+	} else {
+		resp.Rcode = h.handle(query, dns.CanonicalName(tsig.Hdr.Name))
+	}
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, uint16(DefaultTSIGFudge/time.Second), time.Now().Unix())
+	resp.Extra[len(resp.Extra)-1].(*dns.TSIG).Error = tsig.Error
+	rawResp, _, err := dns.TsigGenerate(resp, secret, tsig.MAC, false)
+	return rawResp, err
+}
+
+// verifyTSIG verifies the TSIG record of the message, if any, returning
+// nil when the message is not signed. Otherwise, we return a copy of the
+// TSIG record whose Error field is the TSIG error, along with the secret.
+func (h *UpdateHandler) verifyTSIG(rawQuery []byte, query *dns.Msg) (*dns.TSIG, string) {
+	signed := query.IsTsig()
+	if signed == nil {
+		return nil, ""
+	}
+	tsig := *signed
+	tsig.Error = dns.RcodeSuccess
+	secret, found := h.tsigSecret(tsig.Hdr.Name)
+	if !found {
+		tsig.Error, tsig.MAC = dns.RcodeBadKey, ""
+		return &tsig, ""
+	}
+	switch err := dns.TsigVerify(rawQuery, secret, "", false); {
+	case errors.Is(err, dns.ErrTime):
+		tsig.Error = dns.RcodeBadTime
+	case err != nil:
+		tsig.Error, tsig.MAC = dns.RcodeBadSig, ""
+	}
+	return &tsig, secret
+}
+
+// tsigSecret returns the secret of the given TSIG key.
+func (h *UpdateHandler) tsigSecret(name string) (string, bool) {
+	for key, secret := range h.TSIGKeys {
+		if equalASCIIName(dns.Fqdn(key), dns.Fqdn(name)) {
+			return secret, true
+		}
+	}
+	return "", false
+}
+
+// handle checks the authenticated message, which the given key signed,
+// and invokes Apply, returning the response code.
+func (h *UpdateHandler) handle(query *dns.Msg, keyName string) int {
+	// 1. check the zone section (RFC 2136 Sect. 3.1)
+	if query.Opcode != dns.OpcodeUpdate || len(query.Question) != 1 {
+		return dns.RcodeFormatError
+	}
+	zone := query.Question[0]
+	if zone.Qtype != dns.TypeSOA || zone.Qclass != dns.ClassINET {
+		return dns.RcodeFormatError
+	}
+	if !equalASCIIName(zone.Name, dns.Fqdn(h.Origin)) {
+		return dns.RcodeNotAuth
+	}
+
+	// 2. prescan the prerequisite and update sections
+	for _, rr := range query.Answer {
+		if rcode := updatePrescanPrerequisite(zone.Name, rr); rcode != dns.RcodeSuccess {
+			return rcode
+		}
+	}
+	for _, rr := range query.Ns {
+		if rcode := updatePrescanUpdate(zone.Name, rr); rcode != dns.RcodeSuccess {
+			return rcode
+		}
+	}
+
+	// 3. apply the update
+	return h.Apply(&Update{
+		Zone:          dns.CanonicalName(zone.Name),
+		KeyName:       keyName,
+		Prerequisites: query.Answer,
+		Updates:       query.Ns,
+	})
+}
+
+// updateIsMetaType returns whether the given type is a meta type
+// that cannot appear in the prerequisite and update sections.
+func updateIsMetaType(rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTSIG:
+		return true
+	default:
+		return false
+	}
+}
+
+// updatePrescanPrerequisite prescans a prerequisite (RFC 2136 Sect. 3.2).
+func updatePrescanPrerequisite(zone string, rr dns.RR) int {
+	hdr := rr.Header()
+	switch {
+	case hdr.Ttl != 0 || updateIsMetaType(hdr.Rrtype):
+		return dns.RcodeFormatError
+	case !dns.IsSubDomain(zone, hdr.Name):
+		return dns.RcodeNotZone
+	case hdr.Class == dns.ClassANY || hdr.Class == dns.ClassNONE:
+		if hdr.Rdlength != 0 {
+			return dns.RcodeFormatError
+		}
+		return dns.RcodeSuccess
+	case hdr.Class == dns.ClassINET && hdr.Rrtype != dns.TypeANY:
+		return dns.RcodeSuccess
+	default:
+		return dns.RcodeFormatError
+	}
+}
+
+// updatePrescanUpdate prescans an update (RFC 2136 Sect. 3.4.1.3).
+func updatePrescanUpdate(zone string, rr dns.RR) int {
+	hdr := rr.Header()
+	switch {
+	case !dns.IsSubDomain(zone, hdr.Name):
+		return dns.RcodeNotZone
+	case updateIsMetaType(hdr.Rrtype):
+		return dns.RcodeFormatError
+	case hdr.Class == dns.ClassINET && hdr.Rrtype != dns.TypeANY:
+		return dns.RcodeSuccess
+	case hdr.Class == dns.ClassANY && hdr.Ttl == 0 && hdr.Rdlength == 0:
+		return dns.RcodeSuccess
+	case hdr.Class == dns.ClassNONE && hdr.Ttl == 0 && hdr.Rrtype != dns.TypeANY:
+		return dns.RcodeSuccess
+	default:
+		return dns.RcodeFormatError
+	}
+}
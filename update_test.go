@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateHandler_HandleUpdate(t *testing.T) {
+	const secret = "c2VjcmV0LWtleS1mb3ItdGVzdGluZw=="
+
+	// newRR parses the given RR.
+	newRR := func(s string) dns.RR {
+		rr, err := dns.NewRR(s)
+		assert.NoError(t, err)
+		return rr
+	}
+
+	// newUpdate returns an UPDATE adding an A record to the given zone.
+	newUpdate := func(zone string) *dns.Msg {
+		msg := &dns.Msg{}
+		msg.SetUpdate(zone)
+		msg.Insert([]dns.RR{newRR("www.example.com. 300 IN A 192.0.2.1")})
+		return msg
+	}
+
+	// sign returns the raw message signed using the given key.
+	sign := func(msg *dns.Msg, key, secret string, timeSigned int64) []byte {
+		msg.SetTsig(key, dns.HmacSHA256, 300, timeSigned)
+		raw, _, err := dns.TsigGenerate(msg, secret, "", false)
+		assert.NoError(t, err)
+		return raw
+	}
+
+	// pack returns the raw message.
+	pack := func(msg *dns.Msg) []byte {
+		raw, err := msg.Pack()
+		assert.NoError(t, err)
+		return raw
+	}
+
+	tests := []struct {
+		name      string
+		keys      map[string]string
+		rawQuery  func() []byte
+		rcode     int
+		tsigError uint16
+		applied   bool
+	}{{
+		name:     "unsigned update without keys",
+		rawQuery: func() []byte { return pack(newUpdate("example.com.")) },
+		rcode:    dns.RcodeSuccess,
+		applied:  true,
+	}, {
+		name:     "unsigned update with keys",
+		keys:     map[string]string{"update-key.": secret},
+		rawQuery: func() []byte { return pack(newUpdate("example.com.")) },
+		rcode:    dns.RcodeRefused,
+	}, {
+		name: "signed update",
+		keys: map[string]string{"update-key.": secret},
+		rawQuery: func() []byte {
+			return sign(newUpdate("example.com."), "update-key.", secret, time.Now().Unix())
+		},
+		rcode:   dns.RcodeSuccess,
+		applied: true,
+	}, {
+		name: "unknown key",
+		keys: map[string]string{"update-key.": secret},
+		rawQuery: func() []byte {
+			return sign(newUpdate("example.com."), "other-key.", secret, time.Now().Unix())
+		},
+		rcode:     dns.RcodeNotAuth,
+		tsigError: dns.RcodeBadKey,
+	}, {
+		name: "invalid signature",
+		keys: map[string]string{"update-key.": secret},
+		rawQuery: func() []byte {
+			return sign(newUpdate("example.com."), "update-key.", "b3RoZXItc2VjcmV0", time.Now().Unix())
+		},
+		rcode:     dns.RcodeNotAuth,
+		tsigError: dns.RcodeBadSig,
+	}, {
+		name: "signature out of the time window",
+		keys: map[string]string{"update-key.": secret},
+		rawQuery: func() []byte {
+			return sign(newUpdate("example.com."), "update-key.", secret, time.Now().Add(-time.Hour).Unix())
+		},
+		rcode:     dns.RcodeNotAuth,
+		tsigError: dns.RcodeBadTime,
+	}, {
+		name:     "another zone",
+		rawQuery: func() []byte { return pack(newUpdate("example.org.")) },
+		rcode:    dns.RcodeNotAuth,
+	}, {
+		name: "not an update",
+		rawQuery: func() []byte {
+			msg, err := NewQuery("example.com", dns.TypeSOA)
+			assert.NoError(t, err)
+			return pack(msg)
+		},
+		rcode: dns.RcodeFormatError,
+	}, {
+		name: "update outside of the zone",
+		rawQuery: func() []byte {
+			msg := newUpdate("example.com.")
+			msg.Insert([]dns.RR{newRR("www.example.org. 300 IN A 192.0.2.1")})
+			return pack(msg)
+		},
+		rcode: dns.RcodeNotZone,
+	}, {
+		name: "prerequisites and deletions",
+		rawQuery: func() []byte {
+			msg := newUpdate("example.com.")
+			msg.NameUsed([]dns.RR{newRR("www.example.com. 0 IN A 0.0.0.0")})
+			msg.RRsetNotUsed([]dns.RR{newRR("www.example.com. 0 IN AAAA ::")})
+			msg.RemoveRRset([]dns.RR{newRR("www.example.com. 0 IN TXT \"\"")})
+			msg.Remove([]dns.RR{newRR("www.example.com. 300 IN A 192.0.2.2")})
+			msg.RemoveName([]dns.RR{newRR("old.example.com. 0 IN A 0.0.0.0")})
+			return pack(msg)
+		},
+		rcode:   dns.RcodeSuccess,
+		applied: true,
+	}, {
+		name: "prerequisite with a nonzero TTL",
+		rawQuery: func() []byte {
+			msg := newUpdate("example.com.")
+			msg.Answer = append(msg.Answer, newRR("www.example.com. 300 IN A 192.0.2.1"))
+			return pack(msg)
+		},
+		rcode: dns.RcodeFormatError,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var applied *Update
+			handler := &UpdateHandler{
+				Apply: func(update *Update) int {
+					applied = update
+					return dns.RcodeSuccess
+				},
+				Origin:   "EXAMPLE.com",
+				TSIGKeys: tt.keys,
+			}
+			rawResp, err := handler.HandleUpdate(tt.rawQuery())
+			assert.NoError(t, err)
+			resp := &dns.Msg{}
+			assert.NoError(t, resp.Unpack(rawResp))
+			assert.Equal(t, tt.rcode, resp.Rcode)
+			assert.Equal(t, tt.applied, applied != nil)
+			if tsig := resp.IsTsig(); tsig != nil {
+				assert.Equal(t, tt.tsigError, tsig.Error)
+			}
+			if applied != nil {
+				assert.Equal(t, "example.com.", applied.Zone)
+				assert.NotEmpty(t, applied.Updates)
+			}
+		})
+	}
+
+	t.Run("we sign the response", func(t *testing.T) {
+		handler := &UpdateHandler{
+			Apply:    func(update *Update) int { return dns.RcodeNXRrset },
+			Origin:   "example.com",
+			TSIGKeys: map[string]string{"update-key": secret},
+		}
+		rawQuery := sign(newUpdate("example.com."), "update-key.", secret, time.Now().Unix())
+		query := &dns.Msg{}
+		assert.NoError(t, query.Unpack(rawQuery))
+		rawResp, err := handler.HandleUpdate(rawQuery)
+		assert.NoError(t, err)
+		resp := &dns.Msg{}
+		assert.NoError(t, resp.Unpack(rawResp))
+		assert.Equal(t, dns.RcodeNXRrset, resp.Rcode)
+		assert.NoError(t, dns.TsigVerify(rawResp, secret, query.IsTsig().MAC, false))
+	})
+
+	t.Run("we pass the key name to Apply", func(t *testing.T) {
+		var keyName string
+		handler := &UpdateHandler{
+			Apply: func(update *Update) int {
+				keyName = update.KeyName
+				return dns.RcodeSuccess
+			},
+			Origin:   "example.com",
+			TSIGKeys: map[string]string{"Update-Key": secret},
+		}
+		_, err := handler.HandleUpdate(sign(newUpdate("example.com."), "update-key.", secret, time.Now().Unix()))
+		assert.NoError(t, err)
+		assert.Equal(t, "update-key.", keyName)
+	})
+
+	t.Run("invalid message", func(t *testing.T) {
+		handler := &UpdateHandler{Apply: func(update *Update) int { return dns.RcodeSuccess }}
+		_, err := handler.HandleUpdate([]byte{0x00})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultUpgradeRetryInterval is the default interval after which
+// [*UpgradeTransport] discovers again the encrypted endpoints of a
+// resolver after discovery failed or all the endpoints failed.
+const DefaultUpgradeRetryInterval = 10 * time.Minute
+
+// UpgradeEventKind is the kind of an [*UpgradeEvent].
+type UpgradeEventKind int
+
+const (
+	// UpgradeEventDiscovery indicates that we discovered the encrypted
+	// endpoints of a resolver or that discovery failed.
+	UpgradeEventDiscovery = UpgradeEventKind(iota)
+
+	// UpgradeEventUpgrade indicates that we received the first valid
+	// response from an encrypted endpoint, which verifies it.
+	UpgradeEventUpgrade
+
+	// UpgradeEventFallback indicates that an encrypted endpoint failed,
+	// hence we fall back to the next endpoint, if any, or otherwise to
+	// the unencrypted resolver.
+	UpgradeEventFallback
+)
+
+// String returns the string representation of the kind.
+func (k UpgradeEventKind) String() string {
+	switch k {
+	case UpgradeEventDiscovery:
+		return "discovery"
+	case UpgradeEventUpgrade:
+		return "upgrade"
+	case UpgradeEventFallback:
+		return "fallback"
+	default:
+		return "unknown"
+	}
+}
+
+// UpgradeEvent is an event emitted by [*UpgradeTransport] whenever
+// the way it reaches an unencrypted resolver changes.
+type UpgradeEvent struct {
+	// Kind is the kind of event.
+	Kind UpgradeEventKind
+
+	// Server is the unencrypted resolver.
+	Server *ServerAddr
+
+	// Endpoints contains the discovered endpoints, for [UpgradeEventDiscovery].
+	Endpoints []*ServerAddr
+
+	// Endpoint is the encrypted endpoint we upgraded to, for
+	// [UpgradeEventUpgrade], or that failed, for [UpgradeEventFallback].
+	Endpoint *ServerAddr
+
+	// Err is the discovery error, for [UpgradeEventDiscovery], or the
+	// error that caused falling back, for [UpgradeEventFallback].
+	Err error
+
+	// Time is when the event occurred.
+	Time time.Time
+}
+
+// UpgradeTransport is a [ResolverTransport] that transparently upgrades
+// the queries for unencrypted resolvers to their encrypted endpoints.
+//
+// For each unencrypted resolver, identified by a [ProtocolUDP] or
+// [ProtocolTCP] address containing an IP address, we discover the encrypted
+// endpoints using [DiscoverDesignatedResolvers] before sending the first
+// query. We then send the queries to the first endpoint and, when it fails,
+// we fall back to the next endpoint and eventually to the unencrypted
+// resolver, which we use until RetryInterval elapses, when we discover
+// the endpoints again. We forward the queries using other protocols as is.
+// When a query that is not idempotent, such as UPDATE, fails in a way that
+// is not retry safe (see [IsRetrySafe]), we still fall back for the next
+// queries, but we return the error rather than sending the query again.
+//
+// Construct using [NewUpgradeTransport].
+type UpgradeTransport struct {
+	// OnUpgradeEvent is the optional callback invoked on every transition.
+	OnUpgradeEvent func(ev *UpgradeEvent)
+
+	// RetryInterval is the optional interval after which we discover the
+	// endpoints again after discovery failed or all the endpoints failed.
+	// If zero, we use [DefaultUpgradeRetryInterval].
+	RetryInterval time.Duration
+
+	// TimeNow is an optional function that returns the current time.
+	// If this field is nil, the [time.Now] function will be used.
+	TimeNow func() time.Time
+
+	// Transport is the underlying transport.
+	Transport ResolverTransport
+
+	// mu provides mutual exclusion.
+	mu sync.Mutex
+
+	// states contains the state of each unencrypted resolver.
+	states map[string]*upgradeState
+}
+
+// upgradeState is the upgrade state of an unencrypted resolver.
+type upgradeState struct {
+	// mu provides mutual exclusion and serializes discovery.
+	mu sync.Mutex
+
+	// endpoints contains the endpoints we did not fall back from yet.
+	endpoints []*ServerAddr
+
+	// retryAt is when we should discover the endpoints again.
+	retryAt time.Time
+
+	// verified indicates whether the first endpoint sent a valid response.
+	verified bool
+}
+
+// Ensure that [*UpgradeTransport] implements [ResolverTransport].
+var _ ResolverTransport = (*UpgradeTransport)(nil)
+
+// NewUpgradeTransport creates a new [*UpgradeTransport] using the given transport.
+func NewUpgradeTransport(txp ResolverTransport) *UpgradeTransport {
+	return &UpgradeTransport{Transport: txp, states: make(map[string]*upgradeState)}
+}
+
+// timeNow returns the current time.
+func (t *UpgradeTransport) timeNow() time.Time {
+	if t.TimeNow != nil {
+		return t.TimeNow()
+	}
+	return time.Now()
+}
+
+// retryInterval returns the interval after which we discover again.
+func (t *UpgradeTransport) retryInterval() time.Duration {
+	if t.RetryInterval > 0 {
+		return t.RetryInterval
+	}
+	return DefaultUpgradeRetryInterval
+}
+
+// emit invokes the OnUpgradeEvent callback, if set.
+func (t *UpgradeTransport) emit(ev *UpgradeEvent) {
+	if t.OnUpgradeEvent != nil {
+		ev.Time = t.timeNow()
+		t.OnUpgradeEvent(ev)
+	}
+}
+
+// Query implements [ResolverTransport].
+func (t *UpgradeTransport) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	// 1. forward the queries for encrypted resolvers
+	if addr.Protocol != ProtocolUDP && addr.Protocol != ProtocolTCP {
+		return t.Transport.Query(ctx, addr, query)
+	}
+
+	// 2. try the encrypted endpoints in order
+	state := t.state(addr)
+	for {
+		endpoint := t.endpoint(ctx, state, addr)
+		if endpoint == nil {
+			break
+		}
+		resp, err := t.Transport.Query(ctx, endpoint, query)
+		if err == nil {
+			err = ValidateResponse(query, resp)
+		}
+		if err == nil {
+			t.verify(state, addr, endpoint)
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err // the caller gave up, so do not blame the endpoint
+		}
+		t.fallback(state, addr, endpoint, err)
+		if !canRetryQuery(query, err) {
+			return nil, err // the endpoint may have processed the query
+		}
+	}
+
+	// 3. fall back to the unencrypted resolver
+	return t.Transport.Query(ctx, addr, query)
+}
+
+// state returns the state of the given unencrypted resolver.
+func (t *UpgradeTransport) state(addr *ServerAddr) *upgradeState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := string(addr.Protocol) + " " + addr.Address
+	state, found := t.states[key]
+	if !found {
+		state = &upgradeState{}
+		t.states[key] = state
+	}
+	return state
+}
+
+// endpoint returns the endpoint to use, discovering the endpoints when
+// needed, or nil when we should use the unencrypted resolver.
+func (t *UpgradeTransport) endpoint(ctx context.Context, state *upgradeState, addr *ServerAddr) *ServerAddr {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.endpoints) <= 0 && !t.timeNow().Before(state.retryAt) {
+		endpoints, err := DiscoverDesignatedResolvers(ctx, t.Transport, addr)
+		t.emit(&UpgradeEvent{Kind: UpgradeEventDiscovery, Server: addr, Endpoints: endpoints, Err: err})
+		state.endpoints, state.verified = endpoints, false
+		state.retryAt = t.timeNow().Add(t.retryInterval())
+	}
+	if len(state.endpoints) <= 0 {
+		return nil
+	}
+	return state.endpoints[0]
+}
+
+// verify marks the given endpoint as verified.
+func (t *UpgradeTransport) verify(state *upgradeState, addr, endpoint *ServerAddr) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.endpoints) > 0 && state.endpoints[0] == endpoint && !state.verified {
+		state.verified = true
+		t.emit(&UpgradeEvent{Kind: UpgradeEventUpgrade, Server: addr, Endpoint: endpoint})
+	}
+}
+
+// fallback stops using the given failed endpoint.
+func (t *UpgradeTransport) fallback(state *upgradeState, addr, endpoint *ServerAddr, err error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if len(state.endpoints) <= 0 || state.endpoints[0] != endpoint {
+		return // another query already fell back
+	}
+	state.endpoints, state.verified = state.endpoints[1:], false
+	t.emit(&UpgradeEvent{Kind: UpgradeEventFallback, Server: addr, Endpoint: endpoint, Err: err})
+	if len(state.endpoints) <= 0 {
+		state.retryAt = t.timeNow().Add(t.retryInterval())
+	}
+}
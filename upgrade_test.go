@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgradeEventKind_String(t *testing.T) {
+	tests := []struct {
+		kind     UpgradeEventKind
+		expected string
+	}{
+		{UpgradeEventDiscovery, "discovery"},
+		{UpgradeEventUpgrade, "upgrade"},
+		{UpgradeEventFallback, "fallback"},
+		{UpgradeEventKind(42), "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.kind.String())
+		})
+	}
+}
+
+func TestUpgradeTransport(t *testing.T) {
+	// 1. create a transport where the resolver designates DoT and DoH
+	// endpoints and where we control which protocols fail
+	expectedErr := errors.New("mocked error")
+	failing := map[Protocol]bool{}
+	var used []string
+	ddr := answerFromRecords(t,
+		`_dns.resolver.arpa. 300 IN SVCB 1 dns.example. alpn="dot"`,
+		`_dns.resolver.arpa. 300 IN SVCB 2 dns.example. alpn="h2" dohpath="/dns-query{?dns}"`,
+	)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	txp := NewUpgradeTransport(&MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			if query.Question[0].Name == DDRName {
+				if failing[addr.Protocol] {
+					return nil, expectedErr
+				}
+				return ddr.Query(ctx, addr, query)
+			}
+			used = append(used, string(addr.Protocol))
+			if failing[addr.Protocol] {
+				return nil, expectedErr
+			}
+			resp := new(dns.Msg)
+			resp.SetReply(query)
+			return resp, nil
+		},
+	})
+	txp.TimeNow = func() time.Time { return now }
+	var events []string
+	txp.OnUpgradeEvent = func(ev *UpgradeEvent) {
+		assert.Equal(t, "192.0.2.1:53", ev.Server.Address)
+		assert.Equal(t, now, ev.Time)
+		switch ev.Kind {
+		case UpgradeEventDiscovery:
+			events = append(events, ev.Kind.String())
+			assert.Equal(t, ev.Err == nil, len(ev.Endpoints) == 2)
+		default:
+			events = append(events, ev.Kind.String()+" "+string(ev.Endpoint.Protocol))
+		}
+	}
+
+	// query sends a query for the unencrypted resolver
+	query := func(addr *ServerAddr) error {
+		query, _ := NewQuery("example.com", dns.TypeA)
+		_, err := txp.Query(context.Background(), addr, query)
+		return err
+	}
+	addr := NewServerAddr(ProtocolUDP, "192.0.2.1:53")
+
+	// 2. the first queries use the verified DoT endpoint
+	assert.NoError(t, query(addr))
+	assert.NoError(t, query(addr))
+	assert.Equal(t, []string{"dot", "dot"}, used)
+	assert.Equal(t, []string{"discovery", "upgrade dot"}, events)
+
+	// 3. when DoT fails, we transparently fall back to DoH
+	used, events = nil, nil
+	failing[ProtocolDoT] = true
+	assert.NoError(t, query(addr))
+	assert.Equal(t, []string{"dot", "doh"}, used)
+	assert.Equal(t, []string{"fallback dot", "upgrade doh"}, events)
+
+	// 4. when DoH fails, we fall back to the unencrypted resolver
+	// and do not try the endpoints until the retry interval elapses
+	used, events = nil, nil
+	failing[ProtocolDoH] = true
+	assert.NoError(t, query(addr))
+	now = now.Add(DefaultUpgradeRetryInterval - time.Second)
+	assert.NoError(t, query(addr))
+	assert.Equal(t, []string{"doh", "udp", "udp"}, used)
+	assert.Equal(t, []string{"fallback doh"}, events)
+
+	// 5. once the retry interval elapses, we discover again
+	used, events = nil, nil
+	now = now.Add(time.Second)
+	failing[ProtocolDoT] = false
+	assert.NoError(t, query(addr))
+	assert.Equal(t, []string{"dot"}, used)
+	assert.Equal(t, []string{"discovery", "upgrade dot"}, events)
+
+	// 6. failing discovery results in using the unencrypted resolver
+	used, events = nil, nil
+	failing[ProtocolTCP] = true
+	tcpAddr := NewServerAddr(ProtocolTCP, "192.0.2.1:53")
+	assert.ErrorIs(t, query(tcpAddr), expectedErr)
+	assert.Equal(t, []string{"tcp"}, used)
+	assert.Equal(t, []string{"discovery"}, events)
+
+	// 7. we forward the queries for the other protocols as is
+	used, events = nil, nil
+	assert.ErrorIs(t, query(NewServerAddr(ProtocolDoH, "https://192.0.2.1/dns-query")), expectedErr)
+	assert.Equal(t, []string{"doh"}, used)
+	assert.Empty(t, events)
+}
+
+func TestUpgradeTransport_canceledContext(t *testing.T) {
+	var queries int
+	ddr := answerFromRecords(t, `_dns.resolver.arpa. 300 IN SVCB 1 dns.example. alpn="dot"`)
+	ctx, cancel := context.WithCancel(context.Background())
+	txp := NewUpgradeTransport(&MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			if query.Question[0].Name == DDRName {
+				return ddr.Query(ctx, addr, query)
+			}
+			queries++
+			cancel()
+			return nil, ctx.Err()
+		},
+	})
+	var fallbacks int
+	txp.OnUpgradeEvent = func(ev *UpgradeEvent) {
+		if ev.Kind == UpgradeEventFallback {
+			fallbacks++
+		}
+	}
+
+	query, _ := NewQuery("example.com", dns.TypeA)
+	_, err := txp.Query(ctx, NewServerAddr(ProtocolUDP, "192.0.2.1:53"), query)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, queries)
+	assert.Equal(t, 0, fallbacks)
+}
+
+func TestUpgradeTransport_nonIdempotentQuery(t *testing.T) {
+	expectedErr := errors.New("mocked error")
+	var used []string
+	ddr := answerFromRecords(t,
+		`_dns.resolver.arpa. 300 IN SVCB 1 dns.example. alpn="dot"`,
+		`_dns.resolver.arpa. 300 IN SVCB 2 dns.example. alpn="h2" dohpath="/dns-query{?dns}"`,
+	)
+	txp := NewUpgradeTransport(&MockResolverTransport{
+		MockQuery: func(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+			if len(query.Question) > 0 && query.Question[0].Name == DDRName {
+				return ddr.Query(ctx, addr, query)
+			}
+			used = append(used, string(addr.Protocol))
+			return nil, expectedErr
+		},
+	})
+	var fallbacks int
+	txp.OnUpgradeEvent = func(ev *UpgradeEvent) {
+		if ev.Kind == UpgradeEventFallback {
+			fallbacks++
+		}
+	}
+
+	update := &dns.Msg{}
+	update.SetUpdate("example.com.")
+	_, err := txp.Query(context.Background(), NewServerAddr(ProtocolUDP, "192.0.2.1:53"), update)
+	assert.ErrorIs(t, err, expectedErr)
+	assert.Equal(t, []string{"dot"}, used)
+	assert.Equal(t, 1, fallbacks)
+}
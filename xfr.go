@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ErrZoneTransfer indicates that a zone transfer failed.
+var ErrZoneTransfer = errors.New("zone transfer failed")
+
+// TransferZone transfers the zone with the given origin from the given server
+// using AXFR (RFC 5936) and returns the transferred zone. The server protocol
+// must be [ProtocolTCP] or [ProtocolDoT], the latter implementing zone
+// transfers over TLS (RFC 9103). We do not implement IXFR, since [*Zone] does
+// not track the changes between serials, hence we always transfer the whole
+// zone. Use a context with a deadline, since large transfers take time.
+func (t *Transport) TransferZone(ctx context.Context, addr *ServerAddr, origin string) (*Zone, error) {
+	// 1. connect to the server and make sure we react to the context
+	conn, err := t.dialStream(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		closeConn(conn, ConnCloseError, ctx.Err())
+	})
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// 2. perform the transfer
+	zone, err := t.transferZone(ctx, addr, origin, conn)
+	if err != nil {
+		closeConn(conn, ConnCloseError, err)
+		return nil, err
+	}
+	closeConn(conn, ConnCloseDone, nil)
+	return zone, nil
+}
+
+// transferZone implements [*Transport.TransferZone] using the given conn.
+func (t *Transport) transferZone(ctx context.Context,
+	addr *ServerAddr, origin string, conn net.Conn) (*Zone, error) {
+	// 1. send the AXFR query and read the first response
+	query := &dns.Msg{}
+	query.SetAxfr(dns.CanonicalName(origin))
+	br := bufio.NewReader(conn)
+	t0, rawQuery, rawResp, err := t.exchangeStreamRaw(ctx, addr, query, conn, br)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. process the responses until we see the closing SOA, which
+	// has the same serial of the SOA opening the transfer
+	var (
+		zone    *Zone
+		opening *dns.SOA
+	)
+	for first := true; ; first = false {
+		// 2.1. parse and validate the response; only the first
+		// response is required to contain the question
+		resp := &dns.Msg{}
+		if err := resp.Unpack(rawResp); err != nil {
+			return nil, err
+		}
+		t.stats.onResponse(addr, len(rawResp), resp.Rcode)
+		t.maybeLogResponseConn(ctx, addr, t0, rawQuery, rawResp, conn)
+		switch {
+		case first:
+			if err := ValidateResponse(query, resp); err != nil {
+				return nil, err
+			}
+		case !resp.Response || resp.Id != query.Id:
+			return nil, ErrInvalidResponse
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("%w: %s", ErrZoneTransfer, dns.RcodeToString[resp.Rcode])
+		}
+
+		// 2.2. add the records to the zone
+		for _, rr := range resp.Answer {
+			soa, isSOA := rr.(*dns.SOA)
+			switch {
+			case opening == nil && !isSOA:
+				return nil, fmt.Errorf("%w: transfer does not start with the SOA", ErrZoneTransfer)
+			case opening == nil:
+				opening, zone = soa, NewZone(origin)
+			case isSOA && soa.Serial == opening.Serial:
+				return zone, nil
+			}
+			if err := zone.Add(rr); err != nil {
+				return nil, err
+			}
+		}
+
+		// 2.3. read the next response
+		if rawResp, err = ReadMsgFrame(br); err != nil {
+			return nil, err
+		}
+		t.maybeCaptureReceived(addr, conn, rawResp)
+	}
+}
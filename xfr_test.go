@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeXfrTestMsg writes the given message to the given conn.
+func writeXfrTestMsg(conn net.Conn, msg *dns.Msg) {
+	rawMsg, _ := msg.Pack()
+	frame, _ := newRawMsgFrame(&ServerAddr{}, rawMsg)
+	conn.Write(frame)
+}
+
+// serveXfrTest returns a function answering the SOA and AXFR queries using
+// the records returned by the given function, the first of which is the SOA.
+// We send a message per record and the closing SOA, unless the mutate function,
+// which may be nil, returns false after mutating the message to send.
+func serveXfrTest(records func() []string, mutate func(idx int, msg *dns.Msg) bool) func(conn net.Conn, br *bufio.Reader) {
+	return func(conn net.Conn, br *bufio.Reader) {
+		rawQuery, err := ReadMsgFrame(br)
+		if err != nil {
+			return
+		}
+		query := &dns.Msg{}
+		if err := query.Unpack(rawQuery); err != nil {
+			return
+		}
+		var rrs []dns.RR
+		for _, text := range records() {
+			rr, _ := dns.NewRR(text)
+			rrs = append(rrs, rr)
+		}
+		if query.Question[0].Qtype == dns.TypeSOA {
+			resp := &dns.Msg{}
+			resp.SetReply(query)
+			resp.Answer = rrs[:1]
+			writeXfrTestMsg(conn, resp)
+			return
+		}
+		for idx, rr := range append(rrs, rrs[0]) {
+			resp := &dns.Msg{}
+			resp.SetReply(query)
+			if idx > 0 {
+				resp.Question = nil
+			}
+			resp.Answer = []dns.RR{rr}
+			if mutate != nil && !mutate(idx, resp) {
+				return
+			}
+			writeXfrTestMsg(conn, resp)
+		}
+	}
+}
+
+// xfrTestRecords contains the records used by the zone transfer tests.
+var xfrTestRecords = []string{
+	"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300",
+	"example.com. 3600 IN NS ns1.example.com.",
+	"ns1.example.com. 3600 IN A 192.0.2.1",
+	"www.example.com. 3600 IN A 192.0.2.10",
+}
+
+// xfrTestRecordsWithSerial returns the [xfrTestRecords] using the given serial.
+func xfrTestRecordsWithSerial(serial uint32) []string {
+	records := slices.Clone(xfrTestRecords)
+	records[0] = fmt.Sprintf("example.com. 3600 IN SOA ns1 hostmaster %d 3600 600 86400 300", serial)
+	return records
+}
+
+func TestTransport_TransferZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []string
+		mutate   func(idx int, msg *dns.Msg) bool
+		expected error
+	}{{
+		name:    "success",
+		records: xfrTestRecords,
+	}, {
+		name:    "refused",
+		records: xfrTestRecords,
+		mutate: func(idx int, msg *dns.Msg) bool {
+			msg.Rcode = dns.RcodeRefused
+			return true
+		},
+		expected: ErrZoneTransfer,
+	}, {
+		name:     "missing opening SOA",
+		records:  xfrTestRecords[1:],
+		expected: ErrZoneTransfer,
+	}, {
+		name:     "records outside of the zone",
+		records:  append([]string{xfrTestRecords[0], "www.example.org. 60 IN A 192.0.2.10"}, xfrTestRecords[1:]...),
+		expected: ErrNotInZone,
+	}, {
+		name:    "mismatching question",
+		records: xfrTestRecords,
+		mutate: func(idx int, msg *dns.Msg) bool {
+			if idx == 0 {
+				msg.Question[0].Name = "example.org."
+			}
+			return true
+		},
+		expected: ErrInvalidResponse,
+	}, {
+		name:    "mismatching ID",
+		records: xfrTestRecords,
+		mutate: func(idx int, msg *dns.Msg) bool {
+			if idx > 0 {
+				msg.Id++
+			}
+			return true
+		},
+		expected: ErrInvalidResponse,
+	}, {
+		name:    "truncated transfer",
+		records: xfrTestRecords,
+		mutate: func(idx int, msg *dns.Msg) bool {
+			return idx < 2
+		},
+		expected: io.EOF,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := func() []string { return tt.records }
+			addr, _ := startStreamServer(t, serveXfrTest(records, tt.mutate))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			txp := &Transport{}
+			zone, err := txp.TransferZone(ctx, addr, "example.com")
+			if tt.expected != nil {
+				assert.ErrorIs(t, err, tt.expected)
+				assert.Nil(t, zone)
+			} else {
+				assert.NoError(t, err)
+				query := &dns.Msg{}
+				query.SetQuestion("www.example.com.", dns.TypeA)
+				assert.Len(t, zone.Answer(query).Answer, 1)
+				assert.Equal(t, int64(len(tt.records)+1), txp.Stats()[0].Responses)
+			}
+		})
+	}
+
+	t.Run("unsupported protocols", func(t *testing.T) {
+		addr := NewServerAddr(ProtocolUDP, "127.0.0.1:53")
+		_, err := (&Transport{}).TransferZone(context.Background(), addr, "example.com")
+		assert.ErrorIs(t, err, ErrNoSuchTransportProtocol)
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		addr, _ := startStreamServer(t, func(conn net.Conn, br *bufio.Reader) {
+			ReadMsgFrame(br)
+			time.Sleep(time.Second)
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := (&Transport{}).TransferZone(ctx, addr, "example.com")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,460 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// ErrInvalidZone indicates that a [*Zone] is malformed, e.g.,
+	// because it lacks the SOA or contains conflicting records.
+	ErrInvalidZone = errors.New("invalid zone")
+
+	// ErrNotInZone indicates that a record is outside of a [*Zone].
+	ErrNotInZone = errors.New("record not in zone")
+)
+
+// zoneMaxCNAMEChain is the maximum number of CNAMEs we follow within
+// a [*Zone] when answering a query, to avoid looping forever.
+const zoneMaxCNAMEChain = 8
+
+// Zone is an in-memory authoritative zone, which answers queries using the
+// algorithm of RFC 1034 Sect. 4.3.2, including wildcards, CNAMEs, and
+// referrals to delegated child zones. Zones are useful to build test
+// authoritative servers and lab setups: since [*Zone] implements
+// [ResolverTransport], a [*Resolver] can also directly use a zone.
+//
+// Construct using [NewZone], [ParseZone], or [ParseZoneFile].
+type Zone struct {
+	// origin is the canonical zone apex.
+	origin string
+
+	// nodes maps each canonical name to the RRs it owns, grouped by type,
+	// and contains an empty entry for each empty non-terminal name.
+	nodes map[string]map[uint16][]dns.RR
+
+	// nsecs contains the NSEC chain in canonical order, which
+	// is empty unless we signed the zone using [*Zone.Sign].
+	nsecs []*dns.NSEC
+
+	// mu protects nodes and nsecs.
+	mu sync.RWMutex
+}
+
+// Ensure that [*Zone] implements [ResolverTransport].
+var _ ResolverTransport = (*Zone)(nil)
+
+// NewZone creates an empty [*Zone] with the given origin.
+func NewZone(origin string) *Zone {
+	return &Zone{
+		origin: dns.CanonicalName(origin),
+		nodes:  make(map[string]map[uint16][]dns.RR),
+	}
+}
+
+// ParseZone parses an RFC 1035 master file into a new [*Zone] using the given
+// origin and the given file name, which is only used in error messages. We do
+// not allow $INCLUDE directives, since they would read arbitrary files. The
+// zone must contain the SOA at the origin.
+func ParseZone(r io.Reader, origin, filename string) (*Zone, error) {
+	return parseZone(r, origin, filename, false)
+}
+
+// ParseZoneFile is like [ParseZone] but reads the given file and
+// allows $INCLUDE directives, which are relative to the file.
+func ParseZoneFile(path, origin string) (*Zone, error) {
+	filep, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer filep.Close()
+	return parseZone(filep, origin, path, true)
+}
+
+// parseZone implements [ParseZone] and [ParseZoneFile].
+func parseZone(r io.Reader, origin, filename string, includes bool) (*Zone, error) {
+	zone := NewZone(origin)
+	zp := dns.NewZoneParser(r, zone.origin, filename)
+	zp.SetIncludeAllowed(includes)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if err := zone.Add(rr); err != nil {
+			return nil, err
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if zone.SOA() == nil {
+		return nil, fmt.Errorf("%w: missing SOA", ErrInvalidZone)
+	}
+	return zone, nil
+}
+
+// Origin returns the canonical zone apex.
+func (z *Zone) Origin() string {
+	return z.origin
+}
+
+// SOA returns a copy of the SOA record at the apex or nil.
+func (z *Zone) SOA() *dns.SOA {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	if soa := z.nodes[z.origin][dns.TypeSOA]; len(soa) > 0 {
+		return dns.Copy(soa[0]).(*dns.SOA)
+	}
+	return nil
+}
+
+// Add adds a copy of the given record to the zone, ignoring duplicates.
+// We reject records outside of the zone, a SOA not at the apex or
+// replacing an existing SOA, and CNAMEs coexisting with other data.
+func (z *Zone) Add(rr dns.RR) error {
+	// 1. make sure the record belongs to the zone
+	rr = dns.Copy(rr)
+	hdr := rr.Header()
+	hdr.Name = dns.CanonicalName(hdr.Name)
+	if !dns.IsSubDomain(z.origin, hdr.Name) {
+		return fmt.Errorf("%w: %s", ErrNotInZone, hdr.Name)
+	}
+	if hdr.Rrtype == dns.TypeSOA && hdr.Name != z.origin {
+		return fmt.Errorf("%w: SOA not at the apex: %s", ErrInvalidZone, hdr.Name)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.addLocked(rr)
+}
+
+// addLocked adds the given canonicalized record to the zone, if it
+// does not conflict with the existing records.
+func (z *Zone) addLocked(rr dns.RR) error {
+	// 1. make sure the record does not conflict with existing records
+	hdr := rr.Header()
+	rrsets := z.nodes[hdr.Name]
+	for _, existing := range rrsets[hdr.Rrtype] {
+		if dns.IsDuplicate(existing, rr) {
+			return nil
+		}
+	}
+	switch {
+	case hdr.Rrtype == dns.TypeSOA && len(rrsets[dns.TypeSOA]) > 0:
+		return fmt.Errorf("%w: multiple SOAs", ErrInvalidZone)
+	case hdr.Rrtype == dns.TypeCNAME && len(rrsets[dns.TypeCNAME]) > 0:
+		return fmt.Errorf("%w: multiple CNAMEs: %s", ErrInvalidZone, hdr.Name)
+	case hdr.Rrtype == dns.TypeDNAME && len(rrsets[dns.TypeDNAME]) > 0:
+		return fmt.Errorf("%w: multiple DNAMEs: %s", ErrInvalidZone, hdr.Name)
+	case zoneHasCNAMEConflict(rrsets, hdr.Rrtype):
+		return fmt.Errorf("%w: CNAME and other data: %s", ErrInvalidZone, hdr.Name)
+	}
+
+	// 2. add the record and the empty non-terminals above it
+	if rrsets == nil {
+		rrsets = make(map[uint16][]dns.RR)
+		z.nodes[hdr.Name] = rrsets
+	}
+	rrsets[hdr.Rrtype] = append(rrsets[hdr.Rrtype], rr)
+	for name := hdr.Name; name != z.origin; {
+		name = zoneParentName(name)
+		if _, found := z.nodes[name]; found {
+			break
+		}
+		z.nodes[name] = make(map[uint16][]dns.RR)
+	}
+	return nil
+}
+
+// zoneHasCNAMEConflict returns whether adding a record with the given type
+// to the given RRsets would make a CNAME coexist with other data, which
+// RFC 1034 forbids except for the DNSSEC records.
+func zoneHasCNAMEConflict(rrsets map[uint16][]dns.RR, rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeRRSIG, dns.TypeNSEC:
+		return false
+	case dns.TypeCNAME:
+		for other := range rrsets {
+			if other != dns.TypeRRSIG && other != dns.TypeNSEC {
+				return true
+			}
+		}
+		return false
+	default:
+		return len(rrsets[dns.TypeCNAME]) > 0
+	}
+}
+
+// zoneParentName returns the parent of the given canonical name.
+func zoneParentName(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
+
+// Query implements [ResolverTransport] by answering the query using
+// [*Zone.Answer], regardless of the server address.
+func (z *Zone) Query(ctx context.Context, addr *ServerAddr, query *dns.Msg) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return z.Answer(query), nil
+}
+
+// Answer returns the authoritative response to the given query. Queries
+// for names outside of the zone or classes other than IN get REFUSED,
+// while queries below a delegation get a referral. Queries below a DNAME
+// get the DNAME along with the synthesized CNAME (RFC 6672), thus the
+// delegations and DNAMEs occlude the records below them. Wildcards match
+// the names below their closest encloser (RFC 4592), which may be an empty
+// non-terminal. We follow the CNAMEs pointing to names in the zone. We do not add DNSSEC records unless
+// they are explicitly queried or the query sets the DO bit and the zone
+// is signed (see [*Zone.Sign]). The returned RRs are copies.
+func (z *Zone) Answer(query *dns.Msg) *dns.Msg {
+	// 1. make sure we can answer the query
+	resp := &dns.Msg{}
+	resp.SetReply(query)
+	if len(query.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+		return resp
+	}
+	q0 := query.Question[0]
+	name := dns.CanonicalName(q0.Name)
+	if q0.Qclass != dns.ClassINET || !dns.IsSubDomain(z.origin, name) {
+		resp.Rcode = dns.RcodeRefused
+		return resp
+	}
+	resp.Authoritative = true
+	opt := query.IsEdns0()
+	dnssec := opt != nil && opt.Do()
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	// 2. answer following the CNAMEs within the zone
+	owner := q0.Name
+	for chain := 0; chain <= zoneMaxCNAMEChain; chain++ {
+		target, found := z.answerLocked(resp, owner, name, q0.Qtype, dnssec)
+		if !found || !dns.IsSubDomain(z.origin, target) {
+			break
+		}
+		owner, name = target, target
+	}
+
+	// 3. echo the EDNS version and the DO bit
+	if opt != nil {
+		resp.SetEdns0(opt.UDPSize(), dnssec)
+	}
+	return resp
+}
+
+// answerLocked adds to the response the records answering the query for
+// the given canonical name, using owner as the owner name of synthesized
+// records, and returns the CNAME target to follow, if any. When dnssec
+// is true, we also add the RRSIGs and the NSEC proofs.
+func (z *Zone) answerLocked(resp *dns.Msg, owner, name string, qtype uint16, dnssec bool) (string, bool) {
+	// 1. refer to the child zone when the name is at or below a delegation,
+	// except for DS queries at the delegation, which the parent answers, and
+	// synthesize a CNAME when the name is below a DNAME
+	switch cut, rrtype := z.cutLocked(name, qtype); rrtype {
+	case dns.TypeNS:
+		z.referLocked(resp, cut, dnssec)
+		return "", false
+	case dns.TypeDNAME:
+		return z.synthesizeLocked(resp, owner, name, cut, qtype, dnssec)
+	}
+
+	// 2. use the matching node or, if it does not exist, the wildcard
+	// at the closest encloser, if any, otherwise the name does not exist
+	var wildcard bool
+	rrsets, found := z.nodes[name]
+	if !found {
+		encloser := zoneParentName(name)
+		for _, exists := z.nodes[encloser]; !exists && encloser != z.origin; _, exists = z.nodes[encloser] {
+			encloser = zoneParentName(encloser)
+		}
+		rrsets, found = z.nodes["*."+encloser]
+		if !found {
+			resp.Rcode = dns.RcodeNameError
+			z.addNegativeSOALocked(resp, dnssec)
+			if dnssec {
+				resp.Ns = z.appendNSECLocked(resp.Ns, z.coveringNSECLocked(name))
+				resp.Ns = z.appendNSECLocked(resp.Ns, z.coveringNSECLocked("*."+encloser))
+			}
+			return "", false
+		}
+		wildcard = true
+	} else {
+		owner = ""
+	}
+
+	// 3. answer with the matching RRs or the CNAME, if any, otherwise
+	// with the SOA, since the name exists but has no matching RRs
+	var answer []dns.RR
+	switch {
+	case qtype == dns.TypeANY:
+		for _, rrtype := range slices.Sorted(maps.Keys(rrsets)) {
+			answer = append(answer, rrsets[rrtype]...)
+		}
+	case len(rrsets[qtype]) > 0:
+		answer = rrsets[qtype]
+	case len(rrsets[dns.TypeCNAME]) > 0:
+		answer = rrsets[dns.TypeCNAME]
+	}
+	if len(answer) <= 0 {
+		z.addNegativeSOALocked(resp, dnssec)
+		if dnssec {
+			// the NSEC at the node proves that the type does not exist, while
+			// empty non-terminals have no NSEC, so we use the covering one
+			nsec := z.coveringNSECLocked(name)
+			if len(rrsets[dns.TypeNSEC]) > 0 {
+				nsec = rrsets[dns.TypeNSEC][0].(*dns.NSEC)
+			}
+			resp.Ns = z.appendNSECLocked(resp.Ns, nsec)
+		}
+	} else {
+		for _, rr := range answer {
+			rr = dns.Copy(rr)
+			if owner != "" {
+				rr.Header().Name = owner
+			}
+			resp.Answer = append(resp.Answer, rr)
+		}
+		if dnssec && qtype != dns.TypeANY {
+			resp.Answer = appendZoneRRSIGs(resp.Answer, rrsets, answer[0].Header().Rrtype, owner)
+		}
+	}
+
+	// 4. prove that the wildcard expansion was legit since there is no
+	// closer match, as documented by RFC 4035 Sect. 3.1.3.3
+	if dnssec && wildcard {
+		resp.Ns = z.appendNSECLocked(resp.Ns, z.coveringNSECLocked(name))
+	}
+	if len(answer) > 0 {
+		if cname, ok := answer[0].(*dns.CNAME); ok && qtype != dns.TypeCNAME && qtype != dns.TypeANY {
+			return dns.CanonicalName(cname.Target), true
+		}
+	}
+	return "", false
+}
+
+// cutLocked returns the topmost name occluding the given name, along with
+// the type of the records causing the occlusion, or an empty string and zero
+// if there is none. Delegation points, excluding the apex, occlude the names
+// at and below them, except for DS queries at the delegation point, while
+// DNAME owners, including the apex, occlude the names below them.
+func (z *Zone) cutLocked(name string, qtype uint16) (string, uint16) {
+	names := []string{z.origin}
+	for ; name != z.origin; name = zoneParentName(name) {
+		names = append(names, name)
+	}
+	slices.Reverse(names[1:])
+	for idx, cut := range names {
+		rrsets, last := z.nodes[cut], idx == len(names)-1
+		switch {
+		case cut != z.origin && len(rrsets[dns.TypeNS]) > 0:
+			if last && qtype == dns.TypeDS {
+				return "", 0
+			}
+			return cut, dns.TypeNS
+		case !last && len(rrsets[dns.TypeDNAME]) > 0:
+			return cut, dns.TypeDNAME
+		}
+	}
+	return "", 0
+}
+
+// synthesizeLocked adds to the response the DNAME owned by the given cut
+// along with the CNAME synthesized for the given canonical name, using
+// owner as its owner name, as documented by RFC 6672 Sect. 3.2, and returns
+// the CNAME target to follow, if any. When the target would be longer than
+// the maximum name length, we respond with YXDOMAIN.
+func (z *Zone) synthesizeLocked(resp *dns.Msg, owner, name, cut string, qtype uint16, dnssec bool) (string, bool) {
+	// 1. add the DNAME
+	rrsets := z.nodes[cut]
+	dname := rrsets[dns.TypeDNAME][0].(*dns.DNAME)
+	resp.Answer = append(resp.Answer, dns.Copy(dname))
+	if dnssec {
+		resp.Answer = appendZoneRRSIGs(resp.Answer, rrsets, dns.TypeDNAME, "")
+	}
+
+	// 2. replace the DNAME owner with the DNAME target
+	target := name[:len(name)-len(cut)]
+	if suffix := dns.CanonicalName(dname.Target); suffix != "." {
+		target += suffix
+	}
+	if _, ok := dns.IsDomainName(target); !ok {
+		resp.Rcode = dns.RcodeYXDomain
+		return "", false
+	}
+
+	// 3. add the unsigned synthesized CNAME, which has the DNAME TTL
+	resp.Answer = append(resp.Answer, &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   owner,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    dname.Hdr.Ttl,
+		},
+		Target: target,
+	})
+	return target, qtype != dns.TypeCNAME && qtype != dns.TypeANY
+}
+
+// referLocked adds to the response the referral to the given delegation,
+// including the glue records of the name servers within the zone. When
+// dnssec is true, we also add the signed DS RRset or, if the child zone
+// is not signed, the NSEC proving that the DS RRset does not exist.
+func (z *Zone) referLocked(resp *dns.Msg, cut string, dnssec bool) {
+	if len(resp.Answer) <= 0 {
+		resp.Authoritative = false
+	}
+	rrsets := z.nodes[cut]
+	for _, rr := range rrsets[dns.TypeNS] {
+		resp.Ns = append(resp.Ns, dns.Copy(rr))
+		target := dns.CanonicalName(rr.(*dns.NS).Ns)
+		for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			for _, glue := range z.nodes[target][rrtype] {
+				resp.Extra = append(resp.Extra, dns.Copy(glue))
+			}
+		}
+	}
+	switch {
+	case !dnssec:
+	case len(rrsets[dns.TypeDS]) > 0:
+		for _, rr := range rrsets[dns.TypeDS] {
+			resp.Ns = append(resp.Ns, dns.Copy(rr))
+		}
+		resp.Ns = appendZoneRRSIGs(resp.Ns, rrsets, dns.TypeDS, "")
+	case len(rrsets[dns.TypeNSEC]) > 0:
+		resp.Ns = z.appendNSECLocked(resp.Ns, rrsets[dns.TypeNSEC][0].(*dns.NSEC))
+	}
+}
+
+// addNegativeSOALocked adds the SOA to the authority section of a negative
+// response, using the TTL for negative caching defined by RFC 2308, along
+// with the covering RRSIGs when dnssec is true.
+func (z *Zone) addNegativeSOALocked(resp *dns.Msg, dnssec bool) {
+	rrsets := z.nodes[z.origin]
+	for _, rr := range rrsets[dns.TypeSOA] {
+		soa := dns.Copy(rr).(*dns.SOA)
+		soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+		resp.Ns = append(resp.Ns, soa)
+		if dnssec {
+			count := len(resp.Ns)
+			resp.Ns = appendZoneRRSIGs(resp.Ns, rrsets, dns.TypeSOA, "")
+			for _, sig := range resp.Ns[count:] {
+				sig.Header().Ttl = soa.Hdr.Ttl
+			}
+		}
+	}
+}
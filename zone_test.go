@@ -0,0 +1,427 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/stretchr/testify/assert"
+)
+
+// zoneTestData is the master file used by the [*Zone] tests.
+const zoneTestData = `$ORIGIN example.com.
+$TTL 3600
+@        IN SOA   ns1 hostmaster 1 7200 3600 1209600 300
+@        IN NS    ns1
+ns1      IN A     192.0.2.1
+www      IN A     192.0.2.10
+www      IN AAAA  2001:db8::10
+alias    IN CNAME www
+external IN CNAME www.example.org.
+loop1    IN CNAME loop2
+loop2    IN CNAME loop1
+*.wild   IN A     192.0.2.20
+*.cwild  IN CNAME www
+a.b.c    IN A     192.0.2.30
+sub      IN NS    ns.sub
+sub      IN DS    12345 13 2 0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef
+ns.sub   IN A     192.0.2.53
+`
+
+// zoneTestRRs returns the string representation of the given RRs.
+func zoneTestRRs(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		out = append(out, strings.ReplaceAll(rr.String(), "\t", " "))
+	}
+	return out
+}
+
+func TestZone_Answer(t *testing.T) {
+	soa := "example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"
+	tests := []struct {
+		name          string
+		qname         string
+		qtype         uint16
+		rcode         int
+		authoritative bool
+		answer        []string
+		ns            []string
+		extra         []string
+	}{{
+		name:          "exact match",
+		qname:         "WWW.Example.COM.",
+		qtype:         dns.TypeAAAA,
+		authoritative: true,
+		answer:        []string{"www.example.com. 3600 IN AAAA 2001:db8::10"},
+	}, {
+		name:          "all types",
+		qname:         "www.example.com.",
+		qtype:         dns.TypeANY,
+		authoritative: true,
+		answer:        []string{"www.example.com. 3600 IN A 192.0.2.10", "www.example.com. 3600 IN AAAA 2001:db8::10"},
+	}, {
+		name:          "no data",
+		qname:         "www.example.com.",
+		qtype:         dns.TypeMX,
+		authoritative: true,
+		ns:            []string{soa},
+	}, {
+		name:          "no such name",
+		qname:         "missing.example.com.",
+		qtype:         dns.TypeA,
+		rcode:         dns.RcodeNameError,
+		authoritative: true,
+		ns:            []string{soa},
+	}, {
+		name:          "CNAME within the zone",
+		qname:         "alias.example.com.",
+		qtype:         dns.TypeA,
+		authoritative: true,
+		answer:        []string{"alias.example.com. 3600 IN CNAME www.example.com.", "www.example.com. 3600 IN A 192.0.2.10"},
+	}, {
+		name:          "CNAME query",
+		qname:         "alias.example.com.",
+		qtype:         dns.TypeCNAME,
+		authoritative: true,
+		answer:        []string{"alias.example.com. 3600 IN CNAME www.example.com."},
+	}, {
+		name:          "CNAME outside of the zone",
+		qname:         "external.example.com.",
+		qtype:         dns.TypeA,
+		authoritative: true,
+		answer:        []string{"external.example.com. 3600 IN CNAME www.example.org."},
+	}, {
+		name:          "wildcard",
+		qname:         "a.b.wild.example.com.",
+		qtype:         dns.TypeA,
+		authoritative: true,
+		answer:        []string{"a.b.wild.example.com. 3600 IN A 192.0.2.20"},
+	}, {
+		name:          "wildcard without matching type",
+		qname:         "foo.wild.example.com.",
+		qtype:         dns.TypeTXT,
+		authoritative: true,
+		ns:            []string{soa},
+	}, {
+		name:          "wildcard CNAME",
+		qname:         "foo.cwild.example.com.",
+		qtype:         dns.TypeA,
+		authoritative: true,
+		answer:        []string{"foo.cwild.example.com. 3600 IN CNAME www.example.com.", "www.example.com. 3600 IN A 192.0.2.10"},
+	}, {
+		name:          "empty non-terminal",
+		qname:         "b.c.example.com.",
+		qtype:         dns.TypeA,
+		authoritative: true,
+		ns:            []string{soa},
+	}, {
+		name:          "below an empty non-terminal",
+		qname:         "x.b.c.example.com.",
+		qtype:         dns.TypeA,
+		rcode:         dns.RcodeNameError,
+		authoritative: true,
+		ns:            []string{soa},
+	}, {
+		name:   "referral",
+		qname:  "host.sub.example.com.",
+		qtype:  dns.TypeA,
+		ns:     []string{"sub.example.com. 3600 IN NS ns.sub.example.com."},
+		extra:  []string{"ns.sub.example.com. 3600 IN A 192.0.2.53"},
+		answer: nil,
+	}, {
+		name:   "referral at the delegation",
+		qname:  "sub.example.com.",
+		qtype:  dns.TypeNS,
+		ns:     []string{"sub.example.com. 3600 IN NS ns.sub.example.com."},
+		extra:  []string{"ns.sub.example.com. 3600 IN A 192.0.2.53"},
+		answer: nil,
+	}, {
+		name:          "DS at the delegation",
+		qname:         "sub.example.com.",
+		qtype:         dns.TypeDS,
+		authoritative: true,
+		answer: []string{"sub.example.com. 3600 IN DS 12345 13 2 " +
+			"0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"},
+	}, {
+		name:  "outside of the zone",
+		qname: "example.org.",
+		qtype: dns.TypeA,
+		rcode: dns.RcodeRefused,
+	}}
+
+	zone := runtimex.Try1(ParseZone(strings.NewReader(zoneTestData), "example.com", ""))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &dns.Msg{}
+			query.SetQuestion(tt.qname, tt.qtype)
+			resp := zone.Answer(query)
+			assert.Equal(t, query.Id, resp.Id)
+			assert.Equal(t, tt.rcode, resp.Rcode)
+			assert.Equal(t, tt.authoritative, resp.Authoritative)
+			assert.Equal(t, tt.answer, zoneTestRRs(resp.Answer))
+			assert.Equal(t, tt.ns, zoneTestRRs(resp.Ns))
+			assert.Equal(t, tt.extra, zoneTestRRs(resp.Extra))
+		})
+	}
+
+	t.Run("CNAME loops", func(t *testing.T) {
+		query := &dns.Msg{}
+		query.SetQuestion("loop1.example.com.", dns.TypeA)
+		resp := zone.Answer(query)
+		assert.Equal(t, dns.RcodeSuccess, resp.Rcode)
+		assert.Len(t, resp.Answer, zoneMaxCNAMEChain+1)
+	})
+
+	t.Run("malformed queries", func(t *testing.T) {
+		resp := zone.Answer(&dns.Msg{})
+		assert.Equal(t, dns.RcodeFormatError, resp.Rcode)
+		query := &dns.Msg{}
+		query.SetQuestion("www.example.com.", dns.TypeA)
+		query.Question[0].Qclass = dns.ClassCHAOS
+		resp = zone.Answer(query)
+		assert.Equal(t, dns.RcodeRefused, resp.Rcode)
+	})
+
+	t.Run("returning copies", func(t *testing.T) {
+		query := &dns.Msg{}
+		query.SetQuestion("www.example.com.", dns.TypeA)
+		zone.Answer(query).Answer[0].Header().Ttl = 1
+		assert.Equal(t, uint32(3600), zone.Answer(query).Answer[0].Header().Ttl)
+	})
+}
+
+// zoneConformanceTestData is the example zone of RFC 4592 Sect. 2.2.1,
+// to which we add DNAMEs to test the occlusion rules of RFC 6672.
+const zoneConformanceTestData = `$ORIGIN example.
+$TTL 3600
+@                   IN SOA   ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300
+@                   IN NS    ns.example.com.
+@                   IN NS    ns.example.net.
+*                   IN TXT   "this is a wildcard"
+*                   IN MX    10 host1.example.
+sub.*               IN TXT   "this is not a wildcard"
+host1               IN A     192.0.2.1
+_ssh._tcp.host1     IN SRV   0 0 22 host1.example.
+_ssh._tcp.host2     IN SRV   0 0 22 host2.example.
+subdel              IN NS    ns.example.com.
+subdel              IN NS    ns.example.net.
+occluded.subdel     IN A     192.0.2.2
+redirect            IN DNAME host1.example.
+hidden.redirect     IN A     192.0.2.3
+outside             IN DNAME example.org.
+overflow            IN DNAME aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb.example.org.
+`
+
+func TestZone_Answer_conformance(t *testing.T) {
+	soa := "example. 300 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"
+	referral := []string{"subdel.example. 3600 IN NS ns.example.com.", "subdel.example. 3600 IN NS ns.example.net."}
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		answer []string
+		ns     []string
+	}{{
+		name:   "wildcard matching one label",
+		qname:  "host3.example.",
+		qtype:  dns.TypeMX,
+		answer: []string{"host3.example. 3600 IN MX 10 host1.example."},
+	}, {
+		name:  "wildcard without the queried type",
+		qname: "host3.example.",
+		qtype: dns.TypeA,
+		ns:    []string{soa},
+	}, {
+		name:   "wildcard matching many labels",
+		qname:  "foo.bar.example.",
+		qtype:  dns.TypeTXT,
+		answer: []string{`foo.bar.example. 3600 IN TXT "this is a wildcard"`},
+	}, {
+		name:  "existing names do not match the wildcard",
+		qname: "host1.example.",
+		qtype: dns.TypeMX,
+		ns:    []string{soa},
+	}, {
+		name:  "names below the wildcard are not wildcards",
+		qname: "sub.*.example.",
+		qtype: dns.TypeMX,
+		ns:    []string{soa},
+	}, {
+		name:  "empty non-terminals prevent matching the wildcard",
+		qname: "_telnet._tcp.host1.example.",
+		qtype: dns.TypeSRV,
+		rcode: dns.RcodeNameError,
+		ns:    []string{soa},
+	}, {
+		name:  "wildcards do not match below a delegation",
+		qname: "host.subdel.example.",
+		qtype: dns.TypeA,
+		ns:    referral,
+	}, {
+		name:  "a wildcard is the closest encloser",
+		qname: "ghost.*.example.",
+		qtype: dns.TypeMX,
+		rcode: dns.RcodeNameError,
+		ns:    []string{soa},
+	}, {
+		name:  "delegations occlude the records below them",
+		qname: "occluded.subdel.example.",
+		qtype: dns.TypeA,
+		ns:    referral,
+	}, {
+		name:  "DNAME at the owner",
+		qname: "redirect.example.",
+		qtype: dns.TypeDNAME,
+		answer: []string{
+			"redirect.example. 3600 IN DNAME host1.example.",
+		},
+	}, {
+		name:  "DNAMEs do not apply to their owner",
+		qname: "redirect.example.",
+		qtype: dns.TypeA,
+		ns:    []string{soa},
+	}, {
+		name:  "DNAME synthesizing a CNAME within the zone",
+		qname: "_ssh._tcp.Redirect.example.",
+		qtype: dns.TypeSRV,
+		answer: []string{
+			"redirect.example. 3600 IN DNAME host1.example.",
+			"_ssh._tcp.Redirect.example. 3600 IN CNAME _ssh._tcp.host1.example.",
+			"_ssh._tcp.host1.example. 3600 IN SRV 0 0 22 host1.example.",
+		},
+	}, {
+		name:  "DNAMEs occlude the records below them",
+		qname: "hidden.redirect.example.",
+		qtype: dns.TypeA,
+		rcode: dns.RcodeNameError,
+		answer: []string{
+			"redirect.example. 3600 IN DNAME host1.example.",
+			"hidden.redirect.example. 3600 IN CNAME hidden.host1.example.",
+		},
+		ns: []string{soa},
+	}, {
+		name:  "DNAME synthesizing a CNAME outside of the zone",
+		qname: "www.outside.example.",
+		qtype: dns.TypeA,
+		answer: []string{
+			"outside.example. 3600 IN DNAME example.org.",
+			"www.outside.example. 3600 IN CNAME www.example.org.",
+		},
+	}, {
+		name:  "CNAME queries below a DNAME",
+		qname: "www.outside.example.",
+		qtype: dns.TypeCNAME,
+		answer: []string{
+			"outside.example. 3600 IN DNAME example.org.",
+			"www.outside.example. 3600 IN CNAME www.example.org.",
+		},
+	}, {
+		name:  "DNAME substitution overflowing the name length",
+		qname: strings.Repeat(strings.Repeat("c", 63)+".", 3) + "overflow.example.",
+		qtype: dns.TypeA,
+		rcode: dns.RcodeYXDomain,
+		answer: []string{
+			"overflow.example. 3600 IN DNAME " + strings.Repeat("a", 63) + "." +
+				strings.Repeat("b", 63) + ".example.org.",
+		},
+	}}
+
+	zone, err := ParseZone(strings.NewReader(zoneConformanceTestData), "example", "")
+	assert.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &dns.Msg{}
+			query.SetQuestion(tt.qname, tt.qtype)
+			resp := zone.Answer(query)
+			assert.Equal(t, tt.rcode, resp.Rcode)
+			assert.Equal(t, tt.answer, zoneTestRRs(resp.Answer))
+			assert.Equal(t, tt.ns, zoneTestRRs(resp.Ns))
+		})
+	}
+}
+
+func TestZone_Add(t *testing.T) {
+	tests := []struct {
+		name     string
+		rr       string
+		expected error
+	}{
+		{"duplicate", "www.example.com. 60 IN A 192.0.2.10", nil},
+		{"outside of the zone", "www.example.org. 60 IN A 192.0.2.10", ErrNotInZone},
+		{"SOA not at the apex", "www.example.com. 60 IN SOA ns1 hostmaster 1 1 1 1 1", ErrInvalidZone},
+		{"multiple SOAs", "example.com. 60 IN SOA ns1 hostmaster 2 1 1 1 1", ErrInvalidZone},
+		{"multiple CNAMEs", "alias.example.com. 60 IN CNAME ns1.example.com.", ErrInvalidZone},
+		{"data at a CNAME", "alias.example.com. 60 IN A 192.0.2.10", ErrInvalidZone},
+		{"CNAME at data", "www.example.com. 60 IN CNAME ns1.example.com.", ErrInvalidZone},
+		{"NSEC at a CNAME", "alias.example.com. 60 IN NSEC www.example.com. CNAME NSEC", nil},
+		{"multiple DNAMEs", "dname.example.com. 60 IN DNAME example.org.", ErrInvalidZone},
+		{"DNAME at a CNAME", "alias.example.com. 60 IN DNAME example.org.", ErrInvalidZone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := runtimex.Try1(ParseZone(strings.NewReader(zoneTestData), "example.com", ""))
+			dname, err := dns.NewRR("dname.example.com. 60 IN DNAME example.net.")
+			assert.NoError(t, err)
+			assert.NoError(t, zone.Add(dname))
+			rr, err := dns.NewRR(tt.rr)
+			assert.NoError(t, err)
+			assert.ErrorIs(t, zone.Add(rr), tt.expected)
+		})
+	}
+
+	t.Run("zones without records", func(t *testing.T) {
+		zone := NewZone("Example.COM")
+		assert.Equal(t, "example.com.", zone.Origin())
+		assert.Nil(t, zone.SOA())
+		query := &dns.Msg{}
+		query.SetQuestion("a.b.example.com.", dns.TypeA)
+		assert.Equal(t, dns.RcodeNameError, zone.Answer(query).Rcode)
+	})
+}
+
+func TestParseZone(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"missing SOA", "$ORIGIN example.com.\nwww 60 IN A 192.0.2.10\n"},
+		{"syntax error", "$ORIGIN example.com.\nwww 60 IN A foo\n"},
+		{"record outside of the zone", "www.example.org. 60 IN A 192.0.2.10\n"},
+		{"include", "$INCLUDE /etc/passwd\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseZone(strings.NewReader(tt.data), "example.com", "")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseZoneFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.com.zone")
+	include := filepath.Join(dir, "hosts.zone")
+	assert.NoError(t, os.WriteFile(include, []byte("www 60 IN A 192.0.2.10\n"), 0600))
+	data := "$ORIGIN example.com.\n@ 60 IN SOA ns1 hostmaster 1 1 1 1 1\n$INCLUDE " + include + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0600))
+
+	zone, err := ParseZoneFile(path, "example.com")
+	assert.NoError(t, err)
+	reso := &Resolver{Transport: zone}
+	addrs, err := reso.LookupHost(context.Background(), "www.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.0.2.10"}, addrs)
+
+	_, err = ParseZoneFile(filepath.Join(dir, "nonexistent"), "example.com")
+	assert.Error(t, err)
+}
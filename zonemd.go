@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrZoneDigest indicates that verifying the digest of a [*Zone] failed.
+var ErrZoneDigest = errors.New("zone digest verification failed")
+
+// Digest computes the message digest of the zone (RFC 8976) using the SIMPLE
+// scheme and the given hash algorithm, which is either [dns.ZoneMDHashAlgSHA384]
+// or [dns.ZoneMDHashAlgSHA512], and returns the corresponding ZONEMD record.
+// The digest covers all the records in the zone, including the glue and
+// occluded records, except for the apex ZONEMD RRset and its RRSIGs. To
+// publish the digest, add the record to the zone and, if the zone is signed,
+// sign the zone again using [*Zone.Sign].
+func (z *Zone) Digest(hashAlg uint8) (*dns.ZONEMD, error) {
+	soa := z.SOA()
+	if soa == nil {
+		return nil, fmt.Errorf("%w: missing SOA", ErrInvalidZone)
+	}
+	digest, err := z.digest(hashAlg)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.ZONEMD{
+		Hdr: dns.RR_Header{
+			Name:   z.origin,
+			Rrtype: dns.TypeZONEMD,
+			Class:  dns.ClassINET,
+			Ttl:    soa.Hdr.Ttl,
+		},
+		Serial: soa.Serial,
+		Scheme: dns.ZoneMDSchemeSimple,
+		Hash:   hashAlg,
+		Digest: hex.EncodeToString(digest),
+	}, nil
+}
+
+// VerifyDigest verifies the zone using the ZONEMD records at the apex, as
+// described by RFC 8976 Sect. 4, which is useful to check the integrity of
+// zones loaded from files or transferred using [*Transport.TransferZone],
+// including the root zone. Verification succeeds when the digest of any
+// ZONEMD record with the SOA serial and with a supported scheme and hash
+// algorithm matches. Otherwise, the returned error wraps [ErrZoneDigest].
+// This method does not validate the DNSSEC signatures of the ZONEMD RRset.
+func (z *Zone) VerifyDigest() error {
+	// 1. make sure the zone contains the ZONEMD RRset
+	soa := z.SOA()
+	if soa == nil {
+		return fmt.Errorf("%w: missing SOA", ErrInvalidZone)
+	}
+	z.mu.RLock()
+	zonemds := slices.Clone(z.nodes[z.origin][dns.TypeZONEMD])
+	z.mu.RUnlock()
+	if len(zonemds) <= 0 {
+		return fmt.Errorf("%w: missing ZONEMD", ErrZoneDigest)
+	}
+
+	// 2. try each usable ZONEMD record, rejecting RRsets with
+	// multiple records using the same scheme and hash algorithm
+	seen := make(map[[2]uint8]bool)
+	var tried bool
+	for _, rr := range zonemds {
+		zonemd := rr.(*dns.ZONEMD)
+		key := [2]uint8{zonemd.Scheme, zonemd.Hash}
+		if seen[key] {
+			return fmt.Errorf("%w: duplicate scheme and hash algorithm", ErrZoneDigest)
+		}
+		seen[key] = true
+	}
+	for _, rr := range zonemds {
+		zonemd := rr.(*dns.ZONEMD)
+		if zonemd.Serial != soa.Serial || zonemd.Scheme != dns.ZoneMDSchemeSimple {
+			continue
+		}
+		digest, err := z.digest(zonemd.Hash)
+		if err != nil {
+			continue
+		}
+		tried = true
+		if strings.EqualFold(hex.EncodeToString(digest), zonemd.Digest) {
+			return nil
+		}
+	}
+	if !tried {
+		return fmt.Errorf("%w: no usable ZONEMD", ErrZoneDigest)
+	}
+	return fmt.Errorf("%w: digest mismatch", ErrZoneDigest)
+}
+
+// digest computes the digest of the zone using the SIMPLE scheme.
+func (z *Zone) digest(hashAlg uint8) ([]byte, error) {
+	// 1. select the hash algorithm
+	var hasher hash.Hash
+	switch hashAlg {
+	case dns.ZoneMDHashAlgSHA384:
+		hasher = sha512.New384()
+	case dns.ZoneMDHashAlgSHA512:
+		hasher = sha512.New()
+	default:
+		return nil, fmt.Errorf("%w: unsupported hash algorithm: %d", ErrZoneDigest, hashAlg)
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	// 2. hash the records in canonical order (RFC 4034 Sect. 6.3)
+	names := slices.SortedFunc(maps.Keys(z.nodes), zoneCanonicalCompare)
+	buffer := make([]byte, dns.MaxMsgSize)
+	for _, name := range names {
+		rrsets := z.nodes[name]
+		for _, rrtype := range slices.Sorted(maps.Keys(rrsets)) {
+			if name == z.origin && rrtype == dns.TypeZONEMD {
+				continue
+			}
+			var rdatas [][]byte
+			for _, rr := range rrsets[rrtype] {
+				if sig, ok := rr.(*dns.RRSIG); ok && name == z.origin && sig.TypeCovered == dns.TypeZONEMD {
+					continue
+				}
+				wire, err := zoneDigestWireFormat(rr, buffer)
+				if err != nil {
+					return nil, err
+				}
+				rdatas = append(rdatas, wire)
+			}
+			size := zoneDigestHeaderSize(name)
+			slices.SortFunc(rdatas, func(a, b []byte) int {
+				return bytes.Compare(a[size:], b[size:])
+			})
+			for _, wire := range rdatas {
+				hasher.Write(wire)
+			}
+		}
+	}
+	return hasher.Sum(nil), nil
+}
+
+// zoneDigestHeaderSize returns the size of the wire format of the header
+// of the RRs owned by the given name, which we use to skip to the RDATA.
+func zoneDigestHeaderSize(name string) int {
+	size, _ := dns.PackDomainName(name, make([]byte, 256), 0, nil, false)
+	return size + 10
+}
+
+// zoneDigestWireFormat returns the canonical wire format of the given RR
+// (RFC 4034 Sect. 6.2), using buffer as scratch space.
+func zoneDigestWireFormat(rr dns.RR, buffer []byte) ([]byte, error) {
+	rr = dns.Copy(rr)
+	rr.Header().Name = dns.CanonicalName(rr.Header().Name)
+	switch v := rr.(type) {
+	case *dns.NS:
+		v.Ns = dns.CanonicalName(v.Ns)
+	case *dns.CNAME:
+		v.Target = dns.CanonicalName(v.Target)
+	case *dns.SOA:
+		v.Ns, v.Mbox = dns.CanonicalName(v.Ns), dns.CanonicalName(v.Mbox)
+	case *dns.PTR:
+		v.Ptr = dns.CanonicalName(v.Ptr)
+	case *dns.MX:
+		v.Mx = dns.CanonicalName(v.Mx)
+	case *dns.SRV:
+		v.Target = dns.CanonicalName(v.Target)
+	case *dns.DNAME:
+		v.Target = dns.CanonicalName(v.Target)
+	case *dns.NAPTR:
+		v.Replacement = dns.CanonicalName(v.Replacement)
+	case *dns.KX:
+		v.Exchanger = dns.CanonicalName(v.Exchanger)
+	case *dns.RT:
+		v.Host = dns.CanonicalName(v.Host)
+	case *dns.AFSDB:
+		v.Hostname = dns.CanonicalName(v.Hostname)
+	case *dns.RP:
+		v.Mbox, v.Txt = dns.CanonicalName(v.Mbox), dns.CanonicalName(v.Txt)
+	case *dns.MINFO:
+		v.Rmail, v.Email = dns.CanonicalName(v.Rmail), dns.CanonicalName(v.Email)
+	case *dns.PX:
+		v.Map822, v.Mapx400 = dns.CanonicalName(v.Map822), dns.CanonicalName(v.Mapx400)
+	case *dns.RRSIG:
+		v.SignerName = dns.CanonicalName(v.SignerName)
+	}
+	off, err := dns.PackRR(rr, buffer, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(buffer[:off]), nil
+}
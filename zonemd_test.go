@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// zoneDigestTestData is the simple example zone of RFC 8976 Appendix A.1.
+const zoneDigestTestData = `example.      86400  IN  SOA     ns1 admin 2018031900 (
+                                 1800 900 604800 86400 )
+              86400  IN  NS      ns1
+              86400  IN  NS      ns2
+              86400  IN  ZONEMD  2018031900 1 1 (
+                                 c68090d90a7aed71
+                                 6bc459f9340e3d7c
+                                 1370d4d24b7e2fc3
+                                 a1ddc0b9a87153b9
+                                 a9713b3c9ae5cc27
+                                 777f98b8e730044c )
+ns1           3600   IN  A       203.0.113.63
+NS2           3600   IN  AAAA    2001:db8::63
+`
+
+func TestZone_VerifyDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected error
+	}{{
+		name: "RFC 8976 example",
+		data: zoneDigestTestData,
+	}, {
+		name:     "modified zone",
+		data:     zoneDigestTestData + "www 3600 IN A 203.0.113.80\n",
+		expected: ErrZoneDigest,
+	}, {
+		name:     "missing ZONEMD",
+		data:     "example. 86400 IN SOA ns1 admin 2018031900 1800 900 604800 86400\n",
+		expected: ErrZoneDigest,
+	}, {
+		name:     "mismatching serial",
+		data:     strings.Replace(zoneDigestTestData, "ZONEMD  2018031900", "ZONEMD  2018031901", 1),
+		expected: ErrZoneDigest,
+	}, {
+		name:     "unsupported hash algorithm",
+		data:     strings.Replace(zoneDigestTestData, "ZONEMD  2018031900 1 1", "ZONEMD  2018031900 1 240", 1),
+		expected: ErrZoneDigest,
+	}, {
+		name:     "duplicate scheme and hash algorithm",
+		data:     zoneDigestTestData + "example. 86400 IN ZONEMD 2018031900 1 1 00\n",
+		expected: ErrZoneDigest,
+	}, {
+		name: "unsupported records are ignored",
+		data: zoneDigestTestData + "example. 86400 IN ZONEMD 2018031900 1 240 00\n",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, err := ParseZone(strings.NewReader(tt.data), "example", "")
+			assert.NoError(t, err)
+			assert.ErrorIs(t, zone.VerifyDigest(), tt.expected)
+		})
+	}
+
+	t.Run("missing SOA", func(t *testing.T) {
+		assert.ErrorIs(t, NewZone("example").VerifyDigest(), ErrInvalidZone)
+	})
+}
+
+func TestZone_Digest(t *testing.T) {
+	zone, err := ParseZone(strings.NewReader(zoneDigestTestData), "example", "")
+	assert.NoError(t, err)
+
+	zonemd, err := zone.Digest(dns.ZoneMDHashAlgSHA384)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.\t86400\tIN\tZONEMD\t2018031900 1 1 "+
+		"c68090d90a7aed716bc459f9340e3d7c1370d4d24b7e2fc3a1ddc0b9a87153b9a9713b3c9ae5cc27777f98b8e730044c",
+		zonemd.String())
+
+	zonemd, err = zone.Digest(dns.ZoneMDHashAlgSHA512)
+	assert.NoError(t, err)
+	assert.Len(t, zonemd.Digest, 128)
+	assert.NoError(t, zone.Add(zonemd))
+	assert.NoError(t, zone.VerifyDigest())
+
+	_, err = zone.Digest(240)
+	assert.ErrorIs(t, err, ErrZoneDigest)
+	_, err = NewZone("example").Digest(dns.ZoneMDHashAlgSHA384)
+	assert.ErrorIs(t, err, ErrInvalidZone)
+}
+
+func TestSecondary_VerifyDigest(t *testing.T) {
+	serial := &atomic.Uint32{}
+	serial.Store(1)
+	primary, _ := startStreamServer(t, serveXfrTest(func() []string {
+		return xfrTestRecordsWithSerial(serial.Load())
+	}, nil))
+	sec := NewSecondary("example.com", primary)
+	sec.VerifyDigest = true
+	_, err := sec.RefreshOnce(context.Background())
+	assert.ErrorIs(t, err, ErrZoneDigest)
+	assert.Nil(t, sec.Zone())
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"cmp"
+	"crypto"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ZoneSigningKey is a DNSSEC key used by [*Zone.Sign].
+type ZoneSigningKey struct {
+	// DNSKEY is the public key, which must be owned by the zone apex. We
+	// use keys with the SEP flag set to sign the DNSKEY RRset and the other
+	// keys to sign the other RRsets. When all the keys have (or lack) the
+	// SEP flag, we use all of them to sign all the RRsets.
+	DNSKEY *dns.DNSKEY
+
+	// Signer is the private key matching the DNSKEY.
+	Signer crypto.Signer
+}
+
+// Sign signs the zone using the given keys, which we add to the zone apex, and
+// the given signatures validity period. We build the NSEC chain (RFC 4034) and
+// create the RRSIGs for the authoritative RRsets, excluding the delegations NS
+// RRsets and the glue. Then, [*Zone.Answer] includes the RRSIGs and the NSEC
+// proofs (RFC 4035 Sect. 3.1) when the query sets the DO bit, which allows
+// testing validating clients end-to-end. Since we sign the RRsets when this
+// method is invoked, sign again after adding records using [*Zone.Add].
+//
+// On failure, the zone may contain the given DNSKEYs but is otherwise unchanged.
+func (z *Zone) Sign(keys []*ZoneSigningKey, inception, expiration time.Time) error {
+	// 1. make sure the keys are usable
+	if len(keys) <= 0 {
+		return fmt.Errorf("%w: no signing keys", ErrInvalidZone)
+	}
+	for _, key := range keys {
+		if key.DNSKEY == nil || key.Signer == nil {
+			return fmt.Errorf("%w: incomplete signing key", ErrInvalidZone)
+		}
+		if dns.CanonicalName(key.DNSKEY.Hdr.Name) != z.origin {
+			return fmt.Errorf("%w: DNSKEY not at the apex: %s", ErrInvalidZone, key.DNSKEY.Hdr.Name)
+		}
+	}
+	soa := z.SOA()
+	if soa == nil {
+		return fmt.Errorf("%w: missing SOA", ErrInvalidZone)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	// 2. add the DNSKEYs to the apex
+	for _, key := range keys {
+		rr := dns.Copy(key.DNSKEY).(*dns.DNSKEY)
+		rr.Hdr.Name, rr.Hdr.Rrtype, rr.Hdr.Class = z.origin, dns.TypeDNSKEY, dns.ClassINET
+		if err := z.addLocked(rr); err != nil {
+			return err
+		}
+	}
+
+	// 3. collect the authoritative names in canonical order, thus excluding
+	// the empty non-terminals and the names below the delegations and DNAMEs
+	var names []string
+	for name, rrsets := range z.nodes {
+		if cut, _ := z.cutLocked(name, dns.TypeDS); len(zoneSignedTypes(rrsets, false)) > 0 && cut == "" {
+			names = append(names, name)
+		}
+	}
+	slices.SortFunc(names, zoneCanonicalCompare)
+
+	// 4. build the NSEC chain, which wraps around to the apex, using the
+	// negative caching TTL defined by RFC 9077
+	nsecs := make([]*dns.NSEC, 0, len(names))
+	for idx, name := range names {
+		rrsets := z.nodes[name]
+		bitmap := append(zoneSignedTypes(rrsets, name != z.origin), dns.TypeNSEC, dns.TypeRRSIG)
+		if len(rrsets[dns.TypeNS]) > 0 && name != z.origin {
+			bitmap = append(bitmap, dns.TypeNS)
+		}
+		slices.Sort(bitmap)
+		nsecs = append(nsecs, &dns.NSEC{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeNSEC,
+				Class:  dns.ClassINET,
+				Ttl:    min(soa.Hdr.Ttl, soa.Minttl),
+			},
+			NextDomain: names[(idx+1)%len(names)],
+			TypeBitMap: slices.Compact(bitmap),
+		})
+	}
+
+	// 5. sign the authoritative RRsets, including the NSECs
+	sigs := make(map[string][]dns.RR)
+	for idx, name := range names {
+		rrsets := z.nodes[name]
+		for _, rrtype := range zoneSignedTypes(rrsets, name != z.origin) {
+			signed, err := z.signRRset(keys, rrsets[rrtype], inception, expiration)
+			if err != nil {
+				return err
+			}
+			sigs[name] = append(sigs[name], signed...)
+		}
+		signed, err := z.signRRset(keys, []dns.RR{nsecs[idx]}, inception, expiration)
+		if err != nil {
+			return err
+		}
+		sigs[name] = append(sigs[name], signed...)
+	}
+
+	// 6. replace the previous NSECs and RRSIGs, if any
+	for _, rrsets := range z.nodes {
+		delete(rrsets, dns.TypeNSEC)
+		delete(rrsets, dns.TypeRRSIG)
+	}
+	for idx, name := range names {
+		z.nodes[name][dns.TypeNSEC] = []dns.RR{nsecs[idx]}
+		z.nodes[name][dns.TypeRRSIG] = sigs[name]
+	}
+	z.nsecs = nsecs
+	return nil
+}
+
+// zoneSignedTypes returns, in ascending order, the types of the given RRsets
+// we should sign, excluding NSECs and RRSIGs. At delegations, which are not
+// the apex, we only sign the DS RRset, since the child zone is authoritative.
+func zoneSignedTypes(rrsets map[uint16][]dns.RR, delegation bool) []uint16 {
+	var types []uint16
+	delegation = delegation && len(rrsets[dns.TypeNS]) > 0
+	for _, rrtype := range slices.Sorted(maps.Keys(rrsets)) {
+		switch {
+		case rrtype == dns.TypeNSEC || rrtype == dns.TypeRRSIG || len(rrsets[rrtype]) <= 0:
+		case delegation && rrtype != dns.TypeDS:
+		default:
+			types = append(types, rrtype)
+		}
+	}
+	return types
+}
+
+// signRRset returns the RRSIGs covering the given RRset.
+func (z *Zone) signRRset(keys []*ZoneSigningKey, rrset []dns.RR, inception, expiration time.Time) ([]dns.RR, error) {
+	var sigs []dns.RR
+	for _, key := range zoneSigningKeysFor(keys, rrset[0].Header().Rrtype) {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+			Algorithm:  key.DNSKEY.Algorithm,
+			Expiration: uint32(expiration.Unix()),
+			Inception:  uint32(inception.Unix()),
+			KeyTag:     key.DNSKEY.KeyTag(),
+			SignerName: z.origin,
+		}
+		if err := sig.Sign(key.Signer, rrset); err != nil {
+			return nil, fmt.Errorf("%w: cannot sign %s: %s", ErrInvalidZone, rrset[0].Header().Name, err.Error())
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// zoneSigningKeysFor returns the keys to sign the RRset with the given type.
+func zoneSigningKeysFor(keys []*ZoneSigningKey, rrtype uint16) []*ZoneSigningKey {
+	var ksks, zsks []*ZoneSigningKey
+	for _, key := range keys {
+		if key.DNSKEY.Flags&dns.SEP != 0 {
+			ksks = append(ksks, key)
+			continue
+		}
+		zsks = append(zsks, key)
+	}
+	switch {
+	case rrtype == dns.TypeDNSKEY && len(ksks) > 0:
+		return ksks
+	case rrtype != dns.TypeDNSKEY && len(zsks) > 0:
+		return zsks
+	default:
+		return keys
+	}
+}
+
+// zoneCanonicalCompare compares two canonical names using the canonical
+// order defined by RFC 4034 Sect. 6.1, comparing the labels from right to
+// left. For simplicity, we compare labels in presentation format, hence
+// the order could be wrong for labels containing escaped characters.
+func zoneCanonicalCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(a), dns.SplitDomainName(b)
+	for ia, ib := len(la)-1, len(lb)-1; ia >= 0 && ib >= 0; ia, ib = ia-1, ib-1 {
+		if diff := strings.Compare(la[ia], lb[ib]); diff != 0 {
+			return diff
+		}
+	}
+	return cmp.Compare(len(la), len(lb))
+}
+
+// coveringNSECLocked returns the NSEC owned by the given canonical name or
+// the NSEC covering it, i.e., owned by the closest name preceding it in
+// canonical order, or nil if the zone is not signed.
+func (z *Zone) coveringNSECLocked(name string) *dns.NSEC {
+	idx, found := slices.BinarySearchFunc(z.nsecs, name, func(nsec *dns.NSEC, name string) int {
+		return zoneCanonicalCompare(nsec.Hdr.Name, name)
+	})
+	switch {
+	case found:
+		return z.nsecs[idx]
+	case idx > 0:
+		return z.nsecs[idx-1]
+	default:
+		return nil
+	}
+}
+
+// appendNSECLocked appends to rrs a copy of the given NSEC, if not nil
+// and not already in rrs, along with the covering RRSIGs.
+func (z *Zone) appendNSECLocked(rrs []dns.RR, nsec *dns.NSEC) []dns.RR {
+	if nsec == nil || slices.ContainsFunc(rrs, func(rr dns.RR) bool { return dns.IsDuplicate(rr, nsec) }) {
+		return rrs
+	}
+	rrs = append(rrs, dns.Copy(nsec))
+	return appendZoneRRSIGs(rrs, z.nodes[nsec.Hdr.Name], dns.TypeNSEC, "")
+}
+
+// appendZoneRRSIGs appends to rrs copies of the RRSIGs in the given RRsets
+// covering the given type, setting their owner name, when not empty.
+func appendZoneRRSIGs(rrs []dns.RR, rrsets map[uint16][]dns.RR, rrtype uint16, owner string) []dns.RR {
+	for _, rr := range rrsets[dns.TypeRRSIG] {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == rrtype {
+			sig = dns.Copy(sig).(*dns.RRSIG)
+			if owner != "" {
+				sig.Hdr.Name = owner
+			}
+			rrs = append(rrs, sig)
+		}
+	}
+	return rrs
+}
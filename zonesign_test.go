@@ -0,0 +1,228 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package dnscore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rbmk-project/common/runtimex"
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyZoneSigningTestRRs verifies the RRSIGs of the given RRs and
+// returns the types of the RRsets, in order, including the RRSIGs.
+func verifyZoneSigningTestRRs(t *testing.T, keys []*ZoneSigningKey, rrs []dns.RR) []string {
+	var types []string
+	for _, rr := range rrs {
+		types = append(types, dns.TypeToString[rr.Header().Rrtype])
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		var rrset []dns.RR
+		for _, other := range rrs {
+			hdr := other.Header()
+			if hdr.Rrtype == sig.TypeCovered && strings.EqualFold(hdr.Name, sig.Hdr.Name) {
+				rrset = append(rrset, other)
+			}
+		}
+		var verified bool
+		for _, key := range keys {
+			if key.DNSKEY.KeyTag() == sig.KeyTag {
+				verified = sig.Verify(key.DNSKEY, rrset) == nil && sig.ValidityPeriod(time.Now())
+			}
+		}
+		assert.True(t, verified, sig.String())
+	}
+	return types
+}
+
+// zoneSigningTestNSECs returns the NSECs in the given RRs.
+func zoneSigningTestNSECs(rrs []dns.RR) []string {
+	var nsecs []string
+	for _, rr := range rrs {
+		if nsec, ok := rr.(*dns.NSEC); ok {
+			nsecs = append(nsecs, nsec.Hdr.Name+" "+nsec.NextDomain)
+		}
+	}
+	return nsecs
+}
+
+func TestZone_Sign(t *testing.T) {
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		rcode  int
+		answer []string
+		ns     []string
+		nsecs  []string
+	}{{
+		name:   "answer",
+		qname:  "www.example.com.",
+		qtype:  dns.TypeA,
+		answer: []string{"A", "RRSIG"},
+	}, {
+		name:   "DNSKEY",
+		qname:  "example.com.",
+		qtype:  dns.TypeDNSKEY,
+		answer: []string{"DNSKEY", "DNSKEY", "RRSIG"},
+	}, {
+		name:   "CNAME",
+		qname:  "alias.example.com.",
+		qtype:  dns.TypeA,
+		answer: []string{"CNAME", "RRSIG", "A", "RRSIG"},
+	}, {
+		name:  "no data",
+		qname: "www.example.com.",
+		qtype: dns.TypeMX,
+		ns:    []string{"SOA", "RRSIG", "NSEC", "RRSIG"},
+		nsecs: []string{"www.example.com. example.com."},
+	}, {
+		name:  "no such name",
+		qname: "missing.example.com.",
+		qtype: dns.TypeA,
+		rcode: dns.RcodeNameError,
+		ns:    []string{"SOA", "RRSIG", "NSEC", "RRSIG", "NSEC", "RRSIG"},
+		nsecs: []string{"loop2.example.com. ns1.example.com.", "example.com. alias.example.com."},
+	}, {
+		name:  "empty non-terminal",
+		qname: "b.c.example.com.",
+		qtype: dns.TypeA,
+		ns:    []string{"SOA", "RRSIG", "NSEC", "RRSIG"},
+		nsecs: []string{"alias.example.com. a.b.c.example.com."},
+	}, {
+		name:   "wildcard",
+		qname:  "foo.wild.example.com.",
+		qtype:  dns.TypeA,
+		answer: []string{"A", "RRSIG"},
+		ns:     []string{"NSEC", "RRSIG"},
+		nsecs:  []string{"*.wild.example.com. www.example.com."},
+	}, {
+		name:  "wildcard without matching type",
+		qname: "foo.wild.example.com.",
+		qtype: dns.TypeTXT,
+		ns:    []string{"SOA", "RRSIG", "NSEC", "RRSIG"},
+		nsecs: []string{"*.wild.example.com. www.example.com."},
+	}, {
+		name:  "referral to a signed zone",
+		qname: "host.sub.example.com.",
+		qtype: dns.TypeA,
+		ns:    []string{"NS", "DS", "RRSIG"},
+	}, {
+		name:  "referral to an unsigned zone",
+		qname: "insecure.example.com.",
+		qtype: dns.TypeA,
+		ns:    []string{"NS", "NSEC", "RRSIG"},
+		nsecs: []string{"insecure.example.com. loop1.example.com."},
+	}, {
+		name:   "DS",
+		qname:  "sub.example.com.",
+		qtype:  dns.TypeDS,
+		answer: []string{"DS", "RRSIG"},
+	}}
+
+	// sign a zone containing an insecure delegation
+	zone := runtimex.Try1(ParseZone(strings.NewReader(zoneTestData), "example.com", ""))
+	rr, _ := dns.NewRR("insecure.example.com. 3600 IN NS ns.example.net.")
+	assert.NoError(t, zone.Add(rr))
+	keys := []*ZoneSigningKey{
+		runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE|dns.SEP)),
+		runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE)),
+	}
+	now := time.Now()
+	assert.NoError(t, zone.Sign(keys, now.Add(-time.Hour), now.Add(time.Hour)))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &dns.Msg{}
+			query.SetQuestion(tt.qname, tt.qtype)
+			query.SetEdns0(1232, true)
+			resp := zone.Answer(query)
+			assert.Equal(t, tt.rcode, resp.Rcode)
+			assert.Equal(t, tt.answer, verifyZoneSigningTestRRs(t, keys, resp.Answer))
+			assert.Equal(t, tt.ns, verifyZoneSigningTestRRs(t, keys, resp.Ns))
+			assert.Equal(t, tt.nsecs, zoneSigningTestNSECs(resp.Ns))
+			assert.True(t, resp.IsEdns0().Do())
+		})
+	}
+
+	t.Run("NSEC type bitmaps", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			expected []uint16
+		}{
+			{"example.com.", []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}},
+			{"insecure.example.com.", []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}},
+			{"alias.example.com.", []uint16{dns.TypeCNAME, dns.TypeRRSIG, dns.TypeNSEC}},
+			{"ns.sub.example.com.", nil},
+			{"b.c.example.com.", nil},
+		}
+		for _, tt := range tests {
+			query := &dns.Msg{}
+			query.SetQuestion(tt.name, dns.TypeNSEC)
+			query.SetEdns0(1232, true)
+			resp := zone.Answer(query)
+			var bitmap []uint16
+			for _, rr := range append(resp.Answer, resp.Ns...) {
+				if nsec, ok := rr.(*dns.NSEC); ok && nsec.Hdr.Name == tt.name {
+					bitmap = nsec.TypeBitMap
+				}
+			}
+			assert.Equal(t, tt.expected, bitmap, tt.name)
+		}
+	})
+
+	t.Run("queries without the DO bit", func(t *testing.T) {
+		query := &dns.Msg{}
+		query.SetQuestion("missing.example.com.", dns.TypeA)
+		query.SetEdns0(1232, false)
+		resp := zone.Answer(query)
+		assert.Equal(t, []string{"SOA"}, verifyZoneSigningTestRRs(t, keys, resp.Ns))
+		assert.False(t, resp.IsEdns0().Do())
+	})
+
+	t.Run("signing again", func(t *testing.T) {
+		now := time.Now()
+		assert.NoError(t, zone.Sign(keys, now.Add(-time.Hour), now.Add(time.Hour)))
+		query := &dns.Msg{}
+		query.SetQuestion("example.com.", dns.TypeDNSKEY)
+		query.SetEdns0(1232, true)
+		resp := zone.Answer(query)
+		assert.Equal(t, []string{"DNSKEY", "DNSKEY", "RRSIG"}, verifyZoneSigningTestRRs(t, keys, resp.Answer))
+	})
+}
+
+func TestZone_SignErrors(t *testing.T) {
+	valid := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE))
+	elsewhere := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE))
+	elsewhere.DNSKEY.Hdr.Name = "example.org."
+	unsupported := runtimex.Try1(GenerateZoneSigningKey("example.com", dns.ECDSAP256SHA256, dns.ZONE))
+	unsupported.DNSKEY.Algorithm = dns.ED25519
+	parseZone := func() *Zone {
+		return runtimex.Try1(ParseZone(strings.NewReader(zoneTestData), "example.com", ""))
+	}
+
+	tests := []struct {
+		name string
+		zone *Zone
+		keys []*ZoneSigningKey
+	}{
+		{"no keys", parseZone(), nil},
+		{"incomplete key", parseZone(), []*ZoneSigningKey{{DNSKEY: valid.DNSKEY}}},
+		{"key not at the apex", parseZone(), []*ZoneSigningKey{elsewhere}},
+		{"missing SOA", NewZone("example.com"), []*ZoneSigningKey{valid}},
+		{"mismatching algorithm", parseZone(), []*ZoneSigningKey{unsupported}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			err := tt.zone.Sign(tt.keys, now, now.Add(time.Hour))
+			assert.ErrorIs(t, err, ErrInvalidZone)
+		})
+	}
+}